@@ -0,0 +1,65 @@
+package app
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/v43/github"
+)
+
+// eventSource returns the installation, owner and full repository name that
+// a webhook event comes from. The repository is "" for events that aren't
+// about one, like installation events.
+func eventSource(event interface{}) (int64, string, string) {
+	var installationID int64
+	if e, ok := event.(interface{ GetInstallation() *github.Installation }); ok {
+		installationID = e.GetInstallation().GetID()
+	}
+	switch e := event.(type) {
+	case *github.PushEvent:
+		return installationID, e.GetRepo().GetOwner().GetLogin(), e.GetRepo().GetFullName()
+	case interface{ GetRepo() *github.Repository }:
+		if repo := e.GetRepo(); repo != nil {
+			return installationID, repo.GetOwner().GetLogin(), repo.GetFullName()
+		}
+	}
+	if e, ok := event.(interface{ GetInstallation() *github.Installation }); ok {
+		return installationID, e.GetInstallation().GetAccount().GetLogin(), ""
+	}
+	return installationID, "", ""
+}
+
+// matchesSource reports whether any of entries names the installation, owner
+// or repository. Owners and repositories are compared case-insensitively, like
+// GitHub does.
+func matchesSource(entries []string, installationID int64, owner string, fullRepoName string) bool {
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if id, err := strconv.ParseInt(entry, 10, 64); err == nil {
+			if id == installationID {
+				return true
+			}
+			continue
+		}
+		if strings.Contains(entry, "/") {
+			if fullRepoName != "" && strings.EqualFold(entry, fullRepoName) {
+				return true
+			}
+			continue
+		}
+		if owner != "" && strings.EqualFold(entry, owner) {
+			return true
+		}
+	}
+	return false
+}
+
+// sourceAllowed reports whether the bot acts on events from the installation,
+// owner and repository, according to AllowList and DenyList.
+func sourceAllowed(installationID int64, owner string, fullRepoName string) bool {
+	settings := CurrentSettings()
+	if matchesSource(settings.DenyList, installationID, owner, fullRepoName) {
+		return false
+	}
+	return len(settings.AllowList) == 0 || matchesSource(settings.AllowList, installationID, owner, fullRepoName)
+}
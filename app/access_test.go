@@ -0,0 +1,95 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-github/v43/github"
+)
+
+// setAccessLists sets AllowList and DenyList for the duration of the test.
+func setAccessLists(t *testing.T, allow []string, deny []string) {
+	setTestSettings(t, func(s *Settings) { s.AllowList, s.DenyList = allow, deny })
+}
+
+func TestEventSource(t *testing.T) {
+	repo := &github.Repository{FullName: github.String("o/r"), Owner: &github.User{Login: github.String("o")}}
+	installation := &github.Installation{ID: github.Int64(2), Account: &github.User{Login: github.String("acct")}}
+	for _, tc := range []struct {
+		event interface{}
+		id    int64
+		owner string
+		repo  string
+	}{
+		{&github.CheckSuiteEvent{Repo: repo, Installation: installation}, 2, "o", "o/r"},
+		{&github.PushEvent{Repo: &github.PushEventRepository{FullName: github.String("o/r"), Owner: &github.User{Login: github.String("o")}}, Installation: installation}, 2, "o", "o/r"},
+		{&github.InstallationEvent{Installation: installation}, 2, "acct", ""},
+		{&github.PingEvent{}, 0, "", ""},
+	} {
+		id, owner, repo := eventSource(tc.event)
+		if id != tc.id || owner != tc.owner || repo != tc.repo {
+			t.Errorf("eventSource(%T) = %d, %q, %q; want %d, %q, %q", tc.event, id, owner, repo, tc.id, tc.owner, tc.repo)
+		}
+	}
+}
+
+func TestSourceAllowed(t *testing.T) {
+	for _, tc := range []struct {
+		allow, deny []string
+		want        bool
+	}{
+		{nil, nil, true},
+		{[]string{"2"}, nil, true},
+		{[]string{"3"}, nil, false},
+		{[]string{"O"}, nil, true},
+		{[]string{" o/R "}, nil, true},
+		{[]string{"o/other"}, nil, false},
+		{[]string{"other", "o/r"}, nil, true},
+		{nil, []string{"o"}, false},
+		{nil, []string{"o/other"}, true},
+		{[]string{"o"}, []string{"o/r"}, false},
+		{[]string{"o/r"}, []string{"2"}, false},
+	} {
+		setAccessLists(t, tc.allow, tc.deny)
+		if got := sourceAllowed(2, "o", "o/r"); got != tc.want {
+			t.Errorf("sourceAllowed with allow %q and deny %q = %t, want %t", tc.allow, tc.deny, got, tc.want)
+		}
+	}
+}
+
+func TestSourceAllowedWithoutRepository(t *testing.T) {
+	setAccessLists(t, []string{"o/r"}, nil)
+	if sourceAllowed(2, "o", "") {
+		t.Errorf("repository entry allows events of its whole owner")
+	}
+	setAccessLists(t, nil, []string{"o/r"})
+	if !sourceAllowed(2, "o", "") {
+		t.Errorf("repository entry denies events of its whole owner")
+	}
+}
+
+func TestHandleWebhookIgnoresEventsFromSourcesThatAreNotAllowed(t *testing.T) {
+	setAccessLists(t, []string{"o"}, []string{"o/denied"})
+	app := &GithubApp{
+		webhookSecrets: []string{testWebhookSecret},
+		deliveries:     newExpiringSet(deliveryTTL, maxDeliveries),
+	}
+	app.events = newEventQueue(app, 0, 3)
+	denied := checkSuitePayload("def")
+	denied["repository"] = map[string]interface{}{"name": "denied", "full_name": "o/denied", "owner": map[string]interface{}{"login": "o"}}
+	other := checkSuitePayload("ghi")
+	other["repository"] = map[string]interface{}{"name": "r", "full_name": "other/r", "owner": map[string]interface{}{"login": "other"}}
+
+	app.HandleWebhook(httptest.NewRecorder(), webhookRequest(t, "check_suite", "delivery-1", checkSuitePayload("abc"), testWebhookSecret))
+	for id, payload := range map[string]interface{}{"delivery-2": denied, "delivery-3": other} {
+		w := httptest.NewRecorder()
+		app.HandleWebhook(w, webhookRequest(t, "check_suite", id, payload, testWebhookSecret))
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: got status %d, want %d", id, w.Code, http.StatusOK)
+		}
+	}
+	if events := app.events.List(); len(events) != 1 || events[0].DeliveryID != "delivery-1" {
+		t.Errorf("got queued events %+v, want only the allowed delivery", events)
+	}
+}
@@ -0,0 +1,232 @@
+package app
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v43/github"
+	"gopkg.in/yaml.v3"
+)
+
+// maxFailures is the number of recent failures kept for the admin API.
+const maxFailures = 100
+
+// Failure is an error handling an event or running a check.
+type Failure struct {
+	Time       time.Time `json:"time"`
+	DeliveryID string    `json:"delivery_id,omitempty"`
+	Event      string    `json:"event,omitempty"`
+	Repo       string    `json:"repo,omitempty"`
+	SHA        string    `json:"sha,omitempty"`
+	// Check is the comma-separated checks of a failed job.
+	Check string `json:"check,omitempty"`
+	Error string `json:"error"`
+}
+
+// failureLog keeps the most recent failures in memory.
+type failureLog struct {
+	mu       sync.Mutex
+	failures []*Failure
+}
+
+func (l *failureLog) Add(f *Failure) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.failures = append(l.failures, f)
+	if len(l.failures) > maxFailures {
+		l.failures = l.failures[len(l.failures)-maxFailures:]
+	}
+}
+
+// List returns the recorded failures, most recent first.
+func (l *failureLog) List() []*Failure {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	failures := make([]*Failure, 0, len(l.failures))
+	for i := len(l.failures) - 1; i >= 0; i-- {
+		failures = append(failures, l.failures[i])
+	}
+	return failures
+}
+
+// recordFailure records an error handling a webhook event.
+func (app *GithubApp) recordFailure(ctx context.Context, event interface{}, err error) {
+	f := &Failure{
+		Time:       time.Now(),
+		DeliveryID: deliveryID(ctx),
+		Event:      eventType(event),
+		Error:      err.Error(),
+	}
+	if e, ok := event.(interface{ GetRepo() *github.Repository }); ok {
+		f.Repo = e.GetRepo().GetFullName()
+	}
+	app.failures.Add(f)
+}
+
+// recordJobFailure records an error running a job in the background. Jobs
+// run inline are recorded with the event that started them.
+func (app *GithubApp) recordJobFailure(job *Job, err error) {
+	checks := make([]string, 0, len(job.Checks))
+	for _, check := range job.Checks {
+		checks = append(checks, check.Name)
+	}
+	app.failures.Add(&Failure{
+		Time:       time.Now(),
+		DeliveryID: job.DeliveryID,
+		Repo:       job.FullRepoName,
+		SHA:        job.HeadSHA,
+		Check:      strings.Join(checks, ","),
+		Error:      err.Error(),
+	})
+}
+
+// AdminHandler serves the admin API under /admin/, authenticated with token
+// as a bearer token:
+//
+//	GET  /admin/queue                     webhook events waiting for a worker
+//	GET  /admin/running                   checks running in this process
+//	GET  /admin/failures                  recent failures, most recent first
+//	GET  /admin/config?repo=o/r[&ref=x]   the repository's parsed .reviewbot.yaml
+//	POST /admin/cancel?repo=o/r&sha=x&check=c
+//	POST /admin/rerun?repo=o/r&sha=x&check=c
+//	POST /admin/replay                    handle the captured event in the body
+func (app *GithubApp) AdminHandler(token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		auth := []byte(req.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(auth, []byte("Bearer "+token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		ctx := req.Context()
+		q := req.URL.Query()
+		endpoint := strings.Trim(strings.TrimPrefix(req.URL.Path, "/admin"), "/")
+		method := http.MethodGet
+		if endpoint == "cancel" || endpoint == "rerun" || endpoint == "replay" {
+			method = http.MethodPost
+		}
+		if req.Method != method {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		switch endpoint {
+		case "queue":
+			events := []*QueuedEvent{}
+			if app.events != nil {
+				events = app.events.List()
+			}
+			writeJSON(w, events)
+		case "running":
+			writeJSON(w, app.running.List())
+		case "failures":
+			writeJSON(w, app.failures.List())
+		case "config":
+			owner, repoName, ok := strings.Cut(q.Get("repo"), "/")
+			if !ok {
+				http.Error(w, "repo must be owner/name", http.StatusBadRequest)
+				return
+			}
+			installationID, err := app.repoInstallationID(ctx, owner, repoName)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			config, err := app.fetchRepoConfig(ctx, installationID, owner, repoName, q.Get("ref"))
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/yaml")
+			if err := yaml.NewEncoder(w).Encode(config); err != nil {
+				logFrom(ctx).Warnw("failed to write config", "error", err)
+			}
+		case "cancel", "rerun":
+			if err := app.adminCheckAction(ctx, endpoint, q.Get("repo"), q.Get("sha"), q.Get("check")); err != nil {
+				writeError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusAccepted)
+		case "replay":
+			c := &CapturedEvent{}
+			if err := json.NewDecoder(req.Body).Decode(c); err != nil {
+				http.Error(w, fmt.Sprintf("invalid captured event: %s", err), http.StatusBadRequest)
+				return
+			}
+			if err := app.ReplayEvent(ctx, c); err != nil {
+				writeError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		default:
+			http.NotFound(w, req)
+		}
+	}
+}
+
+// adminCheckAction cancels or reruns checkName on headSHA of fullRepoName.
+func (app *GithubApp) adminCheckAction(ctx context.Context, action string, fullRepoName string, headSHA string, checkName string) error {
+	owner, repoName, ok := strings.Cut(fullRepoName, "/")
+	if !ok || headSHA == "" || checkName == "" {
+		return &httpError{http.StatusBadRequest, "repo, sha and check are required"}
+	}
+	if _, err := GetChecker(checkName); err != nil {
+		return &httpError{http.StatusBadRequest, err.Error()}
+	}
+	installationID, err := app.repoInstallationID(ctx, owner, repoName)
+	if err != nil {
+		return err
+	}
+	ctx = withLogFields(ctx, "repo", fullRepoName, "sha", headSHA, "check", checkName)
+	if action == "cancel" {
+		if !app.CancelCheck(installationID, headSHA, checkName) {
+			return &httpError{http.StatusNotFound, "check isn't running in this process"}
+		}
+		logFrom(ctx).Infow("check cancelled through the admin API")
+		return nil
+	}
+	repo, res, err := app.GetClient(installationID).Repositories.Get(ctx, owner, repoName)
+	if err := extractError(ctx, res, err); err != nil {
+		return err
+	}
+	logFrom(ctx).Infow("check rerun through the admin API")
+	return app.createCheckRuns(ctx, installationID, repo, headSHA, []string{checkName})
+}
+
+// repoInstallationID returns the ID of the app's installation on a
+// repository.
+func (app *GithubApp) repoInstallationID(ctx context.Context, owner string, repoName string) (int64, error) {
+	installation, res, err := app.GetAppClient().Apps.FindRepositoryInstallation(ctx, owner, repoName)
+	if err := extractError(ctx, res, err); err != nil {
+		return 0, err
+	}
+	return installation.GetID(), nil
+}
+
+// httpError is an error with the status code it is served with.
+type httpError struct {
+	statusCode int
+	message    string
+}
+
+func (e *httpError) Error() string {
+	return e.message
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		Logger.Warnw("failed to write response", "error", err)
+	}
+}
@@ -0,0 +1,203 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v43/github"
+)
+
+const testAdminToken = "admin-token"
+
+// adminRequest serves an admin API request to app and returns the response.
+func adminRequest(app *GithubApp, method string, target string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	w := httptest.NewRecorder()
+	app.AdminHandler(testAdminToken)(w, req)
+	return w
+}
+
+// serveInstallation lets f report the app's installation on o/r.
+func serveInstallation(f *fakeGitHub) {
+	f.handle("GET /repos/o/r/installation", func(w http.ResponseWriter, req *http.Request) {
+		writeTestJSON(w, http.StatusOK, map[string]interface{}{"id": testInstallationID})
+	})
+}
+
+func TestFailureLog(t *testing.T) {
+	var nilLog *failureLog
+	nilLog.Add(&Failure{Error: "ignored"})
+	if failures := nilLog.List(); len(failures) != 0 {
+		t.Errorf("got failures %v from a nil log", failures)
+	}
+
+	l := &failureLog{}
+	for i := 0; i < maxFailures+5; i++ {
+		l.Add(&Failure{Error: fmt.Sprint(i)})
+	}
+	failures := l.List()
+	if len(failures) != maxFailures {
+		t.Fatalf("got %d failures, want the most recent %d", len(failures), maxFailures)
+	}
+	if failures[0].Error != fmt.Sprint(maxFailures+4) || failures[maxFailures-1].Error != "5" {
+		t.Errorf("got failures %s to %s, want the most recent first", failures[0].Error, failures[maxFailures-1].Error)
+	}
+}
+
+func TestAdminHandlerRequiresToken(t *testing.T) {
+	app := &GithubApp{running: newRunningChecks()}
+	for _, auth := range []string{"", "Bearer wrong", testAdminToken} {
+		req := httptest.NewRequest(http.MethodGet, "/admin/running", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		app.AdminHandler(testAdminToken)(w, req)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("got status %d with authorization %q, want %d", w.Code, auth, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestAdminHandlerRejectsUnknownRequests(t *testing.T) {
+	app := &GithubApp{running: newRunningChecks()}
+	for _, tc := range []struct {
+		method string
+		target string
+		want   int
+	}{
+		{http.MethodGet, "/admin/cancel?repo=o/r&sha=abc&check=gofmt", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/admin/running", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/admin/unknown", http.StatusNotFound},
+		{http.MethodGet, "/admin/config?repo=r", http.StatusBadRequest},
+		{http.MethodPost, "/admin/rerun?repo=o/r&check=gofmt", http.StatusBadRequest},
+		{http.MethodPost, "/admin/rerun?repo=o/r&sha=abc&check=no-such-check", http.StatusBadRequest},
+	} {
+		if w := adminRequest(app, tc.method, tc.target); w.Code != tc.want {
+			t.Errorf("%s %s: got status %d, want %d", tc.method, tc.target, w.Code, tc.want)
+		}
+	}
+}
+
+func TestAdminHandlerListsQueuedEvents(t *testing.T) {
+	app := &GithubApp{
+		webhookSecrets: []string{testWebhookSecret},
+		deliveries:     newExpiringSet(deliveryTTL, maxDeliveries),
+	}
+	app.events = newEventQueue(app, 0, 2)
+	app.HandleWebhook(httptest.NewRecorder(), webhookRequest(t, "check_suite", "delivery-1", checkSuitePayload("abc"), testWebhookSecret))
+	app.HandleWebhook(httptest.NewRecorder(), webhookRequest(t, "check_suite", "delivery-2", checkSuitePayload("def"), testWebhookSecret))
+
+	w := adminRequest(app, http.MethodGet, "/admin/queue")
+	var events []*QueuedEvent
+	if err := json.NewDecoder(w.Body).Decode(&events); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].DeliveryID != "delivery-1" || events[1].DeliveryID != "delivery-2" || events[0].Type != "CheckSuiteEvent" {
+		t.Errorf("got queued events %+v, want both deliveries, oldest first", events)
+	}
+}
+
+func TestAdminHandlerListsRunningChecks(t *testing.T) {
+	app := &GithubApp{running: newRunningChecks()}
+	now := time.Now()
+	app.running.Add("later", &RunningCheck{FullRepoName: "o/r", CheckName: "gofmt", StartedAt: now})
+	app.running.Add("earlier", &RunningCheck{FullRepoName: "o/r", CheckName: "bazel", StartedAt: now.Add(-time.Minute)})
+
+	w := adminRequest(app, http.MethodGet, "/admin/running")
+	var checks []map[string]interface{}
+	if err := json.NewDecoder(w.Body).Decode(&checks); err != nil {
+		t.Fatal(err)
+	}
+	if len(checks) != 2 || checks[0]["check"] != "bazel" || checks[1]["check"] != "gofmt" || checks[0]["repo"] != "o/r" {
+		t.Errorf("got running checks %v, want the longest running first", checks)
+	}
+}
+
+func TestAdminHandlerListsFailures(t *testing.T) {
+	app := &GithubApp{failures: &failureLog{}}
+	ctx := withDeliveryID(context.Background(), "delivery-1")
+	app.recordFailure(ctx, &github.PushEvent{Repo: &github.PushEventRepository{FullName: github.String("o/r")}}, errors.New("clone failed"))
+	app.recordJobFailure(&Job{DeliveryID: "delivery-2", FullRepoName: "o/r", HeadSHA: "abc", Checks: []*JobCheck{{Name: "gofmt"}, {Name: "bazel"}}}, errors.New("timed out"))
+
+	w := adminRequest(app, http.MethodGet, "/admin/failures")
+	var failures []*Failure
+	if err := json.NewDecoder(w.Body).Decode(&failures); err != nil {
+		t.Fatal(err)
+	}
+	if len(failures) != 2 {
+		t.Fatalf("got failures %+v, want 2", failures)
+	}
+	if f := failures[0]; f.DeliveryID != "delivery-2" || f.SHA != "abc" || f.Check != "gofmt,bazel" || f.Error != "timed out" {
+		t.Errorf("got job failure %+v", f)
+	}
+	if f := failures[1]; f.DeliveryID != "delivery-1" || f.Event != "PushEvent" || f.Error != "clone failed" {
+		t.Errorf("got event failure %+v", f)
+	}
+}
+
+func TestAdminHandlerServesRepoConfig(t *testing.T) {
+	f := newFakeGitHub(t)
+	serveInstallation(f)
+	serveRepoConfig(f, "checks:\n  gofmt:\n    enabled: false\n")
+	app := newTestApp(t, f)
+
+	w := adminRequest(app, http.MethodGet, "/admin/config?repo=o/r")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body)
+	}
+	if !strings.Contains(w.Body.String(), "gofmt:") || !strings.Contains(w.Body.String(), "enabled: false") {
+		t.Errorf("got config %q, want the parsed .reviewbot.yaml", w.Body)
+	}
+
+	if w := adminRequest(app, http.MethodGet, "/admin/config?repo=o/missing"); w.Code != http.StatusNotFound {
+		t.Errorf("got status %d for a repo without the app, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestAdminHandlerCancelsCheck(t *testing.T) {
+	registerTestChecker(t, &funcChecker{name: "test-lint"})
+	f := newFakeGitHub(t)
+	serveInstallation(f)
+	app := newTestApp(t, f)
+	cancelled := false
+	app.running.Add(inFlightKey(testInstallationID, "abc", "test-lint"), &RunningCheck{cancel: func() { cancelled = true }})
+
+	if w := adminRequest(app, http.MethodPost, "/admin/cancel?repo=o/r&sha=abc&check=test-lint"); w.Code != http.StatusAccepted {
+		t.Fatalf("got status %d: %s", w.Code, w.Body)
+	}
+	if !cancelled {
+		t.Errorf("the running check wasn't cancelled")
+	}
+	if w := adminRequest(app, http.MethodPost, "/admin/cancel?repo=o/r&sha=def&check=test-lint"); w.Code != http.StatusNotFound {
+		t.Errorf("got status %d for a check that isn't running, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestAdminHandlerRerunsCheck(t *testing.T) {
+	registerTestChecker(t, &funcChecker{name: "test-lint"})
+	f := newFakeGitHub(t)
+	serveInstallation(f)
+	f.handle("GET /repos/o/r", func(w http.ResponseWriter, req *http.Request) {
+		writeTestJSON(w, http.StatusOK, testRepo())
+	})
+	created := serveCheckRunCreation(t, f, "abc")
+	app := newTestApp(t, f)
+	d := &recordingDispatcher{}
+	app.SetDispatcher(d)
+
+	if w := adminRequest(app, http.MethodPost, "/admin/rerun?repo=o/r&sha=abc&check=test-lint"); w.Code != http.StatusAccepted {
+		t.Fatalf("got status %d: %s", w.Code, w.Body)
+	}
+	if runs := created(); len(runs) == 0 || runs[0].Name != "test-lint" || runs[0].HeadSHA != "abc" {
+		t.Errorf("created %+v, want a new test-lint run on abc", runs)
+	}
+}
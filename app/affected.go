@@ -0,0 +1,139 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// defaultFullBuildPaths are the files whose changes can affect every target,
+// so changing them falls back to running the check on all configured targets.
+var defaultFullBuildPaths = []string{
+	"WORKSPACE",
+	"WORKSPACE.bazel",
+	"MODULE.bazel",
+	"MODULE.bazel.lock",
+	".bazelrc",
+	".bazelversion",
+}
+
+// changedFiles returns the files changed on headSHA since it diverged from
+// baseSHA, relative to the repository root.
+func changedFiles(dir string, baseSHA string, headSHA string) ([]string, error) {
+	out, err := gitOutput(dir, "diff", "--name-only", "--no-renames", baseSHA+"..."+headSHA)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{}
+	for _, f := range strings.Split(out, "\n") {
+		if f, ok := cleanChangedPath(f); ok {
+			files = append(files, f)
+		}
+	}
+	return files, nil
+}
+
+// cleanChangedPath cleans a changed file path as reported by git or a
+// provider. It reports false for paths that aren't within the repository.
+func cleanChangedPath(f string) (string, bool) {
+	f = strings.TrimSpace(f)
+	if f == "" {
+		return "", false
+	}
+	f = path.Clean(f)
+	if f == "." || path.IsAbs(f) || f == ".." || strings.HasPrefix(f, "../") {
+		return "", false
+	}
+	return f, true
+}
+
+// needsFullBuild reports whether any of the changed files is one that
+// affects every target.
+func (c *CheckConfig) needsFullBuild(files []string) bool {
+	patterns := c.FullBuildPaths
+	if len(patterns) == 0 {
+		patterns = defaultFullBuildPaths
+	}
+	for _, f := range files {
+		for _, pattern := range patterns {
+			if matchGlob(pattern, f) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// fileTargetPattern returns a quoted bazel query expression for a changed
+// file, or an empty string if the file no longer exists in the checkout. ok
+// is false if the file can't be expressed in a query.
+func fileTargetPattern(dir string, file string) (pattern string, ok bool) {
+	if strings.ContainsAny(file, "\"\n") {
+		return "", false
+	}
+	switch path.Base(file) {
+	case "BUILD", "BUILD.bazel":
+		pkg := path.Dir(file)
+		if pkg == "." {
+			pkg = ""
+		}
+		return fmt.Sprintf("\"//%s:all\"", pkg), true
+	}
+	if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(file))); err != nil {
+		return "", true
+	}
+	return fmt.Sprintf("%q", file), true
+}
+
+// bazelTargets returns the target patterns a bazel check runs on. In affected
+// mode, only the configured targets that depend on a changed file are
+// returned, which may be none; otherwise, or when the changes can't be
+// expressed in a query, all configured targets are. It fails if the query of
+// affected targets fails, rather than letting the check pass without building
+// anything.
+func bazelTargets(ctx context.Context, target *CheckTarget, tests bool) ([]string, error) {
+	config := target.Config
+	all := config.BazelTargets(target.bazel)
+	if !config.Affected || target.ChangedFiles == nil {
+		return all, nil
+	}
+	if config.needsFullBuild(target.ChangedFiles) {
+		logFrom(ctx).Infow("changes need a full build")
+		return all, nil
+	}
+
+	var files []string
+	for _, f := range target.ChangedFiles {
+		p, ok := fileTargetPattern(target.Dir, f)
+		if !ok {
+			logFrom(ctx).Infow("changed file can't be queried, building everything", "file", f)
+			return all, nil
+		}
+		if p != "" {
+			files = append(files, p)
+		}
+	}
+	if len(files) == 0 {
+		return nil, nil
+	}
+	query := fmt.Sprintf("rdeps(%s, set(%s))", strings.Join(all, " + "), strings.Join(files, " "))
+	if tests {
+		query = fmt.Sprintf("tests(%s)", query)
+	}
+	stdOut, stdErr, err := runCheckCmd(ctx, target, "bb", "query", "--output=label", query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute affected targets: %s: %s", err, truncateOutput(strings.TrimSpace(cleanLine(stdErr.String())), CurrentSettings().MaxOutputLines))
+	}
+	targets := []string{}
+	for _, line := range strings.Split(stdOut.String(), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "//") || strings.HasPrefix(line, "@") {
+			targets = append(targets, line)
+		}
+	}
+	logFrom(ctx).Infow("computed affected targets", "targets", len(targets), "changed_files", len(target.ChangedFiles))
+	return targets, nil
+}
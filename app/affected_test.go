@@ -0,0 +1,153 @@
+package app
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestChangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	testGit(t, dir, "init", "-q")
+	base := commitTestFile(t, dir, "BUILD", "base\n")
+	commitTestFile(t, dir, "app/main.go", "package main\n")
+	head := commitTestFile(t, dir, "BUILD", "head\n")
+
+	got, err := changedFiles(dir, base, head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"BUILD", "app/main.go"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("changedFiles = %v, want %v", got, want)
+	}
+}
+
+func TestNeedsFullBuild(t *testing.T) {
+	for _, tc := range []struct {
+		config *CheckConfig
+		files  []string
+		want   bool
+	}{
+		{&CheckConfig{}, []string{"app/main.go", "app/BUILD"}, false},
+		{&CheckConfig{}, []string{"app/main.go", "MODULE.bazel"}, true},
+		{&CheckConfig{FullBuildPaths: []string{"third_party/**"}}, []string{"MODULE.bazel"}, false},
+		{&CheckConfig{FullBuildPaths: []string{"third_party/**"}}, []string{"third_party/go/deps.bzl"}, true},
+	} {
+		if got := tc.config.needsFullBuild(tc.files); got != tc.want {
+			t.Errorf("needsFullBuild(%v) with paths %v = %t, want %t", tc.files, tc.config.FullBuildPaths, got, tc.want)
+		}
+	}
+}
+
+func TestCleanChangedPath(t *testing.T) {
+	for f, want := range map[string]string{
+		" app/main.go ":   "app/main.go",
+		"./app/BUILD":     "app/BUILD",
+		"app/../lib/x.go": "lib/x.go",
+		"":                "",
+		".":               "",
+		"/etc/passwd":     "",
+		"../outside":      "",
+		"app/../../x":     "",
+	} {
+		got, ok := cleanChangedPath(f)
+		if got != want || ok != (want != "") {
+			t.Errorf("cleanChangedPath(%q) = %q, %t, want %q", f, got, ok, want)
+		}
+	}
+}
+
+func TestFileTargetPattern(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "app/main.go", "package main\n")
+	for file, want := range map[string]string{
+		"app/BUILD":         `"//app:all"`,
+		"lib/BUILD.bazel":   `"//lib:all"`,
+		"BUILD":             `"//:all"`,
+		"app/main.go":       `"app/main.go"`,
+		"app/removed.go":    "",
+		"app/lib/BUILD.old": "",
+	} {
+		if got, ok := fileTargetPattern(dir, file); got != want || !ok {
+			t.Errorf("fileTargetPattern(%q) = %q, %t, want %q", file, got, ok, want)
+		}
+	}
+	for _, file := range []string{`app/"quoted".go`, "app/new\nline.go"} {
+		if _, ok := fileTargetPattern(dir, file); ok {
+			t.Errorf("fileTargetPattern(%q) can be queried", file)
+		}
+	}
+}
+
+func TestBazelTargetsQueriesAffectedTargets(t *testing.T) {
+	queryFile := filepath.Join(t.TempDir(), "query")
+	installFakeTool(t, "bb", `echo "$@" > `+queryFile+`
+echo "Loading: 0 packages loaded"
+echo "//app:app_test"
+echo "//app:lib_test"
+`)
+	dir := t.TempDir()
+	writeTestFile(t, dir, "app/main.go", "package main\n")
+	target := &CheckTarget{
+		Dir:          dir,
+		Config:       &CheckConfig{Affected: true, Targets: []string{"//app/...", "//lib/..."}},
+		ChangedFiles: []string{"app/main.go", "app/BUILD", "app/removed.go"},
+	}
+
+	got, err := bazelTargets(context.Background(), target, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"//app:app_test", "//app:lib_test"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("bazelTargets = %v, want %v", got, want)
+	}
+	query, err := os.ReadFile(queryFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `tests(rdeps(//app/... + //lib/..., set("app/main.go" "//app:all")))`; !strings.Contains(string(query), want) {
+		t.Errorf("got query %q, want %q", query, want)
+	}
+}
+
+func TestBazelTargetsFallsBackToAllTargets(t *testing.T) {
+	all := []string{"//..."}
+	for name, target := range map[string]*CheckTarget{
+		"not affected mode":    {Config: &CheckConfig{}, ChangedFiles: []string{"app/main.go"}},
+		"unknown changes":      {Config: &CheckConfig{Affected: true}},
+		"WORKSPACE is changed": {Config: &CheckConfig{Affected: true}, ChangedFiles: []string{"app/main.go", "WORKSPACE"}},
+		"unqueryable file":     {Dir: t.TempDir(), Config: &CheckConfig{Affected: true}, ChangedFiles: []string{`app/"quoted".go`}},
+	} {
+		if got, err := bazelTargets(context.Background(), target, false); err != nil || !reflect.DeepEqual(got, all) {
+			t.Errorf("%s: bazelTargets = %v, %v, want %v", name, got, err, all)
+		}
+	}
+}
+
+func TestBazelTargetsWithoutExistingFiles(t *testing.T) {
+	target := &CheckTarget{Dir: t.TempDir(), Config: &CheckConfig{Affected: true}, ChangedFiles: []string{"app/removed.go"}}
+	if got, err := bazelTargets(context.Background(), target, false); err != nil || got != nil {
+		t.Errorf("bazelTargets = %v, %v, want none", got, err)
+	}
+}
+
+func TestBazelTargetsFailsWhenQueryFails(t *testing.T) {
+	installFakeTool(t, "bb", `echo "//app:partial"
+echo "ERROR: no such package 'app'" >&2
+exit 7
+`)
+	dir := t.TempDir()
+	writeTestFile(t, dir, "app/main.go", "package main\n")
+	target := &CheckTarget{Dir: dir, Config: &CheckConfig{Affected: true}, ChangedFiles: []string{"app/main.go"}}
+
+	_, err := bazelTargets(context.Background(), target, false)
+	if err == nil || !strings.Contains(err.Error(), "no such package 'app'") {
+		t.Errorf("got error %v, want the query's failure", err)
+	}
+	if _, err := checkBazelBuild(context.Background(), newTestApp(t, newFakeGitHub(t)), target); err == nil {
+		t.Errorf("bazel check passed although its targets couldn't be computed")
+	}
+}
@@ -0,0 +1,131 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/google/go-github/v43/github"
+)
+
+// aggregateCheckName is the name of the aggregate check run. It isn't a
+// registered check and can't be configured in .reviewbot.yaml.
+const aggregateCheckName = "reviewbot"
+
+// rerunFailedIdentifier identifies the action on the aggregate check run that
+// reruns the checks that failed.
+const rerunFailedIdentifier = "rerun-failed"
+
+// rerunFailedAction is offered on a failed aggregate check run.
+var rerunFailedAction = &github.CheckRunAction{
+	Label:       "Re-run failed",
+	Description: "Re-run only the checks that failed.",
+	Identifier:  rerunFailedIdentifier,
+}
+
+// failedCheck reports whether the conclusion of a completed run fails the
+// aggregate.
+func failedCheck(run *github.CheckRun) bool {
+	return failedConclusion(run.GetConclusion())
+}
+
+// aggregateConclusion rolls up the conclusions of runs. Neutral and skipped
+// checks don't fail the aggregate. It returns "" while a run isn't completed.
+func aggregateConclusion(runs map[string]*github.CheckRun) string {
+	conclusion := "success"
+	for _, run := range runs {
+		if run.GetStatus() != "completed" {
+			return ""
+		}
+		if failedCheck(run) {
+			conclusion = "failure"
+		}
+	}
+	return conclusion
+}
+
+// updateAggregateCheckRun creates or updates the aggregate check run on
+// headSHA from the latest runs of the other checks.
+func updateAggregateCheckRun(ctx context.Context, ghc *github.Client, appID int64, owner string, repoName string, headSHA string) error {
+	unlock := lockSummary(fmt.Sprintf("%s/%s@%s", owner, repoName, headSHA))
+	defer unlock()
+
+	runs, err := listCheckRuns(ctx, ghc, appID, owner, repoName, headSHA)
+	if err != nil {
+		return err
+	}
+	aggregate := runs[aggregateCheckName]
+	delete(runs, aggregateCheckName)
+	if len(runs) == 0 {
+		return nil
+	}
+	output := &github.CheckRunOutput{
+		Title:   github.String("Checks are running"),
+		Summary: github.String(summaryTable(runs)),
+	}
+	conclusion := aggregateConclusion(runs)
+	switch conclusion {
+	case "success":
+		output.Title = github.String("All checks passed")
+	case "failure":
+		output.Title = github.String("Some checks failed")
+	}
+
+	// A completed run isn't set back in progress, since a new run supersedes
+	// it when checks are rerun.
+	if aggregate == nil || (aggregate.GetStatus() == "completed" && conclusion == "") {
+		opts := github.CreateCheckRunOptions{
+			Name:    aggregateCheckName,
+			HeadSHA: headSHA,
+			Status:  github.String(inProgress),
+			Output:  output,
+		}
+		if conclusion != "" {
+			opts.Status = github.String("completed")
+			opts.Conclusion = github.String(conclusion)
+		}
+		if conclusion == "failure" {
+			opts.Actions = []*github.CheckRunAction{rerunFailedAction}
+		}
+		_, res, err := ghc.Checks.CreateCheckRun(ctx, owner, repoName, opts)
+		return extractError(ctx, res, err)
+	}
+	opts := github.UpdateCheckRunOptions{
+		Name:   aggregateCheckName,
+		Output: output,
+	}
+	if conclusion != "" {
+		opts.Status = github.String("completed")
+		opts.Conclusion = github.String(conclusion)
+	}
+	if conclusion == "failure" {
+		opts.Actions = []*github.CheckRunAction{rerunFailedAction}
+	}
+	_, res, err := ghc.Checks.UpdateCheckRun(ctx, owner, repoName, aggregate.GetID(), opts)
+	return extractError(ctx, res, err)
+}
+
+// rerunFailedChecks creates new check runs for the checks whose latest run on
+// headSHA failed, leaving the runs of the other checks alone.
+func (app *GithubApp) rerunFailedChecks(ctx context.Context, installationID int64, repo *github.Repository, headSHA string) error {
+	runs, err := listCheckRuns(ctx, app.GetClient(installationID), app.appID, repo.GetOwner().GetLogin(), repo.GetName(), headSHA)
+	if err != nil {
+		return err
+	}
+	// The runs of matrix cells aren't registered checks themselves.
+	var checkNames []string
+	for checkName, run := range runs {
+		if _, err := GetChecker(checkName); err != nil {
+			continue
+		}
+		if run.GetStatus() == "completed" && failedCheck(run) {
+			checkNames = append(checkNames, checkName)
+		}
+	}
+	sort.Strings(checkNames)
+	if len(checkNames) == 0 {
+		logFrom(ctx).Infow("no failed checks to rerun", "repo", repo.GetFullName(), "sha", headSHA)
+		return nil
+	}
+	return app.createCheckRuns(ctx, installationID, repo, headSHA, checkNames)
+}
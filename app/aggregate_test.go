@@ -0,0 +1,224 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-github/v43/github"
+)
+
+func TestAggregateConclusion(t *testing.T) {
+	run := func(status, conclusion string) *github.CheckRun {
+		return &github.CheckRun{Status: github.String(status), Conclusion: github.String(conclusion)}
+	}
+	for _, tc := range []struct {
+		desc string
+		runs map[string]*github.CheckRun
+		want string
+	}{
+		{
+			desc: "passed",
+			runs: map[string]*github.CheckRun{"a": run("completed", "success"), "b": run("completed", "neutral"), "c": run("completed", "skipped")},
+			want: "success",
+		},
+		{
+			desc: "failed",
+			runs: map[string]*github.CheckRun{"a": run("completed", "success"), "b": run("completed", "timed_out")},
+			want: "failure",
+		},
+		{
+			desc: "running",
+			runs: map[string]*github.CheckRun{"a": run("completed", "failure"), "b": run("in_progress", "")},
+			want: "",
+		},
+	} {
+		if got := aggregateConclusion(tc.runs); got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.desc, got, tc.want)
+		}
+	}
+}
+
+// serveAggregateRuns lets f list runs as the check runs on abc and records
+// the aggregate check runs created and the updates of existing runs.
+func serveAggregateRuns(t *testing.T, f *fakeGitHub, runs ...map[string]interface{}) (created *[]*github.CreateCheckRunOptions, updated *[]*github.UpdateCheckRunOptions) {
+	f.handle("GET /repos/o/r/commits/abc/check-runs", func(w http.ResponseWriter, req *http.Request) {
+		writeTestJSON(w, http.StatusOK, map[string]interface{}{"total_count": len(runs), "check_runs": runs})
+	})
+	created = &[]*github.CreateCheckRunOptions{}
+	f.handle("POST /repos/o/r/check-runs", func(w http.ResponseWriter, req *http.Request) {
+		opts := &github.CreateCheckRunOptions{}
+		if err := json.NewDecoder(req.Body).Decode(opts); err != nil {
+			t.Errorf("failed to decode check run: %s", err)
+		}
+		*created = append(*created, opts)
+		writeTestJSON(w, http.StatusCreated, map[string]interface{}{"id": 100})
+	})
+	updated = &[]*github.UpdateCheckRunOptions{}
+	f.handle("PATCH /repos/o/r/check-runs/9", func(w http.ResponseWriter, req *http.Request) {
+		opts := &github.UpdateCheckRunOptions{}
+		if err := json.NewDecoder(req.Body).Decode(opts); err != nil {
+			t.Errorf("failed to decode check run update: %s", err)
+		}
+		*updated = append(*updated, opts)
+		writeTestJSON(w, http.StatusOK, map[string]interface{}{"id": 9})
+	})
+	return created, updated
+}
+
+func TestUpdateAggregateCheckRunCreatesRun(t *testing.T) {
+	f := newFakeGitHub(t)
+	created, _ := serveAggregateRuns(t, f,
+		map[string]interface{}{"id": 1, "name": "gofmt", "status": "completed", "conclusion": "success"},
+		map[string]interface{}{"id": 2, "name": "buildifier", "status": "completed", "conclusion": "neutral"},
+	)
+	app := newTestApp(t, f)
+
+	if err := updateAggregateCheckRun(context.Background(), app.GetClient(testInstallationID), testAppID, "o", "r", "abc"); err != nil {
+		t.Fatal(err)
+	}
+	if len(*created) != 1 {
+		t.Fatalf("created %d check runs, want the aggregate", len(*created))
+	}
+	opts := (*created)[0]
+	if opts.Name != aggregateCheckName || opts.GetStatus() != "completed" || opts.GetConclusion() != "success" {
+		t.Errorf("created %s %q with conclusion %q, want a successful %s", opts.Name, opts.GetStatus(), opts.GetConclusion(), aggregateCheckName)
+	}
+	if opts.GetOutput().GetTitle() != "All checks passed" {
+		t.Errorf("got title %q, want all checks to have passed", opts.GetOutput().GetTitle())
+	}
+	if len(opts.Actions) != 0 {
+		t.Errorf("got actions %+v, want none when all checks passed", opts.Actions)
+	}
+}
+
+func TestUpdateAggregateCheckRunUpdatesRun(t *testing.T) {
+	f := newFakeGitHub(t)
+	created, updated := serveAggregateRuns(t, f,
+		map[string]interface{}{"id": 1, "name": "gofmt", "status": "completed", "conclusion": "failure"},
+		map[string]interface{}{"id": 9, "name": aggregateCheckName, "status": "in_progress"},
+	)
+	app := newTestApp(t, f)
+
+	if err := updateAggregateCheckRun(context.Background(), app.GetClient(testInstallationID), testAppID, "o", "r", "abc"); err != nil {
+		t.Fatal(err)
+	}
+	if len(*created) != 0 {
+		t.Errorf("created %d check runs, want the aggregate to be updated", len(*created))
+	}
+	if len(*updated) != 1 || (*updated)[0].GetConclusion() != "failure" || (*updated)[0].GetOutput().GetTitle() != "Some checks failed" {
+		t.Fatalf("got updates %+v, want the aggregate to fail", *updated)
+	}
+	if actions := (*updated)[0].Actions; len(actions) != 1 || actions[0].Identifier != rerunFailedIdentifier {
+		t.Errorf("got actions %+v, want the failed aggregate to offer a rerun", actions)
+	}
+}
+
+func TestUpdateAggregateCheckRunStartsNewRunWhenRerun(t *testing.T) {
+	f := newFakeGitHub(t)
+	created, updated := serveAggregateRuns(t, f,
+		map[string]interface{}{"id": 1, "name": "gofmt", "status": "queued"},
+		map[string]interface{}{"id": 9, "name": aggregateCheckName, "status": "completed", "conclusion": "failure"},
+	)
+	app := newTestApp(t, f)
+
+	if err := updateAggregateCheckRun(context.Background(), app.GetClient(testInstallationID), testAppID, "o", "r", "abc"); err != nil {
+		t.Fatal(err)
+	}
+	if len(*updated) != 0 {
+		t.Errorf("updated the completed aggregate %d times", len(*updated))
+	}
+	if len(*created) != 1 || (*created)[0].GetStatus() != inProgress || (*created)[0].Conclusion != nil {
+		t.Errorf("created %+v, want a new aggregate in progress", *created)
+	}
+}
+
+func TestFailedCheck(t *testing.T) {
+	for conclusion, want := range map[string]bool{
+		"success":         false,
+		"neutral":         false,
+		"skipped":         false,
+		"failure":         true,
+		"cancelled":       true,
+		"timed_out":       true,
+		"action_required": true,
+	} {
+		if got := failedCheck(&github.CheckRun{Conclusion: github.String(conclusion)}); got != want {
+			t.Errorf("failedCheck(%s) = %t, want %t", conclusion, got, want)
+		}
+	}
+}
+
+func TestTakeRequestedActionRerunsFailedChecks(t *testing.T) {
+	registerTestChecker(t, &funcChecker{name: "test-failed"})
+	registerTestChecker(t, &funcChecker{name: "test-passed"})
+	f := newFakeGitHub(t)
+	created, _ := serveAggregateRuns(t, f,
+		map[string]interface{}{"id": 1, "name": "test-failed", "status": "completed", "conclusion": "failure"},
+		map[string]interface{}{"id": 2, "name": "test-passed", "status": "completed", "conclusion": "success"},
+		map[string]interface{}{"id": 9, "name": aggregateCheckName, "status": "completed", "conclusion": "failure"},
+	)
+	app := newTestApp(t, f)
+	d := &recordingDispatcher{}
+	app.SetDispatcher(d)
+
+	event := func(identifier string) *github.CheckRunEvent {
+		return &github.CheckRunEvent{
+			Installation:    &github.Installation{ID: github.Int64(testInstallationID)},
+			Repo:            testRepo(),
+			CheckRun:        &github.CheckRun{ID: github.Int64(9), Name: github.String(aggregateCheckName), HeadSHA: github.String("abc")},
+			RequestedAction: &github.RequestedAction{Identifier: identifier},
+		}
+	}
+	if err := app.TakeRequestedAction(context.Background(), event("fix-"+aggregateCheckName)); err != nil {
+		t.Fatal(err)
+	}
+	if len(*created) != 0 {
+		t.Fatalf("created %d check runs for an unknown action", len(*created))
+	}
+	if err := app.TakeRequestedAction(context.Background(), event(rerunFailedIdentifier)); err != nil {
+		t.Fatal(err)
+	}
+	if len(*created) == 0 || (*created)[0].Name != "test-failed" {
+		t.Fatalf("created %+v, want a new run of the failed check", *created)
+	}
+	for _, opts := range (*created)[1:] {
+		if opts.Name != aggregateCheckName {
+			t.Errorf("created a run of %s, want only the failed check to rerun", opts.Name)
+		}
+	}
+	if len(d.jobs) != 1 || len(d.jobs[0].Checks) != 1 || d.jobs[0].Checks[0].Name != "test-failed" {
+		t.Errorf("dispatched %+v, want a job running only the failed check", d.jobs)
+	}
+}
+
+func TestTakeRequestedActionRerunsFailedMatrixCells(t *testing.T) {
+	registerTestChecker(t, &funcChecker{name: "test-matrix", matrix: true})
+	f := newFakeGitHub(t)
+	created, _ := serveAggregateRuns(t, f,
+		map[string]interface{}{"id": 1, "name": "test-matrix (asan)", "status": "completed", "conclusion": "failure"},
+		map[string]interface{}{"id": 2, "name": "test-matrix (linux)", "status": "completed", "conclusion": "success"},
+		map[string]interface{}{"id": 3, "name": "unknown (asan)", "status": "completed", "conclusion": "failure"},
+		map[string]interface{}{"id": 9, "name": aggregateCheckName, "status": "completed", "conclusion": "failure"},
+	)
+	app := newTestApp(t, f)
+	d := &recordingDispatcher{}
+	app.SetDispatcher(d)
+
+	event := &github.CheckRunEvent{
+		Installation:    &github.Installation{ID: github.Int64(testInstallationID)},
+		Repo:            testRepo(),
+		CheckRun:        &github.CheckRun{ID: github.Int64(9), Name: github.String(aggregateCheckName), HeadSHA: github.String("abc")},
+		RequestedAction: &github.RequestedAction{Identifier: rerunFailedIdentifier},
+	}
+	if err := app.TakeRequestedAction(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+	if len(*created) == 0 || (*created)[0].Name != "test-matrix (asan)" {
+		t.Fatalf("created %+v, want a new run of the failed cell", *created)
+	}
+	if len(d.jobs) != 1 || len(d.jobs[0].Checks) != 1 || d.jobs[0].Checks[0].Name != "test-matrix (asan)" {
+		t.Errorf("dispatched %+v, want a job running only the failed cell", d.jobs)
+	}
+}
@@ -1,89 +1,212 @@
 package app
 
 import (
-	"bufio"
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
-	"strconv"
 	"strings"
+	"time"
 
 	"github.com/bradleyfalzon/ghinstallation/v2"
 	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/golang-jwt/jwt/v4"
 	"github.com/google/go-github/v43/github"
 )
 
 const (
 	inProgress      = "in_progress"
+	secretTTL       = 10 * time.Minute
 	buildifierCheck = "buildifier"
-	buildifierFix   = "buildifier-fix"
 	nogoCheck       = "bazel"
 )
 
 var (
-	checks           = []string{"buildifier", "bazel"}
+	requiredEvents   = []string{"check_suite", "check_run"}
 	lineCommentRegex = regexp.MustCompile(`^(?P<file>.*):(?P<line>\d+):(?P<col>\d+):(?P<comment>.*)`)
 	urlRegex         = regexp.MustCompile(`Streaming build results to: (?P<url>.*)`)
-)
-
-func GetCheckFn(checkName string) (checkFn, error) {
-	switch checkName {
-	case "buildifier":
-		return checkBuildifier, nil
-	case "bazel":
-		return checkBazelBuild, nil
-	}
 
-	return nil, fmt.Errorf("checkFn not found for %q", checkName)
-}
+	pullRequestRefSpec = config.RefSpec("+refs/pull/*/head:refs/remotes/origin/pull/*")
+)
 
 type GithubApp struct {
 	appID         int64
 	appsTransport *ghinstallation.AppsTransport
-	transport     *ghinstallation.Transport
-	webhookSecret string
-	bbAPIKey      string
+	// webhookSecrets are all accepted webhook secrets. More than one is
+	// configured while a secret is being rotated.
+	webhookSecrets []string
+	bbAPIKeys      SecretProvider
+
+	deliveries *expiringSet
+	inFlight   *expiringSet
+	dispatcher JobDispatcher
+	events     *eventQueue
+	pulls      *pullTracker
+	running    *runningChecks
+	store      Store
+	rateLimits *rateLimiters
+	warmups    *warmupScheduler
+	failures   *failureLog
+	tokens     *tokenCache
 }
 
-func NewGithubApp(appID int64, privateKeyPath string, webhookSecret string, bbAPIKey string) (*GithubApp, error) {
-	appsTransport, err := ghinstallation.NewAppsTransportKeyFromFile(http.DefaultTransport, appID, privateKeyPath)
+// validateConfig checks the configuration up front and returns a single error
+// listing every problem found, so that misconfiguration fails at startup
+// rather than in the middle of a check.
+func validateConfig(privateKey []byte, webhookSecrets []string, bbAPIKeys SecretProvider) error {
+	var problems []string
+	if len(privateKey) == 0 {
+		problems = append(problems, "private key is empty")
+	} else if _, err := jwt.ParseRSAPrivateKeyFromPEM(privateKey); err != nil {
+		problems = append(problems, fmt.Sprintf("private key is invalid: %s", err))
+	}
+	if len(webhookSecrets) == 0 {
+		problems = append(problems, "webhook secret is empty")
+	}
+	for i, secret := range webhookSecrets {
+		if secret == "" {
+			problems = append(problems, fmt.Sprintf("webhook secret #%d is empty", i))
+		}
+	}
+	missingKey := false
+	reported := make(map[string]struct{})
+	for _, checkName := range registeredChecks() {
+		c, err := GetChecker(checkName)
+		if err != nil {
+			return err
+		}
+		withReq, ok := c.(CheckerWithRequirements)
+		if !ok {
+			continue
+		}
+		req := withReq.Requirements()
+		if _, ok := CheckExecutor.(LocalExecutor); !ok {
+			// Tools don't run on the host.
+			req.Binaries = nil
+		}
+		if !enabledByDefault(c) {
+			// Opt-in checks only run in repositories that enable them, so a
+			// missing binary shouldn't prevent the app from starting.
+			for _, bin := range missingBinaries(req.Binaries) {
+				Logger.Warnw("binary required by opt-in check is not on PATH", "binary", bin, "check", checkName)
+			}
+			continue
+		}
+		if req.NeedsBBAPIKey && UseBuildBuddy && !missingKey && (bbAPIKeys == nil || bbAPIKeys == StaticSecretProvider("")) {
+			problems = append(problems, fmt.Sprintf("check %q requires a BuildBuddy API key", checkName))
+			missingKey = true
+		}
+		for _, bin := range missingBinaries(req.Binaries) {
+			if _, ok := reported[bin]; ok {
+				continue
+			}
+			problems = append(problems, fmt.Sprintf("binary %q required by check %q is not on PATH", bin, checkName))
+			reported[bin] = struct{}{}
+		}
+	}
+	if e, ok := CheckExecutor.(*ContainerExecutor); ok {
+		if _, err := exec.LookPath(e.Runtime); err != nil {
+			problems = append(problems, fmt.Sprintf("container runtime %q is not on PATH", e.Runtime))
+		}
+		if e.Image == "" {
+			problems = append(problems, "container image is empty")
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// missingBinaries returns the binaries of bins that aren't on PATH, with bb
+// resolved to the bazel binary in use. Downloaded tools are fetched on first
+// use, so they are never missing.
+func missingBinaries(bins []string) []string {
+	var missing []string
+	for _, bin := range bins {
+		if isDownloaded(bin) {
+			continue
+		}
+		if bin == "bb" {
+			bin = bazelBinary()
+		}
+		if _, err := exec.LookPath(bin); err != nil {
+			missing = append(missing, bin)
+		}
+	}
+	return missing
+}
+
+// NewGithubApp returns the app with appID, authenticated with the PEM encoded
+// privateKey.
+func NewGithubApp(appID int64, privateKey []byte, webhookSecrets []string, bbAPIKeys SecretProvider) (*GithubApp, error) {
+	if err := validateConfig(privateKey, webhookSecrets, bbAPIKeys); err != nil {
+		return nil, err
+	}
+	appsTransport, err := ghinstallation.NewAppsTransport(http.DefaultTransport, appID, privateKey)
 	if err != nil {
 		return nil, fmt.Errorf("error creating github app client: %s", err)
 	}
+	appsTransport.BaseURL = githubAPIURL()
 
 	app := &GithubApp{
-		appID:         appID,
-		webhookSecret: webhookSecret,
-		appsTransport: appsTransport,
-		bbAPIKey:      bbAPIKey,
+		appID:          appID,
+		webhookSecrets: webhookSecrets,
+		appsTransport:  appsTransport,
+		bbAPIKeys:      newCachingSecretProvider(bbAPIKeys, secretTTL),
+		deliveries:     newExpiringSet(deliveryTTL, maxDeliveries),
+		inFlight:       newExpiringSet(inFlightTTL, 0),
+		pulls:          newPullTracker(pullTrackerTTL),
+		running:        newRunningChecks(),
+		rateLimits:     newRateLimiters(),
+		failures:       &failureLog{},
+		tokens:         newTokenCache(),
+	}
+	app.dispatcher = &inlineDispatcher{app: app}
+	app.warmups = newWarmupScheduler(app, CacheWarmupWorkers)
+	if EventWorkers > 0 {
+		app.events = newEventQueue(app, EventWorkers, EventQueueSize)
 	}
 	return app, nil
 }
 
+// SetStore configures where the state of started check runs is recorded. By
+// default it isn't recorded.
+func (app *GithubApp) SetStore(store Store) {
+	app.store = store
+}
+
+// SetDispatcher configures where check jobs are sent for execution. By default
+// they run inline while handling the webhook.
+func (app *GithubApp) SetDispatcher(dispatcher JobDispatcher) {
+	app.dispatcher = dispatcher
+}
+
 func (app *GithubApp) GetClient(installationID int64) *github.Client {
-	transport := ghinstallation.NewFromAppsTransport(app.appsTransport, installationID)
-	return github.NewClient(&http.Client{Transport: transport})
+	return app.newClient(installationID, &installationTransport{app: app, installationID: installationID})
 }
 
 func (app *GithubApp) GetAppClient() *github.Client {
-	return github.NewClient(&http.Client{Transport: app.appsTransport})
+	return app.newClient(0, app.appsTransport)
 }
 
-func (app *GithubApp) Token(ctx context.Context, installationID int64) (string, error) {
-	tok, res, err := app.GetAppClient().Apps.CreateInstallationToken(ctx, installationID, &github.InstallationTokenOptions{})
-	if err := extractError(ctx, res, err); err != nil {
-		return "", err
-	}
-	return tok.GetToken(), nil
+// newClient returns a client sending requests through transport, subject to
+// the rate limit of installationID.
+func (app *GithubApp) newClient(installationID int64, transport http.RoundTripper) *github.Client {
+	return setClientURLs(github.NewClient(&http.Client{Transport: &rateLimitTransport{
+		base:    transport,
+		limiter: app.rateLimits.Get(installationID),
+	}}))
 }
 
 func extractError(ctx context.Context, res *github.Response, err error) error {
@@ -114,8 +237,29 @@ func readBody(ctx context.Context, res *github.Response) string {
 	return string(b)
 }
 
+// validatePayload validates the request against each accepted webhook secret
+// in turn and returns the payload for the first one that matches.
+func (app *GithubApp) validatePayload(req *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: %s", err)
+	}
+	for i, secret := range app.webhookSecrets {
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		payload, validateErr := github.ValidatePayload(req, []byte(secret))
+		if validateErr == nil {
+			if i > 0 {
+				Logger.Infow("webhook payload validated with previous secret", "delivery_id", github.DeliveryID(req), "secret_index", i)
+			}
+			return payload, nil
+		}
+		err = validateErr
+	}
+	return nil, err
+}
+
 func (app *GithubApp) HandleWebhook(w http.ResponseWriter, req *http.Request) {
-	payload, err := github.ValidatePayload(req, []byte(app.webhookSecret))
+	payload, err := app.validatePayload(req)
 	if err != nil {
 		writeError(w, err)
 		return
@@ -126,33 +270,125 @@ func (app *GithubApp) HandleWebhook(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	log.Printf("Got webhook payload of type %T", event)
-	ctx := context.Background()
+	deliveryID := github.DeliveryID(req)
+	ctx := withDeliveryID(context.Background(), deliveryID)
+	if CaptureDir != "" {
+		captureEvent(ctx, github.WebHookType(req), deliveryID, payload)
+	}
+	if deliveryID != "" && !app.addDelivery(ctx, deliveryID) {
+		logFrom(ctx).Infow("skipping redelivered webhook")
+		return
+	}
+
+	logFrom(ctx).Infow("got webhook", "event", github.WebHookType(req))
+
+	if e, ok := event.(*github.PingEvent); ok {
+		app.handlePing(ctx, w, e)
+		return
+	}
+	if installationID, owner, repo := eventSource(event); !sourceAllowed(installationID, owner, repo) {
+		// Answer with success so that GitHub doesn't flag the hook as
+		// failing.
+		logFrom(ctx).Infow("ignoring event from a source that isn't allowed", "installation_id", installationID, "owner", owner, "repo", repo)
+		return
+	}
+
+	if app.events == nil {
+		if err := app.processEvent(ctx, event); err != nil {
+			logFrom(ctx).Errorw("error handling event", "error", err)
+			app.recordFailure(ctx, event, err)
+		}
+		return
+	}
+	if !app.events.Enqueue(&webhookEvent{deliveryID: deliveryID, event: event}) {
+		// Forget the delivery so that a manual redelivery is processed.
+		app.removeDelivery(ctx, deliveryID)
+		http.Error(w, "event queue is full", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
 
+// processEvent acts on a parsed webhook event.
+func (app *GithubApp) processEvent(ctx context.Context, event interface{}) error {
+	var err error
 	switch e := event.(type) {
 	case *github.CheckSuiteEvent:
 		checkSuiteRequested := (e.GetAction() == "requested" || e.GetAction() == "rerequested")
 		if checkSuiteRequested {
 			err = app.CreateCheckRuns(ctx, e.Installation.GetID(), e.GetRepo(), e.CheckSuite.GetHeadSHA())
 		}
+	case *github.PullRequestEvent:
+		switch e.GetAction() {
+		case "opened", "synchronize", "reopened", "ready_for_review":
+			// Check suites aren't created for pull requests from forks, so
+			// create the check runs against the PR head directly.
+			app.pulls.Track(e.Installation.GetID(), e.GetRepo(), e.GetPullRequest())
+			err = app.CreateCheckRuns(ctx, e.Installation.GetID(), e.GetRepo(), e.GetPullRequest().GetHead().GetSHA())
+		case "unlabeled":
+			// Removing a skip label runs the checks that it skipped. Runs
+			// that already exist are left alone by createCheckRuns.
+			app.pulls.Track(e.Installation.GetID(), e.GetRepo(), e.GetPullRequest())
+			err = app.createCheckRunsOnUnlabel(ctx, e)
+		}
 	case *github.CheckRunEvent:
 		if e.CheckRun.GetApp().GetID() == app.appID {
 			switch e.GetAction() {
 			case "created":
 				err = app.InitCheckRun(ctx, e)
 			case "rerequested":
-				err = app.CreateCheckRuns(ctx, e.Installation.GetID(), e.GetRepo(), e.CheckRun.GetHeadSHA())
+				checkNames := []string{e.CheckRun.GetName()}
+				if e.CheckRun.GetName() == aggregateCheckName {
+					checkNames = registeredChecks()
+				}
+				err = app.createCheckRuns(ctx, e.Installation.GetID(), e.GetRepo(), e.CheckRun.GetHeadSHA(), checkNames)
 			case "requested_action":
 				err = app.TakeRequestedAction(ctx, e)
 			}
 		}
+	case *github.IssueCommentEvent:
+		if e.GetAction() == "created" && e.GetIssue().IsPullRequest() {
+			err = app.HandleComment(ctx, e)
+		}
+	case *github.PushEvent:
+		err = app.handlePush(ctx, e)
 	}
-	if err != nil {
-		log.Printf("error handling event: %s", err)
+	return err
+}
+
+// handlePing confirms that the webhook is wired up correctly and warns about
+// events the bot needs that the hook isn't subscribed to.
+func (app *GithubApp) handlePing(ctx context.Context, w http.ResponseWriter, event *github.PingEvent) {
+	hook := event.GetHook()
+	logFrom(ctx).Infow("got ping", "hook_id", event.GetHookID(), "hook_type", hook.GetType(), "events", hook.Events)
+
+	subscribed := make(map[string]struct{}, len(hook.Events))
+	for _, e := range hook.Events {
+		subscribed[e] = struct{}{}
+	}
+	var missing []string
+	if _, all := subscribed["*"]; !all && len(hook.Events) > 0 {
+		for _, e := range requiredEvents {
+			if _, ok := subscribed[e]; !ok {
+				missing = append(missing, e)
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "pong: review bot received ping for hook %d\n", event.GetHookID())
+	if len(missing) > 0 {
+		logFrom(ctx).Warnw("hook is not subscribed to required events", "hook_id", event.GetHookID(), "missing", missing)
+		fmt.Fprintf(w, "warning: hook is not subscribed to required events: %s\n", strings.Join(missing, ", "))
 	}
 }
 
 func (app *GithubApp) InitCheckRun(ctx context.Context, event *github.CheckRunEvent) error {
+	ctx = withLogFields(ctx, "repo", event.Repo.GetFullName(), "sha", event.CheckRun.GetHeadSHA(), "check", event.CheckRun.GetName())
+	if status := event.CheckRun.GetStatus(); status != "queued" {
+		// Runs created in progress are already being executed by a job.
+		logFrom(ctx).Infow("check run is not queued, not starting it", "status", status)
+		return nil
+	}
 	owner := event.Repo.GetOwner().GetLogin()
 	repo := event.Repo.GetName()
 	id := event.CheckRun.GetID()
@@ -161,123 +397,137 @@ func (app *GithubApp) InitCheckRun(ctx context.Context, event *github.CheckRunEv
 
 	opts := github.UpdateCheckRunOptions{
 		Name:   checkName,
-		Status: github.String("in_progress"),
+		Status: github.String(inProgress),
 	}
 	ghc := app.GetClient(installationID)
-	updateRun, res, err := ghc.Checks.UpdateCheckRun(ctx, owner, repo, id, opts)
-	if err := extractError(ctx, res, err); err != nil {
+	updateRun, err := updateNewCheckRun(ctx, ghc, owner, repo, id, opts)
+	if err != nil {
 		return err
 	}
-	log.Printf("updated Run %v", updateRun)
+	logFrom(ctx).Infow("check run started", "check_run_id", updateRun.GetID())
 
-	fullRepoName := event.Repo.GetFullName()
-	headSHA := event.CheckRun.GetHeadSHA()
-
-	// Run a test
-	dir := getTmpDir(fullRepoName, checkName)
+	token, err := app.Token(ctx, installationID)
+	if err != nil {
+		return fmt.Errorf("failed to get token: %s", err)
+	}
+	pr := app.pullRequest(ctx, installationID, event.Repo, event.CheckRun.GetHeadSHA())
+	return app.dispatcher.Dispatch(ctx, &Job{
+		DeliveryID:     deliveryID(ctx),
+		AppID:          app.appID,
+		InstallationID: installationID,
+		FullRepoName:   event.Repo.GetFullName(),
+		HeadSHA:        event.CheckRun.GetHeadSHA(),
+		BaseSHA:        pr.GetBase().GetSHA(),
+		PullNumber:     pr.GetNumber(),
+		BaseBranch:     pr.GetBase().GetRef(),
+		Checks:         []*JobCheck{{Name: checkName, CheckRunID: id}},
+		Token:          token,
+	})
+}
 
-	ref := GitRef{
-		hash: headSHA,
+// TakeRequestedAction handles a click on a check run's fix button, one of its
+// buildozer actions or the aggregate check's "Re-run failed" button.
+func (app *GithubApp) TakeRequestedAction(ctx context.Context, event *github.CheckRunEvent) error {
+	checkName := event.CheckRun.GetName()
+	identifier := event.RequestedAction.Identifier
+	if checkName == aggregateCheckName {
+		if identifier != rerunFailedIdentifier {
+			return nil
+		}
+		return app.rerunFailedChecks(ctx, event.Installation.GetID(), event.GetRepo(), event.CheckRun.GetHeadSHA())
+	}
+	if _, ok := buildozerActionIndex(identifier); !ok && identifier != fixIdentifier(checkName) {
+		return nil
 	}
+	pr := 0
+	if prs := event.CheckRun.PullRequests; len(prs) > 0 {
+		pr = prs[0].GetNumber()
+	}
+	return app.fixBranch(ctx, event.Installation.GetID(), event.Repo.GetFullName(), event.CheckRun.CheckSuite.GetHeadBranch(), event.CheckRun.GetHeadSHA(), pr, checkName, identifier)
+}
 
-	_, err = app.cloneRepo(ctx, fullRepoName, installationID, ref, dir)
+// fixerFor returns the Fixer of the requested action identifier on a run of
+// checkName, whose configuration is config.
+func fixerFor(checkName string, identifier string, config *CheckConfig) (Fixer, error) {
+	if i, ok := buildozerActionIndex(identifier); ok {
+		if i >= len(config.BuildozerActions) {
+			return nil, fmt.Errorf("check %q has no buildozer action %d", checkName, i)
+		}
+		return &buildozerFixer{action: config.BuildozerActions[i]}, nil
+	}
+	if identifier != fixIdentifier(checkName) {
+		return nil, fmt.Errorf("unknown action %q", identifier)
+	}
+	checker, err := GetChecker(checkName)
 	if err != nil {
-		return fmt.Errorf("failed to clone repo: %s", err)
+		return nil, err
 	}
-	defer func() {
-		err = os.RemoveAll(dir)
-		if err != nil {
-			log.Printf("failed to cleanup dir %q: %s", dir, err)
+	fixer, ok := checker.(Fixer)
+	if !ok || !checker.SupportsFix() {
+		return nil, fmt.Errorf("check %q doesn't support fixes", checkName)
+	}
+	return fixer, nil
+}
+
+// fixBranch clones headBranch of pull request pr, lets the Fixer of the action
+// identifier on checkName change the checkout, and pushes the result as a new
+// commit.
+func (app *GithubApp) fixBranch(ctx context.Context, installationID int64, fullRepoName string, headBranch string, headSHA string, pr int, checkName string, identifier string) error {
+	ctx = withLogFields(ctx, "repo", fullRepoName, "sha", headSHA, "check", checkName, "action", identifier)
+	// Buildozer actions are configured in the checkout, so they can only be
+	// resolved after cloning.
+	if _, ok := buildozerActionIndex(identifier); !ok {
+		if _, err := fixerFor(checkName, identifier, nil); err != nil {
+			return err
 		}
-	}()
+	}
 
-	checker, err := GetCheckFn(checkName)
+	dir, err := allocWorkspace(ctx, fullRepoName, headSHA, identifier)
 	if err != nil {
 		return err
 	}
-	result, err := checker(app, dir)
+	defer releaseWorkspace(ctx, dir)
+	ref := GitRef{
+		branch: headBranch,
+	}
+	r, err := app.cloneRepo(ctx, fullRepoName, installationID, ref, dir)
 	if err != nil {
-		return fmt.Errorf("failed to run %s: %s", checkName, err)
+		return fmt.Errorf("failed to clone repo: %s", err)
 	}
-	opts = createCompletedUpdateCheckRunOptions(result, checkName)
-	updateRun, res, err = ghc.Checks.UpdateCheckRun(ctx, owner, repo, id, opts)
-	if err := extractError(ctx, res, err); err != nil {
+	if err := checkoutBranch(r, headBranch); err != nil {
 		return err
 	}
-	log.Printf("updated Run %v", updateRun)
-
-	err = os.RemoveAll(dir)
+	config, err := loadRepoConfigFromDir(dir)
 	if err != nil {
-		log.Printf("failed to cleanup dir %q: %s", dir, err)
+		return err
+	}
+	target := &CheckTarget{
+		InstallationID: installationID,
+		FullRepoName:   fullRepoName,
+		HeadSHA:        headSHA,
+		Dir:            dir,
+		Config:         config.Check(checkName),
+	}
+	fixer, err := fixerFor(checkName, identifier, target.Config)
+	if err != nil {
+		return err
+	}
+	if err := fixer.Fix(ctx, app, target); err != nil {
+		return fmt.Errorf("failed to fix %s: %s", checkName, err)
 	}
-	return nil
-}
-
-func (app *GithubApp) TakeRequestedAction(ctx context.Context, event *github.CheckRunEvent) error {
-	installationID := event.Installation.GetID()
-	fullRepoName := event.Repo.GetFullName()
-	headBranch := event.CheckRun.CheckSuite.GetHeadBranch()
-
-	if event.RequestedAction.Identifier == buildifierFix {
-		dir := getTmpDir(fullRepoName, buildifierFix)
-		ref := GitRef{
-			branch: headBranch,
-		}
-		_, err := app.cloneRepo(ctx, fullRepoName, installationID, ref, dir)
-		if err != nil {
-			return fmt.Errorf("failed to clone repo: %s", err)
-		}
-		defer func() {
-			err = os.RemoveAll(dir)
-			if err != nil {
-				log.Printf("failed to cleanup dir %q: %s", dir, err)
-			}
-		}()
-		//hack.. git push https://x-access-token:#{@installation_token.to_s}@github.com/#{full_repo_name}.git
-		token, err := app.Token(ctx, installationID)
-		if err != nil {
-			return fmt.Errorf("failed to get token: %s", err)
-		}
-		url := fmt.Sprintf("https://x-access-token:%s@github.com/%s.git", token, fullRepoName)
-		curDir, err := os.Getwd()
-		if err != nil {
-			return errors.New("failed to get current directory")
-		}
-		err = os.Chdir(dir)
-		if err != nil {
-			return fmt.Errorf("failed to change directory to %q: %s", dir, err)
-		}
-		_, stdErr, err := runCmd("git", "checkout", "--track", fmt.Sprintf("origin/%s", headBranch))
-		if stdErr.Len() != 0 {
-			log.Println(stdErr.String())
-		}
-		if err != nil {
-			return fmt.Errorf("failed to checkout branch %s: %s", headBranch, err)
-		}
-		_, _, err = runCmd("buildifier", "--mode=fix", "-r", dir)
-		if err != nil {
-			return err
-		}
 
-		log.Println("Creating commit")
-		_, stdErr, err = runCmd("git", "commit", "-a", "-m", `Fix BUILD lint errors`, "--author", `Lulu's Code Review Bot <lulu@luluz.club>`)
-		if stdErr.Len() != 0 {
-			log.Println(stdErr.String())
-		}
-		if err != nil {
-			return fmt.Errorf("failed to create commit: %s", err)
-		}
-		_, stdErr, err = runCmd("git", "push", url)
-		if stdErr.Len() != 0 {
-			log.Println(stdErr.String())
-		}
-		if err != nil {
-			return fmt.Errorf("failed to push to %q: %s", url, err)
-		}
-		err = os.Chdir(curDir)
-		if err != nil {
-			return fmt.Errorf("failed to change directory back %q: %s", curDir, err)
-		}
+	author := fixSignature(config.Fix)
+	hash, err := commitAll(r, fixCommitMessage(config.Fix, fixer, checkName, pr, author), author)
+	if err != nil {
+		return err
+	}
+	logFrom(ctx).Infow("created fix commit", "commit", hash.String())
+	token, err := app.Token(ctx, installationID)
+	if err != nil {
+		return fmt.Errorf("failed to get token: %s", err)
+	}
+	if err := pushBranch(ctx, r, token, fullRepoName, headBranch); err != nil {
+		return err
 	}
 	return nil
 }
@@ -287,18 +537,26 @@ func createCompletedUpdateCheckRunOptions(result *Result, checkName string) gith
 		Title:   github.String(result.Title),
 		Summary: github.String(result.Summary),
 	}
+	if result.Text != "" {
+		output.Text = github.String(result.Text)
+	}
 
 	if len(result.Annotations) > 0 {
 		output.Annotations = []*github.CheckRunAnnotation{}
 	}
 	for _, a := range result.Annotations {
-		output.Annotations = append(output.Annotations, &github.CheckRunAnnotation{
+		annotation := &github.CheckRunAnnotation{
 			Path:            github.String(a.Path),
 			StartLine:       github.Int(a.Line),
 			EndLine:         github.Int(a.Line),
 			AnnotationLevel: github.String(a.Severity),
 			Message:         github.String(a.Message),
-		})
+		}
+		if a.Column > 0 {
+			annotation.StartColumn = github.Int(a.Column)
+			annotation.EndColumn = github.Int(a.Column)
+		}
+		output.Annotations = append(output.Annotations, annotation)
 	}
 	opts := github.UpdateCheckRunOptions{
 		Name:       checkName,
@@ -308,54 +566,313 @@ func createCompletedUpdateCheckRunOptions(result *Result, checkName string) gith
 	}
 	if result.URL != "" {
 		opts.DetailsURL = github.String(result.URL)
+	} else if result.Conclusion == "action_required" {
+		// GitHub rejects action_required without a details URL.
+		opts.Conclusion = github.String("failure")
 	}
-	if action := result.Action; action != nil {
-		opts.Actions = []*github.CheckRunAction{
-			{
-				Label:       action.Label,
-				Description: action.Description,
-				Identifier:  action.Identifier,
-			},
+	actions := result.Actions
+	if result.Action != nil {
+		actions = append([]*Action{result.Action}, actions...)
+	}
+	for _, action := range actions {
+		if len(opts.Actions) == maxCheckRunActions {
+			break
 		}
+		opts.Actions = append(opts.Actions, &github.CheckRunAction{
+			Label:       action.Label,
+			Description: action.Description,
+			Identifier:  action.Identifier,
+		})
 	}
 	return opts
 }
 
-func getTmpDir(fullRepoName string, checkName string) string {
-	return fmt.Sprintf("/tmp/%s/%s", fullRepoName, checkName)
-}
+// maxCheckRunActions is the number of actions GitHub allows on a check run.
+const maxCheckRunActions = 3
+
+// maxAnnotationsPerRequest is the number of annotations GitHub accepts in a
+// single check run update; any beyond it are silently dropped.
+const maxAnnotationsPerRequest = 50
 
-type checkFn func(app *GithubApp, dir string) (*Result, error)
+// completeCheckRun reports result on a check run. Annotations are sent in
+// batches, since GitHub only accepts maxAnnotationsPerRequest per update and
+// appends the annotations of consecutive updates.
+func completeCheckRun(ctx context.Context, ghc *github.Client, owner string, repo string, id int64, result *Result, checkName string) (*github.CheckRun, error) {
+	opts := createCompletedUpdateCheckRunOptions(result, checkName)
+	annotations := opts.Output.Annotations
+	if len(annotations) > maxAnnotationsPerRequest {
+		opts.Output.Annotations = annotations[:maxAnnotationsPerRequest]
+	}
+	run, res, err := ghc.Checks.UpdateCheckRun(ctx, owner, repo, id, opts)
+	if err := extractError(ctx, res, err); err != nil {
+		return nil, err
+	}
+	for start := maxAnnotationsPerRequest; start < len(annotations); start += maxAnnotationsPerRequest {
+		end := start + maxAnnotationsPerRequest
+		if end > len(annotations) {
+			end = len(annotations)
+		}
+		batch := github.UpdateCheckRunOptions{
+			Name: checkName,
+			Output: &github.CheckRunOutput{
+				Title:       opts.Output.Title,
+				Summary:     opts.Output.Summary,
+				Text:        opts.Output.Text,
+				Annotations: annotations[start:end],
+			},
+		}
+		run, res, err = ghc.Checks.UpdateCheckRun(ctx, owner, repo, id, batch)
+		if err := extractError(ctx, res, err); err != nil {
+			return nil, fmt.Errorf("failed to add annotations %d-%d: %s", start, end, err)
+		}
+	}
+	return run, nil
+}
 
+// CreateCheckRuns creates a check run for each registered check on headSHA.
+// No check runs are created for draft pull requests or pull requests with a
+// skip label, if the repository is configured to skip them.
 func (app *GithubApp) CreateCheckRuns(ctx context.Context, installationID int64, repo *github.Repository, headSHA string) error {
+	config, err := app.fetchRepoConfig(ctx, installationID, repo.GetOwner().GetLogin(), repo.GetName(), headSHA)
+	if err != nil {
+		return err
+	}
+	if reason := config.skipReason(app.pullRequest(ctx, installationID, repo, headSHA)); reason != "" {
+		logFrom(ctx).Infow("skipping checks", "repo", repo.GetFullName(), "sha", headSHA, "reason", reason)
+		return nil
+	}
+	return app.createCheckRuns(ctx, installationID, repo, headSHA, registeredChecks())
+}
+
+// createCheckRunsOnUnlabel creates the check runs skipped because of a label
+// that was removed from a pull request.
+func (app *GithubApp) createCheckRunsOnUnlabel(ctx context.Context, e *github.PullRequestEvent) error {
+	repo := e.GetRepo()
+	headSHA := e.GetPullRequest().GetHead().GetSHA()
+	config, err := app.fetchRepoConfig(ctx, e.Installation.GetID(), repo.GetOwner().GetLogin(), repo.GetName(), headSHA)
+	if err != nil {
+		return err
+	}
+	if !config.isSkipLabel(e.GetLabel().GetName()) {
+		return nil
+	}
+	return app.CreateCheckRuns(ctx, e.Installation.GetID(), repo, headSHA)
+}
+
+// createCheckRuns creates a check run for each of checkNames, skipping checks
+// disabled in the repository's .reviewbot.yaml and checks that already have a
+// queued or in-progress run from this app for headSHA. If the installation
+// isn't allowed to use check runs, the checks are reported as commit statuses
+// instead.
+func (app *GithubApp) createCheckRuns(ctx context.Context, installationID int64, repo *github.Repository, headSHA string, checkNames []string) error {
+	ctx = withLogFields(ctx, "repo", repo.GetFullName(), "sha", headSHA)
 	owner := repo.GetOwner().GetLogin()
 	repoName := repo.GetName()
 
-	for _, checkName := range checks {
-		opts := github.CreateCheckRunOptions{
-			Name:    checkName,
-			HeadSHA: headSHA,
+	config, err := app.fetchRepoConfig(ctx, installationID, owner, repoName, headSHA)
+	if err != nil {
+		return err
+	}
+	checkNames = config.expandMatrix(checkNames)
+	existing, err := listCheckRuns(ctx, app.GetClient(installationID), app.appID, owner, repoName, headSHA)
+	statuses := isForbidden(err)
+	if statuses {
+		logFrom(ctx).Infow("check runs are forbidden, reporting commit statuses instead")
+	} else if err != nil {
+		return err
+	}
+
+	// With ConcurrentChecks, the runs are created in progress and executed as
+	// a single job sharing one clone; otherwise each run is queued and picked
+	// up by InitCheckRun when GitHub reports it created.
+	var created []*JobCheck
+	var changed []string
+	changedLoaded := false
+	skipped := false
+	// The pull request is looked up at most once, and only when it's needed.
+	var pr *github.PullRequest
+	prLoaded := false
+	pullRequest := func() *github.PullRequest {
+		if !prLoaded {
+			pr = app.pullRequest(ctx, installationID, repo, headSHA)
+			prLoaded = true
 		}
-		_, res, err := app.GetClient(installationID).Checks.CreateCheckRun(ctx, owner, repoName, opts)
-		if err := extractError(ctx, res, err); err != nil {
+		return pr
+	}
+	for _, checkName := range checkNames {
+		checker, err := GetChecker(checkName)
+		if err != nil {
+			return err
+		}
+		if !config.Check(checkName).IsEnabled(enabledByDefault(checker)) {
+			logFrom(ctx).Infow("check is disabled by "+repoConfigFile, "check", checkName)
+			continue
+		}
+		if run, ok := existing[checkName]; ok && run.GetStatus() != "completed" {
+			logFrom(ctx).Infow("check run already exists", "check", checkName, "status", run.GetStatus())
+			continue
+		}
+		if cc := config.Check(checkName); len(cc.TriggerPaths) > 0 {
+			if !changedLoaded {
+				changed, err = app.listChangedFiles(ctx, installationID, repo, headSHA, pullRequest())
+				if err != nil {
+					return fmt.Errorf("failed to list changed files: %s", err)
+				}
+				changedLoaded = true
+			}
+			if !cc.triggeredBy(changed) {
+				if err := app.createSkippedCheckRun(ctx, installationID, repo, headSHA, checkName, cc.TriggerPaths, statuses); err != nil {
+					return err
+				}
+				skipped = true
+				continue
+			}
+		}
+		if !statuses {
+			opts := github.CreateCheckRunOptions{
+				Name:    checkName,
+				HeadSHA: headSHA,
+			}
+			if CurrentSettings().ConcurrentChecks {
+				opts.Status = github.String(inProgress)
+			}
+			run, res, err := app.GetClient(installationID).Checks.CreateCheckRun(ctx, owner, repoName, opts)
+			err = extractError(ctx, res, err)
+			if err == nil {
+				logFrom(ctx).Infow("check run created", "check", checkName, "check_run_id", run.GetID())
+				created = append(created, &JobCheck{Name: checkName, CheckRunID: run.GetID()})
+				continue
+			}
+			if !isForbidden(err) {
+				return err
+			}
+			logFrom(ctx).Infow("check runs are forbidden, reporting commit statuses instead")
+			statuses = true
+		}
+		if err := createCommitStatus(ctx, app.GetClient(installationID), owner, repoName, headSHA, checkName, nil); err != nil {
 			return err
 		}
-		log.Printf("checkRun created: %s", checkName)
+		logFrom(ctx).Infow("commit status created", "check", checkName)
+		created = append(created, &JobCheck{Name: checkName})
+	}
+	if config.AggregateCheckEnabled() && !statuses && (len(created) > 0 || skipped) {
+		if err := updateAggregateCheckRun(ctx, app.GetClient(installationID), app.appID, owner, repoName, headSHA); err != nil {
+			logFrom(ctx).Warnw("failed to update aggregate check run", "error", err)
+		}
+	}
+	if !CurrentSettings().ConcurrentChecks {
+		// Queued check runs are started by InitCheckRun, but nothing starts
+		// the checks reported as commit statuses.
+		var started []*JobCheck
+		for _, check := range created {
+			if check.CheckRunID == 0 {
+				started = append(started, check)
+			}
+		}
+		created = started
+	}
+	if len(created) == 0 {
+		return nil
+	}
+
+	token, err := app.Token(ctx, installationID)
+	if err != nil {
+		return fmt.Errorf("failed to get token: %s", err)
+	}
+	pr = pullRequest()
+	return app.dispatcher.Dispatch(ctx, &Job{
+		DeliveryID:     deliveryID(ctx),
+		AppID:          app.appID,
+		InstallationID: installationID,
+		FullRepoName:   repo.GetFullName(),
+		HeadSHA:        headSHA,
+		BaseSHA:        pr.GetBase().GetSHA(),
+		PullNumber:     pr.GetNumber(),
+		BaseBranch:     pr.GetBase().GetRef(),
+		Checks:         created,
+		Token:          token,
+	})
+}
+
+// skippedSummary explains why a check with triggerPaths didn't run.
+func skippedSummary(triggerPaths []string) string {
+	return fmt.Sprintf("Skipped since no files matching %s changed.", strings.Join(triggerPaths, ", "))
+}
+
+// createSkippedCheckRun completes checkName as neutral on headSHA without
+// running it, since none of the files matching triggerPaths changed. With
+// statuses, it's reported as a commit status instead.
+func (app *GithubApp) createSkippedCheckRun(ctx context.Context, installationID int64, repo *github.Repository, headSHA string, checkName string, triggerPaths []string, statuses bool) error {
+	summary := skippedSummary(triggerPaths)
+	if statuses {
+		result := &Result{Title: summary, Conclusion: "neutral"}
+		return createCommitStatus(ctx, app.GetClient(installationID), repo.GetOwner().GetLogin(), repo.GetName(), headSHA, checkName, result)
+	}
+	opts := github.CreateCheckRunOptions{
+		Name:       checkName,
+		HeadSHA:    headSHA,
+		Status:     github.String("completed"),
+		Conclusion: github.String("neutral"),
+		Output: &github.CheckRunOutput{
+			Title:   github.String("Skipped"),
+			Summary: github.String(summary),
+		},
+	}
+	run, res, err := app.GetClient(installationID).Checks.CreateCheckRun(ctx, repo.GetOwner().GetLogin(), repo.GetName(), opts)
+	if err := extractError(ctx, res, err); err != nil {
+		return err
 	}
+	logFrom(ctx).Infow("check run skipped", "check", checkName, "check_run_id", run.GetID())
 	return nil
 }
 
+// listCheckRuns returns the latest check run created by this app for each
+// check name on ref. It follows pagination, since a suite can have more check
+// runs than fit on one page.
+func listCheckRuns(ctx context.Context, ghc *github.Client, appID int64, owner string, repoName string, ref string) (map[string]*github.CheckRun, error) {
+	runs := make(map[string]*github.CheckRun)
+	opts := &github.ListCheckRunsOptions{
+		AppID:       github.Int64(appID),
+		Filter:      github.String("latest"),
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	for {
+		result, res, err := ghc.Checks.ListCheckRunsForRef(ctx, owner, repoName, ref, opts)
+		if err := extractError(ctx, res, err); err != nil {
+			return nil, err
+		}
+		for _, run := range result.CheckRuns {
+			name := run.GetName()
+			if prev, ok := runs[name]; !ok || run.GetID() > prev.GetID() {
+				runs[name] = run
+			}
+		}
+		if res.NextPage == 0 {
+			break
+		}
+		opts.Page = res.NextPage
+	}
+	return runs, nil
+}
+
 func writeError(w http.ResponseWriter, err error) {
 	statusCode := 500
 	if err, ok := err.(*github.ErrorResponse); ok && err.Response != nil {
 		statusCode = err.Response.StatusCode
 	}
+	if err, ok := err.(*httpError); ok {
+		statusCode = err.statusCode
+	}
 	http.Error(w, err.Error(), statusCode)
 }
 
 type GitRef struct {
 	hash   string
 	branch string
+	// history is set when the commits before the ref are needed, e.g. to
+	// diff against a base. Otherwise clones only fetch the ref's commit,
+	// except from RepoCacheDir, whose checkouts share the mirror's history.
+	history bool
 }
 
 func (app *GithubApp) cloneRepo(ctx context.Context, fullRepoName string, installationID int64, ref GitRef, targetDir string) (*git.Repository, error) {
@@ -363,67 +880,178 @@ func (app *GithubApp) cloneRepo(ctx context.Context, fullRepoName string, instal
 	if err != nil {
 		return nil, fmt.Errorf("failed to get token: %s", err)
 	}
-	url := fmt.Sprintf("https://x-access-token:%s@github.com/%s.git", token, fullRepoName)
-	r, err := git.PlainCloneContext(ctx, targetDir, false, &git.CloneOptions{
+	return cloneRepoWithToken(ctx, token, fullRepoName, ref, targetDir)
+}
+
+func cloneRepoWithToken(ctx context.Context, token string, fullRepoName string, ref GitRef, targetDir string) (*git.Repository, error) {
+	return cloneRepoFromURL(ctx, repoURL(fullRepoName), tokenAuth(token), fullRepoName, ref, targetDir, pullRequestRefSpec)
+}
+
+// cloneRepoFromURL clones the repository at url, authenticated with auth, and
+// checks ref out into targetDir. The clone's origin is url, which must not
+// embed credentials. cacheName identifies the repository in RepoCacheDir.
+// Commits that aren't reachable from a branch are looked for in the refs
+// fetched by pullRefSpec, e.g. those of pull requests from forks.
+func cloneRepoFromURL(ctx context.Context, url string, auth transport.AuthMethod, cacheName string, ref GitRef, targetDir string, pullRefSpec config.RefSpec) (*git.Repository, error) {
+	if RepoCacheDir != "" {
+		return cachedClone(ctx, url, auth, cacheName, ref, targetDir, pullRefSpec)
+	}
+	depth := 1
+	if ref.history {
+		depth = 0
+	}
+	opts := &git.CloneOptions{
 		URL:      url,
+		Auth:     auth,
+		Depth:    depth,
+		Tags:     git.NoTags,
 		Progress: os.Stdout,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("unable to clone repo to %q: %s", targetDir, err)
-	}
-
-	w, err := r.Worktree()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get work tree: %s", err)
 	}
-
 	if ref.branch != "" {
-		err = w.Pull(&git.PullOptions{
-			ReferenceName: plumbing.NewBranchReferenceName(ref.branch),
-		})
-
-		if err != nil && err != git.NoErrAlreadyUpToDate {
-			return nil, fmt.Errorf("failed to pull: %s", err)
-		}
+		opts.ReferenceName = plumbing.NewBranchReferenceName(ref.branch)
+		opts.SingleBranch = depth != 0
+	}
+	r, err := git.PlainCloneContext(ctx, targetDir, false, opts)
+	if err != nil {
+		return nil, fmt.Errorf("unable to clone repo to %q: %s", targetDir, err)
 	}
 
 	if ref.hash != "" {
-		err := w.Checkout(&git.CheckoutOptions{
-			Hash:  plumbing.NewHash(ref.hash),
-			Force: true,
-		})
+		w, err := r.Worktree()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get work tree: %s", err)
+		}
+		hash := plumbing.NewHash(ref.hash)
+		// Commits from pull requests opened from forks are only reachable
+		// through the pull request refs of the base repository. They are
+		// fetched before the checkout, since checking out a missing commit
+		// leaves HEAD at it and breaks later fetches.
+		if _, err = r.CommitObject(hash); err != nil {
+			var remote *git.Remote
+			if remote, err = r.Remote(git.DefaultRemoteName); err == nil {
+				err = fetch(ctx, remote, &git.FetchOptions{
+					RefSpecs: []config.RefSpec{pullRefSpec},
+					Auth:     auth,
+					Depth:    depth,
+					Tags:     git.NoTags,
+				})
+			}
+			if err == nil {
+				_, err = r.CommitObject(hash)
+			}
+		}
+		if err != nil && depth != 0 {
+			// The commit isn't the head of a branch or pull request, so it's
+			// only reachable through history.
+			logFrom(ctx).Infow("commit not found in shallow clone, cloning with history", "commit", ref.hash)
+			if err := os.RemoveAll(targetDir); err != nil {
+				return nil, err
+			}
+			ref.history = true
+			return cloneRepoFromURL(ctx, url, auth, cacheName, ref, targetDir, pullRefSpec)
+		}
+		if err == nil {
+			err = w.Checkout(&git.CheckoutOptions{Hash: hash, Force: true})
+		}
 		if err != nil {
 			return nil, fmt.Errorf("failed to checkout %s: %s", ref.hash, err)
 		}
 	}
 
+	if err := fetchLFSObjects(ctx, targetDir, auth); err != nil {
+		return nil, err
+	}
+
 	return r, nil
 }
 
 func runCmd(toolName string, arg ...string) (bytes.Buffer, bytes.Buffer, error) {
+	return runCmdInDir(context.Background(), "", toolName, arg...)
+}
+
+// runCmdInDir is like runCmd but runs the command in dir, and kills it when
+// ctx is done. Unlike os.Chdir, it is safe to use from concurrently running
+// checks. The output is also copied to the writer of ctx, see withOutput. A
+// failed exit status is returned as an *exec.ExitError, whether or not the
+// command wrote to stderr.
+func runCmdInDir(ctx context.Context, dir string, toolName string, arg ...string) (bytes.Buffer, bytes.Buffer, error) {
 	var output, stderr bytes.Buffer
-	cmd := exec.Command(toolName, arg...)
+	cmd := exec.CommandContext(ctx, toolName, arg...)
+	cmd.Dir = dir
 	cmd.Stdout = &output
 	cmd.Stderr = &stderr
+	if w := outputFrom(ctx); w != nil {
+		cmd.Stdout = io.MultiWriter(&output, w)
+		cmd.Stderr = io.MultiWriter(&stderr, w)
+	}
 	err := cmd.Run()
 
 	if err != nil {
-		log.Printf("check failed for cmd %q: %v", cmd, err)
+		logFrom(ctx).Warnw("command failed", "cmd", redactSecrets(cmd.String()), "error", err)
 	}
 	if stderr.Len() > 0 {
-		log.Printf("output: %s, %s", output.String(), stderr.String())
-		return output, stderr, nil
+		maxLines := CurrentSettings().MaxOutputLines
+		logFrom(ctx).Infow("command output", "cmd", redactSecrets(cmd.String()), "stdout", redactSecrets(truncateOutput(output.String(), maxLines)), "stderr", redactSecrets(truncateOutput(stderr.String(), maxLines)))
 	}
 	return output, stderr, err
 }
 
+// exitCode returns the exit status of a command run with runCmdInDir that
+// returned err: 0 if it succeeded, or -1 if it couldn't run or was killed.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// toolFailure reports a check whose tool failed rather than reporting issues,
+// with what went wrong and the tool's stderr in the summary.
+func toolFailure(tool string, problem string, stdErr string) *Result {
+	summary := fmt.Sprintf("%s failed: %s", tool, problem)
+	if stdErr = strings.TrimSpace(cleanLine(stdErr)); stdErr != "" {
+		const fence = "\n\n```\n%s\n```"
+		summary += fmt.Sprintf(fence, truncateText(stdErr, maxCheckRunText-len(summary)-len(fence)))
+	}
+	return &Result{
+		Title:      fmt.Sprintf("%s failed", tool),
+		Summary:    summary,
+		Conclusion: "failure",
+	}
+}
+
 type Result struct {
-	Title       string
-	Summary     string
+	Title   string
+	Summary string
+	// Text is shown below the summary in the check run output.
+	Text string
+	// Conclusion is one of "success", "failure", "neutral", "skipped",
+	// "cancelled", "timed_out" or "action_required". Checks that found
+	// nothing to check conclude neutral. action_required needs a URL that
+	// tells what to do; without one it's reported as failure.
 	Conclusion  string
 	Annotations []*Annotation
 	URL         string
-	Action      *Action
+	// Log is the output of the commands the check ran, shown on the bot's
+	// results page.
+	Log    string
+	Action *Action
+	// Actions are offered in addition to Action.
+	Actions []*Action
+}
+
+// failedConclusion reports whether a check that concluded with conclusion
+// failed. Neutral and skipped checks don't.
+func failedConclusion(conclusion string) bool {
+	switch conclusion {
+	case "success", "neutral", "skipped":
+		return false
+	}
+	return true
 }
 
 type Action struct {
@@ -433,146 +1061,215 @@ type Action struct {
 }
 
 type Annotation struct {
-	Message  string
-	Line     int
+	Message string
+	Line    int
+	// Column is the column of the issue on Line, or 0 if it isn't known.
+	Column   int
 	Path     string
 	Severity string
+	// WholeFile is set when the issue is with the file as a whole, like
+	// its formatting, rather than with Line, which is then 1.
+	WholeFile bool
 }
 
-// checkBuildifier checks if the given file is formatted according to buildifier and, if not, prints
-// a diff detailing what's wrong with the file to stdout and returns an error.
-func checkBuildifier(_ *GithubApp, dir string) (*Result, error) {
-	_, stdErr, err := runCmd("buildifier", "--mode=check", "-r", dir)
-	res := &Result{
-		Title: "Buildifier Lint Result",
+// buildifierReport is the output of `buildifier --format=json`.
+type buildifierReport struct {
+	Success bool              `json:"success"`
+	Files   []*buildifierFile `json:"files"`
+}
+
+type buildifierFile struct {
+	Filename  string               `json:"filename"`
+	Formatted bool                 `json:"formatted"`
+	Valid     bool                 `json:"valid"`
+	Warnings  []*buildifierWarning `json:"warnings"`
+}
+
+type buildifierWarning struct {
+	Start struct {
+		Line   int `json:"line"`
+		Column int `json:"column"`
+	} `json:"start"`
+	Category string `json:"category"`
+	Message  string `json:"message"`
+	URL      string `json:"url"`
+}
+
+// checkBuildifier checks that BUILD and .bzl files are formatted and lints
+// them. Unformatted files fail the check; lint warnings are annotated as
+// warnings, unless their category is one of the check's
+// failure_categories.
+func checkBuildifier(ctx context.Context, _ *GithubApp, target *CheckTarget) (*Result, error) {
+	dir := target.Dir
+	buildifier, err := buildtool(ctx, "buildifier", target)
+	if err != nil {
+		return nil, err
 	}
-	if stdErr.Len() == 0 {
+	args := append([]string{"--mode=check", "--lint=warn", "--format=json"}, target.Config.Flags...)
+	stdOut, stdErr, err := runCheckCmd(ctx, target, buildifier, append(args, "-r", dir)...)
+	if stdOut.Len() == 0 {
 		if err != nil {
 			return nil, err
 		}
-		res.Summary = "No issues found."
-		res.Conclusion = "success"
+		return nil, fmt.Errorf("buildifier produced no output: %s", strings.TrimSpace(cleanLine(stdErr.String())))
+	}
+	report := &buildifierReport{}
+	if err := json.Unmarshal(stdOut.Bytes(), report); err != nil {
+		return nil, fmt.Errorf("failed to parse buildifier output: %s", err)
+	}
+	if len(report.Files) == 0 {
+		return &Result{
+			Title:      "Buildifier Lint Result",
+			Summary:    "No BUILD or .bzl files found.",
+			Conclusion: "neutral",
+		}, nil
 	}
 
-	scanner := bufio.NewScanner(&stdErr)
+	failureCategories := make(map[string]bool)
+	for _, c := range target.Config.FailureCategories {
+		failureCategories[c] = true
+	}
 	annotations := []*Annotation{}
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		log.Printf("scanner: %q", line)
-		parts := strings.Split(line, "#")
-		if len(parts) > 0 {
-			rel, err := filepath.Rel(dir, strings.TrimSpace(parts[0]))
-			if err != nil {
-				log.Printf("failed to get reletive path: %s", err)
+	for _, f := range report.Files {
+		rel, err := filepath.Rel(dir, f.Filename)
+		if err != nil {
+			logFrom(ctx).Warnw("failed to get relative path", "path", f.Filename, "error", err)
+			rel = f.Filename
+		}
+		if !f.Valid {
+			annotations = append(annotations, &Annotation{
+				Message:   fmt.Sprintf("file %q can't be parsed", rel),
+				Severity:  "failure",
+				Path:      rel,
+				Line:      1,
+				WholeFile: true,
+			})
+			continue
+		}
+		if !f.Formatted {
+			annotations = append(annotations, &Annotation{
+				Message:   fmt.Sprintf("file %q needs reformat", rel),
+				Severity:  "failure",
+				Path:      rel,
+				Line:      1,
+				WholeFile: true,
+			})
+		}
+		for _, w := range f.Warnings {
+			severity := "warning"
+			if failureCategories[w.Category] {
+				severity = "failure"
+			}
+			message := fmt.Sprintf("[%s] %s", w.Category, w.Message)
+			if w.URL != "" {
+				message += fmt.Sprintf(" (%s)", w.URL)
 			}
 			annotations = append(annotations, &Annotation{
-				Message:  fmt.Sprintf("file %q needs reformat", rel),
-				Severity: "failure",
+				Message:  message,
+				Severity: severity,
 				Path:     rel,
-				Line:     1,
+				Line:     w.Start.Line,
+				Column:   w.Start.Column,
 			})
 		}
 	}
 
-	if len(annotations) > 0 {
-		res.Summary = fmt.Sprintf("%d BUILD files need reformat", len(annotations))
-		res.Conclusion = "failure"
-		res.Annotations = annotations
-		res.Action = &Action{
-			Label:       "Fix this",
-			Description: "Automatically fix buildifier errors.",
-			Identifier:  buildifierFix,
+	res := &Result{
+		Title: "Buildifier Lint Result",
+	}
+	annotations = target.Config.filterAnnotations(annotations)
+	failures := 0
+	for _, a := range annotations {
+		if a.Severity == "failure" {
+			failures++
 		}
-	} else {
+	}
+	switch {
+	case failures > 0:
+		res.Summary = fmt.Sprintf("%d issues must be fixed, %d warnings", failures, len(annotations)-failures)
+		res.Conclusion = "failure"
+		res.Action = newFixAction(buildifierCheck, "Automatically fix buildifier errors.")
+	case len(annotations) > 0:
+		res.Summary = fmt.Sprintf("%d warnings", len(annotations))
+		res.Conclusion = "neutral"
+	default:
 		res.Summary = "No issues found."
 		res.Conclusion = "success"
 	}
+	res.Annotations = annotations
 	return res, nil
 }
 
-func checkBazelBuild(app *GithubApp, dir string) (*Result, error) {
-	curDir, err := os.Getwd()
+// fixBuildifier reformats every BUILD file in the checkout.
+func fixBuildifier(ctx context.Context, _ *GithubApp, target *CheckTarget) error {
+	buildifier, err := buildtool(ctx, "buildifier", target)
 	if err != nil {
-		return nil, errors.New("failed to get current directory")
+		return err
 	}
-	err = os.Chdir(dir)
+	_, _, err = runCheckCmd(ctx, target, buildifier, "--mode=fix", "-r", target.Dir)
+	return err
+}
+
+func checkBazelBuild(ctx context.Context, app *GithubApp, target *CheckTarget) (*Result, error) {
+	apiKey, err := app.BuildBuddyAPIKey(ctx, target)
 	if err != nil {
-		return nil, fmt.Errorf("failed to change directory to %q: %s", dir, err)
+		return nil, err
 	}
-
-	stdOut, _, err := runCmd("bb", "build", "//...", fmt.Sprintf("--remote_header=x-buildbuddy-api-key=%s", app.bbAPIKey))
-	if stdOut.Len() == 0 {
+	targets, err := bazelTargets(ctx, target, false)
+	if err != nil {
 		return nil, err
 	}
-	scanner := bufio.NewScanner(&stdOut)
+	if len(targets) == 0 {
+		return &Result{
+			Title:      "Build result",
+			Summary:    "No targets are affected by this change.",
+			Conclusion: "neutral",
+		}, nil
+	}
+	bep, stdOut, stdErr, err := runBazelWithBEP(ctx, apiKey, target, "build", target.bazelFlags("build"), targets)
+	if isDiskFull(err, &stdOut, &stdErr) {
+		return diskFullResult(ctx, "Build result", target), nil
+	}
 
 	res := &Result{
 		Title: "Build result",
 	}
-	annotations := []*Annotation{}
-
-	url := ""
-	// dedupe
-	m := make(map[string]struct{})
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		line = strings.TrimSpace(line)
-
-		// check url
-		if url == "" {
-			urlIndex := urlRegex.SubexpIndex("url")
-			matches := urlRegex.FindStringSubmatch(line)
-			if len(matches) > 0 {
-				url = matches[urlIndex]
-				log.Printf("find url: %q", url)
-			}
-		}
-
-		// check errors
-		if strings.HasPrefix(line, "ERROR: ") || strings.HasPrefix(line, "INFO: ") || strings.HasPrefix(line, "FAILED: ") {
-			continue
+	if bep == nil || !bep.finished {
+		// Without a complete build event stream, e.g. when bazel crashed,
+		// fall back to scraping its output.
+		if stdOut.Len() == 0 {
+			return nil, err
 		}
-		fileIndex := lineCommentRegex.SubexpIndex("file")
-		lineIndex := lineCommentRegex.SubexpIndex("line")
-		commentIndex := lineCommentRegex.SubexpIndex("comment")
-		matches := lineCommentRegex.FindStringSubmatch(line)
-		if len(matches) > 0 {
-			if _, ok := m[line]; ok {
-				continue
-			}
-			file := matches[fileIndex]
-			lineNumStr := matches[lineIndex]
-			lineNum, err := strconv.Atoi(lineNumStr)
-			if err != nil {
-				log.Printf("unable to parse string %q to int", lineNumStr)
-			}
-			comment := matches[commentIndex]
-			annotations = append(annotations, &Annotation{
-				Message:  comment,
-				Severity: "failure",
-				Path:     file,
-				Line:     lineNum,
-			})
-			m[line] = struct{}{}
-			log.Println(line)
+		out := parseBazelOutput(ctx, &stdOut)
+		if len(out.annotations) == 0 {
+			res.Summary = "No issues found."
+			res.Conclusion = "success"
+		} else {
+			res.Summary = "Build doesn't complete successfully"
+			res.Conclusion = "failure"
+			res.Annotations = target.Config.filterAnnotations(out.annotations)
 		}
+		res.Summary += out.otherURLsSummary()
+		res.URL = out.primaryURL()
+		enrichResult(ctx, apiKey, res, nil)
+		return res, nil
 	}
-	if len(annotations) == 0 {
+
+	res.URL = bep.primaryURL()
+	if bep.success {
 		res.Summary = "No issues found."
 		res.Conclusion = "success"
 	} else {
-		res.Summary = "Build doesn't complete successfully"
+		res.Summary = fmt.Sprintf("Build doesn't complete successfully: %d targets failed", len(bep.failedTargets))
+		if bep.exitName != "" {
+			res.Summary += fmt.Sprintf(" (%s)", bep.exitName)
+		}
 		res.Conclusion = "failure"
-		res.Annotations = annotations
-	}
-	res.URL = url
-
-	err = os.Chdir(curDir)
-	if err != nil {
-		return nil, fmt.Errorf("failed to change directory to %q: %s", curDir, err)
+		res.Annotations = target.Config.filterAnnotations(bep.annotations)
+		res.Text = bep.failedTargetsTable(res.URL)
+		res.Text = truncateText(res.Text+bep.failureLogs(maxCheckRunText-len(res.Text)), maxCheckRunText)
 	}
+	res.Summary += bep.otherURLsSummary()
+	enrichResult(ctx, apiKey, res, bep)
 	return res, nil
-
 }
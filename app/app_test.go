@@ -0,0 +1,510 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bradleyfalzon/ghinstallation/v2"
+	"github.com/google/go-github/v43/github"
+)
+
+const (
+	testAppID          = 1
+	testInstallationID = 2
+	testWebhookSecret  = "secret"
+)
+
+// fakeGitHub serves the GitHub API for tests. Requests are routed by method
+// and path, without the /api/v3 prefix of the enterprise URLs the app is
+// pointed at; others get a 404. Every request is counted by route.
+type fakeGitHub struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	routes   map[string]http.HandlerFunc
+	requests map[string]int
+}
+
+func newFakeGitHub(t *testing.T) *fakeGitHub {
+	f := &fakeGitHub{
+		routes:   make(map[string]http.HandlerFunc),
+		requests: make(map[string]int),
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	if err := SetGitHubURLs(f.URL+"/api/v3/", ""); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { SetGitHubURLs("", "") })
+	f.handle("POST /app/installations/2/access_tokens", func(w http.ResponseWriter, req *http.Request) {
+		writeTestJSON(w, http.StatusCreated, map[string]interface{}{
+			"token":      "test-token",
+			"expires_at": time.Now().Add(time.Hour),
+		})
+	})
+	return f
+}
+
+// newFakeAPI returns a fake of the API of another code host than GitHub. Its
+// routes have escaped paths, like "GET /api/v4/projects/g%2Fp".
+func newFakeAPI(t *testing.T) *fakeGitHub {
+	f := &fakeGitHub{
+		routes:   make(map[string]http.HandlerFunc),
+		requests: make(map[string]int),
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.URL.Path = req.URL.EscapedPath()
+		f.serve(w, req)
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// handle routes requests to route, e.g. "GET /repos/o/r", to h.
+func (f *fakeGitHub) handle(route string, h http.HandlerFunc) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.routes[route] = h
+}
+
+// count returns how many requests were made to route.
+func (f *fakeGitHub) count(route string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests[route]
+}
+
+func (f *fakeGitHub) serve(w http.ResponseWriter, req *http.Request) {
+	route := req.Method + " " + strings.TrimPrefix(req.URL.Path, "/api/v3")
+	f.mu.Lock()
+	f.requests[route]++
+	h, ok := f.routes[route]
+	f.mu.Unlock()
+	if !ok {
+		writeTestJSON(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+		return
+	}
+	h(w, req)
+}
+
+func writeTestJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+var (
+	testKeyOnce sync.Once
+	testKey     []byte
+)
+
+// testPrivateKey returns a PEM encoded RSA key for the app to sign its JWTs
+// with.
+func testPrivateKey(t *testing.T) []byte {
+	testKeyOnce.Do(func() {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testKey = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	})
+	return testKey
+}
+
+// newTestApp returns an app talking to f. Unlike NewGithubApp, it doesn't
+// require the tools of the checks to be installed.
+func newTestApp(t *testing.T, f *fakeGitHub, webhookSecrets ...string) *GithubApp {
+	if len(webhookSecrets) == 0 {
+		webhookSecrets = []string{testWebhookSecret}
+	}
+	appsTransport, err := ghinstallation.NewAppsTransport(http.DefaultTransport, testAppID, testPrivateKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	appsTransport.BaseURL = githubAPIURL()
+	app := &GithubApp{
+		appID:          testAppID,
+		webhookSecrets: webhookSecrets,
+		appsTransport:  appsTransport,
+		bbAPIKeys:      StaticSecretProvider("bb-key"),
+		deliveries:     newExpiringSet(deliveryTTL, maxDeliveries),
+		inFlight:       newExpiringSet(inFlightTTL, 0),
+		pulls:          newPullTracker(pullTrackerTTL),
+		running:        newRunningChecks(),
+		rateLimits:     newRateLimiters(),
+		failures:       &failureLog{},
+		tokens:         newTokenCache(),
+	}
+	app.dispatcher = &inlineDispatcher{app: app}
+	app.warmups = newWarmupScheduler(app, 1)
+	return app
+}
+
+// webhookRequest returns a webhook delivery of event with payload, signed
+// with secret.
+func webhookRequest(t *testing.T, event string, deliveryID string, payload interface{}, secret string) *http.Request {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	req := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-GitHub-Event", event)
+	req.Header.Set("X-GitHub-Delivery", deliveryID)
+	req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	return req
+}
+
+func checkSuitePayload(headSHA string) map[string]interface{} {
+	return map[string]interface{}{
+		"action":       "requested",
+		"check_suite":  map[string]interface{}{"head_sha": headSHA},
+		"repository":   map[string]interface{}{"name": "r", "full_name": "o/r", "owner": map[string]interface{}{"login": "o"}},
+		"installation": map[string]interface{}{"id": testInstallationID},
+	}
+}
+
+// writeTestFile writes content to path, relative to dir, creating its parent
+// directories.
+func writeTestFile(t *testing.T, dir string, path string, content string) {
+	path = filepath.Join(dir, path)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCompleteCheckRunSendsAnnotationsInBatches(t *testing.T) {
+	f := newFakeGitHub(t)
+	var updates []*github.UpdateCheckRunOptions
+	f.handle("PATCH /repos/o/r/check-runs/7", func(w http.ResponseWriter, req *http.Request) {
+		opts := &github.UpdateCheckRunOptions{}
+		if err := json.NewDecoder(req.Body).Decode(opts); err != nil {
+			t.Errorf("failed to decode check run update: %s", err)
+		}
+		updates = append(updates, opts)
+		writeTestJSON(w, http.StatusOK, map[string]interface{}{"id": 7})
+	})
+	result := &Result{Title: "buildifier result", Summary: "120 issues found", Conclusion: "failure"}
+	for i := 0; i < 120; i++ {
+		result.Annotations = append(result.Annotations, &Annotation{Path: "BUILD", Line: i + 1, Message: "lint", Severity: "failure"})
+	}
+
+	if _, err := completeCheckRun(context.Background(), setClientURLs(github.NewClient(nil)), "o", "r", 7, result, buildifierCheck); err != nil {
+		t.Fatal(err)
+	}
+	if len(updates) != 3 {
+		t.Fatalf("check run was updated %d times, want 3", len(updates))
+	}
+	line := 1
+	for i, want := range []int{50, 50, 20} {
+		annotations := updates[i].Output.Annotations
+		if len(annotations) != want {
+			t.Errorf("update %d has %d annotations, want %d", i, len(annotations), want)
+			continue
+		}
+		if annotations[0].GetStartLine() != line {
+			t.Errorf("update %d starts at line %d, want %d", i, annotations[0].GetStartLine(), line)
+		}
+		line += want
+	}
+	if updates[0].GetConclusion() != "failure" || updates[0].Output.GetSummary() != result.Summary {
+		t.Errorf("first update has conclusion %q and summary %q, want the result's", updates[0].GetConclusion(), updates[0].Output.GetSummary())
+	}
+}
+
+func TestCreateCheckRunsSkipsDraftPullRequests(t *testing.T) {
+	f := newFakeGitHub(t)
+	serveRepoConfig(f, "skip_drafts: true\n")
+	created := serveCheckRunCreation(t, f, "abc")
+	app := newTestApp(t, f)
+	app.SetDispatcher(&recordingDispatcher{})
+	pr := testPR(1, "open", true, "main", "abc")
+	app.pulls.Track(testInstallationID, testRepo(), pr)
+
+	if err := app.CreateCheckRuns(context.Background(), testInstallationID, testRepo(), "abc"); err != nil {
+		t.Fatal(err)
+	}
+	if runs := created(); len(runs) != 0 {
+		t.Errorf("created %d check runs on a draft pull request", len(runs))
+	}
+
+	pr.Draft = github.Bool(false)
+	err := app.processEvent(context.Background(), &github.PullRequestEvent{
+		Action:       github.String("ready_for_review"),
+		Installation: &github.Installation{ID: github.Int64(testInstallationID)},
+		Repo:         testRepo(),
+		PullRequest:  pr,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(created()) == 0 {
+		t.Errorf("created no check runs when the pull request was marked ready for review")
+	}
+}
+
+func TestRemovingSkipLabelCreatesCheckRuns(t *testing.T) {
+	f := newFakeGitHub(t)
+	serveRepoConfig(f, "skip_labels: [skip-ci]\n")
+	created := serveCheckRunCreation(t, f, "abc")
+	app := newTestApp(t, f)
+	app.SetDispatcher(&recordingDispatcher{})
+	pr := testPR(1, "open", false, "main", "abc")
+	pr.Labels = []*github.Label{{Name: github.String("bug")}}
+	unlabeled := func(label string) *github.PullRequestEvent {
+		return &github.PullRequestEvent{
+			Action:       github.String("unlabeled"),
+			Installation: &github.Installation{ID: github.Int64(testInstallationID)},
+			Repo:         testRepo(),
+			PullRequest:  pr,
+			Label:        &github.Label{Name: github.String(label)},
+		}
+	}
+
+	if err := app.processEvent(context.Background(), unlabeled("docs")); err != nil {
+		t.Fatal(err)
+	}
+	if runs := created(); len(runs) != 0 {
+		t.Errorf("created %d check runs when a label that doesn't skip checks was removed", len(runs))
+	}
+	if err := app.processEvent(context.Background(), unlabeled("skip-ci")); err != nil {
+		t.Fatal(err)
+	}
+	if len(created()) == 0 {
+		t.Errorf("created no check runs when the skip label was removed")
+	}
+}
+
+func TestCreateCheckRunsSkipsChecksWithoutChangedTriggerPaths(t *testing.T) {
+	f := newFakeGitHub(t)
+	serveRepoConfig(f, `checks:
+  test-lint:
+    trigger_paths: ["**/*.go"]
+  test-build:
+    trigger_paths: ["**/BUILD"]
+`)
+	f.handle("GET /repos/o/r/commits/abc", func(w http.ResponseWriter, req *http.Request) {
+		writeTestJSON(w, http.StatusOK, map[string]interface{}{
+			"sha":   "abc",
+			"files": []interface{}{map[string]interface{}{"filename": "cmd/main.go"}},
+		})
+	})
+	created := serveCheckRunCreation(t, f, "abc")
+	registerTestChecker(t, &funcChecker{name: "test-lint"})
+	registerTestChecker(t, &funcChecker{name: "test-build"})
+	app := newTestApp(t, f)
+	d := &recordingDispatcher{}
+	app.SetDispatcher(d)
+
+	if err := app.createCheckRuns(context.Background(), testInstallationID, testRepo(), "abc", []string{"test-lint", "test-build"}); err != nil {
+		t.Fatal(err)
+	}
+	runs := created()
+	if len(runs) != 2 {
+		t.Fatalf("created %d check runs, want 2", len(runs))
+	}
+	if runs[0].Name != "test-lint" || runs[0].GetStatus() != inProgress {
+		t.Errorf("created %s %q, want test-lint to run", runs[0].Name, runs[0].GetStatus())
+	}
+	if runs[1].Name != "test-build" || runs[1].GetStatus() != "completed" || runs[1].GetConclusion() != "neutral" {
+		t.Errorf("created %s %q with conclusion %q, want test-build to be skipped as neutral", runs[1].Name, runs[1].GetStatus(), runs[1].GetConclusion())
+	}
+	if len(d.jobs) != 1 || len(d.jobs[0].Checks) != 1 || d.jobs[0].Checks[0].Name != "test-lint" {
+		t.Errorf("dispatched jobs %+v, want one running only test-lint", d.jobs)
+	}
+	if n := f.count("GET /repos/o/r/commits/abc"); n != 1 {
+		t.Errorf("listed the commit's files %d times, want once for all checks", n)
+	}
+}
+
+func TestHandleWebhookSkipsRedeliveries(t *testing.T) {
+	f := newFakeGitHub(t)
+	f.handle("GET /repos/o/r/commits/abc/check-runs", func(w http.ResponseWriter, req *http.Request) {
+		writeTestJSON(w, http.StatusOK, map[string]interface{}{"total_count": 0, "check_runs": []interface{}{}})
+	})
+	f.handle("GET /repos/o/r/commits/abc/pulls", func(w http.ResponseWriter, req *http.Request) {
+		writeTestJSON(w, http.StatusOK, []interface{}{})
+	})
+	f.handle("POST /repos/o/r/check-runs", func(w http.ResponseWriter, req *http.Request) {
+		writeTestJSON(w, http.StatusCreated, map[string]interface{}{"id": 1, "status": "queued"})
+	})
+	app := newTestApp(t, f)
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		app.HandleWebhook(w, webhookRequest(t, "check_suite", "delivery-1", checkSuitePayload("abc"), testWebhookSecret))
+		if w.Code != http.StatusOK {
+			t.Fatalf("delivery %d: got status %d, want %d: %s", i+1, w.Code, http.StatusOK, w.Body)
+		}
+	}
+	if got := f.count("GET /repos/o/r/commits/abc/check-runs"); got != 1 {
+		t.Errorf("check runs were listed %d times, want once for the first delivery", got)
+	}
+	// Only the first delivery creates check runs, one per check enabled by
+	// default.
+	want := 0
+	for _, name := range registeredChecks() {
+		checker, err := GetChecker(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if enabledByDefault(checker) {
+			want++
+		}
+	}
+	if got := f.count("POST /repos/o/r/check-runs"); got != want {
+		t.Errorf("%d check runs were created, want %d", got, want)
+	}
+
+	// Another delivery of the same event is processed.
+	w := httptest.NewRecorder()
+	app.HandleWebhook(w, webhookRequest(t, "check_suite", "delivery-2", checkSuitePayload("abc"), testWebhookSecret))
+	if got := f.count("GET /repos/o/r/commits/abc/check-runs"); got != 2 {
+		t.Errorf("check runs were listed %d times after a new delivery, want 2", got)
+	}
+	if got := f.count("POST /repos/o/r/check-runs"); got != 2*want {
+		t.Errorf("%d check runs were created after a new delivery, want %d", got, 2*want)
+	}
+}
+
+// useContainerExecutor runs checks in containers for the duration of the
+// test, so that their tools needn't be installed on the host.
+func useContainerExecutor(t *testing.T) {
+	old := CheckExecutor
+	CheckExecutor = &ContainerExecutor{Runtime: "sh", Image: "reviewbot"}
+	t.Cleanup(func() { CheckExecutor = old })
+}
+
+func TestValidateConfig(t *testing.T) {
+	useContainerExecutor(t)
+
+	if err := validateConfig(testPrivateKey(t), []string{testWebhookSecret}, StaticSecretProvider("bb-key")); err != nil {
+		t.Errorf("validateConfig of a valid configuration: %s", err)
+	}
+
+	err := validateConfig(nil, []string{testWebhookSecret, ""}, StaticSecretProvider("bb-key"))
+	if err == nil {
+		t.Fatal("validateConfig of an invalid configuration succeeded")
+	}
+	for _, problem := range []string{"private key is empty", "webhook secret #1 is empty"} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("validateConfig error %q doesn't report %q", err, problem)
+		}
+	}
+
+	for name, key := range map[string][]byte{
+		"not PEM":        []byte("not a key"),
+		"not an RSA key": []byte("-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n"),
+		"truncated":      testPrivateKey(t)[:100],
+	} {
+		err := validateConfig(key, []string{testWebhookSecret}, StaticSecretProvider("bb-key"))
+		if err == nil || !strings.Contains(err.Error(), "private key is invalid") {
+			t.Errorf("validateConfig of a private key that is %s: got %v, want it reported invalid", name, err)
+		}
+	}
+
+	// Checks run on the host need their binaries on PATH.
+	CheckExecutor = LocalExecutor{}
+	t.Setenv("PATH", "")
+	err = validateConfig(testPrivateKey(t), []string{testWebhookSecret}, StaticSecretProvider("bb-key"))
+	if err == nil || !strings.Contains(err.Error(), "is not on PATH") {
+		t.Errorf("validateConfig with an empty PATH: got %v, want a required binary reported missing", err)
+	}
+}
+
+func TestValidatePayloadAcceptsEverySecret(t *testing.T) {
+	app := &GithubApp{webhookSecrets: []string{"current", "previous"}}
+	payload := map[string]interface{}{"zen": "Keep it logically awesome."}
+
+	for _, secret := range []string{"current", "previous"} {
+		if _, err := app.validatePayload(webhookRequest(t, "ping", "delivery", payload, secret)); err != nil {
+			t.Errorf("payload signed with the %s secret was rejected: %s", secret, err)
+		}
+	}
+	if _, err := app.validatePayload(webhookRequest(t, "ping", "delivery", payload, "revoked")); err == nil {
+		t.Errorf("payload signed with a revoked secret was accepted")
+	}
+}
+
+func TestListCheckRunsPaginates(t *testing.T) {
+	f := newFakeGitHub(t)
+	f.handle("GET /repos/o/r/commits/abc/check-runs", func(w http.ResponseWriter, req *http.Request) {
+		runs := []map[string]interface{}{{"id": 1, "name": "buildifier"}, {"id": 2, "name": "bazel"}}
+		if req.URL.Query().Get("page") == "2" {
+			runs = []map[string]interface{}{{"id": 3, "name": "bazel-test"}, {"id": 4, "name": "buildifier"}}
+		} else {
+			next := *req.URL
+			q := next.Query()
+			q.Set("page", "2")
+			next.RawQuery = q.Encode()
+			w.Header().Set("Link", fmt.Sprintf(`<%s%s>; rel="next"`, f.URL, next.RequestURI()))
+		}
+		writeTestJSON(w, http.StatusOK, map[string]interface{}{"total_count": 4, "check_runs": runs})
+	})
+	ghc := setClientURLs(github.NewClient(nil))
+
+	runs, err := listCheckRuns(context.Background(), ghc, testAppID, "o", "r", "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int64{"buildifier": 4, "bazel": 2, "bazel-test": 3}
+	if len(runs) != len(want) {
+		t.Errorf("got %d check runs, want %d", len(runs), len(want))
+	}
+	for name, id := range want {
+		if got := runs[name].GetID(); got != id {
+			t.Errorf("got check run %d for %s, want the latest, %d", got, name, id)
+		}
+	}
+	if got := f.count("GET /repos/o/r/commits/abc/check-runs"); got != 2 {
+		t.Errorf("listed %d pages, want 2", got)
+	}
+}
+
+func TestHandleWebhookAnswersPing(t *testing.T) {
+	f := newFakeGitHub(t)
+	app := newTestApp(t, f)
+
+	payload := map[string]interface{}{
+		"zen":     "Design for failure.",
+		"hook_id": 42,
+		"hook":    map[string]interface{}{"type": "App", "events": []string{"check_run", "check_suite", "pull_request"}},
+	}
+	w := httptest.NewRecorder()
+	app.HandleWebhook(w, webhookRequest(t, "ping", "ping-1", payload, testWebhookSecret))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+	if body := w.Body.String(); !strings.Contains(body, "pong") || !strings.Contains(body, "42") {
+		t.Errorf("got response %q, want a pong for hook 42", body)
+	}
+
+	payload["hook"] = map[string]interface{}{"type": "App", "events": []string{"pull_request"}}
+	w = httptest.NewRecorder()
+	app.HandleWebhook(w, webhookRequest(t, "ping", "ping-2", payload, testWebhookSecret))
+	if body := w.Body.String(); !strings.Contains(body, "warning") || !strings.Contains(body, "check_suite") {
+		t.Errorf("got response %q, want a warning about the missing check_suite event", body)
+	}
+}
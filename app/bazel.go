@@ -0,0 +1,325 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"expvar"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	bazelTestCheck = "bazel-test"
+
+	maxBazelLineLength = 4 * 1024 * 1024
+)
+
+var (
+	diskFullRegex = regexp.MustCompile(`(?i)no space left on device|\bENOSPC\b|disk quota exceeded`)
+	// diskFullErrors counts checks that failed because the host ran out of
+	// disk space, so operators can alert on it.
+	diskFullErrors = expvar.NewInt("disk_full_errors")
+)
+
+var testSummaryRegex = regexp.MustCompile(`^(?P<target>//\S+)\s+(?:\(cached\)\s+)?(?P<status>PASSED|FLAKY|TIMEOUT|FAILED TO BUILD|FAILED|INCOMPLETE|NO STATUS|SKIPPED)\b`)
+
+// testResult is the outcome of a single test target as reported in the bazel
+// test summary.
+type testResult struct {
+	target string
+	status string
+}
+
+// bazelOutput holds everything we extract from the output of a bb invocation.
+type bazelOutput struct {
+	// urls holds every BuildBuddy invocation URL in the order they were
+	// streamed. Sharded or retried invocations emit more than one.
+	urls        []string
+	annotations []*Annotation
+	testResults []*testResult
+}
+
+// runBazel runs `bb <command>` on targets in dir and returns its stdout and
+// stderr. apiKey authenticates with BuildBuddy unless UseBuildBuddy is false.
+func runBazel(ctx context.Context, apiKey string, target *CheckTarget, command string, flags []string, targets []string) (bytes.Buffer, bytes.Buffer, error) {
+	args := []string{command}
+	if UseBuildBuddy {
+		args = append(args, fmt.Sprintf("--remote_header=x-buildbuddy-api-key=%s", apiKey))
+	}
+	args = append(args, CurrentSettings().BazelFlags...)
+	args = append(args, flags...)
+	if l := limiterFrom(ctx); l != nil && l.limits.Jobs > 0 {
+		// After the configured flags, which could set it too.
+		args = append(args, fmt.Sprintf("--jobs=%d", l.limits.Jobs))
+	}
+	args = append(args, "--")
+	args = append(args, targets...)
+	return runCheckCmd(ctx, target, "bb", args...)
+}
+
+// isDiskFull reports whether a command failed because the host ran out of
+// disk space, judging by its error and output.
+func isDiskFull(err error, outputs ...*bytes.Buffer) bool {
+	if err != nil && diskFullRegex.MatchString(err.Error()) {
+		return true
+	}
+	for _, output := range outputs {
+		if diskFullRegex.Match(output.Bytes()) {
+			return true
+		}
+	}
+	return false
+}
+
+// diskFullResult reports a check that failed because of the host rather than
+// the code under test, and raises an operator alert.
+func diskFullResult(ctx context.Context, title string, target *CheckTarget) *Result {
+	diskFullErrors.Add(1)
+	logFrom(ctx).Errorw("ALERT: host out of disk space", "dir", target.Dir)
+	return &Result{
+		Title:      title,
+		Summary:    "build failed: host out of disk space. This is an infrastructure problem, not a problem with your change; re-run the check once space has been freed.",
+		Conclusion: "failure",
+	}
+}
+
+// parseBazelOutput scans the output of a bazel invocation for the BuildBuddy
+// invocation URL, file:line:col diagnostics and test summary lines.
+func parseBazelOutput(ctx context.Context, r io.Reader) *bazelOutput {
+	out := &bazelOutput{
+		annotations: []*Annotation{},
+	}
+	scanner := bufio.NewScanner(r)
+	// Bazel progress lines can be very long; don't let one of them end the
+	// scan before we reach the URLs and summary near the end of the stream.
+	scanner.Buffer(make([]byte, 0, 64*1024), maxBazelLineLength)
+
+	// dedupe
+	m := make(map[string]struct{})
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(cleanLine(scanner.Text()))
+
+		// check url
+		urlIndex := urlRegex.SubexpIndex("url")
+		if matches := urlRegex.FindStringSubmatch(line); len(matches) > 0 {
+			url := matches[urlIndex]
+			if len(out.urls) == 0 || out.urls[len(out.urls)-1] != url {
+				out.urls = append(out.urls, url)
+				logFrom(ctx).Infow("found invocation URL", "url", url)
+			}
+		}
+
+		// check test summary
+		if matches := testSummaryRegex.FindStringSubmatch(line); len(matches) > 0 {
+			out.testResults = append(out.testResults, &testResult{
+				target: matches[testSummaryRegex.SubexpIndex("target")],
+				status: matches[testSummaryRegex.SubexpIndex("status")],
+			})
+			continue
+		}
+
+		// check errors
+		if strings.HasPrefix(line, "ERROR: ") || strings.HasPrefix(line, "INFO: ") || strings.HasPrefix(line, "FAILED: ") {
+			continue
+		}
+		fileIndex := lineCommentRegex.SubexpIndex("file")
+		lineIndex := lineCommentRegex.SubexpIndex("line")
+		commentIndex := lineCommentRegex.SubexpIndex("comment")
+		matches := lineCommentRegex.FindStringSubmatch(line)
+		if len(matches) > 0 {
+			if _, ok := m[line]; ok {
+				continue
+			}
+			file := matches[fileIndex]
+			lineNumStr := matches[lineIndex]
+			lineNum, err := strconv.Atoi(lineNumStr)
+			if err != nil {
+				logFrom(ctx).Warnw("unable to parse line number", "line_number", lineNumStr)
+			}
+			comment := matches[commentIndex]
+			out.annotations = append(out.annotations, &Annotation{
+				Message:  comment,
+				Severity: "failure",
+				Path:     file,
+				Line:     lineNum,
+			})
+			m[line] = struct{}{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		logFrom(ctx).Warnw("failed to read bazel output", "error", err)
+	}
+	return out
+}
+
+// primaryURL returns the URL of the final invocation, which is the one whose
+// results the check reports.
+func (o *bazelOutput) primaryURL() string {
+	if len(o.urls) == 0 {
+		return ""
+	}
+	return o.urls[len(o.urls)-1]
+}
+
+// otherURLsSummary lists the URLs of earlier invocations for the check summary.
+func (o *bazelOutput) otherURLsSummary() string {
+	if len(o.urls) < 2 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("\n\nOther invocations:")
+	for _, url := range o.urls[:len(o.urls)-1] {
+		b.WriteString("\n- ")
+		b.WriteString(url)
+	}
+	return b.String()
+}
+
+// testStatusSeverity maps a bazel test status to an annotation severity.
+// Statuses that fail the check are failures. Flaky tests eventually passed,
+// so they are reported as warnings. An empty string means the status doesn't
+// need an annotation.
+func testStatusSeverity(status string) string {
+	switch status {
+	case "FAILED", "FAILED TO BUILD", "TIMEOUT", "INCOMPLETE":
+		return "failure"
+	case "FLAKY":
+		return "warning"
+	}
+	return ""
+}
+
+// buildFileForTarget returns the path of the BUILD file, relative to dir, that
+// defines the given label.
+func buildFileForTarget(dir string, target string) string {
+	pkg := strings.TrimPrefix(target, "//")
+	if i := strings.Index(pkg, ":"); i >= 0 {
+		pkg = pkg[:i]
+	}
+	for _, name := range []string{"BUILD.bazel", "BUILD"} {
+		path := filepath.Join(pkg, name)
+		if _, err := os.Stat(filepath.Join(dir, path)); err == nil {
+			return path
+		}
+	}
+	return filepath.Join(pkg, "BUILD")
+}
+
+// checkBazelTest runs `bb test //...` and reports failing test targets.
+func checkBazelTest(ctx context.Context, app *GithubApp, target *CheckTarget) (*Result, error) {
+	apiKey, err := app.BuildBuddyAPIKey(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	dir := target.Dir
+	targets, err := bazelTargets(ctx, target, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		return &Result{
+			Title:      "Test result",
+			Summary:    "No tests are affected by this change.",
+			Conclusion: "neutral",
+		}, nil
+	}
+	bep, stdOut, stdErr, err := runBazelWithBEP(ctx, apiKey, target, "test", target.bazelFlags("test"), targets)
+	if isDiskFull(err, &stdOut, &stdErr) {
+		return diskFullResult(ctx, "Test result", target), nil
+	}
+	if stdOut.Len() == 0 {
+		return nil, err
+	}
+
+	res := &Result{
+		Title: "Test result",
+	}
+	out := parseBazelOutput(ctx, &stdOut)
+	retryURLs := retryFailedTests(ctx, apiKey, target, out)
+	annotations := target.Config.filterAnnotations(out.annotations)
+
+	passed, failed, flaky := 0, 0, 0
+	var flakyOnRetry []string
+	for _, t := range out.testResults {
+		severity := testStatusSeverity(t.status)
+		switch {
+		case t.status == "PASSED":
+			passed++
+		case t.status == "FLAKY":
+			flaky++
+		case severity == "failure":
+			failed++
+		}
+		if t.status == "FLAKY" && len(retryURLs) > 0 {
+			flakyOnRetry = append(flakyOnRetry, t.target)
+		}
+		if severity == "" {
+			continue
+		}
+		annotations = append(annotations, &Annotation{
+			Message:   fmt.Sprintf("%s %s", t.target, t.status),
+			Severity:  severity,
+			Path:      buildFileForTarget(dir, t.target),
+			Line:      1,
+			WholeFile: true,
+		})
+	}
+
+	// The diagnostics of tests that passed on a retry don't fail the check.
+	if failed == 0 && (len(out.annotations) == 0 || len(flakyOnRetry) > 0) {
+		res.Summary = fmt.Sprintf("%d tests passed.", passed)
+		res.Conclusion = "success"
+	} else {
+		res.Summary = fmt.Sprintf("%d tests failed, %d passed.", failed, passed)
+		res.Conclusion = "failure"
+	}
+	if flaky > 0 {
+		res.Summary += fmt.Sprintf(" %d tests were flaky.", flaky)
+	}
+	if len(annotations) > 0 {
+		res.Annotations = annotations
+	}
+	res.Summary += out.otherURLsSummary()
+	res.Summary += retriesSummary(retryURLs)
+	res.URL = out.primaryURL()
+	res.Text = testResultsTable(out.testResults, res.URL)
+	enrichResult(ctx, apiKey, res, bep)
+	if target.Config.FlakyIssue {
+		if err := app.fileFlakyTests(ctx, target, flakyOnRetry); err != nil {
+			logFrom(ctx).Warnw("failed to file flaky tests", "error", err)
+		}
+	}
+	return res, nil
+}
+
+// testResultsTable renders per-target test results as a markdown table, with
+// targets that didn't pass listed first. Targets link to their page in the
+// BuildBuddy invocation when invocationURL is set.
+func testResultsTable(results []*testResult, invocationURL string) string {
+	if len(results) == 0 {
+		return ""
+	}
+	sorted := append([]*testResult(nil), results...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].status != "PASSED" && sorted[j].status == "PASSED"
+	})
+	var b strings.Builder
+	b.WriteString("| Target | Status |\n| --- | --- |\n")
+	for _, t := range sorted {
+		target := fmt.Sprintf("`%s`", t.target)
+		if invocationURL != "" {
+			target = fmt.Sprintf("[%s](%s?target=%s)", target, invocationURL, url.QueryEscape(t.target))
+		}
+		fmt.Fprintf(&b, "| %s | %s |\n", target, t.status)
+	}
+	return b.String()
+}
@@ -0,0 +1,242 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func setUseBuildBuddy(t *testing.T, use bool, flags ...string) {
+	oldUse := UseBuildBuddy
+	t.Cleanup(func() { UseBuildBuddy = oldUse })
+	UseBuildBuddy = use
+	setTestSettings(t, func(s *Settings) { s.BazelFlags = flags })
+}
+
+func TestTestResultsTable(t *testing.T) {
+	results := []*testResult{
+		{target: "//app:app_test", status: "PASSED"},
+		{target: "//lib:lib_test", status: "FAILED"},
+		{target: "//lib:flaky_test", status: "FLAKY"},
+	}
+	want := "| Target | Status |\n| --- | --- |\n" +
+		"| [`//lib:lib_test`](https://app.buildbuddy.io/invocation/abc?target=%2F%2Flib%3Alib_test) | FAILED |\n" +
+		"| [`//lib:flaky_test`](https://app.buildbuddy.io/invocation/abc?target=%2F%2Flib%3Aflaky_test) | FLAKY |\n" +
+		"| [`//app:app_test`](https://app.buildbuddy.io/invocation/abc?target=%2F%2Fapp%3Aapp_test) | PASSED |\n"
+	if got := testResultsTable(results, "https://app.buildbuddy.io/invocation/abc"); got != want {
+		t.Errorf("got table\n%s\nwant\n%s", got, want)
+	}
+	if got := testResultsTable(results[:1], ""); got != "| Target | Status |\n| --- | --- |\n| `//app:app_test` | PASSED |\n" {
+		t.Errorf("got table without invocation\n%s", got)
+	}
+	if got := testResultsTable(nil, ""); got != "" {
+		t.Errorf("got table %q without results, want none", got)
+	}
+}
+
+func TestCompletedCheckRunOptionsIncludeText(t *testing.T) {
+	opts := createCompletedUpdateCheckRunOptions(&Result{Title: "bazel-test result", Text: "| Target | Status |"}, bazelTestCheck)
+	if opts.Output.GetText() != "| Target | Status |" {
+		t.Errorf("got output text %q, want the result's", opts.Output.GetText())
+	}
+	opts = createCompletedUpdateCheckRunOptions(&Result{Title: "bazel-test result"}, bazelTestCheck)
+	if opts.Output.Text != nil {
+		t.Errorf("got output text %q for a result without text, want none", opts.Output.GetText())
+	}
+}
+
+func TestRunBazelWithBuildBuddy(t *testing.T) {
+	setUseBuildBuddy(t, true, "--remote_cache=grpc://cache")
+	installFakeTool(t, "bb", `echo bb "$@"`+"\n")
+
+	stdOut, _, err := runBazel(context.Background(), "key", &CheckTarget{Dir: t.TempDir()}, "test", []string{"--config=ci"}, []string{"//..."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.TrimSpace(stdOut.String()), "bb test --remote_header=x-buildbuddy-api-key=key --remote_cache=grpc://cache --config=ci -- //..."; got != want {
+		t.Errorf("ran %q, want %q", got, want)
+	}
+}
+
+func TestRunBazelWithoutBuildBuddy(t *testing.T) {
+	setUseBuildBuddy(t, false, "--remote_cache=grpc://cache")
+	installFakeTool(t, "bazel", `echo bazel "$@"`+"\n")
+	installFakeTool(t, "bb", "exit 1\n")
+
+	stdOut, _, err := runBazel(context.Background(), "", &CheckTarget{Dir: t.TempDir()}, "build", []string{"--config=ci"}, []string{"//..."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.TrimSpace(stdOut.String()), "bazel build --remote_cache=grpc://cache --config=ci -- //..."; got != want {
+		t.Errorf("ran %q, want %q", got, want)
+	}
+}
+
+func TestBuildBuddyAPIKeyWithoutBuildBuddy(t *testing.T) {
+	setUseBuildBuddy(t, false)
+	app := newTestApp(t, newFakeGitHub(t))
+	if key, err := app.BuildBuddyAPIKey(context.Background(), &CheckTarget{FullRepoName: "o/r"}); err != nil || key != "" {
+		t.Errorf("BuildBuddyAPIKey without BuildBuddy = %q, %v, want none", key, err)
+	}
+}
+
+func TestValidateConfigWithoutBuildBuddy(t *testing.T) {
+	setUseBuildBuddy(t, false)
+	t.Setenv("PATH", "")
+	err := validateConfig(testPrivateKey(t), []string{testWebhookSecret}, nil)
+	if err == nil {
+		t.Fatal("validateConfig without any binaries succeeded")
+	}
+	if strings.Contains(err.Error(), "requires a BuildBuddy API key") {
+		t.Errorf("validateConfig error %q requires an API key without BuildBuddy", err)
+	}
+	if !strings.Contains(err.Error(), `binary "bazel" required by check`) || strings.Contains(err.Error(), `binary "bb"`) {
+		t.Errorf("validateConfig error %q, want bazel required instead of bb", err)
+	}
+}
+
+func TestParseBazelOutputTestResults(t *testing.T) {
+	output := strings.Join([]string{
+		"INFO: Build completed, 1 test FAILED, 4 total actions",
+		"foo/foo_test.go:12:3: expected 1, got 2",
+		"//foo:foo_test                                                          FAILED in 0.4s",
+		"//bar:bar_test                                                 (cached) PASSED in 0.1s",
+		"//baz:baz_test                                                           FLAKY, failed in 1 out of 2 in 3.1s",
+		"//qux:qux_test                                                 FAILED TO BUILD",
+		"",
+		"Executed 3 out of 4 tests: 1 test passes, 2 fail locally.",
+	}, "\n")
+	out := parseBazelOutput(context.Background(), strings.NewReader(output))
+
+	want := []testResult{
+		{target: "//foo:foo_test", status: "FAILED"},
+		{target: "//bar:bar_test", status: "PASSED"},
+		{target: "//baz:baz_test", status: "FLAKY"},
+		{target: "//qux:qux_test", status: "FAILED TO BUILD"},
+	}
+	if len(out.testResults) != len(want) {
+		t.Fatalf("got %d test results, want %d", len(out.testResults), len(want))
+	}
+	for i, r := range out.testResults {
+		if *r != want[i] {
+			t.Errorf("test result %d: got %+v, want %+v", i, *r, want[i])
+		}
+	}
+
+	if len(out.annotations) != 1 {
+		t.Fatalf("got %d annotations, want 1", len(out.annotations))
+	}
+	if a := out.annotations[0]; a.Path != "foo/foo_test.go" || a.Line != 12 || a.Message != " expected 1, got 2" {
+		t.Errorf("got annotation %+v", a)
+	}
+}
+
+func TestTestStatusSeverity(t *testing.T) {
+	for status, want := range map[string]string{
+		"PASSED":          "",
+		"NO STATUS":       "",
+		"SKIPPED":         "",
+		"FAILED":          "failure",
+		"FAILED TO BUILD": "failure",
+		"TIMEOUT":         "failure",
+		"INCOMPLETE":      "failure",
+		"FLAKY":           "warning",
+	} {
+		if got := testStatusSeverity(status); got != want {
+			t.Errorf("testStatusSeverity(%q) = %q, want %q", status, got, want)
+		}
+	}
+}
+
+func TestBuildFileForTarget(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "foo/BUILD.bazel", "")
+	writeTestFile(t, dir, "bar/BUILD", "")
+
+	for target, want := range map[string]string{
+		"//foo:foo_test": "foo/BUILD.bazel",
+		"//bar:bar_test": "bar/BUILD",
+		"//baz":          "baz/BUILD",
+	} {
+		if got := buildFileForTarget(dir, target); got != want {
+			t.Errorf("buildFileForTarget(%q) = %q, want %q", target, got, want)
+		}
+	}
+}
+
+func TestParseBazelOutputURLs(t *testing.T) {
+	output := strings.Join([]string{
+		"INFO: Streaming build results to: https://app.buildbuddy.io/invocation/first",
+		"INFO: Streaming build results to: https://app.buildbuddy.io/invocation/first",
+		"INFO: Streaming build results to: https://app.buildbuddy.io/invocation/second",
+		"INFO: Streaming build results to: https://app.buildbuddy.io/invocation/final",
+	}, "\n")
+	out := parseBazelOutput(context.Background(), strings.NewReader(output))
+
+	if got, want := out.primaryURL(), "https://app.buildbuddy.io/invocation/final"; got != want {
+		t.Errorf("primaryURL() = %q, want %q", got, want)
+	}
+	want := "\n\nOther invocations:\n- https://app.buildbuddy.io/invocation/first\n- https://app.buildbuddy.io/invocation/second"
+	if got := out.otherURLsSummary(); got != want {
+		t.Errorf("otherURLsSummary() = %q, want %q", got, want)
+	}
+}
+
+func TestParseBazelOutputSingleURL(t *testing.T) {
+	out := parseBazelOutput(context.Background(), strings.NewReader("INFO: Streaming build results to: https://app.buildbuddy.io/invocation/only\n"))
+	if got, want := out.primaryURL(), "https://app.buildbuddy.io/invocation/only"; got != want {
+		t.Errorf("primaryURL() = %q, want %q", got, want)
+	}
+	if got := out.otherURLsSummary(); got != "" {
+		t.Errorf("otherURLsSummary() = %q, want nothing", got)
+	}
+}
+
+func TestParseBazelOutputStripsColors(t *testing.T) {
+	output := strings.Join([]string{
+		"\x1b[32mINFO: \x1b[0mStreaming build results to: https://app.buildbuddy.io/invocation/abc\x1b[0m",
+		"\x1b[35mfoo/foo.go\x1b[0m:4:2: \x1b[1mundefined: bar\x1b[0m",
+		"\x1b[31m//foo:foo_test\x1b[0m                                                   \x1b[31m\x1b[1mFAILED\x1b[0m in 0.4s",
+	}, "\n")
+	out := parseBazelOutput(context.Background(), strings.NewReader(output))
+
+	if got, want := out.primaryURL(), "https://app.buildbuddy.io/invocation/abc"; got != want {
+		t.Errorf("primaryURL() = %q, want %q", got, want)
+	}
+	if len(out.annotations) != 1 {
+		t.Fatalf("got %d annotations, want 1", len(out.annotations))
+	}
+	if a := out.annotations[0]; a.Path != "foo/foo.go" || a.Line != 4 || a.Message != " undefined: bar" {
+		t.Errorf("got annotation %+v", a)
+	}
+	if len(out.testResults) != 1 || out.testResults[0].status != "FAILED" {
+		t.Errorf("got test results %+v, want //foo:foo_test FAILED", out.testResults)
+	}
+}
+
+func TestIsDiskFull(t *testing.T) {
+	for _, tc := range []struct {
+		err    error
+		output string
+		want   bool
+	}{
+		{nil, "ERROR: /tmp/out/foo.o: No space left on device", true},
+		{errors.New("write /tmp/foo: no space left on device"), "", true},
+		{nil, "java.io.IOException: ENOSPC", true},
+		{nil, "Disk quota exceeded", true},
+		{errors.New("exit status 1"), "ERROR: foo/BUILD:3:1: compilation failed", false},
+	} {
+		if got := isDiskFull(tc.err, bytes.NewBufferString(tc.output)); got != tc.want {
+			t.Errorf("isDiskFull(%v, %q) = %t, want %t", tc.err, tc.output, got, tc.want)
+		}
+	}
+}
+
+func TestDiskFullResult(t *testing.T) {
+	res := diskFullResult(context.Background(), "Build result", &CheckTarget{Dir: t.TempDir()})
+	if res.Conclusion != "failure" || !strings.Contains(res.Summary, "out of disk space") {
+		t.Errorf("got result %+v, want a failure blaming the host", res)
+	}
+}
@@ -0,0 +1,297 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+var (
+	// BazelWorkspaceDir is where persistent checkouts are kept for jobs with
+	// checks that run bazel, each with an output base of its own, so that the
+	// bazel server and its caches survive between check runs. Checkouts are
+	// updated in place rather than cloned, so that bazel only reanalyzes what
+	// changed. Empty clones into a fresh workspace every time.
+	BazelWorkspaceDir = ""
+)
+
+// expungedFile is the file in a persistent workspace whose modification time
+// is when its output base was last expunged.
+const expungedFile = "expunged"
+
+// bazelWorkspaces tracks the persistent workspaces in use.
+var bazelWorkspaces = struct {
+	mu   sync.Mutex
+	busy map[string]bool
+	// evictMu serializes enforcing BazelWorkspaceBudget.
+	evictMu sync.Mutex
+}{
+	busy: make(map[string]bool),
+}
+
+// bazelWorkspace is a persistent workspace in BazelWorkspaceDir. Each
+// repository has as many as it had jobs running at the same time.
+type bazelWorkspace struct {
+	dir string
+}
+
+// src is the checkout of the workspace.
+func (w *bazelWorkspace) src() string {
+	return filepath.Join(w.dir, "src")
+}
+
+// outputBase is the bazel output base of the workspace.
+func (w *bazelWorkspace) outputBase() string {
+	return filepath.Join(w.dir, "output_base")
+}
+
+// bazel runs the bazel command arg in the workspace.
+func (w *bazelWorkspace) bazel(ctx context.Context, arg ...string) error {
+	bazel, err := bazelTool(ctx)
+	if err != nil {
+		return err
+	}
+	_, _, err = runCmdInDir(ctx, w.src(), bazel, append([]string{"--output_base=" + w.outputBase()}, arg...)...)
+	return err
+}
+
+// usesBazel reports whether any of checks runs bazel.
+func usesBazel(checks []*JobCheck) bool {
+	for _, check := range checks {
+		checker, err := GetChecker(check.Name)
+		if err != nil {
+			continue
+		}
+		c, ok := checker.(CheckerWithRequirements)
+		if !ok {
+			continue
+		}
+		for _, b := range c.Requirements().Binaries {
+			if isBazelTool(b) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isBazelTool reports whether toolName is bazel or a wrapper of it.
+func isBazelTool(toolName string) bool {
+	switch filepath.Base(toolName) {
+	case "bb", "bazel", "bazelisk":
+		return true
+	}
+	return false
+}
+
+// acquireBazelWorkspace returns a persistent workspace of fullRepoName that
+// isn't in use, creating one if they all are. It must be released with
+// release.
+func acquireBazelWorkspace(fullRepoName string) (*bazelWorkspace, error) {
+	bazelWorkspaces.mu.Lock()
+	defer bazelWorkspaces.mu.Unlock()
+	parent := filepath.Join(BazelWorkspaceDir, filepath.FromSlash(fullRepoName))
+	for i := 0; ; i++ {
+		dir := filepath.Join(parent, strconv.Itoa(i))
+		if bazelWorkspaces.busy[dir] {
+			continue
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create bazel workspace: %s", err)
+		}
+		bazelWorkspaces.busy[dir] = true
+		return &bazelWorkspace{dir: dir}, nil
+	}
+}
+
+// checkout checks ref out in the workspace with the token of a job. An
+// existing checkout is updated in place and cleaned of untracked files.
+func (w *bazelWorkspace) checkout(ctx context.Context, token string, fullRepoName string, ref GitRef) error {
+	url, auth := repoURL(fullRepoName), tokenAuth(token)
+	src := w.src()
+	if _, err := os.Stat(filepath.Join(src, ".git")); os.IsNotExist(err) {
+		if err := os.RemoveAll(src); err != nil {
+			return err
+		}
+		// The checkout is kept and updated in place, which can't deepen a
+		// shallow clone.
+		ref.history = true
+		_, err := cloneRepoFromURL(ctx, url, auth, fullRepoName, ref, src, pullRequestRefSpec)
+		return err
+	}
+	if err := updateCheckout(ctx, url, auth, fullRepoName, ref, src, pullRequestRefSpec); err != nil {
+		return err
+	}
+	return fetchLFSObjects(ctx, src, auth)
+}
+
+// updateCheckout checks ref out in the existing clone in dir, fetching what
+// it misses, and removes untracked and ignored files, so that the checkout
+// matches a fresh clone. Files that didn't change are left alone.
+func updateCheckout(ctx context.Context, url string, auth transport.AuthMethod, fullRepoName string, ref GitRef, dir string, pullRefSpec config.RefSpec) error {
+	r, err := git.PlainOpen(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open checkout %q: %s", dir, err)
+	}
+	if RepoCacheDir != "" {
+		// Clones from the mirror share its objects.
+		mirror, err := updateMirror(ctx, url, auth, fullRepoName, pullRefSpec)
+		if err != nil {
+			return err
+		}
+		if err := shareMirror(r, dir, mirror); err != nil {
+			return err
+		}
+	} else {
+		remote := git.NewRemote(r.Storer, &config.RemoteConfig{Name: git.DefaultRemoteName, URLs: []string{url}})
+		err := fetch(ctx, remote, &git.FetchOptions{
+			RefSpecs: []config.RefSpec{"+refs/heads/*:refs/remotes/origin/*", pullRefSpec},
+			Auth:     auth,
+			Tags:     git.NoTags,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to fetch: %s", err)
+		}
+	}
+	if err := checkoutRef(r, ref); err != nil {
+		return err
+	}
+	if err := runGit(dir, "clean", "-ffdx"); err != nil {
+		return fmt.Errorf("failed to clean checkout: %s", err)
+	}
+	return nil
+}
+
+// maybeExpunge cleans the output base of the workspace with bazel clean
+// --expunge if it wasn't for BazelExpungeInterval. The first run only
+// starts the interval.
+func (w *bazelWorkspace) maybeExpunge(ctx context.Context) {
+	interval := CurrentSettings().BazelExpungeInterval
+	if interval <= 0 {
+		return
+	}
+	marker := filepath.Join(w.dir, expungedFile)
+	info, err := os.Stat(marker)
+	if err == nil && time.Since(info.ModTime()) < interval {
+		return
+	}
+	if err == nil {
+		logFrom(ctx).Infow("expunging bazel output base", "output_base", w.outputBase())
+		if err := w.bazel(ctx, "clean", "--expunge"); err != nil {
+			logFrom(ctx).Warnw("failed to expunge bazel output base", "output_base", w.outputBase(), "error", err)
+			return
+		}
+	}
+	if err := os.WriteFile(marker, nil, 0644); err != nil {
+		logFrom(ctx).Warnw("failed to mark bazel output base expunged", "error", err)
+	}
+}
+
+// release returns the workspace for the next job of its repository, and
+// removes least recently used workspaces while BazelWorkspaceBudget is
+// exceeded.
+func (w *bazelWorkspace) release(ctx context.Context) {
+	// The modification time of a workspace is when it was last used.
+	now := time.Now()
+	if err := os.Chtimes(w.dir, now, now); err != nil {
+		logFrom(ctx).Warnw("failed to touch bazel workspace", "dir", w.dir, "error", err)
+	}
+	bazelWorkspaces.mu.Lock()
+	delete(bazelWorkspaces.busy, w.dir)
+	bazelWorkspaces.mu.Unlock()
+	if err := enforceBazelWorkspaceBudget(ctx); err != nil {
+		logFrom(ctx).Warnw("failed to enforce bazel workspace budget", "error", err)
+	}
+}
+
+// enforceBazelWorkspaceBudget removes the least recently used workspaces
+// that aren't in use until they take up less than BazelWorkspaceBudget.
+func enforceBazelWorkspaceBudget(ctx context.Context) error {
+	budget := CurrentSettings().BazelWorkspaceBudget
+	if budget <= 0 {
+		return nil
+	}
+	bazelWorkspaces.evictMu.Lock()
+	defer bazelWorkspaces.evictMu.Unlock()
+	usage, err := dirSize(BazelWorkspaceDir)
+	if err != nil {
+		return err
+	}
+	if usage < budget {
+		return nil
+	}
+	// Workspaces are BazelWorkspaceDir/{owner}/{repo}/{n}.
+	dirs, err := filepath.Glob(filepath.Join(BazelWorkspaceDir, "*", "*", "*"))
+	if err != nil {
+		return err
+	}
+	used := make(map[string]time.Time)
+	for _, dir := range dirs {
+		info, err := os.Stat(dir)
+		if err != nil {
+			continue
+		}
+		used[dir] = info.ModTime()
+	}
+	sort.Slice(dirs, func(i, j int) bool { return used[dirs[i]].Before(used[dirs[j]]) })
+	for _, dir := range dirs {
+		if usage < budget {
+			break
+		}
+		bazelWorkspaces.mu.Lock()
+		busy := bazelWorkspaces.busy[dir]
+		if !busy {
+			// Keep the workspace from being acquired while it's removed.
+			bazelWorkspaces.busy[dir] = true
+		}
+		bazelWorkspaces.mu.Unlock()
+		if busy {
+			continue
+		}
+		size, err := dirSize(dir)
+		if err == nil {
+			err = removeBazelWorkspace(ctx, &bazelWorkspace{dir: dir})
+		}
+		bazelWorkspaces.mu.Lock()
+		delete(bazelWorkspaces.busy, dir)
+		bazelWorkspaces.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		logFrom(ctx).Infow("removed bazel workspace", "dir", dir, "size", size)
+		usage -= size
+	}
+	return nil
+}
+
+// removeBazelWorkspace stops the bazel server of a workspace and removes it.
+func removeBazelWorkspace(ctx context.Context, w *bazelWorkspace) error {
+	if _, err := os.Stat(w.outputBase()); err == nil {
+		if err := w.bazel(ctx, "shutdown"); err != nil {
+			logFrom(ctx).Warnw("failed to shut down bazel server", "output_base", w.outputBase(), "error", err)
+		}
+	}
+	return forceRemoveAll(w.dir)
+}
+
+// forceRemoveAll is like os.RemoveAll, but also removes the contents of
+// read-only directories, which bazel makes of its output base.
+func forceRemoveAll(dir string) error {
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() {
+			os.Chmod(path, 0755)
+		}
+		return nil
+	})
+	return os.RemoveAll(dir)
+}
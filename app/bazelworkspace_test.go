@@ -0,0 +1,197 @@
+package app
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func setBazelWorkspaceDir(t *testing.T, budget int64) string {
+	oldDir := BazelWorkspaceDir
+	t.Cleanup(func() { BazelWorkspaceDir = oldDir })
+	BazelWorkspaceDir = t.TempDir()
+	setTestSettings(t, func(s *Settings) { s.BazelWorkspaceBudget = budget })
+	return BazelWorkspaceDir
+}
+
+func TestUsesBazel(t *testing.T) {
+	registerTestChecker(t, &funcChecker{name: "test-bazel", requirements: CheckRequirements{Binaries: []string{"/usr/bin/bazelisk"}}})
+	registerTestChecker(t, &funcChecker{name: "test-lint", requirements: CheckRequirements{Binaries: []string{"golangci-lint"}}})
+
+	if usesBazel([]*JobCheck{{Name: "test-lint"}, {Name: "unknown"}}) {
+		t.Errorf("checks without bazel use bazel")
+	}
+	if !usesBazel([]*JobCheck{{Name: "test-lint"}, {Name: "test-bazel"}}) {
+		t.Errorf("checks with bazelisk don't use bazel")
+	}
+}
+
+func TestAcquireBazelWorkspace(t *testing.T) {
+	root := setBazelWorkspaceDir(t, 0)
+	ctx := context.Background()
+
+	a, err := acquireBazelWorkspace("o/r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := acquireBazelWorkspace("o/r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.dir != filepath.Join(root, "o", "r", "0") || b.dir != filepath.Join(root, "o", "r", "1") {
+		t.Errorf("got workspaces %s and %s, want one per concurrent job", a.dir, b.dir)
+	}
+	b.release(ctx)
+	a.release(ctx)
+	c, err := acquireBazelWorkspace("o/r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.release(ctx)
+	if c.dir != a.dir {
+		t.Errorf("got workspace %s after releasing, want %s to be reused", c.dir, a.dir)
+	}
+	if c.src() != filepath.Join(a.dir, "src") || c.outputBase() != filepath.Join(a.dir, "output_base") {
+		t.Errorf("got checkout %s and output base %s, want them in the workspace", c.src(), c.outputBase())
+	}
+}
+
+func TestBazelWorkspaceCheckout(t *testing.T) {
+	setBazelWorkspaceDir(t, 0)
+	src := t.TempDir()
+	testGit(t, src, "init", "-q", "-b", "main")
+	first := commitTestFile(t, src, "BUILD", "# first\n")
+	f := newFakeGitHub(t)
+	serveTestRepo(t, f, src, nil)
+	ctx := context.Background()
+	ws, err := acquireBazelWorkspace("o/r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.release(ctx)
+
+	if err := ws.checkout(ctx, "job-token", "o/r", GitRef{hash: first}); err != nil {
+		t.Fatal(err)
+	}
+	if head := testGit(t, ws.src(), "rev-parse", "HEAD"); head != first {
+		t.Fatalf("checked out %s, want %s", head, first)
+	}
+	writeTestFile(t, ws.src(), "bazel-out/leftover", "")
+
+	second := commitTestFile(t, src, "BUILD", "# second\n")
+	serveTestRepo(t, f, src, nil)
+	if err := ws.checkout(ctx, "job-token", "o/r", GitRef{hash: second}); err != nil {
+		t.Fatal(err)
+	}
+	if head := testGit(t, ws.src(), "rev-parse", "HEAD"); head != second {
+		t.Errorf("checked out %s, want the checkout updated to %s", head, second)
+	}
+	if b, err := os.ReadFile(filepath.Join(ws.src(), "BUILD")); err != nil || string(b) != "# second\n" {
+		t.Errorf("got BUILD %q, %v, want the second commit's", b, err)
+	}
+	if _, err := os.Stat(filepath.Join(ws.src(), "bazel-out")); !os.IsNotExist(err) {
+		t.Errorf("untracked files of the previous run weren't removed: %v", err)
+	}
+}
+
+func TestMaybeExpunge(t *testing.T) {
+	setTestSettings(t, func(s *Settings) { s.BazelExpungeInterval = time.Hour })
+	log := filepath.Join(t.TempDir(), "bb.log")
+	installFakeTool(t, "bb", `echo "$@" >> `+log+"\n")
+	ws := &bazelWorkspace{dir: t.TempDir()}
+	os.Mkdir(ws.src(), 0755)
+	ctx := context.Background()
+
+	// The first run starts the interval.
+	ws.maybeExpunge(ctx)
+	marker := filepath.Join(ws.dir, expungedFile)
+	if _, err := os.Stat(marker); err != nil {
+		t.Fatalf("first run didn't start the interval: %s", err)
+	}
+	ws.maybeExpunge(ctx)
+	if _, err := os.Stat(log); !os.IsNotExist(err) {
+		t.Errorf("expunged within the interval")
+	}
+
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(marker, old, old)
+	ws.maybeExpunge(ctx)
+	b, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "--output_base=" + ws.outputBase() + " clean --expunge\n"; string(b) != want {
+		t.Errorf("ran bb %q, want %q", b, want)
+	}
+	if info, err := os.Stat(marker); err != nil || time.Since(info.ModTime()) > time.Hour {
+		t.Errorf("expunging didn't restart the interval")
+	}
+}
+
+func TestEnforceBazelWorkspaceBudget(t *testing.T) {
+	root := setBazelWorkspaceDir(t, 250)
+	installFakeTool(t, "bb", "exit 0\n")
+	ctx := context.Background()
+	var workspaces []string
+	for i, name := range []string{"o/a/0", "o/b/0", "o/b/1"} {
+		dir := filepath.Join(root, filepath.FromSlash(name))
+		writeTestFile(t, dir, "src/data", strings.Repeat("x", 100))
+		// o/a/0 was used least recently.
+		used := time.Now().Add(time.Duration(i-3) * time.Hour)
+		os.Chtimes(dir, used, used)
+		workspaces = append(workspaces, dir)
+	}
+	// A workspace in use is never removed.
+	bazelWorkspaces.mu.Lock()
+	bazelWorkspaces.busy[workspaces[0]] = true
+	bazelWorkspaces.mu.Unlock()
+	defer func() {
+		bazelWorkspaces.mu.Lock()
+		delete(bazelWorkspaces.busy, workspaces[0])
+		bazelWorkspaces.mu.Unlock()
+	}()
+
+	if err := enforceBazelWorkspaceBudget(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []bool{true, false, true} {
+		if _, err := os.Stat(workspaces[i]); (err == nil) != want {
+			t.Errorf("%s exists: %v, want %v", workspaces[i], err == nil, want)
+		}
+	}
+}
+
+func TestForceRemoveAll(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "output_base")
+	writeTestFile(t, dir, "external/repo/file", "")
+	os.Chmod(filepath.Join(dir, "external", "repo"), 0555)
+	if err := forceRemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("%s wasn't removed: %v", dir, err)
+	}
+}
+
+func TestRunCheckCmdPassesOutputBase(t *testing.T) {
+	installFakeTool(t, "bb", `echo "$@"`+"\n")
+	target := &CheckTarget{Dir: t.TempDir(), outputBase: "/ws/output_base"}
+
+	stdOut, _, err := runCheckCmd(context.Background(), target, "bb", "build", "//...")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(stdOut.String()); got != "--output_base=/ws/output_base build //..." {
+		t.Errorf("ran bazel with %q, want the output base as its startup option", got)
+	}
+	stdOut, _, err = runCheckCmd(context.Background(), target, "echo", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(stdOut.String()); got != "hello" {
+		t.Errorf("ran echo with %q, want no output base", got)
+	}
+}
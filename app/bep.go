@@ -0,0 +1,335 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// bepEvent is the subset of a Build Event Protocol event, as written by
+// --build_event_json_file, that we report on.
+type bepEvent struct {
+	ID struct {
+		TargetConfigured *struct {
+			Label string `json:"label"`
+		} `json:"targetConfigured"`
+		TargetCompleted *struct {
+			Label string `json:"label"`
+		} `json:"targetCompleted"`
+		ActionCompleted *struct {
+			Label string `json:"label"`
+		} `json:"actionCompleted"`
+		TestResult *struct {
+			Label string `json:"label"`
+		} `json:"testResult"`
+	} `json:"id"`
+	Progress *struct {
+		Stdout string `json:"stdout"`
+		Stderr string `json:"stderr"`
+	} `json:"progress"`
+	Completed *struct {
+		Success bool `json:"success"`
+	} `json:"completed"`
+	Aborted *struct {
+		Reason      string `json:"reason"`
+		Description string `json:"description"`
+	} `json:"aborted"`
+	Action *struct {
+		Success  bool   `json:"success"`
+		Label    string `json:"label"`
+		Type     string `json:"type"`
+		ExitCode int    `json:"exitCode"`
+		Stderr   *struct {
+			URI string `json:"uri"`
+		} `json:"stderr"`
+	} `json:"action"`
+	Finished *struct {
+		OverallSuccess bool `json:"overallSuccess"`
+		ExitCode       *struct {
+			Name string `json:"name"`
+			Code int    `json:"code"`
+		} `json:"exitCode"`
+	} `json:"finished"`
+	TestResult *struct {
+		Status           string `json:"status"`
+		TestActionOutput []struct {
+			Name string `json:"name"`
+			URI  string `json:"uri"`
+		} `json:"testActionOutput"`
+	} `json:"testResult"`
+	BuildMetrics *struct {
+		ActionSummary struct {
+			ActionsExecuted protoInt64 `json:"actionsExecuted"`
+			RunnerCount     []struct {
+				Name  string     `json:"name"`
+				Count protoInt64 `json:"count"`
+			} `json:"runnerCount"`
+		} `json:"actionSummary"`
+	} `json:"buildMetrics"`
+}
+
+// bepActionFailure is an action that failed during the build.
+type bepActionFailure struct {
+	label    string
+	mnemonic string
+	exitCode int
+	stderr   string
+}
+
+// bepOutput is what we extract from the build event stream of an invocation.
+type bepOutput struct {
+	*bazelOutput
+	// finished is set if the stream reached the end of the build.
+	finished bool
+	success  bool
+	exitName string
+	// failedTargets are the targets that failed to build or were aborted, in
+	// the order they were reported.
+	failedTargets  []string
+	failedActions  []*bepActionFailure
+	abortedReasons map[string]string
+	// progress is the output bazel printed during the build.
+	progress string
+	// executedActions and cacheHits are the number of actions bazel needed
+	// and the number of them served by a cache.
+	executedActions int64
+	cacheHits       int64
+	// testLogs are the URIs of the logs of the last attempt of each test,
+	// keyed by label, and testStatus its status.
+	testLogs   map[string]string
+	testStatus map[string]string
+	tests      []string
+}
+
+// maxFailureLogLines is the number of lines of each failed action's stderr
+// included in the check run output.
+const maxFailureLogLines = 200
+
+// bepDir creates a directory in the checkout for the build event file of a
+// command run by a check, and returns its path relative to the checkout,
+// which is also valid inside sandbox containers. Every run gets a directory
+// of its own, as checks running the same command, like the cells of a
+// matrix, share the checkout.
+func bepDir(target *CheckTarget) (string, error) {
+	dir, err := os.MkdirTemp(target.Dir, ".reviewbot-bep-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create build event directory: %s", err)
+	}
+	return filepath.Base(dir), nil
+}
+
+// readFileURI returns the contents of a file:// URI. Outputs in the remote
+// cache, with bytestream:// URIs, aren't fetched.
+func readFileURI(uri string) (string, bool) {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return "", false
+	}
+	b, err := os.ReadFile(u.Path)
+	if err != nil {
+		return "", false
+	}
+	return string(b), true
+}
+
+// parseBEP reads the build event JSON file at path. Diagnostics and
+// invocation URLs are parsed from the progress output and the stderr of
+// failed actions with parseBazelOutput.
+func parseBEP(ctx context.Context, path string) (*bepOutput, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	out := &bepOutput{
+		abortedReasons: make(map[string]string),
+		testLogs:       make(map[string]string),
+		testStatus:     make(map[string]string),
+	}
+	var progress strings.Builder
+	failed := make(map[string]bool)
+	addFailed := func(label string) {
+		if label != "" && !failed[label] {
+			failed[label] = true
+			out.failedTargets = append(out.failedTargets, label)
+		}
+	}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxBazelLineLength)
+	for scanner.Scan() {
+		event := &bepEvent{}
+		if err := json.Unmarshal(scanner.Bytes(), event); err != nil {
+			return nil, fmt.Errorf("failed to parse build event: %s", err)
+		}
+		switch {
+		case event.Progress != nil:
+			progress.WriteString(event.Progress.Stderr)
+			progress.WriteString(event.Progress.Stdout)
+		case event.Action != nil && !event.Action.Success:
+			a := &bepActionFailure{
+				label:    event.Action.Label,
+				mnemonic: event.Action.Type,
+				exitCode: event.Action.ExitCode,
+			}
+			if event.Action.Stderr != nil {
+				a.stderr, _ = readFileURI(event.Action.Stderr.URI)
+			}
+			out.failedActions = append(out.failedActions, a)
+			progress.WriteString(a.stderr)
+		case event.ID.TargetCompleted != nil && event.Completed != nil && !event.Completed.Success:
+			addFailed(event.ID.TargetCompleted.Label)
+		case event.ID.TargetCompleted != nil && event.Aborted != nil:
+			label := event.ID.TargetCompleted.Label
+			addFailed(label)
+			out.abortedReasons[label] = strings.TrimSpace(event.Aborted.Reason + " " + event.Aborted.Description)
+		case event.ID.TestResult != nil && event.TestResult != nil:
+			label := event.ID.TestResult.Label
+			if _, ok := out.testStatus[label]; !ok {
+				out.tests = append(out.tests, label)
+			}
+			out.testStatus[label] = event.TestResult.Status
+			for _, o := range event.TestResult.TestActionOutput {
+				if o.Name == "test.log" {
+					out.testLogs[label] = o.URI
+				}
+			}
+		case event.BuildMetrics != nil:
+			summary := event.BuildMetrics.ActionSummary
+			out.executedActions = int64(summary.ActionsExecuted)
+			for _, runner := range summary.RunnerCount {
+				if strings.HasSuffix(runner.Name, "cache hit") {
+					out.cacheHits += int64(runner.Count)
+				}
+			}
+		case event.Finished != nil:
+			out.finished = true
+			out.success = event.Finished.OverallSuccess
+			if code := event.Finished.ExitCode; code != nil {
+				out.success = code.Code == 0
+				out.exitName = code.Name
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read build events: %s", err)
+	}
+	out.progress = progress.String()
+	out.bazelOutput = parseBazelOutput(ctx, strings.NewReader(out.progress))
+	return out, nil
+}
+
+// failedTargetsTable renders the failed targets of a build, and the actions
+// that failed them, as a markdown table. Targets link to their page in the
+// BuildBuddy invocation when invocationURL is set.
+func (o *bepOutput) failedTargetsTable(invocationURL string) string {
+	if len(o.failedTargets) == 0 {
+		return ""
+	}
+	actions := make(map[string][]string)
+	for _, a := range o.failedActions {
+		actions[a.label] = append(actions[a.label], fmt.Sprintf("%s (exit code %d)", a.mnemonic, a.exitCode))
+	}
+	var b strings.Builder
+	b.WriteString("| Target | Failure |\n| --- | --- |\n")
+	for _, label := range o.failedTargets {
+		target := fmt.Sprintf("`%s`", label)
+		if invocationURL != "" {
+			target = fmt.Sprintf("[%s](%s?target=%s)", target, invocationURL, url.QueryEscape(label))
+		}
+		failure := strings.Join(actions[label], ", ")
+		if reason, ok := o.abortedReasons[label]; ok {
+			failure = "aborted: " + reason
+		}
+		if failure == "" {
+			failure = "failed to build"
+		}
+		fmt.Fprintf(&b, "| %s | %s |\n", target, strings.ReplaceAll(failure, "|", `\|`))
+	}
+	return b.String()
+}
+
+// failedTestLabels returns the tests whose last attempt didn't pass and
+// whose log is known, in the order they were reported.
+func (o *bepOutput) failedTestLabels() []string {
+	var labels []string
+	for _, label := range o.tests {
+		if o.testStatus[label] != "PASSED" && o.testLogs[label] != "" {
+			labels = append(labels, label)
+		}
+	}
+	return labels
+}
+
+// failureLogs renders the stderr of failed actions as markdown, up to max
+// bytes. If bazel didn't make the stderr of any of them available, e.g. for
+// remotely executed actions, the end of the build's output is used instead.
+// It is what developers need for failures without file:line diagnostics, like
+// link errors.
+func (o *bepOutput) failureLogs(max int) string {
+	var sections []string
+	for _, a := range o.failedActions {
+		if strings.TrimSpace(a.stderr) == "" {
+			continue
+		}
+		sections = append(sections, fmt.Sprintf("#### `%s` %s\n%s", a.label, a.mnemonic, logBlock(a.stderr)))
+	}
+	if len(sections) == 0 && strings.TrimSpace(o.progress) != "" {
+		sections = append(sections, "#### Build output\n"+logBlock(o.progress))
+	}
+
+	var b strings.Builder
+	for i, section := range sections {
+		if i == 0 && len(section)+2 > max && max > 100 {
+			// Keep the start of a single huge log rather than nothing.
+			section = truncateText(section, max-6) + "\n```"
+		}
+		if b.Len()+len(section)+2 > max {
+			fmt.Fprintf(&b, "\n\n%d more failure logs omitted.", len(sections)-i)
+			break
+		}
+		b.WriteString("\n\n")
+		b.WriteString(section)
+	}
+	return b.String()
+}
+
+// logBlock renders the last maxFailureLogLines lines of a log as a markdown
+// code block.
+func logBlock(log string) string {
+	lines := strings.Split(strings.TrimRight(cleanLine(log), "\n"), "\n")
+	if omitted := len(lines) - maxFailureLogLines; omitted > 0 {
+		lines = append([]string{fmt.Sprintf("... (%d lines omitted) ...", omitted)}, lines[omitted:]...)
+	}
+	return "```\n" + strings.Join(lines, "\n") + "\n```"
+}
+
+// runBazelWithBEP runs `bb <command>` like runBazel, writing the build event
+// stream into the checkout, and parses it. The progress of the build is
+// reported while it runs, see watchBuildProgress. The returned stream is nil if
+// bazel didn't write a readable one, e.g. because its flags were invalid.
+func runBazelWithBEP(ctx context.Context, apiKey string, target *CheckTarget, command string, flags []string, targets []string) (*bepOutput, bytes.Buffer, bytes.Buffer, error) {
+	dir, err := bepDir(target)
+	if err != nil {
+		return nil, bytes.Buffer{}, bytes.Buffer{}, err
+	}
+	defer os.RemoveAll(filepath.Join(target.Dir, dir))
+	file := filepath.Join(dir, command+".json")
+	path := filepath.Join(target.Dir, file)
+	flags = append([]string{"--build_event_json_file=" + file}, flags...)
+	stopProgress := watchBuildProgress(ctx, target, path)
+	stdOut, stdErr, err := runBazel(ctx, apiKey, target, command, flags, targets)
+	stopProgress()
+	bep, parseErr := parseBEP(ctx, path)
+	if parseErr != nil {
+		logFrom(ctx).Warnw("failed to read build event stream", "error", parseErr)
+		bep = nil
+	}
+	return bep, stdOut, stdErr, err
+}
@@ -0,0 +1,232 @@
+package app
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testBuildEvents returns the build event stream of a failed build, with the
+// stderr of the failed action in a file under dir.
+func testBuildEvents(t *testing.T, dir string) string {
+	stderr := filepath.Join(dir, "stderr")
+	writeTestFile(t, dir, "stderr", "lib/lib.go:1:2: syntax error\n")
+	return strings.Join([]string{
+		`{"id":{"progress":{}},"progress":{"stderr":"Streaming build results to: https://app.buildbuddy.io/invocation/abc\n"}}`,
+		`{"id":{"progress":{"opaqueCount":1}},"progress":{"stderr":"app/main.go:3:5: undefined: x\n"}}`,
+		`{"id":{"actionCompleted":{"label":"//lib:lib"}},"action":{"success":false,"label":"//lib:lib","type":"GoCompile","exitCode":1,"stderr":{"uri":"file://` + stderr + `"}}}`,
+		`{"id":{"targetCompleted":{"label":"//lib:lib"}},"completed":{"success":false}}`,
+		`{"id":{"targetCompleted":{"label":"//lib:lib"}},"completed":{"success":false}}`,
+		`{"id":{"targetCompleted":{"label":"//app:main"}},"aborted":{"reason":"ANALYSIS_FAILURE","description":"missing dep"}}`,
+		`{"id":{"targetCompleted":{"label":"//app:ok"}},"completed":{"success":true}}`,
+		`{"id":{"buildFinished":{}},"finished":{"overallSuccess":false,"exitCode":{"name":"BUILD_FAILURE","code":1}}}`,
+	}, "\n") + "\n"
+}
+
+func TestParseBEP(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "bep.json", testBuildEvents(t, dir))
+
+	out, err := parseBEP(context.Background(), filepath.Join(dir, "bep.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !out.finished || out.success || out.exitName != "BUILD_FAILURE" {
+		t.Errorf("got finished %t, success %t, exit %q, want a finished BUILD_FAILURE", out.finished, out.success, out.exitName)
+	}
+	if got := strings.Join(out.failedTargets, " "); got != "//lib:lib //app:main" {
+		t.Errorf("got failed targets %q, want //lib:lib and //app:main once each", got)
+	}
+	if len(out.failedActions) != 1 || out.failedActions[0].mnemonic != "GoCompile" || out.failedActions[0].stderr != "lib/lib.go:1:2: syntax error\n" {
+		t.Errorf("got failed actions %+v, want GoCompile with its stderr", out.failedActions)
+	}
+	if out.abortedReasons["//app:main"] != "ANALYSIS_FAILURE missing dep" {
+		t.Errorf("got aborted reasons %v", out.abortedReasons)
+	}
+	if out.primaryURL() != "https://app.buildbuddy.io/invocation/abc" {
+		t.Errorf("got URL %q, want the streamed invocation", out.primaryURL())
+	}
+	if len(out.annotations) != 2 || out.annotations[0].Path != "app/main.go" || out.annotations[1].Path != "lib/lib.go" {
+		t.Errorf("got annotations %v, want the progress and action diagnostics", out.annotations)
+	}
+}
+
+func TestParseBEPTestResultsAndMetrics(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "bep.json", strings.Join([]string{
+		`{"id":{"testResult":{"label":"//a:test","attempt":1}},"testResult":{"status":"FAILED","testActionOutput":[{"name":"test.xml","uri":"file:///a/1/test.xml"},{"name":"test.log","uri":"file:///a/1/test.log"}]}}`,
+		`{"id":{"testResult":{"label":"//a:test","attempt":2}},"testResult":{"status":"PASSED","testActionOutput":[{"name":"test.log","uri":"file:///a/2/test.log"}]}}`,
+		`{"id":{"testResult":{"label":"//b:test","attempt":1}},"testResult":{"status":"TIMEOUT","testActionOutput":[{"name":"test.log","uri":"bytestream://remote/blobs/b/1"}]}}`,
+		`{"id":{"testResult":{"label":"//c:test","attempt":1}},"testResult":{"status":"FAILED"}}`,
+		`{"id":{"buildMetrics":{}},"buildMetrics":{"actionSummary":{"actionsExecuted":"40","runnerCount":[{"name":"remote cache hit","count":25},{"name":"disk cache hit","count":"5"},{"name":"remote","count":10}]}}}`,
+	}, "\n")+"\n")
+
+	out, err := parseBEP(context.Background(), filepath.Join(dir, "bep.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.executedActions != 40 || out.cacheHits != 30 {
+		t.Errorf("got %d cache hits of %d actions, want 30 of 40", out.cacheHits, out.executedActions)
+	}
+	if got := strings.Join(out.tests, " "); got != "//a:test //b:test //c:test" {
+		t.Errorf("got tests %q, want each test once", got)
+	}
+	if out.testStatus["//a:test"] != "PASSED" || out.testLogs["//a:test"] != "file:///a/2/test.log" {
+		t.Errorf("got %s with log %s for //a:test, want its last attempt", out.testStatus["//a:test"], out.testLogs["//a:test"])
+	}
+	if got := strings.Join(out.failedTestLabels(), " "); got != "//b:test" {
+		t.Errorf("got failed tests %q, want the ones with logs that didn't pass", got)
+	}
+}
+
+func TestParseBEPWithInvalidEvents(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "bep.json", "{\"id\":{}}\nnot json\n")
+	if _, err := parseBEP(context.Background(), filepath.Join(dir, "bep.json")); err == nil {
+		t.Errorf("parsed an invalid build event stream")
+	}
+	if _, err := parseBEP(context.Background(), filepath.Join(dir, "missing.json")); err == nil {
+		t.Errorf("parsed a build event stream that doesn't exist")
+	}
+}
+
+func TestReadFileURI(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "stderr", "boom")
+	if got, ok := readFileURI("file://" + filepath.Join(dir, "stderr")); !ok || got != "boom" {
+		t.Errorf("got %q, %t, want the file's contents", got, ok)
+	}
+	if _, ok := readFileURI("bytestream://remote.buildbuddy.io/blobs/abc/4"); ok {
+		t.Errorf("read a bytestream URI")
+	}
+}
+
+func TestFailedTargetsTable(t *testing.T) {
+	out := &bepOutput{
+		failedTargets:  []string{"//lib:lib", "//app:main", "//x:y"},
+		failedActions:  []*bepActionFailure{{label: "//lib:lib", mnemonic: "GoCompile", exitCode: 1}, {label: "//lib:lib", mnemonic: "GoLink", exitCode: 2}},
+		abortedReasons: map[string]string{"//app:main": "ANALYSIS_FAILURE a|b"},
+	}
+	want := "| Target | Failure |\n| --- | --- |\n" +
+		"| [`//lib:lib`](https://app.buildbuddy.io/invocation/abc?target=%2F%2Flib%3Alib) | GoCompile (exit code 1), GoLink (exit code 2) |\n" +
+		"| [`//app:main`](https://app.buildbuddy.io/invocation/abc?target=%2F%2Fapp%3Amain) | aborted: ANALYSIS_FAILURE a\\|b |\n" +
+		"| [`//x:y`](https://app.buildbuddy.io/invocation/abc?target=%2F%2Fx%3Ay) | failed to build |\n"
+	if got := out.failedTargetsTable("https://app.buildbuddy.io/invocation/abc"); got != want {
+		t.Errorf("got table\n%s\nwant\n%s", got, want)
+	}
+	if got := out.failedTargetsTable(""); !strings.Contains(got, "| `//x:y` | failed to build |") {
+		t.Errorf("got table %q without an invocation URL, want plain labels", got)
+	}
+	if got := (&bepOutput{}).failedTargetsTable(""); got != "" {
+		t.Errorf("got table %q without failed targets, want none", got)
+	}
+}
+
+func TestLogBlock(t *testing.T) {
+	if got := logBlock("\x1b[31mld: undefined symbol\x1b[0m\n"); got != "```\nld: undefined symbol\n```" {
+		t.Errorf("got %q, want the log without colors in a code block", got)
+	}
+	var lines []string
+	for i := 0; i < maxFailureLogLines+5; i++ {
+		lines = append(lines, "line")
+	}
+	got := logBlock(strings.Join(lines, "\n"))
+	if !strings.HasPrefix(got, "```\n... (5 lines omitted) ...\nline\n") || strings.Count(got, "line\n") != maxFailureLogLines {
+		t.Errorf("got %q, want the last %d lines", got, maxFailureLogLines)
+	}
+}
+
+func TestFailureLogs(t *testing.T) {
+	out := &bepOutput{
+		failedActions: []*bepActionFailure{
+			{label: "//app:main", mnemonic: "GoLink", stderr: "ld: undefined symbol\n"},
+			{label: "//remote:lib", mnemonic: "GoCompile"},
+			{label: "//lib:lib", mnemonic: "GoCompile", stderr: "lib.go:1:2: syntax error\n"},
+		},
+		progress: "INFO: Build failed\n",
+	}
+	want := "\n\n#### `//app:main` GoLink\n```\nld: undefined symbol\n```\n\n#### `//lib:lib` GoCompile\n```\nlib.go:1:2: syntax error\n```"
+	if got := out.failureLogs(maxCheckRunText); got != want {
+		t.Errorf("got logs %q, want %q", got, want)
+	}
+	if got := out.failureLogs(60); got != "\n\n#### `//app:main` GoLink\n```\nld: undefined symbol\n```\n\n1 more failure logs omitted." {
+		t.Errorf("got logs %q, want the logs that don't fit to be omitted", got)
+	}
+
+	// Remotely executed actions don't have a local stderr.
+	out.failedActions = out.failedActions[1:2]
+	if got := out.failureLogs(maxCheckRunText); got != "\n\n#### Build output\n```\nINFO: Build failed\n```" {
+		t.Errorf("got logs %q, want the build's output", got)
+	}
+}
+
+func TestFailureLogsKeepsStartOfHugeLog(t *testing.T) {
+	out := &bepOutput{failedActions: []*bepActionFailure{{label: "//app:main", mnemonic: "GoLink", stderr: strings.Repeat("x", 1000)}}}
+	got := out.failureLogs(500)
+	if len(got) > 500 || !strings.Contains(got, "#### `//app:main` GoLink\n```\nxxx") || !strings.HasSuffix(got, "... (truncated)\n```") {
+		t.Errorf("got %d bytes of logs %q, want the truncated start of the log", len(got), got)
+	}
+}
+
+func TestCheckBazelBuildReadsBuildEvents(t *testing.T) {
+	setBuildBuddyURL(t, "")
+	events := t.TempDir()
+	writeTestFile(t, events, "bep.json", testBuildEvents(t, events))
+	installFakeTool(t, "bb", `for a; do
+  case "$a" in --build_event_json_file=*) cp `+filepath.Join(events, "bep.json")+` "${a#*=}";; esac
+done
+echo "unparsed.go:1:1: scraped from stdout"
+exit 1
+`)
+	app := newTestApp(t, newFakeGitHub(t))
+	dir := t.TempDir()
+	res, err := checkBazelBuild(context.Background(), app, &CheckTarget{Dir: dir, Config: &CheckConfig{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Conclusion != "failure" || res.Summary != "Build doesn't complete successfully: 2 targets failed (BUILD_FAILURE)" {
+		t.Errorf("got %s with summary %q", res.Conclusion, res.Summary)
+	}
+	if res.URL != "https://app.buildbuddy.io/invocation/abc" || !strings.Contains(res.Text, "GoCompile (exit code 1)") {
+		t.Errorf("got URL %q and text %q, want the invocation and failed targets", res.URL, res.Text)
+	}
+	if !strings.Contains(res.Text, "#### `//lib:lib` GoCompile\n```\nlib/lib.go:1:2: syntax error\n```") {
+		t.Errorf("text %q doesn't include the failed action's stderr", res.Text)
+	}
+	if len(res.Annotations) != 2 {
+		t.Errorf("got annotations %v, want the ones from the build events only", res.Annotations)
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 0 {
+		t.Errorf("got %v, %v in the checkout, want the build event file removed", entries, err)
+	}
+}
+
+func TestBepDirIsUniquePerRun(t *testing.T) {
+	target := &CheckTarget{Dir: t.TempDir()}
+	first, err := bepDir(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := bepDir(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second || filepath.IsAbs(first) || !strings.HasPrefix(first, ".reviewbot-bep-") {
+		t.Errorf("got build event directories %q and %q, want distinct ones relative to the checkout", first, second)
+	}
+}
+
+func TestCheckBazelBuildWithoutBuildEvents(t *testing.T) {
+	setBuildBuddyURL(t, "")
+	installFakeTool(t, "bb", "echo 'app/main.go:3:5: undefined: x'\nexit 1\n")
+	app := newTestApp(t, newFakeGitHub(t))
+	res, err := checkBazelBuild(context.Background(), app, &CheckTarget{Dir: t.TempDir(), Config: &CheckConfig{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Conclusion != "failure" || len(res.Annotations) != 1 || res.Annotations[0].Path != "app/main.go" {
+		t.Errorf("got %s with annotations %v, want bazel's output to be scraped", res.Conclusion, res.Annotations)
+	}
+}
@@ -0,0 +1,363 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+// maxInlineComments caps the inline comments posted for a check, like
+// maxSuggestions.
+const maxInlineComments = 50
+
+// Bitbucket is a Provider for repositories on Bitbucket Cloud. Results are
+// reported as build statuses, and the annotations of failed checks as inline
+// comments on the pull request. Pull requests from forks aren't checked,
+// since their commits can't be fetched from the target repository.
+type Bitbucket struct {
+	// APIURL is the Bitbucket API, e.g. "https://api.bitbucket.org/2.0".
+	APIURL string
+	// URL is the Bitbucket site that repositories are cloned from, e.g.
+	// "https://bitbucket.org".
+	URL string
+	// Token is a workspace or repository access token with the pull request
+	// write, repository write and repository admin scopes. Admin is needed to
+	// check the permission of users running commands.
+	Token string
+	// WebhookSecret is the secret of the repository or workspace webhooks.
+	WebhookSecret string
+}
+
+func (b *Bitbucket) Name() string { return "bitbucket" }
+
+type bitbucketEndpoint struct {
+	Branch struct {
+		Name string `json:"name"`
+	} `json:"branch"`
+	Commit struct {
+		Hash string `json:"hash"`
+	} `json:"commit"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+}
+
+// bitbucketWebhook holds the fields of pull request webhooks that the bot
+// uses.
+type bitbucketWebhook struct {
+	Actor struct {
+		AccountID string `json:"account_id"`
+		Nickname  string `json:"nickname"`
+	} `json:"actor"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+	PullRequest struct {
+		ID          int               `json:"id"`
+		Source      bitbucketEndpoint `json:"source"`
+		Destination bitbucketEndpoint `json:"destination"`
+	} `json:"pullrequest"`
+	// Comment is set on comment events.
+	Comment struct {
+		Content struct {
+			Raw string `json:"raw"`
+		} `json:"content"`
+	} `json:"comment"`
+}
+
+// validSignature reports whether the X-Hub-Signature of a webhook signs
+// payload with the webhook secret.
+func (b *Bitbucket) validSignature(req *http.Request, payload []byte) bool {
+	signature := strings.TrimPrefix(req.Header.Get("X-Hub-Signature"), "sha256=")
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(b.WebhookSecret))
+	mac.Write(payload)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+func (b *Bitbucket) ParseWebhook(ctx context.Context, req *http.Request) (*CheckRequest, error) {
+	payload, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	if !b.validSignature(req, payload) {
+		return nil, &httpError{statusCode: http.StatusUnauthorized, message: "invalid webhook signature"}
+	}
+	hook := &bitbucketWebhook{}
+	if err := json.Unmarshal(payload, hook); err != nil {
+		return nil, &httpError{statusCode: http.StatusBadRequest, message: fmt.Sprintf("invalid webhook payload: %s", err)}
+	}
+	event := req.Header.Get("X-Event-Key")
+	ctx = withLogFields(ctx, "repo", hook.Repository.FullName, "pr", hook.PullRequest.ID)
+	logFrom(ctx).Infow("got webhook", "event", event)
+	switch event {
+	case "pullrequest:created", "pullrequest:updated":
+		return b.pullRequestCheckRequest(ctx, hook)
+	case "pullrequest:comment_created":
+		return b.commandCheckRequest(ctx, hook)
+	}
+	return nil, nil
+}
+
+// pullRequestCheckRequest returns the request to run the checks on the head
+// of the webhook's pull request, or nil for pull requests from forks.
+func (b *Bitbucket) pullRequestCheckRequest(ctx context.Context, hook *bitbucketWebhook) (*CheckRequest, error) {
+	pr := &hook.PullRequest
+	if pr.Source.Repository.FullName != pr.Destination.Repository.FullName {
+		logFrom(ctx).Infow("ignoring pull request from a fork")
+		return nil, nil
+	}
+	creq := &CheckRequest{
+		Repo:         hook.Repository.FullName,
+		Branch:       pr.Source.Branch.Name,
+		MergeRequest: pr.ID,
+	}
+	// Webhooks only carry abbreviated hashes.
+	var err error
+	if creq.SHA, err = b.commitHash(ctx, creq.Repo, pr.Source.Commit.Hash); err != nil {
+		return nil, err
+	}
+	if creq.BaseSHA, err = b.commitHash(ctx, creq.Repo, pr.Destination.Commit.Hash); err != nil {
+		logFrom(ctx).Warnw("failed to resolve base commit", "error", err)
+	}
+	return creq, nil
+}
+
+// commitHash returns the full hash of the commit with the abbreviated hash.
+func (b *Bitbucket) commitHash(ctx context.Context, repo string, hash string) (string, error) {
+	var commit struct {
+		Hash string `json:"hash"`
+	}
+	if err := b.api(ctx, http.MethodGet, b.repoPath(repo, "commit/"+hash), nil, &commit); err != nil {
+		return "", fmt.Errorf("failed to resolve commit %s: %s", hash, err)
+	}
+	return commit.Hash, nil
+}
+
+// commandCheckRequest returns the request of the command in a pull request
+// comment, if any. Only users with write access may run commands.
+func (b *Bitbucket) commandCheckRequest(ctx context.Context, hook *bitbucketWebhook) (*CheckRequest, error) {
+	commands := parseCommands(hook.Comment.Content.Raw)
+	if len(commands) == 0 {
+		return nil, nil
+	}
+	ctx = withLogFields(ctx, "user", hook.Actor.Nickname)
+	var perm struct {
+		Permission string `json:"permission"`
+	}
+	err := b.api(ctx, http.MethodGet, b.repoPath(hook.Repository.FullName, "permissions-config/users/"+url.PathEscape(hook.Actor.AccountID)), nil, &perm)
+	if err != nil || (perm.Permission != "write" && perm.Permission != "admin") {
+		logFrom(ctx).Infow("ignoring command from user without write access", "permission", perm.Permission, "error", err)
+		return nil, nil
+	}
+	creq, err := b.pullRequestCheckRequest(ctx, hook)
+	if creq == nil || err != nil {
+		return nil, err
+	}
+	// Only the first command is run, since each one needs its own checkout.
+	cmd := commands[0]
+	for _, checkName := range cmd.args {
+		if _, err := GetChecker(checkName); err != nil {
+			return nil, b.Comment(ctx, creq, fmt.Sprintf("@%s %s", hook.Actor.Nickname, err))
+		}
+	}
+	switch cmd.name {
+	case "rerun":
+		creq.Checks = cmd.args
+		return creq, nil
+	case "fix":
+		if len(cmd.args) != 1 {
+			return nil, b.Comment(ctx, creq, fmt.Sprintf("@%s usage: %s fix <check>", hook.Actor.Nickname, commandPrefix))
+		}
+		creq.Fix = cmd.args[0]
+		return creq, nil
+	}
+	return nil, b.Comment(ctx, creq, fmt.Sprintf("@%s unknown command %q, expected rerun or fix", hook.Actor.Nickname, cmd.name))
+}
+
+// repoURL returns the clone URL of repo, without credentials.
+func (b *Bitbucket) repoURL(repo string) (string, error) {
+	u, err := url.Parse(b.URL)
+	if err != nil {
+		return "", fmt.Errorf("invalid Bitbucket URL %q: %s", b.URL, err)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + repo + ".git"
+	return u.String(), nil
+}
+
+// auth returns the credentials of git operations on the workspace's
+// repositories.
+func (b *Bitbucket) auth() transport.AuthMethod {
+	return &githttp.BasicAuth{Username: "x-token-auth", Password: b.Token}
+}
+
+func (b *Bitbucket) CloneURL(ctx context.Context, repo string) (string, transport.AuthMethod, error) {
+	url, err := b.repoURL(repo)
+	return url, b.auth(), err
+}
+
+// MergeRequestRefSpec fetches the branches, since Bitbucket has no refs for
+// pull requests.
+func (b *Bitbucket) MergeRequestRefSpec() config.RefSpec {
+	return config.RefSpec("+refs/heads/*:refs/remotes/origin/*")
+}
+
+// bitbucketState maps the conclusion of a result to the state of a build
+// status.
+func bitbucketState(result *Result) string {
+	if result == nil {
+		return "INPROGRESS"
+	}
+	switch result.Conclusion {
+	case "success", "neutral", "skipped":
+		return "SUCCESSFUL"
+	case "cancelled":
+		return "STOPPED"
+	}
+	return "FAILED"
+}
+
+func (b *Bitbucket) ReportStatus(ctx context.Context, creq *CheckRequest, checkName string, result *Result) error {
+	// Build statuses must link somewhere, so link the commit by default.
+	link := fmt.Sprintf("%s/%s/commits/%s", strings.TrimSuffix(b.URL, "/"), creq.Repo, creq.SHA)
+	status := map[string]string{
+		"key":   checkName,
+		"name":  checkName,
+		"state": bitbucketState(result),
+		"url":   link,
+	}
+	if result != nil {
+		status["description"] = result.Title
+		if result.URL != "" {
+			status["url"] = result.URL
+		}
+	}
+	return b.api(ctx, http.MethodPost, b.repoPath(creq.Repo, "commit/"+creq.SHA+"/statuses/build"), status, nil)
+}
+
+type bitbucketComment struct {
+	Content struct {
+		Raw string `json:"raw"`
+	} `json:"content"`
+	Inline *struct {
+		Path string `json:"path"`
+		To   int    `json:"to,omitempty"`
+	} `json:"inline,omitempty"`
+}
+
+func (b *Bitbucket) Comment(ctx context.Context, creq *CheckRequest, body string) error {
+	c := &bitbucketComment{}
+	c.Content.Raw = body
+	return b.api(ctx, http.MethodPost, b.repoPath(creq.Repo, fmt.Sprintf("pullrequests/%d/comments", creq.MergeRequest)), c, nil)
+}
+
+// CommentLines posts the annotations of a check as inline comments on the
+// pull request, skipping those that are already posted.
+func (b *Bitbucket) CommentLines(ctx context.Context, creq *CheckRequest, checkName string, annotations []*Annotation) error {
+	path := b.repoPath(creq.Repo, fmt.Sprintf("pullrequests/%d/comments", creq.MergeRequest))
+	existing := make(map[string]bool)
+	next := path + "?pagelen=100"
+	for next != "" {
+		var page struct {
+			Values []*bitbucketComment `json:"values"`
+			Next   string              `json:"next"`
+		}
+		if err := b.api(ctx, http.MethodGet, next, nil, &page); err != nil {
+			return err
+		}
+		for _, c := range page.Values {
+			if c.Inline != nil {
+				existing[reviewCommentKey(c.Inline.Path, c.Inline.To, c.Content.Raw)] = true
+			}
+		}
+		next = page.Next
+	}
+	posted := 0
+	for _, a := range annotations {
+		c := &bitbucketComment{}
+		c.Content.Raw = fmt.Sprintf("**%s**: %s", checkName, a.Message)
+		c.Inline = &struct {
+			Path string `json:"path"`
+			To   int    `json:"to,omitempty"`
+		}{Path: a.Path, To: a.Line}
+		if existing[reviewCommentKey(a.Path, a.Line, c.Content.Raw)] {
+			continue
+		}
+		if err := b.api(ctx, http.MethodPost, path, c, nil); err != nil {
+			return err
+		}
+		posted++
+		if posted == maxInlineComments {
+			break
+		}
+	}
+	return nil
+}
+
+func (b *Bitbucket) PushBranch(ctx context.Context, r *git.Repository, repo string, branch string) error {
+	url, err := b.repoURL(repo)
+	if err != nil {
+		return err
+	}
+	return pushBranchTo(ctx, r, url, b.auth(), branch)
+}
+
+// repoPath returns the API path of a resource of repo.
+func (b *Bitbucket) repoPath(repo string, resource string) string {
+	return fmt.Sprintf("repositories/%s/%s", repo, resource)
+}
+
+// api sends a request with a JSON body, if any, to path of the Bitbucket
+// API, or to path itself if it's an absolute URL like the next page of a
+// list, and decodes the response into out, if set.
+func (b *Bitbucket) api(ctx context.Context, method string, path string, body interface{}, out interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(buf)
+	}
+	u := path
+	if !strings.HasPrefix(path, "https://") && !strings.HasPrefix(path, "http://") {
+		u = strings.TrimSuffix(b.APIURL, "/") + "/" + path
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+b.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1<<10))
+		return fmt.Errorf("Bitbucket API %s %s returned %s: %s", method, path, res.Status, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
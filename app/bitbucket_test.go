@@ -0,0 +1,288 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+const testBitbucketSecret = "bitbucket-secret"
+
+// newFakeBitbucket returns a Bitbucket provider talking to a fake server,
+// whose routes are like "GET /2.0/repositories/w/r".
+func newFakeBitbucket(t *testing.T) (*Bitbucket, *fakeGitHub) {
+	f := newFakeAPI(t)
+	return &Bitbucket{APIURL: f.URL + "/2.0", URL: "https://bitbucket.example.com", Token: "bitbucket-token", WebhookSecret: testBitbucketSecret}, f
+}
+
+// recordBitbucketRequests records the JSON bodies sent to route.
+func recordBitbucketRequests(t *testing.T, f *fakeGitHub, route string) *[]map[string]interface{} {
+	var bodies []map[string]interface{}
+	f.handle(route, func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer bitbucket-token" {
+			t.Errorf("%s wasn't authenticated with the token", route)
+		}
+		body := make(map[string]interface{})
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			t.Errorf("failed to decode %s: %s", route, err)
+		}
+		bodies = append(bodies, body)
+		writeTestJSON(w, http.StatusCreated, map[string]interface{}{"id": 1})
+	})
+	return &bodies
+}
+
+// commentBody returns the raw content of a recorded comment.
+func commentBody(c map[string]interface{}) string {
+	content, _ := c["content"].(map[string]interface{})
+	raw, _ := content["raw"].(string)
+	return raw
+}
+
+func bitbucketWebhookRequest(t *testing.T, event string, secret string, payload interface{}) *http.Request {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	req := httptest.NewRequest(http.MethodPost, "/bitbucket/event_handler", bytes.NewReader(body))
+	req.Header.Set("X-Event-Key", event)
+	req.Header.Set("X-Hub-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	return req
+}
+
+func bitbucketEndpointPayload(repo string, branch string, hash string) map[string]interface{} {
+	return map[string]interface{}{
+		"branch":     map[string]string{"name": branch},
+		"commit":     map[string]string{"hash": hash},
+		"repository": map[string]string{"full_name": repo},
+	}
+}
+
+func bitbucketPayload(sourceRepo string, comment string) map[string]interface{} {
+	return map[string]interface{}{
+		"actor":      map[string]string{"account_id": "acct-9", "nickname": "dev"},
+		"repository": map[string]string{"full_name": "w/r"},
+		"pullrequest": map[string]interface{}{
+			"id":          5,
+			"source":      bitbucketEndpointPayload(sourceRepo, "feature", "abc"),
+			"destination": bitbucketEndpointPayload("w/r", "main", "def"),
+		},
+		"comment": map[string]interface{}{"content": map[string]string{"raw": comment}},
+	}
+}
+
+// serveBitbucketRepo resolves the abbreviated hashes abc and def of w/r and
+// gives acct-9 permission.
+func serveBitbucketRepo(f *fakeGitHub, permission string) {
+	for _, hash := range []string{"abc", "def"} {
+		full := hash + strings.Repeat("0", 40-len(hash))
+		f.handle("GET /2.0/repositories/w/r/commit/"+hash, func(w http.ResponseWriter, req *http.Request) {
+			writeTestJSON(w, http.StatusOK, map[string]string{"hash": full})
+		})
+	}
+	f.handle("GET /2.0/repositories/w/r/permissions-config/users/acct-9", func(w http.ResponseWriter, req *http.Request) {
+		writeTestJSON(w, http.StatusOK, map[string]string{"permission": permission})
+	})
+}
+
+func TestBitbucketParseWebhookRejectsInvalidSignature(t *testing.T) {
+	b, _ := newFakeBitbucket(t)
+	_, err := b.ParseWebhook(context.Background(), bitbucketWebhookRequest(t, "pullrequest:created", "wrong", bitbucketPayload("w/r", "")))
+	if e, ok := err.(*httpError); !ok || e.statusCode != http.StatusUnauthorized {
+		t.Errorf("got error %v, want unauthorized", err)
+	}
+}
+
+func TestBitbucketParseWebhookPullRequest(t *testing.T) {
+	b, f := newFakeBitbucket(t)
+	serveBitbucketRepo(f, "write")
+
+	for _, event := range []string{"pullrequest:created", "pullrequest:updated"} {
+		creq, err := b.ParseWebhook(context.Background(), bitbucketWebhookRequest(t, event, testBitbucketSecret, bitbucketPayload("w/r", "")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := CheckRequest{Repo: "w/r", SHA: "abc" + strings.Repeat("0", 37), BaseSHA: "def" + strings.Repeat("0", 37), Branch: "feature", MergeRequest: 5}
+		if creq == nil || creq.Repo != want.Repo || creq.SHA != want.SHA || creq.BaseSHA != want.BaseSHA || creq.Branch != want.Branch || creq.MergeRequest != want.MergeRequest {
+			t.Errorf("%s: got request %+v, want %+v", event, creq, want)
+		}
+	}
+
+	for _, tc := range []struct{ event, source string }{
+		{"pullrequest:fulfilled", "w/r"},
+		{"pullrequest:created", "fork/r"},
+	} {
+		creq, err := b.ParseWebhook(context.Background(), bitbucketWebhookRequest(t, tc.event, testBitbucketSecret, bitbucketPayload(tc.source, "")))
+		if err != nil || creq != nil {
+			t.Errorf("%s from %s: got request %+v, %v, want none", tc.event, tc.source, creq, err)
+		}
+	}
+}
+
+func TestBitbucketParseWebhookCommands(t *testing.T) {
+	registerTestChecker(t, &funcChecker{name: "test-lint"})
+	for _, tc := range []struct {
+		comment    string
+		permission string
+		checks     []string
+		fix        string
+		reply      string
+	}{
+		{comment: "looks good", permission: "write"},
+		{comment: "/reviewbot rerun test-lint", permission: "write", checks: []string{"test-lint"}},
+		{comment: "/reviewbot rerun test-lint", permission: "read"},
+		{comment: "/reviewbot fix test-lint", permission: "admin", fix: "test-lint"},
+		{comment: "/reviewbot fix", permission: "write", reply: "@dev usage: /reviewbot fix <check>"},
+		{comment: "/reviewbot rerun unknown", permission: "write", reply: "@dev "},
+		{comment: "/reviewbot deploy", permission: "write", reply: `@dev unknown command "deploy"`},
+	} {
+		b, f := newFakeBitbucket(t)
+		serveBitbucketRepo(f, tc.permission)
+		comments := recordBitbucketRequests(t, f, "POST /2.0/repositories/w/r/pullrequests/5/comments")
+
+		creq, err := b.ParseWebhook(context.Background(), bitbucketWebhookRequest(t, "pullrequest:comment_created", testBitbucketSecret, bitbucketPayload("w/r", tc.comment)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		run := tc.checks != nil || tc.fix != ""
+		if (creq != nil) != run {
+			t.Errorf("%q: got request %+v, want one: %t", tc.comment, creq, run)
+		} else if creq != nil && (strings.Join(creq.Checks, ",") != strings.Join(tc.checks, ",") || creq.Fix != tc.fix) {
+			t.Errorf("%q: got request %+v, want checks %q and fix %q", tc.comment, creq, tc.checks, tc.fix)
+		}
+		if tc.reply == "" {
+			if len(*comments) != 0 {
+				t.Errorf("%q: commented %v, want no comment", tc.comment, *comments)
+			}
+		} else if len(*comments) != 1 || !strings.HasPrefix(commentBody((*comments)[0]), tc.reply) {
+			t.Errorf("%q: commented %v, want a comment starting with %q", tc.comment, *comments, tc.reply)
+		}
+	}
+}
+
+func TestBitbucketState(t *testing.T) {
+	for _, tc := range []struct {
+		result *Result
+		want   string
+	}{
+		{nil, "INPROGRESS"},
+		{&Result{Conclusion: "success"}, "SUCCESSFUL"},
+		{&Result{Conclusion: "neutral"}, "SUCCESSFUL"},
+		{&Result{Conclusion: "cancelled"}, "STOPPED"},
+		{&Result{Conclusion: "failure"}, "FAILED"},
+	} {
+		if got := bitbucketState(tc.result); got != tc.want {
+			t.Errorf("bitbucketState(%+v) = %q, want %q", tc.result, got, tc.want)
+		}
+	}
+}
+
+func TestBitbucketReportStatus(t *testing.T) {
+	b, f := newFakeBitbucket(t)
+	statuses := recordBitbucketRequests(t, f, "POST /2.0/repositories/w/r/commit/abc/statuses/build")
+	creq := &CheckRequest{Repo: "w/r", SHA: "abc"}
+	ctx := context.Background()
+
+	if err := b.ReportStatus(ctx, creq, "test-lint", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.ReportStatus(ctx, creq, "test-lint", &Result{Conclusion: "failure", Title: "1 issue", URL: "https://example.com/invocation"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(*statuses) != 2 {
+		t.Fatalf("reported %d statuses, want 2", len(*statuses))
+	}
+	running := (*statuses)[0]
+	if running["key"] != "test-lint" || running["state"] != "INPROGRESS" || running["url"] != "https://bitbucket.example.com/w/r/commits/abc" {
+		t.Errorf("got status %v, want test-lint in progress linking to the commit", running)
+	}
+	failed := (*statuses)[1]
+	if failed["state"] != "FAILED" || failed["description"] != "1 issue" || failed["url"] != "https://example.com/invocation" {
+		t.Errorf("got status %v, want a failure linking to the invocation", failed)
+	}
+}
+
+func TestBitbucketCommentLines(t *testing.T) {
+	b, f := newFakeBitbucket(t)
+	var posted []map[string]interface{}
+	f.handle("GET /2.0/repositories/w/r/pullrequests/5/comments", func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Query().Get("page") == "2" {
+			writeTestJSON(w, http.StatusOK, map[string]interface{}{"values": []map[string]interface{}{
+				{"content": map[string]string{"raw": "**test-lint**: unused"}, "inline": map[string]interface{}{"path": "main.go", "to": 1}},
+			}})
+			return
+		}
+		writeTestJSON(w, http.StatusOK, map[string]interface{}{
+			"values": []map[string]interface{}{{"content": map[string]string{"raw": "a summary"}}},
+			"next":   fmt.Sprintf("%s/2.0/repositories/w/r/pullrequests/5/comments?page=2", f.URL),
+		})
+	})
+	f.handle("POST /2.0/repositories/w/r/pullrequests/5/comments", func(w http.ResponseWriter, req *http.Request) {
+		body := make(map[string]interface{})
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			t.Errorf("failed to decode comment: %s", err)
+		}
+		posted = append(posted, body)
+		writeTestJSON(w, http.StatusCreated, map[string]interface{}{"id": 1})
+	})
+
+	err := b.CommentLines(context.Background(), &CheckRequest{Repo: "w/r", MergeRequest: 5}, "test-lint", []*Annotation{
+		{Path: "main.go", Line: 1, Message: "unused"},
+		{Path: "main.go", Line: 2, Message: "shadowed"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(posted) != 1 || commentBody(posted[0]) != "**test-lint**: shadowed" {
+		t.Fatalf("posted %v, want only the annotation that isn't posted yet", posted)
+	}
+	if inline, _ := posted[0]["inline"].(map[string]interface{}); inline["path"] != "main.go" || inline["to"] != float64(2) {
+		t.Errorf("posted comment on %v, want main.go:2", posted[0]["inline"])
+	}
+}
+
+func TestBitbucketCommentLinesCapsComments(t *testing.T) {
+	b, f := newFakeBitbucket(t)
+	f.handle("GET /2.0/repositories/w/r/pullrequests/5/comments", func(w http.ResponseWriter, req *http.Request) {
+		writeTestJSON(w, http.StatusOK, map[string]interface{}{"values": []interface{}{}})
+	})
+	comments := recordBitbucketRequests(t, f, "POST /2.0/repositories/w/r/pullrequests/5/comments")
+	var annotations []*Annotation
+	for i := 0; i < maxInlineComments+5; i++ {
+		annotations = append(annotations, &Annotation{Path: "main.go", Line: i + 1, Message: "unused"})
+	}
+
+	if err := b.CommentLines(context.Background(), &CheckRequest{Repo: "w/r", MergeRequest: 5}, "test-lint", annotations); err != nil {
+		t.Fatal(err)
+	}
+	if len(*comments) != maxInlineComments {
+		t.Errorf("posted %d comments, want %d", len(*comments), maxInlineComments)
+	}
+}
+
+func TestBitbucketCloneURL(t *testing.T) {
+	b := &Bitbucket{URL: "https://bitbucket.example.com/", Token: "secret"}
+	url, auth, err := b.CloneURL(context.Background(), "w/r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "https://bitbucket.example.com/w/r.git"; url != want {
+		t.Errorf("got clone URL %q, want %q", url, want)
+	}
+	if want := (&githttp.BasicAuth{Username: "x-token-auth", Password: "secret"}); !reflect.DeepEqual(auth, want) {
+		t.Errorf("got clone credentials %v, want the token", auth)
+	}
+}
@@ -0,0 +1,84 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+const blackCheck = "black"
+
+func init() {
+	RegisterChecker(&funcChecker{
+		name:         blackCheck,
+		fn:           checkBlack,
+		fixFn:        fixBlack,
+		fixMessage:   "Format Python sources with black",
+		optIn:        true,
+		requirements: CheckRequirements{Binaries: []string{"black", "git"}},
+		rule:         CheckRule{Description: "Python files are formatted with black.", HelpURI: "https://black.readthedocs.io/en/stable/the_black_code_style/"},
+	})
+}
+
+// checkBlack lists the Python files that black would reformat.
+func checkBlack(ctx context.Context, _ *GithubApp, target *CheckTarget) (*Result, error) {
+	tool := target.Config.ToolOr("black")
+	args := append([]string{"--check"}, target.Config.Flags...)
+	// black exits with 1 when files would be reformatted and 123 when it
+	// fails, and reports both on stderr.
+	_, stdErr, err := runCheckCmd(ctx, target, tool, append(args, ".")...)
+	if code := exitCode(err); code != 0 && code != 1 {
+		return toolFailure(tool, fmt.Sprintf("exit status %d", code), stdErr.String()), nil
+	}
+
+	annotations := []*Annotation{}
+	scanner := bufio.NewScanner(&stdErr)
+	for scanner.Scan() {
+		line := strings.TrimSpace(cleanLine(scanner.Text()))
+		if !strings.HasPrefix(line, "would reformat ") {
+			continue
+		}
+		path := filepath.ToSlash(filepath.Clean(strings.TrimPrefix(line, "would reformat ")))
+		annotations = append(annotations, &Annotation{
+			Message:   fmt.Sprintf("file %q needs reformat with %s", path, tool),
+			Severity:  "failure",
+			Path:      path,
+			Line:      1,
+			WholeFile: true,
+		})
+	}
+	if err != nil && len(annotations) == 0 {
+		return toolFailure(tool, "it would reformat files it didn't list", stdErr.String()), nil
+	}
+	annotations = target.Config.filterAnnotations(annotations)
+
+	res := &Result{
+		Title: fmt.Sprintf("%s result", tool),
+	}
+	if len(annotations) == 0 {
+		res.Summary = "No issues found."
+		res.Conclusion = "success"
+		return res, nil
+	}
+	res.Summary = fmt.Sprintf("%d Python files need reformat", len(annotations))
+	res.Conclusion = "failure"
+	res.Annotations = annotations
+	res.Action = newFixAction(blackCheck, fmt.Sprintf("Automatically reformat with %s.", tool))
+	return res, nil
+}
+
+// fixBlack reformats the Python files of the checkout in place.
+func fixBlack(ctx context.Context, _ *GithubApp, target *CheckTarget) error {
+	args := append([]string{"--quiet"}, target.Config.Flags...)
+	// black exits with 123 when it can't parse a file, which it leaves as it
+	// is.
+	_, stdErr, err := runCheckCmd(ctx, target, target.Config.ToolOr("black"), append(args, ".")...)
+	if code := exitCode(err); code == 123 {
+		return fmt.Errorf("failed to reformat some files: %s", strings.TrimSpace(cleanLine(stdErr.String())))
+	} else if err != nil {
+		return fmt.Errorf("failed to reformat: %s: %s", err, stdErr.String())
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package app
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckBlack(t *testing.T) {
+	tool := writeFakeTool(t, `cat >&2 <<'REPORT'
+would reformat src/app.py
+would reformat ./tests/test_app.py
+Oh no! 💥 💔 💥
+2 files would be reformatted, 3 files would be left unchanged.
+REPORT
+exit 1
+`)
+	res, err := checkBlack(context.Background(), nil, &CheckTarget{Dir: t.TempDir(), Config: &CheckConfig{Tool: tool}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Conclusion != "failure" || res.Summary != "2 Python files need reformat" {
+		t.Errorf("got %s with summary %q, want a failure for 2 files", res.Conclusion, res.Summary)
+	}
+	want := []string{"src/app.py", "tests/test_app.py"}
+	if len(res.Annotations) != len(want) {
+		t.Fatalf("got %d annotations, want %d", len(res.Annotations), len(want))
+	}
+	for i, a := range res.Annotations {
+		if a.Path != want[i] || a.Line != 1 || a.Severity != "failure" {
+			t.Errorf("annotation %d: got %+v, want a failure on line 1 of %s", i, *a, want[i])
+		}
+	}
+	if res.Action == nil || res.Action.Identifier != fixIdentifier(blackCheck) {
+		t.Errorf("got action %+v, want the black fix", res.Action)
+	}
+}
+
+func TestCheckBlackWithFormattedFiles(t *testing.T) {
+	tool := writeFakeTool(t, "echo 'All done! ✨ 🍰 ✨' >&2; echo '4 files would be left unchanged.' >&2\n")
+	res, err := checkBlack(context.Background(), nil, &CheckTarget{Dir: t.TempDir(), Config: &CheckConfig{Tool: tool}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Conclusion != "success" {
+		t.Errorf("got conclusion %q, want success", res.Conclusion)
+	}
+}
+
+func TestFixBlack(t *testing.T) {
+	tool := writeFakeTool(t, `[ "$1" = --quiet ] && [ "$2" = . ] && echo 'x = 1' > app.py`)
+	dir := t.TempDir()
+	writeTestFile(t, dir, "app.py", "x=1\n")
+	if err := fixBlack(context.Background(), nil, &CheckTarget{Dir: dir, Config: &CheckConfig{Tool: tool}}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "app.py"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "x = 1\n" {
+		t.Errorf("app.py has %q after the fix, want it reformatted", b)
+	}
+}
+
+func TestFixBlackFailure(t *testing.T) {
+	tool := writeFakeTool(t, "echo 'error: cannot format bad.py: Cannot parse' >&2; exit 123\n")
+	err := fixBlack(context.Background(), nil, &CheckTarget{Dir: t.TempDir(), Config: &CheckConfig{Tool: tool}})
+	if err == nil || !strings.Contains(err.Error(), "failed to reformat some files: error: cannot format bad.py") {
+		t.Errorf("got error %v, want the files black couldn't reformat reported", err)
+	}
+}
+
+func TestBlackReportsToolFailures(t *testing.T) {
+	testToolFailures(t, checkBlack, map[string]string{
+		"echo 'error: cannot format main.py: Cannot parse' >&2; exit 123": "cannot format main.py",
+		// black exits with 123 when it fails, although it lists the files it
+		// would reformat.
+		"echo 'would reformat app.py' >&2; echo 'error: cannot format bad.py: Cannot parse' >&2; exit 123": "cannot format bad.py",
+		"echo 'Oh no! 1 file would be reformatted.' >&2; exit 1":                                           "didn't list",
+	})
+}
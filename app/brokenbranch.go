@@ -0,0 +1,185 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/go-github/v43/github"
+)
+
+// brokenBranchLabel labels the issue of a broken default branch.
+const brokenBranchLabel = "broken-default-branch"
+
+const (
+	// maxCulpritCommits is how many commits of the default branch are
+	// searched for the last one that passed all checks.
+	maxCulpritCommits = 20
+	// maxFailingTargets is how many failure annotations of a check are
+	// listed in the issue.
+	maxFailingTargets = 20
+)
+
+// openLabeledIssue returns the most recent open issue labeled label, or nil if
+// there is none.
+func openLabeledIssue(ctx context.Context, ghc *github.Client, owner string, repo string, label string) (*github.Issue, error) {
+	issues, res, err := ghc.Issues.ListByRepo(ctx, owner, repo, &github.IssueListByRepoOptions{
+		State:       "open",
+		Labels:      []string{label},
+		ListOptions: github.ListOptions{PerPage: 1},
+	})
+	if err := extractError(ctx, res, err); err != nil {
+		return nil, err
+	}
+	if len(issues) == 0 {
+		return nil, nil
+	}
+	return issues[0], nil
+}
+
+// updateBrokenBranchIssue opens, updates or closes the issue of the job's
+// repository's default branch once all checks of the job's commit completed,
+// if the commit is still the head of the branch. results are the results of
+// the job's checks, which list their failing targets.
+func (app *GithubApp) updateBrokenBranchIssue(ctx context.Context, job *Job, results map[string]*Result) error {
+	owner, repo := job.ownerAndRepo()
+	ghc := app.jobClient(job)
+	repository, res, err := ghc.Repositories.Get(ctx, owner, repo)
+	if err := extractError(ctx, res, err); err != nil {
+		return err
+	}
+	branchName := repository.GetDefaultBranch()
+	branch, res, err := ghc.Repositories.GetBranch(ctx, owner, repo, branchName, true)
+	if err := extractError(ctx, res, err); err != nil {
+		return err
+	}
+	if branch.GetCommit().GetSHA() != job.HeadSHA {
+		return nil
+	}
+
+	// Keep concurrent jobs of the commit from opening an issue each.
+	unlock := lockSummary(job.FullRepoName + "#" + brokenBranchLabel)
+	defer unlock()
+	runs, err := listCheckRuns(ctx, ghc, job.AppID, owner, repo, job.HeadSHA)
+	if err != nil {
+		return err
+	}
+	delete(runs, aggregateCheckName)
+	conclusion := aggregateConclusion(runs)
+	if conclusion == "" {
+		// The last job of the commit to complete updates the issue.
+		return nil
+	}
+	issue, err := openLabeledIssue(ctx, ghc, owner, repo, brokenBranchLabel)
+	if err != nil {
+		return err
+	}
+
+	if conclusion == "success" {
+		if issue == nil {
+			return nil
+		}
+		comment := fmt.Sprintf("All checks pass again on %s.", job.HeadSHA)
+		_, res, err := ghc.Issues.CreateComment(ctx, owner, repo, issue.GetNumber(), &github.IssueComment{Body: github.String(comment)})
+		if err := extractError(ctx, res, err); err != nil {
+			return err
+		}
+		_, res, err = ghc.Issues.Edit(ctx, owner, repo, issue.GetNumber(), &github.IssueRequest{State: github.String("closed")})
+		if err := extractError(ctx, res, err); err != nil {
+			return err
+		}
+		logFrom(ctx).Infow("closed broken branch issue", "issue", issue.GetNumber())
+		return nil
+	}
+
+	report := brokenBranchReport(job.HeadSHA, runs, results)
+	if issue != nil {
+		_, res, err := ghc.Issues.CreateComment(ctx, owner, repo, issue.GetNumber(), &github.IssueComment{Body: github.String("Still failing.\n\n" + report)})
+		return extractError(ctx, res, err)
+	}
+	culprits, err := culpritCommits(ctx, ghc, job.AppID, repository, job.HeadSHA)
+	if err != nil {
+		logFrom(ctx).Warnw("failed to find culprit commits", "error", err)
+	}
+	issue, res, err = ghc.Issues.Create(ctx, owner, repo, &github.IssueRequest{
+		Title:  github.String(fmt.Sprintf("Checks are failing on %s", branchName)),
+		Body:   github.String(report + culprits),
+		Labels: &[]string{brokenBranchLabel},
+	})
+	if err := extractError(ctx, res, err); err != nil {
+		return err
+	}
+	logFrom(ctx).Infow("opened broken branch issue", "issue", issue.GetNumber())
+	return nil
+}
+
+// brokenBranchReport renders the failed runs of headSHA, with the failing
+// targets of those in results.
+func brokenBranchReport(headSHA string, runs map[string]*github.CheckRun, results map[string]*Result) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Checks failed on %s:\n\n", headSHA)
+	b.WriteString(summaryTable(runs))
+	names := make([]string, 0, len(runs))
+	for name := range runs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		run := runs[name]
+		if !failedCheck(run) {
+			continue
+		}
+		fmt.Fprintf(&b, "\n#### %s: %s\n", name, run.GetOutput().GetTitle())
+		if summary := run.GetOutput().GetSummary(); summary != "" {
+			fmt.Fprintf(&b, "%s\n", truncateText(summary, 2000))
+		}
+		result, ok := results[name]
+		if !ok {
+			continue
+		}
+		var failing []string
+		for _, a := range result.Annotations {
+			if a.Severity == "failure" {
+				failing = append(failing, fmt.Sprintf("- `%s:%d`: %s", a.Path, a.Line, a.Message))
+			}
+		}
+		if omitted := len(failing) - maxFailingTargets; omitted > 0 {
+			failing = append(failing[:maxFailingTargets], fmt.Sprintf("- ... and %d more", omitted))
+		}
+		if len(failing) > 0 {
+			fmt.Fprintf(&b, "\n%s\n", strings.Join(failing, "\n"))
+		}
+	}
+	return b.String()
+}
+
+// culpritCommits lists the commits of the default branch up to headSHA since
+// the last one that passed all checks of the app.
+func culpritCommits(ctx context.Context, ghc *github.Client, appID int64, repository *github.Repository, headSHA string) (string, error) {
+	owner, repo := repository.GetOwner().GetLogin(), repository.GetName()
+	commits, res, err := ghc.Repositories.ListCommits(ctx, owner, repo, &github.CommitsListOptions{
+		SHA:         headSHA,
+		ListOptions: github.ListOptions{PerPage: maxCulpritCommits},
+	})
+	if err := extractError(ctx, res, err); err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for i, c := range commits {
+		if i > 0 {
+			runs, err := listCheckRuns(ctx, ghc, appID, owner, repo, c.GetSHA())
+			if err != nil {
+				return "", err
+			}
+			delete(runs, aggregateCheckName)
+			if len(runs) > 0 && aggregateConclusion(runs) == "success" {
+				return fmt.Sprintf("\n### Culprits\n\nThe last commit that passed all checks is %s ([compare](%s/compare/%s...%s)):\n\n%s",
+					c.GetSHA(), repository.GetHTMLURL(), c.GetSHA(), headSHA, b.String()), nil
+			}
+		}
+		subject, _, _ := strings.Cut(c.GetCommit().GetMessage(), "\n")
+		fmt.Fprintf(&b, "- %s %s (%s)\n", c.GetSHA(), subject, c.GetCommit().GetAuthor().GetName())
+	}
+	return fmt.Sprintf("\n### Culprits\n\nNone of the last %d commits passed all checks.\n", len(commits)), nil
+}
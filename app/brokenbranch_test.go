@@ -0,0 +1,257 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v43/github"
+)
+
+// fakeBrokenBranch serves o/r, whose default branch main is at head, with the
+// check runs of its commits and the open issue labeled broken-default-branch,
+// if any, and records the changes made to issues.
+type fakeBrokenBranch struct {
+	head    string
+	commits []string
+	runs    map[string][]map[string]interface{}
+	open    []map[string]interface{}
+
+	created  []*github.IssueRequest
+	comments []*github.IssueComment
+	edits    []*github.IssueRequest
+}
+
+func (b *fakeBrokenBranch) serve(t *testing.T, f *fakeGitHub) {
+	f.handle("GET /repos/o/r", func(w http.ResponseWriter, req *http.Request) {
+		writeTestJSON(w, http.StatusOK, map[string]interface{}{
+			"name":           "r",
+			"full_name":      "o/r",
+			"owner":          map[string]interface{}{"login": "o"},
+			"default_branch": "main",
+			"html_url":       "https://github.com/o/r",
+		})
+	})
+	f.handle("GET /repos/o/r/branches/main", func(w http.ResponseWriter, req *http.Request) {
+		writeTestJSON(w, http.StatusOK, map[string]interface{}{"name": "main", "commit": map[string]interface{}{"sha": b.head}})
+	})
+	f.handle("GET /repos/o/r/commits", func(w http.ResponseWriter, req *http.Request) {
+		var commits []map[string]interface{}
+		for i, sha := range b.commits {
+			commits = append(commits, map[string]interface{}{
+				"sha":    sha,
+				"commit": map[string]interface{}{"message": fmt.Sprintf("Change %d\n\nDetails", i), "author": map[string]interface{}{"name": "Dev"}},
+			})
+		}
+		writeTestJSON(w, http.StatusOK, commits)
+	})
+	for sha := range b.runs {
+		sha := sha
+		f.handle("GET /repos/o/r/commits/"+sha+"/check-runs", func(w http.ResponseWriter, req *http.Request) {
+			writeTestJSON(w, http.StatusOK, map[string]interface{}{"total_count": len(b.runs[sha]), "check_runs": b.runs[sha]})
+		})
+	}
+	f.handle("GET /repos/o/r/issues", func(w http.ResponseWriter, req *http.Request) {
+		if got := req.URL.Query().Get("labels"); got != brokenBranchLabel {
+			t.Errorf("listed issues labeled %q, want %q", got, brokenBranchLabel)
+		}
+		writeTestJSON(w, http.StatusOK, b.open)
+	})
+	f.handle("POST /repos/o/r/issues", func(w http.ResponseWriter, req *http.Request) {
+		issue := &github.IssueRequest{}
+		if err := json.NewDecoder(req.Body).Decode(issue); err != nil {
+			t.Errorf("failed to decode issue: %s", err)
+		}
+		b.created = append(b.created, issue)
+		writeTestJSON(w, http.StatusCreated, map[string]interface{}{"number": 12})
+	})
+	f.handle("POST /repos/o/r/issues/7/comments", func(w http.ResponseWriter, req *http.Request) {
+		comment := &github.IssueComment{}
+		if err := json.NewDecoder(req.Body).Decode(comment); err != nil {
+			t.Errorf("failed to decode comment: %s", err)
+		}
+		b.comments = append(b.comments, comment)
+		writeTestJSON(w, http.StatusCreated, comment)
+	})
+	f.handle("PATCH /repos/o/r/issues/7", func(w http.ResponseWriter, req *http.Request) {
+		issue := &github.IssueRequest{}
+		if err := json.NewDecoder(req.Body).Decode(issue); err != nil {
+			t.Errorf("failed to decode issue update: %s", err)
+		}
+		b.edits = append(b.edits, issue)
+		writeTestJSON(w, http.StatusOK, map[string]interface{}{"number": 7})
+	})
+}
+
+func brokenBranchJob() *Job {
+	return &Job{InstallationID: testInstallationID, AppID: testAppID, FullRepoName: "o/r", HeadSHA: "abc", Token: "job-token"}
+}
+
+func TestBrokenBranchIssueEnabled(t *testing.T) {
+	setTestSettings(t, func(s *Settings) { s.BrokenBranchIssue = true })
+	off := false
+	if !(&RepoConfig{}).BrokenBranchIssueEnabled() || (&RepoConfig{BrokenBranchIssue: &off}).BrokenBranchIssueEnabled() {
+		t.Errorf("got the app-wide setting overridden incorrectly")
+	}
+}
+
+func TestUpdateBrokenBranchIssueOpensIssue(t *testing.T) {
+	f := newFakeGitHub(t)
+	b := &fakeBrokenBranch{
+		head:    "abc",
+		commits: []string{"abc", "def", "old"},
+		runs: map[string][]map[string]interface{}{
+			"abc": {
+				{"id": 1, "name": bazelTestCheck, "status": "completed", "conclusion": "failure", "output": map[string]interface{}{"title": "Test result", "summary": "1 tests failed, 2 passed."}},
+				{"id": 2, "name": buildifierCheck, "status": "completed", "conclusion": "success"},
+				{"id": 3, "name": aggregateCheckName, "status": "completed", "conclusion": "failure"},
+			},
+			"def": {{"id": 4, "name": bazelTestCheck, "status": "completed", "conclusion": "failure"}},
+			"old": {{"id": 5, "name": bazelTestCheck, "status": "completed", "conclusion": "success"}},
+		},
+	}
+	b.serve(t, f)
+	app := newTestApp(t, f)
+	results := map[string]*Result{bazelTestCheck: {Annotations: []*Annotation{
+		{Path: "a/BUILD", Line: 1, Message: "//a:a_test FAILED", Severity: "failure"},
+		{Path: "b/BUILD", Line: 1, Message: "//b:b_test FLAKY", Severity: "warning"},
+	}}}
+
+	if err := app.updateBrokenBranchIssue(context.Background(), brokenBranchJob(), results); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.created) != 1 {
+		t.Fatalf("created %d issues, want 1", len(b.created))
+	}
+	issue := b.created[0]
+	if issue.GetTitle() != "Checks are failing on main" || issue.Labels == nil || (*issue.Labels)[0] != brokenBranchLabel {
+		t.Errorf("got issue %+v, want one labeled %s", issue, brokenBranchLabel)
+	}
+	body := issue.GetBody()
+	for _, want := range []string{
+		"Checks failed on abc:",
+		"#### " + bazelTestCheck + ": Test result\n1 tests failed, 2 passed.\n",
+		"- `a/BUILD:1`: //a:a_test FAILED",
+		"The last commit that passed all checks is old ([compare](https://github.com/o/r/compare/old...abc)):\n\n- abc Change 0 (Dev)\n- def Change 1 (Dev)\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("issue body %q doesn't contain %q", body, want)
+		}
+	}
+	if strings.Contains(body, "//b:b_test") || strings.Contains(body, "#### "+buildifierCheck) || strings.Contains(body, "| "+aggregateCheckName+" |") {
+		t.Errorf("issue body %q lists more than the failures", body)
+	}
+}
+
+func TestUpdateBrokenBranchIssueCommentsWhileFailing(t *testing.T) {
+	f := newFakeGitHub(t)
+	b := &fakeBrokenBranch{
+		head: "abc",
+		runs: map[string][]map[string]interface{}{
+			"abc": {{"id": 1, "name": bazelTestCheck, "status": "completed", "conclusion": "failure"}},
+		},
+		open: []map[string]interface{}{{"number": 7}},
+	}
+	b.serve(t, f)
+	app := newTestApp(t, f)
+
+	if err := app.updateBrokenBranchIssue(context.Background(), brokenBranchJob(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.created) != 0 || len(b.comments) != 1 || len(b.edits) != 0 {
+		t.Fatalf("created %d issues, %d comments and %d edits, want a comment", len(b.created), len(b.comments), len(b.edits))
+	}
+	if body := b.comments[0].GetBody(); !strings.HasPrefix(body, "Still failing.\n\nChecks failed on abc:") {
+		t.Errorf("got comment %q, want the failures reported", body)
+	}
+}
+
+func TestUpdateBrokenBranchIssueClosesIssue(t *testing.T) {
+	f := newFakeGitHub(t)
+	b := &fakeBrokenBranch{
+		head: "abc",
+		runs: map[string][]map[string]interface{}{
+			"abc": {{"id": 1, "name": bazelTestCheck, "status": "completed", "conclusion": "success"}},
+		},
+		open: []map[string]interface{}{{"number": 7}},
+	}
+	b.serve(t, f)
+	app := newTestApp(t, f)
+
+	if err := app.updateBrokenBranchIssue(context.Background(), brokenBranchJob(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.comments) != 1 || b.comments[0].GetBody() != "All checks pass again on abc." {
+		t.Errorf("got comments %+v, want the fix announced", b.comments)
+	}
+	if len(b.edits) != 1 || b.edits[0].GetState() != "closed" {
+		t.Errorf("got edits %+v, want the issue closed", b.edits)
+	}
+}
+
+func TestUpdateBrokenBranchIssueIgnoresOtherCommits(t *testing.T) {
+	for name, b := range map[string]*fakeBrokenBranch{
+		// The branch moved on to another commit.
+		"not head": {
+			head: "new",
+			runs: map[string][]map[string]interface{}{"abc": {{"id": 1, "name": bazelTestCheck, "status": "completed", "conclusion": "failure"}}},
+		},
+		// Another job of the commit is still running.
+		"in progress": {
+			head: "abc",
+			runs: map[string][]map[string]interface{}{"abc": {
+				{"id": 1, "name": bazelTestCheck, "status": "completed", "conclusion": "failure"},
+				{"id": 2, "name": nogoCheck, "status": inProgress},
+			}},
+		},
+	} {
+		f := newFakeGitHub(t)
+		b.serve(t, f)
+		app := newTestApp(t, f)
+		if err := app.updateBrokenBranchIssue(context.Background(), brokenBranchJob(), nil); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if n := f.count("GET /repos/o/r/issues"); n != 0 || len(b.created) != 0 {
+			t.Errorf("%s: listed issues %d times and created %d, want the issue left alone", name, n, len(b.created))
+		}
+	}
+}
+
+func TestBrokenBranchReportLimitsFailingTargets(t *testing.T) {
+	runs := map[string]*github.CheckRun{bazelTestCheck: {Name: github.String(bazelTestCheck), Status: github.String("completed"), Conclusion: github.String("failure")}}
+	result := &Result{}
+	for i := 0; i < maxFailingTargets+5; i++ {
+		result.Annotations = append(result.Annotations, &Annotation{Path: "BUILD", Line: i + 1, Message: "failed", Severity: "failure"})
+	}
+
+	report := brokenBranchReport("abc", runs, map[string]*Result{bazelTestCheck: result})
+	if n := strings.Count(report, "- `BUILD:"); n != maxFailingTargets {
+		t.Errorf("listed %d failing targets, want %d", n, maxFailingTargets)
+	}
+	if !strings.Contains(report, "- ... and 5 more") {
+		t.Errorf("report %q doesn't count the omitted targets", report)
+	}
+}
+
+func TestCulpritCommitsWithoutPassingCommit(t *testing.T) {
+	f := newFakeGitHub(t)
+	b := &fakeBrokenBranch{
+		commits: []string{"abc", "def"},
+		runs:    map[string][]map[string]interface{}{"def": {}},
+	}
+	b.serve(t, f)
+	ghc := newTestApp(t, f).jobClient(brokenBranchJob())
+	repository := &github.Repository{Name: github.String("r"), Owner: &github.User{Login: github.String("o")}}
+
+	culprits, err := culpritCommits(context.Background(), ghc, testAppID, repository, "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Commits without check runs weren't checked, so they didn't pass.
+	if want := "\n### Culprits\n\nNone of the last 2 commits passed all checks.\n"; culprits != want {
+		t.Errorf("got culprits %q, want %q", culprits, want)
+	}
+}
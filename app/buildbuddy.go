@@ -0,0 +1,234 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// UseBuildBuddy runs the bazel of checks with bb, authenticated with the
+	// repository's BuildBuddy API key. When it's false, checks run plain
+	// bazel with BazelFlags and don't need an API key, for teams that don't
+	// use BuildBuddy.
+	UseBuildBuddy = true
+)
+
+// maxTestLogBytes is the size of the largest test log fetched from
+// BuildBuddy. Only the end of the log is shown anyway.
+const maxTestLogBytes = 1 << 20
+
+// protoInt64 is an int64 in the JSON mapping of protocol buffers, which
+// encodes them as strings.
+type protoInt64 int64
+
+func (i *protoInt64) UnmarshalJSON(b []byte) error {
+	s := strings.Trim(string(b), `"`)
+	if s == "" || s == "null" {
+		return nil
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	*i = protoInt64(n)
+	return err
+}
+
+// bbInvocation is an invocation returned by the BuildBuddy API.
+type bbInvocation struct {
+	Success      bool       `json:"success"`
+	DurationUsec protoInt64 `json:"duration_usec"`
+	ActionCount  protoInt64 `json:"action_count"`
+	Command      string     `json:"command"`
+}
+
+// bbTarget is a target of an invocation returned by the BuildBuddy API.
+type bbTarget struct {
+	ID struct {
+		Label string `json:"label"`
+	} `json:"id"`
+	Status string `json:"status"`
+}
+
+// buildBuddyClient calls the BuildBuddy API with a repository's API key.
+type buildBuddyClient struct {
+	url    string
+	apiKey string
+	client *http.Client
+}
+
+func newBuildBuddyClient(apiKey string) *buildBuddyClient {
+	return &buildBuddyClient{
+		url:    strings.TrimSuffix(CurrentSettings().BuildBuddyURL, "/"),
+		apiKey: apiKey,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// call posts req to an API method and decodes its response into res.
+func (c *buildBuddyClient) call(ctx context.Context, method string, req interface{}, res interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url+"/api/v1/"+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("x-buildbuddy-api-key", c.apiKey)
+	httpRes, err := c.client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("BuildBuddy %s failed: %s", method, err)
+	}
+	defer httpRes.Body.Close()
+	if httpRes.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(httpRes.Body, 1024))
+		return fmt.Errorf("BuildBuddy %s failed with status %d: %s", method, httpRes.StatusCode, msg)
+	}
+	if w, ok := res.(io.Writer); ok {
+		_, err = io.Copy(w, io.LimitReader(httpRes.Body, maxTestLogBytes))
+		return err
+	}
+	return json.NewDecoder(httpRes.Body).Decode(res)
+}
+
+// invocation returns the invocation with id, polling until BuildBuddy has
+// processed it or ctx is done.
+func (c *buildBuddyClient) invocation(ctx context.Context, id string) (*bbInvocation, error) {
+	req := map[string]interface{}{"selector": map[string]string{"invocation_id": id}}
+	backoff := time.Second
+	for {
+		res := &struct {
+			Invocation []*bbInvocation `json:"invocation"`
+		}{}
+		if err := c.call(ctx, "GetInvocation", req, res); err != nil {
+			return nil, err
+		}
+		if len(res.Invocation) > 0 {
+			return res.Invocation[0], nil
+		}
+		if err := sleepContext(ctx, backoff); err != nil {
+			return nil, fmt.Errorf("invocation %s wasn't processed in time", id)
+		}
+		backoff *= 2
+	}
+}
+
+// targets returns the targets of the invocation with id.
+func (c *buildBuddyClient) targets(ctx context.Context, id string) ([]*bbTarget, error) {
+	req := map[string]interface{}{"selector": map[string]string{"invocation_id": id}}
+	var targets []*bbTarget
+	for {
+		res := &struct {
+			Target        []*bbTarget `json:"target"`
+			NextPageToken string      `json:"next_page_token"`
+		}{}
+		if err := c.call(ctx, "GetTarget", req, res); err != nil {
+			return nil, err
+		}
+		targets = append(targets, res.Target...)
+		if res.NextPageToken == "" {
+			return targets, nil
+		}
+		req["page_token"] = res.NextPageToken
+	}
+}
+
+// file returns the contents of a build output by its bytestream URI, up to
+// maxTestLogBytes.
+func (c *buildBuddyClient) file(ctx context.Context, uri string) (string, error) {
+	var b bytes.Buffer
+	if err := c.call(ctx, "GetFile", map[string]string{"uri": uri}, &b); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// invocationID returns the ID of the invocation at a BuildBuddy invocation
+// URL, e.g. https://app.buildbuddy.io/invocation/<id>.
+func invocationID(invocationURL string) string {
+	i := strings.Index(invocationURL, "/invocation/")
+	if i < 0 {
+		return ""
+	}
+	id := invocationURL[i+len("/invocation/"):]
+	if j := strings.IndexAny(id, "?#/"); j >= 0 {
+		id = id[:j]
+	}
+	return id
+}
+
+// enrichResult adds statistics of the invocation behind res.URL from the
+// BuildBuddy API and the build event stream to the summary of res, and the
+// logs of failed tests to its text. Failures to reach BuildBuddy are only
+// logged, since the result is complete without them.
+func enrichResult(ctx context.Context, apiKey string, res *Result, bep *bepOutput) {
+	id := invocationID(res.URL)
+	if !UseBuildBuddy || CurrentSettings().BuildBuddyURL == "" || id == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, CurrentSettings().BuildBuddyPollTimeout)
+	defer cancel()
+	c := newBuildBuddyClient(apiKey)
+
+	var rows [][2]string
+	invocation, err := c.invocation(ctx, id)
+	if err != nil {
+		logFrom(ctx).Warnw("failed to get invocation from BuildBuddy", "invocation_id", id, "error", err)
+	} else {
+		rows = append(rows,
+			[2]string{"Duration", (time.Duration(invocation.DurationUsec) * time.Microsecond).Round(time.Second).String()},
+			[2]string{"Actions", strconv.FormatInt(int64(invocation.ActionCount), 10)})
+	}
+	targets, err := c.targets(ctx, id)
+	if err != nil {
+		logFrom(ctx).Warnw("failed to get targets from BuildBuddy", "invocation_id", id, "error", err)
+	} else if len(targets) > 0 {
+		counts := make(map[string]int)
+		for _, t := range targets {
+			counts[strings.ToLower(strings.ReplaceAll(t.Status, "_", " "))]++
+		}
+		var statuses []string
+		for status, n := range counts {
+			statuses = append(statuses, fmt.Sprintf("%d %s", n, status))
+		}
+		sort.Strings(statuses)
+		rows = append(rows, [2]string{"Targets", fmt.Sprintf("%d (%s)", len(targets), strings.Join(statuses, ", "))})
+	}
+	if bep != nil && bep.executedActions > 0 {
+		rows = append(rows, [2]string{"Cache hit rate", fmt.Sprintf("%.0f%% (%d of %d actions)",
+			100*float64(bep.cacheHits)/float64(bep.executedActions), bep.cacheHits, bep.executedActions)})
+	}
+	if len(rows) > 0 {
+		var b strings.Builder
+		b.WriteString("\n\n| | |\n| --- | --- |\n")
+		for _, row := range rows {
+			fmt.Fprintf(&b, "| %s | %s |\n", row[0], row[1])
+		}
+		res.Summary += b.String()
+	}
+
+	if bep == nil {
+		return
+	}
+	var logs strings.Builder
+	for _, label := range bep.failedTestLabels() {
+		log, ok := readFileURI(bep.testLogs[label])
+		if !ok {
+			if log, err = c.file(ctx, bep.testLogs[label]); err != nil {
+				logFrom(ctx).Warnw("failed to get test log from BuildBuddy", "target", label, "error", err)
+				continue
+			}
+		}
+		fmt.Fprintf(&logs, "\n\n#### `%s` test log\n%s", label, logBlock(log))
+	}
+	if logs.Len() > 0 {
+		res.Text = truncateText(res.Text+logs.String(), maxCheckRunText)
+	}
+}
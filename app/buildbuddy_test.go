@@ -0,0 +1,182 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeBuildBuddy serves the BuildBuddy API methods in handlers for the
+// duration of the test, checking the API key of every request.
+func fakeBuildBuddy(t *testing.T, handlers map[string]func(req map[string]interface{}) interface{}) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key := r.Header.Get("x-buildbuddy-api-key"); key != "bb-key" {
+			t.Errorf("%s called with API key %q", r.URL.Path, key)
+		}
+		h, ok := handlers[strings.TrimPrefix(r.URL.Path, "/api/v1/")]
+		if !ok {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		req := make(map[string]interface{})
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("failed to decode %s request: %s", r.URL.Path, err)
+		}
+		res := h(req)
+		if s, ok := res.(string); ok {
+			w.Write([]byte(s))
+			return
+		}
+		writeTestJSON(w, http.StatusOK, res)
+	}))
+	t.Cleanup(s.Close)
+	setBuildBuddyURL(t, s.URL)
+}
+
+// setBuildBuddyURL points BuildBuddyURL at url for the duration of the test.
+func setBuildBuddyURL(t *testing.T, url string) {
+	setTestSettings(t, func(s *Settings) { s.BuildBuddyURL = url })
+}
+
+func TestProtoInt64(t *testing.T) {
+	var v struct {
+		A protoInt64 `json:"a"`
+		B protoInt64 `json:"b"`
+		C protoInt64 `json:"c"`
+	}
+	if err := json.Unmarshal([]byte(`{"a": "12345678901", "b": 7, "c": null}`), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.A != 12345678901 || v.B != 7 || v.C != 0 {
+		t.Errorf("got %+v, want 12345678901, 7 and 0", v)
+	}
+	if err := json.Unmarshal([]byte(`{"a": "x"}`), &v); err == nil {
+		t.Errorf("parsed an invalid int64")
+	}
+}
+
+func TestInvocationID(t *testing.T) {
+	for url, want := range map[string]string{
+		"https://app.buildbuddy.io/invocation/abc-123":              "abc-123",
+		"https://app.buildbuddy.io/invocation/abc-123?target=//x:y": "abc-123",
+		"https://app.buildbuddy.io/invocation/abc-123#timing":       "abc-123",
+		"https://example.com/build/42":                              "",
+		"":                                                          "",
+	} {
+		if got := invocationID(url); got != want {
+			t.Errorf("invocationID(%q) = %q, want %q", url, got, want)
+		}
+	}
+}
+
+func TestEnrichResult(t *testing.T) {
+	var mu sync.Mutex
+	var pages []interface{}
+	fakeBuildBuddy(t, map[string]func(map[string]interface{}) interface{}{
+		"GetInvocation": func(req map[string]interface{}) interface{} {
+			if id := req["selector"].(map[string]interface{})["invocation_id"]; id != "abc" {
+				t.Errorf("got invocation %v, want abc", id)
+			}
+			return map[string]interface{}{"invocation": []map[string]interface{}{{"success": false, "duration_usec": "95000000", "action_count": "42"}}}
+		},
+		"GetTarget": func(req map[string]interface{}) interface{} {
+			mu.Lock()
+			defer mu.Unlock()
+			pages = append(pages, req["page_token"])
+			if req["page_token"] == nil {
+				return map[string]interface{}{
+					"target":          []map[string]interface{}{{"id": map[string]string{"label": "//a"}, "status": "BUILT"}, {"id": map[string]string{"label": "//b"}, "status": "FAILED_TO_BUILD"}},
+					"next_page_token": "2",
+				}
+			}
+			return map[string]interface{}{"target": []map[string]interface{}{{"id": map[string]string{"label": "//c"}, "status": "BUILT"}}}
+		},
+		"GetFile": func(req map[string]interface{}) interface{} {
+			if req["uri"] != "bytestream://remote/blobs/log/1" {
+				t.Errorf("got file %v", req["uri"])
+			}
+			return "remote test failed\n"
+		},
+	})
+	dir := t.TempDir()
+	writeTestFile(t, dir, "test.log", "local test failed\n")
+	bep := &bepOutput{
+		executedActions: 40,
+		cacheHits:       30,
+		tests:           []string{"//local:test", "//remote:test", "//ok:test"},
+		testStatus:      map[string]string{"//local:test": "FAILED", "//remote:test": "TIMEOUT", "//ok:test": "PASSED"},
+		testLogs: map[string]string{
+			"//local:test":  "file://" + filepath.Join(dir, "test.log"),
+			"//remote:test": "bytestream://remote/blobs/log/1",
+			"//ok:test":     "file://" + filepath.Join(dir, "missing.log"),
+		},
+	}
+	res := &Result{Summary: "Tests failed", URL: "https://app.buildbuddy.io/invocation/abc", Text: "table"}
+	enrichResult(context.Background(), "bb-key", res, bep)
+
+	want := "Tests failed\n\n| | |\n| --- | --- |\n" +
+		"| Duration | 1m35s |\n" +
+		"| Actions | 42 |\n" +
+		"| Targets | 3 (1 failed to build, 2 built) |\n" +
+		"| Cache hit rate | 75% (30 of 40 actions) |\n"
+	if res.Summary != want {
+		t.Errorf("got summary %q, want %q", res.Summary, want)
+	}
+	wantText := "table\n\n#### `//local:test` test log\n```\nlocal test failed\n```\n\n#### `//remote:test` test log\n```\nremote test failed\n```"
+	if res.Text != wantText {
+		t.Errorf("got text %q, want %q", res.Text, wantText)
+	}
+	if len(pages) != 2 {
+		t.Errorf("got target pages %v, want both pages", pages)
+	}
+}
+
+func TestEnrichResultPollsUntilInvocationIsProcessed(t *testing.T) {
+	calls := 0
+	fakeBuildBuddy(t, map[string]func(map[string]interface{}) interface{}{
+		"GetInvocation": func(map[string]interface{}) interface{} {
+			calls++
+			if calls == 1 {
+				return map[string]interface{}{}
+			}
+			return map[string]interface{}{"invocation": []map[string]interface{}{{"duration_usec": "1000000", "action_count": "1"}}}
+		},
+		"GetTarget": func(map[string]interface{}) interface{} { return map[string]interface{}{} },
+	})
+	res := &Result{URL: "https://app.buildbuddy.io/invocation/abc"}
+	enrichResult(context.Background(), "bb-key", res, nil)
+	if calls != 2 || !strings.Contains(res.Summary, "| Duration | 1s |") {
+		t.Errorf("got summary %q after %d calls, want the processed invocation", res.Summary, calls)
+	}
+}
+
+func TestEnrichResultWithoutBuildBuddy(t *testing.T) {
+	fakeBuildBuddy(t, nil)
+	res := &Result{Summary: "Build failed", URL: "https://app.buildbuddy.io/invocation/abc"}
+	enrichResult(context.Background(), "bb-key", res, &bepOutput{})
+	if res.Summary != "Build failed" || res.Text != "" {
+		t.Errorf("got summary %q and text %q, want the result unchanged when BuildBuddy fails", res.Summary, res.Text)
+	}
+
+	setTestSettings(t, func(s *Settings) { s.BuildBuddyPollTimeout = 10 * time.Millisecond })
+	fakeBuildBuddy(t, map[string]func(map[string]interface{}) interface{}{
+		"GetInvocation": func(map[string]interface{}) interface{} { return map[string]interface{}{} },
+	})
+	enrichResult(context.Background(), "bb-key", res, nil)
+	if res.Summary != "Build failed" {
+		t.Errorf("got summary %q, want the result unchanged when the invocation isn't processed in time", res.Summary)
+	}
+
+	setBuildBuddyURL(t, "")
+	res.URL = "http://127.0.0.1:1/invocation/abc"
+	enrichResult(context.Background(), "bb-key", res, &bepOutput{executedActions: 1})
+	if res.Summary != "Build failed" {
+		t.Errorf("got summary %q with BuildBuddy disabled, want it unchanged", res.Summary)
+	}
+}
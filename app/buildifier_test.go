@@ -0,0 +1,113 @@
+package app
+
+import (
+	"context"
+	"testing"
+)
+
+// installFakeBuildifier installs a buildifier that prints report, with $dir
+// replaced by the directory it is asked to check.
+func installFakeBuildifier(t *testing.T, report string) {
+	installFakeTool(t, "buildifier", "for dir; do :; done\ncat <<REPORT\n"+report+"\nREPORT\nexit 4\n")
+}
+
+func TestCheckBuildifier(t *testing.T) {
+	installFakeBuildifier(t, `{"success": false, "files": [
+  {"filename": "$dir/BUILD", "formatted": false, "valid": true, "warnings": [
+    {"start": {"line": 3, "column": 1}, "category": "load", "message": "Loaded symbol \"x\" is unused.", "url": "https://example.com/load"}
+  ]},
+  {"filename": "$dir/lib/defs.bzl", "formatted": true, "valid": true, "warnings": [
+    {"start": {"line": 7, "column": 5}, "category": "native-build", "message": "The \"native\" module shouldn't be used in BUILD files."}
+  ]},
+  {"filename": "$dir/broken/BUILD", "formatted": false, "valid": false}
+]}`)
+	target := &CheckTarget{Dir: t.TempDir(), Config: &CheckConfig{FailureCategories: []string{"native-build"}}}
+	res, err := checkBuildifier(context.Background(), nil, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Conclusion != "failure" || res.Summary != "3 issues must be fixed, 1 warnings" {
+		t.Errorf("got %s with summary %q, want a failure with 3 issues and 1 warning", res.Conclusion, res.Summary)
+	}
+	want := []Annotation{
+		{Path: "BUILD", Line: 1, Message: `file "BUILD" needs reformat`, Severity: "failure", WholeFile: true},
+		{Path: "BUILD", Line: 3, Column: 1, Message: `[load] Loaded symbol "x" is unused. (https://example.com/load)`, Severity: "warning"},
+		{Path: "lib/defs.bzl", Line: 7, Column: 5, Message: `[native-build] The "native" module shouldn't be used in BUILD files.`, Severity: "failure"},
+		{Path: "broken/BUILD", Line: 1, Message: `file "broken/BUILD" can't be parsed`, Severity: "failure", WholeFile: true},
+	}
+	if len(res.Annotations) != len(want) {
+		t.Fatalf("got %d annotations, want %d", len(res.Annotations), len(want))
+	}
+	for i, a := range res.Annotations {
+		if *a != want[i] {
+			t.Errorf("annotation %d: got %+v, want %+v", i, *a, want[i])
+		}
+	}
+	if res.Action == nil {
+		t.Errorf("failed result has no fix action")
+	}
+}
+
+func TestCheckBuildifierWithOnlyWarnings(t *testing.T) {
+	installFakeBuildifier(t, `{"success": false, "files": [
+  {"filename": "$dir/BUILD", "formatted": true, "valid": true, "warnings": [
+    {"start": {"line": 3, "column": 1}, "category": "load", "message": "Loaded symbol \"x\" is unused."}
+  ]}
+]}`)
+	res, err := checkBuildifier(context.Background(), nil, &CheckTarget{Dir: t.TempDir(), Config: &CheckConfig{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Conclusion != "neutral" || res.Action != nil {
+		t.Errorf("got %s with action %+v, want warnings alone to be neutral without a fix", res.Conclusion, res.Action)
+	}
+}
+
+func TestCheckBuildifierWithoutBuildFiles(t *testing.T) {
+	installFakeBuildifier(t, `{"success": true, "files": []}`)
+	res, err := checkBuildifier(context.Background(), nil, &CheckTarget{Dir: t.TempDir(), Config: &CheckConfig{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Conclusion != "neutral" || res.Summary != "No BUILD or .bzl files found." {
+		t.Errorf("got %s with summary %q, want neutral without BUILD files", res.Conclusion, res.Summary)
+	}
+}
+
+func TestCheckBuildifierWithoutOutput(t *testing.T) {
+	installFakeTool(t, "buildifier", "echo 'unknown flag: --format' >&2; exit 2\n")
+	if _, err := checkBuildifier(context.Background(), nil, &CheckTarget{Dir: t.TempDir(), Config: &CheckConfig{}}); err == nil {
+		t.Errorf("check succeeded although buildifier printed no report")
+	}
+}
+
+func TestCompletedCheckRunOptionsActionRequired(t *testing.T) {
+	opts := createCompletedUpdateCheckRunOptions(&Result{Conclusion: "action_required", Title: "Sign the CLA"}, "cla")
+	if opts.GetConclusion() != "failure" {
+		t.Errorf("got conclusion %q without a URL, want failure", opts.GetConclusion())
+	}
+	opts = createCompletedUpdateCheckRunOptions(&Result{Conclusion: "action_required", Title: "Sign the CLA", URL: "https://example.com/cla"}, "cla")
+	if opts.GetConclusion() != "action_required" || opts.GetDetailsURL() != "https://example.com/cla" {
+		t.Errorf("got conclusion %q with details URL %q, want action_required linking to the URL", opts.GetConclusion(), opts.GetDetailsURL())
+	}
+}
+
+func TestCompletedCheckRunOptionsIncludeColumns(t *testing.T) {
+	opts := createCompletedUpdateCheckRunOptions(&Result{
+		Title: "Buildifier Lint Result",
+		Annotations: []*Annotation{
+			{Path: "BUILD", Line: 3, Column: 5, Message: "unused load", Severity: "warning"},
+			{Path: "BUILD", Line: 1, Message: "needs reformat", Severity: "failure"},
+		},
+	}, buildifierCheck)
+	annotations := opts.Output.Annotations
+	if len(annotations) != 2 {
+		t.Fatalf("got %d annotations, want 2", len(annotations))
+	}
+	if annotations[0].GetStartColumn() != 5 || annotations[0].GetEndColumn() != 5 {
+		t.Errorf("got columns %d-%d, want 5", annotations[0].GetStartColumn(), annotations[0].GetEndColumn())
+	}
+	if annotations[1].StartColumn != nil || annotations[1].EndColumn != nil {
+		t.Errorf("got columns for an annotation without a column")
+	}
+}
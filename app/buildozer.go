@@ -0,0 +1,98 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// buildozerActionPrefix starts the requested action identifiers of buildozer
+// actions, followed by the action's index in the check's configuration.
+const buildozerActionPrefix = "buildozer-"
+
+// buildozerCommandsFile holds the commands of a buildozer action while it
+// runs.
+const buildozerCommandsFile = ".reviewbot-buildozer-commands"
+
+// BuildozerAction is a one-click BUILD file change offered on failed check
+// runs, configured in .reviewbot.yaml. For example:
+//
+//	buildozer_actions:
+//	  - label: Add go_test size
+//	    description: Mark go tests as small.
+//	    commands:
+//	      - "set size small|//...:%go_test"
+type BuildozerAction struct {
+	// Label is the button's text, at most 20 characters.
+	Label string `yaml:"label"`
+	// Description is shown next to the button, at most 40 characters.
+	Description string `yaml:"description"`
+	// Commands are buildozer commands in the format of `buildozer -f`, i.e.
+	// `<command>|<target>`.
+	Commands []string `yaml:"commands"`
+}
+
+// buildozerActionIndex parses the identifier of a buildozer action.
+func buildozerActionIndex(identifier string) (int, bool) {
+	if !strings.HasPrefix(identifier, buildozerActionPrefix) {
+		return 0, false
+	}
+	i, err := strconv.Atoi(strings.TrimPrefix(identifier, buildozerActionPrefix))
+	if err != nil || i < 0 {
+		return 0, false
+	}
+	return i, true
+}
+
+// buildozerActions returns the actions offered for the check's buildozer
+// actions.
+func (c *CheckConfig) buildozerActions() []*Action {
+	var actions []*Action
+	for i, a := range c.BuildozerActions {
+		actions = append(actions, &Action{
+			Label:       a.Label,
+			Description: a.Description,
+			Identifier:  fmt.Sprintf("%s%d", buildozerActionPrefix, i),
+		})
+	}
+	return actions
+}
+
+// buildozerFixer runs the commands of a BuildozerAction as a Fixer.
+type buildozerFixer struct {
+	action *BuildozerAction
+}
+
+func (f *buildozerFixer) Fix(ctx context.Context, _ *GithubApp, target *CheckTarget) error {
+	if len(f.action.Commands) == 0 {
+		return fmt.Errorf("buildozer action %q has no commands", f.action.Label)
+	}
+	// The commands file is written into the checkout, so that it is visible
+	// to sandboxed tools. It is removed before the fix is committed.
+	commands := filepath.Join(target.Dir, buildozerCommandsFile)
+	if err := os.WriteFile(commands, []byte(strings.Join(f.action.Commands, "\n")+"\n"), 0644); err != nil {
+		return err
+	}
+	defer os.Remove(commands)
+	buildozer, err := buildtool(ctx, "buildozer", target)
+	if err != nil {
+		return err
+	}
+	// buildozer exits with 3 when the commands didn't change anything.
+	_, stdErr, err := runCheckCmd(ctx, target, buildozer, "-f", buildozerCommandsFile)
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 3 {
+		return fmt.Errorf("buildozer action %q didn't change any BUILD files", f.action.Label)
+	}
+	if err != nil {
+		return fmt.Errorf("buildozer failed: %s: %s", err, stdErr.String())
+	}
+	return nil
+}
+
+func (f *buildozerFixer) FixCommitMessage() string {
+	return fmt.Sprintf("Apply buildozer action %q", f.action.Label)
+}
@@ -0,0 +1,138 @@
+package app
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuildozerActionIndex(t *testing.T) {
+	for identifier, want := range map[string]int{"buildozer-0": 0, "buildozer-2": 2, "buildozer--1": -1, "buildozer-x": -1, "fix-gofmt": -1} {
+		i, ok := buildozerActionIndex(identifier)
+		if want < 0 && ok {
+			t.Errorf("buildozerActionIndex(%q) = %d, want it to not be a buildozer action", identifier, i)
+		}
+		if want >= 0 && (!ok || i != want) {
+			t.Errorf("buildozerActionIndex(%q) = %d, %t, want %d", identifier, i, ok, want)
+		}
+	}
+}
+
+func TestBuildozerActions(t *testing.T) {
+	config := &CheckConfig{BuildozerActions: []*BuildozerAction{
+		{Label: "Size tests", Description: "Mark go tests as small."},
+		{Label: "Public", Description: "Make libraries public."},
+	}}
+	actions := config.buildozerActions()
+	if len(actions) != 2 {
+		t.Fatalf("got %d actions, want 2", len(actions))
+	}
+	for i, want := range []Action{
+		{Label: "Size tests", Description: "Mark go tests as small.", Identifier: "buildozer-0"},
+		{Label: "Public", Description: "Make libraries public.", Identifier: "buildozer-1"},
+	} {
+		if *actions[i] != want {
+			t.Errorf("action %d: got %+v, want %+v", i, *actions[i], want)
+		}
+	}
+}
+
+func TestBuildozerFixer(t *testing.T) {
+	commands := filepath.Join(t.TempDir(), "commands")
+	installFakeTool(t, "buildozer", `cp "$2" `+commands+"\n")
+	dir := t.TempDir()
+	fixer := &buildozerFixer{action: &BuildozerAction{
+		Label:    "Size tests",
+		Commands: []string{"set size small|//...:%go_test", "add tags manual|//app:e2e"},
+	}}
+	if err := fixer.Fix(context.Background(), nil, &CheckTarget{Dir: dir, Config: &CheckConfig{}}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(commands)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "set size small|//...:%go_test\nadd tags manual|//app:e2e\n" {
+		t.Errorf("ran buildozer with commands %q", b)
+	}
+	if _, err := os.Stat(filepath.Join(dir, buildozerCommandsFile)); !os.IsNotExist(err) {
+		t.Errorf("got %v for the commands file, want it removed", err)
+	}
+	if got := fixer.FixCommitMessage(); got != `Apply buildozer action "Size tests"` {
+		t.Errorf("got commit message %q", got)
+	}
+}
+
+func TestBuildozerFixerWithoutChanges(t *testing.T) {
+	installFakeTool(t, "buildozer", "exit 3\n")
+	fixer := &buildozerFixer{action: &BuildozerAction{Label: "Size tests", Commands: []string{"set size small|//...:*"}}}
+	err := fixer.Fix(context.Background(), nil, &CheckTarget{Dir: t.TempDir(), Config: &CheckConfig{}})
+	if err == nil || !strings.Contains(err.Error(), "didn't change any BUILD files") {
+		t.Errorf("got error %v, want buildozer's exit code 3 to be reported", err)
+	}
+
+	fixer = &buildozerFixer{action: &BuildozerAction{Label: "Empty"}}
+	if err := fixer.Fix(context.Background(), nil, &CheckTarget{Dir: t.TempDir(), Config: &CheckConfig{}}); err == nil {
+		t.Errorf("ran a buildozer action without commands")
+	}
+}
+
+func TestFixerFor(t *testing.T) {
+	fix := &funcChecker{name: "test-fix", fixFn: func(ctx context.Context, app *GithubApp, target *CheckTarget) error { return nil }}
+	registerTestChecker(t, fix)
+	registerTestChecker(t, &funcChecker{name: "test-nofix"})
+	config := &CheckConfig{BuildozerActions: []*BuildozerAction{{Label: "Size tests"}}}
+
+	if fixer, err := fixerFor("test-fix", fixIdentifier("test-fix"), config); err != nil || fixer != Fixer(fix) {
+		t.Errorf("got %v, %v for the fix action, want the check", fixer, err)
+	}
+	if fixer, err := fixerFor("test-nofix", "buildozer-0", config); err != nil || fixer.(*buildozerFixer).action != config.BuildozerActions[0] {
+		t.Errorf("got %v, %v for the buildozer action, want its fixer", fixer, err)
+	}
+	for _, tc := range []struct{ check, identifier string }{
+		{"test-nofix", "buildozer-1"},
+		{"test-nofix", fixIdentifier("test-nofix")},
+		{"test-fix", "other"},
+	} {
+		if _, err := fixerFor(tc.check, tc.identifier, config); err == nil {
+			t.Errorf("got a fixer for %s on %s", tc.identifier, tc.check)
+		}
+	}
+}
+
+func TestCompletedCheckRunOptionsIncludeActions(t *testing.T) {
+	fix := newFixAction("test-fix", "Fix it.")
+	config := &CheckConfig{BuildozerActions: []*BuildozerAction{{Label: "a"}, {Label: "b"}, {Label: "c"}}}
+	opts := createCompletedUpdateCheckRunOptions(&Result{Action: fix, Actions: config.buildozerActions()}, "test-fix")
+	if len(opts.Actions) != maxCheckRunActions {
+		t.Fatalf("got %d actions, want %d", len(opts.Actions), maxCheckRunActions)
+	}
+	for i, want := range []string{fix.Identifier, "buildozer-0", "buildozer-1"} {
+		if opts.Actions[i].Identifier != want {
+			t.Errorf("action %d: got %q, want %q", i, opts.Actions[i].Identifier, want)
+		}
+	}
+}
+
+func TestRunJobCheckOffersBuildozerActionsOnFailure(t *testing.T) {
+	conclusion := "failure"
+	registerTestChecker(t, &funcChecker{
+		name: "test-lint",
+		fn: func(ctx context.Context, _ *GithubApp, _ *CheckTarget) (*Result, error) {
+			return &Result{Title: "Lint", Summary: "Done", Conclusion: conclusion}, nil
+		},
+	})
+	config := &CheckConfig{BuildozerActions: []*BuildozerAction{{Label: "Size tests", Description: "Mark go tests as small."}}}
+
+	opts := runTestJobCheck(t, "test-lint", config, t.TempDir())
+	if len(opts.Actions) != 1 || opts.Actions[0].Identifier != "buildozer-0" || opts.Actions[0].Label != "Size tests" {
+		t.Errorf("got actions %+v on a failed run, want the buildozer action", opts.Actions)
+	}
+
+	conclusion = "success"
+	if opts := runTestJobCheck(t, "test-lint", config, t.TempDir()); len(opts.Actions) != 0 {
+		t.Errorf("got actions %+v on a successful run, want none", opts.Actions)
+	}
+}
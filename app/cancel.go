@@ -0,0 +1,118 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// runningChecks tracks how to cancel the checks currently running, keyed by
+// inFlightKey.
+type runningChecks struct {
+	mu     sync.Mutex
+	checks map[string]*RunningCheck
+}
+
+// RunningCheck is a check running in this process.
+type RunningCheck struct {
+	InstallationID int64     `json:"installation_id"`
+	FullRepoName   string    `json:"repo"`
+	HeadSHA        string    `json:"sha"`
+	CheckName      string    `json:"check"`
+	StartedAt      time.Time `json:"started_at"`
+	cancel         context.CancelFunc
+}
+
+func newRunningChecks() *runningChecks {
+	return &runningChecks{
+		checks: make(map[string]*RunningCheck),
+	}
+}
+
+func (r *runningChecks) Add(key string, check *RunningCheck) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checks[key] = check
+}
+
+func (r *runningChecks) Remove(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.checks, key)
+}
+
+func (r *runningChecks) Cancel(key string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	check, ok := r.checks[key]
+	if ok {
+		check.cancel()
+	}
+	return ok
+}
+
+// List returns the running checks, longest running first.
+func (r *runningChecks) List() []*RunningCheck {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	checks := make([]*RunningCheck, 0, len(r.checks))
+	for _, check := range r.checks {
+		checks = append(checks, check)
+	}
+	sort.Slice(checks, func(i, j int) bool {
+		return checks[i].StartedAt.Before(checks[j].StartedAt)
+	})
+	return checks
+}
+
+// CancelCheck cancels a running check, killing its subprocesses. The check run
+// is completed as cancelled. It returns false if no such check is running.
+func (app *GithubApp) CancelCheck(installationID int64, headSHA string, checkName string) bool {
+	return app.running.Cancel(inFlightKey(installationID, headSHA, checkName))
+}
+
+// runWithTimeout runs checker against target within the resource limits of
+// its configuration, turning a timeout or a cancellation into a result rather
+// than an error.
+func (app *GithubApp) runWithTimeout(ctx context.Context, checker Checker, target *CheckTarget) (*Result, error) {
+	timeout := CurrentSettings().CheckTimeout
+	if target.Config.Timeout > 0 {
+		timeout = target.Config.Timeout
+	}
+	checkCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	key := inFlightKey(target.InstallationID, target.HeadSHA, checker.Name())
+	app.running.Add(key, &RunningCheck{
+		InstallationID: target.InstallationID,
+		FullRepoName:   target.FullRepoName,
+		HeadSHA:        target.HeadSHA,
+		CheckName:      checker.Name(),
+		StartedAt:      time.Now(),
+		cancel:         cancel,
+	})
+	defer app.running.Remove(key)
+
+	limiter, err := newResourceLimiter(target.Config.Limits)
+	if err != nil {
+		return nil, err
+	}
+	result, err := checker.Run(withLimits(checkCtx, limiter), app, target)
+	limiter.release(ctx)
+	switch {
+	case checkCtx.Err() == context.DeadlineExceeded:
+		result, err = &Result{
+			Title:      fmt.Sprintf("%s timed out", checker.Name()),
+			Summary:    fmt.Sprintf("The check didn't complete within %s.", timeout),
+			Conclusion: "timed_out",
+		}, nil
+	case checkCtx.Err() == context.Canceled && ctx.Err() == nil:
+		result, err = &Result{
+			Title:      fmt.Sprintf("%s cancelled", checker.Name()),
+			Summary:    "The check was cancelled.",
+			Conclusion: "cancelled",
+		}, nil
+	}
+	return limiter.report(result, err)
+}
@@ -0,0 +1,71 @@
+package app
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// sleepChecker runs sleep in a subprocess until its context is done.
+var sleepChecker = &funcChecker{
+	name: "test-sleep",
+	fn: func(ctx context.Context, _ *GithubApp, target *CheckTarget) (*Result, error) {
+		_, _, err := runCmdInDir(ctx, target.Dir, "sleep", "60")
+		if err != nil {
+			return nil, err
+		}
+		return &Result{Conclusion: "success"}, nil
+	},
+}
+
+func TestRunWithTimeoutKillsChecksThatTimeOut(t *testing.T) {
+	app := &GithubApp{running: newRunningChecks()}
+	target := &CheckTarget{HeadSHA: "abc", Config: &CheckConfig{Timeout: 50 * time.Millisecond}}
+
+	start := time.Now()
+	res, err := app.runWithTimeout(context.Background(), sleepChecker, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Conclusion != "timed_out" {
+		t.Errorf("got conclusion %q, want timed_out", res.Conclusion)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("check took %s, want its subprocess killed at the timeout", elapsed)
+	}
+}
+
+func TestCancelCheck(t *testing.T) {
+	app := &GithubApp{running: newRunningChecks()}
+	target := &CheckTarget{InstallationID: testInstallationID, HeadSHA: "abc", Config: &CheckConfig{}}
+	if app.CancelCheck(testInstallationID, "abc", sleepChecker.Name()) {
+		t.Errorf("cancelled a check that isn't running")
+	}
+
+	done := make(chan *Result)
+	go func() {
+		res, err := app.runWithTimeout(context.Background(), sleepChecker, target)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- res
+	}()
+	for !app.CancelCheck(testInstallationID, "abc", sleepChecker.Name()) {
+		time.Sleep(time.Millisecond)
+	}
+	if res := <-done; res == nil || res.Conclusion != "cancelled" {
+		t.Errorf("got result %+v, want a cancelled check", res)
+	}
+	if app.CancelCheck(testInstallationID, "abc", sleepChecker.Name()) {
+		t.Errorf("cancelled a check that completed")
+	}
+}
+
+func TestRunWithTimeoutReturnsErrorsWhenParentIsDone(t *testing.T) {
+	app := &GithubApp{running: newRunningChecks()}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := app.runWithTimeout(ctx, sleepChecker, &CheckTarget{Config: &CheckConfig{}}); err == nil {
+		t.Errorf("check succeeded although the app is shutting down")
+	}
+}
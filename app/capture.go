@@ -0,0 +1,76 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/go-github/v43/github"
+)
+
+// CaptureDir is where the raw payloads of received webhooks are archived, so
+// that they can be replayed later. Empty disables capturing.
+var CaptureDir = ""
+
+// CapturedEvent is a webhook delivery archived in CaptureDir.
+type CapturedEvent struct {
+	Event      string          `json:"event"`
+	DeliveryID string          `json:"delivery_id"`
+	ReceivedAt time.Time       `json:"received_at"`
+	Payload    json.RawMessage `json:"payload"`
+}
+
+// captureEvent archives a validated webhook payload in CaptureDir. Failures
+// are only logged, since capturing mustn't stop events from being handled.
+func captureEvent(ctx context.Context, eventType string, deliveryID string, payload []byte) {
+	c := &CapturedEvent{
+		Event:      eventType,
+		DeliveryID: deliveryID,
+		ReceivedAt: time.Now().UTC(),
+		Payload:    payload,
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		logFrom(ctx).Warnw("failed to capture event", "error", err)
+		return
+	}
+	name := fmt.Sprintf("%s-%s-%s.json", c.ReceivedAt.Format("20060102T150405.000Z"), eventType, deliveryID)
+	path := filepath.Join(CaptureDir, name)
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		logFrom(ctx).Warnw("failed to capture event", "path", path, "error", err)
+		return
+	}
+	logFrom(ctx).Infow("captured event", "path", path)
+}
+
+// ReadCapturedEvent reads a webhook delivery archived in CaptureDir.
+func ReadCapturedEvent(path string) (*CapturedEvent, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &CapturedEvent{}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("failed to parse captured event %q: %s", path, err)
+	}
+	if c.Event == "" || len(c.Payload) == 0 {
+		return nil, fmt.Errorf("%q isn't a captured event", path)
+	}
+	return c, nil
+}
+
+// ReplayEvent handles a captured webhook delivery again, bypassing signature
+// validation and redelivery detection. It returns once the event has been
+// processed.
+func (app *GithubApp) ReplayEvent(ctx context.Context, c *CapturedEvent) error {
+	event, err := github.ParseWebHook(c.Event, c.Payload)
+	if err != nil {
+		return err
+	}
+	ctx = withDeliveryID(ctx, c.DeliveryID)
+	logFrom(ctx).Infow("replaying event", "event", c.Event, "received_at", c.ReceivedAt)
+	return app.processEvent(ctx, event)
+}
@@ -0,0 +1,108 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHandleWebhookCapturesEvents(t *testing.T) {
+	defer func(dir string) { CaptureDir = dir }(CaptureDir)
+	CaptureDir = t.TempDir()
+	app := &GithubApp{
+		webhookSecrets: []string{testWebhookSecret},
+		deliveries:     newExpiringSet(deliveryTTL, maxDeliveries),
+	}
+	app.events = newEventQueue(app, 0, 2)
+
+	app.HandleWebhook(httptest.NewRecorder(), webhookRequest(t, "check_suite", "delivery-1", checkSuitePayload("abc"), testWebhookSecret))
+	app.HandleWebhook(httptest.NewRecorder(), webhookRequest(t, "check_suite", "delivery-2", checkSuitePayload("abc"), "wrong-secret"))
+
+	paths, err := filepath.Glob(filepath.Join(CaptureDir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 1 || !strings.HasSuffix(paths[0], "-check_suite-delivery-1.json") {
+		t.Fatalf("captured %v, want only the validated delivery", paths)
+	}
+	c, err := ReadCapturedEvent(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Event != "check_suite" || c.DeliveryID != "delivery-1" || c.ReceivedAt.IsZero() || !strings.Contains(string(c.Payload), `"head_sha":"abc"`) {
+		t.Errorf("got captured event %+v, want delivery-1 with its payload", c)
+	}
+}
+
+func TestReadCapturedEventRejectsOtherFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "invalid.json", "{")
+	writeTestFile(t, dir, "other.json", `{"action": "requested"}`)
+	for _, name := range []string{"invalid.json", "other.json", "missing.json"} {
+		if _, err := ReadCapturedEvent(filepath.Join(dir, name)); err == nil {
+			t.Errorf("read %s as a captured event", name)
+		}
+	}
+}
+
+func TestReplayEvent(t *testing.T) {
+	registerTestChecker(t, &funcChecker{name: "test-lint"})
+	f := newFakeGitHub(t)
+	created := serveCheckRunCreation(t, f, "abc")
+	app := newTestApp(t, f)
+	app.SetDispatcher(&recordingDispatcher{})
+
+	// Replays aren't skipped as redeliveries.
+	app.deliveries.Add("delivery-1")
+	payload, err := json.Marshal(checkSuitePayload("abc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.ReplayEvent(context.Background(), &CapturedEvent{Event: "check_suite", DeliveryID: "delivery-1", Payload: payload}); err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, opts := range created() {
+		found = found || opts.Name == "test-lint"
+	}
+	if !found {
+		t.Errorf("created %+v, want the replayed check suite to create test-lint", created())
+	}
+
+	if err := app.ReplayEvent(context.Background(), &CapturedEvent{Event: "no_such_event", Payload: payload}); err == nil {
+		t.Errorf("replayed an unknown event")
+	}
+}
+
+func TestAdminHandlerReplaysEvent(t *testing.T) {
+	f := newFakeGitHub(t)
+	created := serveCheckRunCreation(t, f, "abc")
+	registerTestChecker(t, &funcChecker{name: "test-lint"})
+	app := newTestApp(t, f)
+	app.SetDispatcher(&recordingDispatcher{})
+
+	body := `{"event": "check_suite", "delivery_id": "delivery-1", "payload": {"action": "requested", "check_suite": {"head_sha": "abc"},
+		"repository": {"name": "r", "full_name": "o/r", "owner": {"login": "o"}}, "installation": {"id": 2}}}`
+	req := httptest.NewRequest(http.MethodPost, "/admin/replay", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	w := httptest.NewRecorder()
+	app.AdminHandler(testAdminToken)(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body)
+	}
+	if len(created()) == 0 {
+		t.Errorf("the replayed check suite created no check runs")
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/admin/replay", strings.NewReader("{"))
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	w = httptest.NewRecorder()
+	app.AdminHandler(testAdminToken)(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d for an invalid body, want %d", w.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,312 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/google/go-github/v43/github"
+)
+
+// Checker is a check that the bot runs against a checkout of a repository.
+// New checks can live in their own file and add themselves with
+// RegisterChecker from an init function.
+type Checker interface {
+	// Name is the name of the check run shown on GitHub.
+	Name() string
+	// Run runs the check against target and returns its result.
+	Run(ctx context.Context, app *GithubApp, target *CheckTarget) (*Result, error)
+	// SupportsFix reports whether the check offers an automatic fix.
+	SupportsFix() bool
+}
+
+// Fixer is implemented by checkers that can fix the issues they report. When
+// a check's result offers the action returned by newFixAction, clicking it
+// clones the PR branch, runs Fix on the checkout and pushes the changes.
+type Fixer interface {
+	// Fix changes the checkout at target.Dir to fix the reported issues.
+	Fix(ctx context.Context, app *GithubApp, target *CheckTarget) error
+	// FixCommitMessage is the message of the commit pushing the fix.
+	FixCommitMessage() string
+}
+
+// fixIdentifier is the requested action identifier of a check's fix.
+func fixIdentifier(checkName string) string {
+	return checkName + "-fix"
+}
+
+// newFixAction returns the "Fix this" action for a check that supports fixes.
+func newFixAction(checkName string, description string) *Action {
+	return &Action{
+		Label:       "Fix this",
+		Description: description,
+		Identifier:  fixIdentifier(checkName),
+	}
+}
+
+// CheckRequirements describes what a check needs from the host to run.
+type CheckRequirements struct {
+	// Binaries must be on PATH.
+	Binaries []string
+	// NeedsBBAPIKey is set for checks that talk to BuildBuddy if
+	// UseBuildBuddy is set.
+	NeedsBBAPIKey bool
+}
+
+// CheckerWithRequirements is implemented by checkers whose requirements should
+// be validated when the app starts.
+type CheckerWithRequirements interface {
+	Checker
+	Requirements() CheckRequirements
+}
+
+// OptInChecker is implemented by checkers that only run in repositories that
+// enable them in .reviewbot.yaml.
+type OptInChecker interface {
+	Checker
+	OptIn() bool
+}
+
+// isMatrixChecker reports whether c runs in a check run per cell of the
+// matrix of repositories that configure one. See BazelConfig.Matrix.
+func isMatrixChecker(c Checker) bool {
+	fc, ok := c.(*funcChecker)
+	return ok && fc.matrix
+}
+
+// matrixCheckName returns the name of the check run of checkName in a matrix
+// cell.
+func matrixCheckName(checkName string, cell string) string {
+	return fmt.Sprintf("%s (%s)", checkName, cell)
+}
+
+// splitMatrixCheckName splits the name of the check run of a matrix cell into
+// the name of the check and the cell. cell is "" for other check runs.
+func splitMatrixCheckName(name string) (checkName string, cell string) {
+	i := strings.LastIndex(name, " (")
+	if i < 0 || !strings.HasSuffix(name, ")") {
+		return name, ""
+	}
+	return name[:i], name[i+2 : len(name)-1]
+}
+
+// CheckRule describes what a check enforces, for tools like code scanning that
+// show findings next to the rule they break.
+type CheckRule struct {
+	// Description is a one-line summary of the rule.
+	Description string
+	// HelpURI links to the documentation of the rule, if any.
+	HelpURI string
+}
+
+// CheckerWithRule is implemented by checkers that describe their rule.
+type CheckerWithRule interface {
+	Checker
+	Rule() CheckRule
+}
+
+// enabledByDefault reports whether c runs in repositories that don't
+// configure it.
+func enabledByDefault(c Checker) bool {
+	optIn, ok := c.(OptInChecker)
+	return !ok || !optIn.OptIn()
+}
+
+// CheckTarget describes the checkout a check runs against.
+type CheckTarget struct {
+	InstallationID int64
+	FullRepoName   string
+	HeadSHA        string
+	// BaseSHA is the head of the pull request's base branch, or "" if it
+	// isn't known.
+	BaseSHA string
+	Dir     string
+	// ChangedFiles are the files changed by the pull request, or nil if they
+	// aren't known.
+	ChangedFiles []string
+	// Config is the check's configuration from the repository's
+	// .reviewbot.yaml.
+	Config *CheckConfig
+	// pullDiff is the diff of the pull request by file, or nil if it isn't
+	// known.
+	pullDiff map[string]*fileDiff
+	// log collects the output of the check's commands, or is nil if it isn't
+	// kept.
+	log *commandLog
+	// progress reports the progress of the running check, or is nil if it
+	// isn't reported.
+	progress func(summary string)
+	// cacheKey is the key the check's result is cached under, or "" if it
+	// isn't cached.
+	cacheKey string
+	// outputBase is the bazel output base that the check's bazel commands
+	// use, or "" for bazel's default.
+	outputBase string
+	// bazel are the repository's bazel settings for the branch being
+	// checked, or nil if it has none.
+	bazel *BazelSettings
+	// ghc is a GitHub client authenticated with the job's installation
+	// token, or nil if the check can't call GitHub.
+	ghc *github.Client
+}
+
+// bazelFlags returns the flags of the check's bazel command, "build" or
+// "test": those of the repository's bazel settings, then the check's own.
+func (t *CheckTarget) bazelFlags(command string) []string {
+	var flags []string
+	if t.bazel != nil {
+		flags = t.bazel.flags(command)
+	}
+	return append(flags, t.Config.Flags...)
+}
+
+// findFiles returns the paths, relative to the checkout and slash-separated,
+// of the files matching any of patterns. Patterns use the syntax of
+// matchGlob. The .git directory is skipped.
+func (t *CheckTarget) findFiles(patterns ...string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(t.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(t.Dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		for _, pattern := range patterns {
+			if matchGlob(pattern, rel) {
+				files = append(files, rel)
+				break
+			}
+		}
+		return nil
+	})
+	return files, err
+}
+
+// BuildBuddyAPIKey returns the BuildBuddy API key to use for target's
+// repository, or "" if UseBuildBuddy is false.
+func (app *GithubApp) BuildBuddyAPIKey(ctx context.Context, target *CheckTarget) (string, error) {
+	if !UseBuildBuddy {
+		return "", nil
+	}
+	return app.bbAPIKeys.BuildBuddyAPIKey(ctx, target.InstallationID, target.FullRepoName)
+}
+
+var (
+	checkersMu sync.RWMutex
+	checkers   = make(map[string]Checker)
+	// checkerNames holds the registered checks in registration order, which is
+	// the order their check runs are created in.
+	checkerNames []string
+)
+
+// RegisterChecker makes a checker available to the app. It panics if a
+// checker with the same name is already registered.
+func RegisterChecker(c Checker) {
+	checkersMu.Lock()
+	defer checkersMu.Unlock()
+	name := c.Name()
+	if _, ok := checkers[name]; ok {
+		panic(fmt.Sprintf("checker %q registered twice", name))
+	}
+	checkers[name] = c
+	checkerNames = append(checkerNames, name)
+}
+
+// GetChecker returns the registered checker with the given name. The checks
+// of matrix cells are run by their matrix checker, named after the cell.
+func GetChecker(checkName string) (Checker, error) {
+	checkersMu.RLock()
+	defer checkersMu.RUnlock()
+	if c, ok := checkers[checkName]; ok {
+		return c, nil
+	}
+	if name, cell := splitMatrixCheckName(checkName); cell != "" {
+		if c, ok := checkers[name]; ok && isMatrixChecker(c) {
+			cellChecker := *c.(*funcChecker)
+			cellChecker.name = checkName
+			return &cellChecker, nil
+		}
+	}
+	return nil, fmt.Errorf("checker not found for %q", checkName)
+}
+
+// registeredChecks returns the names of all registered checkers.
+func registeredChecks() []string {
+	checkersMu.RLock()
+	defer checkersMu.RUnlock()
+	return append([]string(nil), checkerNames...)
+}
+
+type checkFn func(ctx context.Context, app *GithubApp, target *CheckTarget) (*Result, error)
+
+type fixFn func(ctx context.Context, app *GithubApp, target *CheckTarget) error
+
+// funcChecker adapts a check function to the Checker interface.
+type funcChecker struct {
+	name       string
+	fn         checkFn
+	fixFn      fixFn
+	fixMessage string
+	optIn      bool
+	// matrix runs the check per matrix cell, see isMatrixChecker.
+	matrix       bool
+	requirements CheckRequirements
+	rule         CheckRule
+}
+
+func (c *funcChecker) Name() string { return c.name }
+
+func (c *funcChecker) Run(ctx context.Context, app *GithubApp, target *CheckTarget) (*Result, error) {
+	return c.fn(ctx, app, target)
+}
+
+func (c *funcChecker) SupportsFix() bool { return c.fixFn != nil }
+
+func (c *funcChecker) Fix(ctx context.Context, app *GithubApp, target *CheckTarget) error {
+	return c.fixFn(ctx, app, target)
+}
+
+func (c *funcChecker) FixCommitMessage() string { return c.fixMessage }
+
+func (c *funcChecker) Requirements() CheckRequirements { return c.requirements }
+
+func (c *funcChecker) OptIn() bool { return c.optIn }
+
+func (c *funcChecker) Rule() CheckRule { return c.rule }
+
+func init() {
+	RegisterChecker(&funcChecker{
+		name:         buildifierCheck,
+		fn:           checkBuildifier,
+		fixFn:        fixBuildifier,
+		fixMessage:   "Fix BUILD lint errors",
+		requirements: CheckRequirements{Binaries: []string{"buildifier", "git"}},
+		rule:         CheckRule{Description: "BUILD and .bzl files are formatted and free of lint warnings.", HelpURI: "https://github.com/bazelbuild/buildtools/blob/master/WARNINGS.md"},
+	})
+	RegisterChecker(&funcChecker{
+		name:         nogoCheck,
+		fn:           checkBazelBuild,
+		matrix:       true,
+		requirements: CheckRequirements{Binaries: []string{"bb"}, NeedsBBAPIKey: true},
+		rule:         CheckRule{Description: "Bazel targets build and pass nogo static analysis.", HelpURI: "https://github.com/bazelbuild/rules_go/blob/master/go/nogo.rst"},
+	})
+	RegisterChecker(&funcChecker{
+		name:         bazelTestCheck,
+		fn:           checkBazelTest,
+		matrix:       true,
+		requirements: CheckRequirements{Binaries: []string{"bb"}, NeedsBBAPIKey: true},
+		rule:         CheckRule{Description: "Bazel tests pass.", HelpURI: "https://bazel.build/reference/test-encyclopedia"},
+	})
+}
@@ -0,0 +1,145 @@
+package app
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-github/v43/github"
+)
+
+// registerTestChecker registers c for the duration of the test.
+func registerTestChecker(t *testing.T, c Checker) {
+	RegisterChecker(c)
+	t.Cleanup(func() { unregisterChecker(c.Name()) })
+}
+
+// unregisterChecker removes the checker registered as checkName.
+func unregisterChecker(checkName string) {
+	checkersMu.Lock()
+	defer checkersMu.Unlock()
+	delete(checkers, checkName)
+	for i, name := range checkerNames {
+		if name == checkName {
+			checkerNames = append(checkerNames[:i:i], checkerNames[i+1:]...)
+			break
+		}
+	}
+}
+
+func TestRegisterChecker(t *testing.T) {
+	ran := false
+	registerTestChecker(t, &funcChecker{
+		name: "test-lint",
+		fn: func(context.Context, *GithubApp, *CheckTarget) (*Result, error) {
+			ran = true
+			return &Result{Conclusion: "success"}, nil
+		},
+	})
+
+	c, err := GetChecker("test-lint")
+	if err != nil {
+		t.Fatalf("GetChecker: %s", err)
+	}
+	if _, err := c.Run(context.Background(), nil, &CheckTarget{}); err != nil || !ran {
+		t.Errorf("Run of the registered checker: ran %t, error %v", ran, err)
+	}
+	names := registeredChecks()
+	if len(names) == 0 || names[len(names)-1] != "test-lint" {
+		t.Errorf("registeredChecks() = %v, want test-lint registered last", names)
+	}
+	if _, err := GetChecker("no-such-check"); err == nil {
+		t.Errorf("GetChecker of an unregistered check succeeded")
+	}
+}
+
+func TestRegisterCheckerPanicsOnDuplicateName(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("registering a second %s checker didn't panic", buildifierCheck)
+		}
+	}()
+	RegisterChecker(&funcChecker{name: buildifierCheck})
+}
+
+func TestFuncCheckerFixer(t *testing.T) {
+	fixed := false
+	var c Checker = &funcChecker{
+		name: "test-lint",
+		fixFn: func(context.Context, *GithubApp, *CheckTarget) error {
+			fixed = true
+			return nil
+		},
+		fixMessage: "Fix lint errors",
+	}
+	fixer, ok := c.(Fixer)
+	if !ok || !c.SupportsFix() {
+		t.Fatalf("checker with a fix function doesn't support fixes")
+	}
+	if err := fixer.Fix(context.Background(), nil, &CheckTarget{}); err != nil || !fixed {
+		t.Errorf("Fix: fixed %t, error %v", fixed, err)
+	}
+	if got := fixer.FixCommitMessage(); got != "Fix lint errors" {
+		t.Errorf("got commit message %q, want the checker's", got)
+	}
+	if action := newFixAction("test-lint", "Fix it."); action.Identifier != "test-lint-fix" {
+		t.Errorf("got fix action identifier %q, want test-lint-fix", action.Identifier)
+	}
+	if (&funcChecker{name: "test-lint"}).SupportsFix() {
+		t.Errorf("checker without a fix function supports fixes")
+	}
+}
+
+func TestTakeRequestedActionRequiresFixer(t *testing.T) {
+	registerTestChecker(t, &funcChecker{name: "test-lint"})
+	event := func(identifier string) *github.CheckRunEvent {
+		return &github.CheckRunEvent{
+			Installation:    &github.Installation{ID: github.Int64(testInstallationID)},
+			Repo:            testRepo(),
+			CheckRun:        &github.CheckRun{Name: github.String("test-lint"), CheckSuite: &github.CheckSuite{HeadBranch: github.String("feature")}},
+			RequestedAction: &github.RequestedAction{Identifier: identifier},
+		}
+	}
+	app := &GithubApp{}
+
+	// Nothing is cloned for other actions or for checks without fixes, so the
+	// app needs no GitHub client.
+	if err := app.TakeRequestedAction(context.Background(), event("something-else")); err != nil {
+		t.Errorf("unknown action: %s", err)
+	}
+	if err := app.TakeRequestedAction(context.Background(), event(fixIdentifier("test-lint"))); err == nil {
+		t.Errorf("fix of a check without fixes succeeded")
+	}
+}
+
+func TestSplitMatrixCheckName(t *testing.T) {
+	for name, want := range map[string][2]string{
+		"bazel-test (asan)":       {"bazel-test", "asan"},
+		"bazel-test (a (b))":      {"bazel-test (a", "b)"},
+		"bazel-test":              {"bazel-test", ""},
+		"bazel-test (unclosed":    {"bazel-test (unclosed", ""},
+		matrixCheckName("x", "y"): {"x", "y"},
+	} {
+		if checkName, cell := splitMatrixCheckName(name); checkName != want[0] || cell != want[1] {
+			t.Errorf("splitMatrixCheckName(%q) = %q, %q, want %q, %q", name, checkName, cell, want[0], want[1])
+		}
+	}
+}
+
+func TestGetCheckerOfMatrixCell(t *testing.T) {
+	registerTestChecker(t, &funcChecker{name: "test-matrix", matrix: true})
+	registerTestChecker(t, &funcChecker{name: "test-plain"})
+
+	c, err := GetChecker("test-matrix (asan)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Name() != "test-matrix (asan)" || !isMatrixChecker(c) {
+		t.Errorf("got checker %q, want the matrix checker named after the cell", c.Name())
+	}
+	if c, _ := GetChecker("test-matrix"); c.Name() != "test-matrix" {
+		t.Errorf("getting a cell renamed the matrix checker to %q", c.Name())
+	}
+	if _, err := GetChecker("test-plain (asan)"); err == nil {
+		t.Errorf("GetChecker of a cell of a check without a matrix succeeded")
+	}
+}
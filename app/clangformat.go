@@ -0,0 +1,131 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const clangFormatCheck = "clang-format"
+
+// clangFormatSources are the C and C++ files checked by clang-format.
+var clangFormatSources = []string{
+	"**/*.c", "**/*.cc", "**/*.cpp", "**/*.cxx",
+	"**/*.h", "**/*.hh", "**/*.hpp", "**/*.hxx",
+}
+
+// clangFormatViolationRegex matches the diagnostics of `clang-format
+// --dry-run`, e.g. "src/a.cc:12:3: error: code should be clang-formatted
+// [-Wclang-format-violations]".
+var clangFormatViolationRegex = regexp.MustCompile(`^(.+?):(\d+):(\d+): (?:error|warning): code should be clang-formatted`)
+
+func init() {
+	RegisterChecker(&funcChecker{
+		name:         clangFormatCheck,
+		fn:           checkClangFormat,
+		fixFn:        fixClangFormat,
+		fixMessage:   "Format C/C++ sources",
+		optIn:        true,
+		requirements: CheckRequirements{Binaries: []string{"clang-format", "git"}},
+		rule:         CheckRule{Description: "C and C++ files are formatted with clang-format.", HelpURI: "https://clang.llvm.org/docs/ClangFormat.html"},
+	})
+}
+
+// clangFormatFiles returns the C and C++ files to format, or nil if the
+// repository has no .clang-format style at its root.
+func clangFormatFiles(target *CheckTarget) ([]string, error) {
+	if _, err := os.Stat(filepath.Join(target.Dir, ".clang-format")); os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	files, err := target.findFiles(clangFormatSources...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list C/C++ sources: %s", err)
+	}
+	var matched []string
+	for _, f := range files {
+		if target.Config.MatchesPath(f) {
+			matched = append(matched, f)
+		}
+	}
+	return matched, nil
+}
+
+// checkClangFormat annotates the lines of C and C++ files that differ from
+// the repository's .clang-format style.
+func checkClangFormat(ctx context.Context, _ *GithubApp, target *CheckTarget) (*Result, error) {
+	tool := target.Config.ToolOr("clang-format")
+	res := &Result{
+		Title: fmt.Sprintf("%s result", tool),
+	}
+	files, err := clangFormatFiles(target)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		res.Summary = "No C/C++ sources with a .clang-format style found."
+		res.Conclusion = "neutral"
+		return res, nil
+	}
+
+	args := append([]string{"--dry-run", "--style=file"}, target.Config.Flags...)
+	_, stdErr, err := runCheckCmd(ctx, target, tool, append(args, files...)...)
+	annotations := []*Annotation{}
+	seen := make(map[string]bool)
+	unformatted := make(map[string]bool)
+	scanner := bufio.NewScanner(&stdErr)
+	for scanner.Scan() {
+		m := clangFormatViolationRegex.FindStringSubmatch(cleanLine(scanner.Text()))
+		if m == nil {
+			continue
+		}
+		path := filepath.ToSlash(filepath.Clean(m[1]))
+		line, _ := strconv.Atoi(m[2])
+		key := fmt.Sprintf("%s:%d", path, line)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		unformatted[path] = true
+		annotations = append(annotations, &Annotation{
+			Message:  fmt.Sprintf("line needs reformat with %s", tool),
+			Severity: "failure",
+			Path:     path,
+			Line:     line,
+		})
+	}
+	if err != nil && len(annotations) == 0 {
+		return nil, fmt.Errorf("%s failed: %s: %s", tool, err, strings.TrimSpace(cleanLine(stdErr.String())))
+	}
+
+	if len(annotations) == 0 {
+		res.Summary = "No issues found."
+		res.Conclusion = "success"
+		return res, nil
+	}
+	res.Summary = fmt.Sprintf("%d C/C++ files need reformat", len(unformatted))
+	res.Conclusion = "failure"
+	res.Annotations = annotations
+	res.Action = newFixAction(clangFormatCheck, fmt.Sprintf("Automatically reformat with %s.", tool))
+	return res, nil
+}
+
+// fixClangFormat reformats the C and C++ files of the checkout in place.
+func fixClangFormat(ctx context.Context, _ *GithubApp, target *CheckTarget) error {
+	files, err := clangFormatFiles(target)
+	if err != nil || len(files) == 0 {
+		return err
+	}
+	args := append([]string{"-i", "--style=file"}, target.Config.Flags...)
+	_, stdErr, err := runCheckCmd(ctx, target, target.Config.ToolOr("clang-format"), append(args, files...)...)
+	if err != nil {
+		return fmt.Errorf("failed to reformat: %s: %s", err, stdErr.String())
+	}
+	return nil
+}
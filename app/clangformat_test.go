@@ -0,0 +1,91 @@
+package app
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckClangFormat(t *testing.T) {
+	installFakeTool(t, "clang-format", `cat >&2 <<'REPORT'
+src/a.cc:12:3: error: code should be clang-formatted [-Wclang-format-violations]
+  int x=1;
+  ^
+src/a.cc:12:9: error: code should be clang-formatted [-Wclang-format-violations]
+include/b.h:4:1: warning: code should be clang-formatted [-Wclang-format-violations]
+REPORT
+exit 1
+`)
+	dir := t.TempDir()
+	writeTestFile(t, dir, ".clang-format", "BasedOnStyle: Google\n")
+	writeTestFile(t, dir, "src/a.cc", "int x=1;\n")
+	writeTestFile(t, dir, "include/b.h", "#pragma once\n")
+	writeTestFile(t, dir, "main.go", "package main\n")
+
+	res, err := checkClangFormat(context.Background(), nil, &CheckTarget{Dir: dir, Config: &CheckConfig{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Conclusion != "failure" || res.Summary != "2 C/C++ files need reformat" {
+		t.Errorf("got %s with summary %q, want a failure for 2 files", res.Conclusion, res.Summary)
+	}
+	want := []Annotation{
+		{Path: "src/a.cc", Line: 12, Message: "line needs reformat with clang-format", Severity: "failure"},
+		{Path: "include/b.h", Line: 4, Message: "line needs reformat with clang-format", Severity: "failure"},
+	}
+	if len(res.Annotations) != len(want) {
+		t.Fatalf("got %d annotations, want %d", len(res.Annotations), len(want))
+	}
+	for i, a := range res.Annotations {
+		if *a != want[i] {
+			t.Errorf("annotation %d: got %+v, want %+v", i, *a, want[i])
+		}
+	}
+	if res.Action == nil || res.Action.Identifier != fixIdentifier(clangFormatCheck) {
+		t.Errorf("got action %+v, want the clang-format fix", res.Action)
+	}
+}
+
+func TestCheckClangFormatWithoutStyle(t *testing.T) {
+	installFakeTool(t, "clang-format", "echo 'clang-format ran' >&2; exit 1\n")
+	dir := t.TempDir()
+	writeTestFile(t, dir, "src/a.cc", "int x=1;\n")
+
+	res, err := checkClangFormat(context.Background(), nil, &CheckTarget{Dir: dir, Config: &CheckConfig{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Conclusion != "neutral" {
+		t.Errorf("got conclusion %q without a .clang-format, want neutral", res.Conclusion)
+	}
+}
+
+func TestFixClangFormat(t *testing.T) {
+	installFakeTool(t, "clang-format", `for f; do
+  case "$f" in
+    -*) ;;
+    *) echo "int x = 1;" > "$f" ;;
+  esac
+done
+`)
+	dir := t.TempDir()
+	writeTestFile(t, dir, ".clang-format", "BasedOnStyle: Google\n")
+	writeTestFile(t, dir, "src/a.cc", "int x=1;\n")
+	writeTestFile(t, dir, "third_party/c.cc", "int y=2;\n")
+
+	target := &CheckTarget{Dir: dir, Config: &CheckConfig{Paths: []string{"src/**"}}}
+	if err := fixClangFormat(context.Background(), nil, target); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]string{"src/a.cc": "int x = 1;\n", "third_party/c.cc": "int y=2;\n"} {
+		b, err := os.ReadFile(filepath.Join(dir, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != want {
+			t.Errorf("%s has %q after the fix, want %q", path, strings.TrimSpace(string(b)), strings.TrimSpace(want))
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package app
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/go-github/v43/github"
+)
+
+// codeScanningCategory returns the category of a check's analyses, which
+// keeps the uploads of different checks on the same commit from replacing
+// each other's alerts.
+func codeScanningCategory(checkName string) string {
+	return "reviewbot/" + checkName + "/"
+}
+
+// encodeSARIF returns log gzipped and base64 encoded, as the code scanning API
+// expects.
+func encodeSARIF(log *SARIFLog) (string, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(w).Encode(log); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// codeScanningRef returns the ref that analyses of the job's commit are
+// reported for: the pull request's head, or else a branch whose head is the
+// commit. It returns "" if there is neither.
+func codeScanningRef(ctx context.Context, ghc *github.Client, job *Job) (string, error) {
+	if job.PullNumber != 0 {
+		return fmt.Sprintf("refs/pull/%d/head", job.PullNumber), nil
+	}
+	branch, err := headBranch(ctx, ghc, job)
+	if branch == "" {
+		return "", err
+	}
+	return "refs/heads/" + branch, nil
+}
+
+// headBranch returns a branch whose head is the job's commit, or "" if there
+// is none.
+func headBranch(ctx context.Context, ghc *github.Client, job *Job) (string, error) {
+	owner, repo := job.ownerAndRepo()
+	branches, res, err := ghc.Repositories.ListBranchesHeadCommit(ctx, owner, repo, job.HeadSHA)
+	if err := extractError(ctx, res, err); err != nil {
+		return "", fmt.Errorf("failed to list branches of %s: %s", job.HeadSHA, err)
+	}
+	if len(branches) == 0 {
+		return "", nil
+	}
+	return branches[0].GetName(), nil
+}
+
+// uploadCodeScanning uploads results, keyed by check name, to code scanning
+// for the job's commit, as an analysis per check. Checks without annotations
+// are uploaded too, so that their previous alerts are closed.
+func (app *GithubApp) uploadCodeScanning(ctx context.Context, job *Job, results map[string]*Result, startedAt time.Time) error {
+	if len(results) == 0 {
+		return nil
+	}
+	ghc := app.jobClient(job)
+	ref, err := codeScanningRef(ctx, ghc, job)
+	if err != nil {
+		return err
+	}
+	if ref == "" {
+		logFrom(ctx).Infow("not uploading to code scanning, commit isn't the head of a branch or pull request")
+		return nil
+	}
+	names := make([]string, 0, len(results))
+	for name := range results {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	owner, repo := job.ownerAndRepo()
+	for _, name := range names {
+		log := ToSARIF(map[string]*Result{name: results[name]})
+		log.Runs[0].AutomationDetails = &SARIFAutomationDetails{ID: codeScanningCategory(name)}
+		sarif, err := encodeSARIF(log)
+		if err != nil {
+			return fmt.Errorf("failed to encode SARIF of %s: %s", name, err)
+		}
+		analysis := &github.SarifAnalysis{
+			CommitSHA: github.String(job.HeadSHA),
+			Ref:       github.String(ref),
+			Sarif:     github.String(sarif),
+			StartedAt: &github.Timestamp{Time: startedAt},
+			ToolName:  github.String(name),
+		}
+		_, res, err := ghc.CodeScanning.UploadSarif(ctx, owner, repo, analysis)
+		// The upload is processed asynchronously, so success is reported
+		// as 202 Accepted.
+		if _, ok := err.(*github.AcceptedError); ok {
+			err = nil
+		}
+		if err := extractError(ctx, res, err); err != nil {
+			return fmt.Errorf("failed to upload SARIF of %s: %s", name, err)
+		}
+		logFrom(ctx).Infow("uploaded to code scanning", "check", name, "ref", ref, "results", len(log.Runs[0].Results))
+	}
+	return nil
+}
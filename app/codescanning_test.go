@@ -0,0 +1,141 @@
+package app
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v43/github"
+)
+
+// decodeSARIF reverses encodeSARIF.
+func decodeSARIF(t *testing.T, s string) *SARIFLog {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var log SARIFLog
+	if err := json.NewDecoder(r).Decode(&log); err != nil {
+		t.Fatal(err)
+	}
+	return &log
+}
+
+func TestEncodeSARIF(t *testing.T) {
+	log := ToSARIF(map[string]*Result{
+		"test-lint": {Annotations: []*Annotation{{Path: "main.go", Line: 3, Severity: "warning", Message: "unused"}}},
+	})
+	s, err := encodeSARIF(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := decodeSARIF(t, s)
+	if len(got.Runs) != 1 || len(got.Runs[0].Results) != 1 || got.Runs[0].Results[0].Message.Text != "unused" {
+		t.Errorf("decoded SARIF %+v, want the encoded log", got)
+	}
+}
+
+func TestCodeScanningRef(t *testing.T) {
+	f := newFakeGitHub(t)
+	var branches []map[string]interface{}
+	f.handle("GET /repos/o/r/commits/abc/branches-where-head", func(w http.ResponseWriter, req *http.Request) {
+		writeTestJSON(w, http.StatusOK, branches)
+	})
+	ghc := newTestApp(t, f).jobClient(&Job{InstallationID: testInstallationID, Token: "job-token"})
+
+	ref, err := codeScanningRef(context.Background(), ghc, &Job{FullRepoName: "o/r", HeadSHA: "abc", PullNumber: 5})
+	if err != nil || ref != "refs/pull/5/head" {
+		t.Errorf("ref of a pull request = %q, %v, want refs/pull/5/head", ref, err)
+	}
+	if n := f.count("GET /repos/o/r/commits/abc/branches-where-head"); n != 0 {
+		t.Errorf("listed branches %d times for a pull request", n)
+	}
+
+	ref, err = codeScanningRef(context.Background(), ghc, &Job{FullRepoName: "o/r", HeadSHA: "abc"})
+	if err != nil || ref != "" {
+		t.Errorf("ref of a commit that isn't a branch head = %q, %v, want none", ref, err)
+	}
+
+	branches = []map[string]interface{}{{"name": "main"}}
+	ref, err = codeScanningRef(context.Background(), ghc, &Job{FullRepoName: "o/r", HeadSHA: "abc"})
+	if err != nil || ref != "refs/heads/main" {
+		t.Errorf("ref of a branch head = %q, %v, want refs/heads/main", ref, err)
+	}
+}
+
+func TestUploadCodeScanning(t *testing.T) {
+	f := newFakeGitHub(t)
+	var uploads []*github.SarifAnalysis
+	f.handle("POST /repos/o/r/code-scanning/sarifs", func(w http.ResponseWriter, req *http.Request) {
+		var analysis github.SarifAnalysis
+		if err := json.NewDecoder(req.Body).Decode(&analysis); err != nil {
+			t.Errorf("failed to decode upload: %s", err)
+		}
+		uploads = append(uploads, &analysis)
+		writeTestJSON(w, http.StatusAccepted, map[string]string{"id": "1"})
+	})
+	app := newTestApp(t, f)
+	job := &Job{InstallationID: testInstallationID, FullRepoName: "o/r", HeadSHA: "abc", PullNumber: 5, Token: "job-token"}
+	err := app.uploadCodeScanning(context.Background(), job, map[string]*Result{
+		"test-lint": {Annotations: []*Annotation{{Path: "main.go", Line: 3, Severity: "failure", Message: "unused"}}},
+		"test-fmt":  {Conclusion: "success"},
+	}, time.Now())
+	if err != nil {
+		t.Fatalf("uploadCodeScanning: %s", err)
+	}
+
+	if len(uploads) != 2 {
+		t.Fatalf("uploaded %d analyses, want one per check", len(uploads))
+	}
+	for i, name := range []string{"test-fmt", "test-lint"} {
+		u := uploads[i]
+		if u.GetToolName() != name || u.GetCommitSHA() != "abc" || u.GetRef() != "refs/pull/5/head" {
+			t.Errorf("upload %d is of %s at %s, %s; want %s at abc, refs/pull/5/head", i, u.GetToolName(), u.GetCommitSHA(), u.GetRef(), name)
+		}
+		log := decodeSARIF(t, u.GetSarif())
+		if len(log.Runs) != 1 || log.Runs[0].AutomationDetails == nil || log.Runs[0].AutomationDetails.ID != codeScanningCategory(name) {
+			t.Errorf("upload of %s isn't a single run categorized as %q: %+v", name, codeScanningCategory(name), log.Runs)
+		}
+	}
+	if results := decodeSARIF(t, uploads[1].GetSarif()).Runs[0].Results; len(results) != 1 {
+		t.Errorf("uploaded %d results of test-lint, want its annotation", len(results))
+	}
+}
+
+func TestUploadCodeScanningWithoutRef(t *testing.T) {
+	f := newFakeGitHub(t)
+	f.handle("GET /repos/o/r/commits/abc/branches-where-head", func(w http.ResponseWriter, req *http.Request) {
+		writeTestJSON(w, http.StatusOK, []interface{}{})
+	})
+	app := newTestApp(t, f)
+	job := &Job{InstallationID: testInstallationID, FullRepoName: "o/r", HeadSHA: "abc", Token: "job-token"}
+	if err := app.uploadCodeScanning(context.Background(), job, map[string]*Result{"test-lint": {}}, time.Now()); err != nil {
+		t.Fatalf("uploadCodeScanning: %s", err)
+	}
+	if n := f.count("POST /repos/o/r/code-scanning/sarifs"); n != 0 {
+		t.Errorf("uploaded %d analyses of a commit without a ref, want none", n)
+	}
+}
+
+func TestCodeScanningEnabled(t *testing.T) {
+	setTestSettings(t, func(s *Settings) { s.CodeScanning = true })
+	if !(&RepoConfig{}).CodeScanningEnabled() {
+		t.Errorf("code scanning isn't enabled by default when the flag is set")
+	}
+	if (&RepoConfig{CodeScanning: github.Bool(false)}).CodeScanningEnabled() {
+		t.Errorf("repository config doesn't override the flag")
+	}
+	setTestSettings(t, func(s *Settings) { s.CodeScanning = false })
+	if !(&RepoConfig{CodeScanning: github.Bool(true)}).CodeScanningEnabled() {
+		t.Errorf("repository config doesn't opt in to code scanning")
+	}
+}
@@ -0,0 +1,111 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-github/v43/github"
+)
+
+// commandPrefix starts a command to the bot in a pull request comment, e.g.
+// "/reviewbot rerun bazel" or "/reviewbot fix buildifier".
+const commandPrefix = "/reviewbot"
+
+// commentCommand is a command parsed from a pull request comment.
+type commentCommand struct {
+	name string
+	args []string
+}
+
+// parseCommands returns the commands in a comment, one per line starting with
+// commandPrefix.
+func parseCommands(body string) []*commentCommand {
+	var commands []*commentCommand
+	for _, line := range strings.Split(body, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != commandPrefix {
+			continue
+		}
+		commands = append(commands, &commentCommand{name: fields[1], args: fields[2:]})
+	}
+	return commands
+}
+
+// HandleComment runs the commands in a new pull request comment. Only users
+// with write access to the repository may run commands.
+func (app *GithubApp) HandleComment(ctx context.Context, event *github.IssueCommentEvent) error {
+	commands := parseCommands(event.GetComment().GetBody())
+	if len(commands) == 0 {
+		return nil
+	}
+	installationID := event.Installation.GetID()
+	repo := event.GetRepo()
+	owner := repo.GetOwner().GetLogin()
+	repoName := repo.GetName()
+	user := event.GetComment().GetUser().GetLogin()
+	ctx = withLogFields(ctx, "repo", repo.GetFullName(), "pr", event.GetIssue().GetNumber(), "user", user)
+	ghc := app.GetClient(installationID)
+
+	perm, res, err := ghc.Repositories.GetPermissionLevel(ctx, owner, repoName, user)
+	if err := extractError(ctx, res, err); err != nil {
+		return fmt.Errorf("failed to get permission of %s: %s", user, err)
+	}
+	switch perm.GetPermission() {
+	case "admin", "maintain", "write":
+	default:
+		logFrom(ctx).Infow("ignoring command from user without write access", "permission", perm.GetPermission())
+		return app.replyToComment(ctx, ghc, event, fmt.Sprintf("@%s only collaborators with write access can run %s commands.", user, commandPrefix))
+	}
+
+	pr, res, err := ghc.PullRequests.Get(ctx, owner, repoName, event.GetIssue().GetNumber())
+	if err := extractError(ctx, res, err); err != nil {
+		return fmt.Errorf("failed to get pull request: %s", err)
+	}
+	app.pulls.Track(installationID, repo, pr)
+	headSHA := pr.GetHead().GetSHA()
+
+	var failed []string
+	for _, cmd := range commands {
+		if err := app.runCommand(ctx, installationID, repo, pr, cmd); err != nil {
+			failed = append(failed, fmt.Sprintf("`%s %s`: %s", cmd.name, strings.Join(cmd.args, " "), err))
+		}
+	}
+	if len(failed) > 0 {
+		return app.replyToComment(ctx, ghc, event, fmt.Sprintf("@%s failed to run commands on %s:\n- %s", user, headSHA, strings.Join(failed, "\n- ")))
+	}
+	_, res, err = ghc.Reactions.CreateIssueCommentReaction(ctx, owner, repoName, event.GetComment().GetID(), "+1")
+	return extractError(ctx, res, err)
+}
+
+func (app *GithubApp) runCommand(ctx context.Context, installationID int64, repo *github.Repository, pr *github.PullRequest, cmd *commentCommand) error {
+	for _, checkName := range cmd.args {
+		if _, err := GetChecker(checkName); err != nil {
+			return err
+		}
+	}
+	switch cmd.name {
+	case "rerun":
+		checkNames := cmd.args
+		if len(checkNames) == 0 {
+			checkNames = registeredChecks()
+		}
+		return app.createCheckRuns(ctx, installationID, repo, pr.GetHead().GetSHA(), checkNames)
+	case "fix":
+		if len(cmd.args) != 1 {
+			return fmt.Errorf("usage: %s fix <check>", commandPrefix)
+		}
+		if pr.GetHead().GetRepo().GetID() != repo.GetID() {
+			return fmt.Errorf("can't push fixes to a fork")
+		}
+		return app.fixBranch(ctx, installationID, repo.GetFullName(), pr.GetHead().GetRef(), pr.GetHead().GetSHA(), pr.GetNumber(), cmd.args[0], fixIdentifier(cmd.args[0]))
+	}
+	return fmt.Errorf("unknown command %q, expected rerun or fix", cmd.name)
+}
+
+func (app *GithubApp) replyToComment(ctx context.Context, ghc *github.Client, event *github.IssueCommentEvent, body string) error {
+	repo := event.GetRepo()
+	comment := &github.IssueComment{Body: github.String(body)}
+	_, res, err := ghc.Issues.CreateComment(ctx, repo.GetOwner().GetLogin(), repo.GetName(), event.GetIssue().GetNumber(), comment)
+	return extractError(ctx, res, err)
+}
@@ -0,0 +1,146 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v43/github"
+)
+
+func TestParseCommands(t *testing.T) {
+	body := "Looks good.\n/reviewbot rerun bazel gofmt\n  /reviewbot fix buildifier  \n/reviewbot\n/reviewbotrerun\n"
+	got := parseCommands(body)
+	want := []*commentCommand{
+		{name: "rerun", args: []string{"bazel", "gofmt"}},
+		{name: "fix", args: []string{"buildifier"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if cmds := parseCommands("please /reviewbot rerun"); len(cmds) != 0 {
+		t.Errorf("got %+v for a command in the middle of a line, want none", cmds)
+	}
+}
+
+// commentEvent returns the creation of a comment by user on pull request 5 of
+// testRepo.
+func commentEvent(user string, body string) *github.IssueCommentEvent {
+	return &github.IssueCommentEvent{
+		Action:       github.String("created"),
+		Installation: &github.Installation{ID: github.Int64(testInstallationID)},
+		Repo:         testRepo(),
+		Issue:        &github.Issue{Number: github.Int(5)},
+		Comment: &github.IssueComment{
+			ID:   github.Int64(9),
+			Body: github.String(body),
+			User: &github.User{Login: github.String(user)},
+		},
+	}
+}
+
+// servePermission lets f report permission as the access level of user.
+func servePermission(f *fakeGitHub, user string, permission string) {
+	f.handle("GET /repos/o/r/collaborators/"+user+"/permission", func(w http.ResponseWriter, req *http.Request) {
+		writeTestJSON(w, http.StatusOK, map[string]interface{}{"permission": permission})
+	})
+}
+
+func TestHandleCommentRerunsChecks(t *testing.T) {
+	f := newFakeGitHub(t)
+	servePermission(f, "alice", "write")
+	f.handle("GET /repos/o/r/pulls/5", func(w http.ResponseWriter, req *http.Request) {
+		writeTestJSON(w, http.StatusOK, testPR(5, "open", false, "main", "abc"))
+	})
+	created := serveCheckRunCreation(t, f, "abc")
+	f.handle("POST /repos/o/r/issues/comments/9/reactions", func(w http.ResponseWriter, req *http.Request) {
+		writeTestJSON(w, http.StatusCreated, map[string]interface{}{"content": "+1"})
+	})
+	registerTestChecker(t, &funcChecker{name: "test-lint"})
+	app := newTestApp(t, f)
+	d := &recordingDispatcher{}
+	app.SetDispatcher(d)
+
+	if err := app.HandleComment(context.Background(), commentEvent("alice", "/reviewbot rerun test-lint")); err != nil {
+		t.Fatal(err)
+	}
+	runs := created()
+	if len(runs) != 1 || runs[0].Name != "test-lint" || runs[0].HeadSHA != "abc" {
+		t.Errorf("created check runs %+v, want test-lint on abc", runs)
+	}
+	if len(d.jobs) != 1 {
+		t.Errorf("dispatched %d jobs, want 1", len(d.jobs))
+	}
+	if f.count("POST /repos/o/r/issues/comments/9/reactions") != 1 {
+		t.Errorf("comment wasn't acknowledged with a reaction")
+	}
+}
+
+func TestHandleCommentFromUserWithoutWriteAccess(t *testing.T) {
+	f := newFakeGitHub(t)
+	servePermission(f, "mallory", "read")
+	var reply string
+	f.handle("POST /repos/o/r/issues/5/comments", func(w http.ResponseWriter, req *http.Request) {
+		comment := &github.IssueComment{}
+		if err := json.NewDecoder(req.Body).Decode(comment); err != nil {
+			t.Errorf("failed to decode comment: %s", err)
+		}
+		reply = comment.GetBody()
+		writeTestJSON(w, http.StatusCreated, comment)
+	})
+	app := newTestApp(t, f)
+
+	if err := app.HandleComment(context.Background(), commentEvent("mallory", "/reviewbot rerun")); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(reply, "@mallory") || !strings.Contains(reply, "write access") {
+		t.Errorf("got reply %q, want it to explain that write access is needed", reply)
+	}
+	if n := f.count("GET /repos/o/r/pulls/5"); n != 0 {
+		t.Errorf("fetched the pull request %d times for a user without write access", n)
+	}
+}
+
+func TestHandleCommentReportsFailedCommands(t *testing.T) {
+	f := newFakeGitHub(t)
+	servePermission(f, "alice", "admin")
+	f.handle("GET /repos/o/r/pulls/5", func(w http.ResponseWriter, req *http.Request) {
+		writeTestJSON(w, http.StatusOK, testPR(5, "open", false, "main", "abc"))
+	})
+	var reply string
+	f.handle("POST /repos/o/r/issues/5/comments", func(w http.ResponseWriter, req *http.Request) {
+		comment := &github.IssueComment{}
+		if err := json.NewDecoder(req.Body).Decode(comment); err != nil {
+			t.Errorf("failed to decode comment: %s", err)
+		}
+		reply = comment.GetBody()
+		writeTestJSON(w, http.StatusCreated, comment)
+	})
+	app := newTestApp(t, f)
+
+	if err := app.HandleComment(context.Background(), commentEvent("alice", "/reviewbot deploy\n/reviewbot fix gofmt buildifier")); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"unknown command \"deploy\"", "usage: /reviewbot fix <check>"} {
+		if !strings.Contains(reply, want) {
+			t.Errorf("got reply %q, want it to contain %q", reply, want)
+		}
+	}
+	if n := f.count("POST /repos/o/r/issues/comments/9/reactions"); n != 0 {
+		t.Errorf("reacted %d times to a comment with failed commands", n)
+	}
+}
+
+func TestHandleCommentIgnoresOtherComments(t *testing.T) {
+	f := newFakeGitHub(t)
+	app := newTestApp(t, f)
+	if err := app.HandleComment(context.Background(), commentEvent("alice", "LGTM")); err != nil {
+		t.Fatal(err)
+	}
+	if n := f.count("GET /repos/o/r/collaborators/alice/permission"); n != 0 {
+		t.Errorf("looked up permissions %d times for a comment without commands", n)
+	}
+}
@@ -0,0 +1,170 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"golang.org/x/crypto/openpgp"
+)
+
+var (
+	// FixSigningKey signs commits pushed by fix actions, if set.
+	FixSigningKey *openpgp.Entity
+)
+
+// FixConfig overrides how the commits of fix actions are made in a
+// repository. Empty fields use the app-wide settings.
+type FixConfig struct {
+	AuthorName  string `yaml:"author_name"`
+	AuthorEmail string `yaml:"author_email"`
+	// Message is a template like FixMessage.
+	Message string `yaml:"message"`
+	SignOff *bool  `yaml:"sign_off"`
+}
+
+// LoadSigningKey reads the armored OpenPGP private key at path for signing
+// fix commits. The key must not be encrypted.
+func LoadSigningKey(path string) (*openpgp.Entity, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	keys, err := openpgp.ReadArmoredKeyRing(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key %q: %s", path, err)
+	}
+	if len(keys) == 0 || keys[0].PrivateKey == nil {
+		return nil, fmt.Errorf("%q doesn't contain a private key", path)
+	}
+	if keys[0].PrivateKey.Encrypted {
+		return nil, fmt.Errorf("signing key %q is encrypted", path)
+	}
+	return keys[0], nil
+}
+
+// fixSignature returns the signature used for commits created by fix actions
+// in a repository with config.
+func fixSignature(config *FixConfig) *object.Signature {
+	sig := &object.Signature{
+		Name:  CurrentSettings().FixAuthorName,
+		Email: CurrentSettings().FixAuthorEmail,
+		When:  time.Now(),
+	}
+	if config != nil && config.AuthorName != "" {
+		sig.Name = config.AuthorName
+	}
+	if config != nil && config.AuthorEmail != "" {
+		sig.Email = config.AuthorEmail
+	}
+	return sig
+}
+
+// fixCommitMessage returns the message of the commit fixing checkName on pull
+// request pr, which is 0 if it isn't known.
+func fixCommitMessage(config *FixConfig, fixer Fixer, checkName string, pr int, author *object.Signature) string {
+	template := CurrentSettings().FixMessage
+	if config != nil && config.Message != "" {
+		template = config.Message
+	}
+	message := fixer.FixCommitMessage()
+	if template != "" {
+		prString := ""
+		if pr != 0 {
+			prString = "#" + strconv.Itoa(pr)
+		}
+		message = strings.NewReplacer("{{check}}", checkName, "{{pr}}", prString).Replace(template)
+	}
+	signOff := CurrentSettings().FixSignOff
+	if config != nil && config.SignOff != nil {
+		signOff = *config.SignOff
+	}
+	if signOff {
+		message = fmt.Sprintf("%s\n\nSigned-off-by: %s <%s>", strings.TrimRight(message, "\n"), author.Name, author.Email)
+	}
+	return message
+}
+
+// commitAll commits every new, modified and deleted file in the worktree of r
+// as author, signed with FixSigningKey if set, without relying on the local
+// git config or the git CLI. Ignored files aren't committed.
+func commitAll(r *git.Repository, message string, author *object.Signature) (plumbing.Hash, error) {
+	w, err := r.Worktree()
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to get work tree: %s", err)
+	}
+	// Fixes can create files, like generated BUILD files or lockfiles.
+	status, err := w.Status()
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to get status: %s", err)
+	}
+	for path, s := range status {
+		if s.Worktree == git.Untracked {
+			if _, err := w.Add(path); err != nil {
+				return plumbing.ZeroHash, fmt.Errorf("failed to add %s: %s", path, err)
+			}
+		}
+	}
+	hash, err := w.Commit(message, &git.CommitOptions{
+		All:     true,
+		Author:  author,
+		SignKey: FixSigningKey,
+	})
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to create commit: %s", err)
+	}
+	return hash, nil
+}
+
+// checkoutBranch checks out branch of r at the commit of origin/branch, so
+// that commits made on top of it can be pushed back.
+func checkoutBranch(r *git.Repository, branch string) error {
+	remoteRef, err := r.Reference(plumbing.NewRemoteReferenceName(git.DefaultRemoteName, branch), true)
+	if err != nil {
+		return fmt.Errorf("failed to resolve origin/%s: %s", branch, err)
+	}
+	branchRef := plumbing.NewBranchReferenceName(branch)
+	if err := r.Storer.SetReference(plumbing.NewHashReference(branchRef, remoteRef.Hash())); err != nil {
+		return fmt.Errorf("failed to create branch %s: %s", branch, err)
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		return fmt.Errorf("failed to get work tree: %s", err)
+	}
+	if err := w.Checkout(&git.CheckoutOptions{Branch: branchRef, Force: true}); err != nil {
+		return fmt.Errorf("failed to checkout branch %s: %s", branch, err)
+	}
+	return nil
+}
+
+// pushBranch pushes branch of r to fullRepoName on GitHub with token.
+func pushBranch(ctx context.Context, r *git.Repository, token string, fullRepoName string, branch string) error {
+	return pushBranchTo(ctx, r, repoURL(fullRepoName), tokenAuth(token), branch)
+}
+
+// pushBranchTo pushes branch of r to the repository at url, authenticated
+// with auth.
+func pushBranchTo(ctx context.Context, r *git.Repository, url string, auth transport.AuthMethod, branch string) error {
+	remote := git.NewRemote(r.Storer, &config.RemoteConfig{
+		Name: git.DefaultRemoteName,
+		URLs: []string{url},
+	})
+	ref := plumbing.NewBranchReferenceName(branch)
+	err := remote.PushContext(ctx, &git.PushOptions{
+		RefSpecs: []config.RefSpec{config.RefSpec(ref + ":" + ref)},
+		Auth:     auth,
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return fmt.Errorf("failed to push %s to %s: %s", branch, url, err)
+	}
+	return nil
+}
@@ -0,0 +1,299 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+func TestFixSignature(t *testing.T) {
+	if sig := fixSignature(nil); sig.Name != CurrentSettings().FixAuthorName || sig.Email != CurrentSettings().FixAuthorEmail {
+		t.Errorf("got author %s <%s>, want the app-wide author", sig.Name, sig.Email)
+	}
+	sig := fixSignature(&FixConfig{AuthorName: "Fix Bot", AuthorEmail: "fix@example.com"})
+	if sig.Name != "Fix Bot" || sig.Email != "fix@example.com" {
+		t.Errorf("got author %s <%s>, want the repository's author", sig.Name, sig.Email)
+	}
+}
+
+func TestFixCommitMessage(t *testing.T) {
+	fixer := &funcChecker{fixMessage: "Fix BUILD lint errors"}
+	author := &object.Signature{Name: "Fix Bot", Email: "fix@example.com"}
+
+	if got := fixCommitMessage(nil, fixer, buildifierCheck, 7, author); got != "Fix BUILD lint errors" {
+		t.Errorf("got message %q, want the check's own", got)
+	}
+	signOff := true
+	config := &FixConfig{Message: "Fix {{check}} issues in {{pr}}", SignOff: &signOff}
+	want := "Fix buildifier issues in #7\n\nSigned-off-by: Fix Bot <fix@example.com>"
+	if got := fixCommitMessage(config, fixer, buildifierCheck, 7, author); got != want {
+		t.Errorf("got message %q, want %q", got, want)
+	}
+	if got := fixCommitMessage(&FixConfig{Message: "Fix {{check}} issues {{pr}}"}, fixer, buildifierCheck, 0, author); got != "Fix buildifier issues " {
+		t.Errorf("got message %q without a pull request, want the placeholder to be empty", got)
+	}
+}
+
+func TestFixCommitMessageUsesAppWideSettings(t *testing.T) {
+	setTestSettings(t, func(s *Settings) {
+		s.FixMessage = "Apply {{check}} fixes"
+		s.FixSignOff = true
+	})
+	author := &object.Signature{Name: "Fix Bot", Email: "fix@example.com"}
+
+	want := "Apply gofmt fixes\n\nSigned-off-by: Fix Bot <fix@example.com>"
+	if got := fixCommitMessage(nil, &funcChecker{}, "gofmt", 0, author); got != want {
+		t.Errorf("got message %q, want %q", got, want)
+	}
+	signOff := false
+	if got := fixCommitMessage(&FixConfig{SignOff: &signOff}, &funcChecker{}, "gofmt", 0, author); got != "Apply gofmt fixes" {
+		t.Errorf("got message %q, want the repository to turn off the sign-off", got)
+	}
+}
+
+// writeSigningKey writes a new unencrypted armored private key to a
+// temporary file and returns its path and the key.
+func writeSigningKey(t *testing.T) (string, *openpgp.Entity) {
+	entity, err := openpgp.NewEntity("Fix Bot", "", "fix@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	w, err := armor.Encode(&b, openpgp.PrivateKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.SerializePrivate(w, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "key.asc")
+	if err := os.WriteFile(path, b.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path, entity
+}
+
+func TestLoadSigningKey(t *testing.T) {
+	path, entity := writeSigningKey(t)
+	key, err := LoadSigningKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.PrimaryKey.KeyId != entity.PrimaryKey.KeyId {
+		t.Errorf("loaded key %X, want %X", key.PrimaryKey.KeyId, entity.PrimaryKey.KeyId)
+	}
+
+	notAKey := filepath.Join(t.TempDir(), "key.asc")
+	if err := os.WriteFile(notAKey, []byte("not a key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadSigningKey(notAKey); err == nil {
+		t.Errorf("loaded a key from a file without one")
+	}
+}
+
+func TestCommitAllSignsWithSigningKey(t *testing.T) {
+	path, _ := writeSigningKey(t)
+	key, err := LoadSigningKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(key *openpgp.Entity) { FixSigningKey = key }(FixSigningKey)
+	FixSigningKey = key
+
+	dir := t.TempDir()
+	r, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, dir, "BUILD", "old\n")
+	w, err := r.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Add("BUILD"); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := commitAll(r, "initial", &object.Signature{Name: "Fix Bot", Email: "fix@example.com", When: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit, err := r.CommitObject(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var public bytes.Buffer
+	aw, err := armor.Encode(&public, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := key.Serialize(aw); err != nil {
+		t.Fatal(err)
+	}
+	if err := aw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := commit.Verify(public.String()); err != nil {
+		t.Errorf("commit signature doesn't verify: %s", err)
+	}
+}
+
+func TestCommitAll(t *testing.T) {
+	dir := t.TempDir()
+	r, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, dir, "BUILD", "old\n")
+	w, err := r.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Add("BUILD"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := commitAll(r, "initial", &object.Signature{Name: "Someone", Email: "someone@example.com", When: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestFile(t, dir, "BUILD", "new\n")
+	writeTestFile(t, dir, "pkg/BUILD.bazel", "generated\n")
+	author := &object.Signature{Name: "Fix Bot", Email: "fix@example.com", When: time.Now()}
+	hash, err := commitAll(r, "Fix BUILD lint errors", author)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit, err := r.CommitObject(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commit.Author.Name != author.Name || commit.Author.Email != author.Email {
+		t.Errorf("commit author is %s <%s>, want %s <%s>", commit.Author.Name, commit.Author.Email, author.Name, author.Email)
+	}
+	stats, err := commit.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 2 {
+		t.Errorf("commit changed %d files, want the modified and the new file", len(stats))
+	}
+	status, err := w.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.IsClean() {
+		t.Errorf("work tree isn't clean after the commit: %s", status)
+	}
+}
+
+func TestCommitAllAddsNewFiles(t *testing.T) {
+	dir := t.TempDir()
+	testGit(t, dir, "init", "-q")
+	commitTestFile(t, dir, "MODULE.bazel", "module(name = \"m\")\n")
+	writeTestFile(t, dir, ".gitignore", "bazel-*\n")
+	writeTestFile(t, dir, "MODULE.bazel.lock", "{}\n")
+	writeTestFile(t, dir, "bazel-out/log", "ignored\n")
+	r, err := git.PlainOpen(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash, err := commitAll(r, "Update lockfiles", &object.Signature{Name: "Fix Bot", Email: "fix@example.com", When: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit, err := r.CommitObject(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]bool{"MODULE.bazel.lock": true, ".gitignore": true, "bazel-out/log": false} {
+		if _, err := commit.File(path); (err == nil) != want {
+			t.Errorf("%s: got error %v from the commit, want committed %t", path, err, want)
+		}
+	}
+}
+
+func TestCheckoutBranch(t *testing.T) {
+	src := t.TempDir()
+	testGit(t, src, "init", "-q", "-b", "main")
+	commitTestFile(t, src, "BUILD", "main\n")
+	testGit(t, src, "checkout", "-q", "-b", "feature")
+	feature := commitTestFile(t, src, "BUILD", "feature\n")
+	testGit(t, src, "checkout", "-q", "main")
+
+	dir := t.TempDir()
+	r, err := git.PlainClone(dir, false, &git.CloneOptions{URL: src})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkoutBranch(r, "feature"); err != nil {
+		t.Fatal(err)
+	}
+	head, err := r.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Name() != "refs/heads/feature" || head.Hash().String() != feature {
+		t.Errorf("HEAD is %s at %s, want feature at %s", head.Name(), head.Hash(), feature)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "BUILD"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "feature\n" {
+		t.Errorf("checkout has %q, want the feature branch's file", b)
+	}
+	if err := checkoutBranch(r, "missing"); err == nil {
+		t.Errorf("checked out a branch that doesn't exist on origin")
+	}
+}
+
+func TestPushBranchAuthenticatesWithToken(t *testing.T) {
+	f := newFakeGitHub(t)
+	var auth, service string
+	f.handle("GET /o/r.git/info/refs", func(w http.ResponseWriter, req *http.Request) {
+		auth = req.Header.Get("Authorization")
+		service = req.URL.Query().Get("service")
+		http.Error(w, "forbidden", http.StatusForbidden)
+	})
+	dir := t.TempDir()
+	r, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = pushBranch(context.Background(), r, "secret-token", "o/r", "feature")
+	if err == nil {
+		t.Fatal("push succeeded although GitHub rejected it")
+	}
+	if strings.Contains(err.Error(), "secret-token") {
+		t.Errorf("error %q contains the token", err)
+	}
+	if want := "Basic " + base64.StdEncoding.EncodeToString([]byte("x-access-token:secret-token")); auth != want {
+		t.Errorf("pushed with authorization %q, want the installation token", auth)
+	}
+	if service != "git-receive-pack" {
+		t.Errorf("requested service %q, want git-receive-pack", service)
+	}
+	cfg, err := r.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Remotes) != 0 {
+		t.Errorf("push added remotes %v to the repository's config", cfg.Remotes)
+	}
+}
@@ -0,0 +1,171 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const commitMessageCheck = "commit-message"
+
+// defaultCommitPattern matches Conventional Commits subjects, e.g.
+// "fix(app): handle empty diffs".
+const defaultCommitPattern = `^(build|chore|ci|docs|feat|fix|perf|refactor|revert|style|test)(\([^)]+\))?!?: \S`
+
+// defaultMaxSubjectLength is the longest commit subject allowed by default.
+const defaultMaxSubjectLength = 72
+
+// conventionalPrefixRegex matches the type and scope of a Conventional
+// Commits subject, which is skipped when checking for the imperative mood.
+var conventionalPrefixRegex = regexp.MustCompile(`^\w+(\([^)]*\))?!?:\s*`)
+
+func init() {
+	RegisterChecker(&funcChecker{
+		name:         commitMessageCheck,
+		fn:           checkCommitMessages,
+		optIn:        true,
+		requirements: CheckRequirements{Binaries: []string{"git"}},
+		rule:         CheckRule{Description: "Commit messages follow the repository's conventions.", HelpURI: "https://www.conventionalcommits.org/"},
+	})
+}
+
+// pullCommit is a commit of a pull request.
+type pullCommit struct {
+	sha     string
+	message string
+}
+
+// pullCommits returns the non-merge commits on headSHA since it diverged from
+// baseSHA, oldest first.
+func pullCommits(dir string, baseSHA string, headSHA string) ([]*pullCommit, error) {
+	out, err := gitOutput(dir, "log", "--reverse", "--no-merges", "--format=%H%x00%B%x00", baseSHA+".."+headSHA)
+	if err != nil {
+		return nil, err
+	}
+	var commits []*pullCommit
+	fields := strings.Split(out, "\x00")
+	for i := 0; i+1 < len(fields); i += 2 {
+		commits = append(commits, &pullCommit{
+			sha:     strings.TrimSpace(fields[i]),
+			message: strings.TrimSpace(fields[i+1]),
+		})
+	}
+	return commits, nil
+}
+
+// notImperative reports whether the first word of a subject looks like it
+// isn't in the imperative mood, e.g. "Added" or "Fixes" rather than "Add" or
+// "Fix". It's a guess that flags imperative verbs like "Embed" or "Bring" too,
+// so it only warns.
+func notImperative(subject string) bool {
+	fields := strings.Fields(conventionalPrefixRegex.ReplaceAllString(subject, ""))
+	if len(fields) == 0 {
+		return false
+	}
+	word := strings.ToLower(strings.Trim(fields[0], ".,:;"))
+	if len(word) <= 3 {
+		return false
+	}
+	for _, suffix := range []string{"ss", "us", "is"} {
+		if strings.HasSuffix(word, suffix) {
+			return false
+		}
+	}
+	return strings.HasSuffix(word, "ed") || strings.HasSuffix(word, "ing") || strings.HasSuffix(word, "s")
+}
+
+// commitMessageProblems returns what's wrong with message, and warnings about
+// what might be, which don't make it invalid.
+func commitMessageProblems(message string, pattern *regexp.Regexp, maxSubjectLength int, issuePattern *regexp.Regexp) (problems []string, warnings []string) {
+	subject, body, _ := strings.Cut(message, "\n")
+	if !pattern.MatchString(subject) {
+		problems = append(problems, fmt.Sprintf("subject doesn't match `%s`", pattern))
+	}
+	if n := len([]rune(subject)); n > maxSubjectLength {
+		problems = append(problems, fmt.Sprintf("subject is %d characters long, more than %d", n, maxSubjectLength))
+	}
+	if notImperative(subject) {
+		warnings = append(warnings, "subject may not be in the imperative mood")
+	}
+	if body != "" && strings.TrimSpace(strings.SplitN(body, "\n", 2)[0]) != "" {
+		problems = append(problems, "subject isn't separated from the body by a blank line")
+	}
+	if issuePattern != nil && !issuePattern.MatchString(message) {
+		problems = append(problems, fmt.Sprintf("message doesn't reference an issue matching `%s`", issuePattern))
+	}
+	return problems, warnings
+}
+
+// checkCommitMessages validates the messages of the pull request's commits.
+// Problems are listed per commit in the summary, since commits aren't files
+// that can be annotated.
+func checkCommitMessages(ctx context.Context, _ *GithubApp, target *CheckTarget) (*Result, error) {
+	res := &Result{
+		Title: "Commit message result",
+	}
+	if target.BaseSHA == "" {
+		res.Summary = "Commit messages are only checked on pull requests."
+		res.Conclusion = "neutral"
+		return res, nil
+	}
+	config := target.Config
+	pattern := defaultCommitPattern
+	if config.CommitPattern != "" {
+		pattern = config.CommitPattern
+	}
+	patternRegex, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid commit_pattern %q: %s", pattern, err)
+	}
+	var issueRegex *regexp.Regexp
+	if config.IssuePattern != "" {
+		if issueRegex, err = regexp.Compile(config.IssuePattern); err != nil {
+			return nil, fmt.Errorf("invalid issue_pattern %q: %s", config.IssuePattern, err)
+		}
+	}
+	maxSubjectLength := defaultMaxSubjectLength
+	if config.MaxSubjectLength > 0 {
+		maxSubjectLength = config.MaxSubjectLength
+	}
+
+	commits, err := pullCommits(target.Dir, target.BaseSHA, target.HeadSHA)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list commits: %s", err)
+	}
+	var b strings.Builder
+	b.WriteString("| Commit | Subject | Problems |\n")
+	b.WriteString("|---|---|---|\n")
+	invalid, warned := 0, 0
+	for _, c := range commits {
+		problems, warnings := commitMessageProblems(c.message, patternRegex, maxSubjectLength, issueRegex)
+		if len(problems) == 0 && len(warnings) == 0 {
+			continue
+		}
+		if len(problems) > 0 {
+			invalid++
+		} else {
+			warned++
+		}
+		for _, w := range warnings {
+			problems = append(problems, "warning: "+w)
+		}
+		subject, _, _ := strings.Cut(c.message, "\n")
+		sha := c.sha
+		if len(sha) > 12 {
+			sha = sha[:12]
+		}
+		fmt.Fprintf(&b, "| %s | %s | %s |\n", sha, strings.ReplaceAll(subject, "|", `\|`), strings.Join(problems, "<br>"))
+	}
+	if invalid == 0 {
+		res.Summary = fmt.Sprintf("All %d commit messages are valid.", len(commits))
+		if warned > 0 {
+			res.Summary += fmt.Sprintf(" %d have warnings.\n\n%s", warned, b.String())
+		}
+		res.Conclusion = "success"
+		return res, nil
+	}
+	res.Summary = fmt.Sprintf("%d of %d commit messages are invalid.\n\n%s", invalid, len(commits), b.String())
+	res.Conclusion = "failure"
+	return res, nil
+}
@@ -0,0 +1,118 @@
+package app
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestCommitMessageProblems(t *testing.T) {
+	pattern := regexp.MustCompile(defaultCommitPattern)
+	for _, tc := range []struct {
+		message  string
+		issue    string
+		problems int
+	}{
+		{message: "fix(app): handle empty diffs"},
+		{message: "feat!: drop the v1 API\n\nBREAKING CHANGE: v1 is gone."},
+		{message: "fix: process empty diffs"},
+		{message: "Handle empty diffs", problems: 1},
+		{message: "fix: handled empty diffs"},
+		{message: "fix: handle empty diffs\nCloses #1", problems: 1},
+		{message: "fix: " + strings.Repeat("a", 70), problems: 1},
+		{message: "fix: handle empty diffs", issue: `#\d+`, problems: 1},
+		{message: "fix: handle empty diffs\n\nCloses #1", issue: `#\d+`},
+	} {
+		var issue *regexp.Regexp
+		if tc.issue != "" {
+			issue = regexp.MustCompile(tc.issue)
+		}
+		if problems, _ := commitMessageProblems(tc.message, pattern, defaultMaxSubjectLength, issue); len(problems) != tc.problems {
+			t.Errorf("commitMessageProblems(%q) = %q, want %d problems", tc.message, problems, tc.problems)
+		}
+	}
+}
+
+func TestCheckCommitMessages(t *testing.T) {
+	dir := t.TempDir()
+	testGit(t, dir, "init", "-q", "-b", "main")
+	base := commitTestFile(t, dir, "a.txt", "a\n")
+	testGit(t, dir, "commit", "-q", "--allow-empty", "-m", "fix: handle empty diffs")
+	testGit(t, dir, "commit", "-q", "--allow-empty", "-m", "Added | pipes")
+	head := testGit(t, dir, "rev-parse", "HEAD")
+
+	target := &CheckTarget{Dir: dir, BaseSHA: base, HeadSHA: head, Config: &CheckConfig{}}
+	res, err := checkCommitMessages(context.Background(), nil, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Conclusion != "failure" || !strings.HasPrefix(res.Summary, "1 of 2 commit messages are invalid.") {
+		t.Errorf("got %s with summary %q, want 1 of 2 commits to be invalid", res.Conclusion, res.Summary)
+	}
+	if row := "| " + head[:12] + ` | Added \| pipes | subject doesn't match`; !strings.Contains(res.Summary, row) {
+		t.Errorf("summary %q doesn't contain %q", res.Summary, row)
+	}
+
+	target.Config = &CheckConfig{CommitPattern: `^\w`}
+	if res, err = checkCommitMessages(context.Background(), nil, target); err != nil {
+		t.Fatal(err)
+	}
+	if res.Conclusion != "success" || !strings.Contains(res.Summary, "1 have warnings") || !strings.Contains(res.Summary, "warning: subject may not be in the imperative mood") || strings.Contains(res.Summary, "fix: handle") {
+		t.Errorf("got %s with summary %q, want success with a warning about the mood of the second commit", res.Conclusion, res.Summary)
+	}
+}
+
+func TestCheckCommitMessagesWithValidMessages(t *testing.T) {
+	dir := t.TempDir()
+	testGit(t, dir, "init", "-q", "-b", "main")
+	base := commitTestFile(t, dir, "a.txt", "a\n")
+	testGit(t, dir, "commit", "-q", "--allow-empty", "-m", "docs: describe the config")
+	head := testGit(t, dir, "rev-parse", "HEAD")
+
+	res, err := checkCommitMessages(context.Background(), nil, &CheckTarget{Dir: dir, BaseSHA: base, HeadSHA: head, Config: &CheckConfig{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Conclusion != "success" || res.Summary != "All 1 commit messages are valid." {
+		t.Errorf("got %s with summary %q, want success", res.Conclusion, res.Summary)
+	}
+}
+
+func TestCheckCommitMessagesOutsideOfPullRequest(t *testing.T) {
+	res, err := checkCommitMessages(context.Background(), nil, &CheckTarget{Dir: t.TempDir(), Config: &CheckConfig{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Conclusion != "neutral" {
+		t.Errorf("got %s without a base, want neutral", res.Conclusion)
+	}
+}
+
+func TestCheckCommitMessagesWithInvalidPattern(t *testing.T) {
+	target := &CheckTarget{Dir: t.TempDir(), BaseSHA: "abc", HeadSHA: "def", Config: &CheckConfig{CommitPattern: "("}}
+	if _, err := checkCommitMessages(context.Background(), nil, target); err == nil {
+		t.Errorf("check succeeded with an invalid commit_pattern")
+	}
+}
+
+func TestCommitMessageProblemsOnlyWarnsAboutMood(t *testing.T) {
+	pattern := regexp.MustCompile(defaultCommitPattern)
+	for _, tc := range []struct {
+		message  string
+		problems int
+		warnings int
+	}{
+		{"fix: handle empty diffs", 0, 0},
+		{"fix: handled empty diffs", 0, 1},
+		{"feat: embed the version", 0, 1},
+		{"fix: process empty diffs", 0, 0},
+		{"Handled empty diffs", 1, 1},
+		{"fix: handle empty diffs\nCloses #1", 1, 0},
+	} {
+		problems, warnings := commitMessageProblems(tc.message, pattern, defaultMaxSubjectLength, nil)
+		if len(problems) != tc.problems || len(warnings) != tc.warnings {
+			t.Errorf("commitMessageProblems(%q) = %q, %q, want %d problems and %d warnings", tc.message, problems, warnings, tc.problems, tc.warnings)
+		}
+	}
+}
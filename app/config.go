@@ -0,0 +1,580 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v43/github"
+	"gopkg.in/yaml.v3"
+)
+
+// repoConfigFile is the per-repository configuration file, read from the root
+// of the repository at the commit being checked.
+const repoConfigFile = ".reviewbot.yaml"
+
+// RepoConfig is the contents of a repository's .reviewbot.yaml. For example:
+//
+//	checks:
+//	  buildifier:
+//	    version: "v7.1.2"
+//	    flags: ["--lint=warn", "--warnings=all"]
+//	    paths: ["**/BUILD*", "**/*.bzl"]
+//	    trigger_paths: ["**/BUILD*", "**/*.bzl"]
+//	  bazel:
+//	    targets: ["//app/...", "//lib/..."]
+//	  bazel-test:
+//	    enabled: false
+//	    limits: {cpus: 4, memory: "8g", nice: 10, jobs: 8}
+//	    retries: 2
+//	    flaky_issue: true
+//	bazel:
+//	  targets: ["//..."]
+//	  configs: ["ci"]
+//	  test_flags: ["--test_output=errors"]
+//	  branches:
+//	    - branch: "release/*"
+//	      configs: ["ci", "opt"]
+//	  matrix:
+//	    - name: linux
+//	    - name: asan
+//	      configs: ["asan"]
+//	summary_comment: true
+//	aggregate_check: true
+//	broken_branch_issue: true
+//	email_authors: true
+//	skip_drafts: true
+//	skip_labels: ["skip-ci"]
+//	fix:
+//	  message: "Fix {{check}} issues in {{pr}}"
+//	  sign_off: true
+type RepoConfig struct {
+	Checks map[string]*CheckConfig `yaml:"checks"`
+	// Bazel configures the bazel builds and tests of checks.
+	Bazel *BazelConfig `yaml:"bazel"`
+	// Fix configures the commits pushed by fix actions.
+	Fix *FixConfig `yaml:"fix"`
+	// SummaryComment overrides whether a comment summarizing the results of
+	// all checks is posted on pull requests. See Settings.SummaryComment.
+	SummaryComment *bool `yaml:"summary_comment"`
+	// AggregateCheck overrides whether a check run rolling up the results
+	// of all checks is created. See Settings.AggregateCheck.
+	AggregateCheck *bool `yaml:"aggregate_check"`
+	// CacheWarmup overrides whether pushes to the default branch warm the
+	// remote cache. See Settings.CacheWarmup.
+	CacheWarmup *bool `yaml:"cache_warmup"`
+	// CodeScanning overrides whether the annotations of checks are uploaded
+	// to code scanning. See Settings.CodeScanning.
+	CodeScanning *bool `yaml:"code_scanning"`
+	// BrokenBranchIssue overrides whether failing checks on the default
+	// branch open an issue. See Settings.BrokenBranchIssue.
+	BrokenBranchIssue *bool `yaml:"broken_branch_issue"`
+	// EmailAuthors overrides whether the authors of commits that break
+	// checks on protected branches are emailed. See Settings.EmailAuthors.
+	EmailAuthors *bool `yaml:"email_authors"`
+	// SkipDrafts overrides whether checks are skipped on draft pull
+	// requests. See Settings.SkipDrafts.
+	SkipDrafts *bool `yaml:"skip_drafts"`
+	// SkipLabels are labels that skip checks on the pull requests carrying
+	// them, in addition to the bot-wide Settings.SkipLabels of
+	// --github.skip_labels.
+	SkipLabels []string `yaml:"skip_labels"`
+}
+
+// SummaryCommentEnabled reports whether to post a summary comment on pull
+// requests.
+func (c *RepoConfig) SummaryCommentEnabled() bool {
+	if c.SummaryComment == nil {
+		return CurrentSettings().SummaryComment
+	}
+	return *c.SummaryComment
+}
+
+// AggregateCheckEnabled reports whether to create the aggregate check run.
+func (c *RepoConfig) AggregateCheckEnabled() bool {
+	if c.AggregateCheck == nil {
+		return CurrentSettings().AggregateCheck
+	}
+	return *c.AggregateCheck
+}
+
+// CacheWarmupEnabled reports whether to warm the remote cache after pushes
+// to the default branch.
+func (c *RepoConfig) CacheWarmupEnabled() bool {
+	if c.CacheWarmup == nil {
+		return CurrentSettings().CacheWarmup
+	}
+	return *c.CacheWarmup
+}
+
+// CodeScanningEnabled reports whether to upload the annotations of checks to
+// code scanning.
+func (c *RepoConfig) CodeScanningEnabled() bool {
+	if c.CodeScanning == nil {
+		return CurrentSettings().CodeScanning
+	}
+	return *c.CodeScanning
+}
+
+// BrokenBranchIssueEnabled reports whether to open an issue when checks fail
+// on the default branch.
+func (c *RepoConfig) BrokenBranchIssueEnabled() bool {
+	if c.BrokenBranchIssue == nil {
+		return CurrentSettings().BrokenBranchIssue
+	}
+	return *c.BrokenBranchIssue
+}
+
+// EmailAuthorsEnabled reports whether to email the authors of commits that
+// break checks on protected branches.
+func (c *RepoConfig) EmailAuthorsEnabled() bool {
+	if c.EmailAuthors == nil {
+		return CurrentSettings().EmailAuthors
+	}
+	return *c.EmailAuthors
+}
+
+// skipReason returns why checks don't run automatically on pr, or "" if they
+// do. pr may be nil for commits that aren't the head of a pull request.
+func (c *RepoConfig) skipReason(pr *github.PullRequest) string {
+	if pr == nil {
+		return ""
+	}
+	skipDrafts := CurrentSettings().SkipDrafts
+	if c.SkipDrafts != nil {
+		skipDrafts = *c.SkipDrafts
+	}
+	if skipDrafts && pr.GetDraft() {
+		return "pull request is a draft"
+	}
+	for _, l := range pr.Labels {
+		if c.isSkipLabel(l.GetName()) {
+			return fmt.Sprintf("pull request is labeled %q", l.GetName())
+		}
+	}
+	return ""
+}
+
+// isSkipLabel reports whether label skips checks.
+func (c *RepoConfig) isSkipLabel(label string) bool {
+	for _, skip := range append(append([]string(nil), CurrentSettings().SkipLabels...), c.SkipLabels...) {
+		if strings.EqualFold(skip, label) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckConfig configures a single check.
+type CheckConfig struct {
+	// Enabled turns the check on or off. Checks run by default unless they
+	// are opt-in.
+	Enabled *bool `yaml:"enabled"`
+	// Tool overrides the binary a check runs, for checks that support
+	// drop-in alternatives (e.g. gofumpt instead of gofmt).
+	Tool string `yaml:"tool"`
+	// Version pins the release of the tools that the bot downloads for the
+	// check, e.g. "v7.1.2" for buildifier and the buildozer of the check's
+	// buildozer_actions. Defaults to BuildtoolsVersion.
+	Version string `yaml:"version"`
+	// Flags are extra flags passed to the check's tool.
+	Flags []string `yaml:"flags"`
+	// Targets are the bazel target patterns to build or test. Defaults to
+	// the targets of the repository's bazel settings.
+	Targets []string `yaml:"targets"`
+	// Timeout overrides how long the check may run, e.g. "30m".
+	Timeout time.Duration `yaml:"timeout"`
+	// Limits constrain the resources of the check's commands, within those
+	// of the bot's host.
+	Limits *ResourceLimits `yaml:"limits"`
+	// Affected only builds or tests the targets that depend on files changed
+	// in the pull request.
+	Affected bool `yaml:"affected"`
+	// FullBuildPaths are globs of files whose changes make affected mode fall
+	// back to all targets. Defaults to the WORKSPACE, MODULE.bazel and
+	// .bazelrc files.
+	FullBuildPaths []string `yaml:"full_build_paths"`
+	// Paths are globs restricting which files the check reports on. `**`
+	// matches any number of directories. Defaults to every file.
+	Paths []string `yaml:"paths"`
+	// TriggerPaths are globs of files that the check runs for. If set, the
+	// check is skipped, completing as neutral, unless the pull request or
+	// commit changes a matching file.
+	TriggerPaths []string `yaml:"trigger_paths"`
+	// Suggestions posts the changes of a fixable check's fix on pull request
+	// lines as review comments with suggested changes, so that authors can
+	// apply them from the pull request.
+	Suggestions bool `yaml:"suggestions"`
+	// AnnotateAllLines annotates issues anywhere in the repository. By
+	// default, only lines added or modified by the pull request are
+	// annotated.
+	AnnotateAllLines bool `yaml:"annotate_all_lines"`
+	// FailureCategories are the lint warning categories that fail the check
+	// rather than being reported as warnings, for linters that categorize
+	// their warnings (e.g. buildifier's "load" or "native-build").
+	FailureCategories []string `yaml:"failure_categories"`
+	// Header is the license header required at the top of source files,
+	// without comment markers. {{year}} matches any year and is replaced
+	// with the current year when headers are added.
+	Header string `yaml:"header"`
+	// CommitPattern is the regular expression that the subject of commit
+	// messages must match. Defaults to Conventional Commits.
+	CommitPattern string `yaml:"commit_pattern"`
+	// MaxSubjectLength is the longest allowed commit subject. Defaults to
+	// 72.
+	MaxSubjectLength int `yaml:"max_subject_length"`
+	// IssuePattern, if set, is a regular expression that commit messages
+	// must match somewhere, e.g. "#\\d+" to require an issue reference.
+	IssuePattern string `yaml:"issue_pattern"`
+	// MaxFileSize is the size in bytes of the largest file a pull request
+	// may add outside of Git LFS. Defaults to 5 MiB.
+	MaxFileSize int64 `yaml:"max_file_size"`
+	// ForbiddenExtensions are the extensions of binary artifacts that may
+	// only be added through Git LFS, e.g. ".jar". Defaults to common
+	// archives, executables and object files.
+	ForbiddenExtensions []string `yaml:"forbidden_extensions"`
+	// Policies are the dependency rules enforced by the bazel-policy check.
+	Policies []*DependencyPolicy `yaml:"policies"`
+	// Lockfiles are the files, relative to the repository root, that the
+	// lockfile check keeps up to date. Defaults to MODULE.bazel.lock.
+	Lockfiles []string `yaml:"lockfiles"`
+	// UpdateCommand regenerates Lockfiles. Defaults to
+	// ["bb", "mod", "deps", "--lockfile_mode=update"].
+	UpdateCommand []string `yaml:"update_command"`
+	// BuildozerActions are offered on failed runs of the check and push the
+	// changes of their buildozer commands when clicked.
+	BuildozerActions []*BuildozerAction `yaml:"buildozer_actions"`
+	// Retries reruns the tests that failed in the bazel-test check up to
+	// this many times. Tests that pass on a retry are reported as flaky and
+	// don't fail the check.
+	Retries int `yaml:"retries"`
+	// FlakyIssue files the tests found flaky on retries in an open issue
+	// labeled flaky-tests, creating it if there is none.
+	FlakyIssue bool `yaml:"flaky_issue"`
+	// CountOutOfDiff adds the number of issues that weren't annotated
+	// because they are outside of the pull request's diff to the summary.
+	CountOutOfDiff bool `yaml:"count_out_of_diff"`
+}
+
+// BazelConfig configures how checks build and test the repository with bazel,
+// with different settings for some branches.
+type BazelConfig struct {
+	BazelSettings `yaml:",inline"`
+	// Branches override the settings on the branches they match. The
+	// settings of the first match replace those they set.
+	Branches []*BazelBranchSettings `yaml:"branches"`
+	// Matrix fans the bazel build and test checks out into a check run per
+	// cell, named like "bazel-test (asan)", each with the settings of its
+	// cell added to those of the branch.
+	Matrix []*BazelMatrixCell `yaml:"matrix"`
+}
+
+// BazelSettings are the target patterns and flags of bazel builds and tests.
+type BazelSettings struct {
+	// Targets are the target patterns to build and test, unless a check
+	// sets its own. Defaults to //...
+	Targets []string `yaml:"targets"`
+	// Configs are the names of .bazelrc configs passed with --config.
+	Configs []string `yaml:"configs"`
+	// BuildFlags are passed to builds and tests, like the build options of
+	// a .bazelrc.
+	BuildFlags []string `yaml:"build_flags"`
+	// TestFlags are only passed to tests.
+	TestFlags []string `yaml:"test_flags"`
+}
+
+// BazelBranchSettings are the bazel settings of the branches matching Branch.
+type BazelBranchSettings struct {
+	// Branch is a glob of branch names, e.g. "release/*".
+	Branch        string `yaml:"branch"`
+	BazelSettings `yaml:",inline"`
+}
+
+// BazelMatrixCell is a configuration that matrix checks run in.
+type BazelMatrixCell struct {
+	// Name is shown in the names of the cell's check runs.
+	Name string `yaml:"name"`
+	// Targets replace those of the branch. Configs, BuildFlags and TestFlags
+	// are added to those of the branch.
+	BazelSettings `yaml:",inline"`
+}
+
+// hasBranches reports whether any branch has settings of its own.
+func (c *BazelConfig) hasBranches() bool {
+	return c != nil && len(c.Branches) > 0
+}
+
+// settings returns the bazel settings of the check checkName on branch, which
+// may be "" if it isn't known. It never returns nil.
+func (c *BazelConfig) settings(branch string, checkName string) *BazelSettings {
+	if c == nil {
+		return &BazelSettings{}
+	}
+	s := c.branchSettings(branch)
+	_, cellName := splitMatrixCheckName(checkName)
+	if cell := c.cell(cellName); cell != nil {
+		if len(cell.Targets) > 0 {
+			s.Targets = cell.Targets
+		}
+		s.Configs = append(append([]string(nil), s.Configs...), cell.Configs...)
+		s.BuildFlags = append(append([]string(nil), s.BuildFlags...), cell.BuildFlags...)
+		s.TestFlags = append(append([]string(nil), s.TestFlags...), cell.TestFlags...)
+	}
+	return &s
+}
+
+// cell returns the matrix cell named name, or nil if there is none.
+func (c *BazelConfig) cell(name string) *BazelMatrixCell {
+	if name == "" {
+		return nil
+	}
+	for _, cell := range c.Matrix {
+		if cell != nil && cell.Name == name {
+			return cell
+		}
+	}
+	return nil
+}
+
+// branchSettings returns the bazel settings of branch.
+func (c *BazelConfig) branchSettings(branch string) BazelSettings {
+	s := c.BazelSettings
+	if branch == "" {
+		return s
+	}
+	for _, b := range c.Branches {
+		if b == nil || !matchGlob(b.Branch, branch) {
+			continue
+		}
+		if len(b.Targets) > 0 {
+			s.Targets = b.Targets
+		}
+		if len(b.Configs) > 0 {
+			s.Configs = b.Configs
+		}
+		if len(b.BuildFlags) > 0 {
+			s.BuildFlags = b.BuildFlags
+		}
+		if len(b.TestFlags) > 0 {
+			s.TestFlags = b.TestFlags
+		}
+		break
+	}
+	return s
+}
+
+// expandMatrix replaces the matrix checks among checkNames with a check per
+// cell of the repository's matrix, if it has one. The names of cells are
+// kept.
+func (c *RepoConfig) expandMatrix(checkNames []string) []string {
+	if c == nil || c.Bazel == nil || len(c.Bazel.Matrix) == 0 {
+		return checkNames
+	}
+	var expanded []string
+	for _, checkName := range checkNames {
+		checker, err := GetChecker(checkName)
+		if _, cell := splitMatrixCheckName(checkName); err != nil || cell != "" || !isMatrixChecker(checker) {
+			expanded = append(expanded, checkName)
+			continue
+		}
+		for _, cell := range c.Bazel.Matrix {
+			if cell != nil && cell.Name != "" {
+				expanded = append(expanded, matrixCheckName(checkName, cell.Name))
+			}
+		}
+	}
+	return expanded
+}
+
+// flags returns the flags of the bazel command, "build" or "test".
+func (s *BazelSettings) flags(command string) []string {
+	var flags []string
+	for _, config := range s.Configs {
+		flags = append(flags, "--config="+config)
+	}
+	flags = append(flags, s.BuildFlags...)
+	if command == "test" {
+		flags = append(flags, s.TestFlags...)
+	}
+	return flags
+}
+
+// DependencyPolicy forbids targets from depending, directly or transitively,
+// on other targets. For example:
+//
+//	name: no-experimental
+//	from: //app/...
+//	forbidden: //experimental/...
+type DependencyPolicy struct {
+	Name string `yaml:"name"`
+	// From and Forbidden are bazel query expressions.
+	From      string `yaml:"from"`
+	Forbidden string `yaml:"forbidden"`
+}
+
+// Check returns the configuration of the named check. The checks of matrix
+// cells share the configuration of their check. It never returns nil.
+func (c *RepoConfig) Check(checkName string) *CheckConfig {
+	checkName, _ = splitMatrixCheckName(checkName)
+	if c != nil {
+		if cc, ok := c.Checks[checkName]; ok && cc != nil {
+			return cc
+		}
+	}
+	return &CheckConfig{}
+}
+
+// IsEnabled reports whether the check should run, given whether it runs when
+// the repository doesn't configure it.
+func (c *CheckConfig) IsEnabled(defaultEnabled bool) bool {
+	if c.Enabled == nil {
+		return defaultEnabled
+	}
+	return *c.Enabled
+}
+
+// ToolOr returns the configured tool, or defaultTool if none is configured.
+func (c *CheckConfig) ToolOr(defaultTool string) string {
+	if c.Tool == "" {
+		return defaultTool
+	}
+	return c.Tool
+}
+
+// BazelTargets returns the target patterns to pass to bazel, given the
+// repository's bazel settings.
+func (c *CheckConfig) BazelTargets(settings *BazelSettings) []string {
+	if len(c.Targets) > 0 {
+		return c.Targets
+	}
+	if settings != nil && len(settings.Targets) > 0 {
+		return settings.Targets
+	}
+	return []string{"//..."}
+}
+
+// MatchesPath reports whether the check's path filters include path, which is
+// relative to the repository root.
+func (c *CheckConfig) MatchesPath(path string) bool {
+	if len(c.Paths) == 0 {
+		return true
+	}
+	for _, pattern := range c.Paths {
+		if matchGlob(pattern, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// triggeredBy reports whether changes to files make the check run.
+func (c *CheckConfig) triggeredBy(files []string) bool {
+	if len(c.TriggerPaths) == 0 {
+		return true
+	}
+	for _, f := range files {
+		for _, pattern := range c.TriggerPaths {
+			if matchGlob(pattern, f) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// filterAnnotations drops annotations on files excluded by the check's path
+// filters.
+func (c *CheckConfig) filterAnnotations(annotations []*Annotation) []*Annotation {
+	if len(c.Paths) == 0 {
+		return annotations
+	}
+	filtered := []*Annotation{}
+	for _, a := range annotations {
+		if c.MatchesPath(a.Path) {
+			filtered = append(filtered, a)
+		}
+	}
+	return filtered
+}
+
+// matchGlob matches a slash-separated path against a glob pattern. In
+// addition to the syntax of path.Match, `**` matches any number of path
+// segments, including none.
+func matchGlob(pattern string, path string) bool {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+				if i+1 < len(pattern) && pattern[i+1] == '/' {
+					i++
+					b.WriteString("(?:.*/)?")
+				} else {
+					b.WriteString(".*")
+				}
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return false
+	}
+	return re.MatchString(path)
+}
+
+func parseRepoConfig(b []byte) (*RepoConfig, error) {
+	config := &RepoConfig{}
+	if err := yaml.Unmarshal(b, config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %s", repoConfigFile, err)
+	}
+	return config, nil
+}
+
+// loadRepoConfigFromDir reads the repository configuration from a checkout.
+// A missing file yields the default configuration.
+func loadRepoConfigFromDir(dir string) (*RepoConfig, error) {
+	b, err := os.ReadFile(filepath.Join(dir, repoConfigFile))
+	if os.IsNotExist(err) {
+		return &RepoConfig{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseRepoConfig(b)
+}
+
+// fetchRepoConfig reads the repository configuration at ref through the GitHub
+// API. A missing file yields the default configuration.
+func (app *GithubApp) fetchRepoConfig(ctx context.Context, installationID int64, owner string, repoName string, ref string) (*RepoConfig, error) {
+	return fetchRepoConfigWithClient(ctx, app.GetClient(installationID), owner, repoName, ref)
+}
+
+// fetchRepoConfigWithClient is like fetchRepoConfig, but reads the
+// configuration with ghc, e.g. the client of a job.
+func fetchRepoConfigWithClient(ctx context.Context, ghc *github.Client, owner string, repoName string, ref string) (*RepoConfig, error) {
+	content, _, res, err := ghc.Repositories.GetContents(ctx, owner, repoName, repoConfigFile, &github.RepositoryContentGetOptions{Ref: ref})
+	if res != nil && res.StatusCode == 404 {
+		return &RepoConfig{}, nil
+	}
+	if err := extractError(ctx, res, err); err != nil {
+		return nil, err
+	}
+	s, err := content.GetContent()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %s", repoConfigFile, err)
+	}
+	return parseRepoConfig([]byte(s))
+}
@@ -0,0 +1,301 @@
+package app
+
+import (
+	"encoding/base64"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v43/github"
+)
+
+func TestParseRepoConfig(t *testing.T) {
+	config, err := parseRepoConfig([]byte(`
+checks:
+  buildifier:
+    flags: ["--lint=warn"]
+    paths: ["**/BUILD"]
+  bazel:
+    targets: ["//app/..."]
+    timeout: 30m
+  bazel-test:
+    enabled: false
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := config.Check(buildifierCheck).Flags; !reflect.DeepEqual(got, []string{"--lint=warn"}) {
+		t.Errorf("buildifier flags = %v, want [--lint=warn]", got)
+	}
+	if got := config.Check(nogoCheck).BazelTargets(nil); !reflect.DeepEqual(got, []string{"//app/..."}) {
+		t.Errorf("bazel targets = %v, want [//app/...]", got)
+	}
+	if got := config.Check(nogoCheck).Timeout; got != 30*time.Minute {
+		t.Errorf("bazel timeout = %s, want 30m", got)
+	}
+	if config.Check(bazelTestCheck).IsEnabled(true) {
+		t.Errorf("bazel-test is enabled, want it disabled")
+	}
+	unlisted := config.Check("unlisted")
+	if !unlisted.IsEnabled(true) || unlisted.IsEnabled(false) || !reflect.DeepEqual(unlisted.BazelTargets(nil), []string{"//..."}) {
+		t.Errorf("unlisted check config = %+v, want the defaults", unlisted)
+	}
+
+	optedIn, err := parseRepoConfig([]byte("checks:\n  golangci-lint:\n    enabled: true\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !optedIn.Check(golangciLintCheck).IsEnabled(false) {
+		t.Errorf("golangci-lint is disabled, want the repository to opt in")
+	}
+
+	if _, err := parseRepoConfig([]byte("checks: [")); err == nil {
+		t.Errorf("parseRepoConfig of invalid YAML succeeded")
+	}
+}
+
+func TestLoadRepoConfigFromDirWithoutFile(t *testing.T) {
+	config, err := loadRepoConfigFromDir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !config.Check(buildifierCheck).IsEnabled(true) {
+		t.Errorf("buildifier is disabled without a config file")
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	for _, tc := range []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"**/BUILD", "BUILD", true},
+		{"**/BUILD", "app/lib/BUILD", true},
+		{"**/BUILD", "app/BUILD.bazel", false},
+		{"app/*.go", "app/app.go", true},
+		{"app/*.go", "app/lib/lib.go", false},
+		{"app/**", "app/lib/lib.go", true},
+		{"*.bz?", "defs.bzl", true},
+	} {
+		if got := matchGlob(tc.pattern, tc.path); got != tc.want {
+			t.Errorf("matchGlob(%q, %q) = %t, want %t", tc.pattern, tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestFilterAnnotations(t *testing.T) {
+	annotations := []*Annotation{{Path: "app/BUILD"}, {Path: "app/app.go"}}
+	config := &CheckConfig{Paths: []string{"**/BUILD"}}
+
+	got := config.filterAnnotations(annotations)
+	if len(got) != 1 || got[0].Path != "app/BUILD" {
+		t.Errorf("filterAnnotations kept %d annotations, want only the one on app/BUILD", len(got))
+	}
+	if got := (&CheckConfig{}).filterAnnotations(annotations); len(got) != 2 {
+		t.Errorf("filterAnnotations without paths kept %d annotations, want all", len(got))
+	}
+}
+
+// serveRepoConfig lets f serve content as the .reviewbot.yaml of o/r.
+func serveRepoConfig(f *fakeGitHub, content string) {
+	f.handle("GET /repos/o/r/contents/"+repoConfigFile, func(w http.ResponseWriter, req *http.Request) {
+		writeTestJSON(w, http.StatusOK, map[string]interface{}{
+			"type":     "file",
+			"name":     repoConfigFile,
+			"path":     repoConfigFile,
+			"encoding": "base64",
+			"content":  base64.StdEncoding.EncodeToString([]byte(content)),
+		})
+	})
+}
+
+func TestSkipReason(t *testing.T) {
+	setTestSettings(t, func(s *Settings) {
+		s.SkipDrafts = false
+		s.SkipLabels = []string{"skip-ci"}
+	})
+
+	draft := testPR(1, "open", true, "main", "abc")
+	labeled := testPR(2, "open", false, "main", "def")
+	labeled.Labels = []*github.Label{{Name: github.String("bug")}, {Name: github.String("Skip-CI")}}
+	repoLabeled := testPR(3, "open", false, "main", "123")
+	repoLabeled.Labels = []*github.Label{{Name: github.String("wip")}}
+	skipDrafts := true
+
+	for _, tc := range []struct {
+		desc   string
+		config *RepoConfig
+		pr     *github.PullRequest
+		want   string
+	}{
+		{desc: "no pull request", config: &RepoConfig{}, want: ""},
+		{desc: "draft", config: &RepoConfig{}, pr: draft, want: ""},
+		{desc: "draft skipped by repository", config: &RepoConfig{SkipDrafts: &skipDrafts}, pr: draft, want: "pull request is a draft"},
+		{desc: "app-wide skip label", config: &RepoConfig{}, pr: labeled, want: `pull request is labeled "Skip-CI"`},
+		{desc: "repository skip label", config: &RepoConfig{SkipLabels: []string{"wip"}}, pr: repoLabeled, want: `pull request is labeled "wip"`},
+		{desc: "other label", config: &RepoConfig{}, pr: repoLabeled, want: ""},
+	} {
+		if got := tc.config.skipReason(tc.pr); got != tc.want {
+			t.Errorf("%s: got skip reason %q, want %q", tc.desc, got, tc.want)
+		}
+	}
+}
+
+func TestTriggeredBy(t *testing.T) {
+	c := &CheckConfig{TriggerPaths: []string{"**/BUILD*", "**/*.bzl"}}
+	for _, tc := range []struct {
+		files []string
+		want  bool
+	}{
+		{files: []string{"main.go", "lib/BUILD.bazel"}, want: true},
+		{files: []string{"tools/defs.bzl"}, want: true},
+		{files: []string{"main.go", "README.md"}, want: false},
+		{files: nil, want: false},
+	} {
+		if got := c.triggeredBy(tc.files); got != tc.want {
+			t.Errorf("triggeredBy(%q) = %t, want %t", tc.files, got, tc.want)
+		}
+	}
+	if !(&CheckConfig{}).triggeredBy(nil) {
+		t.Errorf("check without trigger paths isn't triggered")
+	}
+}
+
+func TestBazelConfigSettings(t *testing.T) {
+	config, err := parseRepoConfig([]byte(`
+bazel:
+  targets: ["//app/..."]
+  configs: ["ci"]
+  build_flags: ["--keep_going"]
+  test_flags: ["--test_output=errors"]
+  branches:
+    - branch: "release/*"
+      configs: ["ci", "opt"]
+    - branch: "release/1.0"
+      targets: ["//legacy/..."]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !config.Bazel.hasBranches() {
+		t.Errorf("hasBranches = false, want true")
+	}
+	for _, tc := range []struct {
+		branch string
+		want   BazelSettings
+	}{
+		{"", BazelSettings{Targets: []string{"//app/..."}, Configs: []string{"ci"}, BuildFlags: []string{"--keep_going"}, TestFlags: []string{"--test_output=errors"}}},
+		{"main", BazelSettings{Targets: []string{"//app/..."}, Configs: []string{"ci"}, BuildFlags: []string{"--keep_going"}, TestFlags: []string{"--test_output=errors"}}},
+		// Only the first matching branch applies.
+		{"release/1.0", BazelSettings{Targets: []string{"//app/..."}, Configs: []string{"ci", "opt"}, BuildFlags: []string{"--keep_going"}, TestFlags: []string{"--test_output=errors"}}},
+	} {
+		if got := config.Bazel.settings(tc.branch, nogoCheck); !reflect.DeepEqual(*got, tc.want) {
+			t.Errorf("settings(%q) = %+v, want %+v", tc.branch, *got, tc.want)
+		}
+	}
+
+	var none *BazelConfig
+	if none.hasBranches() || !reflect.DeepEqual(*none.settings("main", nogoCheck), BazelSettings{}) {
+		t.Errorf("a repository without bazel settings got some")
+	}
+}
+
+func TestBazelSettingsFlags(t *testing.T) {
+	s := &BazelSettings{Configs: []string{"ci", "opt"}, BuildFlags: []string{"--keep_going"}, TestFlags: []string{"--test_output=errors"}}
+	if got, want := s.flags("build"), []string{"--config=ci", "--config=opt", "--keep_going"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("build flags = %q, want %q", got, want)
+	}
+	if got, want := s.flags("test"), []string{"--config=ci", "--config=opt", "--keep_going", "--test_output=errors"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("test flags = %q, want %q", got, want)
+	}
+
+	target := &CheckTarget{Config: &CheckConfig{Flags: []string{"--nogo"}}, bazel: s}
+	if got, want := target.bazelFlags("build"), []string{"--config=ci", "--config=opt", "--keep_going", "--nogo"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("check build flags = %q, want the repository's flags before the check's %q", got, want)
+	}
+	target.bazel = nil
+	if got := target.bazelFlags("test"); !reflect.DeepEqual(got, []string{"--nogo"}) {
+		t.Errorf("check test flags without settings = %q, want the check's", got)
+	}
+}
+
+func TestCheckConfigBazelTargets(t *testing.T) {
+	settings := &BazelSettings{Targets: []string{"//app/..."}}
+	if got := (&CheckConfig{Targets: []string{"//cmd/..."}}).BazelTargets(settings); !reflect.DeepEqual(got, []string{"//cmd/..."}) {
+		t.Errorf("targets of a check with its own = %q, want them", got)
+	}
+	if got := (&CheckConfig{}).BazelTargets(settings); !reflect.DeepEqual(got, []string{"//app/..."}) {
+		t.Errorf("targets of a check without its own = %q, want the repository's", got)
+	}
+	if got := (&CheckConfig{}).BazelTargets(&BazelSettings{}); !reflect.DeepEqual(got, []string{"//..."}) {
+		t.Errorf("targets without settings = %q, want //...", got)
+	}
+}
+
+func TestBazelConfigMatrixSettings(t *testing.T) {
+	config, err := parseRepoConfig([]byte(`
+bazel:
+  configs: ["ci"]
+  matrix:
+    - name: linux
+    - name: asan
+      configs: ["asan"]
+      test_flags: ["--test_tag_filters=-no-asan"]
+    - name: cross
+      targets: ["//cmd/..."]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		checkName string
+		want      BazelSettings
+	}{
+		{bazelTestCheck, BazelSettings{Configs: []string{"ci"}}},
+		{matrixCheckName(bazelTestCheck, "linux"), BazelSettings{Configs: []string{"ci"}}},
+		{matrixCheckName(bazelTestCheck, "asan"), BazelSettings{Configs: []string{"ci", "asan"}, TestFlags: []string{"--test_tag_filters=-no-asan"}}},
+		{matrixCheckName(nogoCheck, "cross"), BazelSettings{Targets: []string{"//cmd/..."}, Configs: []string{"ci"}}},
+		{matrixCheckName(bazelTestCheck, "unknown"), BazelSettings{Configs: []string{"ci"}}},
+	} {
+		if got := config.Bazel.settings("", tc.checkName); !reflect.DeepEqual(*got, tc.want) {
+			t.Errorf("settings of %q = %+v, want %+v", tc.checkName, *got, tc.want)
+		}
+	}
+	// Cells don't change the settings of the branch.
+	if got := config.Bazel.settings("", bazelTestCheck); !reflect.DeepEqual(got.Configs, []string{"ci"}) {
+		t.Errorf("configs of the branch = %q after a cell's, want [ci]", got.Configs)
+	}
+}
+
+func TestExpandMatrix(t *testing.T) {
+	config, err := parseRepoConfig([]byte("bazel:\n  matrix:\n    - name: linux\n    - name: asan\n    - {}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := config.expandMatrix([]string{buildifierCheck, nogoCheck, matrixCheckName(bazelTestCheck, "asan"), "unknown"})
+	want := []string{buildifierCheck, matrixCheckName(nogoCheck, "linux"), matrixCheckName(nogoCheck, "asan"), matrixCheckName(bazelTestCheck, "asan"), "unknown"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expandMatrix = %q, want %q", got, want)
+	}
+
+	checkNames := []string{buildifierCheck, nogoCheck}
+	if got := (&RepoConfig{}).expandMatrix(checkNames); !reflect.DeepEqual(got, checkNames) {
+		t.Errorf("expandMatrix without a matrix = %q, want %q", got, checkNames)
+	}
+	var none *RepoConfig
+	if got := none.expandMatrix(checkNames); !reflect.DeepEqual(got, checkNames) {
+		t.Errorf("expandMatrix without a config = %q, want %q", got, checkNames)
+	}
+
+	// The checks of cells share the configuration of their check.
+	config, err = parseRepoConfig([]byte("checks:\n  bazel-test:\n    flags: [--nocache_test_results]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := config.Check(matrixCheckName(bazelTestCheck, "asan")).Flags; !reflect.DeepEqual(got, []string{"--nocache_test_results"}) {
+		t.Errorf("flags of a cell = %q, want those of %s", got, bazelTestCheck)
+	}
+}
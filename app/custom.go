@@ -0,0 +1,180 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// CustomCheck is a check defined by the operator rather than built into the
+// bot. It runs a command in the checkout and reports the findings it prints
+// as file:line:col: message lines. For example, in the file of
+// --checks.custom:
+//
+//	checks:
+//	  - name: mypy
+//	    command: ["mypy", "--no-color-output", "--show-column-numbers", "."]
+//	  - name: detekt
+//	    command: ["detekt", "--report", "txt:build/detekt.txt"]
+//	    report_file: build/detekt.txt
+//	    opt_in: true
+type CustomCheck struct {
+	// Name is the name of the check and its check run.
+	Name string `yaml:"name"`
+	// Command is the tool and its arguments. The flags of the check's
+	// configuration in .reviewbot.yaml are appended to it.
+	Command []string `yaml:"command"`
+	// ReportFile is where the tool writes its findings, relative to the
+	// checkout. If set, the findings are read from it once the command exits
+	// instead of from the command's output, and it is removed afterwards.
+	ReportFile string `yaml:"report_file"`
+	// OptIn makes the check only run in repositories that enable it.
+	OptIn bool `yaml:"opt_in"`
+	// Description is a one-line summary of what the check enforces.
+	Description string `yaml:"description"`
+}
+
+// LoadCustomChecks registers the custom checks listed under checks in the
+// YAML file at path.
+func LoadCustomChecks(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read custom checks: %s", err)
+	}
+	var file struct {
+		Checks []*CustomCheck `yaml:"checks"`
+	}
+	if err := yaml.Unmarshal(b, &file); err != nil {
+		return fmt.Errorf("failed to parse custom checks in %s: %s", path, err)
+	}
+	for _, c := range file.Checks {
+		if err := c.validate(); err != nil {
+			return err
+		}
+	}
+	for _, c := range file.Checks {
+		c.register()
+	}
+	return nil
+}
+
+func (c *CustomCheck) validate() error {
+	if c == nil || c.Name == "" {
+		return fmt.Errorf("custom check has no name")
+	}
+	if _, err := GetChecker(c.Name); err == nil {
+		return fmt.Errorf("custom check %q has the name of another check", c.Name)
+	}
+	if len(c.Command) == 0 || c.Command[0] == "" {
+		return fmt.Errorf("custom check %q has no command", c.Name)
+	}
+	if c.ReportFile != "" {
+		if filepath.IsAbs(c.ReportFile) || strings.HasPrefix(filepath.Clean(c.ReportFile), "..") {
+			return fmt.Errorf("report file %q of custom check %q must be relative to the checkout", c.ReportFile, c.Name)
+		}
+	}
+	return nil
+}
+
+func (c *CustomCheck) register() {
+	RegisterChecker(&funcChecker{
+		name:         c.Name,
+		fn:           c.run,
+		optIn:        c.OptIn,
+		requirements: CheckRequirements{Binaries: []string{c.Command[0]}},
+		rule:         CheckRule{Description: c.Description},
+	})
+}
+
+// run runs the check's command on target and reports its findings.
+func (c *CustomCheck) run(ctx context.Context, _ *GithubApp, target *CheckTarget) (*Result, error) {
+	res := &Result{
+		Title: fmt.Sprintf("%s result", c.Name),
+	}
+	var reportPath string
+	if c.ReportFile != "" {
+		reportPath = filepath.Join(target.Dir, c.ReportFile)
+		// Don't take a stale report, e.g. one committed by mistake, for the
+		// findings of this run.
+		if err := os.Remove(reportPath); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to remove stale report file: %s", err)
+		}
+		defer os.Remove(reportPath)
+	}
+
+	args := append(append([]string(nil), c.Command[1:]...), target.Config.Flags...)
+	// Linters usually exit with an error when they find issues, so the exit
+	// status only fails the check if there are no findings to explain it.
+	stdOut, stdErr, err := runCheckCmd(ctx, target, c.Command[0], args...)
+	var findings io.Reader = io.MultiReader(&stdOut, &stdErr)
+	if reportPath != "" {
+		report, readErr := os.ReadFile(reportPath)
+		if readErr != nil {
+			if err != nil {
+				return nil, fmt.Errorf("%s failed: %s: %s", c.Command[0], err, cleanLine(stdErr.String()))
+			}
+			return nil, fmt.Errorf("%s didn't write its report file %s: %s", c.Command[0], c.ReportFile, readErr)
+		}
+		findings = bytes.NewReader(report)
+	}
+	annotations := target.Config.filterAnnotations(parseFindings(ctx, target.Dir, findings))
+
+	switch {
+	case len(annotations) > 0:
+		res.Summary = fmt.Sprintf("%d issues found", len(annotations))
+		res.Annotations = annotations
+		res.Conclusion = "failure"
+	case err != nil:
+		res.Summary = fmt.Sprintf("%s failed: %s", c.Command[0], err)
+		res.Text = truncateText(target.log.String(), maxCheckRunText)
+		res.Conclusion = "failure"
+	default:
+		res.Summary = "No issues found."
+		res.Conclusion = "success"
+	}
+	return res, nil
+}
+
+// parseFindings parses file:line:col: message lines into annotations. Paths
+// are made relative to dir if they are absolute.
+func parseFindings(ctx context.Context, dir string, r io.Reader) []*Annotation {
+	annotations := []*Annotation{}
+	seen := make(map[string]struct{})
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(cleanLine(scanner.Text()))
+		matches := lineCommentRegex.FindStringSubmatch(line)
+		if len(matches) == 0 {
+			continue
+		}
+		if _, ok := seen[line]; ok {
+			continue
+		}
+		seen[line] = struct{}{}
+		path := matches[lineCommentRegex.SubexpIndex("file")]
+		if rel, err := filepath.Rel(dir, path); err == nil && filepath.IsAbs(path) {
+			path = filepath.ToSlash(rel)
+		}
+		lineNum, _ := strconv.Atoi(matches[lineCommentRegex.SubexpIndex("line")])
+		column, _ := strconv.Atoi(matches[lineCommentRegex.SubexpIndex("col")])
+		annotations = append(annotations, &Annotation{
+			Message:  strings.TrimSpace(matches[lineCommentRegex.SubexpIndex("comment")]),
+			Severity: "failure",
+			Path:     path,
+			Line:     lineNum,
+			Column:   column,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		logFrom(ctx).Warnw("failed to read findings", "error", err)
+	}
+	return annotations
+}
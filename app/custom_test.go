@@ -0,0 +1,149 @@
+package app
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFakeTool writes an executable shell script to a temporary directory
+// and returns its path.
+func writeFakeTool(t *testing.T, script string) string {
+	path := filepath.Join(t.TempDir(), "fake-lint")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// installFakeTool puts an executable shell script named name first on PATH
+// for the duration of the test.
+func installFakeTool(t *testing.T, name string, script string) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestCustomCheckReadsReportFile(t *testing.T) {
+	tool := writeFakeTool(t, `
+echo "decoy.go:1:1: printed, not reported"
+mkdir -p out
+printf '%s\n' "main.go:3:5: unused variable x" "lib/util.go:10:1: missing doc comment" > out/report.txt
+exit 1
+`)
+	dir := t.TempDir()
+	c := &CustomCheck{Name: "fake-lint", Command: []string{tool}, ReportFile: "out/report.txt"}
+	if err := c.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := c.run(context.Background(), nil, &CheckTarget{Dir: dir, Config: &CheckConfig{}})
+	if err != nil {
+		t.Fatalf("run: %s", err)
+	}
+	if res.Conclusion != "failure" {
+		t.Errorf("got conclusion %q, want failure", res.Conclusion)
+	}
+	want := []Annotation{
+		{Path: "main.go", Line: 3, Column: 5, Message: "unused variable x", Severity: "failure"},
+		{Path: "lib/util.go", Line: 10, Column: 1, Message: "missing doc comment", Severity: "failure"},
+	}
+	if len(res.Annotations) != len(want) {
+		t.Fatalf("got %d annotations, want %d", len(res.Annotations), len(want))
+	}
+	for i, a := range res.Annotations {
+		if *a != want[i] {
+			t.Errorf("annotation %d: got %+v, want %+v", i, *a, want[i])
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "out/report.txt")); !os.IsNotExist(err) {
+		t.Errorf("report file wasn't removed: %v", err)
+	}
+}
+
+func TestCustomCheckIgnoresStaleReportFile(t *testing.T) {
+	tool := writeFakeTool(t, "exit 0\n")
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "report.txt"), []byte("main.go:1:1: stale finding\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := &CustomCheck{Name: "fake-lint", Command: []string{tool}, ReportFile: "report.txt"}
+
+	if _, err := c.run(context.Background(), nil, &CheckTarget{Dir: dir, Config: &CheckConfig{}}); err == nil {
+		t.Errorf("run succeeded although the tool didn't write its report file")
+	}
+}
+
+func TestCustomCheckParsesOutput(t *testing.T) {
+	tool := writeFakeTool(t, `echo "$PWD/main.go:3:5: unused variable x"; exit 1`)
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &CustomCheck{Name: "fake-lint", Command: []string{tool}}
+
+	res, err := c.run(context.Background(), nil, &CheckTarget{Dir: dir, Config: &CheckConfig{}})
+	if err != nil {
+		t.Fatalf("run: %s", err)
+	}
+	if len(res.Annotations) != 1 || res.Annotations[0].Path != "main.go" {
+		t.Errorf("got annotations %+v, want one on main.go", res.Annotations)
+	}
+}
+
+func TestCustomCheckShowsOutputOfFailure(t *testing.T) {
+	tool := writeFakeTool(t, "echo 'lint: no config found' >&2; exit 2\n")
+	c := &CustomCheck{Name: "fake-lint", Command: []string{tool}}
+
+	res, err := c.run(context.Background(), nil, &CheckTarget{Dir: t.TempDir(), Config: &CheckConfig{}, log: &commandLog{}})
+	if err != nil {
+		t.Fatalf("run: %s", err)
+	}
+	if res.Conclusion != "failure" || !strings.Contains(res.Text, "lint: no config found") {
+		t.Errorf("got %s with text %q, want a failure showing the tool's output", res.Conclusion, res.Text)
+	}
+}
+
+func TestCustomCheckOptIn(t *testing.T) {
+	for _, c := range []*CustomCheck{
+		{Name: "fake-lint", Command: []string{"lint"}},
+		{Name: "fake-opt-in-lint", Command: []string{"lint"}, OptIn: true},
+	} {
+		c.register()
+		t.Cleanup(func() { unregisterChecker(c.Name) })
+		checker, err := GetChecker(c.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if enabledByDefault(checker) == c.OptIn {
+			t.Errorf("check %s is enabled by default: %t, want %t", c.Name, enabledByDefault(checker), !c.OptIn)
+		}
+	}
+}
+
+func TestCustomCheckRule(t *testing.T) {
+	c := &CustomCheck{Name: "fake-described-lint", Description: "Files pass fake-described-lint.", Command: []string{"lint"}}
+	c.register()
+	t.Cleanup(func() { unregisterChecker(c.Name) })
+	if r := sarifRule(c.Name); r.ShortDescription == nil || r.ShortDescription.Text != c.Description {
+		t.Errorf("rule of %s = %+v, want its description", c.Name, r)
+	}
+}
+
+func TestCustomCheckValidate(t *testing.T) {
+	for _, c := range []*CustomCheck{
+		{Command: []string{"lint"}},
+		{Name: "lint"},
+		{Name: buildifierCheck, Command: []string{"lint"}},
+		{Name: "lint", Command: []string{"lint"}, ReportFile: "/tmp/report.txt"},
+		{Name: "lint", Command: []string{"lint"}, ReportFile: "../report.txt"},
+	} {
+		if err := c.validate(); err == nil {
+			t.Errorf("validate(%+v) succeeded, want an error", c)
+		}
+	}
+}
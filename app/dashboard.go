@@ -0,0 +1,176 @@
+package app
+
+import (
+	"crypto/subtle"
+	_ "embed"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed dashboard.html
+var dashboardHTML []byte
+
+const (
+	// maxDashboardRuns caps the runs listed by the dashboard.
+	maxDashboardRuns = 500
+	// maxTrendRuns caps the runs that duration trends are computed from.
+	maxTrendRuns = 10000
+)
+
+// dashboardRun is a check run record as served to the dashboard.
+type dashboardRun struct {
+	CheckRunID  int64         `json:"check_run_id"`
+	Repo        string        `json:"repo"`
+	HeadSHA     string        `json:"head_sha"`
+	CheckName   string        `json:"check"`
+	Status      string        `json:"status"`
+	Conclusion  string        `json:"conclusion"`
+	StartedAt   time.Time     `json:"started_at"`
+	DurationSec float64       `json:"duration_sec"`
+	LogExcerpt  string        `json:"log_excerpt,omitempty"`
+	Annotations []*Annotation `json:"annotations,omitempty"`
+}
+
+func newDashboardRun(r *CheckRunRecord) *dashboardRun {
+	return &dashboardRun{
+		CheckRunID:  r.CheckRunID,
+		Repo:        r.FullRepoName,
+		HeadSHA:     r.HeadSHA,
+		CheckName:   r.CheckName,
+		Status:      r.Status,
+		Conclusion:  r.Conclusion,
+		StartedAt:   r.StartedAt,
+		DurationSec: r.Duration.Seconds(),
+	}
+}
+
+// trendPoint is the average duration of a check's completed runs on a day.
+type trendPoint struct {
+	Check       string  `json:"check"`
+	Day         string  `json:"day"`
+	Runs        int     `json:"runs"`
+	Failures    int     `json:"failures"`
+	DurationSec float64 `json:"avg_duration_sec"`
+}
+
+// DashboardHandler serves a web UI for the check run history in the app's
+// store under /dashboard/. If password is set, it is required as the
+// password of HTTP basic auth.
+func (app *GithubApp) DashboardHandler(password string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if password != "" {
+			_, p, _ := req.BasicAuth()
+			if subtle.ConstantTimeCompare([]byte(p), []byte(password)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="reviewbot"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		if req.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		path := strings.Trim(strings.TrimPrefix(req.URL.Path, "/dashboard"), "/")
+		if path == "" {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write(dashboardHTML)
+			return
+		}
+		if app.store == nil {
+			http.Error(w, "no check run store is configured", http.StatusNotFound)
+			return
+		}
+		q := req.URL.Query()
+		filter := CheckRunFilter{
+			FullRepoName: q.Get("repo"),
+			CheckName:    q.Get("check"),
+			Conclusion:   q.Get("conclusion"),
+			Limit:        maxDashboardRuns,
+		}
+		switch {
+		case path == "api/runs":
+			records, err := app.store.ListCheckRuns(req.Context(), filter)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			runs := make([]*dashboardRun, 0, len(records))
+			for _, r := range records {
+				runs = append(runs, newDashboardRun(r))
+			}
+			writeJSON(w, runs)
+		case strings.HasPrefix(path, "api/runs/"):
+			id, err := strconv.ParseInt(strings.TrimPrefix(path, "api/runs/"), 10, 64)
+			if err != nil {
+				http.Error(w, "invalid check run ID", http.StatusBadRequest)
+				return
+			}
+			records, err := app.store.ListCheckRuns(req.Context(), CheckRunFilter{CheckRunID: id})
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			if len(records) == 0 {
+				http.NotFound(w, req)
+				return
+			}
+			run := newDashboardRun(records[0])
+			run.LogExcerpt = records[0].LogExcerpt
+			if as, ok := app.store.(AnnotationStore); ok {
+				if run.Annotations, err = as.ListAnnotations(req.Context(), id); err != nil {
+					writeError(w, err)
+					return
+				}
+			}
+			writeJSON(w, run)
+		case path == "api/trends":
+			filter.Status = "completed"
+			filter.Conclusion = ""
+			filter.Limit = maxTrendRuns
+			records, err := app.store.ListCheckRuns(req.Context(), filter)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			writeJSON(w, durationTrends(records))
+		default:
+			http.NotFound(w, req)
+		}
+	}
+}
+
+// durationTrends returns the daily average duration of each check's
+// completed runs, ordered by check and day.
+func durationTrends(records []*CheckRunRecord) []*trendPoint {
+	points := make(map[string]*trendPoint)
+	for _, r := range records {
+		day := r.StartedAt.UTC().Format("2006-01-02")
+		key := r.CheckName + "@" + day
+		p, ok := points[key]
+		if !ok {
+			p = &trendPoint{Check: r.CheckName, Day: day}
+			points[key] = p
+		}
+		// Accumulate the total and divide once all runs are counted.
+		p.DurationSec += r.Duration.Seconds()
+		p.Runs++
+		if r.Conclusion == "failure" || r.Conclusion == "timed_out" {
+			p.Failures++
+		}
+	}
+	trends := make([]*trendPoint, 0, len(points))
+	for _, p := range points {
+		p.DurationSec /= float64(p.Runs)
+		trends = append(trends, p)
+	}
+	sort.Slice(trends, func(i, j int) bool {
+		if trends[i].Check != trends[j].Check {
+			return trends[i].Check < trends[j].Check
+		}
+		return trends[i].Day < trends[j].Day
+	})
+	return trends
+}
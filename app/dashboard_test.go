@@ -0,0 +1,189 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dashboardRequest serves a GET request for target to the dashboard of app
+// and returns the response.
+func dashboardRequest(app *GithubApp, password string, target string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	if password != "" {
+		req.SetBasicAuth("admin", password)
+	}
+	w := httptest.NewRecorder()
+	app.DashboardHandler(password)(w, req)
+	return w
+}
+
+// newDashboardStore returns a store with a failed and a successful gofmt run
+// on consecutive days and a bazel run in progress.
+func newDashboardStore(t *testing.T) *memStore {
+	store := newMemStore()
+	ctx := context.Background()
+	day := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	for _, r := range []*CheckRunRecord{
+		{CheckRunID: 1, FullRepoName: "o/r", HeadSHA: "abc", CheckName: gofmtCheck, StartedAt: day},
+		{CheckRunID: 2, FullRepoName: "o/r", HeadSHA: "def", CheckName: gofmtCheck, StartedAt: day.Add(24 * time.Hour)},
+		{CheckRunID: 3, FullRepoName: "o/other", HeadSHA: "def", CheckName: nogoCheck, StartedAt: day.Add(25 * time.Hour)},
+	} {
+		if err := store.StartCheckRun(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.CompleteCheckRun(ctx, 1, "failure", day.Add(4*time.Second), "main.go isn't formatted"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetAnnotations(ctx, 1, []*Annotation{{Path: "main.go", Line: 1, Severity: "failure", Message: "not formatted"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CompleteCheckRun(ctx, 2, "success", day.Add(24*time.Hour+2*time.Second), ""); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestDashboardHandlerServesUI(t *testing.T) {
+	app := &GithubApp{}
+	w := dashboardRequest(app, "", "/dashboard/")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") || w.Body.Len() == 0 {
+		t.Errorf("got status %d with %q, want the embedded page", w.Code, w.Header().Get("Content-Type"))
+	}
+	if w := dashboardRequest(app, "", "/dashboard/api/runs"); w.Code != http.StatusNotFound {
+		t.Errorf("got status %d without a store, want %d", w.Code, http.StatusNotFound)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/dashboard/", nil)
+	w = httptest.NewRecorder()
+	app.DashboardHandler("")(w, req)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("got status %d for a POST, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestDashboardHandlerRequiresPassword(t *testing.T) {
+	app := &GithubApp{}
+	req := httptest.NewRequest(http.MethodGet, "/dashboard/", nil)
+	req.SetBasicAuth("admin", "wrong")
+	w := httptest.NewRecorder()
+	app.DashboardHandler("secret")(w, req)
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("got status %d, want a basic auth challenge", w.Code)
+	}
+	if w := dashboardRequest(app, "secret", "/dashboard/"); w.Code != http.StatusOK {
+		t.Errorf("got status %d with the password, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestDashboardHandlerListsRuns(t *testing.T) {
+	app := &GithubApp{}
+	app.SetStore(newDashboardStore(t))
+
+	for target, want := range map[string][]int64{
+		"/dashboard/api/runs":                    {3, 2, 1},
+		"/dashboard/api/runs?repo=o/r":           {2, 1},
+		"/dashboard/api/runs?conclusion=failure": {1},
+		"/dashboard/api/runs?check=bazel":        {3},
+	} {
+		w := dashboardRequest(app, "", target)
+		var runs []*dashboardRun
+		if err := json.NewDecoder(w.Body).Decode(&runs); err != nil {
+			t.Fatalf("%s: %s", target, err)
+		}
+		var got []int64
+		for _, r := range runs {
+			got = append(got, r.CheckRunID)
+			if r.LogExcerpt != "" || len(r.Annotations) != 0 {
+				t.Errorf("%s: got the log and annotations of check run %d, want them only in its details", target, r.CheckRunID)
+			}
+		}
+		if len(got) != len(want) {
+			t.Errorf("%s: got check runs %v, want %v", target, got, want)
+			continue
+		}
+		for i := range got {
+			if got[i] != want[i] {
+				t.Errorf("%s: got check runs %v, want %v", target, got, want)
+				break
+			}
+		}
+	}
+}
+
+func TestDashboardHandlerServesRunDetails(t *testing.T) {
+	app := &GithubApp{}
+	app.SetStore(newDashboardStore(t))
+
+	w := dashboardRequest(app, "", "/dashboard/api/runs/1")
+	run := &dashboardRun{}
+	if err := json.NewDecoder(w.Body).Decode(run); err != nil {
+		t.Fatal(err)
+	}
+	if run.Conclusion != "failure" || run.DurationSec != 4 || run.LogExcerpt != "main.go isn't formatted" {
+		t.Errorf("got run %+v, want the failed gofmt run with its log", run)
+	}
+	if len(run.Annotations) != 1 || run.Annotations[0].Path != "main.go" {
+		t.Errorf("got annotations %v, want the run's", run.Annotations)
+	}
+
+	if w := dashboardRequest(app, "", "/dashboard/api/runs/99"); w.Code != http.StatusNotFound {
+		t.Errorf("got status %d for an unknown run, want %d", w.Code, http.StatusNotFound)
+	}
+	if w := dashboardRequest(app, "", "/dashboard/api/runs/abc"); w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d for an invalid ID, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestDashboardHandlerServesTrends(t *testing.T) {
+	app := &GithubApp{}
+	app.SetStore(newDashboardStore(t))
+
+	w := dashboardRequest(app, "", "/dashboard/api/trends?conclusion=failure")
+	var trends []*trendPoint
+	if err := json.NewDecoder(w.Body).Decode(&trends); err != nil {
+		t.Fatal(err)
+	}
+	// The conclusion filter doesn't apply and the running bazel check is
+	// left out.
+	want := []trendPoint{
+		{Check: gofmtCheck, Day: "2022-05-01", Runs: 1, Failures: 1, DurationSec: 4},
+		{Check: gofmtCheck, Day: "2022-05-02", Runs: 1, DurationSec: 2},
+	}
+	if len(trends) != len(want) {
+		t.Fatalf("got trends %+v, want %+v", trends, want)
+	}
+	for i, p := range trends {
+		if *p != want[i] {
+			t.Errorf("trend %d: got %+v, want %+v", i, *p, want[i])
+		}
+	}
+}
+
+func TestDurationTrends(t *testing.T) {
+	day := time.Date(2022, 5, 1, 23, 0, 0, 0, time.UTC)
+	trends := durationTrends([]*CheckRunRecord{
+		{CheckName: nogoCheck, StartedAt: day, Duration: 10 * time.Second, Conclusion: "timed_out"},
+		{CheckName: gofmtCheck, StartedAt: day, Duration: time.Second, Conclusion: "success"},
+		{CheckName: nogoCheck, StartedAt: day.Add(-time.Hour), Duration: 20 * time.Second, Conclusion: "success"},
+		{CheckName: nogoCheck, StartedAt: day.Add(2 * time.Hour), Duration: 30 * time.Second, Conclusion: "failure"},
+	})
+	want := []trendPoint{
+		{Check: nogoCheck, Day: "2022-05-01", Runs: 2, Failures: 1, DurationSec: 15},
+		{Check: nogoCheck, Day: "2022-05-02", Runs: 1, Failures: 1, DurationSec: 30},
+		{Check: gofmtCheck, Day: "2022-05-01", Runs: 1, DurationSec: 1},
+	}
+	if len(trends) != len(want) {
+		t.Fatalf("got trends %+v, want %+v", trends, want)
+	}
+	for i, p := range trends {
+		if *p != want[i] {
+			t.Errorf("trend %d: got %+v, want %+v", i, *p, want[i])
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// deliveryTTL is how long a webhook delivery ID is remembered. GitHub
+	// redeliveries that arrive within this window are acknowledged but not
+	// reprocessed.
+	deliveryTTL = 10 * time.Minute
+	// inFlightTTL bounds how long a check run is considered in flight, so a
+	// crashed handler can't block a check forever.
+	inFlightTTL = 2 * time.Hour
+	// maxDeliveries bounds how many delivery IDs are remembered in memory.
+	// The oldest are forgotten first.
+	maxDeliveries = 10000
+	// persistedDeliveryTTL is how long a delivery ID is kept in a
+	// DeliveryStore.
+	persistedDeliveryTTL = 24 * time.Hour
+)
+
+// DeliveryStore remembers webhook deliveries across restarts, and across
+// replicas sharing the store. A Store that implements it is used to detect
+// redeliveries in addition to the in-memory set.
+type DeliveryStore interface {
+	// AddDelivery records a delivery. It returns false if the delivery has
+	// already been recorded.
+	AddDelivery(ctx context.Context, deliveryID string) (bool, error)
+	// RemoveDelivery forgets a delivery, so that it is processed if it's
+	// delivered again.
+	RemoveDelivery(ctx context.Context, deliveryID string) error
+}
+
+// expiringSet is a concurrency-safe set of keys whose entries expire after a
+// fixed TTL. If maxSize is positive, the oldest entries are evicted to keep
+// the set within that size.
+type expiringSet struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	maxSize int
+	entries map[string]time.Time
+	now     func() time.Time
+}
+
+func newExpiringSet(ttl time.Duration, maxSize int) *expiringSet {
+	return &expiringSet{
+		ttl:     ttl,
+		maxSize: maxSize,
+		entries: make(map[string]time.Time),
+		now:     time.Now,
+	}
+}
+
+// Add inserts key into the set. It returns false if the key is already
+// present and hasn't expired yet.
+func (s *expiringSet) Add(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	s.expireLocked(now)
+	if _, ok := s.entries[key]; ok {
+		return false
+	}
+	if s.maxSize > 0 && len(s.entries) >= s.maxSize {
+		s.evictOldestLocked()
+	}
+	s.entries[key] = now.Add(s.ttl)
+	return true
+}
+
+// Remove deletes key from the set.
+func (s *expiringSet) Remove(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+}
+
+func (s *expiringSet) expireLocked(now time.Time) {
+	for k, expiry := range s.entries {
+		if !now.Before(expiry) {
+			delete(s.entries, k)
+		}
+	}
+}
+
+func (s *expiringSet) evictOldestLocked() {
+	var oldest string
+	var oldestExpiry time.Time
+	for k, expiry := range s.entries {
+		if oldest == "" || expiry.Before(oldestExpiry) {
+			oldest, oldestExpiry = k, expiry
+		}
+	}
+	delete(s.entries, oldest)
+}
+
+// addDelivery records a webhook delivery and reports whether it is new. Only
+// deliveries that aren't remembered in memory are looked up in the store.
+func (app *GithubApp) addDelivery(ctx context.Context, deliveryID string) bool {
+	if !app.deliveries.Add(deliveryID) {
+		return false
+	}
+	ds, ok := app.store.(DeliveryStore)
+	if !ok {
+		return true
+	}
+	added, err := ds.AddDelivery(ctx, deliveryID)
+	if err != nil {
+		logFrom(ctx).Warnw("failed to store delivery", "error", err)
+		return true
+	}
+	return added
+}
+
+// removeDelivery forgets a webhook delivery.
+func (app *GithubApp) removeDelivery(ctx context.Context, deliveryID string) {
+	app.deliveries.Remove(deliveryID)
+	if ds, ok := app.store.(DeliveryStore); ok {
+		if err := ds.RemoveDelivery(ctx, deliveryID); err != nil {
+			logFrom(ctx).Warnw("failed to remove stored delivery", "error", err)
+		}
+	}
+}
+
+func inFlightKey(installationID int64, headSHA string, checkName string) string {
+	return fmt.Sprintf("%d/%s/%s", installationID, headSHA, checkName)
+}
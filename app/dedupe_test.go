@@ -0,0 +1,94 @@
+package app
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestExpiringSetExpiresEntries(t *testing.T) {
+	now := time.Unix(1000, 0)
+	s := newExpiringSet(time.Minute, 0)
+	s.now = func() time.Time { return now }
+
+	if !s.Add("a") || s.Add("a") {
+		t.Fatalf("Add of a new key should succeed once")
+	}
+	now = now.Add(time.Minute)
+	if !s.Add("a") {
+		t.Errorf("Add of an expired key failed")
+	}
+	s.Remove("a")
+	if !s.Add("a") {
+		t.Errorf("Add of a removed key failed")
+	}
+}
+
+func TestExpiringSetEvictsOldestEntries(t *testing.T) {
+	now := time.Unix(1000, 0)
+	s := newExpiringSet(time.Minute, 2)
+	s.now = func() time.Time { return now }
+	for _, key := range []string{"a", "b", "c"} {
+		s.Add(key)
+		now = now.Add(time.Second)
+	}
+	if len(s.entries) != 2 {
+		t.Errorf("set holds %d entries, want at most 2", len(s.entries))
+	}
+	if s.Add("c") || s.Add("b") {
+		t.Errorf("recent keys were evicted")
+	}
+	if !s.Add("a") {
+		t.Errorf("oldest key wasn't evicted")
+	}
+}
+
+// memDeliveryStore is a Store that also remembers deliveries in memory.
+type memDeliveryStore struct {
+	*memStore
+	mu         sync.Mutex
+	deliveries map[string]bool
+}
+
+func (s *memDeliveryStore) AddDelivery(_ context.Context, deliveryID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.deliveries[deliveryID] {
+		return false, nil
+	}
+	s.deliveries[deliveryID] = true
+	return true, nil
+}
+
+func (s *memDeliveryStore) RemoveDelivery(_ context.Context, deliveryID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.deliveries, deliveryID)
+	return nil
+}
+
+func TestAddDeliveryChecksStore(t *testing.T) {
+	store := &memDeliveryStore{memStore: newMemStore(), deliveries: make(map[string]bool)}
+	ctx := context.Background()
+	// Two replicas, or an app before and after a restart, share the store but
+	// not their memory.
+	first := &GithubApp{deliveries: newExpiringSet(deliveryTTL, maxDeliveries)}
+	first.SetStore(store)
+	second := &GithubApp{deliveries: newExpiringSet(deliveryTTL, maxDeliveries)}
+	second.SetStore(store)
+
+	if !first.addDelivery(ctx, "delivery-1") {
+		t.Fatalf("first delivery wasn't added")
+	}
+	if first.addDelivery(ctx, "delivery-1") {
+		t.Errorf("redelivery to the same app was added")
+	}
+	if second.addDelivery(ctx, "delivery-1") {
+		t.Errorf("redelivery to another app sharing the store was added")
+	}
+	first.removeDelivery(ctx, "delivery-1")
+	if !first.addDelivery(ctx, "delivery-1") {
+		t.Errorf("removed delivery wasn't added again")
+	}
+}
@@ -0,0 +1,184 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/v43/github"
+)
+
+// hunkHeaderRegex matches the header of a unified diff hunk.
+var hunkHeaderRegex = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// fileDiff is the part of a file that a pull request touches, by line number
+// in the new version of the file.
+type fileDiff struct {
+	// added are the lines added or modified by the pull request.
+	added map[int]bool
+	// inHunk are the lines shown in the pull request's diff, which review
+	// comments can be attached to.
+	inHunk map[int]bool
+	// renamedFrom is the previous path of a renamed file, or "".
+	renamedFrom string
+}
+
+// parsePatch parses the patch GitHub reports for a pull request file.
+func parsePatch(patch string) *fileDiff {
+	d := &fileDiff{
+		added:  make(map[int]bool),
+		inHunk: make(map[int]bool),
+	}
+	line := 0
+	for _, l := range strings.Split(patch, "\n") {
+		if m := hunkHeaderRegex.FindStringSubmatch(l); m != nil {
+			line, _ = strconv.Atoi(m[3])
+			continue
+		}
+		if line == 0 || strings.HasPrefix(l, "-") || strings.HasPrefix(l, `\`) {
+			continue
+		}
+		d.inHunk[line] = true
+		if strings.HasPrefix(l, "+") {
+			d.added[line] = true
+		}
+		line++
+	}
+	return d
+}
+
+// pullRequestDiff returns the diff of each file changed by a pull request,
+// keyed by path. Files without a patch, such as binaries, map to an empty
+// diff.
+func pullRequestDiff(ctx context.Context, ghc *github.Client, owner string, repo string, number int) (map[string]*fileDiff, error) {
+	diffs := make(map[string]*fileDiff)
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		files, res, err := ghc.PullRequests.ListFiles(ctx, owner, repo, number, opts)
+		if err := extractError(ctx, res, err); err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			d := parsePatch(f.GetPatch())
+			d.renamedFrom = f.GetPreviousFilename()
+			diffs[f.GetFilename()] = d
+		}
+		if res.NextPage == 0 {
+			break
+		}
+		opts.Page = res.NextPage
+	}
+	return diffs, nil
+}
+
+// diffPaths returns the paths that diff, a pull request's diff from
+// pullRequestDiff, adds, modifies or removes, like changedFiles, sorted.
+func diffPaths(diff map[string]*fileDiff) []string {
+	files := []string{}
+	for f, d := range diff {
+		for _, path := range []string{f, d.renamedFrom} {
+			if path, ok := cleanChangedPath(path); ok {
+				files = append(files, path)
+			}
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+// listChangedFiles returns the paths changed by pr, the pull request for
+// headSHA, or by the commit itself if pr is nil.
+func (app *GithubApp) listChangedFiles(ctx context.Context, installationID int64, repo *github.Repository, headSHA string, pr *github.PullRequest) ([]string, error) {
+	owner := repo.GetOwner().GetLogin()
+	repoName := repo.GetName()
+	ghc := app.GetClient(installationID)
+	var files []string
+	if pr != nil {
+		diff, err := pullRequestDiff(ctx, ghc, owner, repoName, pr.GetNumber())
+		if err != nil {
+			return nil, err
+		}
+		return diffPaths(diff), nil
+	}
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		commit, res, err := ghc.Repositories.GetCommit(ctx, owner, repoName, headSHA, opts)
+		if err := extractError(ctx, res, err); err != nil {
+			return nil, err
+		}
+		for _, f := range commit.Files {
+			if f, ok := cleanChangedPath(f.GetFilename()); ok {
+				files = append(files, f)
+			}
+		}
+		if res.NextPage == 0 {
+			break
+		}
+		opts.Page = res.NextPage
+	}
+	return files, nil
+}
+
+// filterToDiff drops the annotations of result on lines that the pull request
+// with diff didn't add or modify, and on files it didn't touch. If count is
+// set, the number of dropped annotations is added to the summary.
+func filterToDiff(result *Result, diff map[string]*fileDiff, count bool) {
+	if len(result.Annotations) == 0 {
+		return
+	}
+	kept := []*Annotation{}
+	for _, a := range result.Annotations {
+		// Issues with a file as a whole, and those of files without a patch
+		// like binaries, are kept as long as the file is in the diff.
+		if d, ok := diff[a.Path]; ok && (a.WholeFile || d.added[a.Line] || len(d.inHunk) == 0) {
+			kept = append(kept, a)
+		}
+	}
+	dropped := len(result.Annotations) - len(kept)
+	result.Annotations = kept
+	if count && dropped > 0 {
+		result.Summary += fmt.Sprintf("\n\n%d issues outside of the lines changed by this pull request aren't annotated.", dropped)
+	}
+}
+
+// hunk is a change in the output of `git diff -U0`.
+type hunk struct {
+	path string
+	// oldStart and oldLines are the replaced lines of the old file. For pure
+	// insertions, oldLines is 0 and the lines are inserted after oldStart.
+	oldStart int
+	oldLines int
+	newLines []string
+}
+
+// parseHunks parses the output of `git diff -U0`.
+func parseHunks(diff string) []*hunk {
+	var hunks []*hunk
+	var path string
+	var h *hunk
+	for _, l := range strings.Split(diff, "\n") {
+		switch {
+		case strings.HasPrefix(l, "+++ "):
+			path = strings.TrimPrefix(strings.TrimPrefix(l, "+++ "), "b/")
+			h = nil
+		case strings.HasPrefix(l, "@@"):
+			m := hunkHeaderRegex.FindStringSubmatch(l)
+			if m == nil {
+				h = nil
+				continue
+			}
+			h = &hunk{path: path, oldLines: 1}
+			h.oldStart, _ = strconv.Atoi(m[1])
+			if m[2] != "" {
+				h.oldLines, _ = strconv.Atoi(m[2])
+			}
+			hunks = append(hunks, h)
+		case h != nil && strings.HasPrefix(l, "+"):
+			h.newLines = append(h.newLines, strings.TrimPrefix(l, "+"))
+		}
+	}
+	return hunks
+}
@@ -0,0 +1,158 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/google/go-github/v43/github"
+)
+
+func TestParsePatch(t *testing.T) {
+	patch := "@@ -1,4 +1,5 @@\n package main\n-import \"fmt\"\n+import (\n+\t\"fmt\"\n+)\n func main() {\n@@ -20,2 +21,2 @@ func main() {\n \tx := 1\n-\ty := 2\n+\ty := 3\n\\ No newline at end of file"
+	d := parsePatch(patch)
+	wantAdded := map[int]bool{2: true, 3: true, 4: true, 22: true}
+	if !reflect.DeepEqual(d.added, wantAdded) {
+		t.Errorf("got added lines %v, want %v", d.added, wantAdded)
+	}
+	wantInHunk := map[int]bool{1: true, 2: true, 3: true, 4: true, 5: true, 21: true, 22: true}
+	if !reflect.DeepEqual(d.inHunk, wantInHunk) {
+		t.Errorf("got lines in hunks %v, want %v", d.inHunk, wantInHunk)
+	}
+}
+
+func TestParsePatchWithoutPatch(t *testing.T) {
+	d := parsePatch("")
+	if len(d.added) != 0 || len(d.inHunk) != 0 {
+		t.Errorf("got %+v for a file without a patch, want an empty diff", d)
+	}
+}
+
+func TestParseHunks(t *testing.T) {
+	diff := `diff --git a/main.go b/main.go
+index 1111111..2222222 100644
+--- a/main.go
++++ b/main.go
+@@ -3 +3 @@ package main
+-import "fmt"
++import "os"
+@@ -10,2 +10,0 @@ func main() {
+-	a()
+-	b()
+diff --git a/lib/BUILD b/lib/BUILD
+--- a/lib/BUILD
++++ b/lib/BUILD
+@@ -4,0 +5,2 @@
++    deps = [],
++    visibility = ["//visibility:public"],
+`
+	got := parseHunks(diff)
+	want := []*hunk{
+		{path: "main.go", oldStart: 3, oldLines: 1, newLines: []string{`import "os"`}},
+		{path: "main.go", oldStart: 10, oldLines: 2},
+		{path: "lib/BUILD", oldStart: 4, oldLines: 0, newLines: []string{"    deps = [],", `    visibility = ["//visibility:public"],`}},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d hunks, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if !reflect.DeepEqual(got[i], want[i]) {
+			t.Errorf("hunk %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestFilterToDiff(t *testing.T) {
+	diff := map[string]*fileDiff{
+		"main.go":  parsePatch("@@ -1,2 +1,3 @@\n package main\n+import \"os\"\n func main() {}"),
+		"logo.png": parsePatch(""),
+	}
+	for _, tc := range []struct {
+		desc        string
+		count       bool
+		wantSummary string
+	}{
+		{desc: "without count", wantSummary: "2 issues"},
+		{desc: "with count", count: true, wantSummary: "2 issues\n\n2 issues outside of the lines changed by this pull request aren't annotated."},
+	} {
+		result := &Result{
+			Summary: "2 issues",
+			Annotations: []*Annotation{
+				{Path: "main.go", Line: 1, Message: "context line"},
+				{Path: "main.go", Line: 2, Message: "added line"},
+				{Path: "other.go", Line: 2, Message: "unchanged file"},
+				{Path: "logo.png", Line: 1, Message: "file without a patch"},
+			},
+		}
+		filterToDiff(result, diff, tc.count)
+		if len(result.Annotations) != 2 || result.Annotations[0].Message != "added line" || result.Annotations[1].Message != "file without a patch" {
+			t.Errorf("%s: kept annotations %+v, want the ones on the added line and the file without a patch", tc.desc, result.Annotations)
+		}
+		if result.Summary != tc.wantSummary {
+			t.Errorf("%s: got summary %q, want %q", tc.desc, result.Summary, tc.wantSummary)
+		}
+	}
+}
+
+func TestFilterToDiffKeepsWholeFileAnnotations(t *testing.T) {
+	diff := map[string]*fileDiff{"main.go": parsePatch("@@ -10,1 +10,2 @@\n x := 1\n+y := 2")}
+	result := &Result{Annotations: []*Annotation{
+		{Path: "main.go", Line: 1, Message: "needs reformat", WholeFile: true},
+		{Path: "main.go", Line: 1, Message: "unchanged line"},
+		{Path: "other.go", Line: 1, Message: "needs reformat", WholeFile: true},
+	}}
+	filterToDiff(result, diff, false)
+	if len(result.Annotations) != 1 || result.Annotations[0].Path != "main.go" || !result.Annotations[0].WholeFile {
+		t.Errorf("kept annotations %+v, want only the whole-file one of the changed file", result.Annotations)
+	}
+}
+
+func TestPullRequestDiff(t *testing.T) {
+	f := newFakeGitHub(t)
+	f.handle("GET /repos/o/r/pulls/5/files", func(w http.ResponseWriter, req *http.Request) {
+		writeTestJSON(w, http.StatusOK, []*github.CommitFile{
+			{Filename: github.String("a.txt"), Patch: github.String("@@ -0,0 +1 @@\n+a")},
+			{Filename: github.String("logo.png")},
+		})
+	})
+	app := newTestApp(t, f)
+
+	diffs, err := pullRequestDiff(context.Background(), app.GetClient(testInstallationID), "o", "r", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 2 || !diffs["a.txt"].added[1] || len(diffs["logo.png"].added) != 0 {
+		t.Errorf("got diffs %+v, want a.txt with line 1 added and logo.png without lines", diffs)
+	}
+}
+
+func TestListChangedFilesOfCommit(t *testing.T) {
+	f := newFakeGitHub(t)
+	f.handle("GET /repos/o/r/commits/abc", func(w http.ResponseWriter, req *http.Request) {
+		writeTestJSON(w, http.StatusOK, map[string]interface{}{
+			"sha":   "abc",
+			"files": []map[string]interface{}{{"filename": "app/main.go"}, {"filename": "./lib/BUILD"}, {"filename": "../outside"}},
+		})
+	})
+	app := newTestApp(t, f)
+
+	files, err := app.listChangedFiles(context.Background(), testInstallationID, testRepo(), "abc", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"app/main.go", "lib/BUILD"}; !reflect.DeepEqual(files, want) {
+		t.Errorf("got changed files %q, want %q without paths outside the repository", files, want)
+	}
+}
+
+func TestDiffPaths(t *testing.T) {
+	diff := map[string]*fileDiff{
+		"b/new.go":     {renamedFrom: "a/old.go"},
+		"a/main.go":    {},
+		"../escape.go": {},
+	}
+	if got, want := diffPaths(diff), []string{"a/main.go", "a/old.go", "b/new.go"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got paths %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,167 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxEmailAnnotations is how many annotations of a check an email lists.
+const maxEmailAnnotations = 10
+
+// emailsSent holds when each author was last emailed.
+var emailsSent = struct {
+	mu   sync.Mutex
+	last map[string]time.Time
+}{
+	last: make(map[string]time.Time),
+}
+
+// allowEmail reports whether author may be emailed now under EmailInterval,
+// and if so records it.
+func allowEmail(author string) bool {
+	emailsSent.mu.Lock()
+	defer emailsSent.mu.Unlock()
+	author = strings.ToLower(author)
+	if last, ok := emailsSent.last[author]; ok && time.Since(last) < CurrentSettings().EmailInterval {
+		return false
+	}
+	emailsSent.last[author] = time.Now()
+	return true
+}
+
+// emailAuthor emails the author of the job's commit about the checks in
+// results that it broke, if the commit is the head of a protected branch.
+// Checks that also failed on the commit's parent were already broken, and
+// aren't mentioned.
+func (app *GithubApp) emailAuthor(ctx context.Context, job *Job, results map[string]*Result) error {
+	var failed []string
+	for _, check := range job.Checks {
+		if result, ok := results[check.Name]; ok && failedConclusion(result.Conclusion) {
+			failed = append(failed, check.Name)
+		}
+	}
+	if len(failed) == 0 || CurrentSettings().SMTPAddr == "" {
+		return nil
+	}
+	owner, repo := job.ownerAndRepo()
+	ghc := app.jobClient(job)
+	branches, res, err := ghc.Repositories.ListBranchesHeadCommit(ctx, owner, repo, job.HeadSHA)
+	if err := extractError(ctx, res, err); err != nil {
+		return err
+	}
+	branch := ""
+	for _, b := range branches {
+		if b.GetProtected() {
+			branch = b.GetName()
+			break
+		}
+	}
+	if branch == "" {
+		return nil
+	}
+	commit, res, err := ghc.Repositories.GetCommit(ctx, owner, repo, job.HeadSHA, nil)
+	if err := extractError(ctx, res, err); err != nil {
+		return err
+	}
+	if len(commit.Parents) > 0 {
+		parentRuns, err := listCheckRuns(ctx, ghc, job.AppID, owner, repo, commit.Parents[0].GetSHA())
+		if err != nil {
+			return err
+		}
+		broken := failed[:0]
+		for _, name := range failed {
+			if run, ok := parentRuns[name]; !ok || run.GetStatus() != "completed" || !failedCheck(run) {
+				broken = append(broken, name)
+			}
+		}
+		failed = broken
+	}
+	author := commit.GetCommit().GetAuthor().GetEmail()
+	if len(failed) == 0 || author == "" || strings.HasSuffix(author, "@users.noreply.github.com") {
+		return nil
+	}
+	if !allowEmail(author) {
+		logFrom(ctx).Infow("not emailing author again so soon", "author", author)
+		return nil
+	}
+
+	subject := fmt.Sprintf("[%s] %s broke %s on %s", job.FullRepoName, shortSHA(job.HeadSHA), strings.Join(failed, ", "), branch)
+	var body strings.Builder
+	subjectLine, _, _ := strings.Cut(commit.GetCommit().GetMessage(), "\n")
+	fmt.Fprintf(&body, "Your commit %s (%s) to %s of %s fails checks that passed before it:\n",
+		shortSHA(job.HeadSHA), subjectLine, branch, job.FullRepoName)
+	for _, name := range failed {
+		result := results[name]
+		fmt.Fprintf(&body, "\n%s: %s\n", name, result.Title)
+		if result.Summary != "" {
+			fmt.Fprintf(&body, "%s\n", truncateText(result.Summary, 2000))
+		}
+		for i, a := range result.Annotations {
+			if i == maxEmailAnnotations {
+				fmt.Fprintf(&body, "  ... and %d more\n", len(result.Annotations)-maxEmailAnnotations)
+				break
+			}
+			fmt.Fprintf(&body, "  %s:%d: %s\n", a.Path, a.Line, a.Message)
+		}
+		if result.URL != "" {
+			fmt.Fprintf(&body, "Details: %s\n", result.URL)
+		}
+		if run := jobCheckRun(job, name); run != 0 {
+			fmt.Fprintf(&body, "Re-run: %s\n", githubWebURL(job.FullRepoName, fmt.Sprintf("runs/%d", run)))
+		}
+	}
+	if err := sendEmail(ctx, author, subject, body.String()); err != nil {
+		return err
+	}
+	logFrom(ctx).Infow("emailed author of broken commit", "author", author, "checks", failed)
+	return nil
+}
+
+// jobCheckRun returns the ID of the check run of the job's check checkName,
+// or 0 if it has none.
+func jobCheckRun(job *Job, checkName string) int64 {
+	for _, check := range job.Checks {
+		if check.Name == checkName {
+			return check.CheckRunID
+		}
+	}
+	return 0
+}
+
+// shortSHA abbreviates sha for people.
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
+
+// sendEmail sends a plain text email to to through SMTPAddr.
+func sendEmail(ctx context.Context, to string, subject string, body string) error {
+	settings := CurrentSettings()
+	var auth smtp.Auth
+	if settings.SMTPUsername != "" {
+		password, err := ResolveSecret(ctx, settings.SMTPPassword)
+		if err != nil {
+			return err
+		}
+		host, _, err := net.SplitHostPort(settings.SMTPAddr)
+		if err != nil {
+			return fmt.Errorf("invalid SMTP address %q: %s", settings.SMTPAddr, err)
+		}
+		auth = smtp.PlainAuth("", settings.SMTPUsername, password, host)
+	}
+	// Keep headers from being injected through the subject.
+	subject = strings.NewReplacer("\r", " ", "\n", " ").Replace(subject)
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s",
+		settings.SMTPFrom, to, subject, time.Now().Format(time.RFC1123Z), strings.ReplaceAll(body, "\n", "\r\n"))
+	if err := smtp.SendMail(settings.SMTPAddr, auth, settings.SMTPFrom, []string{to}, []byte(msg)); err != nil {
+		return fmt.Errorf("failed to send email: %s", err)
+	}
+	return nil
+}
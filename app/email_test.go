@@ -0,0 +1,277 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSMTP is an SMTP server that accepts every email, without
+// authentication.
+type fakeSMTP struct {
+	mu       sync.Mutex
+	messages []string
+	rcpts    []string
+}
+
+// serveSMTP starts a fake SMTP server and points SMTPAddr and SMTPFrom at it.
+func serveSMTP(t *testing.T) *fakeSMTP {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	s := &fakeSMTP{}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.session(conn)
+		}
+	}()
+	setTestSettings(t, func(s *Settings) {
+		s.SMTPAddr, s.SMTPFrom, s.SMTPUsername = l.Addr().String(), "reviewbot@example.com", ""
+	})
+	return s
+}
+
+func (s *fakeSMTP) session(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+	reply("220 localhost")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			s.mu.Lock()
+			s.rcpts = append(s.rcpts, strings.Trim(strings.TrimSpace(line)[len("RCPT TO:"):], "<>"))
+			s.mu.Unlock()
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 go ahead")
+			var msg strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				msg.WriteString(line)
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, msg.String())
+			s.mu.Unlock()
+			reply("250 OK")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func (s *fakeSMTP) sent() ([]string, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.messages...), append([]string(nil), s.rcpts...)
+}
+
+// resetEmailsSent forgets the authors emailed by other tests, and by the
+// test once it finishes.
+func resetEmailsSent(t *testing.T) {
+	reset := func() {
+		emailsSent.mu.Lock()
+		emailsSent.last = make(map[string]time.Time)
+		emailsSent.mu.Unlock()
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+// serveBrokenCommit serves abc of o/r as the head of branch, authored by
+// author on top of parent, whose check runs are parentRuns.
+func serveBrokenCommit(f *fakeGitHub, branch map[string]interface{}, author string, parentRuns ...map[string]interface{}) {
+	f.handle("GET /repos/o/r/commits/abc/branches-where-head", func(w http.ResponseWriter, req *http.Request) {
+		writeTestJSON(w, http.StatusOK, []map[string]interface{}{branch})
+	})
+	f.handle("GET /repos/o/r/commits/abc", func(w http.ResponseWriter, req *http.Request) {
+		writeTestJSON(w, http.StatusOK, map[string]interface{}{
+			"sha":     "abc",
+			"parents": []map[string]interface{}{{"sha": "parent"}},
+			"commit": map[string]interface{}{
+				"message": "Change things\n\nDetails",
+				"author":  map[string]interface{}{"name": "Dev", "email": author},
+			},
+		})
+	})
+	f.handle("GET /repos/o/r/commits/parent/check-runs", func(w http.ResponseWriter, req *http.Request) {
+		writeTestJSON(w, http.StatusOK, map[string]interface{}{"total_count": len(parentRuns), "check_runs": parentRuns})
+	})
+}
+
+func emailJob() *Job {
+	return &Job{
+		InstallationID: testInstallationID,
+		AppID:          testAppID,
+		FullRepoName:   "o/r",
+		HeadSHA:        "abc",
+		Token:          "job-token",
+		Checks:         []*JobCheck{{Name: bazelTestCheck, CheckRunID: 11}, {Name: nogoCheck, CheckRunID: 12}, {Name: buildifierCheck, CheckRunID: 13}},
+	}
+}
+
+func TestEmailAuthorsEnabled(t *testing.T) {
+	setTestSettings(t, func(s *Settings) {
+		s.EmailAuthors, s.SMTPAddr, s.SMTPFrom = true, "localhost:25", "reviewbot@example.com"
+	})
+	off := false
+	if !(&RepoConfig{}).EmailAuthorsEnabled() || (&RepoConfig{EmailAuthors: &off}).EmailAuthorsEnabled() {
+		t.Errorf("got the app-wide setting overridden incorrectly")
+	}
+}
+
+func TestAllowEmail(t *testing.T) {
+	resetEmailsSent(t)
+	setTestSettings(t, func(s *Settings) { s.EmailInterval = time.Hour })
+
+	if !allowEmail("dev@example.com") {
+		t.Errorf("first email to an author isn't allowed")
+	}
+	if allowEmail("Dev@Example.com") {
+		t.Errorf("second email to an author within the interval is allowed")
+	}
+	if !allowEmail("other@example.com") {
+		t.Errorf("email to another author isn't allowed")
+	}
+	setTestSettings(t, func(s *Settings) { s.EmailInterval = 0 })
+	if !allowEmail("dev@example.com") {
+		t.Errorf("email after the interval isn't allowed")
+	}
+}
+
+func TestEmailAuthorReportsBrokenChecks(t *testing.T) {
+	resetEmailsSent(t)
+	s := serveSMTP(t)
+	f := newFakeGitHub(t)
+	serveBrokenCommit(f, map[string]interface{}{"name": "main", "protected": true}, "dev@example.com",
+		map[string]interface{}{"id": 1, "name": bazelTestCheck, "status": "completed", "conclusion": "success"},
+		// nogo was broken before the commit.
+		map[string]interface{}{"id": 2, "name": nogoCheck, "status": "completed", "conclusion": "failure"},
+	)
+	app := newTestApp(t, f)
+	results := map[string]*Result{
+		bazelTestCheck:  {Conclusion: "failure", Title: "Test result", Summary: "1 tests failed.", URL: "https://app.buildbuddy.io/invocation/1", Annotations: []*Annotation{{Path: "a/BUILD", Line: 1, Message: "//a:a_test FAILED"}}},
+		nogoCheck:       {Conclusion: "failure", Title: "Build failed"},
+		buildifierCheck: {Conclusion: "success"},
+	}
+
+	if err := app.emailAuthor(context.Background(), emailJob(), results); err != nil {
+		t.Fatal(err)
+	}
+	messages, rcpts := s.sent()
+	if len(messages) != 1 || len(rcpts) != 1 || rcpts[0] != "dev@example.com" {
+		t.Fatalf("sent %d emails to %q, want one to the author", len(messages), rcpts)
+	}
+	msg := messages[0]
+	for _, want := range []string{
+		"From: reviewbot@example.com\r\n",
+		"To: dev@example.com\r\n",
+		"Subject: [o/r] abc broke bazel-test on main\r\n",
+		"Your commit abc (Change things) to main of o/r fails checks that passed before it:\r\n",
+		"bazel-test: Test result\r\n1 tests failed.\r\n  a/BUILD:1: //a:a_test FAILED\r\nDetails: https://app.buildbuddy.io/invocation/1\r\n",
+		"Re-run: " + githubWebURL("o/r", "runs/11") + "\r\n",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("email %q doesn't contain %q", msg, want)
+		}
+	}
+	if strings.Contains(msg, "Build failed") {
+		t.Errorf("email %q mentions a check that was already broken", msg)
+	}
+}
+
+func TestEmailAuthorSkips(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		protected  bool
+		author     string
+		parentRuns []map[string]interface{}
+	}{
+		{"unprotected branch", false, "dev@example.com", nil},
+		{"no-reply author", true, "1+dev@users.noreply.github.com", nil},
+		{"already broken", true, "dev@example.com", []map[string]interface{}{{"id": 1, "name": bazelTestCheck, "status": "completed", "conclusion": "failure"}}},
+	} {
+		resetEmailsSent(t)
+		s := serveSMTP(t)
+		f := newFakeGitHub(t)
+		serveBrokenCommit(f, map[string]interface{}{"name": "main", "protected": tc.protected}, tc.author, tc.parentRuns...)
+		app := newTestApp(t, f)
+
+		if err := app.emailAuthor(context.Background(), emailJob(), map[string]*Result{bazelTestCheck: {Conclusion: "failure"}}); err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+		if messages, _ := s.sent(); len(messages) != 0 {
+			t.Errorf("%s: sent %d emails, want none", tc.name, len(messages))
+		}
+	}
+}
+
+func TestEmailAuthorIsRateLimited(t *testing.T) {
+	resetEmailsSent(t)
+	s := serveSMTP(t)
+	f := newFakeGitHub(t)
+	serveBrokenCommit(f, map[string]interface{}{"name": "main", "protected": true}, "dev@example.com")
+	app := newTestApp(t, f)
+	results := map[string]*Result{bazelTestCheck: {Conclusion: "failure"}}
+
+	for i := 0; i < 2; i++ {
+		if err := app.emailAuthor(context.Background(), emailJob(), results); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if messages, _ := s.sent(); len(messages) != 1 {
+		t.Errorf("sent %d emails, want one within the interval", len(messages))
+	}
+}
+
+func TestEmailAuthorWithoutFailures(t *testing.T) {
+	f := newFakeGitHub(t)
+	app := newTestApp(t, f)
+	if err := app.emailAuthor(context.Background(), emailJob(), map[string]*Result{bazelTestCheck: {Conclusion: "success"}}); err != nil {
+		t.Fatal(err)
+	}
+	if n := f.count("GET /repos/o/r/commits/abc/branches-where-head"); n != 0 {
+		t.Errorf("listed branches %d times without failures", n)
+	}
+}
+
+func TestSendEmailKeepsSubjectOnOneLine(t *testing.T) {
+	s := serveSMTP(t)
+	if err := sendEmail(context.Background(), "dev@example.com", "broken\r\nBcc: other@example.com", "body\n"); err != nil {
+		t.Fatal(err)
+	}
+	messages, _ := s.sent()
+	if len(messages) != 1 {
+		t.Fatalf("sent %d emails, want 1", len(messages))
+	}
+	if !strings.Contains(messages[0], "Subject: broken  Bcc: other@example.com\r\n") || strings.Contains(messages[0], "\r\nBcc:") {
+		t.Errorf("email %q has a header injected through its subject", messages[0])
+	}
+}
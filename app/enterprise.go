@@ -0,0 +1,85 @@
+package app
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/google/go-github/v43/github"
+)
+
+var (
+	// githubBaseURL and githubUploadURL are the API endpoints of a GitHub
+	// Enterprise Server instance, or nil for github.com.
+	githubBaseURL   *url.URL
+	githubUploadURL *url.URL
+)
+
+// SetGitHubURLs points the app at a GitHub Enterprise Server instance whose
+// API is at baseURL, e.g. https://github.example.com/api/v3/, and whose
+// upload API is at uploadURL. An empty uploadURL is derived from baseURL. An
+// empty baseURL uses github.com.
+func SetGitHubURLs(baseURL string, uploadURL string) error {
+	if baseURL == "" {
+		githubBaseURL, githubUploadURL = nil, nil
+		return nil
+	}
+	if uploadURL == "" {
+		// GHES serves uploads from /api/uploads/ on the same host.
+		u, err := url.Parse(baseURL)
+		if err != nil {
+			return fmt.Errorf("invalid GitHub base URL: %s", err)
+		}
+		uploadURL = (&url.URL{Scheme: u.Scheme, Host: u.Host}).String()
+	}
+	c, err := github.NewEnterpriseClient(baseURL, uploadURL, nil)
+	if err != nil {
+		return fmt.Errorf("invalid GitHub URL: %s", err)
+	}
+	if c.BaseURL.Host == "" || (c.BaseURL.Scheme != "https" && c.BaseURL.Scheme != "http") {
+		return fmt.Errorf("invalid GitHub base URL %q: want an http(s) URL", baseURL)
+	}
+	githubBaseURL, githubUploadURL = c.BaseURL, c.UploadURL
+	return nil
+}
+
+// setClientURLs makes c talk to the configured GitHub instance.
+func setClientURLs(c *github.Client) *github.Client {
+	if githubBaseURL != nil {
+		c.BaseURL = githubBaseURL
+		c.UploadURL = githubUploadURL
+	}
+	return c
+}
+
+// githubAPIURL returns the scheme and host, and path prefix on GHES, of the
+// GitHub API, as ghinstallation expects it.
+func githubAPIURL() string {
+	if githubBaseURL == nil {
+		return "https://api.github.com"
+	}
+	return strings.TrimSuffix(githubBaseURL.String(), "/")
+}
+
+// repoURL returns the clone URL of fullRepoName on the configured GitHub
+// instance. It has no credentials; clones and pushes authenticate with
+// tokenAuth.
+func repoURL(fullRepoName string) string {
+	u := &url.URL{Scheme: "https", Host: "github.com", Path: "/" + fullRepoName + ".git"}
+	if githubBaseURL != nil {
+		u.Scheme = githubBaseURL.Scheme
+		u.Host = githubBaseURL.Host
+	}
+	return u.String()
+}
+
+// githubWebURL returns the URL of the page at path, e.g. "pull/1", of
+// fullRepoName on the configured GitHub instance.
+func githubWebURL(fullRepoName string, path string) string {
+	u := &url.URL{Scheme: "https", Host: "github.com", Path: "/" + fullRepoName + "/" + path}
+	if githubBaseURL != nil {
+		u.Scheme = githubBaseURL.Scheme
+		u.Host = githubBaseURL.Host
+	}
+	return u.String()
+}
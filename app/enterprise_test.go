@@ -0,0 +1,66 @@
+package app
+
+import (
+	"testing"
+)
+
+func TestSetGitHubURLs(t *testing.T) {
+	t.Cleanup(func() { SetGitHubURLs("", "") })
+
+	if err := SetGitHubURLs("https://github.example.com/api/v3", ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := githubBaseURL.String(); got != "https://github.example.com/api/v3/" {
+		t.Errorf("got base URL %s, want a trailing slash to be added", got)
+	}
+	if got := githubUploadURL.String(); got != "https://github.example.com/api/uploads/" {
+		t.Errorf("got upload URL %s, want the one of the base URL's host", got)
+	}
+	if got := githubAPIURL(); got != "https://github.example.com/api/v3" {
+		t.Errorf("got API URL %s, want the base URL without trailing slash", got)
+	}
+	if got, want := repoURL("o/r"), "https://github.example.com/o/r.git"; got != want {
+		t.Errorf("got clone URL %s, want %s", got, want)
+	}
+
+	if err := SetGitHubURLs("https://github.example.com/api/v3/", "https://uploads.example.com/"); err != nil {
+		t.Fatal(err)
+	}
+	if got := githubUploadURL.String(); got != "https://uploads.example.com/api/uploads/" {
+		t.Errorf("got upload URL %s, want the configured one", got)
+	}
+
+	if err := SetGitHubURLs("", ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := githubAPIURL(); got != "https://api.github.com" {
+		t.Errorf("got API URL %s, want github.com's", got)
+	}
+	if got, want := repoURL("o/r"), "https://github.com/o/r.git"; got != want {
+		t.Errorf("got clone URL %s, want %s", got, want)
+	}
+}
+
+func TestSetGitHubURLsWithInvalidURL(t *testing.T) {
+	t.Cleanup(func() { SetGitHubURLs("", "") })
+
+	for _, baseURL := range []string{"github.example.com/api/v3/", "ftp://github.example.com/", "://"} {
+		if err := SetGitHubURLs(baseURL, ""); err == nil {
+			t.Errorf("SetGitHubURLs(%q) succeeded, want an error", baseURL)
+		}
+	}
+}
+
+func TestGitHubWebURL(t *testing.T) {
+	t.Cleanup(func() { SetGitHubURLs("", "") })
+
+	if got, want := githubWebURL("o/r", "pull/1"), "https://github.com/o/r/pull/1"; got != want {
+		t.Errorf("got web URL %s, want %s", got, want)
+	}
+	if err := SetGitHubURLs("http://github.example.com/api/v3", ""); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := githubWebURL("o/r", "commit/abc"), "http://github.example.com/o/r/commit/abc"; got != want {
+		t.Errorf("got web URL %s, want %s on the GitHub Enterprise host", got, want)
+	}
+}
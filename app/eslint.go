@@ -0,0 +1,104 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+)
+
+const eslintCheck = "eslint"
+
+// eslintReport is the output of `eslint --format=json`, one entry per linted
+// file.
+type eslintReport []struct {
+	FilePath string `json:"filePath"`
+	Messages []struct {
+		RuleID   string `json:"ruleId"`
+		Severity int    `json:"severity"`
+		Message  string `json:"message"`
+		Line     int    `json:"line"`
+		Column   int    `json:"column"`
+	} `json:"messages"`
+}
+
+func init() {
+	RegisterChecker(&funcChecker{
+		name:         eslintCheck,
+		fn:           checkESLint,
+		optIn:        true,
+		requirements: CheckRequirements{Binaries: []string{"eslint"}},
+		rule:         CheckRule{Description: "JavaScript and TypeScript files pass eslint.", HelpURI: "https://eslint.org/docs/latest/rules/"},
+	})
+}
+
+// checkESLint runs ESLint with the repository's configuration on the
+// checkout.
+func checkESLint(ctx context.Context, _ *GithubApp, target *CheckTarget) (*Result, error) {
+	tool := target.Config.ToolOr("eslint")
+	args := append([]string{"--format=json"}, target.Config.Flags...)
+	// ESLint exits with 1 when it finds errors and 2 when it fails.
+	stdOut, stdErr, err := runCheckCmd(ctx, target, tool, append(args, ".")...)
+	if code := exitCode(err); (code != 0 && code != 1) || stdOut.Len() == 0 {
+		if err != nil {
+			return toolFailure(tool, err.Error(), stdErr.String()), nil
+		}
+		return toolFailure(tool, "no output", stdErr.String()), nil
+	}
+	report := eslintReport{}
+	if err := json.Unmarshal(stdOut.Bytes(), &report); err != nil {
+		return toolFailure(tool, fmt.Sprintf("unparsable output: %s", err), stdErr.String()), nil
+	}
+
+	annotations := []*Annotation{}
+	for _, file := range report {
+		// File paths are absolute, see checkRuff.
+		path, err := filepath.Rel(target.Dir, file.FilePath)
+		if err != nil {
+			path = file.FilePath
+		}
+		path = filepath.ToSlash(path)
+		for _, m := range file.Messages {
+			severity := "warning"
+			if m.Severity == 2 {
+				severity = "failure"
+			}
+			message := m.Message
+			if m.RuleID != "" {
+				message = fmt.Sprintf("%s (%s)", m.Message, m.RuleID)
+			}
+			line := m.Line
+			if line == 0 {
+				line = 1
+			}
+			annotations = append(annotations, &Annotation{
+				Message:  cleanLine(message),
+				Severity: severity,
+				Path:     path,
+				Line:     line,
+				Column:   m.Column,
+			})
+		}
+	}
+	annotations = target.Config.filterAnnotations(annotations)
+
+	res := &Result{
+		Title: fmt.Sprintf("%s result", tool),
+	}
+	if len(annotations) == 0 {
+		res.Summary = "No issues found."
+		res.Conclusion = "success"
+		return res, nil
+	}
+	res.Summary = fmt.Sprintf("%d issues found", len(annotations))
+	res.Annotations = annotations
+	// Warnings alone don't fail the check.
+	res.Conclusion = "neutral"
+	for _, a := range annotations {
+		if a.Severity == "failure" {
+			res.Conclusion = "failure"
+			break
+		}
+	}
+	return res, nil
+}
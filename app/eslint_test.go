@@ -0,0 +1,94 @@
+package app
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestCheckESLint(t *testing.T) {
+	tool := writeFakeTool(t, `cat <<REPORT
+[
+  {"filePath": "$PWD/src/app.js", "messages": [
+    {"ruleId": "no-unused-vars", "severity": 2, "message": "'x' is defined but never used.", "line": 3, "column": 7},
+    {"ruleId": "semi", "severity": 1, "message": "Missing semicolon.", "line": 4, "column": 12}
+  ]},
+  {"filePath": "$PWD/src/broken.js", "messages": [
+    {"ruleId": null, "severity": 2, "message": "Parsing error: Unexpected token"}
+  ]},
+  {"filePath": "$PWD/src/clean.js", "messages": []}
+]
+REPORT
+exit 1
+`)
+	target := &CheckTarget{Dir: t.TempDir(), Config: &CheckConfig{Tool: tool}}
+	res, err := checkESLint(context.Background(), nil, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Conclusion != "failure" || res.Summary != "3 issues found" {
+		t.Errorf("got %s with summary %q, want a failure with 3 issues", res.Conclusion, res.Summary)
+	}
+	want := []Annotation{
+		{Path: "src/app.js", Line: 3, Column: 7, Message: "'x' is defined but never used. (no-unused-vars)", Severity: "failure"},
+		{Path: "src/app.js", Line: 4, Column: 12, Message: "Missing semicolon. (semi)", Severity: "warning"},
+		{Path: "src/broken.js", Line: 1, Message: "Parsing error: Unexpected token", Severity: "failure"},
+	}
+	if len(res.Annotations) != len(want) {
+		t.Fatalf("got %d annotations, want %d", len(res.Annotations), len(want))
+	}
+	for i, a := range res.Annotations {
+		if *a != want[i] {
+			t.Errorf("annotation %d: got %+v, want %+v", i, *a, want[i])
+		}
+	}
+}
+
+func TestCheckESLintWithOnlyWarnings(t *testing.T) {
+	tool := writeFakeTool(t, `echo '[{"filePath": "'"$PWD"'/a.js", "messages": [{"ruleId": "semi", "severity": 1, "message": "Missing semicolon.", "line": 1, "column": 9}]}]'`)
+	res, err := checkESLint(context.Background(), nil, &CheckTarget{Dir: t.TempDir(), Config: &CheckConfig{Tool: tool}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Conclusion != "neutral" {
+		t.Errorf("got conclusion %q, want warnings alone to be neutral", res.Conclusion)
+	}
+}
+
+// runWithFakeTool runs the check fn on an empty checkout with its tool
+// replaced by a shell script.
+func runWithFakeTool(t *testing.T, fn checkFn, script string) (*Result, error) {
+	target := &CheckTarget{
+		Dir:    t.TempDir(),
+		Config: &CheckConfig{Tool: writeFakeTool(t, script)},
+		log:    &commandLog{},
+	}
+	return fn(context.Background(), nil, target)
+}
+
+// testToolFailures asserts that fn reports the failure of its tool in each of
+// the scripts as a failed check whose summary contains the matching string.
+func testToolFailures(t *testing.T, fn checkFn, scripts map[string]string) {
+	for script, want := range scripts {
+		res, err := runWithFakeTool(t, fn, script)
+		if err != nil {
+			t.Errorf("%q: got error %s, want a failed check", script, err)
+			continue
+		}
+		if res.Conclusion != "failure" || !strings.Contains(res.Summary, want) {
+			t.Errorf("%q: got %s with summary %q, want failure mentioning %q", script, res.Conclusion, res.Summary, want)
+		}
+	}
+}
+
+func TestESLintReportsToolFailures(t *testing.T) {
+	testToolFailures(t, checkESLint, map[string]string{
+		"echo 'Oops! Something went wrong!' >&2; exit 2": "Oops! Something went wrong!",
+		// ESLint exits with 2 for configuration errors, even if it printed a
+		// report.
+		"echo '[]'; exit 2": "exit status 2",
+		"exit 1":            "exit status 1",
+		"exit 0":            "no output",
+		"echo '['; exit 1":  "unparsable output",
+	})
+}
@@ -0,0 +1,52 @@
+package app
+
+import (
+	"bytes"
+	"context"
+)
+
+// Executor runs the tools of checks.
+type Executor interface {
+	// Run runs toolName with arg in dir, the checkout of the check's target.
+	Run(ctx context.Context, dir string, toolName string, arg ...string) (bytes.Buffer, bytes.Buffer, error)
+}
+
+// CheckExecutor is the Executor that runs the tools of all checks.
+var CheckExecutor Executor = LocalExecutor{}
+
+// LocalExecutor runs check tools directly on the host, within the limits of
+// the check, see CgroupRoot.
+type LocalExecutor struct{}
+
+func (LocalExecutor) Run(ctx context.Context, dir string, toolName string, arg ...string) (bytes.Buffer, bytes.Buffer, error) {
+	if l := limiterFrom(ctx); l != nil {
+		var err error
+		toolName, arg, err = l.localCommand(toolName, arg)
+		if err != nil {
+			return bytes.Buffer{}, bytes.Buffer{}, err
+		}
+	}
+	return runCmdInDir(ctx, dir, toolName, arg...)
+}
+
+// runCheckCmd runs a check's tool in the checkout of target with
+// CheckExecutor, and adds the command and its output to the target's log as
+// it runs. bb runs the bazel of bazelTool, with the target's output base.
+func runCheckCmd(ctx context.Context, target *CheckTarget, toolName string, arg ...string) (bytes.Buffer, bytes.Buffer, error) {
+	if toolName == "bb" {
+		var err error
+		if toolName, err = bazelTool(ctx); err != nil {
+			return bytes.Buffer{}, bytes.Buffer{}, err
+		}
+	}
+	if target.outputBase != "" && isBazelTool(toolName) {
+		arg = append([]string{"--output_base=" + target.outputBase}, arg...)
+	}
+	if target.log == nil {
+		return CheckExecutor.Run(ctx, target.Dir, toolName, arg...)
+	}
+	target.log.start(toolName, arg)
+	stdOut, stdErr, err := CheckExecutor.Run(withOutput(ctx, target.log), target.Dir, toolName, arg...)
+	target.log.finish(err)
+	return stdOut, stdErr, err
+}
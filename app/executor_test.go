@@ -0,0 +1,141 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useFakeContainerRuntime runs check tools in containers of a fake runtime
+// for the duration of the test. The runtime appends its arguments to the
+// returned file, one invocation per line, and runs script for `run`.
+func useFakeContainerRuntime(t *testing.T, script string) string {
+	log := filepath.Join(t.TempDir(), "runtime.log")
+	runtime := writeFakeTool(t, `echo "$@" >> `+log+`
+if [ "$1" = run ]; then
+`+script+`
+fi
+`)
+	old := CheckExecutor
+	CheckExecutor = &ContainerExecutor{Runtime: runtime, Image: "reviewbot-tools", CPUs: "2", Memory: "4g"}
+	t.Cleanup(func() { CheckExecutor = old })
+	return log
+}
+
+func readRuntimeLog(t *testing.T, log string) []string {
+	b, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(b)), "\n")
+}
+
+func TestRunCheckCmdInContainer(t *testing.T) {
+	log := useFakeContainerRuntime(t, "echo checked")
+	dir := t.TempDir()
+
+	stdOut, _, err := runCheckCmd(context.Background(), &CheckTarget{Dir: dir}, "buildifier", "--mode=check", "BUILD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdOut.String() != "checked\n" {
+		t.Errorf("got output %q, want the container's", stdOut.String())
+	}
+	calls := readRuntimeLog(t, log)
+	if len(calls) != 1 {
+		t.Fatalf("runtime was called %d times, want once", len(calls))
+	}
+	for _, want := range []string{
+		"run --rm --init --name reviewbot-",
+		"--network none",
+		"--volume " + dir + ":" + dir + " --workdir " + dir,
+		"--cpus 2 --memory 4g reviewbot-tools buildifier --mode=check BUILD",
+	} {
+		if !strings.Contains(calls[0], want) {
+			t.Errorf("runtime was called with %q, want %q in it", calls[0], want)
+		}
+	}
+}
+
+func TestRunCheckCmdRemovesContainerOfCancelledCheck(t *testing.T) {
+	log := useFakeContainerRuntime(t, "exec sleep 60")
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if _, _, err := runCheckCmd(ctx, &CheckTarget{Dir: t.TempDir()}, "bb", "build", "//..."); err == nil {
+		t.Errorf("cancelled command succeeded")
+	}
+	calls := readRuntimeLog(t, log)
+	if len(calls) != 2 || !strings.HasPrefix(calls[1], "rm --force reviewbot-") {
+		t.Fatalf("runtime was called with %q, want the container removed", calls)
+	}
+	name := strings.Fields(calls[1])[2]
+	if !strings.Contains(calls[0], "--name "+name) {
+		t.Errorf("removed container %s, want the one that was run by %q", name, calls[0])
+	}
+}
+
+func TestRunCheckCmdOnHost(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "BUILD", "")
+	stdOut, _, err := runCheckCmd(context.Background(), &CheckTarget{Dir: dir}, "ls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdOut.String() != "BUILD\n" {
+		t.Errorf("got output %q, want the checkout listed", stdOut.String())
+	}
+}
+
+func TestValidateConfigWithContainerExecutor(t *testing.T) {
+	useFakeContainerRuntime(t, "")
+	// The tools of the checks needn't be on the host's PATH.
+	t.Setenv("PATH", "")
+	if err := validateConfig(testPrivateKey(t), []string{testWebhookSecret}, StaticSecretProvider("bb-key")); err != nil {
+		t.Errorf("validateConfig with containers: %s", err)
+	}
+
+	CheckExecutor = &ContainerExecutor{Runtime: "no-such-runtime"}
+	err := validateConfig(testPrivateKey(t), []string{testWebhookSecret}, StaticSecretProvider("bb-key"))
+	if err == nil {
+		t.Fatal("validateConfig without a usable container runtime succeeded")
+	}
+	for _, problem := range []string{`container runtime "no-such-runtime" is not on PATH`, "container image is empty"} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("validateConfig error %q doesn't report %q", err, problem)
+		}
+	}
+}
+
+func TestRunCheckCmdLogsCommandWithoutCredentials(t *testing.T) {
+	target := &CheckTarget{Dir: t.TempDir(), log: &commandLog{}}
+	if _, _, err := runCheckCmd(context.Background(), target, "true", "build", "--remote_header=x-buildbuddy-api-key=secret-key"); err != nil {
+		t.Fatal(err)
+	}
+	runCheckCmd(context.Background(), target, "false")
+	log := target.log.String()
+	if strings.Contains(log, "secret-key") {
+		t.Errorf("log %q contains the API key", log)
+	}
+	for _, want := range []string{"$ true build --remote_header=x-buildbuddy-api-key=<redacted>\n", "$ false\n(exit status 1)\n"} {
+		if !strings.Contains(log, want) {
+			t.Errorf("got log %q, want %q in it", log, want)
+		}
+	}
+}
+
+func TestRunCmdInDirReportsExitStatusWithStderr(t *testing.T) {
+	_, stdErr, err := runCmdInDir(context.Background(), t.TempDir(), "sh", "-c", "echo failed >&2; exit 3")
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+		t.Errorf("got error %v, want exit status 3", err)
+	}
+	if stdErr.String() != "failed\n" {
+		t.Errorf("got stderr %q, want it kept", stdErr.String())
+	}
+}
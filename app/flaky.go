@@ -0,0 +1,103 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-github/v43/github"
+)
+
+// flakyIssueLabel labels the issue that tests found flaky are filed in.
+const flakyIssueLabel = "flaky-tests"
+
+// retriedStatus reports whether a test with status is retried: it ran and
+// failed, rather than failing to build.
+func retriedStatus(status string) bool {
+	return status == "FAILED" || status == "TIMEOUT"
+}
+
+// retryFailedTests reruns the tests of out that failed up to the check's
+// configured number of retries, and marks those that pass on a retry as
+// FLAKY. It returns the invocation URLs of the retries.
+func retryFailedTests(ctx context.Context, apiKey string, target *CheckTarget, out *bazelOutput) []string {
+	var urls []string
+	for attempt := 1; attempt <= target.Config.Retries; attempt++ {
+		failing := make(map[string]*testResult)
+		var targets []string
+		for _, t := range out.testResults {
+			if retriedStatus(t.status) {
+				failing[t.target] = t
+				targets = append(targets, t.target)
+			}
+		}
+		if len(targets) == 0 {
+			break
+		}
+		logFrom(ctx).Infow("retrying failed tests", "attempt", attempt, "tests", len(targets))
+		stdOut, _, err := runBazel(ctx, apiKey, target, "test", target.bazelFlags("test"), targets)
+		if stdOut.Len() == 0 {
+			logFrom(ctx).Warnw("failed to retry tests", "error", err)
+			break
+		}
+		retry := parseBazelOutput(ctx, &stdOut)
+		urls = append(urls, retry.urls...)
+		for _, t := range retry.testResults {
+			if prev, ok := failing[t.target]; ok && t.status == "PASSED" {
+				prev.status = "FLAKY"
+			}
+		}
+	}
+	return urls
+}
+
+// retriesSummary lists the invocation URLs of retries for the check summary.
+func retriesSummary(urls []string) string {
+	if len(urls) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("\n\nRetries:")
+	for _, url := range urls {
+		b.WriteString("\n- ")
+		b.WriteString(url)
+	}
+	return b.String()
+}
+
+// fileFlakyTests adds tests found flaky on target's commit to the open issue
+// labeled flakyIssueLabel, or opens one if there is none.
+func (app *GithubApp) fileFlakyTests(ctx context.Context, target *CheckTarget, tests []string) error {
+	if len(tests) == 0 || target.ghc == nil {
+		return nil
+	}
+	owner, repo, _ := strings.Cut(target.FullRepoName, "/")
+	ghc := target.ghc
+	// Keep concurrent checks of the repository from opening an issue each.
+	unlock := lockSummary(target.FullRepoName + "#" + flakyIssueLabel)
+	defer unlock()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Tests that failed and then passed on a retry on %s:\n", target.HeadSHA)
+	for _, t := range tests {
+		fmt.Fprintf(&b, "- `%s`\n", t)
+	}
+	issue, err := openLabeledIssue(ctx, ghc, owner, repo, flakyIssueLabel)
+	if err != nil {
+		return err
+	}
+	if issue != nil {
+		_, res, err := ghc.Issues.CreateComment(ctx, owner, repo, issue.GetNumber(), &github.IssueComment{Body: github.String(b.String())})
+		return extractError(ctx, res, err)
+	}
+	issue, res, err := ghc.Issues.Create(ctx, owner, repo, &github.IssueRequest{
+		Title:  github.String("Flaky tests"),
+		Body:   github.String(b.String()),
+		Labels: &[]string{flakyIssueLabel},
+	})
+	if err := extractError(ctx, res, err); err != nil {
+		return err
+	}
+	logFrom(ctx).Infow("opened flaky tests issue", "issue", issue.GetNumber())
+	return nil
+}
@@ -0,0 +1,227 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v43/github"
+)
+
+// installFakeFlakyBazel installs a bb whose first test run fails
+// //a:flaky_test and //b:broken_test, and whose retries pass //a:flaky_test
+// only. It returns the file that the targets of each run are recorded to.
+func installFakeFlakyBazel(t *testing.T) string {
+	dir := t.TempDir()
+	log := filepath.Join(dir, "runs")
+	installFakeTool(t, "bb", `
+targets=$(echo "$@" | sed 's/.* -- //')
+echo "$targets" >> `+log+`
+n=$(wc -l < `+log+`)
+echo "Streaming build results to: https://app.buildbuddy.io/invocation/run$n"
+case "$targets" in
+*//c:ok_test*)
+	echo "//a:flaky_test FAILED in 1.0s"
+	echo "//b:broken_test FAILED in 1.0s"
+	echo "//c:ok_test PASSED in 1.0s"
+	exit 3
+	;;
+*)
+	for target in $targets; do
+		case "$target" in
+		//a:flaky_test) echo "//a:flaky_test PASSED in 1.0s" ;;
+		*) echo "$target FAILED in 1.0s" ;;
+		esac
+	done
+	exit 3
+	;;
+esac
+`)
+	return log
+}
+
+func readRuns(t *testing.T, log string) []string {
+	b, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(b)), "\n")
+}
+
+func TestCheckBazelTestRetriesFailedTests(t *testing.T) {
+	runs := installFakeFlakyBazel(t)
+	app := newTestApp(t, newFakeGitHub(t))
+	target := &CheckTarget{Dir: t.TempDir(), Config: &CheckConfig{Targets: []string{"//a:flaky_test", "//b:broken_test", "//c:ok_test"}, Retries: 2}}
+
+	res, err := checkBazelTest(context.Background(), app, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"//a:flaky_test //b:broken_test //c:ok_test", "//a:flaky_test //b:broken_test", "//b:broken_test"}
+	if got := readRuns(t, runs); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("ran tests %q, want %q", got, want)
+	}
+	if res.Conclusion != "failure" {
+		t.Errorf("got conclusion %s, want failure for the consistently failing test", res.Conclusion)
+	}
+	if !strings.HasPrefix(res.Summary, "1 tests failed, 1 passed. 1 tests were flaky.") {
+		t.Errorf("got summary %q, want the flaky test counted", res.Summary)
+	}
+	if !strings.HasSuffix(res.Summary, "Retries:\n- https://app.buildbuddy.io/invocation/run2\n- https://app.buildbuddy.io/invocation/run3") {
+		t.Errorf("got summary %q, want the retries listed", res.Summary)
+	}
+	if res.URL != "https://app.buildbuddy.io/invocation/run1" {
+		t.Errorf("got URL %s, want the first run's", res.URL)
+	}
+}
+
+func TestCheckBazelTestPassesWithFlakyTests(t *testing.T) {
+	runs := filepath.Join(t.TempDir(), "runs")
+	installFakeTool(t, "bb", `
+targets=$(echo "$@" | sed 's/.* -- //')
+echo "$targets" >> `+runs+`
+case "$targets" in
+*//c:ok_test*) echo "//a:flaky_test FAILED in 1.0s"; echo "//c:ok_test PASSED in 1.0s"; exit 3 ;;
+*) echo "//a:flaky_test PASSED in 1.0s" ;;
+esac
+`)
+	app := newTestApp(t, newFakeGitHub(t))
+	target := &CheckTarget{Dir: t.TempDir(), Config: &CheckConfig{Targets: []string{"//a:flaky_test", "//c:ok_test"}, Retries: 3}}
+
+	res, err := checkBazelTest(context.Background(), app, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readRuns(t, runs); len(got) != 2 {
+		t.Errorf("ran tests %q, want one retry once the flaky test passed", got)
+	}
+	if res.Conclusion != "success" || !strings.HasPrefix(res.Summary, "1 tests passed. 1 tests were flaky.") {
+		t.Errorf("got %s: %q, want the flaky test not to fail the check", res.Conclusion, res.Summary)
+	}
+}
+
+func TestCheckBazelTestWithoutRetries(t *testing.T) {
+	runs := installFakeFlakyBazel(t)
+	app := newTestApp(t, newFakeGitHub(t))
+	target := &CheckTarget{Dir: t.TempDir(), Config: &CheckConfig{Targets: []string{"//a:flaky_test", "//b:broken_test", "//c:ok_test"}}}
+
+	res, err := checkBazelTest(context.Background(), app, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readRuns(t, runs); len(got) != 1 {
+		t.Errorf("ran tests %q, want no retries", got)
+	}
+	if res.Conclusion != "failure" || strings.Contains(res.Summary, "Retries") {
+		t.Errorf("got %s: %q, want the failures reported without retries", res.Conclusion, res.Summary)
+	}
+}
+
+func TestRetriedStatus(t *testing.T) {
+	for status, want := range map[string]bool{"FAILED": true, "TIMEOUT": true, "FAILED TO BUILD": false, "PASSED": false, "INCOMPLETE": false} {
+		if got := retriedStatus(status); got != want {
+			t.Errorf("retriedStatus(%q) = %v, want %v", status, got, want)
+		}
+	}
+}
+
+// serveFlakyIssues serves the open issues labeled flaky-tests of o/r and
+// records the issues and comments that are created, which must be listed with
+// the token of flakyTarget's job.
+func serveFlakyIssues(t *testing.T, f *fakeGitHub, open []map[string]interface{}) (issues *[]*github.IssueRequest, comments *[]*github.IssueComment) {
+	issues, comments = &[]*github.IssueRequest{}, &[]*github.IssueComment{}
+	f.handle("GET /repos/o/r/issues", func(w http.ResponseWriter, req *http.Request) {
+		if got := req.URL.Query().Get("labels"); got != flakyIssueLabel {
+			t.Errorf("listed issues labeled %q, want %q", got, flakyIssueLabel)
+		}
+		if got := req.Header.Get("Authorization"); got != "token job-token" {
+			t.Errorf("listed issues with authorization %q, want the job's token", got)
+		}
+		writeTestJSON(w, http.StatusOK, open)
+	})
+	f.handle("POST /repos/o/r/issues", func(w http.ResponseWriter, req *http.Request) {
+		issue := &github.IssueRequest{}
+		if err := json.NewDecoder(req.Body).Decode(issue); err != nil {
+			t.Errorf("failed to decode issue: %s", err)
+		}
+		*issues = append(*issues, issue)
+		writeTestJSON(w, http.StatusCreated, map[string]interface{}{"number": 12})
+	})
+	f.handle("POST /repos/o/r/issues/7/comments", func(w http.ResponseWriter, req *http.Request) {
+		comment := &github.IssueComment{}
+		if err := json.NewDecoder(req.Body).Decode(comment); err != nil {
+			t.Errorf("failed to decode comment: %s", err)
+		}
+		*comments = append(*comments, comment)
+		writeTestJSON(w, http.StatusCreated, comment)
+	})
+	return issues, comments
+}
+
+func flakyTarget(app *GithubApp) *CheckTarget {
+	job := &Job{InstallationID: testInstallationID, FullRepoName: "o/r", HeadSHA: "abc", Token: "job-token"}
+	return &CheckTarget{InstallationID: testInstallationID, FullRepoName: "o/r", HeadSHA: "abc", ghc: app.jobClient(job)}
+}
+
+func TestFileFlakyTestsOpensIssue(t *testing.T) {
+	f := newFakeGitHub(t)
+	issues, comments := serveFlakyIssues(t, f, []map[string]interface{}{})
+	app := newTestApp(t, f)
+	target := flakyTarget(app)
+
+	if err := app.fileFlakyTests(context.Background(), target, []string{"//a:flaky_test"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(*issues) != 1 || len(*comments) != 0 {
+		t.Fatalf("created %d issues and %d comments, want an issue", len(*issues), len(*comments))
+	}
+	issue := (*issues)[0]
+	if issue.GetTitle() != "Flaky tests" || issue.Labels == nil || len(*issue.Labels) != 1 || (*issue.Labels)[0] != flakyIssueLabel {
+		t.Errorf("got issue %+v, want one labeled %s", issue, flakyIssueLabel)
+	}
+	if want := "Tests that failed and then passed on a retry on abc:\n- `//a:flaky_test`\n"; issue.GetBody() != want {
+		t.Errorf("got body %q, want %q", issue.GetBody(), want)
+	}
+}
+
+func TestFileFlakyTestsCommentsOnOpenIssue(t *testing.T) {
+	f := newFakeGitHub(t)
+	issues, comments := serveFlakyIssues(t, f, []map[string]interface{}{{"number": 7}})
+	app := newTestApp(t, f)
+	target := flakyTarget(app)
+
+	if err := app.fileFlakyTests(context.Background(), target, []string{"//a:flaky_test", "//b:flaky_test"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(*issues) != 0 || len(*comments) != 1 {
+		t.Fatalf("created %d issues and %d comments, want a comment", len(*issues), len(*comments))
+	}
+	if body := (*comments)[0].GetBody(); !strings.Contains(body, "- `//a:flaky_test`\n- `//b:flaky_test`\n") {
+		t.Errorf("got comment %q, want the flaky tests listed", body)
+	}
+
+	// Nothing is filed without flaky tests.
+	if err := app.fileFlakyTests(context.Background(), target, nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := f.count("GET /repos/o/r/issues"); n != 1 {
+		t.Errorf("listed issues %d times, want once", n)
+	}
+}
+
+func TestFileFlakyTestsWithoutGitHubClient(t *testing.T) {
+	f := newFakeGitHub(t)
+	app := newTestApp(t, f)
+	target := &CheckTarget{InstallationID: testInstallationID, FullRepoName: "o/r", HeadSHA: "abc"}
+
+	if err := app.fileFlakyTests(context.Background(), target, []string{"//a:flaky_test"}); err != nil {
+		t.Fatal(err)
+	}
+	if n := f.count("GET /repos/o/r/issues"); n != 0 {
+		t.Errorf("listed issues %d times without a client of the job, want none", n)
+	}
+}
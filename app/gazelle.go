@@ -0,0 +1,87 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+const gazelleCheck = "gazelle"
+
+func init() {
+	RegisterChecker(&funcChecker{
+		name:         gazelleCheck,
+		fn:           checkGazelle,
+		fixFn:        fixGazelle,
+		fixMessage:   "Update BUILD files with gazelle",
+		optIn:        true,
+		requirements: CheckRequirements{Binaries: []string{"gazelle", "git"}},
+		rule:         CheckRule{Description: "BUILD files are up to date with the sources, as generated by gazelle.", HelpURI: "https://github.com/bazelbuild/bazel-gazelle"},
+	})
+}
+
+// diffBlock renders a diff as a markdown code block that fits in a check
+// run's output text.
+func diffBlock(diff string) string {
+	const fence = "```diff\n%s\n```"
+	return fmt.Sprintf(fence, truncateText(strings.TrimRight(diff, "\n"), maxCheckRunText-len(fence)))
+}
+
+// checkGazelle reports BUILD files that gazelle would change, with the
+// changes in the output text.
+func checkGazelle(ctx context.Context, _ *GithubApp, target *CheckTarget) (*Result, error) {
+	tool := target.Config.ToolOr("gazelle")
+	args := append([]string{"-mode=diff"}, target.Config.Flags...)
+	// gazelle exits with 1 when BUILD files would change, but also when it
+	// fails, in which case there's no diff.
+	stdOut, stdErr, err := runCheckCmd(ctx, target, tool, args...)
+	if code := exitCode(err); code != 0 && code != 1 {
+		return toolFailure(tool, err.Error(), stdErr.String()), nil
+	}
+
+	diff := stdOut.String()
+	annotations := []*Annotation{}
+	files := make(map[string]bool)
+	for _, h := range parseHunks(diff) {
+		line := h.oldStart
+		if line == 0 {
+			line = 1
+		}
+		files[h.path] = true
+		annotations = append(annotations, &Annotation{
+			Message:  fmt.Sprintf("BUILD file is out of date, run %s", tool),
+			Severity: "failure",
+			Path:     h.path,
+			Line:     line,
+		})
+	}
+	if err != nil && len(files) == 0 {
+		return toolFailure(tool, fmt.Sprintf("%s without a diff", err), stdErr.String()), nil
+	}
+	annotations = target.Config.filterAnnotations(annotations)
+
+	res := &Result{
+		Title: fmt.Sprintf("%s result", tool),
+	}
+	if len(annotations) == 0 {
+		res.Summary = "BUILD files are up to date."
+		res.Conclusion = "success"
+		return res, nil
+	}
+	res.Summary = fmt.Sprintf("%d BUILD files are out of date", len(files))
+	res.Conclusion = "failure"
+	res.Annotations = annotations
+	res.Text = diffBlock(diff)
+	res.Action = newFixAction(gazelleCheck, fmt.Sprintf("Regenerate BUILD files with %s.", tool))
+	return res, nil
+}
+
+// fixGazelle regenerates the BUILD files of the checkout.
+func fixGazelle(ctx context.Context, _ *GithubApp, target *CheckTarget) error {
+	args := append([]string{"fix"}, target.Config.Flags...)
+	_, stdErr, err := runCheckCmd(ctx, target, target.Config.ToolOr("gazelle"), args...)
+	if err != nil {
+		return fmt.Errorf("failed to run gazelle: %s: %s", err, stdErr.String())
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package app
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testGazelleDiff = `--- a/app/BUILD
++++ b/app/BUILD
+@@ -5,1 +5,2 @@
+     srcs = ["app.go"],
++    deps = ["//lib"],
+--- /dev/null
++++ b/lib/BUILD
+@@ -0,0 +1,4 @@
++go_library(
++    name = "lib",
++    srcs = ["lib.go"],
++)
+`
+
+func TestCheckGazelle(t *testing.T) {
+	tool := writeFakeTool(t, "cat <<'DIFF'\n"+testGazelleDiff+"DIFF\nexit 1\n")
+	res, err := checkGazelle(context.Background(), nil, &CheckTarget{Dir: t.TempDir(), Config: &CheckConfig{Tool: tool}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Conclusion != "failure" || res.Summary != "2 BUILD files are out of date" || res.Action == nil {
+		t.Errorf("got %s with summary %q, want a fixable failure for 2 files", res.Conclusion, res.Summary)
+	}
+	want := []Annotation{
+		{Path: "app/BUILD", Line: 5, Message: "BUILD file is out of date, run " + tool, Severity: "failure"},
+		{Path: "lib/BUILD", Line: 1, Message: "BUILD file is out of date, run " + tool, Severity: "failure"},
+	}
+	if len(res.Annotations) != len(want) {
+		t.Fatalf("got annotations %v, want %d", res.Annotations, len(want))
+	}
+	for i, a := range res.Annotations {
+		if *a != want[i] {
+			t.Errorf("annotation %d: got %+v, want %+v", i, *a, want[i])
+		}
+	}
+	if !strings.HasPrefix(res.Text, "```diff\n--- a/app/BUILD") || !strings.HasSuffix(res.Text, "+)\n```") {
+		t.Errorf("got text %q, want the diff in a code block", res.Text)
+	}
+}
+
+func TestCheckGazelleWithPaths(t *testing.T) {
+	tool := writeFakeTool(t, "cat <<'DIFF'\n"+testGazelleDiff+"DIFF\nexit 1\n")
+	res, err := checkGazelle(context.Background(), nil, &CheckTarget{Dir: t.TempDir(), Config: &CheckConfig{Tool: tool, Paths: []string{"app/**"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Annotations) != 1 || res.Annotations[0].Path != "app/BUILD" {
+		t.Errorf("got annotations %v, want only app/BUILD", res.Annotations)
+	}
+}
+
+func TestCheckGazelleUpToDate(t *testing.T) {
+	tool := writeFakeTool(t, "exit 0\n")
+	res, err := checkGazelle(context.Background(), nil, &CheckTarget{Dir: t.TempDir(), Config: &CheckConfig{Tool: tool}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Conclusion != "success" || res.Action != nil {
+		t.Errorf("got %s with action %+v, want success", res.Conclusion, res.Action)
+	}
+}
+
+func TestCheckGazelleWithoutDiff(t *testing.T) {
+	testToolFailures(t, checkGazelle, map[string]string{
+		"exit 1": "without a diff",
+		"echo 'gazelle: no such flag -bogus'; echo 'permission denied' >&2; exit 1": "permission denied",
+		"cat <<'DIFF'\n" + testGazelleDiff + "DIFF\nexit 2\n":                       "exit status 2",
+	})
+}
+
+func TestExitCode(t *testing.T) {
+	_, _, err := runCmdInDir(context.Background(), t.TempDir(), "sh", "-c", "exit 3")
+	if got := exitCode(err); got != 3 {
+		t.Errorf("exitCode of exit 3 = %d", got)
+	}
+	if got := exitCode(nil); got != 0 {
+		t.Errorf("exitCode of success = %d", got)
+	}
+	_, _, err = runCmdInDir(context.Background(), t.TempDir(), "no-such-tool")
+	if got := exitCode(err); got != -1 {
+		t.Errorf("exitCode of a command that couldn't run = %d, want -1", got)
+	}
+}
+
+func TestFixGazelle(t *testing.T) {
+	args := filepath.Join(t.TempDir(), "args")
+	tool := writeFakeTool(t, `echo "$@" > `+args+"\n")
+	target := &CheckTarget{Dir: t.TempDir(), Config: &CheckConfig{Tool: tool, Flags: []string{"-go_prefix=example.com/m"}}}
+	if err := fixGazelle(context.Background(), nil, target); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(b)); got != "fix -go_prefix=example.com/m" {
+		t.Errorf("ran gazelle with %q, want fix and the configured flags", got)
+	}
+}
+
+func TestDiffBlock(t *testing.T) {
+	if got := diffBlock("+a\n-b\n"); got != "```diff\n+a\n-b\n```" {
+		t.Errorf("got %q, want the diff in a code block", got)
+	}
+	if got := diffBlock(strings.Repeat("+a\n", maxCheckRunText)); len(got) > maxCheckRunText || !strings.HasSuffix(got, "... (truncated)\n```") {
+		t.Errorf("got %d bytes ending in %q, want the diff truncated to fit", len(got), got[len(got)-30:])
+	}
+}
+
+func TestGazelleFailureCompletesCheckRun(t *testing.T) {
+	tool := writeFakeTool(t, "echo 'gazelle: unknown flag -mode' >&2; exit 2")
+	opts := runTestJobCheck(t, gazelleCheck, &CheckConfig{Tool: tool}, t.TempDir())
+	if opts.GetConclusion() != "failure" {
+		t.Errorf("check run completed as %q, want failure", opts.GetConclusion())
+	}
+	if summary := opts.GetOutput().GetSummary(); !strings.Contains(summary, "gazelle: unknown flag -mode") {
+		t.Errorf("check run summary %q doesn't include gazelle's error", summary)
+	}
+
+	testToolFailures(t, checkGazelle, map[string]string{
+		"echo 'gazelle: no WORKSPACE' >&2; exit 1": "without a diff",
+	})
+}
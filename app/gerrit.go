@@ -0,0 +1,347 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+// gerritLabel is the label the bot votes on.
+const gerritLabel = "Verified"
+
+// gerritXSSIPrefix precedes every JSON response of the Gerrit REST API.
+const gerritXSSIPrefix = ")]}'"
+
+// Gerrit is a Provider for projects on a Gerrit server. Checks run on every
+// new patchset, which is found by polling or sent by the webhooks plugin in
+// the format of stream-events. The results are posted as one review that
+// votes on the Verified label and holds the annotations of failed checks as
+// robot comments.
+type Gerrit struct {
+	// URL is the Gerrit server, e.g. "https://gerrit.example.com".
+	URL string
+	// Username and Password are the HTTP credentials of the bot's account,
+	// which needs to be allowed to vote on the Verified label.
+	Username string
+	Password string
+	// WebhookSecret authenticates webhook requests, since the webhooks
+	// plugin doesn't sign events. It is sent in the Authorization header,
+	// either as a bearer token or as the password of basic auth, e.g. from
+	// the user info of the webhook URL. It isn't accepted in the URL's query,
+	// which ends up in access logs.
+	WebhookSecret string
+	// Query selects the changes to check when polling, e.g. "status:open
+	// -is:wip".
+	Query string
+
+	mu sync.Mutex
+	// accountID is the bot's account, or 0 if it isn't known yet.
+	accountID int64
+	// polled holds the revisions returned by the last poll, so that they
+	// aren't checked again until the bot has voted on them.
+	polled map[string]bool
+}
+
+func (g *Gerrit) Name() string { return "gerrit" }
+
+// gerritEvent holds the fields of patchset-created stream events that the bot
+// uses.
+type gerritEvent struct {
+	Type   string `json:"type"`
+	Change struct {
+		Project string `json:"project"`
+		Number  int    `json:"number"`
+		WIP     bool   `json:"wip"`
+	} `json:"change"`
+	PatchSet struct {
+		Revision string   `json:"revision"`
+		Ref      string   `json:"ref"`
+		Parents  []string `json:"parents"`
+	} `json:"patchSet"`
+}
+
+func (g *Gerrit) ParseWebhook(ctx context.Context, req *http.Request) (*CheckRequest, error) {
+	if subtle.ConstantTimeCompare([]byte(webhookAuthorization(req)), []byte(g.WebhookSecret)) != 1 {
+		return nil, &httpError{statusCode: http.StatusUnauthorized, message: "invalid webhook secret"}
+	}
+	payload, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	event := &gerritEvent{}
+	if err := json.Unmarshal(payload, event); err != nil {
+		return nil, &httpError{statusCode: http.StatusBadRequest, message: fmt.Sprintf("invalid webhook payload: %s", err)}
+	}
+	ctx = withLogFields(ctx, "repo", event.Change.Project, "change", event.Change.Number)
+	logFrom(ctx).Infow("got webhook", "event", event.Type)
+	if event.Type != "patchset-created" || event.Change.WIP {
+		return nil, nil
+	}
+	creq := &CheckRequest{
+		Repo:         event.Change.Project,
+		SHA:          event.PatchSet.Revision,
+		Ref:          event.PatchSet.Ref,
+		MergeRequest: event.Change.Number,
+	}
+	if len(event.PatchSet.Parents) > 0 {
+		creq.BaseSHA = event.PatchSet.Parents[0]
+	}
+	return creq, nil
+}
+
+// webhookAuthorization returns the bearer token or basic auth password of
+// req, or "" if it has neither.
+func webhookAuthorization(req *http.Request) string {
+	if _, password, ok := req.BasicAuth(); ok {
+		return password
+	}
+	const prefix = "Bearer "
+	if auth := req.Header.Get("Authorization"); len(auth) > len(prefix) && strings.EqualFold(auth[:len(prefix)], prefix) {
+		return auth[len(prefix):]
+	}
+	return ""
+}
+
+// gerritChange holds the fields of changes returned by the changes API that
+// the bot uses.
+type gerritChange struct {
+	Project         string `json:"project"`
+	Number          int    `json:"_number"`
+	CurrentRevision string `json:"current_revision"`
+	Revisions       map[string]struct {
+		Ref    string `json:"ref"`
+		Commit struct {
+			Parents []struct {
+				Commit string `json:"commit"`
+			} `json:"parents"`
+		} `json:"commit"`
+	} `json:"revisions"`
+	Labels map[string]struct {
+		All []struct {
+			AccountID int64 `json:"_account_id"`
+			Value     int   `json:"value"`
+		} `json:"all"`
+	} `json:"labels"`
+}
+
+// voted reports whether account voted on gerritLabel of the change's current
+// patchset.
+func (c *gerritChange) voted(account int64) bool {
+	for _, vote := range c.Labels[gerritLabel].All {
+		if vote.AccountID == account && vote.Value != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Poll returns the requests for the current patchsets of the changes matching
+// Query that the bot hasn't voted on yet.
+func (g *Gerrit) Poll(ctx context.Context) ([]*CheckRequest, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.accountID == 0 {
+		var account struct {
+			AccountID int64 `json:"_account_id"`
+		}
+		if err := g.api(ctx, http.MethodGet, "accounts/self", nil, &account); err != nil {
+			return nil, fmt.Errorf("failed to get own account: %s", err)
+		}
+		g.accountID = account.AccountID
+	}
+	q := url.Values{"q": {g.Query}, "o": {"CURRENT_REVISION", "CURRENT_COMMIT", "DETAILED_LABELS"}}
+	var changes []*gerritChange
+	if err := g.api(ctx, http.MethodGet, "changes/?"+q.Encode(), nil, &changes); err != nil {
+		return nil, err
+	}
+	var creqs []*CheckRequest
+	polled := make(map[string]bool)
+	for _, c := range changes {
+		if c.voted(g.accountID) {
+			continue
+		}
+		polled[c.CurrentRevision] = true
+		if g.polled[c.CurrentRevision] {
+			continue
+		}
+		rev := c.Revisions[c.CurrentRevision]
+		creq := &CheckRequest{
+			Repo:         c.Project,
+			SHA:          c.CurrentRevision,
+			Ref:          rev.Ref,
+			MergeRequest: c.Number,
+		}
+		if len(rev.Commit.Parents) > 0 {
+			creq.BaseSHA = rev.Commit.Parents[0].Commit
+		}
+		creqs = append(creqs, creq)
+	}
+	g.polled = polled
+	return creqs, nil
+}
+
+// repoURL returns the clone URL of repo, without credentials.
+func (g *Gerrit) repoURL(repo string) (string, error) {
+	u, err := url.Parse(g.URL)
+	if err != nil {
+		return "", fmt.Errorf("invalid Gerrit URL %q: %s", g.URL, err)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/a/" + repo
+	return u.String(), nil
+}
+
+// CloneURL authenticates clones as the bot.
+func (g *Gerrit) CloneURL(ctx context.Context, repo string) (string, transport.AuthMethod, error) {
+	url, err := g.repoURL(repo)
+	return url, &githttp.BasicAuth{Username: g.Username, Password: g.Password}, err
+}
+
+// MergeRequestRefSpec fetches every patchset, which is only needed when a
+// request doesn't name the ref of its patchset.
+func (g *Gerrit) MergeRequestRefSpec() config.RefSpec {
+	return config.RefSpec("+refs/changes/*:refs/remotes/origin/changes/*")
+}
+
+// ReportStatus does nothing, since Gerrit has no statuses for single checks;
+// ReportResults votes once all checks completed.
+func (g *Gerrit) ReportStatus(ctx context.Context, creq *CheckRequest, checkName string, result *Result) error {
+	return nil
+}
+
+type gerritRobotComment struct {
+	RobotID    string `json:"robot_id"`
+	RobotRunID string `json:"robot_run_id"`
+	Line       int    `json:"line,omitempty"`
+	Message    string `json:"message"`
+	URL        string `json:"url,omitempty"`
+}
+
+type gerritReview struct {
+	Message       string                           `json:"message,omitempty"`
+	Tag           string                           `json:"tag"`
+	Labels        map[string]int                   `json:"labels,omitempty"`
+	RobotComments map[string][]*gerritRobotComment `json:"robot_comments,omitempty"`
+}
+
+// ReportResults votes -1 on the Verified label if a check failed and +1
+// otherwise, with the annotations of the failed checks on the files of the
+// patchset as robot comments.
+func (g *Gerrit) ReportResults(ctx context.Context, creq *CheckRequest, results map[string]*Result) error {
+	if len(results) == 0 {
+		return nil
+	}
+	// Comments on files outside the patchset are rejected with the whole
+	// review.
+	var files map[string]json.RawMessage
+	if err := g.api(ctx, http.MethodGet, g.revisionPath(creq, "files"), nil, &files); err != nil {
+		return err
+	}
+	review := &gerritReview{
+		Message: providerSummary(creq.SHA, results),
+		Tag:     "autogenerated:reviewbot",
+		Labels:  map[string]int{gerritLabel: 1},
+	}
+	if review.Message == "" {
+		review.Message = fmt.Sprintf("All %d checks passed.", len(results))
+	}
+	var names []string
+	for name := range results {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		result := results[name]
+		if !failedConclusion(result.Conclusion) {
+			continue
+		}
+		review.Labels[gerritLabel] = -1
+		posted := 0
+		for _, a := range result.Annotations {
+			if _, ok := files[a.Path]; !ok {
+				continue
+			}
+			if review.RobotComments == nil {
+				review.RobotComments = make(map[string][]*gerritRobotComment)
+			}
+			review.RobotComments[a.Path] = append(review.RobotComments[a.Path], &gerritRobotComment{
+				RobotID:    "reviewbot/" + name,
+				RobotRunID: creq.SHA,
+				Line:       a.Line,
+				Message:    a.Message,
+				URL:        result.URL,
+			})
+			posted++
+			if posted == maxInlineComments {
+				break
+			}
+		}
+	}
+	return g.api(ctx, http.MethodPost, g.revisionPath(creq, "review"), review, nil)
+}
+
+func (g *Gerrit) Comment(ctx context.Context, creq *CheckRequest, body string) error {
+	review := &gerritReview{Message: body, Tag: "autogenerated:reviewbot"}
+	return g.api(ctx, http.MethodPost, g.revisionPath(creq, "review"), review, nil)
+}
+
+// PushBranch isn't supported, since fixes would have to be uploaded as new
+// patchsets.
+func (g *Gerrit) PushBranch(ctx context.Context, r *git.Repository, repo string, branch string) error {
+	return errors.New("pushing fixes to Gerrit isn't supported")
+}
+
+// revisionPath returns the API path of a resource of the request's patchset.
+func (g *Gerrit) revisionPath(creq *CheckRequest, resource string) string {
+	return fmt.Sprintf("changes/%s~%d/revisions/%s/%s", url.PathEscape(creq.Repo), creq.MergeRequest, creq.SHA, resource)
+}
+
+// api sends a request with a JSON body, if any, to path of the authenticated
+// Gerrit REST API and decodes the response into out, if set.
+func (g *Gerrit) api(ctx context.Context, method string, path string, body interface{}, out interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(g.URL, "/")+"/a/"+path, reqBody)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(g.Username, g.Password)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("Gerrit API %s %s returned %s: %s", method, path, res.Status, truncateOutput(strings.TrimSpace(string(b)), CurrentSettings().MaxOutputLines))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(bytes.TrimPrefix(b, []byte(gerritXSSIPrefix)), out)
+}
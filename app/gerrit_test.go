@@ -0,0 +1,282 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+// newFakeGerrit returns a Gerrit provider talking to a fake server, whose
+// routes are like "GET /a/accounts/self".
+func newFakeGerrit(t *testing.T) (*Gerrit, *fakeGitHub) {
+	f := newFakeAPI(t)
+	return &Gerrit{URL: f.URL, Username: "bot", Password: "secret", WebhookSecret: "gerrit-secret", Query: "status:open"}, f
+}
+
+// writeGerritJSON writes v as a Gerrit REST API response, which starts with
+// the XSSI prefix.
+func writeGerritJSON(t *testing.T, w http.ResponseWriter, req *http.Request, v interface{}) {
+	if user, password, ok := req.BasicAuth(); !ok || user != "bot" || password != "secret" {
+		t.Errorf("%s %s wasn't authenticated as the bot", req.Method, req.URL.Path)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte(gerritXSSIPrefix + "\n"))
+	w.Write(b)
+}
+
+func gerritEventRequest(t *testing.T, secret string, event interface{}) *http.Request {
+	body, err := json.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/gerrit/event_handler", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+secret)
+	return req
+}
+
+func gerritPatchsetEvent(eventType string, wip bool) map[string]interface{} {
+	return map[string]interface{}{
+		"type":     eventType,
+		"change":   map[string]interface{}{"project": "g/p", "number": 5, "wip": wip},
+		"patchSet": map[string]interface{}{"revision": "abc", "ref": "refs/changes/05/5/2", "parents": []string{"def"}},
+	}
+}
+
+func TestGerritParseWebhook(t *testing.T) {
+	g, _ := newFakeGerrit(t)
+	ctx := context.Background()
+
+	_, err := g.ParseWebhook(ctx, gerritEventRequest(t, "wrong", gerritPatchsetEvent("patchset-created", false)))
+	if e, ok := err.(*httpError); !ok || e.statusCode != http.StatusUnauthorized {
+		t.Errorf("got error %v for a wrong secret, want unauthorized", err)
+	}
+
+	creq, err := g.ParseWebhook(ctx, gerritEventRequest(t, "gerrit-secret", gerritPatchsetEvent("patchset-created", false)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := CheckRequest{Repo: "g/p", SHA: "abc", BaseSHA: "def", Ref: "refs/changes/05/5/2", MergeRequest: 5}
+	if creq == nil || creq.Repo != want.Repo || creq.SHA != want.SHA || creq.BaseSHA != want.BaseSHA || creq.Ref != want.Ref || creq.MergeRequest != want.MergeRequest {
+		t.Errorf("got request %+v, want %+v", creq, want)
+	}
+
+	for _, event := range []map[string]interface{}{
+		gerritPatchsetEvent("patchset-created", true),
+		gerritPatchsetEvent("change-merged", false),
+	} {
+		if creq, err := g.ParseWebhook(ctx, gerritEventRequest(t, "gerrit-secret", event)); err != nil || creq != nil {
+			t.Errorf("got request %+v, %v for %v, want none", creq, err, event)
+		}
+	}
+}
+
+func TestGerritParseWebhookAuthorization(t *testing.T) {
+	g, _ := newFakeGerrit(t)
+	event := gerritPatchsetEvent("patchset-created", false)
+
+	basic := gerritEventRequest(t, "", event)
+	basic.SetBasicAuth("gerrit", "gerrit-secret")
+	if creq, err := g.ParseWebhook(context.Background(), basic); err != nil || creq == nil {
+		t.Errorf("got request %+v, %v with the secret as basic auth password, want a request", creq, err)
+	}
+
+	// The query ends up in access logs, so the secret isn't accepted there.
+	query := gerritEventRequest(t, "", event)
+	query.Header.Del("Authorization")
+	query.URL.RawQuery = "secret=gerrit-secret"
+	if _, err := g.ParseWebhook(context.Background(), query); err == nil {
+		t.Errorf("accepted the secret in the query")
+	}
+}
+
+func TestWebhookAuthorization(t *testing.T) {
+	for auth, want := range map[string]string{
+		"Bearer s3cret": "s3cret",
+		"bearer s3cret": "s3cret",
+		"Basic " + base64.StdEncoding.EncodeToString([]byte("user:s3cret")): "s3cret",
+		"Bearer ":      "",
+		"Token s3cret": "",
+		"":             "",
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		if got := webhookAuthorization(req); got != want {
+			t.Errorf("webhookAuthorization of %q = %q, want %q", auth, got, want)
+		}
+	}
+}
+
+func gerritTestChange(number int, revision string, votes ...int64) map[string]interface{} {
+	var all []map[string]interface{}
+	for _, account := range votes {
+		all = append(all, map[string]interface{}{"_account_id": account, "value": 1})
+	}
+	return map[string]interface{}{
+		"project":          "g/p",
+		"_number":          number,
+		"current_revision": revision,
+		"revisions": map[string]interface{}{
+			revision: map[string]interface{}{
+				"ref":    "refs/changes/01/1/1",
+				"commit": map[string]interface{}{"parents": []map[string]string{{"commit": "base"}}},
+			},
+		},
+		"labels": map[string]interface{}{gerritLabel: map[string]interface{}{"all": all}},
+	}
+}
+
+func TestGerritPoll(t *testing.T) {
+	g, f := newFakeGerrit(t)
+	f.handle("GET /a/accounts/self", func(w http.ResponseWriter, req *http.Request) {
+		writeGerritJSON(t, w, req, map[string]int64{"_account_id": 100})
+	})
+	changes := []interface{}{gerritTestChange(1, "abc"), gerritTestChange(2, "def", 100), gerritTestChange(3, "ghi", 200)}
+	f.handle("GET /a/changes/", func(w http.ResponseWriter, req *http.Request) {
+		if q := req.URL.Query(); q.Get("q") != "status:open" || len(q["o"]) != 3 {
+			t.Errorf("got query %v, want the configured query with labels and revisions", q)
+		}
+		writeGerritJSON(t, w, req, changes)
+	})
+	ctx := context.Background()
+
+	creqs, err := g.Poll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(creqs) != 2 || creqs[0].SHA != "abc" || creqs[1].SHA != "ghi" {
+		t.Fatalf("polled %+v, want the changes the bot didn't vote on", creqs)
+	}
+	if c := creqs[0]; c.Repo != "g/p" || c.MergeRequest != 1 || c.Ref != "refs/changes/01/1/1" || c.BaseSHA != "base" {
+		t.Errorf("got request %+v, want change 1 of g/p", c)
+	}
+
+	changes = append(changes, gerritTestChange(4, "jkl"))
+	creqs, err = g.Poll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(creqs) != 1 || creqs[0].SHA != "jkl" {
+		t.Errorf("polled %+v again, want only the new change", creqs)
+	}
+	if n := f.count("GET /a/accounts/self"); n != 1 {
+		t.Errorf("got own account %d times, want it to be kept", n)
+	}
+
+	// Once the bot's vote is removed, e.g. by a rebase, the change is
+	// returned again.
+	changes = []interface{}{gerritTestChange(1, "abc", 100)}
+	if _, err := g.Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	changes = []interface{}{gerritTestChange(1, "abc")}
+	if creqs, err := g.Poll(ctx); err != nil || len(creqs) != 1 {
+		t.Errorf("polled %+v, %v, want the change without the bot's vote", creqs, err)
+	}
+}
+
+// serveGerritReview serves the files of patchset abc of change 5 of g/p and
+// records the reviews posted on it.
+func serveGerritReview(t *testing.T, f *fakeGitHub) *[]*gerritReview {
+	f.handle("GET /a/changes/g%2Fp~5/revisions/abc/files", func(w http.ResponseWriter, req *http.Request) {
+		writeGerritJSON(t, w, req, map[string]interface{}{"/COMMIT_MSG": map[string]int{}, "main.go": map[string]int{"lines_inserted": 3}})
+	})
+	var reviews []*gerritReview
+	f.handle("POST /a/changes/g%2Fp~5/revisions/abc/review", func(w http.ResponseWriter, req *http.Request) {
+		review := &gerritReview{}
+		if err := json.NewDecoder(req.Body).Decode(review); err != nil {
+			t.Errorf("failed to decode review: %s", err)
+		}
+		reviews = append(reviews, review)
+		writeGerritJSON(t, w, req, map[string]interface{}{})
+	})
+	return &reviews
+}
+
+func TestGerritReportResults(t *testing.T) {
+	g, f := newFakeGerrit(t)
+	reviews := serveGerritReview(t, f)
+	creq := &CheckRequest{Repo: "g/p", SHA: "abc", MergeRequest: 5}
+
+	err := g.ReportResults(context.Background(), creq, map[string]*Result{
+		"test-lint": {Conclusion: "failure", Title: "2 issues", URL: "https://example.com/invocation", Annotations: []*Annotation{
+			{Path: "main.go", Line: 3, Message: "unused"},
+			{Path: "other.go", Line: 1, Message: "not in the patchset"},
+		}},
+		"test-fmt": {Conclusion: "success"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*reviews) != 1 {
+		t.Fatalf("posted %d reviews, want 1", len(*reviews))
+	}
+	review := (*reviews)[0]
+	if review.Labels[gerritLabel] != -1 || review.Tag != "autogenerated:reviewbot" || !strings.Contains(review.Message, "test-lint: 2 issues") {
+		t.Errorf("got review %+v, want a -1 vote with a summary of the failed check", review)
+	}
+	comments := review.RobotComments["main.go"]
+	if len(review.RobotComments) != 1 || len(comments) != 1 {
+		t.Fatalf("got robot comments %v, want one on main.go", review.RobotComments)
+	}
+	if c := comments[0]; c.RobotID != "reviewbot/test-lint" || c.RobotRunID != "abc" || c.Line != 3 || c.Message != "unused" || c.URL != "https://example.com/invocation" {
+		t.Errorf("got robot comment %+v, want the annotation of test-lint", c)
+	}
+}
+
+func TestGerritReportResultsVotesForPassingChecks(t *testing.T) {
+	g, f := newFakeGerrit(t)
+	reviews := serveGerritReview(t, f)
+	creq := &CheckRequest{Repo: "g/p", SHA: "abc", MergeRequest: 5}
+
+	if err := g.ReportResults(context.Background(), creq, map[string]*Result{"test-fmt": {Conclusion: "success"}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(*reviews) != 1 || (*reviews)[0].Labels[gerritLabel] != 1 || (*reviews)[0].Message != "All 1 checks passed." {
+		t.Errorf("got reviews %+v, want a +1 vote", *reviews)
+	}
+
+	if err := g.ReportResults(context.Background(), creq, nil); err != nil || len(*reviews) != 1 {
+		t.Errorf("posted a review without results: %v", err)
+	}
+}
+
+func TestGerritComment(t *testing.T) {
+	g, f := newFakeGerrit(t)
+	reviews := serveGerritReview(t, f)
+	if err := g.Comment(context.Background(), &CheckRequest{Repo: "g/p", SHA: "abc", MergeRequest: 5}, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if len(*reviews) != 1 || (*reviews)[0].Message != "hello" || (*reviews)[0].Labels != nil {
+		t.Errorf("got reviews %+v, want a message without a vote", *reviews)
+	}
+}
+
+func TestGerritCloneURL(t *testing.T) {
+	g := &Gerrit{URL: "https://gerrit.example.com/", Username: "bot", Password: "secret"}
+	url, auth, err := g.CloneURL(context.Background(), "g/p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "https://gerrit.example.com/a/g/p"; url != want {
+		t.Errorf("got clone URL %q, want %q", url, want)
+	}
+	if want := (&githttp.BasicAuth{Username: "bot", Password: "secret"}); !reflect.DeepEqual(auth, want) {
+		t.Errorf("got clone credentials %v, want the bot's", auth)
+	}
+	if err := g.PushBranch(context.Background(), nil, "g/p", "main"); err == nil {
+		t.Errorf("pushing to Gerrit succeeded")
+	}
+}
@@ -0,0 +1,291 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+// gitlabDeveloperAccess is the access level of the Developer role, the
+// lowest that may push to a project.
+const gitlabDeveloperAccess = 30
+
+// maxGitLabDescription is the longest description of a commit status GitLab
+// accepts.
+const maxGitLabDescription = 255
+
+// GitLab is a Provider for projects on GitLab. Results are reported as commit
+// statuses, which GitLab shows as an external pipeline on merge requests.
+// Checks run when merge requests are opened or pushed to, and can be run
+// again or fixed with commands in merge request comments.
+type GitLab struct {
+	// URL is the GitLab instance, e.g. "https://gitlab.com".
+	URL string
+	// Token is an access token with the api scope and at least the Developer
+	// role, used for the API, clones and pushes.
+	Token string
+	// WebhookSecret is the secret token of the project or group webhooks.
+	WebhookSecret string
+}
+
+func (g *GitLab) Name() string { return "gitlab" }
+
+type gitlabProject struct {
+	ID                int64  `json:"id"`
+	PathWithNamespace string `json:"path_with_namespace"`
+}
+
+type gitlabMergeRequest struct {
+	IID             int    `json:"iid"`
+	Action          string `json:"action"`
+	OldRev          string `json:"oldrev"`
+	SourceBranch    string `json:"source_branch"`
+	SourceProjectID int64  `json:"source_project_id"`
+	TargetProjectID int64  `json:"target_project_id"`
+	LastCommit      struct {
+		ID string `json:"id"`
+	} `json:"last_commit"`
+}
+
+// gitlabWebhook holds the fields of merge request and note webhooks that the
+// bot uses.
+type gitlabWebhook struct {
+	ObjectKind string `json:"object_kind"`
+	User       struct {
+		ID       int64  `json:"id"`
+		Username string `json:"username"`
+	} `json:"user"`
+	Project          gitlabProject `json:"project"`
+	ObjectAttributes struct {
+		gitlabMergeRequest
+		// Note and NoteableType are set on note events.
+		Note         string `json:"note"`
+		NoteableType string `json:"noteable_type"`
+	} `json:"object_attributes"`
+	// MergeRequest is the merge request commented on by a note event.
+	MergeRequest *gitlabMergeRequest `json:"merge_request"`
+}
+
+func (g *GitLab) ParseWebhook(ctx context.Context, req *http.Request) (*CheckRequest, error) {
+	token := []byte(req.Header.Get("X-Gitlab-Token"))
+	if subtle.ConstantTimeCompare(token, []byte(g.WebhookSecret)) != 1 {
+		return nil, &httpError{statusCode: http.StatusUnauthorized, message: "invalid webhook token"}
+	}
+	payload, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	hook := &gitlabWebhook{}
+	if err := json.Unmarshal(payload, hook); err != nil {
+		return nil, &httpError{statusCode: http.StatusBadRequest, message: fmt.Sprintf("invalid webhook payload: %s", err)}
+	}
+	ctx = withLogFields(ctx, "repo", hook.Project.PathWithNamespace)
+	logFrom(ctx).Infow("got webhook", "event", req.Header.Get("X-Gitlab-Event"))
+	switch hook.ObjectKind {
+	case "merge_request":
+		mr := &hook.ObjectAttributes.gitlabMergeRequest
+		// Updates without a previous revision only change the merge
+		// request's description, labels and the like.
+		if mr.Action != "open" && mr.Action != "reopen" && (mr.Action != "update" || mr.OldRev == "") {
+			return nil, nil
+		}
+		return g.mergeRequestCheckRequest(ctx, &hook.Project, mr)
+	case "note":
+		if hook.ObjectAttributes.NoteableType != "MergeRequest" || hook.MergeRequest == nil {
+			return nil, nil
+		}
+		return g.commandCheckRequest(ctx, hook)
+	}
+	return nil, nil
+}
+
+// mergeRequestCheckRequest returns the request to run the checks on the head
+// of mr.
+func (g *GitLab) mergeRequestCheckRequest(ctx context.Context, project *gitlabProject, mr *gitlabMergeRequest) (*CheckRequest, error) {
+	creq := &CheckRequest{
+		Repo:         project.PathWithNamespace,
+		SHA:          mr.LastCommit.ID,
+		MergeRequest: mr.IID,
+	}
+	// Fixes can't be pushed to forks.
+	if mr.SourceProjectID == mr.TargetProjectID {
+		creq.Branch = mr.SourceBranch
+	}
+	var details struct {
+		DiffRefs struct {
+			BaseSHA string `json:"base_sha"`
+		} `json:"diff_refs"`
+	}
+	if err := g.api(ctx, http.MethodGet, g.projectPath(creq.Repo, fmt.Sprintf("merge_requests/%d", mr.IID)), nil, &details); err != nil {
+		logFrom(ctx).Warnw("failed to get merge request", "error", err)
+	}
+	creq.BaseSHA = details.DiffRefs.BaseSHA
+	return creq, nil
+}
+
+// commandCheckRequest returns the request of the command in a merge request
+// comment, if any. Only members with the Developer role or higher may run
+// commands.
+func (g *GitLab) commandCheckRequest(ctx context.Context, hook *gitlabWebhook) (*CheckRequest, error) {
+	commands := parseCommands(hook.ObjectAttributes.Note)
+	if len(commands) == 0 {
+		return nil, nil
+	}
+	ctx = withLogFields(ctx, "mr", hook.MergeRequest.IID, "user", hook.User.Username)
+	var member struct {
+		AccessLevel int `json:"access_level"`
+	}
+	err := g.api(ctx, http.MethodGet, g.projectPath(hook.Project.PathWithNamespace, fmt.Sprintf("members/all/%d", hook.User.ID)), nil, &member)
+	if err != nil || member.AccessLevel < gitlabDeveloperAccess {
+		logFrom(ctx).Infow("ignoring command from user without developer access", "access_level", member.AccessLevel, "error", err)
+		return nil, nil
+	}
+	creq, err := g.mergeRequestCheckRequest(ctx, &hook.Project, hook.MergeRequest)
+	if err != nil {
+		return nil, err
+	}
+	// Only the first command is run, since each one needs its own checkout.
+	cmd := commands[0]
+	for _, checkName := range cmd.args {
+		if _, err := GetChecker(checkName); err != nil {
+			return nil, g.Comment(ctx, creq, fmt.Sprintf("@%s %s", hook.User.Username, err))
+		}
+	}
+	switch cmd.name {
+	case "rerun":
+		creq.Checks = cmd.args
+		return creq, nil
+	case "fix":
+		if len(cmd.args) != 1 {
+			return nil, g.Comment(ctx, creq, fmt.Sprintf("@%s usage: %s fix <check>", hook.User.Username, commandPrefix))
+		}
+		if creq.Branch == "" {
+			return nil, g.Comment(ctx, creq, fmt.Sprintf("@%s can't push fixes to a fork.", hook.User.Username))
+		}
+		creq.Fix = cmd.args[0]
+		return creq, nil
+	}
+	return nil, g.Comment(ctx, creq, fmt.Sprintf("@%s unknown command %q, expected rerun or fix", hook.User.Username, cmd.name))
+}
+
+// repoURL returns the clone URL of repo, without credentials.
+func (g *GitLab) repoURL(repo string) (string, error) {
+	u, err := url.Parse(g.URL)
+	if err != nil {
+		return "", fmt.Errorf("invalid GitLab URL %q: %s", g.URL, err)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + repo + ".git"
+	return u.String(), nil
+}
+
+// auth returns the credentials of git operations on the instance's
+// repositories.
+func (g *GitLab) auth() transport.AuthMethod {
+	return &githttp.BasicAuth{Username: "oauth2", Password: g.Token}
+}
+
+func (g *GitLab) CloneURL(ctx context.Context, repo string) (string, transport.AuthMethod, error) {
+	url, err := g.repoURL(repo)
+	return url, g.auth(), err
+}
+
+func (g *GitLab) MergeRequestRefSpec() config.RefSpec {
+	return config.RefSpec("+refs/merge-requests/*/head:refs/remotes/origin/merge-requests/*")
+}
+
+// gitlabState maps the conclusion of a result to the state of a commit
+// status.
+func gitlabState(result *Result) string {
+	if result == nil {
+		return "running"
+	}
+	switch result.Conclusion {
+	case "success", "neutral", "skipped":
+		return "success"
+	case "cancelled":
+		return "canceled"
+	}
+	return "failed"
+}
+
+func (g *GitLab) ReportStatus(ctx context.Context, creq *CheckRequest, checkName string, result *Result) error {
+	status := map[string]string{
+		"name":  checkName,
+		"state": gitlabState(result),
+	}
+	if result != nil {
+		description := result.Title
+		if len(description) > maxGitLabDescription {
+			description = description[:maxGitLabDescription-3] + "..."
+		}
+		status["description"] = description
+		if result.URL != "" {
+			status["target_url"] = result.URL
+		}
+	}
+	return g.api(ctx, http.MethodPost, g.projectPath(creq.Repo, "statuses/"+creq.SHA), status, nil)
+}
+
+func (g *GitLab) Comment(ctx context.Context, creq *CheckRequest, body string) error {
+	path := g.projectPath(creq.Repo, fmt.Sprintf("merge_requests/%d/notes", creq.MergeRequest))
+	return g.api(ctx, http.MethodPost, path, map[string]string{"body": body}, nil)
+}
+
+func (g *GitLab) PushBranch(ctx context.Context, r *git.Repository, repo string, branch string) error {
+	url, err := g.repoURL(repo)
+	if err != nil {
+		return err
+	}
+	return pushBranchTo(ctx, r, url, g.auth(), branch)
+}
+
+// projectPath returns the API path of a resource of repo, which the API
+// accepts URL-encoded in place of the project's ID.
+func (g *GitLab) projectPath(repo string, resource string) string {
+	return fmt.Sprintf("projects/%s/%s", url.PathEscape(repo), resource)
+}
+
+// api sends a request with a JSON body, if any, to path of the GitLab API and
+// decodes the response into out, if set.
+func (g *GitLab) api(ctx context.Context, method string, path string, body interface{}, out interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(g.URL, "/")+"/api/v4/"+path, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("PRIVATE-TOKEN", g.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		b, _ := io.ReadAll(io.LimitReader(res.Body, 1<<10))
+		return fmt.Errorf("GitLab API %s %s returned %s: %s", method, path, res.Status, strings.TrimSpace(string(b)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
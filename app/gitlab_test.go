@@ -0,0 +1,286 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+const testGitLabSecret = "gitlab-secret"
+
+// newFakeGitLab returns a GitLab provider talking to a fake server, whose
+// routes are like "GET /api/v4/projects/g%2Fp".
+func newFakeGitLab(t *testing.T) (*GitLab, *fakeGitHub) {
+	f := newFakeAPI(t)
+	return &GitLab{URL: f.URL + "/", Token: "gitlab-token", WebhookSecret: testGitLabSecret}, f
+}
+
+// recordGitLabRequests records the JSON bodies sent to route.
+func recordGitLabRequests(t *testing.T, f *fakeGitHub, route string) *[]map[string]string {
+	var bodies []map[string]string
+	f.handle(route, func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("PRIVATE-TOKEN") != "gitlab-token" {
+			t.Errorf("%s wasn't authenticated with the token", route)
+		}
+		body := make(map[string]string)
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			t.Errorf("failed to decode %s: %s", route, err)
+		}
+		bodies = append(bodies, body)
+		writeTestJSON(w, http.StatusCreated, map[string]interface{}{"id": 1})
+	})
+	return &bodies
+}
+
+func gitlabWebhookRequest(t *testing.T, token string, payload interface{}) *http.Request {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/gitlab/event_handler", bytes.NewReader(body))
+	req.Header.Set("X-Gitlab-Token", token)
+	req.Header.Set("X-Gitlab-Event", "Merge Request Hook")
+	return req
+}
+
+func gitlabMergeRequestPayload(action string, oldRev string) map[string]interface{} {
+	return map[string]interface{}{
+		"object_kind": "merge_request",
+		"project":     map[string]interface{}{"id": 1, "path_with_namespace": "g/p"},
+		"object_attributes": map[string]interface{}{
+			"iid":               5,
+			"action":            action,
+			"oldrev":            oldRev,
+			"source_branch":     "feature",
+			"source_project_id": 1,
+			"target_project_id": 1,
+			"last_commit":       map[string]interface{}{"id": "abc"},
+		},
+	}
+}
+
+func gitlabNotePayload(note string) map[string]interface{} {
+	mr := gitlabMergeRequestPayload("", "")["object_attributes"]
+	return map[string]interface{}{
+		"object_kind":       "note",
+		"user":              map[string]interface{}{"id": 9, "username": "dev"},
+		"project":           map[string]interface{}{"id": 1, "path_with_namespace": "g/p"},
+		"object_attributes": map[string]interface{}{"note": note, "noteable_type": "MergeRequest"},
+		"merge_request":     mr,
+	}
+}
+
+// serveGitLabMergeRequest serves merge request 5 of g/p, with base as the
+// head of its target branch, and dev as a member with accessLevel.
+func serveGitLabMergeRequest(f *fakeGitHub, base string, accessLevel int) {
+	f.handle("GET /api/v4/projects/g%2Fp/merge_requests/5", func(w http.ResponseWriter, req *http.Request) {
+		writeTestJSON(w, http.StatusOK, map[string]interface{}{"diff_refs": map[string]string{"base_sha": base}})
+	})
+	f.handle("GET /api/v4/projects/g%2Fp/members/all/9", func(w http.ResponseWriter, req *http.Request) {
+		writeTestJSON(w, http.StatusOK, map[string]int{"access_level": accessLevel})
+	})
+}
+
+func TestGitLabParseWebhookRejectsInvalidToken(t *testing.T) {
+	g, _ := newFakeGitLab(t)
+	_, err := g.ParseWebhook(context.Background(), gitlabWebhookRequest(t, "wrong", gitlabMergeRequestPayload("open", "")))
+	if e, ok := err.(*httpError); !ok || e.statusCode != http.StatusUnauthorized {
+		t.Errorf("got error %v, want unauthorized", err)
+	}
+}
+
+func TestGitLabParseWebhookMergeRequest(t *testing.T) {
+	g, f := newFakeGitLab(t)
+	serveGitLabMergeRequest(f, "base", gitlabDeveloperAccess)
+
+	for _, tc := range []struct {
+		action, oldRev string
+		want           bool
+	}{
+		{"open", "", true},
+		{"reopen", "", true},
+		{"update", "old", true},
+		{"update", "", false},
+		{"close", "", false},
+	} {
+		creq, err := g.ParseWebhook(context.Background(), gitlabWebhookRequest(t, testGitLabSecret, gitlabMergeRequestPayload(tc.action, tc.oldRev)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (creq != nil) != tc.want {
+			t.Errorf("%s with old revision %q: got request %+v, want one: %t", tc.action, tc.oldRev, creq, tc.want)
+			continue
+		}
+		if creq == nil {
+			continue
+		}
+		want := CheckRequest{Repo: "g/p", SHA: "abc", BaseSHA: "base", Branch: "feature", MergeRequest: 5}
+		if creq.Repo != want.Repo || creq.SHA != want.SHA || creq.BaseSHA != want.BaseSHA || creq.Branch != want.Branch || creq.MergeRequest != want.MergeRequest {
+			t.Errorf("%s: got request %+v, want %+v", tc.action, creq, want)
+		}
+	}
+}
+
+func TestGitLabParseWebhookMergeRequestFromFork(t *testing.T) {
+	g, f := newFakeGitLab(t)
+	serveGitLabMergeRequest(f, "base", gitlabDeveloperAccess)
+	payload := gitlabMergeRequestPayload("open", "")
+	payload["object_attributes"].(map[string]interface{})["source_project_id"] = 2
+
+	creq, err := g.ParseWebhook(context.Background(), gitlabWebhookRequest(t, testGitLabSecret, payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creq == nil || creq.Branch != "" {
+		t.Errorf("got request %+v, want one without a branch to push fixes to", creq)
+	}
+}
+
+func TestGitLabParseWebhookCommands(t *testing.T) {
+	registerTestChecker(t, &funcChecker{name: "test-lint"})
+	for _, tc := range []struct {
+		note        string
+		accessLevel int
+		checks      []string
+		fix         string
+		comment     string
+	}{
+		{note: "looks good", accessLevel: gitlabDeveloperAccess},
+		{note: "/reviewbot rerun test-lint", accessLevel: gitlabDeveloperAccess, checks: []string{"test-lint"}},
+		{note: "/reviewbot rerun test-lint", accessLevel: 20},
+		{note: "/reviewbot fix test-lint", accessLevel: gitlabDeveloperAccess, fix: "test-lint"},
+		{note: "/reviewbot fix", accessLevel: gitlabDeveloperAccess, comment: "@dev usage: /reviewbot fix <check>"},
+		{note: "/reviewbot rerun unknown", accessLevel: gitlabDeveloperAccess, comment: "@dev "},
+		{note: "/reviewbot deploy", accessLevel: gitlabDeveloperAccess, comment: `@dev unknown command "deploy"`},
+	} {
+		g, f := newFakeGitLab(t)
+		serveGitLabMergeRequest(f, "base", tc.accessLevel)
+		notes := recordGitLabRequests(t, f, "POST /api/v4/projects/g%2Fp/merge_requests/5/notes")
+
+		creq, err := g.ParseWebhook(context.Background(), gitlabWebhookRequest(t, testGitLabSecret, gitlabNotePayload(tc.note)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		run := tc.checks != nil || tc.fix != ""
+		if (creq != nil) != run {
+			t.Errorf("%q: got request %+v, want one: %t", tc.note, creq, run)
+		} else if creq != nil && (strings.Join(creq.Checks, ",") != strings.Join(tc.checks, ",") || creq.Fix != tc.fix || creq.SHA != "abc") {
+			t.Errorf("%q: got request %+v, want checks %q and fix %q of abc", tc.note, creq, tc.checks, tc.fix)
+		}
+		if tc.comment == "" {
+			if len(*notes) != 0 {
+				t.Errorf("%q: commented %v, want no comment", tc.note, *notes)
+			}
+		} else if len(*notes) != 1 || !strings.HasPrefix((*notes)[0]["body"], tc.comment) {
+			t.Errorf("%q: commented %v, want a comment starting with %q", tc.note, *notes, tc.comment)
+		}
+	}
+}
+
+func TestGitLabFixCommandFromFork(t *testing.T) {
+	registerTestChecker(t, &funcChecker{name: "test-lint"})
+	g, f := newFakeGitLab(t)
+	serveGitLabMergeRequest(f, "base", gitlabDeveloperAccess)
+	notes := recordGitLabRequests(t, f, "POST /api/v4/projects/g%2Fp/merge_requests/5/notes")
+	payload := gitlabNotePayload("/reviewbot fix test-lint")
+	payload["merge_request"].(map[string]interface{})["source_project_id"] = 2
+
+	creq, err := g.ParseWebhook(context.Background(), gitlabWebhookRequest(t, testGitLabSecret, payload))
+	if err != nil || creq != nil {
+		t.Fatalf("got request %+v, %v, want none", creq, err)
+	}
+	if len(*notes) != 1 || (*notes)[0]["body"] != "@dev can't push fixes to a fork." {
+		t.Errorf("commented %v, want an explanation", *notes)
+	}
+}
+
+func TestGitLabState(t *testing.T) {
+	for _, tc := range []struct {
+		result *Result
+		want   string
+	}{
+		{nil, "running"},
+		{&Result{Conclusion: "success"}, "success"},
+		{&Result{Conclusion: "neutral"}, "success"},
+		{&Result{Conclusion: "skipped"}, "success"},
+		{&Result{Conclusion: "cancelled"}, "canceled"},
+		{&Result{Conclusion: "failure"}, "failed"},
+		{&Result{Conclusion: "timed_out"}, "failed"},
+	} {
+		if got := gitlabState(tc.result); got != tc.want {
+			t.Errorf("gitlabState(%+v) = %q, want %q", tc.result, got, tc.want)
+		}
+	}
+}
+
+func TestGitLabReportStatus(t *testing.T) {
+	g, f := newFakeGitLab(t)
+	statuses := recordGitLabRequests(t, f, "POST /api/v4/projects/g%2Fp/statuses/abc")
+	creq := &CheckRequest{Repo: "g/p", SHA: "abc"}
+	ctx := context.Background()
+
+	if err := g.ReportStatus(ctx, creq, "test-lint", nil); err != nil {
+		t.Fatal(err)
+	}
+	title := strings.Repeat("x", 300)
+	if err := g.ReportStatus(ctx, creq, "test-lint", &Result{Conclusion: "failure", Title: title, URL: "https://example.com/invocation"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(*statuses) != 2 {
+		t.Fatalf("reported %d statuses, want 2", len(*statuses))
+	}
+	if s := (*statuses)[0]; s["name"] != "test-lint" || s["state"] != "running" {
+		t.Errorf("got status %v, want test-lint running", s)
+	}
+	s := (*statuses)[1]
+	if s["state"] != "failed" || s["target_url"] != "https://example.com/invocation" {
+		t.Errorf("got status %v, want a failure linking to the invocation", s)
+	}
+	if d := s["description"]; len(d) != maxGitLabDescription || !strings.HasSuffix(d, "...") {
+		t.Errorf("got description of %d characters, want it truncated to %d", len(d), maxGitLabDescription)
+	}
+}
+
+func TestGitLabComment(t *testing.T) {
+	g, f := newFakeGitLab(t)
+	notes := recordGitLabRequests(t, f, "POST /api/v4/projects/g%2Fp/merge_requests/5/notes")
+
+	if err := g.Comment(context.Background(), &CheckRequest{Repo: "g/p", MergeRequest: 5}, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if len(*notes) != 1 || (*notes)[0]["body"] != "hello" {
+		t.Errorf("commented %v, want hello", *notes)
+	}
+}
+
+func TestGitLabAPIError(t *testing.T) {
+	g, _ := newFakeGitLab(t)
+	err := g.Comment(context.Background(), &CheckRequest{Repo: "g/p", MergeRequest: 5}, "hello")
+	if err == nil || !strings.Contains(err.Error(), "404 Not Found") {
+		t.Errorf("got error %v, want the status of the failed request", err)
+	}
+}
+
+func TestGitLabCloneURL(t *testing.T) {
+	g := &GitLab{URL: "https://gitlab.example.com/", Token: "secret"}
+	url, auth, err := g.CloneURL(context.Background(), "g/p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "https://gitlab.example.com/g/p.git"; url != want {
+		t.Errorf("got clone URL %q, want %q", url, want)
+	}
+	if want := (&githttp.BasicAuth{Username: "oauth2", Password: "secret"}); !reflect.DeepEqual(auth, want) {
+		t.Errorf("got clone credentials %v, want the token", auth)
+	}
+	if _, _, err := (&GitLab{URL: "://invalid"}).CloneURL(context.Background(), "g/p"); err == nil {
+		t.Errorf("got no error for an invalid GitLab URL")
+	}
+}
@@ -0,0 +1,79 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+const gofmtCheck = "gofmt"
+
+func init() {
+	RegisterChecker(&funcChecker{
+		name:         gofmtCheck,
+		fn:           checkGofmt,
+		fixFn:        fixGofmt,
+		fixMessage:   "Format Go sources",
+		optIn:        true,
+		requirements: CheckRequirements{Binaries: []string{"gofmt", "git"}},
+		rule:         CheckRule{Description: "Go files are formatted with gofmt.", HelpURI: "https://pkg.go.dev/cmd/gofmt"},
+	})
+}
+
+// checkGofmt lists the Go files that aren't formatted. It runs gofmt unless
+// the repository configures `tool: gofumpt`.
+func checkGofmt(ctx context.Context, _ *GithubApp, target *CheckTarget) (*Result, error) {
+	tool := target.Config.ToolOr("gofmt")
+	args := append([]string{"-l"}, target.Config.Flags...)
+	stdOut, stdErr, err := runCheckCmd(ctx, target, tool, append(args, ".")...)
+	if stdErr.Len() != 0 {
+		// gofmt reports syntax errors on stderr.
+		return nil, fmt.Errorf("%s failed: %s", tool, strings.TrimSpace(cleanLine(stdErr.String())))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	annotations := []*Annotation{}
+	scanner := bufio.NewScanner(&stdOut)
+	for scanner.Scan() {
+		path := strings.TrimSpace(cleanLine(scanner.Text()))
+		if path == "" {
+			continue
+		}
+		path = filepath.ToSlash(filepath.Clean(path))
+		annotations = append(annotations, &Annotation{
+			Message:   fmt.Sprintf("file %q needs reformat with %s", path, tool),
+			Severity:  "failure",
+			Path:      path,
+			Line:      1,
+			WholeFile: true,
+		})
+	}
+	annotations = target.Config.filterAnnotations(annotations)
+
+	res := &Result{
+		Title: fmt.Sprintf("%s result", tool),
+	}
+	if len(annotations) == 0 {
+		res.Summary = "No issues found."
+		res.Conclusion = "success"
+		return res, nil
+	}
+	res.Summary = fmt.Sprintf("%d Go files need reformat", len(annotations))
+	res.Conclusion = "failure"
+	res.Annotations = annotations
+	res.Action = newFixAction(gofmtCheck, fmt.Sprintf("Automatically reformat with %s.", tool))
+	return res, nil
+}
+
+// fixGofmt reformats every Go file in the checkout in place.
+func fixGofmt(ctx context.Context, _ *GithubApp, target *CheckTarget) error {
+	_, stdErr, err := runCheckCmd(ctx, target, target.Config.ToolOr("gofmt"), "-w", ".")
+	if stdErr.Len() != 0 {
+		return fmt.Errorf("failed to reformat: %s", stdErr.String())
+	}
+	return err
+}
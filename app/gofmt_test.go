@@ -0,0 +1,54 @@
+package app
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCheckGofmtAndFix(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "main.go", "package main\n\nfunc main() {}\n")
+	writeTestFile(t, dir, "lib/util.go", "package lib\nfunc  Util( ) {\n}\n")
+	target := &CheckTarget{Dir: dir, Config: &CheckConfig{}}
+
+	res, err := checkGofmt(context.Background(), nil, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Conclusion != "failure" || len(res.Annotations) != 1 || res.Annotations[0].Path != "lib/util.go" {
+		t.Fatalf("got %s with annotations %+v, want a failure on lib/util.go", res.Conclusion, res.Annotations)
+	}
+	if res.Action == nil || res.Action.Identifier != fixIdentifier(gofmtCheck) {
+		t.Errorf("got action %+v, want the fix action of gofmt", res.Action)
+	}
+
+	if err := fixGofmt(context.Background(), nil, target); err != nil {
+		t.Fatal(err)
+	}
+	res, err = checkGofmt(context.Background(), nil, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Conclusion != "success" {
+		t.Errorf("got %s with annotations %+v after the fix, want success", res.Conclusion, res.Annotations)
+	}
+}
+
+func TestCheckGofmtReportsSyntaxErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "main.go", "package main\n\nfunc main() {\n")
+	if _, err := checkGofmt(context.Background(), nil, &CheckTarget{Dir: dir, Config: &CheckConfig{}}); err == nil {
+		t.Errorf("check succeeded on a file that doesn't parse")
+	}
+}
+
+func TestCheckGofmtRunsConfiguredTool(t *testing.T) {
+	installFakeTool(t, "gofumpt", "echo main.go\n")
+	res, err := checkGofmt(context.Background(), nil, &CheckTarget{Dir: t.TempDir(), Config: &CheckConfig{Tool: "gofumpt"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Title != "gofumpt result" || len(res.Annotations) != 1 {
+		t.Errorf("got %q with annotations %+v, want gofumpt's finding", res.Title, res.Annotations)
+	}
+}
@@ -0,0 +1,96 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+const golangciLintCheck = "golangci-lint"
+
+// golangciReport is the subset of `golangci-lint run --out-format=json` output
+// that we report on.
+type golangciReport struct {
+	Issues []struct {
+		FromLinter string
+		Text       string
+		Severity   string
+		Pos        struct {
+			Filename string
+			Line     int
+			Column   int
+		}
+	}
+}
+
+// golangciSeverity maps a golangci-lint issue severity to an annotation
+// severity. Issues have no severity unless the repository configures one, and
+// golangci-lint fails on them, so those are failures.
+func golangciSeverity(severity string) string {
+	switch severity {
+	case "warning":
+		return "warning"
+	case "info", "notice":
+		return "notice"
+	}
+	return "failure"
+}
+
+func init() {
+	RegisterChecker(&funcChecker{
+		name:         golangciLintCheck,
+		fn:           checkGolangciLint,
+		optIn:        true,
+		requirements: CheckRequirements{Binaries: []string{"golangci-lint"}},
+		rule:         CheckRule{Description: "Go files pass golangci-lint.", HelpURI: "https://golangci-lint.run/usage/linters/"},
+	})
+}
+
+// checkGolangciLint runs golangci-lint on the Go packages of the checkout.
+func checkGolangciLint(ctx context.Context, _ *GithubApp, target *CheckTarget) (*Result, error) {
+	args := append([]string{"run", "--out-format=json"}, target.Config.Flags...)
+	args = append(args, "./...")
+	stdOut, _, err := runCheckCmd(ctx, target, "golangci-lint", args...)
+	if stdOut.Len() == 0 {
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("golangci-lint produced no output")
+	}
+
+	report := &golangciReport{}
+	if err := json.Unmarshal(stdOut.Bytes(), report); err != nil {
+		return nil, fmt.Errorf("failed to parse golangci-lint output: %s", err)
+	}
+
+	annotations := []*Annotation{}
+	for _, issue := range report.Issues {
+		annotations = append(annotations, &Annotation{
+			Message:  fmt.Sprintf("%s (%s)", cleanLine(issue.Text), issue.FromLinter),
+			Severity: golangciSeverity(issue.Severity),
+			Path:     issue.Pos.Filename,
+			Line:     issue.Pos.Line,
+		})
+	}
+	annotations = target.Config.filterAnnotations(annotations)
+
+	res := &Result{
+		Title: "golangci-lint result",
+	}
+	if len(annotations) == 0 {
+		res.Summary = "No issues found."
+		res.Conclusion = "success"
+		return res, nil
+	}
+	res.Summary = fmt.Sprintf("%d issues found", len(annotations))
+	res.Annotations = annotations
+	// Warnings and notices alone don't fail the check.
+	res.Conclusion = "neutral"
+	for _, a := range annotations {
+		if a.Severity == "failure" {
+			res.Conclusion = "failure"
+			break
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,56 @@
+package app
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCheckGolangciLint(t *testing.T) {
+	installFakeTool(t, "golangci-lint", `cat <<'REPORT'
+{"Issues": [
+  {"FromLinter": "errcheck", "Text": "Error return value is not checked", "Pos": {"Filename": "main.go", "Line": 12, "Column": 2}},
+  {"FromLinter": "godot", "Text": "Comment should end in a period", "Severity": "warning", "Pos": {"Filename": "lib/util.go", "Line": 3, "Column": 1}},
+  {"FromLinter": "misspell", "Text": "misspelled word", "Severity": "info", "Pos": {"Filename": "README.go", "Line": 1, "Column": 1}}
+]}
+REPORT
+exit 1
+`)
+	res, err := checkGolangciLint(context.Background(), nil, &CheckTarget{Dir: t.TempDir(), Config: &CheckConfig{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Conclusion != "failure" {
+		t.Errorf("got conclusion %q, want failure", res.Conclusion)
+	}
+	want := []Annotation{
+		{Path: "main.go", Line: 12, Message: "Error return value is not checked (errcheck)", Severity: "failure"},
+		{Path: "lib/util.go", Line: 3, Message: "Comment should end in a period (godot)", Severity: "warning"},
+		{Path: "README.go", Line: 1, Message: "misspelled word (misspell)", Severity: "notice"},
+	}
+	if len(res.Annotations) != len(want) {
+		t.Fatalf("got %d annotations, want %d", len(res.Annotations), len(want))
+	}
+	for i, a := range res.Annotations {
+		if *a != want[i] {
+			t.Errorf("annotation %d: got %+v, want %+v", i, *a, want[i])
+		}
+	}
+}
+
+func TestCheckGolangciLintWithOnlyWarnings(t *testing.T) {
+	installFakeTool(t, "golangci-lint", `echo '{"Issues": [{"FromLinter": "godot", "Text": "Comment should end in a period", "Severity": "warning", "Pos": {"Filename": "main.go", "Line": 3}}]}'`)
+	res, err := checkGolangciLint(context.Background(), nil, &CheckTarget{Dir: t.TempDir(), Config: &CheckConfig{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Conclusion != "neutral" {
+		t.Errorf("got conclusion %q, want warnings alone to be neutral", res.Conclusion)
+	}
+}
+
+func TestCheckGolangciLintWithoutOutput(t *testing.T) {
+	installFakeTool(t, "golangci-lint", "echo 'no go files to analyze' >&2; exit 3\n")
+	if _, err := checkGolangciLint(context.Background(), nil, &CheckTarget{Dir: t.TempDir(), Config: &CheckConfig{}}); err == nil {
+		t.Errorf("check succeeded although golangci-lint failed without output")
+	}
+}
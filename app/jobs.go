@@ -0,0 +1,399 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v43/github"
+)
+
+// Job is a set of check runs for one head SHA that is ready to be executed.
+// It carries an installation-scoped token so that a worker can clone the
+// repository and report the results without access to the app's private key.
+type Job struct {
+	// DeliveryID is the ID of the webhook delivery that created the job. It
+	// is only used to tag log lines.
+	DeliveryID     string
+	AppID          int64
+	InstallationID int64
+	FullRepoName   string
+	HeadSHA        string
+	// BaseSHA is the base of the pull request for HeadSHA, if any. Checks
+	// use it to find the changed files.
+	BaseSHA string
+	// PullNumber is the number of the pull request for HeadSHA, or 0 if
+	// there is none.
+	PullNumber int
+	// BaseBranch is the branch the pull request for HeadSHA is based on, if
+	// any.
+	BaseBranch string
+	Checks     []*JobCheck
+	Token      string
+}
+
+// JobCheck is a check of a job and the check run its result is reported on.
+type JobCheck struct {
+	Name string
+	// CheckRunID is 0 if the installation can't create check runs, in which
+	// case the result is reported as a commit status.
+	CheckRunID int64
+}
+
+// jobBranch returns the branch whose settings apply to the job's commit: the
+// base branch of its pull request, or else a branch whose head is the commit.
+// It returns "" if there is neither.
+func jobBranch(ctx context.Context, ghc *github.Client, job *Job) (string, error) {
+	if job.PullNumber != 0 {
+		return job.BaseBranch, nil
+	}
+	return headBranch(ctx, ghc, job)
+}
+
+func (j *Job) ownerAndRepo() (string, string) {
+	owner, repo, _ := strings.Cut(j.FullRepoName, "/")
+	return owner, repo
+}
+
+// usesStatuses reports whether the job's checks are reported as commit
+// statuses rather than check runs.
+func (j *Job) usesStatuses() bool {
+	for _, check := range j.Checks {
+		if check.CheckRunID == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// JobDispatcher hands jobs off for execution, e.g. by publishing them to a
+// queue that a pool of worker processes consumes.
+type JobDispatcher interface {
+	Dispatch(ctx context.Context, job *Job) error
+}
+
+// inlineDispatcher runs jobs synchronously in the calling goroutine.
+type inlineDispatcher struct {
+	app *GithubApp
+}
+
+func (d *inlineDispatcher) Dispatch(ctx context.Context, job *Job) error {
+	return d.app.RunJob(ctx, job)
+}
+
+// InProcessDispatcher runs jobs on a fixed pool of goroutines in the current
+// process. Jobs are scheduled fairly across installations, see jobScheduler.
+type InProcessDispatcher struct {
+	jobs *jobScheduler
+}
+
+// NewInProcessDispatcher starts workers goroutines that run dispatched jobs
+// with app.
+func NewInProcessDispatcher(app *GithubApp, workers int) *InProcessDispatcher {
+	d := &InProcessDispatcher{
+		jobs: newJobScheduler(),
+	}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for {
+				job := d.jobs.Pop()
+				if err := app.RunJob(context.Background(), job); err != nil {
+					logFrom(jobContext(context.Background(), job)).Errorw("failed to run job", "error", err)
+					app.recordJobFailure(job, err)
+				}
+				d.jobs.Done(job)
+			}
+		}()
+	}
+	return d
+}
+
+func (d *InProcessDispatcher) Dispatch(ctx context.Context, job *Job) error {
+	return d.jobs.Push(ctx, job)
+}
+
+// tokenTransport authenticates requests with an installation token.
+type tokenTransport struct {
+	token string
+}
+
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "token "+t.token)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// jobClient returns a client authenticated with the job's token.
+func (app *GithubApp) jobClient(job *Job) *github.Client {
+	return app.newClient(job.InstallationID, &tokenTransport{token: job.Token})
+}
+
+// RunJob clones the repository once at the job's head SHA, runs all of the
+// job's checks concurrently against that checkout and reports each result on
+// its check run. Checks with a cached result for the same content aren't run,
+// and if none are left, the repository isn't cloned either. It only uses the
+// job's token to talk to GitHub, so it can run in a worker process.
+func (app *GithubApp) RunJob(ctx context.Context, job *Job) error {
+	ctx = jobContext(ctx, job)
+	var checks []*JobCheck
+	for _, check := range job.Checks {
+		key := inFlightKey(job.InstallationID, job.HeadSHA, check.Name)
+		if !app.inFlight.Add(key) {
+			logFrom(ctx).Infow("check is already running", "check", check.Name)
+			continue
+		}
+		defer app.inFlight.Remove(key)
+		checks = append(checks, check)
+	}
+	if len(checks) == 0 {
+		return nil
+	}
+
+	startedAt := time.Now()
+	// Checks whose result on the same content is cached are reported without
+	// running them.
+	cacheKey := app.resultCacheKey(ctx, job)
+	completed := make(map[string]*Result)
+	var toRun []*JobCheck
+	for _, check := range checks {
+		if result := app.reuseCachedResult(ctx, job, check, cacheKey); result != nil {
+			completed[check.Name] = result
+		} else {
+			toRun = append(toRun, check)
+		}
+	}
+	var config *RepoConfig
+	var errs []error
+	var err error
+	if len(toRun) == 0 {
+		owner, repo := job.ownerAndRepo()
+		config, err = fetchRepoConfigWithClient(ctx, app.jobClient(job), owner, repo, job.HeadSHA)
+		if err != nil {
+			return err
+		}
+	} else {
+		var results []*Result
+		config, results, errs, err = app.runJobChecks(ctx, job, toRun, cacheKey)
+		if err != nil {
+			return err
+		}
+		for i, check := range toRun {
+			if results[i] != nil {
+				completed[check.Name] = results[i]
+			}
+		}
+	}
+
+	// The summary comment and the aggregate check run are built from the
+	// commit's check runs.
+	if job.PullNumber != 0 && config.SummaryCommentEnabled() && !job.usesStatuses() {
+		if err := app.updateSummaryComment(ctx, job); err != nil {
+			logFrom(ctx).Warnw("failed to update summary comment", "error", err)
+		}
+	}
+	if config.CodeScanningEnabled() {
+		if err := app.uploadCodeScanning(ctx, job, completed, startedAt); err != nil {
+			logFrom(ctx).Warnw("failed to upload to code scanning", "error", err)
+		}
+	}
+	if config.AggregateCheckEnabled() && !job.usesStatuses() {
+		owner, repo := job.ownerAndRepo()
+		if err := updateAggregateCheckRun(ctx, app.jobClient(job), job.AppID, owner, repo, job.HeadSHA); err != nil {
+			logFrom(ctx).Warnw("failed to update aggregate check run", "error", err)
+		}
+	}
+	if job.PullNumber == 0 && config.BrokenBranchIssueEnabled() && !job.usesStatuses() {
+		if err := app.updateBrokenBranchIssue(ctx, job, completed); err != nil {
+			logFrom(ctx).Warnw("failed to update broken branch issue", "error", err)
+		}
+	}
+	if job.PullNumber == 0 && config.EmailAuthorsEnabled() {
+		if err := app.emailAuthor(ctx, job, completed); err != nil {
+			logFrom(ctx).Warnw("failed to email author", "error", err)
+		}
+	}
+
+	var failed []string
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", toRun[i].Name, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to run checks: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// hasJobCheck reports whether checks include the check named name.
+func hasJobCheck(checks []*JobCheck, name string) bool {
+	for _, check := range checks {
+		if check.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// runJobChecks clones the repository once at the job's head SHA, runs checks
+// concurrently against that checkout, reports each result on its check run
+// and posts the suggestions of failed checks. It returns the repository's
+// configuration and the result or error of each check.
+func (app *GithubApp) runJobChecks(ctx context.Context, job *Job, checks []*JobCheck, cacheKey string) (*RepoConfig, []*Result, []error, error) {
+	var pullDiff map[string]*fileDiff
+	if job.PullNumber != 0 {
+		owner, repo := job.ownerAndRepo()
+		var err error
+		pullDiff, err = pullRequestDiff(ctx, app.jobClient(job), owner, repo, job.PullNumber)
+		if err != nil {
+			logFrom(ctx).Warnw("failed to get pull request diff", "error", err)
+		}
+	}
+	// History is only needed to diff against the base when the pull
+	// request's diff isn't known, to lint its commit messages and to read
+	// the secrets allowlist of the base.
+	ref := GitRef{
+		hash:    job.HeadSHA,
+		history: job.BaseSHA != "" && (pullDiff == nil || hasJobCheck(checks, commitMessageCheck) || hasJobCheck(checks, secretsCheck)),
+	}
+	var dir, outputBase string
+	if BazelWorkspaceDir != "" && usesBazel(checks) {
+		ws, err := acquireBazelWorkspace(job.FullRepoName)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		defer ws.release(ctx)
+		if err := ws.checkout(ctx, job.Token, job.FullRepoName, ref); err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to check out repo: %s", err)
+		}
+		ws.maybeExpunge(ctx)
+		dir, outputBase = ws.src(), ws.outputBase()
+	} else {
+		names := make([]string, 0, len(checks))
+		for _, check := range checks {
+			names = append(names, check.Name)
+		}
+		var err error
+		dir, err = allocWorkspace(ctx, job.FullRepoName, job.HeadSHA, strings.Join(names, "+"))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		defer releaseWorkspace(ctx, dir)
+		if _, err := cloneRepoWithToken(ctx, job.Token, job.FullRepoName, ref, dir); err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to clone repo: %s", err)
+		}
+	}
+
+	config, err := loadRepoConfigFromDir(dir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var branch string
+	if config.Bazel.hasBranches() {
+		if branch, err = jobBranch(ctx, app.jobClient(job), job); err != nil {
+			logFrom(ctx).Warnw("failed to find branch of job", "error", err)
+		}
+	}
+	var changed []string
+	if pullDiff != nil {
+		changed = diffPaths(pullDiff)
+	} else if job.BaseSHA != "" {
+		changed, err = changedFiles(dir, job.BaseSHA, job.HeadSHA)
+		if err != nil {
+			logFrom(ctx).Warnw("failed to list changed files", "error", err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	targets := make([]*CheckTarget, len(checks))
+	results := make([]*Result, len(checks))
+	errs := make([]error, len(checks))
+	ghc := app.jobClient(job)
+	for i, check := range checks {
+		targets[i] = &CheckTarget{
+			InstallationID: job.InstallationID,
+			FullRepoName:   job.FullRepoName,
+			HeadSHA:        job.HeadSHA,
+			BaseSHA:        job.BaseSHA,
+			Dir:            dir,
+			ChangedFiles:   changed,
+			Config:         config.Check(check.Name),
+			pullDiff:       pullDiff,
+			log:            &commandLog{},
+			cacheKey:       cacheKey,
+			outputBase:     outputBase,
+			bazel:          config.Bazel.settings(branch, check.Name),
+			ghc:            ghc,
+		}
+		wg.Add(1)
+		go func(i int, check *JobCheck) {
+			defer wg.Done()
+			results[i], errs[i] = app.runJobCheck(ctx, job, check, targets[i])
+		}(i, check)
+	}
+	wg.Wait()
+
+	// Fixers change the checkout, so they only run once all checks are done,
+	// one at a time.
+	for i, check := range checks {
+		if results[i] == nil || results[i].Conclusion != "failure" || !targets[i].Config.Suggestions || job.PullNumber == 0 {
+			continue
+		}
+		if err := app.postSuggestions(withLogFields(ctx, "check", check.Name), job, check.Name, targets[i]); err != nil {
+			logFrom(ctx).Warnw("failed to post suggestions", "check", check.Name, "error", err)
+		}
+	}
+	return config, results, errs, nil
+}
+
+// runJobCheck runs a single check of a job, reports its result and returns
+// it. A check that fails to run is reported as failed, so that its check run
+// doesn't stay in progress.
+func (app *GithubApp) runJobCheck(ctx context.Context, job *Job, check *JobCheck, target *CheckTarget) (*Result, error) {
+	ctx = withLogFields(ctx, "check", check.Name)
+	checker, err := GetChecker(check.Name)
+	if err != nil {
+		return nil, err
+	}
+	app.recordStart(ctx, job, check)
+	if check.CheckRunID != 0 {
+		target.progress = app.progressReporter(ctx, job, check)
+	}
+	stopLog := app.streamLog(ctx, job, check.Name, target.log)
+	result, err := app.runWithTimeout(ctx, checker, target)
+	if err != nil {
+		logFrom(ctx).Errorw("failed to run check", "error", err)
+		result = &Result{
+			Title:      fmt.Sprintf("%s failed to run", check.Name),
+			Summary:    redactSecrets(err.Error()),
+			Conclusion: "failure",
+		}
+	}
+	if target.pullDiff != nil && !target.Config.AnnotateAllLines {
+		filterToDiff(result, target.pullDiff, target.Config.CountOutOfDiff)
+	}
+	if result.Conclusion == "failure" {
+		result.Actions = append(result.Actions, target.Config.buildozerActions()...)
+	}
+	result.Log = target.log.String()
+	saved := app.saveResult(ctx, job, check.Name, result)
+	app.cacheResult(ctx, job, check.Name, target, result)
+	// The results page shows the result once the log is complete.
+	stopLog()
+	if saved && result.URL == "" {
+		// Link the results page, since nothing else shows the full log.
+		result.URL = resultsURL(job.FullRepoName, job.HeadSHA, check.Name)
+	}
+
+	updateRun, err := app.reportResult(ctx, job, check, result)
+	if err != nil {
+		return nil, err
+	}
+	logFrom(ctx).Infow("check run completed", "check_run_id", updateRun.GetID(), "conclusion", result.Conclusion)
+	app.recordResult(ctx, check, result)
+	app.notify(ctx, job, check.Name, result)
+	return result, nil
+}
@@ -0,0 +1,217 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/google/go-github/v43/github"
+)
+
+// recordingDispatcher records the jobs dispatched to it instead of running
+// them.
+type recordingDispatcher struct {
+	mu   sync.Mutex
+	jobs []*Job
+}
+
+func (d *recordingDispatcher) Dispatch(_ context.Context, job *Job) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.jobs = append(d.jobs, job)
+	return nil
+}
+
+// serveCheckRunCreation lets f list no check runs for the head SHA sha and
+// create check runs with increasing IDs, starting at 1. It returns the options
+// of the check runs created so far.
+func serveCheckRunCreation(t *testing.T, f *fakeGitHub, sha string) func() []*github.CreateCheckRunOptions {
+	f.handle("GET /repos/o/r/commits/"+sha+"/check-runs", func(w http.ResponseWriter, req *http.Request) {
+		writeTestJSON(w, http.StatusOK, map[string]interface{}{"total_count": 0, "check_runs": []interface{}{}})
+	})
+	var mu sync.Mutex
+	var created []*github.CreateCheckRunOptions
+	f.handle("POST /repos/o/r/check-runs", func(w http.ResponseWriter, req *http.Request) {
+		opts := &github.CreateCheckRunOptions{}
+		if err := json.NewDecoder(req.Body).Decode(opts); err != nil {
+			t.Errorf("failed to decode check run: %s", err)
+		}
+		mu.Lock()
+		created = append(created, opts)
+		id := len(created)
+		mu.Unlock()
+		writeTestJSON(w, http.StatusCreated, map[string]interface{}{"id": id, "name": opts.Name})
+	})
+	return func() []*github.CreateCheckRunOptions {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]*github.CreateCheckRunOptions(nil), created...)
+	}
+}
+
+// completedCheckRuns records the check runs that f is asked to complete, by
+// ID.
+func completedCheckRuns(t *testing.T, f *fakeGitHub, id string) <-chan *github.UpdateCheckRunOptions {
+	completed := make(chan *github.UpdateCheckRunOptions, 1)
+	f.handle("PATCH /repos/o/r/check-runs/"+id, func(w http.ResponseWriter, req *http.Request) {
+		var opts github.UpdateCheckRunOptions
+		if err := json.NewDecoder(req.Body).Decode(&opts); err != nil {
+			t.Errorf("failed to decode check run update: %s", err)
+		}
+		if opts.GetStatus() == "completed" {
+			select {
+			case completed <- &opts:
+			default:
+			}
+		}
+		writeTestJSON(w, http.StatusOK, map[string]interface{}{"id": 7})
+	})
+	return completed
+}
+
+// runTestJobCheck runs the check named name on dir through runJobCheck, as
+// check run 7 of o/r, and returns how the check run was completed.
+func runTestJobCheck(t *testing.T, name string, config *CheckConfig, dir string) *github.UpdateCheckRunOptions {
+	f := newFakeGitHub(t)
+	completed := completedCheckRuns(t, f, "7")
+	app := newTestApp(t, f)
+	job := &Job{AppID: testAppID, InstallationID: testInstallationID, FullRepoName: "o/r", HeadSHA: "abc", Token: "job-token"}
+	check := &JobCheck{Name: name, CheckRunID: 7}
+	target := &CheckTarget{
+		InstallationID: testInstallationID,
+		FullRepoName:   "o/r",
+		HeadSHA:        "abc",
+		Dir:            dir,
+		Config:         config,
+		log:            &commandLog{},
+	}
+	if _, err := app.runJobCheck(context.Background(), job, check, target); err != nil {
+		t.Fatalf("runJobCheck: %s", err)
+	}
+	select {
+	case opts := <-completed:
+		return opts
+	default:
+		t.Fatalf("check run of %s wasn't completed", name)
+		return nil
+	}
+}
+
+func TestCreateCheckRunsDispatchesOneJobPerSHA(t *testing.T) {
+	f := newFakeGitHub(t)
+	created := serveCheckRunCreation(t, f, "abc")
+	app := newTestApp(t, f)
+	d := &recordingDispatcher{}
+	app.SetDispatcher(d)
+
+	if err := app.CreateCheckRuns(context.Background(), testInstallationID, testRepo(), "abc"); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.jobs) != 1 {
+		t.Fatalf("dispatched %d jobs, want one for all checks", len(d.jobs))
+	}
+	for _, opts := range created() {
+		if opts.GetStatus() != inProgress {
+			t.Errorf("check run %s was created %q, want %q", opts.Name, opts.GetStatus(), inProgress)
+		}
+	}
+	job := d.jobs[0]
+	want := 0
+	for _, checkName := range registeredChecks() {
+		checker, err := GetChecker(checkName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if enabledByDefault(checker) {
+			want++
+		}
+	}
+	if len(job.Checks) != want {
+		t.Errorf("job has %d checks, want all %d enabled by default", len(job.Checks), want)
+	}
+	ids := make(map[int64]bool)
+	for _, check := range job.Checks {
+		ids[check.CheckRunID] = true
+	}
+	if len(ids) != len(job.Checks) {
+		t.Errorf("job checks %+v don't each have their own check run", job.Checks)
+	}
+	if job.HeadSHA != "abc" || job.Token != "test-token" {
+		t.Errorf("job is for %s with token %q, want abc with the installation token", job.HeadSHA, job.Token)
+	}
+}
+
+func TestJobBranch(t *testing.T) {
+	f := newFakeGitHub(t)
+	branches := []map[string]interface{}{}
+	f.handle("GET /repos/o/r/commits/abc/branches-where-head", func(w http.ResponseWriter, req *http.Request) {
+		writeTestJSON(w, http.StatusOK, branches)
+	})
+	ghc := newTestApp(t, f).jobClient(&Job{InstallationID: testInstallationID, Token: "job-token"})
+
+	branch, err := jobBranch(context.Background(), ghc, &Job{FullRepoName: "o/r", HeadSHA: "abc", PullNumber: 5, BaseBranch: "release/1.0"})
+	if err != nil || branch != "release/1.0" {
+		t.Errorf("branch of a pull request = %q, %v, want its base branch", branch, err)
+	}
+	if n := f.count("GET /repos/o/r/commits/abc/branches-where-head"); n != 0 {
+		t.Errorf("listed branches %d times for a pull request", n)
+	}
+
+	branch, err = jobBranch(context.Background(), ghc, &Job{FullRepoName: "o/r", HeadSHA: "abc"})
+	if err != nil || branch != "" {
+		t.Errorf("branch of a commit that isn't a branch head = %q, %v, want none", branch, err)
+	}
+
+	branches = []map[string]interface{}{{"name": "main"}}
+	branch, err = jobBranch(context.Background(), ghc, &Job{FullRepoName: "o/r", HeadSHA: "abc"})
+	if err != nil || branch != "main" {
+		t.Errorf("branch of a branch head = %q, %v, want main", branch, err)
+	}
+}
+
+func TestCreateCheckRunsFansOutMatrix(t *testing.T) {
+	f := newFakeGitHub(t)
+	serveRepoConfig(f, "bazel:\n  matrix:\n    - name: linux\n    - name: asan\n      configs: [asan]\n")
+	created := serveCheckRunCreation(t, f, "abc")
+	app := newTestApp(t, f)
+	d := &recordingDispatcher{}
+	app.SetDispatcher(d)
+
+	if err := app.createCheckRuns(context.Background(), testInstallationID, testRepo(), "abc", []string{buildifierCheck, bazelTestCheck}); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, opts := range created() {
+		names = append(names, opts.Name)
+	}
+	want := []string{buildifierCheck, matrixCheckName(bazelTestCheck, "linux"), matrixCheckName(bazelTestCheck, "asan")}
+	sort.Strings(names)
+	sort.Strings(want)
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("created check runs %q, want %q", names, want)
+	}
+	if len(d.jobs) != 1 || len(d.jobs[0].Checks) != 3 {
+		t.Errorf("dispatched %+v, want one job with a check per cell", d.jobs)
+	}
+}
+
+func TestRunJobCheckCompletesChecksThatFailToRun(t *testing.T) {
+	registerTestChecker(t, &funcChecker{
+		name: "broken-test-check",
+		fn: func(ctx context.Context, _ *GithubApp, _ *CheckTarget) (*Result, error) {
+			return nil, errors.New("tool crashed")
+		},
+	})
+	opts := runTestJobCheck(t, "broken-test-check", &CheckConfig{}, t.TempDir())
+	if opts.GetConclusion() != "failure" {
+		t.Errorf("check run completed as %q, want failure", opts.GetConclusion())
+	}
+	if summary := opts.GetOutput().GetSummary(); summary != "tool crashed" {
+		t.Errorf("check run summary is %q, want the error", summary)
+	}
+}
@@ -0,0 +1,146 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const largeFilesCheck = "large-files"
+
+// defaultMaxFileSize is the size of the largest file allowed by default.
+const defaultMaxFileSize = 5 << 20
+
+// defaultForbiddenExtensions are the binary artifacts that aren't allowed
+// outside of LFS by default.
+var defaultForbiddenExtensions = []string{
+	".7z", ".a", ".class", ".dll", ".dmg", ".exe", ".iso", ".jar", ".o",
+	".pyc", ".so", ".tar", ".tgz", ".war", ".whl", ".zip",
+}
+
+func init() {
+	RegisterChecker(&funcChecker{
+		name:         largeFilesCheck,
+		fn:           checkLargeFiles,
+		optIn:        true,
+		requirements: CheckRequirements{Binaries: []string{"git"}},
+		rule:         CheckRule{Description: "Large files and binary artifacts aren't committed outside of LFS.", HelpURI: "https://git-lfs.com/"},
+	})
+}
+
+// formatSize formats a size in bytes for people.
+func formatSize(size int64) string {
+	switch {
+	case size >= 1<<30:
+		return fmt.Sprintf("%.1f GiB", float64(size)/(1<<30))
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1f KiB", float64(size)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", size)
+}
+
+// lfsTrackedFiles returns which of files are stored in Git LFS according to
+// the repository's .gitattributes.
+func lfsTrackedFiles(dir string, files []string) (map[string]bool, error) {
+	tracked := make(map[string]bool)
+	if len(files) == 0 {
+		return tracked, nil
+	}
+	out, err := gitOutput(dir, append([]string{"check-attr", "filter", "--"}, files...)...)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(out, "\n") {
+		// Lines look like "path: filter: lfs".
+		if p := strings.TrimSuffix(line, ": filter: lfs"); p != line {
+			tracked[p] = true
+		}
+	}
+	return tracked, nil
+}
+
+// checkLargeFiles fails when the pull request adds files over the configured
+// size or binary artifacts outside of Git LFS.
+func checkLargeFiles(ctx context.Context, _ *GithubApp, target *CheckTarget) (*Result, error) {
+	config := target.Config
+	maxSize := int64(defaultMaxFileSize)
+	if config.MaxFileSize > 0 {
+		maxSize = config.MaxFileSize
+	}
+	forbidden := defaultForbiddenExtensions
+	if len(config.ForbiddenExtensions) > 0 {
+		forbidden = config.ForbiddenExtensions
+	}
+
+	files := target.ChangedFiles
+	if files == nil {
+		var err error
+		if files, err = target.findFiles("**"); err != nil {
+			return nil, fmt.Errorf("failed to list files: %s", err)
+		}
+	}
+	lfs, err := lfsTrackedFiles(target.Dir, files)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read LFS attributes: %s", err)
+	}
+
+	annotations := []*Annotation{}
+	var b strings.Builder
+	b.WriteString("| File | Size | Problem |\n")
+	b.WriteString("|---|---|---|\n")
+	for _, f := range files {
+		if lfs[f] || !config.MatchesPath(f) {
+			continue
+		}
+		info, err := os.Lstat(filepath.Join(target.Dir, filepath.FromSlash(f)))
+		if os.IsNotExist(err) {
+			// Deleted by the pull request.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		var problem string
+		if info.Size() > maxSize {
+			problem = fmt.Sprintf("over the %s limit", formatSize(maxSize))
+		}
+		for _, ext := range forbidden {
+			if strings.EqualFold(path.Ext(f), ext) {
+				problem = fmt.Sprintf("%s files are binary artifacts", ext)
+				break
+			}
+		}
+		if problem == "" {
+			continue
+		}
+		fmt.Fprintf(&b, "| %s | %s | %s |\n", f, formatSize(info.Size()), problem)
+		annotations = append(annotations, &Annotation{
+			Message:   fmt.Sprintf("%s (%s) is %s. Track it with Git LFS or keep it out of the repository.", f, formatSize(info.Size()), problem),
+			Severity:  "failure",
+			Path:      f,
+			Line:      1,
+			WholeFile: true,
+		})
+	}
+
+	res := &Result{
+		Title: "Large file result",
+	}
+	if len(annotations) == 0 {
+		res.Summary = "No large files or binary artifacts found."
+		res.Conclusion = "success"
+		return res, nil
+	}
+	res.Summary = fmt.Sprintf("%d files should be stored in Git LFS, e.g. with `git lfs track`, or not committed.\n\n%s", len(annotations), b.String())
+	res.Conclusion = "failure"
+	res.Annotations = annotations
+	return res, nil
+}
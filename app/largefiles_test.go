@@ -0,0 +1,88 @@
+package app
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestFormatSize(t *testing.T) {
+	for size, want := range map[int64]string{
+		512:           "512 B",
+		1536:          "1.5 KiB",
+		5 << 20:       "5.0 MiB",
+		3<<30 + 1<<29: "3.5 GiB",
+	} {
+		if got := formatSize(size); got != want {
+			t.Errorf("formatSize(%d) = %q, want %q", size, got, want)
+		}
+	}
+}
+
+func TestCheckLargeFiles(t *testing.T) {
+	dir := t.TempDir()
+	testGit(t, dir, "init", "-q")
+	writeTestFile(t, dir, ".gitattributes", "*.bin filter=lfs diff=lfs merge=lfs -text\n")
+	writeTestFile(t, dir, "data/big.csv", strings.Repeat("x", 2048))
+	writeTestFile(t, dir, "model.bin", strings.Repeat("x", 2048))
+	writeTestFile(t, dir, "lib/tool.JAR", "PK")
+	writeTestFile(t, dir, "main.go", "package main\n")
+
+	target := &CheckTarget{
+		Dir:          dir,
+		ChangedFiles: []string{"data/big.csv", "model.bin", "lib/tool.JAR", "main.go", "deleted.zip"},
+		Config:       &CheckConfig{MaxFileSize: 1024},
+	}
+	res, err := checkLargeFiles(context.Background(), nil, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Conclusion != "failure" {
+		t.Errorf("got %s, want failure", res.Conclusion)
+	}
+	want := []string{
+		"data/big.csv (2.0 KiB) is over the 1.0 KiB limit. Track it with Git LFS or keep it out of the repository.",
+		"lib/tool.JAR (2 B) is .jar files are binary artifacts. Track it with Git LFS or keep it out of the repository.",
+	}
+	if len(res.Annotations) != len(want) {
+		t.Fatalf("got annotations %v, want %d", res.Annotations, len(want))
+	}
+	for i, a := range res.Annotations {
+		if a.Message != want[i] || a.Line != 1 || a.Severity != "failure" {
+			t.Errorf("annotation %d: got %+v, want %q", i, *a, want[i])
+		}
+	}
+	if row := "| data/big.csv | 2.0 KiB | over the 1.0 KiB limit |"; !strings.Contains(res.Summary, row) {
+		t.Errorf("summary %q doesn't list %q", res.Summary, row)
+	}
+}
+
+func TestCheckLargeFilesWithForbiddenExtensions(t *testing.T) {
+	dir := t.TempDir()
+	testGit(t, dir, "init", "-q")
+	writeTestFile(t, dir, "app.jar", "PK")
+	writeTestFile(t, dir, "font.ttf", "ttf")
+
+	target := &CheckTarget{Dir: dir, Config: &CheckConfig{ForbiddenExtensions: []string{".ttf"}}}
+	res, err := checkLargeFiles(context.Background(), nil, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Annotations) != 1 || res.Annotations[0].Path != "font.ttf" {
+		t.Errorf("got annotations %v, want only the configured extension", res.Annotations)
+	}
+}
+
+func TestCheckLargeFilesWithoutLargeFiles(t *testing.T) {
+	dir := t.TempDir()
+	testGit(t, dir, "init", "-q")
+	writeTestFile(t, dir, "main.go", "package main\n")
+
+	res, err := checkLargeFiles(context.Background(), nil, &CheckTarget{Dir: dir, ChangedFiles: []string{"main.go"}, Config: &CheckConfig{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Conclusion != "success" || len(res.Annotations) != 0 {
+		t.Errorf("got %s with annotations %v, want success", res.Conclusion, res.Annotations)
+	}
+}
@@ -0,0 +1,93 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// FetchLFS controls whether Git LFS objects are fetched after cloning a
+// repository that tracks files with LFS. Without them, checks operate on LFS
+// pointer files instead of the real content.
+var FetchLFS = true
+
+// errFoundLFS stops the .gitattributes walk as soon as LFS usage is found.
+var errFoundLFS = errors.New("found LFS filter")
+
+// usesLFS reports whether any .gitattributes file in dir routes paths through
+// the LFS filter.
+func usesLFS(dir string) (bool, error) {
+	found := false
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if d.IsDir() || d.Name() != ".gitattributes" {
+			return nil
+		}
+		lfs, err := hasLFSFilter(path)
+		if err != nil {
+			return err
+		}
+		if lfs {
+			found = true
+			return errFoundLFS
+		}
+		return nil
+	})
+	if err == errFoundLFS {
+		err = nil
+	}
+	return found, err
+}
+
+func hasLFSFilter(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, attr := range strings.Fields(line) {
+			if attr == "filter=lfs" {
+				return true, nil
+			}
+		}
+	}
+	return false, scanner.Err()
+}
+
+// fetchLFSObjects replaces LFS pointer files in the checkout at dir with their
+// content, if the repository uses LFS. They're fetched from origin with auth.
+func fetchLFSObjects(ctx context.Context, dir string, auth transport.AuthMethod) error {
+	if !FetchLFS {
+		return nil
+	}
+	lfs, err := usesLFS(dir)
+	if err != nil {
+		return fmt.Errorf("failed to detect LFS usage in %q: %s", dir, err)
+	}
+	if !lfs {
+		return nil
+	}
+	logFrom(ctx).Infow("fetching LFS objects", "dir", dir)
+	if err := runGitWithEnv(dir, gitAuthEnv(auth), "lfs", "pull"); err != nil {
+		return fmt.Errorf("failed to fetch LFS objects: %s", err)
+	}
+	return nil
+}
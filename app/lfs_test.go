@@ -0,0 +1,40 @@
+package app
+
+import (
+	"context"
+	"testing"
+)
+
+func TestUsesLFS(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		files map[string]string
+		want  bool
+	}{
+		{"no attributes", map[string]string{"main.go": "package main\n"}, false},
+		{"root attributes", map[string]string{".gitattributes": "*.bin filter=lfs diff=lfs merge=lfs -text\n"}, true},
+		{"nested attributes", map[string]string{"assets/.gitattributes": "*.png filter=lfs diff=lfs merge=lfs -text\n"}, true},
+		{"other attributes", map[string]string{".gitattributes": "*.sh text eol=lf\n"}, false},
+		{"commented out", map[string]string{".gitattributes": "# *.bin filter=lfs\n"}, false},
+		{"git directory", map[string]string{".git/info/.gitattributes": "*.bin filter=lfs\n"}, false},
+	} {
+		dir := t.TempDir()
+		for path, content := range tc.files {
+			writeTestFile(t, dir, path, content)
+		}
+		got, err := usesLFS(dir)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+		if got != tc.want {
+			t.Errorf("%s: usesLFS = %t, want %t", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestFetchLFSObjectsSkipsRepositoriesWithoutLFS(t *testing.T) {
+	// Fetching would fail, as the directory isn't a repository.
+	if err := fetchLFSObjects(context.Background(), t.TempDir(), nil); err != nil {
+		t.Errorf("fetchLFSObjects: %s", err)
+	}
+}
@@ -0,0 +1,199 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const licenseHeaderCheck = "license-header"
+
+// licenseHeaderSearchLines is how far into a file the license header may
+// start, to allow for shebangs, build constraints and the like.
+const licenseHeaderSearchLines = 10
+
+// licenseCommentPrefixes are the line comment markers of the languages whose
+// files need a license header, by extension or file name.
+var licenseCommentPrefixes = map[string]string{
+	".go": "//", ".c": "//", ".cc": "//", ".cpp": "//", ".h": "//", ".hpp": "//",
+	".java": "//", ".kt": "//", ".scala": "//", ".js": "//", ".jsx": "//",
+	".ts": "//", ".tsx": "//", ".proto": "//", ".rs": "//", ".swift": "//",
+	".py": "#", ".sh": "#", ".bzl": "#", ".bazel": "#", ".rb": "#",
+	".yaml": "#", ".yml": "#", "BUILD": "#", "WORKSPACE": "#",
+}
+
+func init() {
+	RegisterChecker(&funcChecker{
+		name:       licenseHeaderCheck,
+		fn:         checkLicenseHeader,
+		fixFn:      fixLicenseHeader,
+		fixMessage: "Add license headers",
+		optIn:      true,
+		rule:       CheckRule{Description: "Source files start with the license header."},
+	})
+}
+
+// licenseCommentPrefix returns the comment marker of the file at p, or "" if
+// it doesn't need a license header.
+func licenseCommentPrefix(p string) string {
+	if prefix, ok := licenseCommentPrefixes[path.Base(p)]; ok {
+		return prefix
+	}
+	return licenseCommentPrefixes[path.Ext(p)]
+}
+
+// licenseHeaderLines renders header as comment lines with prefix.
+func licenseHeaderLines(header string, prefix string) []string {
+	var lines []string
+	for _, l := range strings.Split(strings.TrimRight(header, "\n"), "\n") {
+		lines = append(lines, strings.TrimRight(prefix+" "+l, " "))
+	}
+	return lines
+}
+
+// licenseHeaderRegex matches header commented with prefix.
+func licenseHeaderRegex(header string, prefix string) (*regexp.Regexp, error) {
+	var parts []string
+	for _, l := range licenseHeaderLines(header, prefix) {
+		parts = append(parts, `[ \t]*`+strings.ReplaceAll(regexp.QuoteMeta(l), regexp.QuoteMeta("{{year}}"), `\d{4}(?:\s*-\s*\d{4})?`)+`[ \t]*`)
+	}
+	return regexp.Compile("(?m)^" + strings.Join(parts, `\r?\n`) + "$")
+}
+
+// licenseHeaderFiles returns the files that need a license header: the ones
+// changed by the pull request, or all of them if they aren't known.
+func licenseHeaderFiles(target *CheckTarget) ([]string, error) {
+	files := target.ChangedFiles
+	if files == nil {
+		var err error
+		if files, err = target.findFiles("**"); err != nil {
+			return nil, fmt.Errorf("failed to list files: %s", err)
+		}
+	}
+	var matched []string
+	for _, f := range files {
+		if licenseCommentPrefix(f) == "" || !target.Config.MatchesPath(f) {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(target.Dir, filepath.FromSlash(f))); os.IsNotExist(err) {
+			// Deleted by the pull request.
+			continue
+		}
+		matched = append(matched, f)
+	}
+	return matched, nil
+}
+
+// missingLicenseHeader reports whether the file at p, relative to dir, lacks
+// header near its top.
+func missingLicenseHeader(dir string, p string, header string) (bool, error) {
+	b, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(p)))
+	if err != nil {
+		return false, err
+	}
+	re, err := licenseHeaderRegex(header, licenseCommentPrefix(p))
+	if err != nil {
+		return false, err
+	}
+	lines := strings.Split(string(b), "\n")
+	if n := licenseHeaderSearchLines + strings.Count(header, "\n") + 1; len(lines) > n {
+		lines = lines[:n]
+	}
+	return !re.MatchString(strings.Join(lines, "\n")), nil
+}
+
+// checkLicenseHeader annotates source files missing the license header
+// configured with `header` in .reviewbot.yaml.
+func checkLicenseHeader(ctx context.Context, _ *GithubApp, target *CheckTarget) (*Result, error) {
+	res := &Result{
+		Title: "License header result",
+	}
+	header := target.Config.Header
+	if strings.TrimSpace(header) == "" {
+		res.Summary = fmt.Sprintf("No license header is configured in %s.", repoConfigFile)
+		res.Conclusion = "neutral"
+		return res, nil
+	}
+	files, err := licenseHeaderFiles(target)
+	if err != nil {
+		return nil, err
+	}
+	annotations := []*Annotation{}
+	for _, f := range files {
+		missing, err := missingLicenseHeader(target.Dir, f, header)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check %s: %s", f, err)
+		}
+		if missing {
+			annotations = append(annotations, &Annotation{
+				Message:   fmt.Sprintf("file %q is missing the license header", f),
+				Severity:  "failure",
+				Path:      f,
+				Line:      1,
+				WholeFile: true,
+			})
+		}
+	}
+	if len(annotations) == 0 {
+		res.Summary = "No issues found."
+		res.Conclusion = "success"
+		return res, nil
+	}
+	res.Summary = fmt.Sprintf("%d files are missing the license header", len(annotations))
+	res.Conclusion = "failure"
+	res.Annotations = annotations
+	res.Action = newFixAction(licenseHeaderCheck, "Add the license header to these files.")
+	return res, nil
+}
+
+// fixLicenseHeader adds the license header, with the current year, to the
+// top of files missing it, after any shebang line.
+func fixLicenseHeader(ctx context.Context, _ *GithubApp, target *CheckTarget) error {
+	header := target.Config.Header
+	if strings.TrimSpace(header) == "" {
+		return fmt.Errorf("no license header is configured in %s", repoConfigFile)
+	}
+	header = strings.ReplaceAll(header, "{{year}}", strconv.Itoa(time.Now().Year()))
+	files, err := licenseHeaderFiles(target)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		missing, err := missingLicenseHeader(target.Dir, f, target.Config.Header)
+		if err != nil {
+			return err
+		}
+		if !missing {
+			continue
+		}
+		full := filepath.Join(target.Dir, filepath.FromSlash(f))
+		b, err := os.ReadFile(full)
+		if err != nil {
+			return err
+		}
+		content := string(b)
+		shebang := ""
+		if strings.HasPrefix(content, "#!") {
+			if i := strings.IndexByte(content, '\n'); i >= 0 {
+				shebang, content = content[:i+1], content[i+1:]
+			} else {
+				shebang, content = content+"\n", ""
+			}
+		}
+		text := strings.Join(licenseHeaderLines(header, licenseCommentPrefix(f)), "\n") + "\n\n"
+		info, err := os.Stat(full)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(full, []byte(shebang+text+content), info.Mode()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testLicenseHeader = "Copyright {{year}} Example Inc.\nSPDX-License-Identifier: Apache-2.0\n"
+
+func TestMissingLicenseHeader(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "a.go", "// Copyright 2019 Example Inc.\n// SPDX-License-Identifier: Apache-2.0\n\npackage a\n")
+	writeTestFile(t, dir, "range.go", "//go:build linux\n\n// Copyright 2019 - 2022 Example Inc.\n// SPDX-License-Identifier: Apache-2.0\n\npackage a\n")
+	writeTestFile(t, dir, "run.sh", "#!/bin/sh\n# Copyright 2021 Example Inc.\n# SPDX-License-Identifier: Apache-2.0\necho hi\n")
+	writeTestFile(t, dir, "other.go", "// Copyright 2021 Other Corp.\n\npackage a\n")
+	writeTestFile(t, dir, "wrong_comment.py", "// Copyright 2021 Example Inc.\n// SPDX-License-Identifier: Apache-2.0\n")
+
+	for p, want := range map[string]bool{
+		"a.go":             false,
+		"range.go":         false,
+		"run.sh":           false,
+		"other.go":         true,
+		"wrong_comment.py": true,
+	} {
+		missing, err := missingLicenseHeader(dir, p, testLicenseHeader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if missing != want {
+			t.Errorf("%s: got missing %t, want %t", p, missing, want)
+		}
+	}
+}
+
+func TestCheckLicenseHeader(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "a.go", "// Copyright 2019 Example Inc.\n// SPDX-License-Identifier: Apache-2.0\n\npackage a\n")
+	writeTestFile(t, dir, "b.go", "package b\n")
+	writeTestFile(t, dir, "BUILD", "go_library(name = \"a\")\n")
+	writeTestFile(t, dir, "README.md", "# Readme\n")
+
+	target := &CheckTarget{
+		Dir:          dir,
+		ChangedFiles: []string{"a.go", "b.go", "README.md", "deleted.go"},
+		Config:       &CheckConfig{Header: testLicenseHeader},
+	}
+	res, err := checkLicenseHeader(context.Background(), nil, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Conclusion != "failure" || len(res.Annotations) != 1 || res.Annotations[0].Path != "b.go" || res.Action == nil {
+		t.Errorf("got %s with annotations %v, want a fixable failure for b.go", res.Conclusion, res.Annotations)
+	}
+
+	// Without changed files, e.g. for pushes, every file is checked.
+	target.ChangedFiles = nil
+	res, err = checkLicenseHeader(context.Background(), nil, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Annotations) != 2 || res.Summary != "2 files are missing the license header" {
+		t.Errorf("got %q with annotations %v, want b.go and BUILD", res.Summary, res.Annotations)
+	}
+}
+
+func TestCheckLicenseHeaderWithoutHeader(t *testing.T) {
+	res, err := checkLicenseHeader(context.Background(), nil, &CheckTarget{Dir: t.TempDir(), Config: &CheckConfig{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Conclusion != "neutral" || len(res.Annotations) != 0 {
+		t.Errorf("got %s with annotations %v, want neutral without a configured header", res.Conclusion, res.Annotations)
+	}
+}
+
+func TestFixLicenseHeader(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "a.go", "package a\n")
+	writeTestFile(t, dir, "run.sh", "#!/bin/sh\necho hi\n")
+	if err := os.Chmod(filepath.Join(dir, "run.sh"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, dir, "b.go", "// Copyright 2019 Example Inc.\n// SPDX-License-Identifier: Apache-2.0\n\npackage b\n")
+
+	target := &CheckTarget{Dir: dir, Config: &CheckConfig{Header: testLicenseHeader}}
+	if err := fixLicenseHeader(context.Background(), nil, target); err != nil {
+		t.Fatal(err)
+	}
+	year := time.Now().Year()
+	for p, want := range map[string]string{
+		"a.go":   fmt.Sprintf("// Copyright %d Example Inc.\n// SPDX-License-Identifier: Apache-2.0\n\npackage a\n", year),
+		"run.sh": fmt.Sprintf("#!/bin/sh\n# Copyright %d Example Inc.\n# SPDX-License-Identifier: Apache-2.0\n\necho hi\n", year),
+		"b.go":   "// Copyright 2019 Example Inc.\n// SPDX-License-Identifier: Apache-2.0\n\npackage b\n",
+	} {
+		b, err := os.ReadFile(filepath.Join(dir, p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != want {
+			t.Errorf("%s: got %q, want %q", p, b, want)
+		}
+	}
+	info, err := os.Stat(filepath.Join(dir, "run.sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0755 {
+		t.Errorf("got mode %s, want the script to stay executable", info.Mode())
+	}
+}
@@ -0,0 +1,269 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CgroupRoot is a cgroup v2 directory the bot may create cgroups in, e.g. one
+// delegated to its user by systemd, with the cpu and memory controllers
+// enabled in its cgroup.subtree_control. The commands of each check run on the
+// host are placed in a cgroup of their own under it to enforce the check's
+// CPU and memory limits. Empty leaves them unenforced on the host.
+var CgroupRoot = ""
+
+// cpuPeriod is the period, in microseconds, of the CPU quota of cgroups.
+const cpuPeriod = 100000
+
+// ResourceLimits constrain the resources the commands of a check may use, so
+// that a runaway build can't exhaust the host. Zero values are unlimited.
+type ResourceLimits struct {
+	// CPUs is how many CPUs the commands may use, e.g. 2 or 0.5. Needs
+	// CgroupRoot on the host.
+	CPUs float64 `yaml:"cpus"`
+	// Memory is how much memory the commands may use together, in bytes or
+	// with a k, m or g suffix, e.g. "8g". Commands are killed when they
+	// exceed it. Needs CgroupRoot on the host.
+	Memory string `yaml:"memory"`
+	// Nice is the niceness from 1 to 19 the commands run with on the host.
+	Nice int `yaml:"nice"`
+	// Jobs is passed to bazel as --jobs.
+	Jobs int `yaml:"jobs"`
+}
+
+// Validate reports whether the limits can be applied.
+func (l *ResourceLimits) Validate() error {
+	if l.CPUs < 0 {
+		return fmt.Errorf("invalid CPU limit %v", l.CPUs)
+	}
+	if _, err := parseMemory(l.Memory); err != nil {
+		return err
+	}
+	if l.Nice < 0 || l.Nice > 19 {
+		return fmt.Errorf("invalid niceness %d: must be between 0 and 19", l.Nice)
+	}
+	if l.Jobs < 0 {
+		return fmt.Errorf("invalid number of jobs %d", l.Jobs)
+	}
+	return nil
+}
+
+// parseMemory returns the number of bytes of a memory limit like "512m", or 0
+// for "".
+func parseMemory(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	n := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(s)), "b")
+	unit := int64(1)
+	if i := strings.IndexAny(n, "kmgt"); i >= 0 && i == len(n)-1 {
+		unit = 1 << (10 * (strings.IndexByte("kmgt", n[i]) + 1))
+		n = n[:i]
+	}
+	v, err := strconv.ParseInt(n, 10, 64)
+	if err != nil || v <= 0 {
+		return 0, fmt.Errorf("invalid memory limit %q", s)
+	}
+	return v * unit, nil
+}
+
+// resourceLimiter applies the limits of a check to its commands, and collects
+// the violations of the limits for the check's summary.
+type resourceLimiter struct {
+	limits ResourceLimits
+	// memory is limits.Memory in bytes.
+	memory int64
+
+	mu sync.Mutex
+	// cgroup is the cgroup the check's commands run in on the host, or ""
+	// until the first of them runs.
+	cgroup     string
+	violations []string
+}
+
+// newResourceLimiter returns a limiter applying DefaultLimits tightened by
+// limits, which may be nil, or nil if nothing is limited.
+func newResourceLimiter(limits *ResourceLimits) (*resourceLimiter, error) {
+	l := CurrentSettings().DefaultLimits
+	if limits != nil {
+		if err := limits.Validate(); err != nil {
+			return nil, err
+		}
+		if limits.CPUs > 0 && (l.CPUs == 0 || limits.CPUs < l.CPUs) {
+			l.CPUs = limits.CPUs
+		}
+		if limits.Jobs > 0 && (l.Jobs == 0 || limits.Jobs < l.Jobs) {
+			l.Jobs = limits.Jobs
+		}
+		if limits.Nice > l.Nice {
+			l.Nice = limits.Nice
+		}
+		defaultMemory, err := parseMemory(l.Memory)
+		if err != nil {
+			return nil, err
+		}
+		memory, _ := parseMemory(limits.Memory)
+		if memory > 0 && (defaultMemory == 0 || memory < defaultMemory) {
+			l.Memory = limits.Memory
+		}
+	}
+	if l == (ResourceLimits{}) {
+		return nil, nil
+	}
+	memory, err := parseMemory(l.Memory)
+	if err != nil {
+		return nil, err
+	}
+	return &resourceLimiter{limits: l, memory: memory}, nil
+}
+
+type limitsKey struct{}
+
+// withLimits returns a context whose check commands, run with CheckExecutor,
+// are constrained by l.
+func withLimits(ctx context.Context, l *resourceLimiter) context.Context {
+	return context.WithValue(ctx, limitsKey{}, l)
+}
+
+func limiterFrom(ctx context.Context) *resourceLimiter {
+	l, _ := ctx.Value(limitsKey{}).(*resourceLimiter)
+	return l
+}
+
+// violated records a violation of the limits, once.
+func (l *resourceLimiter) violated(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	v := fmt.Sprintf(format, args...)
+	for _, existing := range l.violations {
+		if existing == v {
+			return
+		}
+	}
+	l.violations = append(l.violations, v)
+}
+
+// localCommand returns the command line that runs toolName with arg on the
+// host within the limits.
+func (l *resourceLimiter) localCommand(toolName string, arg []string) (string, []string, error) {
+	if CgroupRoot != "" && (l.limits.CPUs > 0 || l.memory > 0) {
+		cgroup, err := l.ensureCgroup()
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to create cgroup: %s", err)
+		}
+		// Move the shell into the cgroup before it execs the tool, so that
+		// everything the tool starts is limited too.
+		arg = append([]string{"-c", `echo $$ > "$0/cgroup.procs" && exec "$@"`, cgroup, toolName}, arg...)
+		toolName = "sh"
+	}
+	if l.limits.Nice > 0 {
+		arg = append([]string{"-n", strconv.Itoa(l.limits.Nice), toolName}, arg...)
+		toolName = "nice"
+	}
+	return toolName, arg, nil
+}
+
+// ensureCgroup creates the cgroup of the check's commands if it doesn't exist
+// yet.
+func (l *resourceLimiter) ensureCgroup() (string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.cgroup != "" {
+		return l.cgroup, nil
+	}
+	name, err := containerName()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(CgroupRoot, name)
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return "", err
+	}
+	settings := map[string]string{}
+	if l.memory > 0 {
+		settings["memory.max"] = strconv.FormatInt(l.memory, 10)
+	}
+	if l.limits.CPUs > 0 {
+		settings["cpu.max"] = fmt.Sprintf("%d %d", int64(l.limits.CPUs*cpuPeriod), cpuPeriod)
+	}
+	for file, value := range settings {
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(value), 0644); err != nil {
+			os.Remove(dir)
+			return "", err
+		}
+	}
+	// Swapping would only slow down a check at its memory limit.
+	os.WriteFile(filepath.Join(dir, "memory.swap.max"), []byte("0"), 0644)
+	l.cgroup = dir
+	return dir, nil
+}
+
+// release records the commands killed for exceeding the memory limit, and
+// removes the cgroup of the check's commands, killing those still running,
+// such as the bazel server.
+func (l *resourceLimiter) release(ctx context.Context) {
+	if l == nil || l.cgroup == "" {
+		return
+	}
+	if n := cgroupOOMKills(l.cgroup); n > 0 {
+		l.violated("The memory limit of %s was exceeded: %d processes were killed.", l.limits.Memory, n)
+	}
+	if err := os.WriteFile(filepath.Join(l.cgroup, "cgroup.kill"), []byte("1"), 0644); err != nil {
+		logFrom(ctx).Warnw("failed to kill the processes of cgroup", "cgroup", l.cgroup, "error", err)
+	}
+	// The cgroup can only be removed once its processes exited.
+	var err error
+	for i := 0; i < 10; i++ {
+		if err = os.Remove(l.cgroup); err == nil {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	logFrom(ctx).Warnw("failed to remove cgroup", "cgroup", l.cgroup, "error", err)
+}
+
+// cgroupOOMKills returns how many processes of cgroup were killed for
+// exceeding its memory limit.
+func cgroupOOMKills(cgroup string) int {
+	f, err := os.Open(filepath.Join(cgroup, "memory.events"))
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) == 2 && fields[0] == "oom_kill" {
+			n, _ := strconv.Atoi(fields[1])
+			return n
+		}
+	}
+	return 0
+}
+
+// report adds the violations of the limits to the summary of the check's
+// result, or to its error if it failed to run.
+func (l *resourceLimiter) report(result *Result, err error) (*Result, error) {
+	if l == nil || len(l.violations) == 0 {
+		return result, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s. %s", err, strings.Join(l.violations, " "))
+	}
+	var b strings.Builder
+	b.WriteString("**Resource limits exceeded**\n")
+	for _, v := range l.violations {
+		fmt.Fprintf(&b, "- %s\n", v)
+	}
+	if result.Summary != "" {
+		result.Summary += "\n\n"
+	}
+	result.Summary += b.String()
+	return result, nil
+}
@@ -0,0 +1,210 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func setDefaultLimits(t *testing.T, limits ResourceLimits) {
+	setTestSettings(t, func(s *Settings) { s.DefaultLimits = limits })
+}
+
+func TestParseMemory(t *testing.T) {
+	for s, want := range map[string]int64{
+		"":       0,
+		"1024":   1024,
+		"512k":   512 << 10,
+		"512m":   512 << 20,
+		"8g":     8 << 30,
+		"8GB":    8 << 30,
+		" 1t ":   1 << 40,
+		"100mb":  100 << 20,
+		"2G":     2 << 30,
+		"123456": 123456,
+	} {
+		got, err := parseMemory(s)
+		if err != nil || got != want {
+			t.Errorf("parseMemory(%q) = %d, %v, want %d", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"lots", "-1g", "0", "1.5g", "g"} {
+		if _, err := parseMemory(s); err == nil {
+			t.Errorf("parseMemory(%q) succeeded", s)
+		}
+	}
+}
+
+func TestResourceLimitsValidate(t *testing.T) {
+	if err := (&ResourceLimits{CPUs: 0.5, Memory: "1g", Nice: 19, Jobs: 4}).Validate(); err != nil {
+		t.Errorf("Validate of valid limits: %s", err)
+	}
+	for _, l := range []ResourceLimits{{CPUs: -1}, {Memory: "lots"}, {Nice: 20}, {Nice: -1}, {Jobs: -2}} {
+		if err := l.Validate(); err == nil {
+			t.Errorf("Validate of %+v succeeded", l)
+		}
+	}
+}
+
+func TestNewResourceLimiter(t *testing.T) {
+	setDefaultLimits(t, ResourceLimits{})
+	if l, err := newResourceLimiter(nil); err != nil || l != nil {
+		t.Errorf("newResourceLimiter without limits = %+v, %v, want none", l, err)
+	}
+	if _, err := newResourceLimiter(&ResourceLimits{Nice: 30}); err == nil {
+		t.Errorf("newResourceLimiter of invalid limits succeeded")
+	}
+
+	setDefaultLimits(t, ResourceLimits{CPUs: 4, Memory: "8g", Nice: 5, Jobs: 8})
+	l, err := newResourceLimiter(&ResourceLimits{CPUs: 2, Memory: "16g", Nice: 1, Jobs: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Repositories can only tighten the defaults.
+	if want := (ResourceLimits{CPUs: 2, Memory: "8g", Nice: 5, Jobs: 8}); l.limits != want || l.memory != 8<<30 {
+		t.Errorf("got limits %+v of %d bytes, want %+v", l.limits, l.memory, want)
+	}
+	l, err = newResourceLimiter(&ResourceLimits{Memory: "1g", Nice: 10, Jobs: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (ResourceLimits{CPUs: 4, Memory: "1g", Nice: 10, Jobs: 2}); l.limits != want || l.memory != 1<<30 {
+		t.Errorf("got limits %+v of %d bytes, want %+v", l.limits, l.memory, want)
+	}
+}
+
+func TestLocalCommand(t *testing.T) {
+	old := CgroupRoot
+	t.Cleanup(func() { CgroupRoot = old })
+	CgroupRoot = ""
+	l := &resourceLimiter{limits: ResourceLimits{CPUs: 1.5, Memory: "1g", Nice: 10}, memory: 1 << 30}
+
+	// Without a cgroup root only the niceness is applied.
+	toolName, arg, err := l.localCommand("bb", []string{"build"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if toolName != "nice" || !reflect.DeepEqual(arg, []string{"-n", "10", "bb", "build"}) {
+		t.Errorf("got command %s %q, want bb run with nice", toolName, arg)
+	}
+
+	CgroupRoot = t.TempDir()
+	toolName, arg, err = l.localCommand("bb", []string{"build"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.cgroup == "" || filepath.Dir(l.cgroup) != CgroupRoot {
+		t.Fatalf("created cgroup %q, want one under %s", l.cgroup, CgroupRoot)
+	}
+	if toolName != "nice" || !reflect.DeepEqual(arg[:5], []string{"-n", "10", "sh", "-c", `echo $$ > "$0/cgroup.procs" && exec "$@"`}) || !reflect.DeepEqual(arg[5:], []string{l.cgroup, "bb", "build"}) {
+		t.Errorf("got command %s %q, want bb moved into the cgroup and run with nice", toolName, arg)
+	}
+	for file, want := range map[string]string{"memory.max": "1073741824", "cpu.max": "150000 100000", "memory.swap.max": "0"} {
+		b, err := os.ReadFile(filepath.Join(l.cgroup, file))
+		if err != nil || string(b) != want {
+			t.Errorf("got %s %q, %v, want %q", file, b, err, want)
+		}
+	}
+
+	// The commands of the check share the cgroup.
+	cgroup := l.cgroup
+	if _, _, err := l.localCommand("bb", []string{"test"}); err != nil || l.cgroup != cgroup {
+		t.Errorf("second command got cgroup %q, %v, want %q", l.cgroup, err, cgroup)
+	}
+}
+
+func TestCgroupOOMKills(t *testing.T) {
+	dir := t.TempDir()
+	if n := cgroupOOMKills(dir); n != 0 {
+		t.Errorf("got %d OOM kills without memory.events, want 0", n)
+	}
+	writeTestFile(t, dir, "memory.events", "low 0\nhigh 0\nmax 3\noom 2\noom_kill 2\n")
+	if n := cgroupOOMKills(dir); n != 2 {
+		t.Errorf("got %d OOM kills, want 2", n)
+	}
+}
+
+func TestResourceLimiterReport(t *testing.T) {
+	var nilLimiter *resourceLimiter
+	if result, err := nilLimiter.report(&Result{Summary: "ok"}, nil); err != nil || result.Summary != "ok" {
+		t.Errorf("nil limiter changed the result to %+v, %v", result, err)
+	}
+
+	l := &resourceLimiter{}
+	l.violated("The memory limit of %s was exceeded.", "1g")
+	l.violated("The memory limit of %s was exceeded.", "1g")
+	result, err := l.report(&Result{Summary: "Build failed."}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Build failed.\n\n**Resource limits exceeded**\n- The memory limit of 1g was exceeded.\n"; result.Summary != want {
+		t.Errorf("got summary %q, want %q", result.Summary, want)
+	}
+	if _, err := l.report(nil, errors.New("bazel crashed")); err == nil || err.Error() != "bazel crashed. The memory limit of 1g was exceeded." {
+		t.Errorf("got error %v, want the violation added", err)
+	}
+}
+
+func TestRunCheckCmdOnHostWithNiceness(t *testing.T) {
+	// nice without a command prints the niceness it runs with.
+	niceness := func(ctx context.Context) int {
+		stdOut, _, err := runCheckCmd(ctx, &CheckTarget{Dir: t.TempDir()}, "nice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(stdOut.String()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	base := niceness(context.Background())
+	if base > 12 {
+		t.Skipf("the test runs with niceness %d already", base)
+	}
+	l := &resourceLimiter{limits: ResourceLimits{Nice: 7}}
+	if got := niceness(withLimits(context.Background(), l)); got != base+7 {
+		t.Errorf("command ran with niceness %d, want %d", got, base+7)
+	}
+}
+
+func TestRunCheckCmdInContainerWithLimits(t *testing.T) {
+	log := useFakeContainerRuntime(t, "exit 137")
+	l := &resourceLimiter{limits: ResourceLimits{CPUs: 1, Memory: "1g"}, memory: 1 << 30}
+
+	if _, _, err := runCheckCmd(withLimits(context.Background(), l), &CheckTarget{Dir: t.TempDir()}, "bb", "build"); err == nil {
+		t.Errorf("killed container succeeded")
+	}
+	calls := readRuntimeLog(t, log)
+	if !strings.Contains(calls[0], "--cpus 1 --memory 1073741824 reviewbot-tools bb build") {
+		t.Errorf("runtime was called with %q, want the check's tighter limits", calls[0])
+	}
+	if len(l.violations) != 1 || !strings.Contains(l.violations[0], "The memory limit of 1073741824 was exceeded") {
+		t.Errorf("got violations %q, want the killed container reported", l.violations)
+	}
+
+	// Looser limits than the executor's are ignored.
+	l = &resourceLimiter{limits: ResourceLimits{CPUs: 8, Memory: "16g"}, memory: 16 << 30}
+	runCheckCmd(withLimits(context.Background(), l), &CheckTarget{Dir: t.TempDir()}, "bb", "build")
+	if calls := readRuntimeLog(t, log); !strings.Contains(calls[len(calls)-1], "--cpus 2 --memory 4g reviewbot-tools") {
+		t.Errorf("runtime was called with %q, want the executor's limits", calls[len(calls)-1])
+	}
+}
+
+func TestRunBazelPassesJobs(t *testing.T) {
+	installFakeTool(t, "bb", `echo "$@"`+"\n")
+	l := &resourceLimiter{limits: ResourceLimits{Jobs: 3}}
+
+	stdOut, _, err := runBazel(withLimits(context.Background(), l), "key", &CheckTarget{Dir: t.TempDir()}, "build", []string{"--jobs=100"}, []string{"//..."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.TrimSpace(stdOut.String()), "build --remote_header=x-buildbuddy-api-key=key --jobs=100 --jobs=3 -- //..."; got != want {
+		t.Errorf("ran bazel with %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,33 @@
+package app
+
+import (
+	"context"
+	"path/filepath"
+)
+
+// RunLocalCheck runs the named check against a local working tree, outside of
+// any GitHub webhook context.
+func RunLocalCheck(ctx context.Context, checkName string, dir string, bbAPIKeys SecretProvider) (*Result, error) {
+	checker, err := GetChecker(checkName)
+	if err != nil {
+		return nil, err
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	config, err := loadRepoConfigFromDir(absDir)
+	if err != nil {
+		return nil, err
+	}
+	app := &GithubApp{
+		bbAPIKeys: bbAPIKeys,
+	}
+	target := &CheckTarget{
+		FullRepoName: filepath.Base(absDir),
+		Dir:          absDir,
+		Config:       config.Check(checkName),
+		bazel:        config.Bazel.settings("", checkName),
+	}
+	return checker.Run(ctx, app, target)
+}
@@ -0,0 +1,185 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const lockfileCheck = "lockfile"
+
+var (
+	defaultLockfiles     = []string{"MODULE.bazel.lock"}
+	defaultUpdateCommand = []string{"bb", "mod", "deps", "--lockfile_mode=update"}
+)
+
+func init() {
+	RegisterChecker(&funcChecker{
+		name:         lockfileCheck,
+		fn:           checkLockfiles,
+		fixFn:        fixLockfiles,
+		fixMessage:   "Update lockfiles",
+		optIn:        true,
+		requirements: CheckRequirements{Binaries: []string{"bb", "diff", "git"}},
+		rule:         CheckRule{Description: "Lockfiles are up to date with the dependencies they lock."},
+	})
+}
+
+// lockfileSettings returns the lockfiles and update command of a check,
+// applying the defaults.
+func lockfileSettings(config *CheckConfig) ([]string, []string) {
+	lockfiles, command := config.Lockfiles, config.UpdateCommand
+	if len(lockfiles) == 0 {
+		lockfiles = defaultLockfiles
+	}
+	if len(command) == 0 {
+		command = defaultUpdateCommand
+	}
+	return lockfiles, command
+}
+
+// updateLockfiles runs the update command of the check on the checkout.
+func updateLockfiles(ctx context.Context, target *CheckTarget) error {
+	_, command := lockfileSettings(target.Config)
+	_, stdErr, err := runCheckCmd(ctx, target, command[0], command[1:]...)
+	if err != nil {
+		return fmt.Errorf("%s failed: %s: %s", strings.Join(command, " "), err, truncateOutput(cleanLine(stdErr.String()), 20))
+	}
+	return nil
+}
+
+// checkLockfiles regenerates the lockfiles and fails with the difference if
+// any of them changed. They're regenerated in a worktree of the checkout's
+// commit, since other checks read the checkout concurrently.
+func checkLockfiles(ctx context.Context, _ *GithubApp, target *CheckTarget) (*Result, error) {
+	res := &Result{
+		Title: "Lockfile result",
+	}
+	lockfiles, command := lockfileSettings(target.Config)
+	if len(target.Config.Lockfiles) == 0 {
+		if _, err := os.Stat(filepath.Join(target.Dir, "MODULE.bazel")); os.IsNotExist(err) {
+			res.Summary = "The repository doesn't use MODULE.bazel."
+			res.Conclusion = "neutral"
+			return res, nil
+		}
+	}
+
+	before := make(map[string][]byte)
+	for _, f := range lockfiles {
+		b, err := os.ReadFile(filepath.Join(target.Dir, filepath.FromSlash(f)))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		before[f] = b
+	}
+	wt, err := addWorktree(target.Dir)
+	if err != nil {
+		return nil, err
+	}
+	defer removeWorktree(ctx, target.Dir, wt)
+	// The worktree has a bazel workspace of its own, so it can't share the
+	// checkout's output base.
+	wtTarget := *target
+	wtTarget.Dir, wtTarget.outputBase = wt, ""
+	updateErr := updateLockfiles(ctx, &wtTarget)
+
+	annotations := []*Annotation{}
+	var diffs strings.Builder
+	for _, f := range lockfiles {
+		after, err := os.ReadFile(filepath.Join(wt, filepath.FromSlash(f)))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if bytes.Equal(before[f], after) {
+			continue
+		}
+		diff, err := lockfileDiff(ctx, wt, f, before[f])
+		if err != nil {
+			return nil, err
+		}
+		diffs.WriteString(diff)
+		message := fmt.Sprintf("%s is out of date, run `%s`", f, strings.Join(command, " "))
+		if before[f] == nil {
+			message = fmt.Sprintf("%s is missing, run `%s`", f, strings.Join(command, " "))
+		}
+		annotations = append(annotations, &Annotation{
+			Message:   message,
+			Severity:  "failure",
+			Path:      f,
+			Line:      1,
+			WholeFile: true,
+		})
+	}
+	if updateErr != nil {
+		return nil, updateErr
+	}
+
+	if len(annotations) == 0 {
+		res.Summary = "Lockfiles are up to date."
+		res.Conclusion = "success"
+		return res, nil
+	}
+	res.Summary = fmt.Sprintf("%d lockfiles are out of date. Run `%s` and commit the result.", len(annotations), strings.Join(command, " "))
+	res.Conclusion = "failure"
+	res.Annotations = annotations
+	res.Text = diffBlock(diffs.String())
+	res.Action = newFixAction(lockfileCheck, "Regenerate and commit the lockfiles.")
+	return res, nil
+}
+
+// addWorktree checks the commit of the checkout in dir out into a new
+// temporary worktree and returns its path.
+func addWorktree(dir string) (string, error) {
+	tmp, err := os.MkdirTemp("", "worktree")
+	if err != nil {
+		return "", err
+	}
+	wt := filepath.Join(tmp, filepath.Base(dir))
+	if err := runGit(dir, "worktree", "add", "--detach", wt, "HEAD"); err != nil {
+		os.RemoveAll(tmp)
+		return "", fmt.Errorf("failed to add worktree: %s", err)
+	}
+	return wt, nil
+}
+
+// removeWorktree removes the worktree wt of the checkout in dir, added by
+// addWorktree.
+func removeWorktree(ctx context.Context, dir string, wt string) {
+	if err := runGit(dir, "worktree", "remove", "--force", wt); err != nil {
+		logFrom(ctx).Warnw("failed to remove worktree", "worktree", wt, "error", err)
+	}
+	if err := os.RemoveAll(filepath.Dir(wt)); err != nil {
+		logFrom(ctx).Warnw("failed to remove worktree", "worktree", wt, "error", err)
+	}
+}
+
+// lockfileDiff returns the unified diff from the original content of the
+// lockfile f to its content in the checkout at dir.
+func lockfileDiff(ctx context.Context, dir string, f string, original []byte) (string, error) {
+	tmp, err := os.CreateTemp("", "lockfile")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(original)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	// diff exits with 1 when the files differ.
+	stdOut, stdErr, err := runCmdInDir(ctx, dir, "diff", "-u", "-N", "--label", "a/"+f, "--label", "b/"+f, tmp.Name(), filepath.FromSlash(f))
+	if err != nil && stdOut.Len() == 0 {
+		return "", fmt.Errorf("failed to diff %s: %s: %s", f, err, stdErr.String())
+	}
+	return stdOut.String(), nil
+}
+
+// fixLockfiles regenerates the lockfiles of the checkout.
+func fixLockfiles(ctx context.Context, _ *GithubApp, target *CheckTarget) error {
+	return updateLockfiles(ctx, target)
+}
@@ -0,0 +1,127 @@
+package app
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// lockfileCheckout returns a git checkout of a commit of files, a map from
+// path to content, since lockfiles are regenerated in a worktree of the
+// checkout's commit.
+func lockfileCheckout(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	testGit(t, dir, "init", "-q")
+	for path, content := range files {
+		writeTestFile(t, dir, path, content)
+	}
+	testGit(t, dir, "add", "-A")
+	testGit(t, dir, "commit", "-q", "--allow-empty", "-m", "Initial commit")
+	return dir
+}
+
+func TestCheckLockfiles(t *testing.T) {
+	installFakeTool(t, "bb", `echo '{"version": 2}' > MODULE.bazel.lock`+"\n")
+	dir := lockfileCheckout(t, map[string]string{"MODULE.bazel": "bazel_dep(name = \"rules_go\")\n", "MODULE.bazel.lock": "{\"version\": 1}\n"})
+
+	res, err := checkLockfiles(context.Background(), nil, &CheckTarget{Dir: dir, Config: &CheckConfig{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Conclusion != "failure" || res.Action == nil {
+		t.Errorf("got %s with action %+v, want a fixable failure", res.Conclusion, res.Action)
+	}
+	want := "MODULE.bazel.lock is out of date, run `bb mod deps --lockfile_mode=update`"
+	if len(res.Annotations) != 1 || res.Annotations[0].Path != "MODULE.bazel.lock" || res.Annotations[0].Message != want {
+		t.Errorf("got annotations %v, want %q", res.Annotations, want)
+	}
+	for _, line := range []string{"--- a/MODULE.bazel.lock", "+++ b/MODULE.bazel.lock", `-{"version": 1}`, `+{"version": 2}`} {
+		if !strings.Contains(res.Text, line) {
+			t.Errorf("text %q doesn't contain %q", res.Text, line)
+		}
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "MODULE.bazel.lock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "{\"version\": 1}\n" {
+		t.Errorf("lockfile is %q after the check, want it untouched", b)
+	}
+	if worktrees := testGit(t, dir, "worktree", "list"); strings.Count(worktrees, "\n") != 0 {
+		t.Errorf("got worktrees %q after the check, want only the checkout", worktrees)
+	}
+}
+
+func TestCheckLockfilesWithMissingLockfile(t *testing.T) {
+	installFakeTool(t, "pin", "echo pinned > deps.lock\n")
+	dir := lockfileCheckout(t, nil)
+	config := &CheckConfig{Lockfiles: []string{"deps.lock"}, UpdateCommand: []string{"pin", "--all"}}
+
+	res, err := checkLockfiles(context.Background(), nil, &CheckTarget{Dir: dir, Config: config})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Annotations) != 1 || res.Annotations[0].Message != "deps.lock is missing, run `pin --all`" {
+		t.Errorf("got annotations %v, want deps.lock to be missing", res.Annotations)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "deps.lock")); !os.IsNotExist(err) {
+		t.Errorf("got %v for the generated lockfile, want it only in the worktree", err)
+	}
+}
+
+func TestCheckLockfilesUpToDate(t *testing.T) {
+	installFakeTool(t, "bb", "exit 0\n")
+	dir := lockfileCheckout(t, map[string]string{"MODULE.bazel": "", "MODULE.bazel.lock": "{}\n"})
+
+	res, err := checkLockfiles(context.Background(), nil, &CheckTarget{Dir: dir, Config: &CheckConfig{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Conclusion != "success" {
+		t.Errorf("got %s, want success", res.Conclusion)
+	}
+}
+
+func TestCheckLockfilesWithoutModule(t *testing.T) {
+	res, err := checkLockfiles(context.Background(), nil, &CheckTarget{Dir: t.TempDir(), Config: &CheckConfig{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Conclusion != "neutral" {
+		t.Errorf("got %s without MODULE.bazel, want neutral", res.Conclusion)
+	}
+}
+
+func TestCheckLockfilesWithFailedUpdate(t *testing.T) {
+	installFakeTool(t, "bb", "echo half > MODULE.bazel.lock\nexit 2\n")
+	dir := lockfileCheckout(t, map[string]string{"MODULE.bazel": "", "MODULE.bazel.lock": "{}\n"})
+
+	if _, err := checkLockfiles(context.Background(), nil, &CheckTarget{Dir: dir, Config: &CheckConfig{}}); err == nil {
+		t.Errorf("check succeeded although the update failed")
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "MODULE.bazel.lock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "{}\n" {
+		t.Errorf("lockfile is %q after the failed update, want it restored", b)
+	}
+}
+
+func TestFixLockfiles(t *testing.T) {
+	installFakeTool(t, "pin", `echo "$@" > deps.lock`+"\n")
+	dir := t.TempDir()
+	target := &CheckTarget{Dir: dir, Config: &CheckConfig{Lockfiles: []string{"deps.lock"}, UpdateCommand: []string{"pin", "--all"}}}
+	if err := fixLockfiles(context.Background(), nil, target); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "deps.lock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "--all\n" {
+		t.Errorf("lockfile is %q, want it regenerated with the update command", b)
+	}
+}
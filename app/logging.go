@@ -0,0 +1,79 @@
+package app
+
+import (
+	"context"
+	"regexp"
+
+	"go.uber.org/zap"
+)
+
+// Logger is the base logger of the app. Code handling a webhook logs through
+// logFrom(ctx) instead, which adds the delivery ID, repository, SHA and check
+// name of the work in progress to every line, so that a single delivery can
+// be traced through cloning, checking and updating its check runs.
+var Logger = newLogger()
+
+func newLogger() *zap.SugaredLogger {
+	l, err := zap.NewProduction()
+	if err != nil {
+		panic(err)
+	}
+	return l.Sugar()
+}
+
+type loggerKey struct{}
+
+type deliveryIDKey struct{}
+
+// logFrom returns the logger attached to ctx, or Logger if there is none.
+func logFrom(ctx context.Context) *zap.SugaredLogger {
+	if l, ok := ctx.Value(loggerKey{}).(*zap.SugaredLogger); ok {
+		return l
+	}
+	return Logger
+}
+
+// withLogFields returns a context whose logger adds the given key-value pairs
+// to every line.
+func withLogFields(ctx context.Context, keysAndValues ...interface{}) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logFrom(ctx).With(keysAndValues...))
+}
+
+// withDeliveryID returns a context for handling the webhook delivery with the
+// given ID.
+func withDeliveryID(ctx context.Context, deliveryID string) context.Context {
+	ctx = context.WithValue(ctx, deliveryIDKey{}, deliveryID)
+	return withLogFields(ctx, "delivery_id", deliveryID)
+}
+
+// deliveryID returns the ID of the webhook delivery being handled in ctx, so
+// that it can be passed along with jobs.
+func deliveryID(ctx context.Context) string {
+	id, _ := ctx.Value(deliveryIDKey{}).(string)
+	return id
+}
+
+// jobContext returns a context whose logger is tagged with the job's delivery
+// ID, repository and SHA. The fields replace any already attached to ctx, as
+// an inline job runs with the context of the webhook that created it.
+func jobContext(ctx context.Context, job *Job) context.Context {
+	ctx = context.WithValue(ctx, deliveryIDKey{}, job.DeliveryID)
+	l := Logger.With("delivery_id", job.DeliveryID, "repo", job.FullRepoName, "sha", job.HeadSHA)
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// secretRegex matches the credentials that can appear in the command lines
+// and output of commands: the BuildBuddy API key passed to bazel as a remote
+// header, and tokens in authenticated URLs.
+var secretRegex = regexp.MustCompile(`(x-buildbuddy-api-key=|x-access-token:)[^\s'"@]+`)
+
+// maxSecretLen bounds the length of the credentials secretRegex matches,
+// including their prefix, so that output cut this far from its end can't end
+// with part of one.
+const maxSecretLen = 512
+
+// redactSecrets replaces the credentials in s with <redacted>. Command lines
+// and output must go through it before they are logged.
+func redactSecrets(s string) string {
+	return secretRegex.ReplaceAllString(s, "${1}<redacted>")
+}
@@ -0,0 +1,230 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type outputKey struct{}
+
+// withOutput returns a context whose commands, run with runCmdInDir, also copy
+// their output to w as it is written.
+func withOutput(ctx context.Context, w io.Writer) context.Context {
+	return context.WithValue(ctx, outputKey{}, w)
+}
+
+func outputFrom(ctx context.Context) io.Writer {
+	w, _ := ctx.Value(outputKey{}).(io.Writer)
+	return w
+}
+
+// maxCommandLogBytes bounds the output a commandLog keeps, and streams to
+// the store, for one check. Beyond it, the head and tail of the output are
+// kept, like truncateOutput does for lines.
+const maxCommandLogBytes = 4 << 20
+
+// commandLog collects the commands a check ran and their output, for the
+// bot's results page. A nil log discards everything.
+type commandLog struct {
+	mu sync.Mutex
+	// head is the first half of the output, which is streamed as it's
+	// written.
+	head strings.Builder
+	// tail is the output after head, of which only the last half is kept
+	// until the check completes. It's trimmed once it holds twice that, so
+	// that trimming doesn't copy it on every write.
+	tail []byte
+	// omitted is how many bytes were trimmed between head and tail.
+	omitted int
+	// flushed is how much of head was taken by unflushed.
+	flushed int
+	// truncated is set once unflushed noted that the rest of the output
+	// only follows when the check completes.
+	truncated bool
+}
+
+// start adds the command line of a command about to run. Like the output of
+// commands, it's only read with its credentials redacted, see redactSecrets.
+func (l *commandLog) start(toolName string, arg []string) {
+	fmt.Fprintf(l, "$ %s\n", strings.Join(append([]string{toolName}, arg...), " "))
+}
+
+// finish adds the error of a command that failed.
+func (l *commandLog) finish(err error) {
+	if err != nil {
+		fmt.Fprintf(l, "(%s)\n", err)
+	}
+}
+
+// Write adds output of the running command. It is called concurrently with
+// its stdout and stderr.
+func (l *commandLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := len(p)
+	if room := maxCommandLogBytes/2 - l.head.Len(); room > 0 {
+		if room > len(p) {
+			room = len(p)
+		}
+		l.head.Write(p[:room])
+		p = p[room:]
+	}
+	l.tail = append(l.tail, p...)
+	if len(l.tail) > maxCommandLogBytes {
+		over := len(l.tail) - maxCommandLogBytes/2
+		l.omitted += over
+		l.tail = l.tail[:copy(l.tail, l.tail[over:])]
+	}
+	return n, nil
+}
+
+// rest returns the last half of the output after head, with a marker for the
+// omitted output, if any.
+func (l *commandLog) rest() string {
+	tail, omitted := l.tail, l.omitted
+	if over := len(tail) - maxCommandLogBytes/2; over > 0 {
+		tail = tail[over:]
+		omitted += over
+	}
+	if omitted == 0 {
+		return string(tail)
+	}
+	return fmt.Sprintf("\n... (%d bytes omitted) ...\n%s", omitted, tail)
+}
+
+// unflushed returns what was added since the last call, without credentials.
+// Unless final is set, a trailing incomplete line is kept for the next call,
+// so that a credential is never split between calls. Once the head of the
+// output is full, its last maxSecretLen bytes and the rest are only returned
+// when final is set.
+func (l *commandLog) unflushed(final bool) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	headFull := len(l.tail) > 0
+	s := l.head.String()[l.flushed:]
+	if !final {
+		end := len(s)
+		if headFull {
+			// The head may end in the middle of a credential, so its end is
+			// kept until it can be redacted along with the rest of the output.
+			end -= maxSecretLen
+			if end < 0 {
+				end = 0
+			}
+		}
+		s = s[:strings.LastIndexByte(s[:end], '\n')+1]
+	}
+	l.flushed += len(s)
+	switch {
+	case final:
+		s += l.rest()
+	case headFull && !l.truncated:
+		l.truncated = true
+		s += "\n... (output truncated, the rest is shown when the check completes) ...\n"
+	}
+	return redactSecrets(s)
+}
+
+// String returns the log, keeping its head and tail if it is longer than
+// MaxOutputLines.
+func (l *commandLog) String() string {
+	if l == nil {
+		return ""
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return truncateOutput(cleanLine(redactSecrets(l.head.String()+l.rest())), CurrentSettings().MaxOutputLines)
+}
+
+// streamLog appends what is added to log to the log of a job's check in the
+// store every LogFlushInterval, if the store implements LogStore, until the
+// returned function is called.
+func (app *GithubApp) streamLog(ctx context.Context, job *Job, checkName string, log *commandLog) func() {
+	ls, ok := app.store.(LogStore)
+	if !ok {
+		return func() {}
+	}
+	if err := ls.StartLog(ctx, job.FullRepoName, job.HeadSHA, checkName); err != nil {
+		logFrom(ctx).Warnw("failed to start log", "error", err)
+		return func() {}
+	}
+	flush := func(final bool) {
+		if output := log.unflushed(final); output != "" {
+			if err := ls.AppendLog(ctx, job.FullRepoName, job.HeadSHA, checkName, cleanLine(output)); err != nil {
+				logFrom(ctx).Warnw("failed to append to log", "error", err)
+			}
+		}
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(CurrentSettings().LogFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				flush(false)
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+		flush(true)
+		if err := ls.FinishLog(ctx, job.FullRepoName, job.HeadSHA, checkName); err != nil {
+			logFrom(ctx).Warnw("failed to finish log", "error", err)
+		}
+	}
+}
+
+// newlineReplacer turns carriage returns, which can't be part of the lines of
+// an event, into newlines. Tools use them to redraw progress.
+var newlineReplacer = strings.NewReplacer("\r\n", "\n", "\r", "\n")
+
+// serveLiveLog sends the log of checkName on sha as server-sent events, each
+// with the output added since the previous one, until the log is complete.
+// The last event is named "done".
+func serveLiveLog(w http.ResponseWriter, req *http.Request, ls LogStore, fullRepoName string, sha string, checkName string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming isn't supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	next := 0
+	for {
+		output, n, done, err := ls.ReadLog(req.Context(), fullRepoName, sha, checkName, next)
+		if err != nil {
+			logFrom(req.Context()).Warnw("failed to read log", "error", err)
+			return
+		}
+		next = n
+		if output != "" {
+			output = newlineReplacer.Replace(output)
+			for _, line := range strings.Split(output, "\n") {
+				fmt.Fprintf(w, "data: %s\n", line)
+			}
+			fmt.Fprint(w, "\n")
+		}
+		if done {
+			fmt.Fprint(w, "event: done\ndata:\n\n")
+			flusher.Flush()
+			return
+		}
+		flusher.Flush()
+		select {
+		case <-time.After(CurrentSettings().LogFlushInterval):
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func setLogFlushInterval(t *testing.T, d time.Duration) {
+	setTestSettings(t, func(s *Settings) { s.LogFlushInterval = d })
+}
+
+func TestCommandLogUnflushed(t *testing.T) {
+	log := &commandLog{}
+	log.start("bazel", []string{"build", "//..."})
+	if got := log.unflushed(false); got != "$ bazel build //...\n" {
+		t.Errorf("got %q, want the command line", got)
+	}
+	log.Write([]byte("INFO: Build completed\n"))
+	log.finish(nil)
+	if got := log.unflushed(false); got != "INFO: Build completed\n" {
+		t.Errorf("got %q, want only the output added since", got)
+	}
+	if got := log.unflushed(false); got != "" {
+		t.Errorf("got %q without new output, want nothing", got)
+	}
+	if got := log.String(); got != "$ bazel build //...\nINFO: Build completed\n" {
+		t.Errorf("got log %q, want all of it", got)
+	}
+}
+
+func TestCommandLogUnflushedHoldsBackIncompleteLines(t *testing.T) {
+	log := &commandLog{}
+	log.Write([]byte("Cloning https://x-access-token:ghs_se"))
+	if got := log.unflushed(false); got != "" {
+		t.Errorf("got %q, want the incomplete line held back", got)
+	}
+	log.Write([]byte("cret@github.com/o/r.git\nDone"))
+	if got, want := log.unflushed(false), "Cloning https://x-access-token:<redacted>@github.com/o/r.git\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := log.unflushed(true); got != "Done" {
+		t.Errorf("got %q, want the incomplete line once final", got)
+	}
+	if got := log.String(); strings.Contains(got, "ghs_secret") {
+		t.Errorf("got log %q, want its credentials redacted", got)
+	}
+}
+
+func TestCommandLogKeepsHeadAndTail(t *testing.T) {
+	l := &commandLog{}
+	head := strings.Repeat("h", maxCommandLogBytes/2)
+	middle := strings.Repeat("m", 1000)
+	tail := strings.Repeat("t", maxCommandLogBytes/2)
+	for _, s := range []string{head, middle, tail} {
+		l.Write([]byte(s))
+	}
+
+	got := l.head.String() + l.rest()
+	want := head + "\n... (1000 bytes omitted) ...\n" + tail
+	if got != want {
+		t.Errorf("log has %d bytes, want %d bytes of head and tail around an omission marker", len(got), len(want))
+	}
+}
+
+func TestCommandLogBoundsOutput(t *testing.T) {
+	log := &commandLog{}
+	line := strings.Repeat("x", 1023) + "\n"
+	for i := 0; i < maxCommandLogBytes/len(line)+100; i++ {
+		log.Write([]byte(line))
+	}
+	log.Write([]byte("last line\n"))
+
+	head := log.unflushed(false)
+	if !strings.HasSuffix(head, "\n... (output truncated, the rest is shown when the check completes) ...\n") || len(head) > maxCommandLogBytes/2+100 {
+		t.Errorf("streamed %d bytes, want the head of the output and a truncation note", len(head))
+	}
+	if got := log.unflushed(false); got != "" {
+		t.Errorf("streamed %d more bytes before the check completed, want none", len(got))
+	}
+	rest := log.unflushed(true)
+	// The end of the head is held back until the output is complete.
+	if !strings.HasPrefix(rest, "x") || !strings.Contains(rest, "\n... (102410 bytes omitted) ...\n") || !strings.HasSuffix(rest, "last line\n") {
+		t.Errorf("got final output %.80q...%q, want the end of the head, the omitted bytes counted and the tail", rest, rest[len(rest)-20:])
+	}
+	if n := len(head) + len(rest); n > maxCommandLogBytes+200 {
+		t.Errorf("streamed %d bytes, want at most about %d", n, maxCommandLogBytes)
+	}
+}
+
+func TestStreamLog(t *testing.T) {
+	setLogFlushInterval(t, time.Millisecond)
+	store := newMemStore()
+	app := &GithubApp{}
+	app.SetStore(store)
+	job := &Job{FullRepoName: "o/r", HeadSHA: "abc"}
+	log := &commandLog{}
+	ctx := context.Background()
+
+	stop := app.streamLog(ctx, job, "lint", log)
+	log.Write([]byte("first\n"))
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		output, _, done, _ := store.ReadLog(ctx, "o/r", "abc", "lint", 0)
+		if output == "first\n" && !done {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("log is %q, want the output flushed while the check runs", output)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	log.Write([]byte("second\n"))
+	stop()
+	output, _, done, _ := store.ReadLog(ctx, "o/r", "abc", "lint", 0)
+	if output != "first\nsecond\n" || !done {
+		t.Errorf("log is %q, complete: %v, want the rest flushed and the log complete", output, done)
+	}
+}
+
+func TestStreamLogWithoutLogStore(t *testing.T) {
+	app := &GithubApp{}
+	// Stopping is a no-op without a store.
+	app.streamLog(context.Background(), &Job{FullRepoName: "o/r", HeadSHA: "abc"}, "lint", &commandLog{})()
+}
+
+func TestResultsHandlerServesLiveLog(t *testing.T) {
+	setLogFlushInterval(t, time.Millisecond)
+	store := newMemStore()
+	ctx := context.Background()
+	store.StartLog(ctx, "o/r", "abc", "lint")
+	store.AppendLog(ctx, "o/r", "abc", "lint", "$ lint\n50%\r100%\n")
+	app := &GithubApp{}
+	app.SetStore(store)
+
+	// The running check's page follows its log.
+	w := resultsRequest(app, "", "/results/o/r/abc/lint", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "running") || !strings.Contains(w.Body.String(), "EventSource") {
+		t.Errorf("got status %d and page %s, want the live log of the running check", w.Code, w.Body)
+	}
+
+	store.FinishLog(ctx, "o/r", "abc", "lint")
+	w = resultsRequest(app, "", "/results/o/r/abc/lint/log", "")
+	if ct := w.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("got content type %q, want server-sent events", ct)
+	}
+	want := "data: $ lint\ndata: 50%\ndata: 100%\ndata: \n\nevent: done\ndata:\n\n"
+	if w.Body.String() != want {
+		t.Errorf("got events %q, want %q", w.Body, want)
+	}
+
+	// A check that failed to run has a log but no result.
+	w = resultsRequest(app, "", "/results/o/r/abc/lint", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "failed to run") || !strings.Contains(w.Body.String(), "100%") {
+		t.Errorf("got status %d and page %s, want the log of the check that failed to run", w.Code, w.Body)
+	}
+}
+
+func TestCommandLogKeepsTailOfManyWrites(t *testing.T) {
+	l := &commandLog{}
+	line := []byte(strings.Repeat("x", 99) + "\n")
+	n := 3 * maxCommandLogBytes / len(line)
+	for i := 0; i < n; i++ {
+		l.Write(line)
+	}
+
+	if len(l.tail) > maxCommandLogBytes {
+		t.Errorf("tail has %d bytes, want at most %d", len(l.tail), maxCommandLogBytes)
+	}
+	output := strings.Repeat(string(line), n)
+	want := fmt.Sprintf("\n... (%d bytes omitted) ...\n%s", len(output)-maxCommandLogBytes, output[len(output)-maxCommandLogBytes/2:])
+	if got := l.rest(); got != want {
+		t.Errorf("rest has %d bytes, want %d bytes of the last output after an omission marker", len(got), len(want))
+	}
+}
+
+func TestCommandLogUnflushedDoesNotSplitSecretsAtHeadEnd(t *testing.T) {
+	l := &commandLog{}
+	const secret = "x-buildbuddy-api-key=s3cr3t"
+	filler := strings.Repeat("f", maxCommandLogBytes/2-len("x-buildbuddy-api-key=s3"))
+	l.Write([]byte(filler + secret + " more output\n"))
+
+	flushed := l.unflushed(false) + l.unflushed(true)
+	if strings.Contains(flushed, "s3") || strings.Contains(flushed, "cr3t") {
+		t.Errorf("flushed output contains part of the secret: %q", flushed[len(flushed)-100:])
+	}
+}
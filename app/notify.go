@@ -0,0 +1,171 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxNotifyAnnotations is how many annotations {{annotations}} lists.
+	maxNotifyAnnotations = 5
+	// maxDiscordMessage is the longest message Discord accepts.
+	maxDiscordMessage = 2000
+)
+
+// notifyHTTPClient posts notifications.
+var notifyHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// NotifyWebhook is a webhook that the outcomes of the checks of some
+// repositories are posted to.
+type NotifyWebhook struct {
+	// Scope is the owner or owner/repo repository whose checks are posted,
+	// or "*" for every repository.
+	Scope string
+	// URL is the URL of the webhook, or a secret reference to it. Webhooks
+	// of discord.com get Discord messages, others Slack messages.
+	URL string
+}
+
+// ParseNotifyWebhooks parses a comma-separated list of scope=url webhooks,
+// e.g. "acme=https://hooks.slack.com/services/...,*=gcpsm://...".
+func ParseNotifyWebhooks(s string) ([]*NotifyWebhook, error) {
+	var webhooks []*NotifyWebhook
+	for i, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		scope, url, ok := strings.Cut(entry, "=")
+		if !ok || scope == "" || url == "" {
+			// The entry isn't quoted, since its URL is a secret.
+			return nil, fmt.Errorf("invalid webhook #%d: want scope=url", i)
+		}
+		webhooks = append(webhooks, &NotifyWebhook{Scope: scope, URL: url})
+	}
+	return webhooks, nil
+}
+
+// matches reports whether the webhook is for fullRepoName.
+func (w *NotifyWebhook) matches(fullRepoName string) bool {
+	owner, _, _ := strings.Cut(fullRepoName, "/")
+	return w.Scope == "*" || strings.EqualFold(w.Scope, owner) || strings.EqualFold(w.Scope, fullRepoName)
+}
+
+// Notification is the outcome of a check that is posted to notifiers.
+type Notification struct {
+	FullRepoName string
+	HeadSHA      string
+	// PullNumber is the number of the pull request of HeadSHA, or 0 if there
+	// is none.
+	PullNumber int
+	CheckName  string
+	Result     *Result
+}
+
+// link returns the web URL of the notification's pull request, or else of its
+// commit.
+func (n *Notification) link() string {
+	if n.PullNumber != 0 {
+		return githubWebURL(n.FullRepoName, "pull/"+strconv.Itoa(n.PullNumber))
+	}
+	return githubWebURL(n.FullRepoName, "commit/"+n.HeadSHA)
+}
+
+// message renders the notification with template. See NotifyTemplate.
+func (n *Notification) message(template string) string {
+	var annotations []string
+	for _, a := range n.Result.Annotations {
+		if len(annotations) == maxNotifyAnnotations {
+			annotations = append(annotations, fmt.Sprintf("... and %d more", len(n.Result.Annotations)-maxNotifyAnnotations))
+			break
+		}
+		annotations = append(annotations, fmt.Sprintf("%s:%d: %s", a.Path, a.Line, a.Message))
+	}
+	return strings.TrimSpace(strings.NewReplacer(
+		"{{repo}}", n.FullRepoName,
+		"{{check}}", n.CheckName,
+		"{{conclusion}}", n.Result.Conclusion,
+		"{{title}}", n.Result.Title,
+		"{{url}}", n.Result.URL,
+		"{{link}}", n.link(),
+		"{{annotations}}", strings.Join(annotations, "\n"),
+	).Replace(template))
+}
+
+// Notifier posts the outcomes of checks somewhere people see them.
+type Notifier interface {
+	Notify(ctx context.Context, n *Notification) error
+}
+
+// webhookNotifier posts notifications to a Slack or Discord incoming webhook.
+type webhookNotifier struct {
+	url string
+}
+
+func (w *webhookNotifier) Notify(ctx context.Context, n *Notification) error {
+	url, err := ResolveSecret(ctx, w.url)
+	if err != nil {
+		return err
+	}
+	message := n.message(CurrentSettings().NotifyTemplate)
+	var payload interface{} = map[string]string{"text": message}
+	if strings.Contains(url, "discord.com/") || strings.Contains(url, "discordapp.com/") {
+		payload = map[string]string{"content": truncateText(message, maxDiscordMessage)}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := notifyHTTPClient.Do(req)
+	if err != nil {
+		// The error includes the URL, which is a secret.
+		return fmt.Errorf("failed to post to webhook of %s", n.FullRepoName)
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook of %s returned %s", n.FullRepoName, res.Status)
+	}
+	return nil
+}
+
+// notifiers returns the notifiers of the outcomes of fullRepoName's checks.
+func notifiers(fullRepoName string) []Notifier {
+	var ns []Notifier
+	for _, w := range CurrentSettings().NotifyWebhooks {
+		if w.matches(fullRepoName) {
+			ns = append(ns, &webhookNotifier{url: w.URL})
+		}
+	}
+	return ns
+}
+
+// notify posts the result of a job's check to the notifiers of its
+// repository, if it failed or NotifyAll is set.
+func (app *GithubApp) notify(ctx context.Context, job *Job, checkName string, result *Result) {
+	if !CurrentSettings().NotifyAll && !failedConclusion(result.Conclusion) {
+		return
+	}
+	n := &Notification{
+		FullRepoName: job.FullRepoName,
+		HeadSHA:      job.HeadSHA,
+		PullNumber:   job.PullNumber,
+		CheckName:    checkName,
+		Result:       result,
+	}
+	for _, notifier := range notifiers(job.FullRepoName) {
+		if err := notifier.Notify(ctx, n); err != nil {
+			logFrom(ctx).Warnw("failed to send notification", "error", err)
+		}
+	}
+}
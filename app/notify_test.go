@@ -0,0 +1,176 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// serveNotifyWebhook records the payloads posted to the returned webhook
+// URL, which responds with status.
+func serveNotifyWebhook(t *testing.T, status int) (string, *[]map[string]string) {
+	var payloads []map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		payload := map[string]string{}
+		if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+			t.Errorf("failed to decode payload: %s", err)
+		}
+		payloads = append(payloads, payload)
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL, &payloads
+}
+
+func setNotifyWebhooks(t *testing.T, webhooks ...*NotifyWebhook) {
+	setTestSettings(t, func(s *Settings) { s.NotifyWebhooks = webhooks })
+}
+
+func testNotification() *Notification {
+	return &Notification{
+		FullRepoName: "o/r",
+		HeadSHA:      "abc",
+		PullNumber:   5,
+		CheckName:    bazelTestCheck,
+		Result:       &Result{Conclusion: "failure", Title: "Test result", URL: "https://app.buildbuddy.io/invocation/1"},
+	}
+}
+
+func TestParseNotifyWebhooks(t *testing.T) {
+	webhooks, err := ParseNotifyWebhooks(" acme=https://hooks.slack.com/services/a , *=file:///run/secrets/hook,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*NotifyWebhook{{Scope: "acme", URL: "https://hooks.slack.com/services/a"}, {Scope: "*", URL: "file:///run/secrets/hook"}}
+	if !reflect.DeepEqual(webhooks, want) {
+		t.Errorf("got webhooks %+v, want %+v", webhooks, want)
+	}
+	if webhooks, err := ParseNotifyWebhooks(""); err != nil || len(webhooks) != 0 {
+		t.Errorf("ParseNotifyWebhooks of nothing = %+v, %v, want none", webhooks, err)
+	}
+	for _, s := range []string{"https://hooks.slack.com/services/secret", "acme=", "=https://hooks.slack.com/services/secret"} {
+		_, err := ParseNotifyWebhooks(s)
+		if err == nil {
+			t.Errorf("ParseNotifyWebhooks(%q) succeeded", s)
+		} else if strings.Contains(err.Error(), "secret") {
+			t.Errorf("error %q reveals the webhook URL", err)
+		}
+	}
+}
+
+func TestNotifyWebhookMatches(t *testing.T) {
+	for scope, want := range map[string]bool{"*": true, "o": true, "O": true, "o/r": true, "o/other": false, "other": false} {
+		if got := (&NotifyWebhook{Scope: scope}).matches("o/r"); got != want {
+			t.Errorf("webhook of %q matches o/r: %v, want %v", scope, got, want)
+		}
+	}
+}
+
+func TestNotificationMessage(t *testing.T) {
+	n := testNotification()
+	for i := 0; i < maxNotifyAnnotations+2; i++ {
+		n.Result.Annotations = append(n.Result.Annotations, &Annotation{Path: "a.go", Line: i + 1, Message: "bad"})
+	}
+
+	want := "bazel-test failure on https://github.com/o/r/pull/5: Test result\nhttps://app.buildbuddy.io/invocation/1\n" +
+		"a.go:1: bad\na.go:2: bad\na.go:3: bad\na.go:4: bad\na.go:5: bad\n... and 2 more"
+	if got := n.message(CurrentSettings().NotifyTemplate); got != want {
+		t.Errorf("got message %q, want %q", got, want)
+	}
+
+	n.PullNumber = 0
+	n.Result.Annotations = nil
+	if got, want := n.message("{{repo}}: {{check}} is {{conclusion}} on {{link}}\n{{annotations}}"), "o/r: bazel-test is failure on https://github.com/o/r/commit/abc"; got != want {
+		t.Errorf("got message %q, want %q", got, want)
+	}
+}
+
+func TestWebhookNotifierPostsSlackMessage(t *testing.T) {
+	url, payloads := serveNotifyWebhook(t, http.StatusOK)
+	// The webhook URL is read from a secret reference.
+	ref := filepath.Join(t.TempDir(), "hook")
+	if err := os.WriteFile(ref, []byte(url+"/services/a\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := (&webhookNotifier{url: "file://" + ref}).Notify(context.Background(), testNotification()); err != nil {
+		t.Fatal(err)
+	}
+	if len(*payloads) != 1 || !strings.HasPrefix((*payloads)[0]["text"], "bazel-test failure on ") {
+		t.Errorf("posted %+v, want a Slack message", *payloads)
+	}
+}
+
+func TestWebhookNotifierPostsDiscordMessage(t *testing.T) {
+	url, payloads := serveNotifyWebhook(t, http.StatusNoContent)
+	n := testNotification()
+	n.Result.Title = strings.Repeat("x", 3000)
+
+	if err := (&webhookNotifier{url: url + "/discord.com/api/webhooks/1"}).Notify(context.Background(), n); err != nil {
+		t.Fatal(err)
+	}
+	if len(*payloads) != 1 {
+		t.Fatalf("posted %d messages, want 1", len(*payloads))
+	}
+	if content := (*payloads)[0]["content"]; len(content) != maxDiscordMessage {
+		t.Errorf("posted a Discord message of %d characters, want it truncated to %d", len(content), maxDiscordMessage)
+	}
+}
+
+func TestWebhookNotifierErrorsHideURL(t *testing.T) {
+	url, _ := serveNotifyWebhook(t, http.StatusNotFound)
+	err := (&webhookNotifier{url: url + "/services/secret"}).Notify(context.Background(), testNotification())
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("got error %v, want the status reported", err)
+	}
+
+	err = (&webhookNotifier{url: "http://127.0.0.1:1/services/secret"}).Notify(context.Background(), testNotification())
+	if err == nil || strings.Contains(err.Error(), "secret") {
+		t.Errorf("got error %v, want a failure that doesn't reveal the URL", err)
+	}
+}
+
+func TestNotifyPostsFailuresToMatchingWebhooks(t *testing.T) {
+	url, payloads := serveNotifyWebhook(t, http.StatusOK)
+	otherURL, otherPayloads := serveNotifyWebhook(t, http.StatusOK)
+	setNotifyWebhooks(t, &NotifyWebhook{Scope: "o", URL: url}, &NotifyWebhook{Scope: "other/r", URL: otherURL})
+	setTestSettings(t, func(s *Settings) { s.NotifyTemplate = "{{check}} {{conclusion}}" })
+	app := newTestApp(t, newFakeGitHub(t))
+	job := &Job{FullRepoName: "o/r", HeadSHA: "abc"}
+	ctx := context.Background()
+
+	for _, conclusion := range []string{"success", "neutral", "failure", "timed_out"} {
+		app.notify(ctx, job, bazelTestCheck, &Result{Conclusion: conclusion})
+	}
+	var got []string
+	for _, p := range *payloads {
+		got = append(got, p["text"])
+	}
+	if want := []string{"bazel-test failure", "bazel-test timed_out"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("posted %q, want only the failures", got)
+	}
+	if len(*otherPayloads) != 0 {
+		t.Errorf("posted %d messages to the webhook of another repository", len(*otherPayloads))
+	}
+
+	setTestSettings(t, func(s *Settings) { s.NotifyAll = true })
+	app.notify(ctx, job, bazelTestCheck, &Result{Conclusion: "success"})
+	if n := len(*payloads); n != 3 || (*payloads)[2]["text"] != "bazel-test success" {
+		t.Errorf("posted %d messages, want the success posted with NotifyAll", n)
+	}
+}
+
+func TestNotifyWithoutWebhooks(t *testing.T) {
+	setNotifyWebhooks(t)
+	if ns := notifiers("o/r"); len(ns) != 0 {
+		t.Errorf("got %d notifiers without webhooks", len(ns))
+	}
+	// Posting nowhere doesn't fail.
+	newTestApp(t, newFakeGitHub(t)).notify(context.Background(), &Job{FullRepoName: "o/r"}, bazelTestCheck, &Result{Conclusion: "failure"})
+}
@@ -0,0 +1,59 @@
+package app
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// ansiRegex matches ANSI CSI and OSC escape sequences, such as the color codes
+// tools emit when run under a pseudo-TTY or with forced color.
+var ansiRegex = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)`)
+
+// maxCheckRunText is the longest text GitHub accepts for the summary or text
+// of a check run's output.
+const maxCheckRunText = 65535
+
+// truncateText cuts s to at most max bytes, ending with a marker if anything
+// was cut. It doesn't split UTF-8 characters.
+func truncateText(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	const marker = "\n... (truncated)"
+	cut := max - len(marker)
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + marker
+}
+
+// truncateOutput keeps the first and last maxLines/2 lines of output and
+// replaces everything in between with a "(N lines omitted)" marker.
+func truncateOutput(output string, maxLines int) string {
+	if maxLines <= 0 {
+		return output
+	}
+	lines := strings.Split(output, "\n")
+	if len(lines) <= maxLines {
+		return output
+	}
+	head := maxLines / 2
+	tail := maxLines - head
+	omitted := len(lines) - head - tail
+	truncated := make([]string, 0, maxLines+1)
+	truncated = append(truncated, lines[:head]...)
+	truncated = append(truncated, fmt.Sprintf("... (%d lines omitted) ...", omitted))
+	truncated = append(truncated, lines[len(lines)-tail:]...)
+	return strings.Join(truncated, "\n")
+}
+
+// cleanLine removes ANSI escape codes from a line of tool output, unless
+// StripANSI is disabled.
+func cleanLine(line string) string {
+	if !CurrentSettings().StripANSI {
+		return line
+	}
+	return ansiRegex.ReplaceAllString(line, "")
+}
@@ -0,0 +1,55 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestTruncateOutput(t *testing.T) {
+	var lines []string
+	for i := 1; i <= 10; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+	}
+	output := strings.Join(lines, "\n")
+
+	want := "line 1\nline 2\n... (6 lines omitted) ...\nline 9\nline 10"
+	if got := truncateOutput(output, 4); got != want {
+		t.Errorf("truncateOutput(output, 4) = %q, want %q", got, want)
+	}
+	if got := truncateOutput(output, 10); got != output {
+		t.Errorf("truncateOutput(output, 10) = %q, want the output unchanged", got)
+	}
+	if got := truncateOutput(output, 0); got != output {
+		t.Errorf("truncateOutput(output, 0) = %q, want the output unchanged", got)
+	}
+}
+
+func TestTruncateText(t *testing.T) {
+	if got := truncateText("short", 10); got != "short" {
+		t.Errorf("truncateText(\"short\", 10) = %q, want it unchanged", got)
+	}
+	s := strings.Repeat("é", 20)
+	got := truncateText(s, 25)
+	if len(got) > 25 {
+		t.Errorf("truncateText returned %d bytes, want at most 25", len(got))
+	}
+	if !strings.HasSuffix(got, "\n... (truncated)") {
+		t.Errorf("truncateText(%q, 25) = %q, want a truncation marker", s, got)
+	}
+	if !strings.HasPrefix(got, "éééé\n") {
+		t.Errorf("truncateText(%q, 25) = %q, want whole characters before the marker", s, got)
+	}
+}
+
+func TestCleanLine(t *testing.T) {
+	line := "\x1b[31mERROR:\x1b[0m \x1b]8;;https://example.com\x07foo.go\x1b]8;;\x07:1:2: \x1b[1;33mbad\x1b[m"
+	if got, want := cleanLine(line), "ERROR: foo.go:1:2: bad"; got != want {
+		t.Errorf("cleanLine(%q) = %q, want %q", line, got, want)
+	}
+
+	setTestSettings(t, func(s *Settings) { s.StripANSI = false })
+	if got := cleanLine(line); got != line {
+		t.Errorf("cleanLine(%q) = %q with StripANSI disabled, want it unchanged", line, got)
+	}
+}
@@ -0,0 +1,124 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const bazelPolicyCheck = "bazel-policy"
+
+// queryLocationRegex matches a line of `bazel query --output=location`, e.g.
+// "/tmp/repo/app/BUILD:12:1: go_library rule //app:lib".
+var queryLocationRegex = regexp.MustCompile(`^(?P<file>.+):(?P<line>\d+):\d+: \S+ rule (?P<label>\S+)$`)
+
+func init() {
+	RegisterChecker(&funcChecker{
+		name:         bazelPolicyCheck,
+		fn:           checkBazelPolicy,
+		optIn:        true,
+		requirements: CheckRequirements{Binaries: []string{"bb"}},
+		rule:         CheckRule{Description: "Bazel targets don't depend on targets forbidden by the dependency policies."},
+	})
+}
+
+// policyViolationQuery returns a query for the targets matching policy.From
+// that introduce a forbidden dependency: those with a direct dependency on a
+// target outside of policy.From that is on a path to policy.Forbidden.
+func policyViolationQuery(policy *DependencyPolicy) string {
+	return fmt.Sprintf("let from = %s in let p = allpaths($from, %s) in rdeps($p intersect $from, $p except $from, 1) intersect $from",
+		policy.From, policy.Forbidden)
+}
+
+// checkBazelPolicy runs the dependency policies configured in .reviewbot.yaml
+// and annotates the BUILD rules introducing forbidden dependencies.
+func checkBazelPolicy(ctx context.Context, _ *GithubApp, target *CheckTarget) (*Result, error) {
+	res := &Result{
+		Title: "Dependency policy result",
+	}
+	policies := target.Config.Policies
+	if len(policies) == 0 {
+		res.Summary = fmt.Sprintf("No dependency policies are configured in %s.", repoConfigFile)
+		res.Conclusion = "neutral"
+		return res, nil
+	}
+
+	annotations := []*Annotation{}
+	var b strings.Builder
+	queryErrors := 0
+	for i, policy := range policies {
+		name := policy.Name
+		if name == "" {
+			name = fmt.Sprintf("policy %d", i+1)
+		}
+		if policy.From == "" || policy.Forbidden == "" {
+			return nil, fmt.Errorf("%s needs both from and forbidden", name)
+		}
+		stdOut, stdErr, err := runCheckCmd(ctx, target, "bb", "query", "--output=location", policyViolationQuery(policy))
+		if err != nil {
+			// A policy that can't be evaluated doesn't hold as far as we
+			// know, e.g. because its labels no longer exist.
+			logFrom(ctx).Warnw("policy query failed", "policy", name, "error", err)
+			fmt.Fprintf(&b, "- %s (`%s` must not depend on `%s`): ⚠️ query failed: %s\n", name, policy.From, policy.Forbidden, queryError(err, &stdErr))
+			queryErrors++
+			continue
+		}
+		violations := 0
+		for _, line := range strings.Split(stdOut.String(), "\n") {
+			m := queryLocationRegex.FindStringSubmatch(strings.TrimSpace(cleanLine(line)))
+			if m == nil {
+				continue
+			}
+			path := m[queryLocationRegex.SubexpIndex("file")]
+			if rel, err := filepath.Rel(target.Dir, path); err == nil && !strings.HasPrefix(rel, "..") {
+				path = rel
+			}
+			lineNum, _ := strconv.Atoi(m[queryLocationRegex.SubexpIndex("line")])
+			label := m[queryLocationRegex.SubexpIndex("label")]
+			annotations = append(annotations, &Annotation{
+				Message:  fmt.Sprintf("%s depends on %s, which %s forbids for %s", label, policy.Forbidden, name, policy.From),
+				Severity: "failure",
+				Path:     filepath.ToSlash(path),
+				Line:     lineNum,
+			})
+			violations++
+		}
+		status := "✅"
+		if violations > 0 {
+			status = fmt.Sprintf("❌ %d violating targets", violations)
+		}
+		fmt.Fprintf(&b, "- %s (`%s` must not depend on `%s`): %s\n", name, policy.From, policy.Forbidden, status)
+	}
+
+	if queryErrors > 0 {
+		res.Summary = fmt.Sprintf("%d dependency policies couldn't be evaluated and %d targets violate them.\n\n%s", queryErrors, len(annotations), b.String())
+		res.Conclusion = "failure"
+		res.Annotations = annotations
+		return res, nil
+	}
+	if len(annotations) == 0 {
+		res.Summary = fmt.Sprintf("All %d dependency policies hold.\n\n%s", len(policies), b.String())
+		res.Conclusion = "success"
+		return res, nil
+	}
+	res.Summary = fmt.Sprintf("%d targets violate dependency policies.\n\n%s", len(annotations), b.String())
+	res.Conclusion = "failure"
+	res.Annotations = annotations
+	return res, nil
+}
+
+// queryError returns the last error bazel reported on stderr, which names the
+// problem with the query, or err if there's none.
+func queryError(err error, stdErr *bytes.Buffer) string {
+	lines := strings.Split(strings.TrimSpace(cleanLine(stdErr.String())), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		if strings.HasPrefix(lines[i], "ERROR: ") {
+			return strings.TrimPrefix(lines[i], "ERROR: ")
+		}
+	}
+	return err.Error()
+}
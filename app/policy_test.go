@@ -0,0 +1,133 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPolicyViolationQuery(t *testing.T) {
+	got := policyViolationQuery(&DependencyPolicy{From: "//app/...", Forbidden: "//experimental/..."})
+	want := "let from = //app/... in let p = allpaths($from, //experimental/...) in rdeps($p intersect $from, $p except $from, 1) intersect $from"
+	if got != want {
+		t.Errorf("got query %q, want %q", got, want)
+	}
+}
+
+func TestCheckBazelPolicy(t *testing.T) {
+	queries := filepath.Join(t.TempDir(), "queries")
+	// Only queries for //experimental have results.
+	installFakeTool(t, "bb", `for q; do :; done
+echo "$q" >> `+queries+`
+case "$q" in
+*experimental*)
+  echo "$PWD/app/BUILD:12:1: go_library rule //app:lib"
+  echo "Loading: 3 packages loaded"
+  echo "/elsewhere/BUILD:3:1: go_library rule @dep//:lib"
+  ;;
+esac
+`)
+	dir := t.TempDir()
+	target := &CheckTarget{Dir: dir, Config: &CheckConfig{Policies: []*DependencyPolicy{
+		{Name: "no-experimental", From: "//app/...", Forbidden: "//experimental/..."},
+		{From: "//lib/...", Forbidden: "//app/..."},
+	}}}
+	res, err := checkBazelPolicy(context.Background(), nil, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Conclusion != "failure" {
+		t.Errorf("got %s, want failure", res.Conclusion)
+	}
+	want := []Annotation{
+		{Path: "app/BUILD", Line: 12, Message: "//app:lib depends on //experimental/..., which no-experimental forbids for //app/...", Severity: "failure"},
+		{Path: "/elsewhere/BUILD", Line: 3, Message: "@dep//:lib depends on //experimental/..., which no-experimental forbids for //app/...", Severity: "failure"},
+	}
+	if len(res.Annotations) != len(want) {
+		t.Fatalf("got annotations %v, want %d", res.Annotations, len(want))
+	}
+	for i, a := range res.Annotations {
+		if *a != want[i] {
+			t.Errorf("annotation %d: got %+v, want %+v", i, *a, want[i])
+		}
+	}
+	for _, line := range []string{
+		"- no-experimental (`//app/...` must not depend on `//experimental/...`): ❌ 2 violating targets",
+		"- policy 2 (`//lib/...` must not depend on `//app/...`): ✅",
+	} {
+		if !strings.Contains(res.Summary, line) {
+			t.Errorf("summary %q doesn't contain %q", res.Summary, line)
+		}
+	}
+	b, err := os.ReadFile(queries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(b), "\n"); n != 2 {
+		t.Errorf("ran %d queries, want one per policy", n)
+	}
+}
+
+func TestCheckBazelPolicyWithoutPolicies(t *testing.T) {
+	res, err := checkBazelPolicy(context.Background(), nil, &CheckTarget{Dir: t.TempDir(), Config: &CheckConfig{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Conclusion != "neutral" {
+		t.Errorf("got %s without policies, want neutral", res.Conclusion)
+	}
+}
+
+func TestCheckBazelPolicyWithIncompletePolicy(t *testing.T) {
+	target := &CheckTarget{Dir: t.TempDir(), Config: &CheckConfig{Policies: []*DependencyPolicy{{Name: "half", From: "//app/..."}}}}
+	if _, err := checkBazelPolicy(context.Background(), nil, target); err == nil || !strings.Contains(err.Error(), "half") {
+		t.Errorf("got error %v, want the incomplete policy to be named", err)
+	}
+}
+
+func TestCheckBazelPolicyWithFailedQuery(t *testing.T) {
+	// Only the query of the missing package fails.
+	installFakeTool(t, "bb", `for q; do :; done
+case "$q" in
+*missing*)
+  echo "Loading: 0 packages loaded" >&2
+  echo "ERROR: no such package 'missing': BUILD file not found" >&2
+  exit 7
+  ;;
+esac
+`)
+	target := &CheckTarget{Dir: t.TempDir(), Config: &CheckConfig{Policies: []*DependencyPolicy{
+		{Name: "gone", From: "//app/...", Forbidden: "//missing/..."},
+		{Name: "fine", From: "//app/...", Forbidden: "//x/..."},
+	}}}
+	res, err := checkBazelPolicy(context.Background(), nil, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Conclusion != "failure" {
+		t.Errorf("got %s, want failure although no targets violate the policies", res.Conclusion)
+	}
+	for _, want := range []string{
+		"1 dependency policies couldn't be evaluated and 0 targets violate them.",
+		"- gone (`//app/...` must not depend on `//missing/...`): ⚠️ query failed: no such package 'missing': BUILD file not found",
+		"- fine (`//app/...` must not depend on `//x/...`): ✅",
+	} {
+		if !strings.Contains(res.Summary, want) {
+			t.Errorf("summary %q doesn't contain %q", res.Summary, want)
+		}
+	}
+}
+
+func TestQueryError(t *testing.T) {
+	stdErr := bytes.NewBufferString("ERROR: first\nINFO: then\nERROR: last\n")
+	if got := queryError(errors.New("exit status 7"), stdErr); got != "last" {
+		t.Errorf("got %q, want the last error bazel reported", got)
+	}
+	if got := queryError(errors.New("exit status 7"), &bytes.Buffer{}); got != "exit status 7" {
+		t.Errorf("got %q without errors on stderr, want the exit status", got)
+	}
+}
@@ -0,0 +1,78 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+const prettierCheck = "prettier"
+
+func init() {
+	RegisterChecker(&funcChecker{
+		name:         prettierCheck,
+		fn:           checkPrettier,
+		fixFn:        fixPrettier,
+		fixMessage:   "Format web sources with Prettier",
+		optIn:        true,
+		requirements: CheckRequirements{Binaries: []string{"prettier", "git"}},
+		rule:         CheckRule{Description: "Files are formatted with prettier.", HelpURI: "https://prettier.io/docs/en/rationale.html"},
+	})
+}
+
+// checkPrettier lists the files that Prettier would reformat. Which files are
+// checked is up to the repository's Prettier configuration and
+// .prettierignore.
+func checkPrettier(ctx context.Context, _ *GithubApp, target *CheckTarget) (*Result, error) {
+	tool := target.Config.ToolOr("prettier")
+	args := append([]string{"--list-different"}, target.Config.Flags...)
+	// Prettier exits with 1 when files differ and 2 when it fails.
+	stdOut, stdErr, err := runCheckCmd(ctx, target, tool, append(args, ".")...)
+	if code := exitCode(err); code != 0 && (code != 1 || stdOut.Len() == 0) {
+		return toolFailure(tool, err.Error(), stdErr.String()), nil
+	}
+
+	annotations := []*Annotation{}
+	scanner := bufio.NewScanner(&stdOut)
+	for scanner.Scan() {
+		path := strings.TrimSpace(cleanLine(scanner.Text()))
+		if path == "" {
+			continue
+		}
+		path = filepath.ToSlash(filepath.Clean(path))
+		annotations = append(annotations, &Annotation{
+			Message:   fmt.Sprintf("file %q needs reformat with %s", path, tool),
+			Severity:  "failure",
+			Path:      path,
+			Line:      1,
+			WholeFile: true,
+		})
+	}
+	annotations = target.Config.filterAnnotations(annotations)
+
+	res := &Result{
+		Title: fmt.Sprintf("%s result", tool),
+	}
+	if len(annotations) == 0 {
+		res.Summary = "No issues found."
+		res.Conclusion = "success"
+		return res, nil
+	}
+	res.Summary = fmt.Sprintf("%d files need reformat", len(annotations))
+	res.Conclusion = "failure"
+	res.Annotations = annotations
+	res.Action = newFixAction(prettierCheck, fmt.Sprintf("Automatically reformat with %s.", tool))
+	return res, nil
+}
+
+// fixPrettier reformats the files of the checkout in place.
+func fixPrettier(ctx context.Context, _ *GithubApp, target *CheckTarget) error {
+	args := append([]string{"--write"}, target.Config.Flags...)
+	_, stdErr, err := runCheckCmd(ctx, target, target.Config.ToolOr("prettier"), append(args, ".")...)
+	if err != nil {
+		return fmt.Errorf("failed to reformat: %s: %s", err, stdErr.String())
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package app
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckPrettier(t *testing.T) {
+	tool := writeFakeTool(t, "printf '%s\\n' src/app.ts ./styles/main.css; exit 1\n")
+	target := &CheckTarget{Dir: t.TempDir(), Config: &CheckConfig{Tool: tool}}
+	res, err := checkPrettier(context.Background(), nil, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Conclusion != "failure" || res.Summary != "2 files need reformat" {
+		t.Errorf("got %s with summary %q, want a failure for 2 files", res.Conclusion, res.Summary)
+	}
+	want := []string{"src/app.ts", "styles/main.css"}
+	if len(res.Annotations) != len(want) {
+		t.Fatalf("got %d annotations, want %d", len(res.Annotations), len(want))
+	}
+	for i, a := range res.Annotations {
+		if a.Path != want[i] || a.Line != 1 || a.Severity != "failure" {
+			t.Errorf("annotation %d: got %+v, want a failure on line 1 of %s", i, *a, want[i])
+		}
+	}
+	if res.Action == nil || res.Action.Identifier != fixIdentifier(prettierCheck) {
+		t.Errorf("got action %+v, want the prettier fix", res.Action)
+	}
+}
+
+func TestCheckPrettierWithFormattedFiles(t *testing.T) {
+	tool := writeFakeTool(t, "exit 0\n")
+	res, err := checkPrettier(context.Background(), nil, &CheckTarget{Dir: t.TempDir(), Config: &CheckConfig{Tool: tool}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Conclusion != "success" {
+		t.Errorf("got conclusion %q, want success", res.Conclusion)
+	}
+}
+
+func TestFixPrettier(t *testing.T) {
+	tool := writeFakeTool(t, `[ "$1" = --write ] && [ "$2" = . ] && echo 'const x = 1;' > app.ts`)
+	dir := t.TempDir()
+	writeTestFile(t, dir, "app.ts", "const x=1\n")
+	if err := fixPrettier(context.Background(), nil, &CheckTarget{Dir: dir, Config: &CheckConfig{Tool: tool}}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "app.ts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "const x = 1;\n" {
+		t.Errorf("app.ts has %q after the fix, want it reformatted", b)
+	}
+}
+
+func TestPrettierReportsToolFailures(t *testing.T) {
+	testToolFailures(t, checkPrettier, map[string]string{
+		"echo '[error] Invalid configuration' >&2; exit 2": "[error] Invalid configuration",
+		"echo '[error] Invalid configuration' >&2; exit 1": "exit status 1",
+		// Prettier exits with 2 when it fails, even if it listed files.
+		"echo src/app.ts; exit 2": "exit status 2",
+	})
+}
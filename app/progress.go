@@ -0,0 +1,167 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v43/github"
+)
+
+// bazelActionsRegex matches the "[done / total]" action counts bazel prefixes
+// its progress messages with.
+var bazelActionsRegex = regexp.MustCompile(`\[([\d,]+) / ([\d,]+)\]`)
+
+// buildProgress is the state of a running build, as far as its build event
+// stream tells.
+type buildProgress struct {
+	targetsConfigured int
+	targetsCompleted  int
+	targetsFailed     int
+	// actionsDone and actionsTotal are the action counts of bazel's latest
+	// progress message.
+	actionsDone  int64
+	actionsTotal int64
+}
+
+func (p *buildProgress) summary(elapsed time.Duration) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Running for %s.\n\n", elapsed.Round(time.Second))
+	fmt.Fprintf(&b, "- %d targets analyzed\n", p.targetsConfigured)
+	fmt.Fprintf(&b, "- %d targets completed", p.targetsCompleted)
+	if p.targetsFailed > 0 {
+		fmt.Fprintf(&b, ", %d failed", p.targetsFailed)
+	}
+	b.WriteString("\n")
+	if p.actionsTotal > 0 {
+		fmt.Fprintf(&b, "- %d / %d actions completed\n", p.actionsDone, p.actionsTotal)
+	}
+	return b.String()
+}
+
+// bepProgressReader follows a build event JSON file while bazel writes it.
+type bepProgressReader struct {
+	path   string
+	offset int64
+	// partial is the start of an event bazel didn't finish writing yet.
+	partial  []byte
+	progress buildProgress
+}
+
+// update reads the events written since the last call.
+func (r *bepProgressReader) update() error {
+	f, err := os.Open(r.path)
+	if os.IsNotExist(err) {
+		// Bazel didn't start the build yet.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Seek(r.offset, io.SeekStart); err != nil {
+		return err
+	}
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	r.offset += int64(len(b))
+	b = append(r.partial, b...)
+	end := bytes.LastIndexByte(b, '\n')
+	r.partial = append([]byte(nil), b[end+1:]...)
+	for _, line := range bytes.Split(b[:end+1], []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		event := &bepEvent{}
+		if err := json.Unmarshal(line, event); err != nil {
+			return fmt.Errorf("failed to parse build event: %s", err)
+		}
+		r.add(event)
+	}
+	return nil
+}
+
+func (r *bepProgressReader) add(event *bepEvent) {
+	p := &r.progress
+	switch {
+	case event.ID.TargetConfigured != nil:
+		p.targetsConfigured++
+	case event.ID.TargetCompleted != nil:
+		p.targetsCompleted++
+		if event.Aborted != nil || (event.Completed != nil && !event.Completed.Success) {
+			p.targetsFailed++
+		}
+	case event.Progress != nil:
+		matches := bazelActionsRegex.FindAllStringSubmatch(event.Progress.Stderr, -1)
+		if len(matches) == 0 {
+			return
+		}
+		last := matches[len(matches)-1]
+		p.actionsDone, _ = strconv.ParseInt(strings.ReplaceAll(last[1], ",", ""), 10, 64)
+		p.actionsTotal, _ = strconv.ParseInt(strings.ReplaceAll(last[2], ",", ""), 10, 64)
+	}
+}
+
+// watchBuildProgress reports the progress of the build writing the build
+// event file at path every ProgressInterval, if the target's check reports
+// progress, until the returned function is called.
+func watchBuildProgress(ctx context.Context, target *CheckTarget, path string) func() {
+	if target.progress == nil || CurrentSettings().ProgressInterval <= 0 {
+		return func() {}
+	}
+	r := &bepProgressReader{path: path}
+	start := time.Now()
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(CurrentSettings().ProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+			if err := r.update(); err != nil {
+				logFrom(ctx).Warnw("failed to read build progress", "error", err)
+				return
+			}
+			target.progress(r.progress.summary(time.Since(start)))
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// progressReporter returns a function that updates the output of a job's
+// in-progress check run with summary.
+func (app *GithubApp) progressReporter(ctx context.Context, job *Job, check *JobCheck) func(summary string) {
+	owner, repo := job.ownerAndRepo()
+	ghc := app.jobClient(job)
+	return func(summary string) {
+		opts := github.UpdateCheckRunOptions{
+			Name:   check.Name,
+			Status: github.String(inProgress),
+			Output: &github.CheckRunOutput{
+				Title:   github.String("In progress"),
+				Summary: github.String(summary),
+			},
+		}
+		_, res, err := ghc.Checks.UpdateCheckRun(ctx, owner, repo, check.CheckRunID, opts)
+		if err := extractError(ctx, res, err); err != nil {
+			logFrom(ctx).Warnw("failed to report progress", "error", err)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v43/github"
+)
+
+const (
+	testTargetConfigured = `{"id":{"targetConfigured":{"label":"//a"}}}`
+	testTargetCompleted  = `{"id":{"targetCompleted":{"label":"//a"}},"completed":{"success":true}}`
+	testTargetFailed     = `{"id":{"targetCompleted":{"label":"//b"}},"completed":{"success":false}}`
+	testProgress         = `{"id":{"progress":{}},"progress":{"stderr":"[1 / 10] Compiling a.go\n[1,200 / 3,400] Compiling b.go"}}`
+)
+
+func TestBuildProgressSummary(t *testing.T) {
+	p := &buildProgress{targetsConfigured: 3, targetsCompleted: 2}
+	if got, want := p.summary(90*time.Second), "Running for 1m30s.\n\n- 3 targets analyzed\n- 2 targets completed\n"; got != want {
+		t.Errorf("got summary %q, want %q", got, want)
+	}
+	p = &buildProgress{targetsConfigured: 3, targetsCompleted: 2, targetsFailed: 1, actionsDone: 5, actionsTotal: 8}
+	if got, want := p.summary(time.Second), "Running for 1s.\n\n- 3 targets analyzed\n- 2 targets completed, 1 failed\n- 5 / 8 actions completed\n"; got != want {
+		t.Errorf("got summary %q, want %q", got, want)
+	}
+}
+
+func TestBEPProgressReader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bep.json")
+	r := &bepProgressReader{path: path}
+	if err := r.update(); err != nil {
+		t.Fatalf("update before bazel wrote the file: %s", err)
+	}
+
+	// The last event isn't completely written yet.
+	events := testTargetConfigured + "\n" + testTargetConfigured + "\n" + testTargetCompleted + "\n" + testProgress
+	if err := os.WriteFile(path, []byte(events[:len(events)-10]), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.update(); err != nil {
+		t.Fatal(err)
+	}
+	if want := (buildProgress{targetsConfigured: 2, targetsCompleted: 1}); r.progress != want {
+		t.Errorf("got progress %+v, want %+v", r.progress, want)
+	}
+
+	if err := os.WriteFile(path, []byte(events+"\n"+testTargetFailed+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.update(); err != nil {
+		t.Fatal(err)
+	}
+	if want := (buildProgress{targetsConfigured: 2, targetsCompleted: 2, targetsFailed: 1, actionsDone: 1200, actionsTotal: 3400}); r.progress != want {
+		t.Errorf("got progress %+v, want %+v", r.progress, want)
+	}
+}
+
+func TestBEPProgressReaderInvalidEvent(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "bep.json", "not json\n")
+	if err := (&bepProgressReader{path: filepath.Join(dir, "bep.json")}).update(); err == nil {
+		t.Errorf("update of an invalid event succeeded")
+	}
+}
+
+func TestWatchBuildProgress(t *testing.T) {
+	setTestSettings(t, func(s *Settings) { s.ProgressInterval = time.Millisecond })
+	dir := t.TempDir()
+	writeTestFile(t, dir, "bep.json", testTargetConfigured+"\n")
+	var mu sync.Mutex
+	var summaries []string
+	target := &CheckTarget{progress: func(summary string) {
+		mu.Lock()
+		defer mu.Unlock()
+		summaries = append(summaries, summary)
+	}}
+
+	stop := watchBuildProgress(context.Background(), target, filepath.Join(dir, "bep.json"))
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		n := len(summaries)
+		mu.Unlock()
+		if n > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no progress was reported")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	stop()
+	mu.Lock()
+	defer mu.Unlock()
+	if !strings.Contains(summaries[0], "- 1 targets analyzed") {
+		t.Errorf("got summary %q, want the configured target counted", summaries[0])
+	}
+	n := len(summaries)
+	time.Sleep(10 * time.Millisecond)
+	if len(summaries) != n {
+		t.Errorf("progress was reported after stopping")
+	}
+}
+
+func TestWatchBuildProgressWithoutReporter(t *testing.T) {
+	// Stopping is a no-op if the check doesn't report progress.
+	watchBuildProgress(context.Background(), &CheckTarget{}, filepath.Join(t.TempDir(), "bep.json"))()
+}
+
+func TestProgressReporter(t *testing.T) {
+	f := newFakeGitHub(t)
+	var updates []*github.UpdateCheckRunOptions
+	f.handle("PATCH /repos/o/r/check-runs/7", func(w http.ResponseWriter, req *http.Request) {
+		opts := &github.UpdateCheckRunOptions{}
+		if err := json.NewDecoder(req.Body).Decode(opts); err != nil {
+			t.Errorf("failed to decode check run update: %s", err)
+		}
+		updates = append(updates, opts)
+		writeTestJSON(w, http.StatusOK, map[string]interface{}{"id": 7})
+	})
+	app := newTestApp(t, f)
+	job := &Job{InstallationID: testInstallationID, FullRepoName: "o/r", HeadSHA: "abc", Token: "job-token"}
+
+	app.progressReporter(context.Background(), job, &JobCheck{Name: "bazel", CheckRunID: 7})("Running for 1s.")
+	if len(updates) != 1 {
+		t.Fatalf("updated the check run %d times, want once", len(updates))
+	}
+	if u := updates[0]; u.GetStatus() != inProgress || u.Output.GetTitle() != "In progress" || u.Output.GetSummary() != "Running for 1s." || u.Conclusion != nil {
+		t.Errorf("got update %+v, want the summary on the in-progress check run", u)
+	}
+}
@@ -0,0 +1,375 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// Provider is a code host other than GitHub that the registered checks run
+// for. GithubApp talks to GitHub directly, since check runs offer more than
+// other hosts, like annotations and actions. A ProviderApp handles the
+// provider's webhooks with the parts they have in common.
+type Provider interface {
+	// Name identifies the provider, e.g. "gitlab".
+	Name() string
+	// ParseWebhook validates a webhook request and returns what it asks the
+	// bot to do, or nil if the event doesn't concern the bot.
+	ParseWebhook(ctx context.Context, req *http.Request) (*CheckRequest, error)
+	// CloneURL returns the URL to clone a repository from, without
+	// credentials, and the credentials to clone it with.
+	CloneURL(ctx context.Context, repo string) (string, transport.AuthMethod, error)
+	// MergeRequestRefSpec fetches the heads of merge requests, which are the
+	// only refs reaching the commits of merge requests from forks.
+	MergeRequestRefSpec() config.RefSpec
+	// ReportStatus reports the status of a check on the request's commit.
+	// result is nil while the check runs.
+	ReportStatus(ctx context.Context, req *CheckRequest, checkName string, result *Result) error
+	// Comment posts a comment on the request's merge request.
+	Comment(ctx context.Context, req *CheckRequest, body string) error
+	// PushBranch pushes branch of r to the repository.
+	PushBranch(ctx context.Context, r *git.Repository, repo string, branch string) error
+}
+
+// InlineCommenter is implemented by providers that can comment on lines of a
+// merge request. The annotations of failed checks are then posted inline, in
+// addition to the summary comment.
+type InlineCommenter interface {
+	// CommentLines posts annotations of checkName on the request's merge
+	// request, skipping those already posted.
+	CommentLines(ctx context.Context, req *CheckRequest, checkName string, annotations []*Annotation) error
+}
+
+// ResultReporter is implemented by providers that report the results of all
+// checks on a commit at once, like a Gerrit review, instead of commenting a
+// summary of the failed checks.
+type ResultReporter interface {
+	// ReportResults reports the results of the checks run for the request, by
+	// check name.
+	ReportResults(ctx context.Context, req *CheckRequest, results map[string]*Result) error
+}
+
+// Poller is implemented by providers that can be polled for commits to check,
+// e.g. when they can't send webhooks to the bot.
+type Poller interface {
+	// Poll returns the requests for the commits that need checking and
+	// weren't returned by a previous call.
+	Poll(ctx context.Context) ([]*CheckRequest, error)
+}
+
+// CheckRequest asks a ProviderApp to run checks on a commit, or to push the
+// fix of a check to a branch.
+type CheckRequest struct {
+	// Repo is the full path of the repository, e.g. "group/project".
+	Repo string
+	SHA  string
+	// BaseSHA is the head of the merge request's target branch, or "" if it
+	// isn't known.
+	BaseSHA string
+	// Branch is the branch whose head is SHA, if it's known.
+	Branch string
+	// Ref is fetched for SHA instead of MergeRequestRefSpec, if set, e.g. the
+	// ref of a Gerrit patchset.
+	Ref string
+	// MergeRequest is the number of the merge request of SHA, or 0 if there
+	// is none.
+	MergeRequest int
+	// Checks are the checks to run. Empty runs every registered check that
+	// the repository enables.
+	Checks []string
+	// Fix is the check whose fix is pushed to Branch, instead of running
+	// checks.
+	Fix string
+}
+
+// ProviderApp runs the registered checks for the webhooks of a Provider.
+type ProviderApp struct {
+	provider Provider
+	// runner runs the checkers, which only need it for the BuildBuddy API
+	// keys and cancellation.
+	runner *GithubApp
+}
+
+// NewProviderApp returns an app running checks for provider.
+func NewProviderApp(provider Provider, bbAPIKeys SecretProvider) *ProviderApp {
+	return &ProviderApp{
+		provider: provider,
+		runner:   NewWorker(bbAPIKeys),
+	}
+}
+
+// HandleWebhook acts on a webhook of the provider in the background, since
+// clones and builds take longer than webhooks wait for.
+func (p *ProviderApp) HandleWebhook(w http.ResponseWriter, req *http.Request) {
+	ctx := withLogFields(context.Background(), "provider", p.provider.Name())
+	creq, err := p.provider.ParseWebhook(ctx, req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if creq == nil {
+		return
+	}
+	go p.handle(ctx, creq)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Poll asks the provider, which must implement Poller, for commits to check
+// every interval until ctx is done, and checks them in the background.
+func (p *ProviderApp) Poll(ctx context.Context, interval time.Duration) {
+	poller := p.provider.(Poller)
+	ctx = withLogFields(ctx, "provider", p.provider.Name())
+	for {
+		creqs, err := poller.Poll(ctx)
+		if err != nil {
+			logFrom(ctx).Warnw("failed to poll", "error", err)
+		}
+		for _, creq := range creqs {
+			go p.handle(ctx, creq)
+		}
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// handle runs the checks or the fix asked for by creq.
+func (p *ProviderApp) handle(ctx context.Context, creq *CheckRequest) {
+	ctx = withLogFields(ctx, "repo", creq.Repo, "sha", creq.SHA)
+	var err error
+	if creq.Fix != "" {
+		err = p.fix(ctx, creq)
+	} else {
+		err = p.runChecks(ctx, creq)
+	}
+	if err != nil {
+		logFrom(ctx).Errorw("error handling event", "error", err)
+	}
+}
+
+// cacheName identifies a repository of the provider in RepoCacheDir and
+// WorkspaceDir, apart from GitHub repositories with the same path.
+func (p *ProviderApp) cacheName(repo string) string {
+	return p.provider.Name() + "/" + repo
+}
+
+// clone checks ref of the request's repository out into dir.
+func (p *ProviderApp) clone(ctx context.Context, creq *CheckRequest, ref GitRef, dir string) (*git.Repository, error) {
+	url, auth, err := p.provider.CloneURL(ctx, creq.Repo)
+	if err != nil {
+		return nil, err
+	}
+	refSpec := p.provider.MergeRequestRefSpec()
+	if creq.Ref != "" {
+		refSpec = config.RefSpec("+" + creq.Ref + ":" + creq.Ref)
+	}
+	r, err := cloneRepoFromURL(ctx, url, auth, p.cacheName(creq.Repo), ref, dir, refSpec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to clone repo: %s", err)
+	}
+	return r, nil
+}
+
+// runChecks runs the requested checks concurrently against one checkout and
+// reports their statuses. Failures are summarized in a comment on the merge
+// request.
+func (p *ProviderApp) runChecks(ctx context.Context, creq *CheckRequest) error {
+	dir, err := allocWorkspace(ctx, p.cacheName(creq.Repo), creq.SHA, "checks")
+	if err != nil {
+		return err
+	}
+	defer releaseWorkspace(ctx, dir)
+	if _, err := p.clone(ctx, creq, GitRef{hash: creq.SHA, history: creq.BaseSHA != ""}, dir); err != nil {
+		return err
+	}
+	config, err := loadRepoConfigFromDir(dir)
+	if err != nil {
+		return err
+	}
+	var changed []string
+	if creq.BaseSHA != "" {
+		changed, err = changedFiles(dir, creq.BaseSHA, creq.SHA)
+		if err != nil {
+			logFrom(ctx).Warnw("failed to list changed files", "error", err)
+		}
+	}
+
+	checkNames := creq.Checks
+	if len(checkNames) == 0 {
+		checkNames = registeredChecks()
+	}
+	checkNames = config.expandMatrix(checkNames)
+	var mu sync.Mutex
+	results := make(map[string]*Result)
+	var wg sync.WaitGroup
+	for _, checkName := range checkNames {
+		checker, err := GetChecker(checkName)
+		if err != nil {
+			return err
+		}
+		cc := config.Check(checkName)
+		if !cc.IsEnabled(enabledByDefault(checker)) {
+			continue
+		}
+		if len(cc.TriggerPaths) > 0 && changed != nil && !cc.triggeredBy(changed) {
+			// Report the skip, so the status of a required check doesn't
+			// stay pending.
+			result := &Result{Title: skippedSummary(cc.TriggerPaths), Conclusion: "neutral"}
+			if err := p.provider.ReportStatus(ctx, creq, checkName, result); err != nil {
+				logFrom(ctx).Warnw("failed to report skipped status", "check", checkName, "error", err)
+			}
+			continue
+		}
+		target := &CheckTarget{
+			FullRepoName: creq.Repo,
+			HeadSHA:      creq.SHA,
+			BaseSHA:      creq.BaseSHA,
+			Dir:          dir,
+			ChangedFiles: changed,
+			Config:       cc,
+			bazel:        config.Bazel.settings(creq.Branch, checkName),
+		}
+		wg.Add(1)
+		go func(checker Checker) {
+			defer wg.Done()
+			result := p.runCheck(withLogFields(ctx, "check", checker.Name()), creq, checker, target)
+			mu.Lock()
+			results[checker.Name()] = result
+			mu.Unlock()
+		}(checker)
+	}
+	wg.Wait()
+
+	if rr, ok := p.provider.(ResultReporter); ok {
+		if err := rr.ReportResults(ctx, creq, results); err != nil {
+			logFrom(ctx).Warnw("failed to report results", "error", err)
+		}
+	} else if creq.MergeRequest != 0 {
+		if body := providerSummary(creq.SHA, results); body != "" {
+			if err := p.provider.Comment(ctx, creq, body); err != nil {
+				logFrom(ctx).Warnw("failed to post summary comment", "error", err)
+			}
+		}
+	}
+	return nil
+}
+
+// runCheck runs checker against target and reports its status. A check that
+// fails to run is reported as failed.
+func (p *ProviderApp) runCheck(ctx context.Context, creq *CheckRequest, checker Checker, target *CheckTarget) *Result {
+	if err := p.provider.ReportStatus(ctx, creq, checker.Name(), nil); err != nil {
+		logFrom(ctx).Warnw("failed to report running status", "error", err)
+	}
+	result, err := p.runner.runWithTimeout(ctx, checker, target)
+	if err != nil {
+		logFrom(ctx).Errorw("failed to run check", "error", err)
+		result = &Result{
+			Title:      fmt.Sprintf("%s failed to run", checker.Name()),
+			Summary:    err.Error(),
+			Conclusion: "failure",
+		}
+	}
+	if err := p.provider.ReportStatus(ctx, creq, checker.Name(), result); err != nil {
+		logFrom(ctx).Warnw("failed to report status", "error", err)
+	}
+	if ic, ok := p.provider.(InlineCommenter); ok && creq.MergeRequest != 0 && failedConclusion(result.Conclusion) && len(result.Annotations) > 0 {
+		if err := ic.CommentLines(ctx, creq, checker.Name(), result.Annotations); err != nil {
+			logFrom(ctx).Warnw("failed to post inline comments", "error", err)
+		}
+	}
+	logFrom(ctx).Infow("check completed", "conclusion", result.Conclusion)
+	return result
+}
+
+// fix pushes a commit with the fix of creq.Fix to creq.Branch.
+func (p *ProviderApp) fix(ctx context.Context, creq *CheckRequest) error {
+	ctx = withLogFields(ctx, "check", creq.Fix)
+	if creq.Branch == "" {
+		return fmt.Errorf("can't fix %s without a branch", creq.Fix)
+	}
+	dir, err := allocWorkspace(ctx, p.cacheName(creq.Repo), creq.SHA, fixIdentifier(creq.Fix))
+	if err != nil {
+		return err
+	}
+	defer releaseWorkspace(ctx, dir)
+	r, err := p.clone(ctx, creq, GitRef{branch: creq.Branch}, dir)
+	if err != nil {
+		return err
+	}
+	if err := checkoutBranch(r, creq.Branch); err != nil {
+		return err
+	}
+	config, err := loadRepoConfigFromDir(dir)
+	if err != nil {
+		return err
+	}
+	target := &CheckTarget{
+		FullRepoName: creq.Repo,
+		HeadSHA:      creq.SHA,
+		Dir:          dir,
+		Config:       config.Check(creq.Fix),
+	}
+	fixer, err := fixerFor(creq.Fix, fixIdentifier(creq.Fix), target.Config)
+	if err != nil {
+		return err
+	}
+	if err := fixer.Fix(ctx, p.runner, target); err != nil {
+		return fmt.Errorf("failed to fix %s: %s", creq.Fix, err)
+	}
+	author := fixSignature(config.Fix)
+	hash, err := commitAll(r, fixCommitMessage(config.Fix, fixer, creq.Fix, creq.MergeRequest, author), author)
+	if err != nil {
+		return err
+	}
+	logFrom(ctx).Infow("created fix commit", "commit", hash.String())
+	return p.provider.PushBranch(ctx, r, creq.Repo, creq.Branch)
+}
+
+// maxSummaryAnnotations caps the annotations listed per check in the summary
+// comment of a provider.
+const maxSummaryAnnotations = 20
+
+// providerSummary renders the results of the failed checks on sha for a
+// merge request comment, or returns "" if none failed.
+func providerSummary(sha string, results map[string]*Result) string {
+	var names []string
+	for name, result := range results {
+		if failedConclusion(result.Conclusion) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	fmt.Fprintf(&b, "### Review bot results for %s\n", sha)
+	for _, name := range names {
+		result := results[name]
+		fmt.Fprintf(&b, "\n#### ❌ %s: %s\n\n", name, result.Title)
+		if result.Summary != "" {
+			b.WriteString(result.Summary + "\n")
+		}
+		for i, a := range result.Annotations {
+			if i == maxSummaryAnnotations {
+				fmt.Fprintf(&b, "- and %d more\n", len(result.Annotations)-i)
+				break
+			}
+			fmt.Fprintf(&b, "- `%s:%d`: %s\n", a.Path, a.Line, a.Message)
+		}
+		if fix := fixIdentifier(name); result.Action != nil && result.Action.Identifier == fix {
+			fmt.Fprintf(&b, "\nComment `%s fix %s` to push a fix.\n", commandPrefix, name)
+		}
+	}
+	return b.String()
+}
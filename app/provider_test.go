@@ -0,0 +1,402 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// fakeProvider is a Provider whose repositories are local directories, which
+// records what it's asked to report.
+type fakeProvider struct {
+	src string
+	// creq is returned by ParseWebhook.
+	creq *CheckRequest
+
+	mu       sync.Mutex
+	statuses []string
+	comments []string
+	pushed   []string
+	// pushedFiles are the files of the commits pushed.
+	pushedFiles [][]string
+}
+
+func (p *fakeProvider) Name() string { return "fake" }
+
+func (p *fakeProvider) ParseWebhook(ctx context.Context, req *http.Request) (*CheckRequest, error) {
+	if req.Header.Get("X-Fake-Token") != "secret" {
+		return nil, &httpError{statusCode: http.StatusUnauthorized, message: "invalid webhook token"}
+	}
+	return p.creq, nil
+}
+
+func (p *fakeProvider) CloneURL(ctx context.Context, repo string) (string, transport.AuthMethod, error) {
+	return p.src, nil, nil
+}
+
+func (p *fakeProvider) MergeRequestRefSpec() config.RefSpec {
+	return config.RefSpec("+refs/merge-requests/*/head:refs/remotes/origin/merge-requests/*")
+}
+
+func (p *fakeProvider) ReportStatus(ctx context.Context, creq *CheckRequest, checkName string, result *Result) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	status := checkName + ":running"
+	if result != nil {
+		status = checkName + ":" + result.Conclusion
+	}
+	p.statuses = append(p.statuses, status)
+	return nil
+}
+
+func (p *fakeProvider) Comment(ctx context.Context, creq *CheckRequest, body string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.comments = append(p.comments, body)
+	return nil
+}
+
+func (p *fakeProvider) PushBranch(ctx context.Context, r *git.Repository, repo string, branch string) error {
+	head, err := r.Head()
+	if err != nil {
+		return err
+	}
+	commit, err := r.CommitObject(head.Hash())
+	if err != nil {
+		return err
+	}
+	stats, err := commit.Stats()
+	if err != nil {
+		return err
+	}
+	var files []string
+	for _, s := range stats {
+		files = append(files, s.Name)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pushed = append(p.pushed, branch)
+	p.pushedFiles = append(p.pushedFiles, files)
+	return nil
+}
+
+// newTestProviderApp returns an app for a fake provider of a repository with
+// a commit on the feature branch, and that commit's hash.
+func newTestProviderApp(t *testing.T) (*ProviderApp, *fakeProvider, string) {
+	setWorkspaceDir(t, 0)
+	src := t.TempDir()
+	testGit(t, src, "init", "-q", "-b", "main")
+	commitTestFile(t, src, "README", "hello\n")
+	testGit(t, src, "checkout", "-q", "-b", "feature")
+	sha := commitTestFile(t, src, "main.go", "package main\n")
+	testGit(t, src, "checkout", "-q", "main")
+	provider := &fakeProvider{src: src}
+	return NewProviderApp(provider, StaticSecretProvider("")), provider, sha
+}
+
+func TestProviderAppRunChecks(t *testing.T) {
+	registerTestChecker(t, &funcChecker{
+		name: "test-lint",
+		fn: func(_ context.Context, _ *GithubApp, target *CheckTarget) (*Result, error) {
+			if _, err := os.Stat(filepath.Join(target.Dir, "main.go")); err != nil {
+				return nil, err
+			}
+			return &Result{Conclusion: "failure", Title: "1 issue", Annotations: []*Annotation{{Path: "main.go", Line: 1, Message: "unused"}}}, nil
+		},
+	})
+	registerTestChecker(t, &funcChecker{
+		name: "test-fmt",
+		fn: func(context.Context, *GithubApp, *CheckTarget) (*Result, error) {
+			return &Result{Conclusion: "success"}, nil
+		},
+	})
+	p, provider, sha := newTestProviderApp(t)
+
+	creq := &CheckRequest{Repo: "g/p", SHA: sha, MergeRequest: 5, Checks: []string{"test-lint", "test-fmt"}}
+	if err := p.runChecks(context.Background(), creq); err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Join(provider.statuses, " ")
+	for _, want := range []string{"test-lint:running", "test-lint:failure", "test-fmt:running", "test-fmt:success"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("got statuses %q, want %s", got, want)
+		}
+	}
+	if len(provider.comments) != 1 || !strings.Contains(provider.comments[0], "- `main.go:1`: unused") || strings.Contains(provider.comments[0], "test-fmt") {
+		t.Errorf("commented %q, want a summary of the failed check", provider.comments)
+	}
+}
+
+// inlineFakeProvider is a fakeProvider that can comment on lines.
+type inlineFakeProvider struct {
+	*fakeProvider
+	lines []string
+}
+
+func (p *inlineFakeProvider) CommentLines(ctx context.Context, creq *CheckRequest, checkName string, annotations []*Annotation) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, a := range annotations {
+		p.lines = append(p.lines, fmt.Sprintf("%s %s:%d", checkName, a.Path, a.Line))
+	}
+	return nil
+}
+
+func TestProviderAppRunChecksCommentsLines(t *testing.T) {
+	registerTestChecker(t, &funcChecker{
+		name: "test-lint",
+		fn: func(context.Context, *GithubApp, *CheckTarget) (*Result, error) {
+			return &Result{Conclusion: "failure", Annotations: []*Annotation{{Path: "main.go", Line: 1, Message: "unused"}}}, nil
+		},
+	})
+	registerTestChecker(t, &funcChecker{
+		name: "test-fmt",
+		fn: func(context.Context, *GithubApp, *CheckTarget) (*Result, error) {
+			return &Result{Conclusion: "neutral", Annotations: []*Annotation{{Path: "main.go", Line: 2, Message: "style"}}}, nil
+		},
+	})
+	p, provider, sha := newTestProviderApp(t)
+	inline := &inlineFakeProvider{fakeProvider: provider}
+	p.provider = inline
+
+	creq := &CheckRequest{Repo: "g/p", SHA: sha, MergeRequest: 5, Checks: []string{"test-lint", "test-fmt"}}
+	if err := p.runChecks(context.Background(), creq); err != nil {
+		t.Fatal(err)
+	}
+	if len(inline.lines) != 1 || inline.lines[0] != "test-lint main.go:1" {
+		t.Errorf("commented on lines %q, want the annotation of the failed check", inline.lines)
+	}
+}
+
+func TestProviderAppRunChecksReportsErrorsAsFailures(t *testing.T) {
+	registerTestChecker(t, &funcChecker{
+		name: "test-lint",
+		fn: func(context.Context, *GithubApp, *CheckTarget) (*Result, error) {
+			return nil, errors.New("tool crashed")
+		},
+	})
+	p, provider, sha := newTestProviderApp(t)
+
+	// Without a merge request, there's nothing to comment on.
+	if err := p.runChecks(context.Background(), &CheckRequest{Repo: "g/p", SHA: sha, Checks: []string{"test-lint"}}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(provider.statuses, " ") != "test-lint:running test-lint:failure" {
+		t.Errorf("got statuses %q, want test-lint failed", provider.statuses)
+	}
+	if len(provider.comments) != 0 {
+		t.Errorf("commented %q without a merge request", provider.comments)
+	}
+}
+
+func TestProviderAppRunChecksReportsTriggerPathSkips(t *testing.T) {
+	for _, name := range []string{"test-go", "test-py"} {
+		registerTestChecker(t, &funcChecker{
+			name: name,
+			fn: func(context.Context, *GithubApp, *CheckTarget) (*Result, error) {
+				return &Result{Conclusion: "success"}, nil
+			},
+		})
+	}
+	setWorkspaceDir(t, 0)
+	src := t.TempDir()
+	testGit(t, src, "init", "-q", "-b", "main")
+	base := commitTestFile(t, src, ".reviewbot.yaml", `checks:
+  test-go:
+    trigger_paths: ["**/*.go"]
+  test-py:
+    trigger_paths: ["**/*.py"]
+`)
+	sha := commitTestFile(t, src, "main.go", "package main\n")
+	provider := &fakeProvider{src: src}
+	p := NewProviderApp(provider, StaticSecretProvider(""))
+
+	creq := &CheckRequest{Repo: "g/p", SHA: sha, BaseSHA: base, Checks: []string{"test-go", "test-py"}}
+	if err := p.runChecks(context.Background(), creq); err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Join(provider.statuses, " ")
+	for _, want := range []string{"test-go:running", "test-go:success", "test-py:neutral"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("got statuses %q, want %s", got, want)
+		}
+	}
+	if strings.Contains(got, "test-py:running") {
+		t.Errorf("got statuses %q, want test-py skipped without running", got)
+	}
+}
+
+// reportingFakeProvider is a fakeProvider that reports all results at once.
+type reportingFakeProvider struct {
+	*fakeProvider
+	reported map[string]*Result
+}
+
+func (p *reportingFakeProvider) ReportResults(ctx context.Context, creq *CheckRequest, results map[string]*Result) error {
+	p.reported = results
+	return nil
+}
+
+func TestProviderAppRunChecksReportsResults(t *testing.T) {
+	registerTestChecker(t, &funcChecker{
+		name: "test-lint",
+		fn: func(_ context.Context, _ *GithubApp, target *CheckTarget) (*Result, error) {
+			b, err := os.ReadFile(filepath.Join(target.Dir, "main.go"))
+			if err != nil {
+				return nil, err
+			}
+			return &Result{Conclusion: "failure", Title: string(b)}, nil
+		},
+	})
+	p, provider, _ := newTestProviderApp(t)
+	reporter := &reportingFakeProvider{fakeProvider: provider}
+	p.provider = reporter
+	// The patchset is only reachable from its change ref.
+	testGit(t, provider.src, "checkout", "-q", "-b", "patchset")
+	patchset := commitTestFile(t, provider.src, "main.go", "package patchset\n")
+	testGit(t, provider.src, "update-ref", "refs/changes/05/5/2", patchset)
+	testGit(t, provider.src, "checkout", "-q", "main")
+	testGit(t, provider.src, "branch", "-q", "-D", "patchset")
+
+	creq := &CheckRequest{Repo: "g/p", SHA: patchset, Ref: "refs/changes/05/5/2", MergeRequest: 5, Checks: []string{"test-lint"}}
+	if err := p.runChecks(context.Background(), creq); err != nil {
+		t.Fatal(err)
+	}
+	if r := reporter.reported["test-lint"]; len(reporter.reported) != 1 || r == nil || r.Title != "package patchset\n" {
+		t.Errorf("reported %+v, want the result of test-lint on the patchset", reporter.reported)
+	}
+	if len(provider.comments) != 0 {
+		t.Errorf("commented %q, want the results reported instead", provider.comments)
+	}
+}
+
+// pollingFakeProvider is a fakeProvider returning a request on the first
+// poll.
+type pollingFakeProvider struct {
+	*fakeProvider
+	polls chan *CheckRequest
+}
+
+func (p *pollingFakeProvider) Poll(ctx context.Context) ([]*CheckRequest, error) {
+	select {
+	case creq := <-p.polls:
+		return []*CheckRequest{creq}, nil
+	default:
+		return nil, nil
+	}
+}
+
+func TestProviderAppPoll(t *testing.T) {
+	checked := make(chan string, 1)
+	registerTestChecker(t, &funcChecker{
+		name: "test-lint",
+		fn: func(_ context.Context, _ *GithubApp, target *CheckTarget) (*Result, error) {
+			checked <- target.HeadSHA
+			return &Result{Conclusion: "success"}, nil
+		},
+	})
+	p, provider, sha := newTestProviderApp(t)
+	poller := &pollingFakeProvider{fakeProvider: provider, polls: make(chan *CheckRequest, 1)}
+	p.provider = poller
+	poller.polls <- &CheckRequest{Repo: "g/p", SHA: sha, Checks: []string{"test-lint"}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.Poll(ctx, time.Millisecond)
+	select {
+	case got := <-checked:
+		if got != sha {
+			t.Errorf("checked %s, want the polled commit %s", got, sha)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("polled commit wasn't checked")
+	}
+}
+
+func TestProviderAppFix(t *testing.T) {
+	registerTestChecker(t, &funcChecker{
+		name: "test-lint",
+		fixFn: func(_ context.Context, _ *GithubApp, target *CheckTarget) error {
+			return os.WriteFile(filepath.Join(target.Dir, "main.go"), []byte("package main // fixed\n"), 0644)
+		},
+		fixMessage: "Fix lint",
+	})
+	p, provider, sha := newTestProviderApp(t)
+
+	if err := p.fix(context.Background(), &CheckRequest{Repo: "g/p", SHA: sha, Branch: "feature", MergeRequest: 5, Fix: "test-lint"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(provider.pushed) != 1 || provider.pushed[0] != "feature" {
+		t.Fatalf("pushed %q, want the feature branch", provider.pushed)
+	}
+	if files := provider.pushedFiles[0]; len(files) != 1 || files[0] != "main.go" {
+		t.Errorf("pushed a commit changing %q, want the fixed main.go", files)
+	}
+}
+
+func TestProviderAppFixWithoutBranch(t *testing.T) {
+	p, _, sha := newTestProviderApp(t)
+	if err := p.fix(context.Background(), &CheckRequest{Repo: "g/p", SHA: sha, Fix: "test-lint"}); err == nil {
+		t.Errorf("fix without a branch succeeded")
+	}
+}
+
+func TestProviderAppHandleWebhook(t *testing.T) {
+	p, provider, _ := newTestProviderApp(t)
+
+	w := httptest.NewRecorder()
+	p.HandleWebhook(w, httptest.NewRequest(http.MethodPost, "/fake/event_handler", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d for an invalid webhook, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/fake/event_handler", nil)
+	req.Header.Set("X-Fake-Token", "secret")
+	w = httptest.NewRecorder()
+	p.HandleWebhook(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("got status %d for an event that doesn't concern the bot, want %d", w.Code, http.StatusOK)
+	}
+	if len(provider.statuses) != 0 {
+		t.Errorf("reported statuses %q for an ignored event", provider.statuses)
+	}
+}
+
+func TestProviderSummary(t *testing.T) {
+	var annotations []*Annotation
+	for i := 0; i < maxSummaryAnnotations+2; i++ {
+		annotations = append(annotations, &Annotation{Path: "main.go", Line: i + 1, Message: "unused"})
+	}
+	summary := providerSummary("abc", map[string]*Result{
+		"test-lint": {Conclusion: "failure", Title: "22 issues", Summary: "Lint failed.", Annotations: annotations, Action: newFixAction("test-lint", "")},
+		"test-fmt":  {Conclusion: "success", Title: "ok"},
+	})
+	for _, want := range []string{
+		"### Review bot results for abc\n",
+		"#### ❌ test-lint: 22 issues\n\nLint failed.\n",
+		"- `main.go:20`: unused\n- and 2 more\n",
+		"Comment `/reviewbot fix test-lint` to push a fix.",
+	} {
+		if !strings.Contains(summary, want) {
+			t.Errorf("summary %q doesn't contain %q", summary, want)
+		}
+	}
+	if strings.Contains(summary, "test-fmt") {
+		t.Errorf("summary %q lists a successful check", summary)
+	}
+	if summary := providerSummary("abc", map[string]*Result{"test-fmt": {Conclusion: "success"}}); summary != "" {
+		t.Errorf("got summary %q without failures, want none", summary)
+	}
+}
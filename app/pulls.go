@@ -0,0 +1,160 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v43/github"
+)
+
+// pullTrackerTTL is how long the pull request for a head SHA is remembered
+// after its last pull_request event.
+const pullTrackerTTL = 24 * time.Hour
+
+type trackedPull struct {
+	pr      *github.PullRequest
+	expires time.Time
+}
+
+// pullTracker remembers the pull requests seen in pull_request events, keyed
+// by repository, head SHA and number, so later stages (like commenting) know
+// which PR a check run belongs to without querying GitHub.
+type pullTracker struct {
+	mu    sync.Mutex
+	ttl   time.Duration
+	pulls map[string]map[int]trackedPull
+}
+
+func newPullTracker(ttl time.Duration) *pullTracker {
+	return &pullTracker{
+		ttl:   ttl,
+		pulls: make(map[string]map[int]trackedPull),
+	}
+}
+
+func pullKey(installationID int64, fullRepoName string, headSHA string) string {
+	return fmt.Sprintf("%d/%s/%s", installationID, fullRepoName, headSHA)
+}
+
+// Track records pr as a pull request for its head SHA, replacing what was
+// recorded for the same pull request.
+func (t *pullTracker) Track(installationID int64, repo *github.Repository, pr *github.PullRequest) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	for k, prs := range t.pulls {
+		for n, p := range prs {
+			if !now.Before(p.expires) {
+				delete(prs, n)
+			}
+		}
+		if len(prs) == 0 {
+			delete(t.pulls, k)
+		}
+	}
+	key := pullKey(installationID, repo.GetFullName(), pr.GetHead().GetSHA())
+	if t.pulls[key] == nil {
+		t.pulls[key] = make(map[int]trackedPull)
+	}
+	t.pulls[key][pr.GetNumber()] = trackedPull{pr: pr, expires: now.Add(t.ttl)}
+}
+
+// Get returns the tracked pull requests for headSHA.
+func (t *pullTracker) Get(installationID int64, fullRepoName string, headSHA string) []*github.PullRequest {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var prs []*github.PullRequest
+	now := time.Now()
+	for _, p := range t.pulls[pullKey(installationID, fullRepoName, headSHA)] {
+		if now.Before(p.expires) {
+			prs = append(prs, p.pr)
+		}
+	}
+	return prs
+}
+
+// resolvePRForSHA returns the pull request whose head is headSHA. A SHA can be
+// the head of several PRs (e.g. the same branch proposed against two bases),
+// so ties are broken deterministically, preferring in order:
+//
+//  1. open PRs over closed ones,
+//  2. ready-for-review PRs over drafts,
+//  3. PRs targeting the repository's default branch,
+//  4. the lowest (oldest) PR number.
+//
+// When pull_request events were seen for headSHA, the pull requests they
+// carried are the candidates, without querying GitHub. It returns nil if no
+// pull request has headSHA as its head.
+func (app *GithubApp) resolvePRForSHA(ctx context.Context, installationID int64, repo *github.Repository, headSHA string) (*github.PullRequest, error) {
+	if prs := app.pulls.Get(installationID, repo.GetFullName(), headSHA); len(prs) > 0 {
+		return pickPR(prs, repo.GetDefaultBranch()), nil
+	}
+	owner := repo.GetOwner().GetLogin()
+	repoName := repo.GetName()
+	ghc := app.GetClient(installationID)
+
+	var candidates []*github.PullRequest
+	opts := &github.PullRequestListOptions{
+		State:       "all",
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	for {
+		prs, res, err := ghc.PullRequests.ListPullRequestsWithCommit(ctx, owner, repoName, headSHA, opts)
+		if err := extractError(ctx, res, err); err != nil {
+			return nil, err
+		}
+		for _, pr := range prs {
+			if pr.GetHead().GetSHA() == headSHA {
+				candidates = append(candidates, pr)
+			}
+		}
+		if res.NextPage == 0 {
+			break
+		}
+		opts.Page = res.NextPage
+	}
+	return pickPR(candidates, repo.GetDefaultBranch()), nil
+}
+
+// pullRequest returns the pull request for headSHA, or nil if there's no pull
+// request or it can't be resolved.
+func (app *GithubApp) pullRequest(ctx context.Context, installationID int64, repo *github.Repository, headSHA string) *github.PullRequest {
+	pr, err := app.resolvePRForSHA(ctx, installationID, repo, headSHA)
+	if err != nil {
+		logFrom(ctx).Warnw("failed to resolve pull request", "sha", headSHA, "error", err)
+		return nil
+	}
+	return pr
+}
+
+// pickPR applies the tie-breaking rules documented on resolvePRForSHA.
+func pickPR(prs []*github.PullRequest, defaultBranch string) *github.PullRequest {
+	if len(prs) == 0 {
+		return nil
+	}
+	rank := func(pr *github.PullRequest) int {
+		r := 0
+		if pr.GetState() != "open" {
+			r += 4
+		}
+		if pr.GetDraft() {
+			r += 2
+		}
+		if pr.GetBase().GetRef() != defaultBranch {
+			r++
+		}
+		return r
+	}
+	sorted := append([]*github.PullRequest(nil), prs...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		ri, rj := rank(sorted[i]), rank(sorted[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return sorted[i].GetNumber() < sorted[j].GetNumber()
+	})
+	return sorted[0]
+}
@@ -0,0 +1,156 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v43/github"
+)
+
+func testPR(number int, state string, draft bool, base string, headSHA string) *github.PullRequest {
+	return &github.PullRequest{
+		Number: github.Int(number),
+		State:  github.String(state),
+		Draft:  github.Bool(draft),
+		Base:   &github.PullRequestBranch{Ref: github.String(base)},
+		Head:   &github.PullRequestBranch{SHA: github.String(headSHA)},
+	}
+}
+
+func TestPickPR(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		prs  []*github.PullRequest
+		want int
+	}{
+		{
+			name: "open over closed",
+			prs:  []*github.PullRequest{testPR(1, "closed", false, "main", "abc"), testPR(2, "open", false, "main", "abc")},
+			want: 2,
+		},
+		{
+			name: "ready over draft",
+			prs:  []*github.PullRequest{testPR(1, "open", true, "main", "abc"), testPR(2, "open", false, "release", "abc")},
+			want: 2,
+		},
+		{
+			name: "default branch over others",
+			prs:  []*github.PullRequest{testPR(1, "open", false, "release", "abc"), testPR(2, "open", false, "main", "abc")},
+			want: 2,
+		},
+		{
+			name: "oldest of equals",
+			prs:  []*github.PullRequest{testPR(3, "open", false, "main", "abc"), testPR(2, "open", false, "main", "abc")},
+			want: 2,
+		},
+	} {
+		if got := pickPR(tc.prs, "main").GetNumber(); got != tc.want {
+			t.Errorf("%s: picked #%d, want #%d", tc.name, got, tc.want)
+		}
+	}
+	if pr := pickPR(nil, "main"); pr != nil {
+		t.Errorf("picked #%d of no pull requests", pr.GetNumber())
+	}
+}
+
+func testRepo() *github.Repository {
+	return &github.Repository{
+		Name:          github.String("r"),
+		FullName:      github.String("o/r"),
+		Owner:         &github.User{Login: github.String("o")},
+		DefaultBranch: github.String("main"),
+	}
+}
+
+func TestResolvePRForSHAUsesTrackedPR(t *testing.T) {
+	app := &GithubApp{pulls: newPullTracker(pullTrackerTTL)}
+	app.pulls.Track(testInstallationID, testRepo(), testPR(1, "open", false, "main", "abc"))
+
+	// The tracked pull request is returned without querying GitHub, which
+	// the app has no client for.
+	pr, err := app.resolvePRForSHA(context.Background(), testInstallationID, testRepo(), "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pr.GetNumber() != 1 {
+		t.Errorf("resolved #%d, want the tracked #1", pr.GetNumber())
+	}
+}
+
+func TestResolvePRForSHAWithTwoTrackedPRs(t *testing.T) {
+	f := newFakeGitHub(t)
+	app := newTestApp(t, f)
+	repo := testRepo()
+	app.pulls.Track(testInstallationID, repo, testPR(1, "open", false, "release", "abc"))
+	app.pulls.Track(testInstallationID, repo, testPR(2, "open", false, "main", "abc"))
+
+	pr, err := app.resolvePRForSHA(context.Background(), testInstallationID, repo, "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pr.GetNumber() != 2 {
+		t.Errorf("resolved #%d, want #2 into the default branch", pr.GetNumber())
+	}
+	if got := f.count("GET /repos/o/r/commits/abc/pulls"); got != 0 {
+		t.Errorf("pull requests were listed %d times, want them taken from the tracked events", got)
+	}
+}
+
+func TestResolvePRForSHAWithTwoListedPRs(t *testing.T) {
+	f := newFakeGitHub(t)
+	f.handle("GET /repos/o/r/commits/abc/pulls", func(w http.ResponseWriter, req *http.Request) {
+		writeTestJSON(w, http.StatusOK, []*github.PullRequest{
+			testPR(1, "open", false, "release", "abc"),
+			testPR(2, "open", false, "main", "abc"),
+			// The commit is in this pull request, but not its head.
+			testPR(3, "open", false, "main", "def"),
+		})
+	})
+	app := newTestApp(t, f)
+
+	pr, err := app.resolvePRForSHA(context.Background(), testInstallationID, testRepo(), "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pr.GetNumber() != 2 {
+		t.Errorf("resolved #%d, want #2 into the default branch", pr.GetNumber())
+	}
+}
+
+func TestPullTracker(t *testing.T) {
+	tracker := newPullTracker(pullTrackerTTL)
+	tracker.Track(testInstallationID, testRepo(), testPR(1, "open", false, "main", "abc"))
+
+	if prs := tracker.Get(testInstallationID, "o/r", "abc"); len(prs) != 1 || prs[0].GetNumber() != 1 {
+		t.Errorf("got %v for the head of #1", prs)
+	}
+	if prs := tracker.Get(testInstallationID, "o/r", "def"); len(prs) != 0 {
+		t.Errorf("got %v for an untracked SHA", prs)
+	}
+	if prs := tracker.Get(testInstallationID+1, "o/r", "abc"); len(prs) != 0 {
+		t.Errorf("got %v for another installation", prs)
+	}
+
+	// Tracking a pull request again replaces it.
+	tracker.Track(testInstallationID, testRepo(), testPR(1, "closed", false, "main", "abc"))
+	tracker.Track(testInstallationID, testRepo(), testPR(3, "open", false, "main", "abc"))
+	prs := tracker.Get(testInstallationID, "o/r", "abc")
+	states := map[int]string{}
+	for _, pr := range prs {
+		states[pr.GetNumber()] = pr.GetState()
+	}
+	if len(prs) != 2 || states[1] != "closed" || states[3] != "open" {
+		t.Errorf("got %v, want the closed #1 and #3", states)
+	}
+}
+
+func TestPullTrackerExpires(t *testing.T) {
+	tracker := newPullTracker(time.Millisecond)
+	tracker.Track(testInstallationID, testRepo(), testPR(1, "open", false, "main", "abc"))
+	time.Sleep(2 * time.Millisecond)
+	if prs := tracker.Get(testInstallationID, "o/r", "abc"); len(prs) != 0 {
+		t.Errorf("got expired %v", prs)
+	}
+}
@@ -0,0 +1,104 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// EventWorkers is the number of background workers processing webhook
+	// events. With 0 workers, events are processed inline before the webhook
+	// is acknowledged.
+	EventWorkers = 4
+	// EventQueueSize is the number of webhook events that can wait for a
+	// worker before new deliveries are rejected.
+	EventQueueSize = 100
+)
+
+// webhookEvent is a validated, parsed webhook waiting to be processed.
+type webhookEvent struct {
+	deliveryID string
+	event      interface{}
+	enqueuedAt time.Time
+}
+
+// QueuedEvent describes a webhook event waiting for a worker.
+type QueuedEvent struct {
+	DeliveryID string    `json:"delivery_id"`
+	Type       string    `json:"type"`
+	EnqueuedAt time.Time `json:"enqueued_at"`
+}
+
+// eventQueue processes webhook events on a pool of background workers, so
+// that GitHub's 10 second delivery timeout isn't spent cloning and building.
+type eventQueue struct {
+	events chan *webhookEvent
+
+	// waiting are the events in the channel, which can't be inspected.
+	mu      sync.Mutex
+	waiting map[*webhookEvent]struct{}
+}
+
+func newEventQueue(app *GithubApp, workers int, size int) *eventQueue {
+	q := &eventQueue{
+		events:  make(chan *webhookEvent, size),
+		waiting: make(map[*webhookEvent]struct{}),
+	}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for e := range q.events {
+				q.mu.Lock()
+				delete(q.waiting, e)
+				q.mu.Unlock()
+				ctx := withDeliveryID(context.Background(), e.deliveryID)
+				if err := app.processEvent(ctx, e.event); err != nil {
+					logFrom(ctx).Errorw("error handling event", "error", err)
+					app.recordFailure(ctx, e.event, err)
+				}
+			}
+		}()
+	}
+	return q
+}
+
+// Enqueue adds an event to the queue. It returns false if the queue is full.
+func (q *eventQueue) Enqueue(e *webhookEvent) bool {
+	e.enqueuedAt = time.Now()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	select {
+	case q.events <- e:
+		q.waiting[e] = struct{}{}
+		return true
+	default:
+		return false
+	}
+}
+
+// List returns the events waiting for a worker, oldest first.
+func (q *eventQueue) List() []*QueuedEvent {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	events := make([]*QueuedEvent, 0, len(q.waiting))
+	for e := range q.waiting {
+		events = append(events, &QueuedEvent{
+			DeliveryID: e.deliveryID,
+			Type:       eventType(e.event),
+			EnqueuedAt: e.enqueuedAt,
+		})
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].EnqueuedAt.Before(events[j].EnqueuedAt)
+	})
+	return events
+}
+
+// eventType returns the name of the type of a parsed webhook event, e.g.
+// "CheckRunEvent".
+func eventType(event interface{}) string {
+	return strings.TrimPrefix(fmt.Sprintf("%T", event), "*github.")
+}
@@ -0,0 +1,53 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-github/v43/github"
+)
+
+func TestHandleWebhookQueuesEvents(t *testing.T) {
+	app := &GithubApp{
+		webhookSecrets: []string{testWebhookSecret},
+		deliveries:     newExpiringSet(deliveryTTL, maxDeliveries),
+	}
+	// Without workers, queued events wait in the queue for the test to
+	// inspect.
+	app.events = newEventQueue(app, 0, 1)
+
+	w := httptest.NewRecorder()
+	app.HandleWebhook(w, webhookRequest(t, "check_suite", "delivery-1", checkSuitePayload("abc"), testWebhookSecret))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusAccepted, w.Body)
+	}
+	select {
+	case e := <-app.events.events:
+		if e.deliveryID != "delivery-1" {
+			t.Errorf("queued delivery %q, want delivery-1", e.deliveryID)
+		}
+		if _, ok := e.event.(*github.CheckSuiteEvent); !ok {
+			t.Errorf("queued a %T, want a *github.CheckSuiteEvent", e.event)
+		}
+	default:
+		t.Fatal("no event was queued")
+	}
+}
+
+func TestHandleWebhookRejectsEventsWhenQueueIsFull(t *testing.T) {
+	app := &GithubApp{
+		webhookSecrets: []string{testWebhookSecret},
+		deliveries:     newExpiringSet(deliveryTTL, maxDeliveries),
+	}
+	app.events = newEventQueue(app, 0, 0)
+
+	w := httptest.NewRecorder()
+	app.HandleWebhook(w, webhookRequest(t, "check_suite", "delivery-1", checkSuitePayload("abc"), testWebhookSecret))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusServiceUnavailable, w.Body)
+	}
+	if !app.deliveries.Add("delivery-1") {
+		t.Errorf("the rejected delivery is remembered, so its redelivery would be skipped")
+	}
+}
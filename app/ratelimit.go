@@ -0,0 +1,171 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// GitHubRetryBackoff is the delay before the first retry of a GitHub API
+	// request. It doubles with every retry, unless GitHub asks for a specific
+	// delay with Retry-After.
+	GitHubRetryBackoff = time.Second
+)
+
+// rateLimitReserve is the number of remaining requests below which calls are
+// spread out evenly over the time left until the rate limit resets.
+const rateLimitReserve = 100
+
+// rateLimiter tracks the primary rate limit of one GitHub identity, as
+// reported by the X-RateLimit headers of its responses.
+type rateLimiter struct {
+	mu        sync.Mutex
+	remaining int
+	reset     time.Time
+	now       func() time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{
+		remaining: -1,
+		now:       time.Now,
+	}
+}
+
+// delay returns how long to wait before the next request.
+func (l *rateLimiter) delay() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	untilReset := l.reset.Sub(l.now())
+	if l.remaining < 0 || l.remaining >= rateLimitReserve || untilReset <= 0 {
+		return 0
+	}
+	if l.remaining == 0 {
+		return untilReset
+	}
+	return untilReset / time.Duration(l.remaining)
+}
+
+func (l *rateLimiter) update(res *http.Response) {
+	remaining, err := strconv.Atoi(res.Header.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+	reset, err := strconv.ParseInt(res.Header.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.remaining = remaining
+	l.reset = time.Unix(reset, 0)
+}
+
+// rateLimiters holds a rateLimiter per installation. Installation 0 is the
+// app itself.
+type rateLimiters struct {
+	mu       sync.Mutex
+	limiters map[int64]*rateLimiter
+}
+
+func newRateLimiters() *rateLimiters {
+	return &rateLimiters{
+		limiters: make(map[int64]*rateLimiter),
+	}
+}
+
+func (r *rateLimiters) Get(installationID int64) *rateLimiter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	l, ok := r.limiters[installationID]
+	if !ok {
+		l = newRateLimiter()
+		r.limiters[installationID] = l
+	}
+	return l
+}
+
+// rateLimitTransport throttles requests to stay within GitHub's primary rate
+// limit, and retries requests that hit a secondary rate limit or fail with a
+// server error.
+type rateLimitTransport struct {
+	base    http.RoundTripper
+	limiter *rateLimiter
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	backoff := GitHubRetryBackoff
+	for attempt := 0; ; attempt++ {
+		if err := sleepContext(ctx, t.limiter.delay()); err != nil {
+			return nil, err
+		}
+		attemptReq := req
+		if attempt > 0 {
+			attemptReq = req.Clone(ctx)
+			if req.Body != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				attemptReq.Body = body
+			}
+		}
+		res, err := t.base.RoundTrip(attemptReq)
+		if err != nil {
+			return nil, err
+		}
+		t.limiter.update(res)
+
+		canRetry := attempt < CurrentSettings().GitHubRetries && (req.Body == nil || req.GetBody != nil)
+		if !canRetry || !shouldRetry(res) {
+			return res, nil
+		}
+		wait := backoff
+		if retryAfter, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil {
+			wait = time.Duration(retryAfter) * time.Second
+		}
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+		logFrom(ctx).Infow("retrying GitHub request", "method", req.Method, "url", req.URL.String(), "status", res.StatusCode, "attempt", attempt+1, "wait", wait)
+		if err := sleepContext(ctx, wait); err != nil {
+			return nil, err
+		}
+		backoff *= 2
+	}
+}
+
+// shouldRetry reports whether res is a rate limit or server error response.
+// It leaves the response body readable.
+func shouldRetry(res *http.Response) bool {
+	switch {
+	case res.StatusCode >= 500, res.StatusCode == http.StatusTooManyRequests:
+		return true
+	case res.StatusCode != http.StatusForbidden:
+		return false
+	case res.Header.Get("Retry-After") != "", res.Header.Get("X-RateLimit-Remaining") == "0":
+		return true
+	}
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	res.Body = io.NopCloser(bytes.NewReader(body))
+	return err == nil && strings.Contains(strings.ToLower(string(body)), "secondary rate limit")
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	select {
+	case <-time.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
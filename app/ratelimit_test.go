@@ -0,0 +1,127 @@
+package app
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterDelay(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := newRateLimiter()
+	l.now = func() time.Time { return now }
+	if got := l.delay(); got != 0 {
+		t.Errorf("delay before any response = %s, want 0", got)
+	}
+
+	for _, tc := range []struct {
+		remaining int
+		reset     time.Time
+		want      time.Duration
+	}{
+		{rateLimitReserve, now.Add(time.Minute), 0},
+		{10, now.Add(10 * time.Second), time.Second},
+		{0, now.Add(time.Minute), time.Minute},
+		{0, now.Add(-time.Second), 0},
+	} {
+		res := &http.Response{Header: http.Header{}}
+		res.Header.Set("X-RateLimit-Remaining", strconv.Itoa(tc.remaining))
+		res.Header.Set("X-RateLimit-Reset", strconv.FormatInt(tc.reset.Unix(), 10))
+		l.update(res)
+		if got := l.delay(); got != tc.want {
+			t.Errorf("delay with %d remaining until %s = %s, want %s", tc.remaining, tc.reset.Sub(now), got, tc.want)
+		}
+	}
+}
+
+// setGitHubRetries sets the retries of GitHub requests for the duration of
+// the test, without backoff.
+func setGitHubRetries(t *testing.T, retries int) {
+	setTestSettings(t, func(s *Settings) { s.GitHubRetries = retries })
+	oldBackoff := GitHubRetryBackoff
+	GitHubRetryBackoff = time.Millisecond
+	t.Cleanup(func() { GitHubRetryBackoff = oldBackoff })
+}
+
+// flakyServer fails the first len(statuses) requests with the given statuses
+// and the body message, then succeeds. It returns the bodies of the requests
+// it received.
+func flakyServer(t *testing.T, message string, statuses ...int) (*httptest.Server, *[]string) {
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		bodies = append(bodies, string(body))
+		if len(bodies) <= len(statuses) {
+			w.WriteHeader(statuses[len(bodies)-1])
+			io.WriteString(w, message)
+			return
+		}
+		io.WriteString(w, "ok")
+	}))
+	t.Cleanup(server.Close)
+	return server, &bodies
+}
+
+func TestRateLimitTransportRetries(t *testing.T) {
+	setGitHubRetries(t, 5)
+	server, bodies := flakyServer(t, `{"message": "You have exceeded a secondary rate limit."}`, http.StatusBadGateway, http.StatusForbidden)
+	client := &http.Client{Transport: &rateLimitTransport{base: http.DefaultTransport, limiter: newRateLimiter()}}
+
+	res, err := client.Post(server.URL, "application/json", strings.NewReader(`{"name": "bazel"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("got status %d, want the retried request to succeed", res.StatusCode)
+	}
+	if len(*bodies) != 3 {
+		t.Fatalf("server got %d requests, want 3", len(*bodies))
+	}
+	for i, body := range *bodies {
+		if body != `{"name": "bazel"}` {
+			t.Errorf("request %d had body %q, want the original one", i, body)
+		}
+	}
+}
+
+func TestRateLimitTransportDoesNotRetryOtherErrors(t *testing.T) {
+	setGitHubRetries(t, 5)
+	server, bodies := flakyServer(t, `{"message": "Resource not accessible by integration"}`, http.StatusForbidden)
+	client := &http.Client{Transport: &rateLimitTransport{base: http.DefaultTransport, limiter: newRateLimiter()}}
+
+	res, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	if res.StatusCode != http.StatusForbidden || !strings.Contains(string(body), "not accessible") {
+		t.Errorf("got %d %q, want the forbidden response with its body", res.StatusCode, body)
+	}
+	if len(*bodies) != 1 {
+		t.Errorf("server got %d requests, want 1", len(*bodies))
+	}
+}
+
+func TestRateLimitTransportGivesUp(t *testing.T) {
+	setGitHubRetries(t, 2)
+	server, bodies := flakyServer(t, "", http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError)
+	client := &http.Client{Transport: &rateLimitTransport{base: http.DefaultTransport, limiter: newRateLimiter()}}
+
+	res, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusInternalServerError {
+		t.Errorf("got status %d, want the last server error", res.StatusCode)
+	}
+	if len(*bodies) != 3 {
+		t.Errorf("server got %d requests, want the request and 2 retries", len(*bodies))
+	}
+}
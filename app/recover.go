@@ -0,0 +1,86 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v43/github"
+)
+
+// RecoverCheckRuns finds the check runs that were still in progress when the
+// bot last stopped, according to its store, completes them as cancelled and
+// requests a new run of each. Without a store there is nothing to recover.
+func (app *GithubApp) RecoverCheckRuns(ctx context.Context) error {
+	if app.store == nil {
+		return nil
+	}
+	records, err := app.store.ListCheckRuns(ctx, CheckRunFilter{Status: inProgress})
+	if err != nil {
+		return err
+	}
+	var failed []string
+	for _, record := range records {
+		if err := app.recoverCheckRun(ctx, record); err != nil {
+			failed = append(failed, fmt.Sprintf("%d: %s", record.CheckRunID, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to recover check runs: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+func (app *GithubApp) recoverCheckRun(ctx context.Context, record *CheckRunRecord) error {
+	ctx = withLogFields(ctx, "repo", record.FullRepoName, "sha", record.HeadSHA, "check", record.CheckName)
+	key := inFlightKey(record.InstallationID, record.HeadSHA, record.CheckName)
+	if !app.inFlight.Add(key) {
+		// Picked up again since startup, e.g. by a redelivered webhook.
+		return nil
+	}
+	app.inFlight.Remove(key)
+
+	owner, repoName, _ := strings.Cut(record.FullRepoName, "/")
+	ghc := app.GetClient(record.InstallationID)
+	if pending, ok := app.store.(PendingResultStore); ok {
+		result, err := pending.PendingResult(ctx, record.CheckRunID)
+		if err != nil {
+			return err
+		}
+		if result != nil {
+			return app.reportPendingResult(ctx, ghc, pending, record, result)
+		}
+	}
+
+	logFrom(ctx).Infow("recovering interrupted check run", "check_run_id", record.CheckRunID)
+	result := &Result{
+		Title:      fmt.Sprintf("%s interrupted", record.CheckName),
+		Summary:    "The review bot restarted while this check was running. A new run has been requested.",
+		Conclusion: "cancelled",
+	}
+	if _, err := completeCheckRun(ctx, ghc, owner, repoName, record.CheckRunID, result, record.CheckName); err != nil {
+		return err
+	}
+	if err := app.store.CompleteCheckRun(ctx, record.CheckRunID, result.Conclusion, time.Now(), result.Summary); err != nil {
+		return err
+	}
+
+	repo, res, err := ghc.Repositories.Get(ctx, owner, repoName)
+	if err := extractError(ctx, res, err); err != nil {
+		return fmt.Errorf("failed to get repository: %s", err)
+	}
+	return app.createCheckRuns(ctx, record.InstallationID, repo, record.HeadSHA, []string{record.CheckName})
+}
+
+// reportPendingResult reports a result that was computed but couldn't be
+// reported before the bot stopped, rather than running the check again.
+func (app *GithubApp) reportPendingResult(ctx context.Context, ghc *github.Client, pending PendingResultStore, record *CheckRunRecord, result *Result) error {
+	logFrom(ctx).Infow("reporting saved result", "check_run_id", record.CheckRunID, "conclusion", result.Conclusion)
+	owner, repoName, _ := strings.Cut(record.FullRepoName, "/")
+	if _, err := completeCheckRun(ctx, ghc, owner, repoName, record.CheckRunID, result, record.CheckName); err != nil {
+		return err
+	}
+	app.recordResult(ctx, &JobCheck{Name: record.CheckName, CheckRunID: record.CheckRunID}, result)
+	return pending.DeletePendingResult(ctx, record.CheckRunID)
+}
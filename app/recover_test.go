@@ -0,0 +1,125 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v43/github"
+)
+
+func TestRecoverCheckRuns(t *testing.T) {
+	f := newFakeGitHub(t)
+	f.handle("GET /repos/o/r", func(w http.ResponseWriter, req *http.Request) {
+		writeTestJSON(w, http.StatusOK, testRepo())
+	})
+	var conclusions []string
+	f.handle("PATCH /repos/o/r/check-runs/7", func(w http.ResponseWriter, req *http.Request) {
+		opts := &github.UpdateCheckRunOptions{}
+		if err := json.NewDecoder(req.Body).Decode(opts); err != nil {
+			t.Errorf("failed to decode check run update: %s", err)
+		}
+		conclusions = append(conclusions, opts.GetConclusion())
+		writeTestJSON(w, http.StatusOK, map[string]interface{}{"id": 7})
+	})
+	created := serveCheckRunCreation(t, f, "abc")
+	app := newTestApp(t, f)
+	d := &recordingDispatcher{}
+	app.SetDispatcher(d)
+	store := newMemStore()
+	app.SetStore(store)
+	ctx := context.Background()
+	for _, r := range []*CheckRunRecord{
+		{CheckRunID: 7, InstallationID: testInstallationID, FullRepoName: "o/r", HeadSHA: "abc", CheckName: nogoCheck},
+		{CheckRunID: 8, InstallationID: testInstallationID, FullRepoName: "o/r", HeadSHA: "abc", CheckName: buildifierCheck},
+	} {
+		if err := store.StartCheckRun(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.CompleteCheckRun(ctx, 8, "success", time.Now(), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.RecoverCheckRuns(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(conclusions) != 1 || conclusions[0] != "cancelled" {
+		t.Errorf("interrupted check run was completed as %v, want cancelled", conclusions)
+	}
+	if r := store.runs[7]; r.Status != "completed" || r.Conclusion != "cancelled" {
+		t.Errorf("got stored check run %+v, want it completed as cancelled", r)
+	}
+	if runs := created(); len(runs) != 1 || runs[0].Name != nogoCheck {
+		t.Errorf("created check runs %+v, want a new run of %s", runs, nogoCheck)
+	}
+	if len(d.jobs) != 1 || len(d.jobs[0].Checks) != 1 || d.jobs[0].Checks[0].Name != nogoCheck {
+		t.Errorf("dispatched jobs %+v, want one for %s", d.jobs, nogoCheck)
+	}
+}
+
+func TestRecoverCheckRunsSkipsChecksRunningAgain(t *testing.T) {
+	f := newFakeGitHub(t)
+	app := newTestApp(t, f)
+	store := newMemStore()
+	app.SetStore(store)
+	record := &CheckRunRecord{CheckRunID: 7, InstallationID: testInstallationID, FullRepoName: "o/r", HeadSHA: "abc", CheckName: nogoCheck}
+	if err := store.StartCheckRun(context.Background(), record); err != nil {
+		t.Fatal(err)
+	}
+	app.inFlight.Add(inFlightKey(testInstallationID, "abc", nogoCheck))
+
+	if err := app.RecoverCheckRuns(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.count("PATCH /repos/o/r/check-runs/7"); got != 0 {
+		t.Errorf("check run was updated %d times although it runs again, want 0", got)
+	}
+}
+
+func TestRecoverCheckRunsReportsPendingResults(t *testing.T) {
+	f := newFakeGitHub(t)
+	completed := completedCheckRuns(t, f, "7")
+	app := newTestApp(t, f)
+	d := &recordingDispatcher{}
+	app.SetDispatcher(d)
+	store := newMemStore()
+	app.SetStore(store)
+	ctx := context.Background()
+	record := &CheckRunRecord{CheckRunID: 7, InstallationID: testInstallationID, FullRepoName: "o/r", HeadSHA: "abc", CheckName: nogoCheck}
+	if err := store.StartCheckRun(ctx, record); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SavePendingResult(ctx, 7, &Result{Conclusion: "failure", Summary: "build failed"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.RecoverCheckRuns(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case opts := <-completed:
+		if opts.GetConclusion() != "failure" {
+			t.Errorf("check run was completed as %q, want the saved failure", opts.GetConclusion())
+		}
+	default:
+		t.Fatal("saved result wasn't reported")
+	}
+	if r := store.runs[7]; r.Status != "completed" || r.Conclusion != "failure" {
+		t.Errorf("got stored check run %+v, want it completed with the saved result", r)
+	}
+	if len(store.pending) != 0 {
+		t.Errorf("got pending results %v after reporting them, want none", store.pending)
+	}
+	if len(d.jobs) != 0 {
+		t.Errorf("dispatched jobs %+v, want the check not to run again", d.jobs)
+	}
+}
+
+func TestRecoverCheckRunsWithoutStore(t *testing.T) {
+	if err := (&GithubApp{}).RecoverCheckRuns(context.Background()); err != nil {
+		t.Errorf("RecoverCheckRuns without a store: %s", err)
+	}
+}
@@ -0,0 +1,249 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+// RepoCacheDir is where bare mirrors of checked repositories are kept between
+// check runs. Clones then only fetch new objects and share the mirror's
+// object store, instead of downloading the whole repository every time. An
+// empty value disables the cache.
+var RepoCacheDir = ""
+
+var (
+	// repoLocks serializes fetches into the same mirror.
+	repoLocksMu sync.Mutex
+	repoLocks   = make(map[string]*sync.Mutex)
+)
+
+func repoLock(fullRepoName string) *sync.Mutex {
+	repoLocksMu.Lock()
+	defer repoLocksMu.Unlock()
+	l, ok := repoLocks[fullRepoName]
+	if !ok {
+		l = &sync.Mutex{}
+		repoLocks[fullRepoName] = l
+	}
+	return l
+}
+
+// updateMirror creates or updates the bare mirror of fullRepoName and returns
+// its path. Besides the branches, the mirror holds the refs fetched by
+// pullRefSpec under their own names. The mirror has no remote configured;
+// url and auth are passed to every fetch.
+func updateMirror(ctx context.Context, url string, auth transport.AuthMethod, fullRepoName string, pullRefSpec config.RefSpec) (string, error) {
+	l := repoLock(fullRepoName)
+	l.Lock()
+	defer l.Unlock()
+
+	mirror := filepath.Join(RepoCacheDir, filepath.FromSlash(fullRepoName)+".git")
+	r, err := git.PlainOpen(mirror)
+	if err == git.ErrRepositoryNotExists {
+		r, err = git.PlainInit(mirror, true)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to open mirror %q: %s", mirror, err)
+	}
+	refSpecs := []config.RefSpec{"+refs/heads/*:refs/heads/*"}
+	if src := pullRefSpec.Src(); src != "refs/heads/*" {
+		refSpecs = append(refSpecs, config.RefSpec("+"+src+":"+src))
+	}
+	remote := git.NewRemote(r.Storer, &config.RemoteConfig{Name: git.DefaultRemoteName, URLs: []string{url}})
+	if err := fetch(ctx, remote, &git.FetchOptions{RefSpecs: refSpecs, Auth: auth, Tags: git.NoTags}); err != nil {
+		return "", fmt.Errorf("failed to update mirror %q: %s", mirror, err)
+	}
+	if err := pruneBranches(remote, r, auth); err != nil {
+		return "", fmt.Errorf("failed to prune mirror %q: %s", mirror, err)
+	}
+	return mirror, nil
+}
+
+// pruneBranches deletes the branches of the mirror r that were deleted on
+// remote, since go-git can't prune when fetching.
+func pruneBranches(remote *git.Remote, r *git.Repository, auth transport.AuthMethod) error {
+	refs, err := remote.List(&git.ListOptions{Auth: auth})
+	if err != nil {
+		return err
+	}
+	remoteBranches := make(map[plumbing.ReferenceName]bool)
+	for _, ref := range refs {
+		remoteBranches[ref.Name()] = true
+	}
+	branches, err := r.Branches()
+	if err != nil {
+		return err
+	}
+	var stale []plumbing.ReferenceName
+	err = branches.ForEach(func(ref *plumbing.Reference) error {
+		if !remoteBranches[ref.Name()] {
+			stale = append(stale, ref.Name())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, name := range stale {
+		if err := r.Storer.RemoveReference(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cachedClone checks ref out into targetDir from the repository's local
+// mirror, fetching only what changed since the last run.
+func cachedClone(ctx context.Context, url string, auth transport.AuthMethod, fullRepoName string, ref GitRef, targetDir string, pullRefSpec config.RefSpec) (*git.Repository, error) {
+	mirror, err := updateMirror(ctx, url, auth, fullRepoName, pullRefSpec)
+	if err != nil {
+		return nil, err
+	}
+	r, err := git.PlainInit(targetDir, false)
+	if err != nil {
+		return nil, fmt.Errorf("unable to clone repo to %q: %s", targetDir, err)
+	}
+	// Point origin at the repository rather than the mirror, so that pushes
+	// and LFS fetches go there.
+	_, err = r.CreateRemote(&config.RemoteConfig{
+		Name:  git.DefaultRemoteName,
+		URLs:  []string{url},
+		Fetch: []config.RefSpec{"+refs/heads/*:refs/remotes/origin/*"},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to add origin: %s", err)
+	}
+	if err := shareMirror(r, targetDir, mirror); err != nil {
+		return nil, err
+	}
+	if err := checkoutRef(r, ref); err != nil {
+		return nil, err
+	}
+	if err := fetchLFSObjects(ctx, targetDir, auth); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// shareMirror makes the clone r in dir use the objects of mirror, like git
+// clone --shared, and sets its remote-tracking branches to the mirror's
+// branches.
+func shareMirror(r *git.Repository, dir string, mirror string) error {
+	alternates := filepath.Join(dir, ".git", "objects", "info", "alternates")
+	if err := os.MkdirAll(filepath.Dir(alternates), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(alternates, []byte(filepath.Join(mirror, "objects")+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to share the objects of %q: %s", mirror, err)
+	}
+	m, err := git.PlainOpen(mirror)
+	if err != nil {
+		return fmt.Errorf("failed to open mirror %q: %s", mirror, err)
+	}
+	branches, err := m.Branches()
+	if err != nil {
+		return err
+	}
+	return branches.ForEach(func(ref *plumbing.Reference) error {
+		name := plumbing.NewRemoteReferenceName(git.DefaultRemoteName, ref.Name().Short())
+		return r.Storer.SetReference(plumbing.NewHashReference(name, ref.Hash()))
+	})
+}
+
+// checkoutRef force checks out ref in r, detached: its commit, or else the
+// commit of origin/branch.
+func checkoutRef(r *git.Repository, ref GitRef) error {
+	rev := ref.hash
+	if rev == "" {
+		rev = "origin/" + ref.branch
+	}
+	hash, err := r.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %s", rev, err)
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		return fmt.Errorf("failed to get work tree: %s", err)
+	}
+	if err := w.Checkout(&git.CheckoutOptions{Hash: *hash, Force: true}); err != nil {
+		return fmt.Errorf("failed to checkout %s: %s", rev, err)
+	}
+	return nil
+}
+
+// fetch is remote.FetchContext, where having nothing to fetch isn't an
+// error.
+func fetch(ctx context.Context, remote *git.Remote, opts *git.FetchOptions) error {
+	if err := remote.FetchContext(ctx, opts); err != nil && err != git.NoErrAlreadyUpToDate {
+		return err
+	}
+	return nil
+}
+
+// tokenAuth authenticates git operations on GitHub with an installation
+// token. It's sent as basic auth rather than in the URL, so that it doesn't
+// end up in the repository's config, process listings or logs.
+func tokenAuth(token string) transport.AuthMethod {
+	return &githttp.BasicAuth{Username: "x-access-token", Password: token}
+}
+
+// gitAuthEnv returns the environment that makes git commands send the
+// credentials of auth with their HTTP requests, or nil if there are none.
+// Unlike arguments, the environment isn't visible to other users.
+func gitAuthEnv(auth transport.AuthMethod) []string {
+	basic, ok := auth.(*githttp.BasicAuth)
+	if !ok || basic == nil {
+		return nil
+	}
+	creds := base64.StdEncoding.EncodeToString([]byte(basic.Username + ":" + basic.Password))
+	return []string{
+		"GIT_CONFIG_COUNT=1",
+		"GIT_CONFIG_KEY_0=http.extraHeader",
+		"GIT_CONFIG_VALUE_0=Authorization: Basic " + creds,
+	}
+}
+
+// runGit runs git in dir. Its output, which includes progress, is only kept
+// for the error.
+func runGit(dir string, args ...string) error {
+	return runGitWithEnv(dir, nil, args...)
+}
+
+// runGitWithEnv is like runGit but adds env to git's environment.
+func runGitWithEnv(dir string, env []string, args ...string) error {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	if env != nil {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("git %s failed: %s: %s", args[0], err, truncateOutput(string(out), CurrentSettings().MaxOutputLines))
+	}
+	return nil
+}
+
+// gitOutput runs git in dir and returns what it wrote to stdout.
+func gitOutput(dir string, args ...string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s failed: %s: %s", args[0], err, truncateOutput(stderr.String(), CurrentSettings().MaxOutputLines))
+	}
+	return string(out), nil
+}
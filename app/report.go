@@ -0,0 +1,92 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/go-github/v43/github"
+)
+
+var (
+	// ReportBackoff is the delay before the first retry of a report; it
+	// doubles after every attempt.
+	ReportBackoff = 2 * time.Second
+)
+
+// retryableReportError reports whether reporting a result may succeed when
+// retried: network errors, server errors and expired tokens.
+func retryableReportError(err error) bool {
+	var errRes *github.ErrorResponse
+	if !errors.As(err, &errRes) || errRes.Response == nil {
+		return true
+	}
+	code := errRes.Response.StatusCode
+	return code == http.StatusUnauthorized || code >= 500
+}
+
+func isUnauthorized(err error) bool {
+	var errRes *github.ErrorResponse
+	return errors.As(err, &errRes) && errRes.Response != nil && errRes.Response.StatusCode == http.StatusUnauthorized
+}
+
+// reportResult completes the job's check run with result, or creates its
+// commit status if it has no check run. Failures are retried with backoff,
+// since losing the result of a long build to a network blip leaves the check
+// run in progress forever. When the job's token expired during the build, a
+// new one is created if the app holds the private key.
+func (app *GithubApp) reportResult(ctx context.Context, job *Job, check *JobCheck, result *Result) (*github.CheckRun, error) {
+	pending, _ := app.store.(PendingResultStore)
+	if check.CheckRunID == 0 {
+		// Results are saved by check run, which commit statuses lack.
+		pending = nil
+	}
+	if pending != nil {
+		if err := pending.SavePendingResult(ctx, check.CheckRunID, result); err != nil {
+			logFrom(ctx).Warnw("failed to save result", "error", err)
+		}
+	}
+	owner, repo := job.ownerAndRepo()
+	// The job is shared by its checks, so a new token is only used for this
+	// report.
+	ghc := app.jobClient(job)
+	backoff := ReportBackoff
+	attempts := CurrentSettings().ReportAttempts
+	for attempt := 1; ; attempt++ {
+		var run *github.CheckRun
+		var err error
+		if check.CheckRunID == 0 {
+			err = createCommitStatus(ctx, ghc, owner, repo, job.HeadSHA, check.Name, result)
+		} else {
+			run, err = completeCheckRun(ctx, ghc, owner, repo, check.CheckRunID, result, check.Name)
+		}
+		if err == nil {
+			if pending != nil {
+				if err := pending.DeletePendingResult(ctx, check.CheckRunID); err != nil {
+					logFrom(ctx).Warnw("failed to delete saved result", "error", err)
+				}
+			}
+			return run, nil
+		}
+		if attempt >= attempts || !retryableReportError(err) {
+			return nil, err
+		}
+		if isUnauthorized(err) && app.appsTransport != nil {
+			app.invalidateToken(job.InstallationID)
+			token, err := app.Token(ctx, job.InstallationID)
+			if err != nil {
+				logFrom(ctx).Warnw("failed to refresh token", "error", err)
+			} else {
+				ghc = app.newClient(job.InstallationID, &tokenTransport{token: token})
+			}
+		}
+		logFrom(ctx).Infow("failed to report result, retrying", "check_run_id", check.CheckRunID, "error", err, "attempt", attempt, "attempts", attempts, "backoff", backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		backoff *= 2
+	}
+}
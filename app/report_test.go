@@ -0,0 +1,124 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v43/github"
+)
+
+// setReportRetries sets ReportAttempts for the duration of the test, with a
+// backoff short enough not to slow it down. Requests aren't retried by the
+// client, so that every report attempt is a single request.
+func setReportRetries(t *testing.T, attempts int) {
+	setTestSettings(t, func(s *Settings) { s.ReportAttempts = attempts })
+	oldBackoff := ReportBackoff
+	t.Cleanup(func() { ReportBackoff = oldBackoff })
+	ReportBackoff = time.Millisecond
+	setGitHubRetries(t, 0)
+}
+
+// serveCheckRunUpdates answers the updates of check run 7 with statuses, one
+// per request, and returns the Authorization header of every request.
+func serveCheckRunUpdates(f *fakeGitHub, statuses ...int) *[]string {
+	var auths []string
+	f.handle("PATCH /repos/o/r/check-runs/7", func(w http.ResponseWriter, req *http.Request) {
+		status := statuses[len(statuses)-1]
+		if len(auths) < len(statuses) {
+			status = statuses[len(auths)]
+		}
+		auths = append(auths, req.Header.Get("Authorization"))
+		writeTestJSON(w, status, map[string]interface{}{"id": 7})
+	})
+	return &auths
+}
+
+func testReport(t *testing.T, f *fakeGitHub) (*GithubApp, *memStore, error) {
+	app := newTestApp(t, f)
+	store := newMemStore()
+	app.SetStore(store)
+	job := &Job{InstallationID: testInstallationID, FullRepoName: "o/r", HeadSHA: "abc", Token: "job-token"}
+	_, err := app.reportResult(context.Background(), job, &JobCheck{Name: nogoCheck, CheckRunID: 7}, &Result{Conclusion: "success"})
+	return app, store, err
+}
+
+func TestReportResultRetries(t *testing.T) {
+	setReportRetries(t, 3)
+	f := newFakeGitHub(t)
+	auths := serveCheckRunUpdates(f, http.StatusBadGateway, http.StatusOK)
+
+	_, store, err := testReport(t, f)
+	if err != nil {
+		t.Fatalf("reportResult: %s", err)
+	}
+	if len(*auths) != 2 {
+		t.Errorf("updated the check run %d times, want a retry after the server error", len(*auths))
+	}
+	if len(store.pending) != 0 {
+		t.Errorf("got pending results %v after the report, want none", store.pending)
+	}
+}
+
+func TestReportResultKeepsUnreportedResults(t *testing.T) {
+	setReportRetries(t, 2)
+	f := newFakeGitHub(t)
+	auths := serveCheckRunUpdates(f, http.StatusInternalServerError)
+
+	_, store, err := testReport(t, f)
+	if err == nil {
+		t.Fatal("reportResult succeeded although every update failed")
+	}
+	if len(*auths) != 2 {
+		t.Errorf("updated the check run %d times, want ReportAttempts", len(*auths))
+	}
+	if result := store.pending[7]; result == nil || result.Conclusion != "success" {
+		t.Errorf("got pending result %+v, want the unreported result", result)
+	}
+}
+
+func TestReportResultDoesNotRetryClientErrors(t *testing.T) {
+	setReportRetries(t, 3)
+	f := newFakeGitHub(t)
+	auths := serveCheckRunUpdates(f, http.StatusUnprocessableEntity)
+
+	if _, _, err := testReport(t, f); err == nil {
+		t.Fatal("reportResult succeeded although the update was rejected")
+	}
+	if len(*auths) != 1 {
+		t.Errorf("updated the check run %d times, want no retry of a rejected update", len(*auths))
+	}
+}
+
+func TestReportResultRefreshesExpiredToken(t *testing.T) {
+	setReportRetries(t, 3)
+	f := newFakeGitHub(t)
+	auths := serveCheckRunUpdates(f, http.StatusUnauthorized, http.StatusOK)
+
+	if _, _, err := testReport(t, f); err != nil {
+		t.Fatalf("reportResult: %s", err)
+	}
+	want := []string{"token job-token", "token test-token"}
+	if len(*auths) != 2 || (*auths)[0] != want[0] || (*auths)[1] != want[1] {
+		t.Errorf("updated the check run with %q, want %q", *auths, want)
+	}
+}
+
+func TestRetryableReportError(t *testing.T) {
+	for status, want := range map[int]bool{
+		http.StatusUnauthorized:        true,
+		http.StatusBadGateway:          true,
+		http.StatusNotFound:            false,
+		http.StatusUnprocessableEntity: false,
+	} {
+		err := &github.ErrorResponse{Response: &http.Response{StatusCode: status}}
+		if got := retryableReportError(err); got != want {
+			t.Errorf("retryableReportError of status %d = %t, want %t", status, got, want)
+		}
+	}
+	if !retryableReportError(errors.New("connection reset")) {
+		t.Errorf("network errors aren't retried")
+	}
+}
@@ -0,0 +1,87 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// resultCacheKey returns the key that the results of job's checks are cached
+// under: the hash of the tree of its head commit and, since checks look at
+// the changes of pull requests, that of its base. It returns "" if results
+// aren't cached.
+func (app *GithubApp) resultCacheKey(ctx context.Context, job *Job) string {
+	if CurrentSettings().ResultCacheTTL <= 0 {
+		return ""
+	}
+	if _, ok := app.store.(ResultCache); !ok {
+		return ""
+	}
+	owner, repo := job.ownerAndRepo()
+	ghc := app.jobClient(job)
+	var trees []string
+	for _, sha := range []string{job.HeadSHA, job.BaseSHA} {
+		if sha == "" {
+			continue
+		}
+		commit, res, err := ghc.Git.GetCommit(ctx, owner, repo, sha)
+		if err := extractError(ctx, res, err); err != nil {
+			logFrom(ctx).Warnw("failed to look up tree of commit", "sha", sha, "error", err)
+			return ""
+		}
+		trees = append(trees, commit.GetTree().GetSHA())
+	}
+	return strings.Join(trees, ":")
+}
+
+// cacheResult caches the result of a job's check under the key of its
+// target, unless it failed.
+func (app *GithubApp) cacheResult(ctx context.Context, job *Job, checkName string, target *CheckTarget, result *Result) {
+	if target.cacheKey == "" || failedConclusion(result.Conclusion) {
+		return
+	}
+	rc := app.store.(ResultCache)
+	if err := rc.CacheResult(ctx, job.FullRepoName, target.cacheKey, job.HeadSHA, checkName, result); err != nil {
+		logFrom(ctx).Warnw("failed to cache result", "error", err)
+	}
+}
+
+// reuseCachedResult reports the result of a job's check cached under key, if
+// there is one, and returns it. It returns nil if the check has to run.
+func (app *GithubApp) reuseCachedResult(ctx context.Context, job *Job, check *JobCheck, key string) *Result {
+	if key == "" {
+		return nil
+	}
+	ctx = withLogFields(ctx, "check", check.Name)
+	rc := app.store.(ResultCache)
+	result, sha, err := rc.CachedResult(ctx, job.FullRepoName, key, check.Name, time.Now().Add(-CurrentSettings().ResultCacheTTL))
+	if err != nil {
+		logFrom(ctx).Warnw("failed to look up cached result", "error", err)
+		return nil
+	}
+	if result == nil {
+		return nil
+	}
+	if sha != job.HeadSHA {
+		if len(sha) > 7 {
+			sha = sha[:7]
+		}
+		if result.Summary != "" {
+			result.Summary += "\n\n"
+		}
+		result.Summary += fmt.Sprintf("Reused the result of %s, which has the same content.", sha)
+	}
+	app.recordStart(ctx, job, check)
+	if app.saveResult(ctx, job, check.Name, result) && result.URL == "" {
+		result.URL = resultsURL(job.FullRepoName, job.HeadSHA, check.Name)
+	}
+	updateRun, err := app.reportResult(ctx, job, check, result)
+	if err != nil {
+		logFrom(ctx).Warnw("failed to report cached result", "error", err)
+		return nil
+	}
+	logFrom(ctx).Infow("check run completed with cached result", "check_run_id", updateRun.GetID(), "conclusion", result.Conclusion, "cached_sha", sha)
+	app.recordResult(ctx, check, result)
+	return result
+}
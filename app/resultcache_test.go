@@ -0,0 +1,123 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func setResultCacheTTL(t *testing.T, ttl time.Duration) {
+	setTestSettings(t, func(s *Settings) { s.ResultCacheTTL = ttl })
+}
+
+// serveCommitTrees lets f return the tree of each commit in trees.
+func serveCommitTrees(f *fakeGitHub, trees map[string]string) {
+	for sha, tree := range trees {
+		sha, tree := sha, tree
+		f.handle("GET /repos/o/r/git/commits/"+sha, func(w http.ResponseWriter, req *http.Request) {
+			writeTestJSON(w, http.StatusOK, map[string]interface{}{"sha": sha, "tree": map[string]string{"sha": tree}})
+		})
+	}
+}
+
+func TestResultCacheKey(t *testing.T) {
+	f := newFakeGitHub(t)
+	serveCommitTrees(f, map[string]string{"abc": "tree-abc", "def": "tree-def"})
+	app := newTestApp(t, f)
+	job := &Job{InstallationID: testInstallationID, FullRepoName: "o/r", HeadSHA: "abc", Token: "job-token"}
+	ctx := context.Background()
+
+	setResultCacheTTL(t, time.Hour)
+	if key := app.resultCacheKey(ctx, job); key != "" {
+		t.Errorf("got key %q without a store, want none", key)
+	}
+	app.SetStore(newMemStore())
+	if key := app.resultCacheKey(ctx, job); key != "tree-abc" {
+		t.Errorf("got key %q, want the tree of the head commit", key)
+	}
+	job.BaseSHA = "def"
+	if key := app.resultCacheKey(ctx, job); key != "tree-abc:tree-def" {
+		t.Errorf("got key %q, want the trees of the head and base commits", key)
+	}
+	job.BaseSHA = "missing"
+	if key := app.resultCacheKey(ctx, job); key != "" {
+		t.Errorf("got key %q for a commit that can't be looked up, want none", key)
+	}
+
+	setResultCacheTTL(t, 0)
+	job.BaseSHA = ""
+	if key := app.resultCacheKey(ctx, job); key != "" {
+		t.Errorf("got key %q with the cache disabled, want none", key)
+	}
+}
+
+func TestRunJobReusesCachedResults(t *testing.T) {
+	setResultCacheTTL(t, time.Hour)
+	registerTestChecker(t, &funcChecker{name: "test-cached", fn: func(context.Context, *GithubApp, *CheckTarget) (*Result, error) {
+		t.Errorf("ran a check with a cached result")
+		return &Result{Conclusion: "success"}, nil
+	}})
+	f := newFakeGitHub(t)
+	serveCommitTrees(f, map[string]string{"abc": "tree"})
+	serveRepoConfig(f, "")
+	completed := completedCheckRuns(t, f, "7")
+	app := newTestApp(t, f)
+	store := newMemStore()
+	app.SetStore(store)
+	store.CacheResult(context.Background(), "o/r", "tree", "0123456789", "test-cached", &Result{Conclusion: "success", Summary: "No issues."})
+	job := &Job{AppID: testAppID, InstallationID: testInstallationID, FullRepoName: "o/r", HeadSHA: "abc", Token: "job-token", Checks: []*JobCheck{{Name: "test-cached", CheckRunID: 7}}}
+
+	// The repository isn't served, so cloning it would fail.
+	if err := app.RunJob(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case opts := <-completed:
+		if opts.GetConclusion() != "success" || opts.Output.GetSummary() != "No issues.\n\nReused the result of 0123456, which has the same content." {
+			t.Errorf("completed the check run %s with %q, want the cached result", opts.GetConclusion(), opts.Output.GetSummary())
+		}
+	default:
+		t.Fatal("check run wasn't completed")
+	}
+	if r := store.runs[7]; r == nil || r.Conclusion != "success" {
+		t.Errorf("recorded check run %+v, want the cached result recorded", r)
+	}
+}
+
+func TestRunJobCachesResultsThatDidNotFail(t *testing.T) {
+	setResultCacheTTL(t, time.Hour)
+	setWorkspaceDir(t, 0)
+	registerTestChecker(t, &funcChecker{name: "test-passing", fn: func(context.Context, *GithubApp, *CheckTarget) (*Result, error) {
+		return &Result{Conclusion: "success", Title: "ok"}, nil
+	}})
+	registerTestChecker(t, &funcChecker{name: "test-failing", fn: func(context.Context, *GithubApp, *CheckTarget) (*Result, error) {
+		return &Result{Conclusion: "failure", Title: "1 issue"}, nil
+	}})
+	src := t.TempDir()
+	testGit(t, src, "init", "-q", "-b", "main")
+	sha := commitTestFile(t, src, "main.go", "package main\n")
+	f := newFakeGitHub(t)
+	serveTestRepo(t, f, src, nil)
+	serveCommitTrees(f, map[string]string{sha: "tree"})
+	serveRepoConfig(f, "")
+	completedCheckRuns(t, f, "1")
+	completedCheckRuns(t, f, "2")
+	app := newTestApp(t, f)
+	store := newMemStore()
+	app.SetStore(store)
+	job := &Job{AppID: testAppID, InstallationID: testInstallationID, FullRepoName: "o/r", HeadSHA: sha, Token: "job-token", Checks: []*JobCheck{
+		{Name: "test-passing", CheckRunID: 1},
+		{Name: "test-failing", CheckRunID: 2},
+	}}
+
+	if err := app.RunJob(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+	if result, cachedSHA, _ := store.CachedResult(context.Background(), "o/r", "tree", "test-passing", time.Time{}); result == nil || result.Title != "ok" || cachedSHA != sha {
+		t.Errorf("cached %+v of %q for the passing check, want its result", result, cachedSHA)
+	}
+	if result, _, _ := store.CachedResult(context.Background(), "o/r", "tree", "test-failing", time.Time{}); result != nil {
+		t.Errorf("cached %+v for the failing check, want it to run again", result)
+	}
+}
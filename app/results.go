@@ -0,0 +1,127 @@
+package app
+
+import (
+	"context"
+	"crypto/subtle"
+	_ "embed"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+//go:embed results.html
+var resultsHTML string
+
+var resultsTemplate = template.Must(template.New("results").Parse(resultsHTML))
+
+// resultsPage is what the results page of a check is rendered from.
+type resultsPage struct {
+	Repo     string
+	SHA      string
+	ShortSHA string
+	Check    string
+	// Result is nil if the check didn't complete.
+	Result *Result
+	// Running is set while the check runs, when the page follows its log.
+	Running bool
+	// Log is the output of a check that failed to run.
+	Log string
+}
+
+// saveResult stores the result of a job's check for the results page, if the
+// app's store implements ResultStore, and reports whether it did.
+func (app *GithubApp) saveResult(ctx context.Context, job *Job, checkName string, result *Result) bool {
+	rs, ok := app.store.(ResultStore)
+	if !ok {
+		return false
+	}
+	if err := rs.SaveResult(ctx, job.FullRepoName, job.HeadSHA, checkName, result); err != nil {
+		logFrom(ctx).Warnw("failed to save result", "error", err)
+		return false
+	}
+	return true
+}
+
+// ResultsHandler serves the stored result of each check, including its full
+// log, under /results/{owner}/{repo}/{sha}/{check}. Check runs without a URL
+// of their own link there when PublicURL is set. While a check runs, the page
+// follows its log, which is served as server-sent events under
+// /results/{owner}/{repo}/{sha}/{check}/log if the store implements LogStore.
+// If password is set, it is required as the password of HTTP basic auth.
+func (app *GithubApp) ResultsHandler(password string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if password != "" {
+			_, p, _ := req.BasicAuth()
+			if subtle.ConstantTimeCompare([]byte(p), []byte(password)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="reviewbot"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		if req.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		rs, ok := app.store.(ResultStore)
+		if !ok {
+			http.Error(w, "no result store is configured", http.StatusNotFound)
+			return
+		}
+		// Check names are escaped in the path, see resultsURL.
+		parts := strings.Split(strings.Trim(strings.TrimPrefix(req.URL.EscapedPath(), "/results"), "/"), "/")
+		live := len(parts) == 5 && parts[4] == "log"
+		if len(parts) != 4 && !live {
+			http.NotFound(w, req)
+			return
+		}
+		checkName, err := url.PathUnescape(parts[3])
+		if err != nil {
+			http.Error(w, "invalid check name", http.StatusBadRequest)
+			return
+		}
+		page := &resultsPage{
+			Repo:     parts[0] + "/" + parts[1],
+			SHA:      parts[2],
+			ShortSHA: parts[2],
+			Check:    checkName,
+		}
+		if len(page.ShortSHA) > 7 {
+			page.ShortSHA = page.ShortSHA[:7]
+		}
+		ls, _ := app.store.(LogStore)
+		if live {
+			if ls == nil {
+				http.Error(w, "no log store is configured", http.StatusNotFound)
+				return
+			}
+			serveLiveLog(w, req, ls, page.Repo, page.SHA, page.Check)
+			return
+		}
+		page.Result, err = rs.Result(req.Context(), page.Repo, page.SHA, page.Check)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if ls != nil {
+			// The result may be of a previous run.
+			log, chunks, done, err := ls.ReadLog(req.Context(), page.Repo, page.SHA, page.Check, 0)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			page.Running = chunks > 0 && !done
+			if page.Result == nil && done {
+				page.Log = truncateOutput(log, CurrentSettings().MaxOutputLines)
+			}
+		}
+		if page.Result == nil && !page.Running && page.Log == "" {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := resultsTemplate.Execute(w, page); err != nil {
+			logFrom(req.Context()).Warnw("failed to render results page", "error", err)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func resultsRequest(app *GithubApp, password string, target string, auth string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	if auth != "" {
+		req.SetBasicAuth("user", auth)
+	}
+	w := httptest.NewRecorder()
+	app.ResultsHandler(password)(w, req)
+	return w
+}
+
+func TestResultsHandler(t *testing.T) {
+	store := newMemStore()
+	store.SaveResult(context.Background(), "o/r", "abcdef123456", "my lint", &Result{
+		Conclusion:  "failure",
+		Title:       "1 issue",
+		Annotations: []*Annotation{{Path: "main.go", Line: 3, Severity: "failure", Message: "unused variable"}},
+		Log:         "$ lint ./...\n<script>alert(1)</script>\n",
+	})
+	app := &GithubApp{}
+	app.SetStore(store)
+
+	w := resultsRequest(app, "", "/results/o/r/abcdef123456/my%20lint", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200: %s", w.Code, w.Body)
+	}
+	body := w.Body.String()
+	for _, want := range []string{"my lint on o/r@abcdef1", "1 issue", "main.go:3", "unused variable", "$ lint ./...", "&lt;script&gt;"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("results page doesn't contain %q:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "<script>") {
+		t.Errorf("results page doesn't escape the log:\n%s", body)
+	}
+
+	for _, target := range []string{"/results/o/r/abcdef123456/other", "/results/o/r/abcdef123456", "/results/o/r/abc/my%20lint/x"} {
+		if w := resultsRequest(app, "", target, ""); w.Code != http.StatusNotFound {
+			t.Errorf("got status %d for %s, want 404", w.Code, target)
+		}
+	}
+}
+
+func TestResultsHandlerWithoutResultStore(t *testing.T) {
+	if w := resultsRequest(&GithubApp{}, "", "/results/o/r/abc/lint", ""); w.Code != http.StatusNotFound {
+		t.Errorf("got status %d without a result store, want 404", w.Code)
+	}
+}
+
+func TestResultsHandlerRequiresPassword(t *testing.T) {
+	store := newMemStore()
+	store.SaveResult(context.Background(), "o/r", "abc", "lint", &Result{Conclusion: "success"})
+	app := &GithubApp{}
+	app.SetStore(store)
+
+	for _, auth := range []string{"", "wrong"} {
+		w := resultsRequest(app, "secret", "/results/o/r/abc/lint", auth)
+		if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("got status %d with password %q, want a basic auth challenge", w.Code, auth)
+		}
+	}
+	if w := resultsRequest(app, "secret", "/results/o/r/abc/lint", "secret"); w.Code != http.StatusOK {
+		t.Errorf("got status %d with the password, want 200", w.Code)
+	}
+}
+
+func TestRunJobCheckLinksResultsPage(t *testing.T) {
+	setPublicURL(t, "https://reviewbot.example.com")
+	registerTestChecker(t, &funcChecker{name: "test-logged", fn: func(ctx context.Context, app *GithubApp, target *CheckTarget) (*Result, error) {
+		if _, _, err := runCheckCmd(ctx, target, "echo", "checked"); err != nil {
+			return nil, err
+		}
+		return &Result{Conclusion: "success", Title: "ok"}, nil
+	}})
+	f := newFakeGitHub(t)
+	completed := completedCheckRuns(t, f, "7")
+	app := newTestApp(t, f)
+	store := newMemStore()
+	app.SetStore(store)
+	job := &Job{AppID: testAppID, InstallationID: testInstallationID, FullRepoName: "o/r", HeadSHA: "abc", Token: "job-token"}
+	target := &CheckTarget{InstallationID: testInstallationID, FullRepoName: "o/r", HeadSHA: "abc", Dir: t.TempDir(), Config: &CheckConfig{}, log: &commandLog{}}
+
+	if _, err := app.runJobCheck(context.Background(), job, &JobCheck{Name: "test-logged", CheckRunID: 7}, target); err != nil {
+		t.Fatal(err)
+	}
+	opts := <-completed
+	if want := "https://reviewbot.example.com/results/o/r/abc/test-logged"; opts.GetDetailsURL() != want {
+		t.Errorf("got details URL %q, want the results page %q", opts.GetDetailsURL(), want)
+	}
+	result, _ := store.Result(context.Background(), "o/r", "abc", "test-logged")
+	if result == nil || result.Log != "$ echo checked\nchecked\n" {
+		t.Errorf("saved result %+v, want it with the log of the check's commands", result)
+	}
+}
@@ -0,0 +1,46 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/go-github/v43/github"
+)
+
+var (
+	// NewCheckRunBackoff is the delay before the first retry; it doubles after
+	// every attempt.
+	NewCheckRunBackoff = 250 * time.Millisecond
+)
+
+func isNotFound(err error) bool {
+	var errRes *github.ErrorResponse
+	if errors.As(err, &errRes) && errRes.Response != nil {
+		return errRes.Response.StatusCode == http.StatusNotFound
+	}
+	return false
+}
+
+// updateNewCheckRun updates a check run that was created moments ago. GitHub
+// is eventually consistent, so the update may briefly 404; those responses are
+// retried with backoff. A 404 that persists past the last attempt is returned.
+func updateNewCheckRun(ctx context.Context, ghc *github.Client, owner string, repo string, id int64, opts github.UpdateCheckRunOptions) (*github.CheckRun, error) {
+	backoff := NewCheckRunBackoff
+	attempts := CurrentSettings().NewCheckRunAttempts
+	for attempt := 1; ; attempt++ {
+		run, res, err := ghc.Checks.UpdateCheckRun(ctx, owner, repo, id, opts)
+		err = extractError(ctx, res, err)
+		if err == nil || !isNotFound(err) || attempt >= attempts {
+			return run, err
+		}
+		logFrom(ctx).Infow("check run not found, retrying", "check_run_id", id, "attempt", attempt, "attempts", attempts, "backoff", backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,71 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v43/github"
+)
+
+func setNewCheckRunRetries(t *testing.T, attempts int, backoff time.Duration) {
+	setTestSettings(t, func(s *Settings) { s.NewCheckRunAttempts = attempts })
+	oldBackoff := NewCheckRunBackoff
+	NewCheckRunBackoff = backoff
+	t.Cleanup(func() { NewCheckRunBackoff = oldBackoff })
+}
+
+func TestUpdateNewCheckRunRetriesNotFound(t *testing.T) {
+	setNewCheckRunRetries(t, 4, time.Millisecond)
+	f := newFakeGitHub(t)
+	f.handle("PATCH /repos/o/r/check-runs/1", func(w http.ResponseWriter, req *http.Request) {
+		if f.count("PATCH /repos/o/r/check-runs/1") == 1 {
+			writeTestJSON(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+			return
+		}
+		writeTestJSON(w, http.StatusOK, map[string]interface{}{"id": 1, "status": "in_progress"})
+	})
+	ghc := setClientURLs(github.NewClient(nil))
+
+	run, err := updateNewCheckRun(context.Background(), ghc, "o", "r", 1, github.UpdateCheckRunOptions{Name: "bazel", Status: github.String("in_progress")})
+	if err != nil {
+		t.Fatalf("updateNewCheckRun: %s", err)
+	}
+	if run.GetStatus() != "in_progress" {
+		t.Errorf("got check run status %q, want in_progress", run.GetStatus())
+	}
+	if got := f.count("PATCH /repos/o/r/check-runs/1"); got != 2 {
+		t.Errorf("check run was updated %d times, want 2", got)
+	}
+}
+
+func TestUpdateNewCheckRunGivesUp(t *testing.T) {
+	setNewCheckRunRetries(t, 3, time.Millisecond)
+	f := newFakeGitHub(t)
+	ghc := setClientURLs(github.NewClient(nil))
+
+	_, err := updateNewCheckRun(context.Background(), ghc, "o", "r", 1, github.UpdateCheckRunOptions{Name: "bazel"})
+	if !isNotFound(err) {
+		t.Errorf("got error %v, want a 404", err)
+	}
+	if got := f.count("PATCH /repos/o/r/check-runs/1"); got != 3 {
+		t.Errorf("check run was updated %d times, want 3", got)
+	}
+}
+
+func TestUpdateNewCheckRunDoesNotRetryOtherErrors(t *testing.T) {
+	setNewCheckRunRetries(t, 3, time.Millisecond)
+	f := newFakeGitHub(t)
+	f.handle("PATCH /repos/o/r/check-runs/1", func(w http.ResponseWriter, req *http.Request) {
+		writeTestJSON(w, http.StatusUnprocessableEntity, map[string]string{"message": "Validation Failed"})
+	})
+	ghc := setClientURLs(github.NewClient(nil))
+
+	if _, err := updateNewCheckRun(context.Background(), ghc, "o", "r", 1, github.UpdateCheckRunOptions{Name: "bazel"}); err == nil {
+		t.Errorf("updateNewCheckRun succeeded, want an error")
+	}
+	if got := f.count("PATCH /repos/o/r/check-runs/1"); got != 1 {
+		t.Errorf("check run was updated %d times, want once", got)
+	}
+}
@@ -0,0 +1,86 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+)
+
+const ruffCheck = "ruff"
+
+// ruffReport is the output of `ruff check --output-format=json`.
+type ruffReport []struct {
+	Code     string `json:"code"`
+	Message  string `json:"message"`
+	Filename string `json:"filename"`
+	URL      string `json:"url"`
+	Location struct {
+		Row    int `json:"row"`
+		Column int `json:"column"`
+	} `json:"location"`
+}
+
+func init() {
+	RegisterChecker(&funcChecker{
+		name:         ruffCheck,
+		fn:           checkRuff,
+		optIn:        true,
+		requirements: CheckRequirements{Binaries: []string{"ruff"}},
+		rule:         CheckRule{Description: "Python files pass ruff.", HelpURI: "https://docs.astral.sh/ruff/rules/"},
+	})
+}
+
+// checkRuff lints the Python files of the checkout with ruff and the
+// repository's ruff configuration.
+func checkRuff(ctx context.Context, _ *GithubApp, target *CheckTarget) (*Result, error) {
+	tool := target.Config.ToolOr("ruff")
+	args := append([]string{"check", "--output-format=json"}, target.Config.Flags...)
+	// ruff exits with 1 when it finds issues and 2 when it fails.
+	stdOut, stdErr, err := runCheckCmd(ctx, target, tool, append(args, ".")...)
+	if code := exitCode(err); (code != 0 && code != 1) || stdOut.Len() == 0 {
+		if err != nil {
+			return toolFailure(tool, err.Error(), stdErr.String()), nil
+		}
+		return toolFailure(tool, "no output", stdErr.String()), nil
+	}
+	report := ruffReport{}
+	if err := json.Unmarshal(stdOut.Bytes(), &report); err != nil {
+		return toolFailure(tool, fmt.Sprintf("unparsable output: %s", err), stdErr.String()), nil
+	}
+
+	annotations := []*Annotation{}
+	for _, issue := range report {
+		// ruff and ESLint report absolute paths. They are the same inside
+		// sandbox containers, which mount the checkout at its host path.
+		path, err := filepath.Rel(target.Dir, issue.Filename)
+		if err != nil {
+			path = issue.Filename
+		}
+		message := fmt.Sprintf("%s: %s", issue.Code, issue.Message)
+		if issue.URL != "" {
+			message = fmt.Sprintf("%s (%s)", message, issue.URL)
+		}
+		annotations = append(annotations, &Annotation{
+			Message:  cleanLine(message),
+			Severity: "failure",
+			Path:     filepath.ToSlash(path),
+			Line:     issue.Location.Row,
+			Column:   issue.Location.Column,
+		})
+	}
+	annotations = target.Config.filterAnnotations(annotations)
+
+	res := &Result{
+		Title: fmt.Sprintf("%s result", tool),
+	}
+	if len(annotations) == 0 {
+		res.Summary = "No issues found."
+		res.Conclusion = "success"
+		return res, nil
+	}
+	res.Summary = fmt.Sprintf("%d issues found", len(annotations))
+	res.Conclusion = "failure"
+	res.Annotations = annotations
+	return res, nil
+}
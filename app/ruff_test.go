@@ -0,0 +1,58 @@
+package app
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCheckRuff(t *testing.T) {
+	tool := writeFakeTool(t, `[ "$1" = check ] || exit 2
+cat <<REPORT
+[
+  {"code": "F401", "message": "'os' imported but unused", "filename": "$PWD/src/app.py", "url": "https://docs.astral.sh/ruff/rules/unused-import", "location": {"row": 1, "column": 8}},
+  {"code": "E501", "message": "Line too long (120 > 88)", "filename": "$PWD/src/app.py", "location": {"row": 10, "column": 89}}
+]
+REPORT
+exit 1
+`)
+	res, err := checkRuff(context.Background(), nil, &CheckTarget{Dir: t.TempDir(), Config: &CheckConfig{Tool: tool}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Conclusion != "failure" || res.Summary != "2 issues found" {
+		t.Errorf("got %s with summary %q, want a failure with 2 issues", res.Conclusion, res.Summary)
+	}
+	want := []Annotation{
+		{Path: "src/app.py", Line: 1, Column: 8, Message: "F401: 'os' imported but unused (https://docs.astral.sh/ruff/rules/unused-import)", Severity: "failure"},
+		{Path: "src/app.py", Line: 10, Column: 89, Message: "E501: Line too long (120 > 88)", Severity: "failure"},
+	}
+	if len(res.Annotations) != len(want) {
+		t.Fatalf("got %d annotations, want %d", len(res.Annotations), len(want))
+	}
+	for i, a := range res.Annotations {
+		if *a != want[i] {
+			t.Errorf("annotation %d: got %+v, want %+v", i, *a, want[i])
+		}
+	}
+}
+
+func TestCheckRuffWithoutIssues(t *testing.T) {
+	tool := writeFakeTool(t, "echo '[]'\n")
+	res, err := checkRuff(context.Background(), nil, &CheckTarget{Dir: t.TempDir(), Config: &CheckConfig{Tool: tool}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Conclusion != "success" {
+		t.Errorf("got conclusion %q, want success", res.Conclusion)
+	}
+}
+
+func TestRuffReportsToolFailures(t *testing.T) {
+	testToolFailures(t, checkRuff, map[string]string{
+		"echo 'ruff failed: invalid pyproject.toml' >&2; exit 2": "invalid pyproject.toml",
+		// ruff exits with 2 when it fails, even if it printed a report.
+		"echo '[]'; echo 'error: Failed to parse pyproject.toml' >&2; exit 2": "Failed to parse pyproject.toml",
+		"exit 0":           "no output",
+		"echo '['; exit 1": "unparsable output",
+	})
+}
@@ -0,0 +1,96 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+)
+
+// ContainerExecutor runs each check tool in a fresh container that only has
+// access to the checkout, since building untrusted code runs its repository
+// rules.
+type ContainerExecutor struct {
+	// Runtime is the container runtime, "docker" or "podman".
+	Runtime string
+	// Image must contain the tools of every enabled check.
+	Image string
+	// CPUs limits the CPUs available to a container, e.g. "4". Empty means
+	// no limit.
+	CPUs string
+	// Memory limits the memory available to a container, e.g. "8g". Empty
+	// means no limit.
+	Memory string
+	// Network is the network containers are attached to, "none" if empty.
+	// To reach the remote cache, create a network that only routes to it.
+	Network string
+}
+
+func (e *ContainerExecutor) Run(ctx context.Context, dir string, toolName string, arg ...string) (bytes.Buffer, bytes.Buffer, error) {
+	name, err := containerName()
+	if err != nil {
+		return bytes.Buffer{}, bytes.Buffer{}, err
+	}
+	network := e.Network
+	if network == "" {
+		network = "none"
+	}
+	args := []string{
+		"run", "--rm", "--init",
+		"--name", name,
+		"--network", network,
+		"--user", fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid()),
+		// Mount the checkout at the same path, so that paths in the tool's
+		// output match the host.
+		"--volume", fmt.Sprintf("%s:%s", dir, dir),
+		"--workdir", dir,
+	}
+	cpus, memory := e.CPUs, e.Memory
+	l := limiterFrom(ctx)
+	if l != nil {
+		// The limits of the check can only tighten those of the executor.
+		if c, err := strconv.ParseFloat(cpus, 64); l.limits.CPUs > 0 && (err != nil || l.limits.CPUs < c) {
+			cpus = strconv.FormatFloat(l.limits.CPUs, 'f', -1, 64)
+		}
+		if m, err := parseMemory(memory); l.memory > 0 && (err != nil || m == 0 || l.memory < m) {
+			memory = strconv.FormatInt(l.memory, 10)
+		}
+	}
+	if cpus != "" {
+		args = append(args, "--cpus", cpus)
+	}
+	if memory != "" {
+		args = append(args, "--memory", memory)
+	}
+	args = append(args, e.Image, toolName)
+	args = append(args, arg...)
+	stdOut, stdErr, err := runCmdInDir(ctx, dir, e.Runtime, args...)
+	var exitErr *exec.ExitError
+	if l != nil && memory != "" && ctx.Err() == nil && errors.As(err, &exitErr) && exitErr.ExitCode() == oomExitCode {
+		l.violated("The memory limit of %s was exceeded: the container was killed.", memory)
+	}
+	if ctx.Err() != nil {
+		// Killing the client doesn't stop the container.
+		if _, _, err := runCmdInDir(context.Background(), "", e.Runtime, "rm", "--force", name); err != nil {
+			logFrom(ctx).Warnw("failed to remove container", "container", name, "error", err)
+		}
+	}
+	return stdOut, stdErr, err
+}
+
+// oomExitCode is the exit code of containers killed for exceeding their
+// memory limit, that of a SIGKILL.
+const oomExitCode = 137
+
+func containerName() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "reviewbot-" + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,143 @@
+package app
+
+import (
+	"sort"
+)
+
+// sarifSchema is the schema of the SARIF documents produced.
+const sarifSchema = "https://json.schemastore.org/sarif-2.1.0.json"
+
+// SARIFLog is a SARIF 2.1.0 document with the subset of properties that
+// check results map to.
+type SARIFLog struct {
+	Schema  string      `json:"$schema"`
+	Version string      `json:"version"`
+	Runs    []*SARIFRun `json:"runs"`
+}
+
+type SARIFRun struct {
+	Tool    SARIFTool      `json:"tool"`
+	Results []*SARIFResult `json:"results"`
+	// AutomationDetails sets the category of the run in code scanning, so
+	// that uploads of different checks don't replace each other's alerts.
+	AutomationDetails *SARIFAutomationDetails `json:"automationDetails,omitempty"`
+}
+
+type SARIFAutomationDetails struct {
+	ID string `json:"id"`
+}
+
+type SARIFTool struct {
+	Driver SARIFDriver `json:"driver"`
+}
+
+type SARIFDriver struct {
+	Name           string       `json:"name"`
+	InformationURI string       `json:"informationUri,omitempty"`
+	Rules          []*SARIFRule `json:"rules"`
+}
+
+type SARIFRule struct {
+	ID               string        `json:"id"`
+	ShortDescription *SARIFMessage `json:"shortDescription,omitempty"`
+	HelpURI          string        `json:"helpUri,omitempty"`
+}
+
+type SARIFResult struct {
+	RuleID    string           `json:"ruleId"`
+	Level     string           `json:"level"`
+	Message   SARIFMessage     `json:"message"`
+	Locations []*SARIFLocation `json:"locations"`
+}
+
+type SARIFMessage struct {
+	Text string `json:"text"`
+}
+
+type SARIFLocation struct {
+	PhysicalLocation SARIFPhysicalLocation `json:"physicalLocation"`
+}
+
+type SARIFPhysicalLocation struct {
+	ArtifactLocation SARIFArtifactLocation `json:"artifactLocation"`
+	Region           SARIFRegion           `json:"region"`
+}
+
+type SARIFArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type SARIFRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+}
+
+// sarifLevel maps an annotation severity to a SARIF result level.
+func sarifLevel(severity string) string {
+	switch severity {
+	case "failure":
+		return "error"
+	case "warning":
+		return "warning"
+	}
+	return "note"
+}
+
+// sarifRule returns the rule of the check checkName, described by its
+// checker if it implements CheckerWithRule.
+func sarifRule(checkName string) *SARIFRule {
+	rule := &SARIFRule{ID: checkName}
+	checker, err := GetChecker(checkName)
+	if err != nil {
+		return rule
+	}
+	if c, ok := checker.(CheckerWithRule); ok {
+		r := c.Rule()
+		if r.Description != "" {
+			rule.ShortDescription = &SARIFMessage{Text: r.Description}
+		}
+		rule.HelpURI = r.HelpURI
+	}
+	return rule
+}
+
+// ToSARIF converts the annotations of check results, keyed by check name, to
+// a SARIF document with a run per check.
+func ToSARIF(results map[string]*Result) *SARIFLog {
+	names := make([]string, 0, len(results))
+	for name := range results {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	log := &SARIFLog{
+		Schema:  sarifSchema,
+		Version: "2.1.0",
+		Runs:    []*SARIFRun{},
+	}
+	for _, name := range names {
+		run := &SARIFRun{
+			Tool: SARIFTool{Driver: SARIFDriver{
+				Name:  name,
+				Rules: []*SARIFRule{sarifRule(name)},
+			}},
+			Results: []*SARIFResult{},
+		}
+		for _, a := range results[name].Annotations {
+			line := a.Line
+			if line < 1 {
+				line = 1
+			}
+			run.Results = append(run.Results, &SARIFResult{
+				RuleID:  name,
+				Level:   sarifLevel(a.Severity),
+				Message: SARIFMessage{Text: a.Message},
+				Locations: []*SARIFLocation{{PhysicalLocation: SARIFPhysicalLocation{
+					ArtifactLocation: SARIFArtifactLocation{URI: a.Path},
+					Region:           SARIFRegion{StartLine: line, StartColumn: a.Column},
+				}}},
+			})
+		}
+		log.Runs = append(log.Runs, run)
+	}
+	return log
+}
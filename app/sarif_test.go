@@ -0,0 +1,68 @@
+package app
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSARIFLevel(t *testing.T) {
+	for severity, want := range map[string]string{
+		"failure": "error",
+		"warning": "warning",
+		"notice":  "note",
+		"":        "note",
+	} {
+		if got := sarifLevel(severity); got != want {
+			t.Errorf("sarifLevel(%q) = %q, want %q", severity, got, want)
+		}
+	}
+}
+
+func TestToSARIF(t *testing.T) {
+	log := ToSARIF(map[string]*Result{
+		gofmtCheck: {Annotations: []*Annotation{
+			{Path: "main.go", Line: 3, Column: 2, Severity: "failure", Message: "not formatted"},
+			{Path: "go.mod", Severity: "notice", Message: "whole file"},
+		}},
+		buildifierCheck: {Conclusion: "success"},
+	})
+	b, err := json.Marshal(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"$schema":"https://json.schemastore.org/sarif-2.1.0.json","version":"2.1.0","runs":[` +
+		`{"tool":{"driver":{"name":"buildifier","rules":[{"id":"buildifier","shortDescription":{"text":"BUILD and .bzl files are formatted and free of lint warnings."},"helpUri":"https://github.com/bazelbuild/buildtools/blob/master/WARNINGS.md"}]}},"results":[]},` +
+		`{"tool":{"driver":{"name":"gofmt","rules":[{"id":"gofmt","shortDescription":{"text":"Go files are formatted with gofmt."},"helpUri":"https://pkg.go.dev/cmd/gofmt"}]}},"results":[` +
+		`{"ruleId":"gofmt","level":"error","message":{"text":"not formatted"},"locations":[{"physicalLocation":{"artifactLocation":{"uri":"main.go"},"region":{"startLine":3,"startColumn":2}}}]},` +
+		`{"ruleId":"gofmt","level":"note","message":{"text":"whole file"},"locations":[{"physicalLocation":{"artifactLocation":{"uri":"go.mod"},"region":{"startLine":1}}}]}]}]}`
+	if string(b) != want {
+		t.Errorf("got\n%s\nwant\n%s", b, want)
+	}
+}
+
+func TestToSARIFWithoutResults(t *testing.T) {
+	b, err := json.Marshal(ToSARIF(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"$schema":"https://json.schemastore.org/sarif-2.1.0.json","version":"2.1.0","runs":[]}`; string(b) != want {
+		t.Errorf("got %s, want %s", b, want)
+	}
+}
+
+func TestSARIFRule(t *testing.T) {
+	registerTestChecker(t, &funcChecker{
+		name: "test-lint",
+		rule: CheckRule{Description: "Files pass test-lint.", HelpURI: "https://example.com/test-lint"},
+	})
+	registerTestChecker(t, &funcChecker{name: "test-fmt"})
+
+	if r := sarifRule("test-lint"); r.ShortDescription == nil || r.ShortDescription.Text != "Files pass test-lint." || r.HelpURI != "https://example.com/test-lint" {
+		t.Errorf("rule of test-lint = %+v, want its description and help URI", r)
+	}
+	for _, name := range []string{"test-fmt", "unknown"} {
+		if r := sarifRule(name); r.ID != name || r.ShortDescription != nil || r.HelpURI != "" {
+			t.Errorf("rule of %s = %+v, want just its ID", name, r)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package app
+
+import (
+	"context"
+	"sync"
+)
+
+// jobScheduler queues jobs per installation and hands them to workers
+// round-robin across installations, so that every installation with waiting
+// jobs gets a turn regardless of how many jobs the others have queued.
+type jobScheduler struct {
+	mu sync.Mutex
+	// ready is signaled when a job may have become runnable.
+	ready *sync.Cond
+	// space is closed and replaced when a job leaves a queue, to wake up
+	// dispatchers waiting for room.
+	space chan struct{}
+
+	queues  map[int64][]*Job
+	running map[int64]int
+	// ring are the installations with queued jobs, in the order they are
+	// served, and next is the position of the one to serve next.
+	ring []int64
+	next int
+}
+
+func newJobScheduler() *jobScheduler {
+	s := &jobScheduler{
+		space:   make(chan struct{}),
+		queues:  make(map[int64][]*Job),
+		running: make(map[int64]int),
+	}
+	s.ready = sync.NewCond(&s.mu)
+	return s
+}
+
+// Push queues job, waiting while its installation already has
+// InstallationQueueSize jobs queued.
+func (s *jobScheduler) Push(ctx context.Context, job *Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := job.InstallationID
+	for len(s.queues[id]) >= CurrentSettings().InstallationQueueSize {
+		space := s.space
+		s.mu.Unlock()
+		select {
+		case <-space:
+		case <-ctx.Done():
+			s.mu.Lock()
+			return ctx.Err()
+		}
+		s.mu.Lock()
+	}
+	if len(s.queues[id]) == 0 {
+		s.ring = append(s.ring, id)
+	}
+	s.queues[id] = append(s.queues[id], job)
+	s.ready.Signal()
+	return nil
+}
+
+// Pop waits for a job whose installation is below InstallationConcurrency
+// and returns it. Done must be called once the job finished.
+func (s *jobScheduler) Pop() *Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		concurrency := CurrentSettings().InstallationConcurrency
+		for i := 0; i < len(s.ring); i++ {
+			pos := (s.next + i) % len(s.ring)
+			id := s.ring[pos]
+			if concurrency > 0 && s.running[id] >= concurrency {
+				continue
+			}
+			job := s.queues[id][0]
+			s.queues[id] = s.queues[id][1:]
+			s.running[id]++
+			if len(s.queues[id]) == 0 {
+				delete(s.queues, id)
+				s.ring = append(s.ring[:pos], s.ring[pos+1:]...)
+				s.next = pos
+			} else {
+				s.next = pos + 1
+			}
+			if len(s.ring) > 0 {
+				s.next %= len(s.ring)
+			} else {
+				s.next = 0
+			}
+			close(s.space)
+			s.space = make(chan struct{})
+			return job
+		}
+		s.ready.Wait()
+	}
+}
+
+// Done records that a job returned by Pop finished.
+func (s *jobScheduler) Done(job *Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := job.InstallationID
+	s.running[id]--
+	if s.running[id] == 0 {
+		delete(s.running, id)
+	}
+	// A worker may be waiting on the installation's limit.
+	s.ready.Broadcast()
+}
@@ -0,0 +1,114 @@
+package app
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// setInstallationLimits sets InstallationConcurrency and InstallationQueueSize
+// for the duration of the test.
+func setInstallationLimits(t *testing.T, concurrency int, queueSize int) {
+	setTestSettings(t, func(s *Settings) {
+		s.InstallationConcurrency, s.InstallationQueueSize = concurrency, queueSize
+	})
+}
+
+func pushTestJobs(t *testing.T, s *jobScheduler, jobs ...*Job) {
+	for _, job := range jobs {
+		if err := s.Push(context.Background(), job); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// receive returns the job popped to popped, failing the test if none is
+// within a few seconds.
+func receive(t *testing.T, popped <-chan *Job) *Job {
+	select {
+	case job := <-popped:
+		return job
+	case <-time.After(5 * time.Second):
+		t.Fatal("Pop didn't return a job")
+		return nil
+	}
+}
+
+func TestJobSchedulerRoundRobin(t *testing.T) {
+	setInstallationLimits(t, 0, 100)
+	s := newJobScheduler()
+	pushTestJobs(t, s,
+		&Job{InstallationID: 1, HeadSHA: "a1"},
+		&Job{InstallationID: 1, HeadSHA: "a2"},
+		&Job{InstallationID: 1, HeadSHA: "a3"},
+		&Job{InstallationID: 2, HeadSHA: "b1"},
+		&Job{InstallationID: 3, HeadSHA: "c1"},
+		&Job{InstallationID: 3, HeadSHA: "c2"},
+	)
+
+	var got []string
+	for i := 0; i < 6; i++ {
+		got = append(got, s.Pop().HeadSHA)
+	}
+	want := []string{"a1", "b1", "c1", "a2", "c2", "a3"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("popped %q, want %q", got, want)
+		}
+	}
+}
+
+func TestJobSchedulerInstallationConcurrency(t *testing.T) {
+	setInstallationLimits(t, 1, 100)
+	s := newJobScheduler()
+	first := &Job{InstallationID: 1, HeadSHA: "a1"}
+	pushTestJobs(t, s, first, &Job{InstallationID: 1, HeadSHA: "a2"})
+
+	if job := s.Pop(); job != first {
+		t.Fatalf("popped %+v, want the first job", job)
+	}
+	popped := make(chan *Job, 1)
+	go func() { popped <- s.Pop() }()
+	select {
+	case job := <-popped:
+		t.Fatalf("popped %s while its installation is at its limit", job.HeadSHA)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	pushTestJobs(t, s, &Job{InstallationID: 2, HeadSHA: "b1"})
+	if job := receive(t, popped); job.HeadSHA != "b1" {
+		t.Errorf("popped %s, want the job of the installation below its limit", job.HeadSHA)
+	}
+
+	go func() { popped <- s.Pop() }()
+	s.Done(first)
+	if job := receive(t, popped); job.HeadSHA != "a2" {
+		t.Errorf("popped %s after the first job was done, want a2", job.HeadSHA)
+	}
+}
+
+func TestJobSchedulerPushWaitsForSpace(t *testing.T) {
+	setInstallationLimits(t, 0, 1)
+	s := newJobScheduler()
+	pushTestJobs(t, s, &Job{InstallationID: 1, HeadSHA: "a1"}, &Job{InstallationID: 2, HeadSHA: "b1"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.Push(ctx, &Job{InstallationID: 1, HeadSHA: "a2"}); err != context.DeadlineExceeded {
+		t.Fatalf("Push to a full queue returned %v, want it to wait until the context is done", err)
+	}
+
+	pushed := make(chan error, 1)
+	go func() { pushed <- s.Push(context.Background(), &Job{InstallationID: 1, HeadSHA: "a2"}) }()
+	if job := s.Pop(); job.HeadSHA != "a1" {
+		t.Fatalf("popped %s, want a1", job.HeadSHA)
+	}
+	select {
+	case err := <-pushed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Push didn't return after a job left the queue")
+	}
+}
@@ -0,0 +1,305 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Secret references select where a credential is read from by URI scheme,
+// so that it doesn't have to appear on the command line:
+//
+//	file:///run/secrets/webhook      contents of a mounted file
+//	env://WEBHOOK_SECRET             an environment variable
+//	awssm://<name or ARN>            AWS Secrets Manager
+//	gcpsm://projects/p/secrets/s     GCP Secret Manager, latest version unless
+//	                                 a /versions/<v> suffix is given
+//	vault://secret/data/reviewbot    Vault, at $VAULT_ADDR with $VAULT_TOKEN
+//
+// A "#field" suffix selects a field of a secret holding a JSON object, and
+// defaults to "value" for Vault. Values without one of these schemes are the
+// secret itself.
+var secretSchemes = map[string]func(ctx context.Context, path string, field string) (string, error){
+	"file":  readFileSecret,
+	"env":   readEnvSecret,
+	"awssm": readAWSSecret,
+	"gcpsm": readGCPSecret,
+	"vault": readVaultSecret,
+}
+
+// secretHTTPClient fetches secrets from secret managers.
+var secretHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// IsSecretRef reports whether s is a secret reference rather than a secret.
+func IsSecretRef(s string) bool {
+	scheme, _, ok := strings.Cut(s, "://")
+	_, known := secretSchemes[scheme]
+	return ok && known
+}
+
+// ResolveSecret returns the secret that ref refers to, or ref itself if it
+// isn't a secret reference. Surrounding whitespace is trimmed.
+func ResolveSecret(ctx context.Context, ref string) (string, error) {
+	if !IsSecretRef(ref) {
+		return ref, nil
+	}
+	scheme, rest, _ := strings.Cut(ref, "://")
+	path, field, _ := strings.Cut(rest, "#")
+	secret, err := secretSchemes[scheme](ctx, path, field)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret %s://%s: %s", scheme, path, err)
+	}
+	return strings.TrimSpace(secret), nil
+}
+
+// RefSecretProvider returns the secret that Ref refers to for every
+// repository. Wrapped in the app's cache, a rotated secret is picked up
+// within secretTTL.
+type RefSecretProvider struct {
+	Ref string
+}
+
+func (p *RefSecretProvider) BuildBuddyAPIKey(ctx context.Context, _ int64, _ string) (string, error) {
+	key, err := ResolveSecret(ctx, p.Ref)
+	if err != nil {
+		return "", err
+	}
+	if key == "" {
+		return "", fmt.Errorf("BuildBuddy API key is empty")
+	}
+	return key, nil
+}
+
+// jsonField returns field of the JSON object secret, or secret itself if
+// field is empty.
+func jsonField(secret string, field string) (string, error) {
+	if field == "" {
+		return secret, nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(secret), &fields); err != nil {
+		return "", fmt.Errorf("secret isn't a JSON object: %s", err)
+	}
+	v, ok := fields[field]
+	if !ok {
+		return "", fmt.Errorf("secret has no field %q", field)
+	}
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(v), nil
+}
+
+func readFileSecret(_ context.Context, path string, field string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return jsonField(string(b), field)
+}
+
+func readEnvSecret(_ context.Context, name string, field string) (string, error) {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("$%s isn't set", name)
+	}
+	return jsonField(v, field)
+}
+
+// getSecret sends req and decodes its JSON response into res.
+func getSecret(req *http.Request, res interface{}) error {
+	httpRes, err := secretHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer httpRes.Body.Close()
+	if httpRes.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(httpRes.Body, 1024))
+		return fmt.Errorf("status %d: %s", httpRes.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(httpRes.Body).Decode(res)
+}
+
+// readAWSSecret reads a secret from AWS Secrets Manager with the credentials
+// and region in the standard AWS_* environment variables. The region of an
+// ARN takes precedence.
+func readAWSSecret(ctx context.Context, id string, field string) (string, error) {
+	accessKey, secretKey := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if accessKey == "" || secretKey == "" {
+		return "", fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if parts := strings.Split(id, ":"); len(parts) > 3 && parts[0] == "arn" {
+		region = parts[3]
+	}
+	if region == "" {
+		return "", fmt.Errorf("AWS_REGION must be set")
+	}
+
+	body, err := json.Marshal(map[string]string{"SecretId": id})
+	if err != nil {
+		return "", err
+	}
+	host := fmt.Sprintf("secretsmanager.%s.amazonaws.com", region)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://"+host+"/", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	headers := map[string]string{
+		"content-type": "application/x-amz-json-1.1",
+		"host":         host,
+		"x-amz-date":   time.Now().UTC().Format("20060102T150405Z"),
+		"x-amz-target": "secretsmanager.GetSecretValue",
+	}
+	if token := os.Getenv("AWS_SESSION_TOKEN"); token != "" {
+		headers["x-amz-security-token"] = token
+	}
+	signAWSRequest(req, headers, body, region, "secretsmanager", accessKey, secretKey)
+
+	res := &struct {
+		SecretString string `json:"SecretString"`
+		SecretBinary []byte `json:"SecretBinary"`
+	}{}
+	if err := getSecret(req, res); err != nil {
+		return "", err
+	}
+	secret := res.SecretString
+	if secret == "" {
+		secret = string(res.SecretBinary)
+	}
+	return jsonField(secret, field)
+}
+
+// signAWSRequest sets headers on req and signs it with AWS Signature Version
+// 4.
+func signAWSRequest(req *http.Request, headers map[string]string, body []byte, region string, service string, accessKey string, secretKey string) {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, headers[name])
+		if name != "host" {
+			req.Header.Set(name, headers[name])
+		}
+	}
+	signedHeaders := strings.Join(names, ";")
+	bodyHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{req.Method, "/", "", canonicalHeaders.String(), signedHeaders, hex.EncodeToString(bodyHash[:])}, "\n")
+
+	amzDate := headers["x-amz-date"]
+	scope := fmt.Sprintf("%s/%s/%s/aws4_request", amzDate[:8], region, service)
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(requestHash[:])}, "\n")
+
+	hmacSHA256 := func(key []byte, data string) []byte {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(data))
+		return h.Sum(nil)
+	}
+	key := []byte("AWS4" + secretKey)
+	for _, part := range []string{amzDate[:8], region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", accessKey, scope, signedHeaders, signature))
+}
+
+// readGCPSecret reads a secret version from GCP Secret Manager, authorized
+// by $GOOGLE_OAUTH_ACCESS_TOKEN or else the service account of the instance
+// from the metadata server.
+func readGCPSecret(ctx context.Context, name string, field string) (string, error) {
+	if !strings.Contains(name, "/versions/") {
+		name += "/versions/latest"
+	}
+	token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN")
+	if token == "" {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token", nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Metadata-Flavor", "Google")
+		res := &struct {
+			AccessToken string `json:"access_token"`
+		}{}
+		if err := getSecret(req, res); err != nil {
+			return "", fmt.Errorf("failed to get access token from the metadata server: %s", err)
+		}
+		token = res.AccessToken
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://secretmanager.googleapis.com/v1/"+name+":access", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	res := &struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}{}
+	if err := getSecret(req, res); err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(res.Payload.Data)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode payload: %s", err)
+	}
+	return jsonField(string(data), field)
+}
+
+// readVaultSecret reads field of a secret from Vault at $VAULT_ADDR with
+// $VAULT_TOKEN. Both KV version 1 and 2 secrets are supported.
+func readVaultSecret(ctx context.Context, path string, field string) (string, error) {
+	addr, token := os.Getenv("VAULT_ADDR"), os.Getenv("VAULT_TOKEN")
+	if addr == "" || token == "" {
+		return "", fmt.Errorf("VAULT_ADDR and VAULT_TOKEN must be set")
+	}
+	if field == "" {
+		field = "value"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(addr, "/")+"/v1/"+path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	res := &struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}{}
+	if err := getSecret(req, res); err != nil {
+		return "", err
+	}
+	data := res.Data
+	if nested, ok := data["data"]; ok {
+		// KV version 2 nests the secret's fields next to its metadata.
+		data = nil
+		if err := json.Unmarshal(nested, &data); err != nil {
+			return "", fmt.Errorf("failed to parse secret: %s", err)
+		}
+	}
+	raw, ok := data[field]
+	if !ok {
+		return "", fmt.Errorf("secret has no field %q", field)
+	}
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return string(raw), nil
+	}
+	return value, nil
+}
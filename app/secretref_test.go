@@ -0,0 +1,246 @@
+package app
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// serveSecrets sends the requests of secretHTTPClient to h for the duration
+// of the test, whatever host they are for.
+func serveSecrets(t *testing.T, h http.HandlerFunc) {
+	s := httptest.NewServer(h)
+	t.Cleanup(s.Close)
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := secretHTTPClient
+	secretHTTPClient = &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		req = req.Clone(req.Context())
+		req.Header.Set("X-Original-Host", req.URL.Host)
+		req.URL.Scheme, req.URL.Host = u.Scheme, u.Host
+		return http.DefaultTransport.RoundTrip(req)
+	})}
+	t.Cleanup(func() { secretHTTPClient = old })
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestIsSecretRef(t *testing.T) {
+	for s, want := range map[string]bool{
+		"env://WEBHOOK_SECRET":       true,
+		"vault://secret/data/bot":    true,
+		"file:///run/secrets/key":    true,
+		"https://example.com/secret": false,
+		"plain-secret":               false,
+	} {
+		if got := IsSecretRef(s); got != want {
+			t.Errorf("IsSecretRef(%q) = %t, want %t", s, got, want)
+		}
+	}
+}
+
+func TestResolveSecret(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "webhook", "file-secret\n")
+	writeTestFile(t, dir, "creds.json", `{"webhook_secret": "json-secret", "app_id": 12}`)
+	t.Setenv("TEST_WEBHOOK_SECRET", " env-secret ")
+
+	for ref, want := range map[string]string{
+		"plain-secret": "plain-secret",
+		"file://" + filepath.Join(dir, "webhook"):                   "file-secret",
+		"file://" + filepath.Join(dir, "creds.json#webhook_secret"): "json-secret",
+		"file://" + filepath.Join(dir, "creds.json#app_id"):         "12",
+		"env://TEST_WEBHOOK_SECRET":                                 "env-secret",
+	} {
+		got, err := ResolveSecret(context.Background(), ref)
+		if err != nil {
+			t.Errorf("ResolveSecret(%q): %s", ref, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("ResolveSecret(%q) = %q, want %q", ref, got, want)
+		}
+	}
+}
+
+func TestResolveSecretWithMissingSecret(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "creds.json", `{"webhook_secret": "json-secret"}`)
+	writeTestFile(t, dir, "plain", "not json")
+
+	for _, ref := range []string{
+		"env://TEST_UNSET_SECRET",
+		"file://" + filepath.Join(dir, "missing"),
+		"file://" + filepath.Join(dir, "creds.json#private_key"),
+		"file://" + filepath.Join(dir, "plain#field"),
+	} {
+		if _, err := ResolveSecret(context.Background(), ref); err == nil {
+			t.Errorf("ResolveSecret(%q) succeeded, want an error", ref)
+		}
+	}
+}
+
+func TestRefSecretProvider(t *testing.T) {
+	t.Setenv("TEST_BB_API_KEY", "bb-key")
+	t.Setenv("TEST_EMPTY_BB_API_KEY", "")
+
+	p := &RefSecretProvider{Ref: "env://TEST_BB_API_KEY"}
+	if key, err := p.BuildBuddyAPIKey(context.Background(), testInstallationID, "o/r"); err != nil || key != "bb-key" {
+		t.Errorf("got key %q, %v, want bb-key", key, err)
+	}
+	p = &RefSecretProvider{Ref: "env://TEST_EMPTY_BB_API_KEY"}
+	if _, err := p.BuildBuddyAPIKey(context.Background(), testInstallationID, "o/r"); err == nil {
+		t.Errorf("got no error for an empty key")
+	}
+}
+
+func TestReadVaultSecret(t *testing.T) {
+	serveSecrets(t, func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("X-Vault-Token") != "vault-token" {
+			writeTestJSON(w, http.StatusForbidden, map[string]interface{}{"errors": []string{"permission denied"}})
+			return
+		}
+		switch req.URL.Path {
+		case "/v1/secret/data/bot":
+			writeTestJSON(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{
+				"data":     map[string]interface{}{"value": "kv2-secret", "port": 3000},
+				"metadata": map[string]interface{}{"version": 3},
+			}})
+		case "/v1/kv/bot":
+			writeTestJSON(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"webhook_secret": "kv1-secret"}})
+		default:
+			writeTestJSON(w, http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+		}
+	})
+	t.Setenv("VAULT_ADDR", "https://vault.example.com/")
+	t.Setenv("VAULT_TOKEN", "vault-token")
+
+	for ref, want := range map[string]string{
+		"vault://secret/data/bot":       "kv2-secret",
+		"vault://secret/data/bot#port":  "3000",
+		"vault://kv/bot#webhook_secret": "kv1-secret",
+	} {
+		got, err := ResolveSecret(context.Background(), ref)
+		if err != nil {
+			t.Errorf("ResolveSecret(%q): %s", ref, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("ResolveSecret(%q) = %q, want %q", ref, got, want)
+		}
+	}
+	for _, ref := range []string{"vault://kv/bot", "vault://missing"} {
+		if _, err := ResolveSecret(context.Background(), ref); err == nil {
+			t.Errorf("ResolveSecret(%q) succeeded, want an error", ref)
+		}
+	}
+
+	t.Setenv("VAULT_TOKEN", "")
+	if _, err := ResolveSecret(context.Background(), "vault://secret/data/bot"); err == nil || !strings.Contains(err.Error(), "VAULT_TOKEN") {
+		t.Errorf("got error %v, want VAULT_TOKEN to be required", err)
+	}
+}
+
+func TestReadGCPSecret(t *testing.T) {
+	var paths []string
+	serveSecrets(t, func(w http.ResponseWriter, req *http.Request) {
+		paths = append(paths, req.URL.Path)
+		switch {
+		case req.Header.Get("X-Original-Host") == "metadata.google.internal":
+			if req.Header.Get("Metadata-Flavor") != "Google" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			writeTestJSON(w, http.StatusOK, map[string]string{"access_token": "metadata-token"})
+		case req.Header.Get("Authorization") != "Bearer metadata-token":
+			writeTestJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthenticated"})
+		default:
+			data := base64.StdEncoding.EncodeToString([]byte(`{"key": "gcp-secret"}`))
+			writeTestJSON(w, http.StatusOK, map[string]interface{}{"payload": map[string]string{"data": data}})
+		}
+	})
+	t.Setenv("GOOGLE_OAUTH_ACCESS_TOKEN", "")
+
+	got, err := ResolveSecret(context.Background(), "gcpsm://projects/p/secrets/bb#key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "gcp-secret" {
+		t.Errorf("got %q, want gcp-secret", got)
+	}
+	if _, err := ResolveSecret(context.Background(), "gcpsm://projects/p/secrets/bb/versions/2#key"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"/computeMetadata/v1/instance/service-accounts/default/token",
+		"/v1/projects/p/secrets/bb/versions/latest:access",
+		"/computeMetadata/v1/instance/service-accounts/default/token",
+		"/v1/projects/p/secrets/bb/versions/2:access",
+	}
+	if strings.Join(paths, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got requests %q, want %q", paths, want)
+	}
+
+	t.Setenv("GOOGLE_OAUTH_ACCESS_TOKEN", "other-token")
+	if _, err := ResolveSecret(context.Background(), "gcpsm://projects/p/secrets/bb"); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("got error %v, want the configured token to be used and rejected", err)
+	}
+}
+
+func TestReadAWSSecret(t *testing.T) {
+	var hosts []string
+	serveSecrets(t, func(w http.ResponseWriter, req *http.Request) {
+		hosts = append(hosts, req.Header.Get("X-Original-Host"))
+		var body map[string]string
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			t.Errorf("failed to decode request: %s", err)
+		}
+		auth := req.Header.Get("Authorization")
+		if req.Header.Get("X-Amz-Target") != "secretsmanager.GetSecretValue" ||
+			!strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/") ||
+			!strings.Contains(auth, "/secretsmanager/aws4_request, SignedHeaders=content-type;host;x-amz-date;x-amz-security-token;x-amz-target, Signature=") {
+			t.Errorf("got target %q and authorization %q, want a signed GetSecretValue request", req.Header.Get("X-Amz-Target"), auth)
+		}
+		if req.Header.Get("X-Amz-Security-Token") != "session" {
+			t.Errorf("got no session token")
+		}
+		writeTestJSON(w, http.StatusOK, map[string]string{"SecretString": `{"webhook_secret": "aws-secret"}`, "Name": body["SecretId"]})
+	})
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret-key")
+	t.Setenv("AWS_SESSION_TOKEN", "session")
+	t.Setenv("AWS_REGION", "us-east-1")
+	t.Setenv("AWS_DEFAULT_REGION", "")
+
+	got, err := ResolveSecret(context.Background(), "awssm://reviewbot#webhook_secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "aws-secret" {
+		t.Errorf("got %q, want aws-secret", got)
+	}
+	if _, err := ResolveSecret(context.Background(), "awssm://arn:aws:secretsmanager:eu-west-1:123:secret:reviewbot"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"secretsmanager.us-east-1.amazonaws.com", "secretsmanager.eu-west-1.amazonaws.com"}
+	if strings.Join(hosts, " ") != strings.Join(want, " ") {
+		t.Errorf("got hosts %q, want %q", hosts, want)
+	}
+
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	if _, err := ResolveSecret(context.Background(), "awssm://reviewbot"); err == nil {
+		t.Errorf("got no error without credentials")
+	}
+}
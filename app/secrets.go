@@ -0,0 +1,133 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SecretProvider resolves the BuildBuddy API key to use for a repository.
+// Implementations can be backed by a secret manager such as Vault or GCP
+// Secret Manager so keys don't have to live in local config files.
+type SecretProvider interface {
+	BuildBuddyAPIKey(ctx context.Context, installationID int64, fullRepoName string) (string, error)
+}
+
+// StaticSecretProvider returns the same key for every repository.
+type StaticSecretProvider string
+
+func (p StaticSecretProvider) BuildBuddyAPIKey(_ context.Context, _ int64, _ string) (string, error) {
+	if p == "" {
+		return "", fmt.Errorf("no BuildBuddy API key configured")
+	}
+	return string(p), nil
+}
+
+// EnvSecretProvider reads keys from environment variables. For the repository
+// "owner/repo" installed as installation 123 it looks up, in order,
+// <Prefix>_OWNER_REPO, <Prefix>_123 and <Prefix>. Characters that aren't
+// valid in variable names are replaced by underscores.
+type EnvSecretProvider struct {
+	Prefix string
+}
+
+func (p *EnvSecretProvider) BuildBuddyAPIKey(_ context.Context, installationID int64, fullRepoName string) (string, error) {
+	names := []string{
+		p.Prefix + "_" + envName(fullRepoName),
+		p.Prefix + "_" + strconv.FormatInt(installationID, 10),
+		p.Prefix,
+	}
+	for _, name := range names {
+		if key := os.Getenv(name); key != "" {
+			return key, nil
+		}
+	}
+	return "", fmt.Errorf("no BuildBuddy API key found for %s in any of %s", fullRepoName, strings.Join(names, ", "))
+}
+
+func envName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' {
+			return r - 'a' + 'A'
+		}
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// FileSecretProvider reads keys from files under Dir. For the repository
+// "owner/repo" installed as installation 123 it looks up, in order,
+// <Dir>/owner/repo, <Dir>/123 and <Dir>/default.
+type FileSecretProvider struct {
+	Dir string
+}
+
+func (p *FileSecretProvider) BuildBuddyAPIKey(_ context.Context, installationID int64, fullRepoName string) (string, error) {
+	paths := []string{
+		filepath.Join(p.Dir, filepath.FromSlash(fullRepoName)),
+		filepath.Join(p.Dir, strconv.FormatInt(installationID, 10)),
+		filepath.Join(p.Dir, "default"),
+	}
+	for _, path := range paths {
+		b, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to read BuildBuddy API key from %q: %s", path, err)
+		}
+		if key := strings.TrimSpace(string(b)); key != "" {
+			return key, nil
+		}
+	}
+	return "", fmt.Errorf("no BuildBuddy API key found for %s under %q", fullRepoName, p.Dir)
+}
+
+type cachedSecret struct {
+	key     string
+	expires time.Time
+}
+
+// cachingSecretProvider caches the keys returned by another provider for a
+// fixed TTL, so that a remote secret store isn't queried for every check.
+type cachingSecretProvider struct {
+	provider SecretProvider
+	ttl      time.Duration
+
+	mu      sync.Mutex
+	secrets map[string]cachedSecret
+}
+
+func newCachingSecretProvider(provider SecretProvider, ttl time.Duration) *cachingSecretProvider {
+	return &cachingSecretProvider{
+		provider: provider,
+		ttl:      ttl,
+		secrets:  make(map[string]cachedSecret),
+	}
+}
+
+func (p *cachingSecretProvider) BuildBuddyAPIKey(ctx context.Context, installationID int64, fullRepoName string) (string, error) {
+	cacheKey := fmt.Sprintf("%d/%s", installationID, fullRepoName)
+	p.mu.Lock()
+	secret, ok := p.secrets[cacheKey]
+	p.mu.Unlock()
+	if ok && time.Now().Before(secret.expires) {
+		return secret.key, nil
+	}
+
+	key, err := p.provider.BuildBuddyAPIKey(ctx, installationID, fullRepoName)
+	if err != nil {
+		return "", err
+	}
+	p.mu.Lock()
+	p.secrets[cacheKey] = cachedSecret{key: key, expires: time.Now().Add(p.ttl)}
+	p.mu.Unlock()
+	return key, nil
+}
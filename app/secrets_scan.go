@@ -0,0 +1,207 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const secretsCheck = "secrets"
+
+// secretsAllowlistFile lists known false positives of the secrets check, at
+// the root of the repository. Each line is either a path glob whose files
+// aren't scanned, or `<path>:<line>` to allow a single finding. Lines
+// starting with # are comments. The file is read from the base of pull
+// requests, so that a pull request can't allow its own secrets.
+const secretsAllowlistFile = ".reviewbot-secrets-allowlist"
+
+// maxSecretScanSize is the size of the largest file scanned for secrets.
+// Larger files are usually generated or vendored.
+const maxSecretScanSize = 1 << 20
+
+// secretRule detects one kind of credential.
+type secretRule struct {
+	id          string
+	description string
+	regex       *regexp.Regexp
+}
+
+var secretRules = []*secretRule{
+	{"private-key", "private key", regexp.MustCompile(`-----BEGIN (?:RSA |EC |DSA |OPENSSH |PGP |ENCRYPTED )?PRIVATE KEY(?: BLOCK)?-----`)},
+	{"aws-access-key-id", "AWS access key ID", regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"github-token", "GitHub token", regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{82})\b`)},
+	{"slack-token", "Slack token", regexp.MustCompile(`\bxox[abprs]-[0-9A-Za-z-]{10,}\b`)},
+	{"google-api-key", "Google API key", regexp.MustCompile(`\bAIza[0-9A-Za-z_\-]{35}\b`)},
+	{"stripe-secret-key", "Stripe secret key", regexp.MustCompile(`\b[sr]k_live_[0-9A-Za-z]{24,}\b`)},
+	{"generic-secret", "hardcoded secret", regexp.MustCompile(`(?i)\b(?:api[_-]?key|secret|passw(?:or)?d|token)\b\s*[:=]\s*["'][^"'\s]{16,}["']`)},
+}
+
+func init() {
+	RegisterChecker(&funcChecker{
+		name:  secretsCheck,
+		fn:    checkSecrets,
+		optIn: true,
+		rule:  CheckRule{Description: "Credentials like keys and tokens aren't committed."},
+	})
+}
+
+// secretsAllowlist is the parsed secretsAllowlistFile.
+type secretsAllowlist struct {
+	paths    []string
+	findings map[string]bool
+}
+
+// loadSecretsAllowlist reads the secretsAllowlistFile of baseSHA in the
+// repository at dir, or of the checkout itself if baseSHA is "", e.g. for
+// pushes, which have already landed.
+func loadSecretsAllowlist(dir string, baseSHA string) (*secretsAllowlist, error) {
+	allowlist := &secretsAllowlist{findings: make(map[string]bool)}
+	var b []byte
+	if baseSHA == "" {
+		var err error
+		b, err = os.ReadFile(filepath.Join(dir, secretsAllowlistFile))
+		if os.IsNotExist(err) {
+			return allowlist, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		found, err := gitOutput(dir, "ls-tree", "--name-only", baseSHA, "--", secretsAllowlistFile)
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(found) == "" {
+			return allowlist, nil
+		}
+		out, err := gitOutput(dir, "show", baseSHA+":"+secretsAllowlistFile)
+		if err != nil {
+			return nil, err
+		}
+		b = []byte(out)
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if i := strings.LastIndex(line, ":"); i > 0 {
+			if _, err := strconv.Atoi(line[i+1:]); err == nil {
+				allowlist.findings[line] = true
+				continue
+			}
+		}
+		allowlist.paths = append(allowlist.paths, line)
+	}
+	return allowlist, nil
+}
+
+func (a *secretsAllowlist) allowsPath(path string) bool {
+	for _, pattern := range a.paths {
+		if matchGlob(pattern, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// scanForSecrets returns the findings in the file at path, relative to dir.
+// Binary files are skipped.
+func scanForSecrets(dir string, path string, allowlist *secretsAllowlist) ([]*Annotation, error) {
+	full := filepath.Join(dir, filepath.FromSlash(path))
+	info, err := os.Lstat(full)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Deleted by the pull request.
+			return nil, nil
+		}
+		return nil, err
+	}
+	if !info.Mode().IsRegular() || info.Size() > maxSecretScanSize {
+		return nil, nil
+	}
+	b, err := os.ReadFile(full)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.IndexByte(b, 0) >= 0 {
+		return nil, nil
+	}
+
+	var annotations []*Annotation
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	scanner.Buffer(nil, maxSecretScanSize)
+	for line := 1; scanner.Scan(); line++ {
+		if allowlist.findings[fmt.Sprintf("%s:%d", path, line)] {
+			continue
+		}
+		for _, rule := range secretRules {
+			loc := rule.regex.FindStringIndex(scanner.Text())
+			if loc == nil {
+				continue
+			}
+			// The secret itself is left out of the message, since check
+			// runs are visible to anyone who can read the repository.
+			annotations = append(annotations, &Annotation{
+				Message: fmt.Sprintf("Possible %s (%s). Revoke it and remove it from the history, or add %q to %s if it isn't a secret.",
+					rule.description, rule.id, fmt.Sprintf("%s:%d", path, line), secretsAllowlistFile),
+				Severity: "failure",
+				Path:     path,
+				Line:     line,
+				Column:   loc[0] + 1,
+			})
+			break
+		}
+	}
+	return annotations, scanner.Err()
+}
+
+// checkSecrets scans the files changed by the pull request, or every file if
+// they aren't known, for committed credentials.
+func checkSecrets(ctx context.Context, _ *GithubApp, target *CheckTarget) (*Result, error) {
+	allowlist, err := loadSecretsAllowlist(target.Dir, target.BaseSHA)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %s", secretsAllowlistFile, err)
+	}
+	files := target.ChangedFiles
+	if files == nil {
+		files, err = target.findFiles("**")
+		if err != nil {
+			return nil, fmt.Errorf("failed to list files: %s", err)
+		}
+	}
+
+	annotations := []*Annotation{}
+	for _, path := range files {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if path == secretsAllowlistFile || allowlist.allowsPath(path) || !target.Config.MatchesPath(path) {
+			continue
+		}
+		found, err := scanForSecrets(target.Dir, path, allowlist)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan %s: %s", path, err)
+		}
+		annotations = append(annotations, found...)
+	}
+
+	res := &Result{
+		Title: "Secret scan result",
+	}
+	if len(annotations) == 0 {
+		res.Summary = fmt.Sprintf("No secrets found in %d files.", len(files))
+		res.Conclusion = "success"
+		return res, nil
+	}
+	res.Summary = fmt.Sprintf("%d possible secrets found", len(annotations))
+	res.Conclusion = "failure"
+	res.Annotations = annotations
+	return res, nil
+}
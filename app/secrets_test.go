@@ -0,0 +1,106 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// fakeSecretProvider returns a key named after the repository and counts
+// lookups.
+type fakeSecretProvider struct {
+	lookups int
+}
+
+func (p *fakeSecretProvider) BuildBuddyAPIKey(_ context.Context, installationID int64, fullRepoName string) (string, error) {
+	p.lookups++
+	return fmt.Sprintf("key-%d-%s", installationID, fullRepoName), nil
+}
+
+func TestBuildBuddyAPIKeyUsesProvider(t *testing.T) {
+	provider := &fakeSecretProvider{}
+	app := &GithubApp{bbAPIKeys: provider}
+
+	key, err := app.BuildBuddyAPIKey(context.Background(), &CheckTarget{InstallationID: 1, FullRepoName: "o/r"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != "key-1-o/r" {
+		t.Errorf("got key %q, want the key of o/r", key)
+	}
+}
+
+func TestCachingSecretProvider(t *testing.T) {
+	ctx := context.Background()
+	provider := &fakeSecretProvider{}
+	p := newCachingSecretProvider(provider, time.Hour)
+
+	for i := 0; i < 2; i++ {
+		if key, err := p.BuildBuddyAPIKey(ctx, 1, "o/r"); err != nil || key != "key-1-o/r" {
+			t.Fatalf("got key %q, error %v, want the key of o/r", key, err)
+		}
+	}
+	if provider.lookups != 1 {
+		t.Errorf("provider was queried %d times, want once", provider.lookups)
+	}
+	if key, _ := p.BuildBuddyAPIKey(ctx, 1, "o/other"); key != "key-1-o/other" {
+		t.Errorf("got key %q, want the key of o/other", key)
+	}
+
+	p.ttl = 0
+	p.BuildBuddyAPIKey(ctx, 2, "o/r")
+	lookups := provider.lookups
+	p.BuildBuddyAPIKey(ctx, 2, "o/r")
+	if provider.lookups != lookups+1 {
+		t.Errorf("expired key wasn't looked up again")
+	}
+}
+
+func TestEnvSecretProvider(t *testing.T) {
+	ctx := context.Background()
+	p := &EnvSecretProvider{Prefix: "TEST_BB_KEY"}
+	t.Setenv("TEST_BB_KEY_MY_ORG_MY_REPO", "repo-key")
+	t.Setenv("TEST_BB_KEY_123", "installation-key")
+	t.Setenv("TEST_BB_KEY", "default-key")
+
+	for _, tc := range []struct {
+		installationID int64
+		fullRepoName   string
+		want           string
+	}{
+		{123, "my-org/my.repo", "repo-key"},
+		{123, "my-org/other", "installation-key"},
+		{456, "my-org/other", "default-key"},
+	} {
+		if key, err := p.BuildBuddyAPIKey(ctx, tc.installationID, tc.fullRepoName); err != nil || key != tc.want {
+			t.Errorf("BuildBuddyAPIKey(%d, %q) = %q, %v, want %q", tc.installationID, tc.fullRepoName, key, err, tc.want)
+		}
+	}
+}
+
+func TestFileSecretProvider(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	p := &FileSecretProvider{Dir: dir}
+	if _, err := p.BuildBuddyAPIKey(ctx, 123, "o/r"); err == nil {
+		t.Errorf("got a key from an empty directory")
+	}
+
+	writeTestFile(t, dir, "o/r", "repo-key\n")
+	writeTestFile(t, dir, "123", "installation-key\n")
+	writeTestFile(t, dir, "default", "default-key\n")
+	for _, tc := range []struct {
+		installationID int64
+		fullRepoName   string
+		want           string
+	}{
+		{123, "o/r", "repo-key"},
+		{123, "o/other", "installation-key"},
+		{456, "o/other", "default-key"},
+	} {
+		if key, err := p.BuildBuddyAPIKey(ctx, tc.installationID, tc.fullRepoName); err != nil || key != tc.want {
+			t.Errorf("BuildBuddyAPIKey(%d, %q) = %q, %v, want %q", tc.installationID, tc.fullRepoName, key, err, tc.want)
+		}
+	}
+}
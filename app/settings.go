@@ -0,0 +1,258 @@
+package app
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Settings are the app-wide settings that can change while the app runs, e.g.
+// when its configuration is reloaded. They are replaced as a whole with
+// SetSettings, so that nothing ever sees a reload half-applied.
+type Settings struct {
+	// MaxOutputLines caps how many lines of command output are retained.
+	// Output longer than this keeps its head and tail, which usually contain
+	// the setup and the error, and replaces the middle with a marker. A value
+	// <= 0 disables truncation.
+	MaxOutputLines int
+	// StripANSI controls whether ANSI escape codes are removed from tool
+	// output before it is parsed into annotations and summaries.
+	StripANSI bool
+	// LogFlushInterval is how often the new output of running checks is
+	// appended to their log in the store, and how often live log views look
+	// for it.
+	LogFlushInterval time.Duration
+	// ProgressInterval is how often the check run of a running bazel command
+	// is updated with the build's progress. A value <= 0 disables the
+	// updates.
+	ProgressInterval time.Duration
+
+	// NewCheckRunAttempts is how many times an update to a freshly created
+	// check run is attempted when GitHub returns 404 because of replication
+	// lag.
+	NewCheckRunAttempts int
+	// ReportAttempts is how many times reporting a check's result on its
+	// check run is attempted. A result that still isn't reported is kept in
+	// the store, if it implements PendingResultStore, and reported by
+	// RecoverCheckRuns on the next start.
+	ReportAttempts int
+	// GitHubRetries is the number of times a GitHub API request is retried
+	// after a secondary rate limit or server error response.
+	GitHubRetries int
+
+	// FixAuthorName is the author name of commits pushed by fix actions.
+	FixAuthorName string
+	// FixAuthorEmail is the author email of commits pushed by fix actions.
+	FixAuthorEmail string
+	// FixMessage is the template of the message of commits pushed by fix
+	// actions. {{check}} is replaced with the check's name and {{pr}} with
+	// the pull request number. Empty uses the check's own message.
+	FixMessage string
+	// FixSignOff adds a Developer Certificate of Origin sign-off by the fix
+	// author to commits pushed by fix actions.
+	FixSignOff bool
+
+	// ConcurrentChecks runs all checks requested for a head SHA as one job
+	// that clones the repository once and runs the checks concurrently.
+	ConcurrentChecks bool
+	// CheckTimeout bounds how long a check may run before its subprocesses
+	// are killed and the check run is marked timed_out. Repositories can
+	// override it per check with `timeout` in .reviewbot.yaml.
+	CheckTimeout time.Duration
+	// DefaultLimits constrain the commands of every check. Repositories can
+	// tighten them, but not loosen them.
+	DefaultLimits ResourceLimits
+	// InstallationConcurrency caps the number of jobs of one installation
+	// that run at the same time on in-process workers, so that a burst of
+	// pushes in one organization doesn't take up every worker. 0 is
+	// unlimited.
+	InstallationConcurrency int
+	// InstallationQueueSize is the number of jobs of one installation that
+	// can wait for a worker before dispatching more of its jobs blocks.
+	InstallationQueueSize int
+	// ResultCacheTTL is how long the results of checks that didn't fail are
+	// reused for commits with the same content, e.g. when a suite is
+	// re-requested or a branch is force-pushed without changes, if the app's
+	// store implements ResultCache. Failed checks always run again, since
+	// they may be flaky. A value <= 0 disables the cache.
+	ResultCacheTTL time.Duration
+	// WorkspaceBudget is the disk space, in bytes, that workspaces may take
+	// up in total. New workspaces wait while the existing ones exceed it.
+	// The size of a checkout isn't known in advance, so the budget can be
+	// overshot by the workspaces allocated last. 0 is unlimited.
+	WorkspaceBudget int64
+	// BazelWorkspaceBudget is the disk space, in bytes, that persistent
+	// bazel workspaces may take up in total. While it's exceeded, the least
+	// recently used workspaces are removed after each run. 0 is unlimited.
+	BazelWorkspaceBudget int64
+	// BazelExpungeInterval is how often the output base of a persistent
+	// workspace is cleaned with bazel clean --expunge, to shed what
+	// accumulated in it. A value <= 0 never expunges.
+	BazelExpungeInterval time.Duration
+
+	// BazelFlags are passed to every bazel build and test of checks before
+	// the flags of the check's configuration, e.g. to set up a remote cache
+	// without BuildBuddy.
+	BazelFlags []string
+	// BuildtoolsVersion is the release of buildifier and buildozer
+	// downloaded for repositories that don't pin one, e.g. "v7.1.2". Empty
+	// runs the ones on PATH instead.
+	BuildtoolsVersion string
+	// BuildtoolsURL is where buildtools releases are downloaded from.
+	// {version}, {tool}, {os} and {arch} are replaced with the release, the
+	// tool and the host's platform.
+	BuildtoolsURL string
+	// BazeliskVersion is the release of bazelisk that checks run bazel with
+	// instead of bb, e.g. "v1.19.0", so that they use the bazel version of
+	// the repository's .bazelversion. Empty runs bb, or bazel if
+	// UseBuildBuddy is false, from PATH.
+	BazeliskVersion string
+	// BazeliskURL is where bazelisk releases are downloaded from, like
+	// BuildtoolsURL.
+	BazeliskURL string
+	// BuildBuddyURL is the base URL of the BuildBuddy API that check results
+	// are enriched from. Empty disables the enrichment.
+	BuildBuddyURL string
+	// BuildBuddyPollTimeout is how long to wait for BuildBuddy to finish
+	// processing an invocation before reporting without its details.
+	BuildBuddyPollTimeout time.Duration
+	// CacheWarmup builds every target of the default branch after each push
+	// to it, so that the remote cache is warm for the check runs of pull
+	// requests based on it. Repositories can override it with
+	// `cache_warmup` in .reviewbot.yaml. It needs the app to receive push
+	// events.
+	CacheWarmup bool
+	// CacheWarmupTimeout bounds a single warmup build.
+	CacheWarmupTimeout time.Duration
+
+	// SummaryComment enables a pull request comment that summarizes the
+	// results of all checks, for people who read the conversation rather
+	// than the Checks tab. Repositories can override it with
+	// `summary_comment` in .reviewbot.yaml.
+	SummaryComment bool
+	// AggregateCheck enables a check run that rolls up the conclusions of
+	// all other checks, so that repositories can require a single status in
+	// branch protection. Repositories can override it with
+	// `aggregate_check` in .reviewbot.yaml.
+	AggregateCheck bool
+	// BrokenBranchIssue opens an issue when checks fail on the head of a
+	// repository's default branch, updates it while they keep failing and
+	// closes it once they pass again. Repositories can override it with
+	// `broken_branch_issue` in .reviewbot.yaml.
+	BrokenBranchIssue bool
+	// CodeScanning uploads the annotations of checks to GitHub code
+	// scanning as SARIF, so that findings also show up in the repository's
+	// Security tab and are tracked across pushes. The app needs write access
+	// to security events. Repositories can override it with `code_scanning`
+	// in .reviewbot.yaml.
+	CodeScanning bool
+	// SkipDrafts skips checks on draft pull requests until they are marked
+	// ready for review. Repositories can override it with `skip_drafts` in
+	// .reviewbot.yaml.
+	SkipDrafts bool
+	// SkipLabels are labels, like "skip-ci", that skip checks on the pull
+	// requests carrying them until they are removed.
+	SkipLabels []string
+	// AllowList restricts the bot to events from the installations, owners
+	// and repositories it lists. Entries are installation IDs, owners like
+	// "org", or repositories like "org/repo". Empty allows everything.
+	AllowList []string
+	// DenyList ignores events from the installations, owners and
+	// repositories it lists, with entries like AllowList. It takes
+	// precedence over AllowList.
+	DenyList []string
+
+	// NotifyWebhooks are the Slack and Discord incoming webhooks that the
+	// outcomes of checks are posted to. See ParseNotifyWebhooks.
+	NotifyWebhooks []*NotifyWebhook
+	// NotifyAll posts every outcome to NotifyWebhooks rather than only
+	// failures.
+	NotifyAll bool
+	// NotifyTemplate is the template of the messages posted to
+	// NotifyWebhooks. {{repo}}, {{check}}, {{conclusion}}, {{title}} and
+	// {{url}} are replaced with the repository, the check, its conclusion,
+	// the title of its result and its details URL, {{link}} with a link to
+	// the pull request or commit and {{annotations}} with the first
+	// annotations of the result, one per line.
+	NotifyTemplate string
+	// EmailAuthors emails the author of a commit pushed to a protected
+	// branch when it breaks checks that passed on its parent. Repositories
+	// can override it with `email_authors` in .reviewbot.yaml. It needs
+	// SMTPAddr and SMTPFrom.
+	EmailAuthors bool
+	// SMTPAddr is the host:port of the SMTP server that emails are sent
+	// through.
+	SMTPAddr string
+	// SMTPUsername and SMTPPassword authenticate with the SMTP server, if
+	// SMTPUsername is set. SMTPPassword may be a secret reference.
+	SMTPUsername string
+	SMTPPassword string
+	// SMTPFrom is the sender of emails.
+	SMTPFrom string
+	// EmailInterval is the least time between two emails to the same
+	// author, so that a series of broken pushes doesn't flood their inbox.
+	EmailInterval time.Duration
+}
+
+// DefaultSettings returns the settings the app starts with.
+func DefaultSettings() *Settings {
+	return &Settings{
+		MaxOutputLines:        2000,
+		StripANSI:             true,
+		LogFlushInterval:      2 * time.Second,
+		ProgressInterval:      30 * time.Second,
+		NewCheckRunAttempts:   4,
+		ReportAttempts:        5,
+		GitHubRetries:         5,
+		FixAuthorName:         "Lulu's Code Review Bot",
+		FixAuthorEmail:        "lulu@luluz.club",
+		ConcurrentChecks:      true,
+		CheckTimeout:          time.Hour,
+		InstallationQueueSize: 100,
+		BazelExpungeInterval:  7 * 24 * time.Hour,
+		BuildtoolsURL:         "https://github.com/bazelbuild/buildtools/releases/download/{version}/{tool}-{os}-{arch}",
+		BazeliskURL:           "https://github.com/bazelbuild/bazelisk/releases/download/{version}/{tool}-{os}-{arch}",
+		BuildBuddyURL:         "https://app.buildbuddy.io",
+		BuildBuddyPollTimeout: 30 * time.Second,
+		CacheWarmupTimeout:    2 * time.Hour,
+		NotifyTemplate:        "{{check}} {{conclusion}} on {{link}}: {{title}}\n{{url}}\n{{annotations}}",
+		EmailInterval:         time.Hour,
+	}
+}
+
+// Validate returns an error describing the first invalid setting, if any.
+func (s *Settings) Validate() error {
+	if err := s.DefaultLimits.Validate(); err != nil {
+		return err
+	}
+	if s.EmailAuthors && (s.SMTPAddr == "" || s.SMTPFrom == "") {
+		return fmt.Errorf("emailing authors requires an SMTP address and sender")
+	}
+	if s.InstallationQueueSize <= 0 {
+		return fmt.Errorf("installation queue size must be positive, got %d", s.InstallationQueueSize)
+	}
+	return nil
+}
+
+// currentSettings holds the *Settings in effect.
+var currentSettings atomic.Value
+
+func init() {
+	currentSettings.Store(DefaultSettings())
+}
+
+// CurrentSettings returns the settings in effect. They are shared and must not
+// be modified; pass a modified copy to SetSettings instead.
+func CurrentSettings() *Settings {
+	return currentSettings.Load().(*Settings)
+}
+
+// SetSettings puts s in effect if it is valid. Otherwise, it returns why and
+// the current settings stay in effect. s must not be modified afterwards.
+func SetSettings(s *Settings) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	currentSettings.Store(s)
+	return nil
+}
@@ -0,0 +1,61 @@
+package app
+
+import "testing"
+
+// setTestSettings puts a copy of the current settings modified by set into
+// effect for the duration of the test.
+func setTestSettings(t *testing.T, set func(s *Settings)) {
+	old := CurrentSettings()
+	s := *old
+	set(&s)
+	if err := SetSettings(&s); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { currentSettings.Store(old) })
+}
+
+func TestSetSettings(t *testing.T) {
+	setTestSettings(t, func(s *Settings) { s.MaxOutputLines = 10 })
+	old := CurrentSettings()
+
+	s := *old
+	s.MaxOutputLines = 20
+	if old.MaxOutputLines != 10 {
+		t.Errorf("modifying a copy changed the settings in effect")
+	}
+	if err := SetSettings(&s); err != nil {
+		t.Fatal(err)
+	}
+	if got := CurrentSettings().MaxOutputLines; got != 20 {
+		t.Errorf("MaxOutputLines = %d after SetSettings, want 20", got)
+	}
+}
+
+func TestSetSettingsKeepsCurrentOnInvalid(t *testing.T) {
+	setTestSettings(t, func(s *Settings) { s.MaxOutputLines = 10 })
+	old := CurrentSettings()
+
+	invalid := *old
+	invalid.MaxOutputLines = 20
+	invalid.EmailAuthors = true
+	if err := SetSettings(&invalid); err == nil {
+		t.Errorf("SetSettings accepted emailing authors without an SMTP server")
+	}
+	invalid = *old
+	invalid.DefaultLimits = ResourceLimits{CPUs: -1}
+	if err := SetSettings(&invalid); err == nil {
+		t.Errorf("SetSettings accepted negative CPU limits")
+	}
+	if CurrentSettings() != old {
+		t.Errorf("invalid settings were put into effect")
+	}
+
+	valid := *old
+	valid.MaxOutputLines = 20
+	if err := SetSettings(&valid); err != nil {
+		t.Fatal(err)
+	}
+	if got := CurrentSettings().MaxOutputLines; got != 20 {
+		t.Errorf("MaxOutputLines = %d after SetSettings, want 20", got)
+	}
+}
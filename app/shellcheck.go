@@ -0,0 +1,104 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+const shellcheckCheck = "shellcheck"
+
+// shellcheckReport is the output of `shellcheck --format=json1`.
+type shellcheckReport struct {
+	Comments []struct {
+		File    string `json:"file"`
+		Line    int    `json:"line"`
+		Column  int    `json:"column"`
+		Level   string `json:"level"`
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"comments"`
+}
+
+// shellcheckSeverity maps a shellcheck level to an annotation severity.
+func shellcheckSeverity(level string) string {
+	switch level {
+	case "error":
+		return "failure"
+	case "warning":
+		return "warning"
+	}
+	// info and style.
+	return "notice"
+}
+
+func init() {
+	RegisterChecker(&funcChecker{
+		name:         shellcheckCheck,
+		fn:           checkShellcheck,
+		optIn:        true,
+		requirements: CheckRequirements{Binaries: []string{"shellcheck"}},
+		rule:         CheckRule{Description: "Shell scripts pass shellcheck.", HelpURI: "https://www.shellcheck.net/wiki/"},
+	})
+}
+
+// checkShellcheck runs shellcheck on the shell scripts of the checkout.
+func checkShellcheck(ctx context.Context, _ *GithubApp, target *CheckTarget) (*Result, error) {
+	res := &Result{
+		Title: "shellcheck result",
+	}
+	files, err := target.findFiles("**/*.sh")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list shell scripts: %s", err)
+	}
+	if len(files) == 0 {
+		res.Summary = "No shell scripts found."
+		res.Conclusion = "neutral"
+		return res, nil
+	}
+
+	args := append([]string{"--format=json1"}, target.Config.Flags...)
+	args = append(args, files...)
+	// shellcheck exits with 1 when it finds issues, so its exit status is
+	// only an error if it produced no report.
+	stdOut, stdErr, err := runCheckCmd(ctx, target, "shellcheck", args...)
+	if stdOut.Len() == 0 {
+		if err != nil {
+			return nil, fmt.Errorf("shellcheck failed: %s: %s", err, cleanLine(stdErr.String()))
+		}
+		return nil, fmt.Errorf("shellcheck produced no output")
+	}
+	report := &shellcheckReport{}
+	if err := json.Unmarshal(stdOut.Bytes(), report); err != nil {
+		return nil, fmt.Errorf("failed to parse shellcheck output: %s", err)
+	}
+
+	annotations := []*Annotation{}
+	for _, c := range report.Comments {
+		annotations = append(annotations, &Annotation{
+			Message:  fmt.Sprintf("SC%d: %s (https://www.shellcheck.net/wiki/SC%d)", c.Code, c.Message, c.Code),
+			Severity: shellcheckSeverity(c.Level),
+			Path:     c.File,
+			Line:     c.Line,
+			Column:   c.Column,
+		})
+	}
+	annotations = target.Config.filterAnnotations(annotations)
+
+	if len(annotations) == 0 {
+		res.Summary = "No issues found."
+		res.Conclusion = "success"
+		return res, nil
+	}
+	res.Summary = fmt.Sprintf("%d issues found", len(annotations))
+	res.Annotations = annotations
+	// Warnings and notices alone don't fail the check.
+	res.Conclusion = "neutral"
+	for _, a := range annotations {
+		if a.Severity == "failure" {
+			res.Conclusion = "failure"
+			break
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,82 @@
+package app
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestFindFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, path := range []string{"build.sh", "tools/ci/test.sh", "tools/README.md", ".git/hooks/pre-commit.sh"} {
+		writeTestFile(t, dir, path, "")
+	}
+	target := &CheckTarget{Dir: dir}
+	files, err := target.findFiles("**/*.sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"build.sh", "tools/ci/test.sh"}; !reflect.DeepEqual(files, want) {
+		t.Errorf("got %q, want %q", files, want)
+	}
+}
+
+func TestCheckShellcheck(t *testing.T) {
+	installFakeTool(t, "shellcheck", `cat <<'REPORT'
+{"comments": [
+  {"file": "build.sh", "line": 3, "column": 6, "level": "warning", "code": 2086, "message": "Double quote to prevent globbing and word splitting."},
+  {"file": "build.sh", "line": 5, "column": 1, "level": "error", "code": 1089, "message": "Parsing stopped here."},
+  {"file": "build.sh", "line": 1, "column": 1, "level": "style", "code": 2148, "message": "Tips depend on target shell."}
+]}
+REPORT
+exit 1
+`)
+	dir := t.TempDir()
+	writeTestFile(t, dir, "build.sh", "echo $1\n")
+	res, err := checkShellcheck(context.Background(), nil, &CheckTarget{Dir: dir, Config: &CheckConfig{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Conclusion != "failure" {
+		t.Errorf("got conclusion %q, want failure", res.Conclusion)
+	}
+	want := []Annotation{
+		{Path: "build.sh", Line: 3, Column: 6, Message: "SC2086: Double quote to prevent globbing and word splitting. (https://www.shellcheck.net/wiki/SC2086)", Severity: "warning"},
+		{Path: "build.sh", Line: 5, Column: 1, Message: "SC1089: Parsing stopped here. (https://www.shellcheck.net/wiki/SC1089)", Severity: "failure"},
+		{Path: "build.sh", Line: 1, Column: 1, Message: "SC2148: Tips depend on target shell. (https://www.shellcheck.net/wiki/SC2148)", Severity: "notice"},
+	}
+	if len(res.Annotations) != len(want) {
+		t.Fatalf("got %d annotations, want %d", len(res.Annotations), len(want))
+	}
+	for i, a := range res.Annotations {
+		if *a != want[i] {
+			t.Errorf("annotation %d: got %+v, want %+v", i, *a, want[i])
+		}
+	}
+}
+
+func TestCheckShellcheckWithOnlyWarnings(t *testing.T) {
+	installFakeTool(t, "shellcheck", `echo '{"comments": [{"file": "build.sh", "line": 3, "column": 6, "level": "warning", "code": 2086, "message": "Double quote."}]}'; exit 1`)
+	dir := t.TempDir()
+	writeTestFile(t, dir, "build.sh", "echo $1\n")
+	res, err := checkShellcheck(context.Background(), nil, &CheckTarget{Dir: dir, Config: &CheckConfig{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Conclusion != "neutral" {
+		t.Errorf("got conclusion %q, want warnings alone to be neutral", res.Conclusion)
+	}
+}
+
+func TestCheckShellcheckWithoutScripts(t *testing.T) {
+	installFakeTool(t, "shellcheck", "echo 'shellcheck ran' >&2; exit 2\n")
+	dir := t.TempDir()
+	writeTestFile(t, dir, "main.go", "package main\n")
+	res, err := checkShellcheck(context.Background(), nil, &CheckTarget{Dir: dir, Config: &CheckConfig{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Conclusion != "neutral" || res.Summary != "No shell scripts found." {
+		t.Errorf("got %s with summary %q, want neutral without shell scripts", res.Conclusion, res.Summary)
+	}
+}
@@ -0,0 +1,86 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/google/go-github/v43/github"
+)
+
+// PublicURL is where the bot is reachable, e.g.
+// "https://reviewbot.example.com". Check runs and commit statuses link to the
+// bot's results page under it when a result has no URL of its own. Empty
+// leaves them without a link.
+var PublicURL = ""
+
+// maxStatusDescription is the longest description of a commit status GitHub
+// accepts.
+const maxStatusDescription = 140
+
+// isForbidden reports whether GitHub refused a request for lack of
+// permission, e.g. because the installation wasn't granted access to checks.
+func isForbidden(err error) bool {
+	var errRes *github.ErrorResponse
+	return errors.As(err, &errRes) && errRes.Response != nil && errRes.Response.StatusCode == http.StatusForbidden
+}
+
+// statusContext returns the context of the commit status that checkName is
+// reported on when the installation can't create check runs.
+func statusContext(checkName string) string {
+	return "reviewbot/" + checkName
+}
+
+// resultsURL returns the URL of the bot's results page of checkName on sha,
+// or "" without a PublicURL.
+func resultsURL(fullRepoName string, sha string, checkName string) string {
+	if PublicURL == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/results/%s/%s/%s", strings.TrimSuffix(PublicURL, "/"), fullRepoName, sha, url.PathEscape(checkName))
+}
+
+// commitState maps the conclusion of a result to the state of a commit
+// status. result is nil while the check runs.
+func commitState(result *Result) string {
+	if result == nil {
+		return "pending"
+	}
+	switch result.Conclusion {
+	case "success", "neutral", "skipped":
+		return "success"
+	case "failure", "action_required":
+		return "failure"
+	}
+	return "error"
+}
+
+// createCommitStatus reports result of checkName as a commit status on sha,
+// for installations that were only granted the statuses permission. result
+// is nil while the check runs.
+func createCommitStatus(ctx context.Context, ghc *github.Client, owner string, repo string, sha string, checkName string, result *Result) error {
+	description := "Running"
+	targetURL := resultsURL(owner+"/"+repo, sha, checkName)
+	if result != nil {
+		description = result.Title
+		if result.URL != "" {
+			targetURL = result.URL
+		}
+	}
+	if len(description) > maxStatusDescription {
+		description = description[:maxStatusDescription-3] + "..."
+	}
+	status := &github.RepoStatus{
+		Context:     github.String(statusContext(checkName)),
+		State:       github.String(commitState(result)),
+		Description: github.String(description),
+	}
+	if targetURL != "" {
+		status.TargetURL = github.String(targetURL)
+	}
+	_, res, err := ghc.Repositories.CreateStatus(ctx, owner, repo, sha, status)
+	return extractError(ctx, res, err)
+}
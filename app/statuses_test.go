@@ -0,0 +1,145 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v43/github"
+)
+
+func setPublicURL(t *testing.T, u string) {
+	old := PublicURL
+	t.Cleanup(func() { PublicURL = old })
+	PublicURL = u
+}
+
+// serveCommitStatuses records the commit statuses created on abc of o/r.
+func serveCommitStatuses(t *testing.T, f *fakeGitHub) *[]*github.RepoStatus {
+	var statuses []*github.RepoStatus
+	f.handle("POST /repos/o/r/statuses/abc", func(w http.ResponseWriter, req *http.Request) {
+		status := &github.RepoStatus{}
+		if err := json.NewDecoder(req.Body).Decode(status); err != nil {
+			t.Errorf("failed to decode commit status: %s", err)
+		}
+		statuses = append(statuses, status)
+		writeTestJSON(w, http.StatusCreated, status)
+	})
+	return &statuses
+}
+
+func TestCommitState(t *testing.T) {
+	for _, tc := range []struct {
+		result *Result
+		want   string
+	}{
+		{nil, "pending"},
+		{&Result{Conclusion: "success"}, "success"},
+		{&Result{Conclusion: "neutral"}, "success"},
+		{&Result{Conclusion: "skipped"}, "success"},
+		{&Result{Conclusion: "failure"}, "failure"},
+		{&Result{Conclusion: "action_required"}, "failure"},
+		{&Result{Conclusion: "cancelled"}, "error"},
+		{&Result{Conclusion: "timed_out"}, "error"},
+	} {
+		if got := commitState(tc.result); got != tc.want {
+			t.Errorf("commitState(%+v) = %q, want %q", tc.result, got, tc.want)
+		}
+	}
+}
+
+func TestResultsURL(t *testing.T) {
+	setPublicURL(t, "")
+	if got := resultsURL("o/r", "abc", "lint"); got != "" {
+		t.Errorf("got results URL %q without a public URL, want none", got)
+	}
+	setPublicURL(t, "https://reviewbot.example.com/")
+	if got, want := resultsURL("o/r", "abc", "my lint"), "https://reviewbot.example.com/results/o/r/abc/my%20lint"; got != want {
+		t.Errorf("got results URL %q, want %q", got, want)
+	}
+}
+
+func TestCreateCheckRunsReportsStatusesWhenChecksAreForbidden(t *testing.T) {
+	f := newFakeGitHub(t)
+	f.handle("GET /repos/o/r/commits/abc/check-runs", func(w http.ResponseWriter, req *http.Request) {
+		writeTestJSON(w, http.StatusForbidden, map[string]string{"message": "Resource not accessible by integration"})
+	})
+	statuses := serveCommitStatuses(t, f)
+	setPublicURL(t, "https://reviewbot.example.com")
+	app := newTestApp(t, f)
+	d := &recordingDispatcher{}
+	app.SetDispatcher(d)
+
+	if err := app.createCheckRuns(context.Background(), testInstallationID, testRepo(), "abc", []string{nogoCheck}); err != nil {
+		t.Fatal(err)
+	}
+	if n := f.count("POST /repos/o/r/check-runs"); n != 0 {
+		t.Errorf("created %d check runs, want commit statuses only", n)
+	}
+	if len(*statuses) != 1 {
+		t.Fatalf("created %d commit statuses, want 1", len(*statuses))
+	}
+	status := (*statuses)[0]
+	if status.GetContext() != "reviewbot/"+nogoCheck || status.GetState() != "pending" {
+		t.Errorf("got commit status %+v, want a pending status of %s", status, nogoCheck)
+	}
+	if want := "https://reviewbot.example.com/results/o/r/abc/" + nogoCheck; status.GetTargetURL() != want {
+		t.Errorf("got target URL %q, want %q", status.GetTargetURL(), want)
+	}
+	if len(d.jobs) != 1 || len(d.jobs[0].Checks) != 1 || d.jobs[0].Checks[0].CheckRunID != 0 || !d.jobs[0].usesStatuses() {
+		t.Errorf("dispatched %+v, want one job whose check has no check run", d.jobs)
+	}
+}
+
+func TestCreateCheckRunsReportsStatusesWhenCreationIsForbidden(t *testing.T) {
+	f := newFakeGitHub(t)
+	f.handle("GET /repos/o/r/commits/abc/check-runs", func(w http.ResponseWriter, req *http.Request) {
+		writeTestJSON(w, http.StatusOK, map[string]interface{}{"total_count": 0, "check_runs": []interface{}{}})
+	})
+	f.handle("POST /repos/o/r/check-runs", func(w http.ResponseWriter, req *http.Request) {
+		writeTestJSON(w, http.StatusForbidden, map[string]string{"message": "Resource not accessible by integration"})
+	})
+	statuses := serveCommitStatuses(t, f)
+	app := newTestApp(t, f)
+	d := &recordingDispatcher{}
+	app.SetDispatcher(d)
+
+	if err := app.createCheckRuns(context.Background(), testInstallationID, testRepo(), "abc", []string{nogoCheck}); err != nil {
+		t.Fatal(err)
+	}
+	if len(*statuses) != 1 || (*statuses)[0].GetState() != "pending" || (*statuses)[0].TargetURL != nil {
+		t.Errorf("got commit statuses %+v, want a pending one without a link", *statuses)
+	}
+	if len(d.jobs) != 1 || d.jobs[0].Checks[0].CheckRunID != 0 {
+		t.Errorf("dispatched %+v, want the check reported as a commit status", d.jobs)
+	}
+}
+
+func TestReportResultCreatesCommitStatus(t *testing.T) {
+	f := newFakeGitHub(t)
+	statuses := serveCommitStatuses(t, f)
+	app := newTestApp(t, f)
+	store := newMemStore()
+	app.SetStore(store)
+	job := &Job{InstallationID: testInstallationID, FullRepoName: "o/r", HeadSHA: "abc", Token: "job-token"}
+	result := &Result{Conclusion: "failure", Title: strings.Repeat("x", 200), URL: "https://example.com/invocation"}
+
+	if _, err := app.reportResult(context.Background(), job, &JobCheck{Name: nogoCheck}, result); err != nil {
+		t.Fatal(err)
+	}
+	if len(*statuses) != 1 {
+		t.Fatalf("created %d commit statuses, want 1", len(*statuses))
+	}
+	status := (*statuses)[0]
+	if status.GetState() != "failure" || status.GetTargetURL() != "https://example.com/invocation" {
+		t.Errorf("got commit status %+v, want a failure linking to the result", status)
+	}
+	if d := status.GetDescription(); len(d) != maxStatusDescription || !strings.HasSuffix(d, "...") {
+		t.Errorf("got description %q, want it truncated to %d characters", d, maxStatusDescription)
+	}
+	if len(store.pending) != 0 {
+		t.Errorf("saved pending results %v for a commit status", store.pending)
+	}
+}
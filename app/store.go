@@ -0,0 +1,563 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// logExcerptLines is how many lines of a check's output are kept in its
+// stored record.
+const logExcerptLines = 50
+
+// CheckRunRecord is the stored state of a check run the bot started.
+type CheckRunRecord struct {
+	CheckRunID     int64
+	InstallationID int64
+	FullRepoName   string
+	HeadSHA        string
+	CheckName      string
+	// Status is "in_progress" until the check completes, then "completed".
+	Status      string
+	Conclusion  string
+	StartedAt   time.Time
+	CompletedAt time.Time
+	Duration    time.Duration
+	LogExcerpt  string
+}
+
+// CheckRunFilter selects check run records. Empty fields match any value.
+type CheckRunFilter struct {
+	CheckRunID   int64
+	FullRepoName string
+	HeadSHA      string
+	CheckName    string
+	Status       string
+	Conclusion   string
+	// Limit caps the number of records returned; 0 means no limit.
+	Limit int
+}
+
+// Store persists the state of check runs, so that interrupted runs can be
+// found after a restart and past runs can be queried.
+type Store interface {
+	// StartCheckRun records that a check run started. Starting a run that
+	// already has a record resets it to in progress.
+	StartCheckRun(ctx context.Context, record *CheckRunRecord) error
+	// CompleteCheckRun records the result of a started check run.
+	CompleteCheckRun(ctx context.Context, checkRunID int64, conclusion string, completedAt time.Time, logExcerpt string) error
+	// ListCheckRuns returns the records matching filter, most recently started
+	// first.
+	ListCheckRuns(ctx context.Context, filter CheckRunFilter) ([]*CheckRunRecord, error)
+}
+
+// SQLStore is a Store backed by a PostgreSQL database. The bot only links
+// the lib/pq driver.
+type SQLStore struct {
+	db *sql.DB
+}
+
+// NewSQLStore returns a store using db, creating its tables if needed.
+func NewSQLStore(ctx context.Context, db *sql.DB) (*SQLStore, error) {
+	_, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS check_runs (
+		check_run_id BIGINT PRIMARY KEY,
+		installation_id BIGINT NOT NULL,
+		repo TEXT NOT NULL,
+		head_sha TEXT NOT NULL,
+		check_name TEXT NOT NULL,
+		status TEXT NOT NULL,
+		conclusion TEXT NOT NULL DEFAULT '',
+		started_at TIMESTAMP NOT NULL,
+		completed_at TIMESTAMP NULL,
+		duration_ms BIGINT NOT NULL DEFAULT 0,
+		log_excerpt TEXT NOT NULL DEFAULT ''
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create check_runs table: %s", err)
+	}
+	_, err = db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		delivery_id TEXT PRIMARY KEY,
+		received_at TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create webhook_deliveries table: %s", err)
+	}
+	_, err = db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS check_run_annotations (
+		check_run_id BIGINT NOT NULL,
+		path TEXT NOT NULL,
+		line INTEGER NOT NULL,
+		severity TEXT NOT NULL,
+		message TEXT NOT NULL
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create check_run_annotations table: %s", err)
+	}
+	_, err = db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS pending_results (
+		check_run_id BIGINT PRIMARY KEY,
+		result TEXT NOT NULL,
+		saved_at TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pending_results table: %s", err)
+	}
+	_, err = db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS results (
+		repo TEXT NOT NULL,
+		head_sha TEXT NOT NULL,
+		check_name TEXT NOT NULL,
+		result TEXT NOT NULL,
+		saved_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (repo, head_sha, check_name)
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create results table: %s", err)
+	}
+	_, err = db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS check_logs (
+		repo TEXT NOT NULL,
+		head_sha TEXT NOT NULL,
+		check_name TEXT NOT NULL,
+		seq INTEGER NOT NULL,
+		output TEXT NOT NULL,
+		done INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (repo, head_sha, check_name, seq)
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create check_logs table: %s", err)
+	}
+	_, err = db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS result_cache (
+		repo TEXT NOT NULL,
+		cache_key TEXT NOT NULL,
+		check_name TEXT NOT NULL,
+		head_sha TEXT NOT NULL,
+		result TEXT NOT NULL,
+		saved_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (repo, cache_key, check_name)
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create result_cache table: %s", err)
+	}
+	return &SQLStore{db: db}, nil
+}
+
+func (s *SQLStore) StartCheckRun(ctx context.Context, r *CheckRunRecord) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO check_runs
+		(check_run_id, installation_id, repo, head_sha, check_name, status, started_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (check_run_id) DO UPDATE SET
+		status = excluded.status, conclusion = '', started_at = excluded.started_at,
+		completed_at = NULL, duration_ms = 0, log_excerpt = ''`,
+		r.CheckRunID, r.InstallationID, r.FullRepoName, r.HeadSHA, r.CheckName, inProgress, r.StartedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to record start of check run %d: %s", r.CheckRunID, err)
+	}
+	return nil
+}
+
+func (s *SQLStore) CompleteCheckRun(ctx context.Context, checkRunID int64, conclusion string, completedAt time.Time, logExcerpt string) error {
+	var startedAt time.Time
+	err := s.db.QueryRowContext(ctx, `SELECT started_at FROM check_runs WHERE check_run_id = $1`, checkRunID).Scan(&startedAt)
+	if err != nil {
+		return fmt.Errorf("failed to look up check run %d: %s", checkRunID, err)
+	}
+	_, err = s.db.ExecContext(ctx, `UPDATE check_runs SET
+		status = 'completed', conclusion = $1, completed_at = $2, duration_ms = $3, log_excerpt = $4
+		WHERE check_run_id = $5`,
+		conclusion, completedAt.UTC(), completedAt.Sub(startedAt).Milliseconds(), logExcerpt, checkRunID)
+	if err != nil {
+		return fmt.Errorf("failed to record completion of check run %d: %s", checkRunID, err)
+	}
+	return nil
+}
+
+func (s *SQLStore) ListCheckRuns(ctx context.Context, filter CheckRunFilter) ([]*CheckRunRecord, error) {
+	var where []string
+	var args []interface{}
+	if filter.CheckRunID != 0 {
+		args = append(args, filter.CheckRunID)
+		where = append(where, fmt.Sprintf("check_run_id = $%d", len(args)))
+	}
+	for _, f := range []struct {
+		column string
+		value  string
+	}{
+		{"repo", filter.FullRepoName},
+		{"head_sha", filter.HeadSHA},
+		{"check_name", filter.CheckName},
+		{"status", filter.Status},
+		{"conclusion", filter.Conclusion},
+	} {
+		if f.value == "" {
+			continue
+		}
+		args = append(args, f.value)
+		where = append(where, fmt.Sprintf("%s = $%d", f.column, len(args)))
+	}
+	query := `SELECT check_run_id, installation_id, repo, head_sha, check_name, status,
+		conclusion, started_at, completed_at, duration_ms, log_excerpt FROM check_runs`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY started_at DESC"
+	if filter.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", filter.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list check runs: %s", err)
+	}
+	defer rows.Close()
+	var records []*CheckRunRecord
+	for rows.Next() {
+		r := &CheckRunRecord{}
+		var completedAt sql.NullTime
+		var durationMS int64
+		err := rows.Scan(&r.CheckRunID, &r.InstallationID, &r.FullRepoName, &r.HeadSHA, &r.CheckName, &r.Status,
+			&r.Conclusion, &r.StartedAt, &completedAt, &durationMS, &r.LogExcerpt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read check run: %s", err)
+		}
+		r.CompletedAt = completedAt.Time
+		r.Duration = time.Duration(durationMS) * time.Millisecond
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+func (s *SQLStore) AddDelivery(ctx context.Context, deliveryID string) (bool, error) {
+	now := time.Now().UTC()
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM webhook_deliveries WHERE received_at < $1`, now.Add(-persistedDeliveryTTL)); err != nil {
+		return false, fmt.Errorf("failed to expire deliveries: %s", err)
+	}
+	res, err := s.db.ExecContext(ctx, `INSERT INTO webhook_deliveries (delivery_id, received_at)
+		VALUES ($1, $2) ON CONFLICT (delivery_id) DO NOTHING`, deliveryID, now)
+	if err != nil {
+		return false, fmt.Errorf("failed to record delivery %s: %s", deliveryID, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+func (s *SQLStore) RemoveDelivery(ctx context.Context, deliveryID string) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM webhook_deliveries WHERE delivery_id = $1`, deliveryID); err != nil {
+		return fmt.Errorf("failed to remove delivery %s: %s", deliveryID, err)
+	}
+	return nil
+}
+
+// AnnotationStore keeps the annotations of completed check runs, so that
+// they can be shown with the run's history. A Store that implements it is
+// given the annotations of every result.
+type AnnotationStore interface {
+	// SetAnnotations replaces the annotations of a check run.
+	SetAnnotations(ctx context.Context, checkRunID int64, annotations []*Annotation) error
+	// ListAnnotations returns the annotations of a check run.
+	ListAnnotations(ctx context.Context, checkRunID int64) ([]*Annotation, error)
+}
+
+func (s *SQLStore) SetAnnotations(ctx context.Context, checkRunID int64, annotations []*Annotation) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM check_run_annotations WHERE check_run_id = $1`, checkRunID); err != nil {
+		return fmt.Errorf("failed to clear annotations of check run %d: %s", checkRunID, err)
+	}
+	for _, a := range annotations {
+		_, err := tx.ExecContext(ctx, `INSERT INTO check_run_annotations
+			(check_run_id, path, line, severity, message) VALUES ($1, $2, $3, $4, $5)`,
+			checkRunID, a.Path, a.Line, a.Severity, a.Message)
+		if err != nil {
+			return fmt.Errorf("failed to record annotation of check run %d: %s", checkRunID, err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) ListAnnotations(ctx context.Context, checkRunID int64) ([]*Annotation, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT path, line, severity, message FROM check_run_annotations
+		WHERE check_run_id = $1 ORDER BY path, line`, checkRunID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list annotations: %s", err)
+	}
+	defer rows.Close()
+	var annotations []*Annotation
+	for rows.Next() {
+		a := &Annotation{}
+		if err := rows.Scan(&a.Path, &a.Line, &a.Severity, &a.Message); err != nil {
+			return nil, fmt.Errorf("failed to read annotation: %s", err)
+		}
+		annotations = append(annotations, a)
+	}
+	return annotations, rows.Err()
+}
+
+// PendingResultStore keeps the results of checks until they are reported on
+// their check runs, so that a result isn't lost when reporting it fails. A
+// Store that implements it is given every result before it is reported.
+type PendingResultStore interface {
+	// SavePendingResult stores the result of a check run, replacing any
+	// result stored for it before.
+	SavePendingResult(ctx context.Context, checkRunID int64, result *Result) error
+	// PendingResult returns the stored result of a check run, or nil if
+	// there is none.
+	PendingResult(ctx context.Context, checkRunID int64) (*Result, error)
+	// DeletePendingResult forgets the result of a check run once it's
+	// reported.
+	DeletePendingResult(ctx context.Context, checkRunID int64) error
+}
+
+func (s *SQLStore) SavePendingResult(ctx context.Context, checkRunID int64, result *Result) error {
+	b, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `INSERT INTO pending_results (check_run_id, result, saved_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (check_run_id) DO UPDATE SET result = excluded.result, saved_at = excluded.saved_at`,
+		checkRunID, string(b), time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to save result of check run %d: %s", checkRunID, err)
+	}
+	return nil
+}
+
+func (s *SQLStore) PendingResult(ctx context.Context, checkRunID int64) (*Result, error) {
+	var b string
+	err := s.db.QueryRowContext(ctx, `SELECT result FROM pending_results WHERE check_run_id = $1`, checkRunID).Scan(&b)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up result of check run %d: %s", checkRunID, err)
+	}
+	result := &Result{}
+	if err := json.Unmarshal([]byte(b), result); err != nil {
+		return nil, fmt.Errorf("invalid result of check run %d: %s", checkRunID, err)
+	}
+	return result, nil
+}
+
+func (s *SQLStore) DeletePendingResult(ctx context.Context, checkRunID int64) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM pending_results WHERE check_run_id = $1`, checkRunID); err != nil {
+		return fmt.Errorf("failed to delete result of check run %d: %s", checkRunID, err)
+	}
+	return nil
+}
+
+// ResultStore keeps the latest result of each check on a commit, for the
+// bot's results page. A Store that implements it is given every result.
+type ResultStore interface {
+	// SaveResult stores the result of checkName on sha, replacing any result
+	// stored for it before.
+	SaveResult(ctx context.Context, fullRepoName string, sha string, checkName string, result *Result) error
+	// Result returns the stored result of checkName on sha, or nil if there
+	// is none.
+	Result(ctx context.Context, fullRepoName string, sha string, checkName string) (*Result, error)
+}
+
+func (s *SQLStore) SaveResult(ctx context.Context, fullRepoName string, sha string, checkName string, result *Result) error {
+	b, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `INSERT INTO results (repo, head_sha, check_name, result, saved_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (repo, head_sha, check_name) DO UPDATE SET result = excluded.result, saved_at = excluded.saved_at`,
+		fullRepoName, sha, checkName, string(b), time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to save result of %s on %s: %s", checkName, sha, err)
+	}
+	return nil
+}
+
+func (s *SQLStore) Result(ctx context.Context, fullRepoName string, sha string, checkName string) (*Result, error) {
+	var b string
+	err := s.db.QueryRowContext(ctx, `SELECT result FROM results WHERE repo = $1 AND head_sha = $2 AND check_name = $3`,
+		fullRepoName, sha, checkName).Scan(&b)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up result of %s on %s: %s", checkName, sha, err)
+	}
+	result := &Result{}
+	if err := json.Unmarshal([]byte(b), result); err != nil {
+		return nil, fmt.Errorf("invalid result of %s on %s: %s", checkName, sha, err)
+	}
+	return result, nil
+}
+
+// LogStore keeps the output of checks while they run, so that it can be
+// followed on the results page, also when the checks run in another process.
+// A Store that implements it is given the output of every check in chunks.
+type LogStore interface {
+	// StartLog starts an empty log of checkName on sha, replacing the log of
+	// a previous run.
+	StartLog(ctx context.Context, fullRepoName string, sha string, checkName string) error
+	// AppendLog adds output to the log of checkName on sha.
+	AppendLog(ctx context.Context, fullRepoName string, sha string, checkName string, output string) error
+	// FinishLog marks the log of checkName on sha complete.
+	FinishLog(ctx context.Context, fullRepoName string, sha string, checkName string) error
+	// ReadLog returns the output of the chunks from the from'th on, the
+	// number of chunks read so far and whether the log is complete. A log
+	// that was never started has 0 chunks.
+	ReadLog(ctx context.Context, fullRepoName string, sha string, checkName string, from int) (output string, next int, done bool, err error)
+}
+
+func (s *SQLStore) StartLog(ctx context.Context, fullRepoName string, sha string, checkName string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM check_logs WHERE repo = $1 AND head_sha = $2 AND check_name = $3`,
+		fullRepoName, sha, checkName); err != nil {
+		return fmt.Errorf("failed to clear log of %s on %s: %s", checkName, sha, err)
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO check_logs (repo, head_sha, check_name, seq, output) VALUES ($1, $2, $3, 0, '')`,
+		fullRepoName, sha, checkName); err != nil {
+		return fmt.Errorf("failed to start log of %s on %s: %s", checkName, sha, err)
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) AppendLog(ctx context.Context, fullRepoName string, sha string, checkName string, output string) error {
+	return s.appendLog(ctx, fullRepoName, sha, checkName, output, 0)
+}
+
+func (s *SQLStore) FinishLog(ctx context.Context, fullRepoName string, sha string, checkName string) error {
+	return s.appendLog(ctx, fullRepoName, sha, checkName, "", 1)
+}
+
+func (s *SQLStore) appendLog(ctx context.Context, fullRepoName string, sha string, checkName string, output string, done int) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO check_logs (repo, head_sha, check_name, seq, output, done)
+		SELECT $1, $2, $3, COALESCE(MAX(seq), -1) + 1, $4, CAST($5 AS INTEGER) FROM check_logs
+		WHERE repo = $1 AND head_sha = $2 AND check_name = $3`,
+		fullRepoName, sha, checkName, output, done)
+	if err != nil {
+		return fmt.Errorf("failed to append to log of %s on %s: %s", checkName, sha, err)
+	}
+	return nil
+}
+
+func (s *SQLStore) ReadLog(ctx context.Context, fullRepoName string, sha string, checkName string, from int) (string, int, bool, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT seq, output, done FROM check_logs
+		WHERE repo = $1 AND head_sha = $2 AND check_name = $3 AND seq >= $4 ORDER BY seq`,
+		fullRepoName, sha, checkName, from)
+	if err != nil {
+		return "", 0, false, fmt.Errorf("failed to read log of %s on %s: %s", checkName, sha, err)
+	}
+	defer rows.Close()
+	var output strings.Builder
+	next := from
+	done := false
+	for rows.Next() {
+		var seq, chunkDone int
+		var chunk string
+		if err := rows.Scan(&seq, &chunk, &chunkDone); err != nil {
+			return "", 0, false, err
+		}
+		output.WriteString(chunk)
+		next = seq + 1
+		done = done || chunkDone != 0
+	}
+	return output.String(), next, done, rows.Err()
+}
+
+// ResultCache keeps the results of checks by the content they checked, so that
+// checks aren't run again on commits with the same content. A Store that
+// implements it is given every result that didn't fail while ResultCacheTTL
+// is set.
+type ResultCache interface {
+	// CacheResult stores the result of checkName on sha under key, replacing
+	// any result cached under it before.
+	CacheResult(ctx context.Context, fullRepoName string, key string, sha string, checkName string, result *Result) error
+	// CachedResult returns the result of checkName cached under key since
+	// notBefore and the commit it was checked on, or nil if there is none.
+	CachedResult(ctx context.Context, fullRepoName string, key string, checkName string, notBefore time.Time) (*Result, string, error)
+}
+
+func (s *SQLStore) CacheResult(ctx context.Context, fullRepoName string, key string, sha string, checkName string, result *Result) error {
+	b, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `INSERT INTO result_cache (repo, cache_key, check_name, head_sha, result, saved_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (repo, cache_key, check_name) DO UPDATE SET head_sha = excluded.head_sha, result = excluded.result, saved_at = excluded.saved_at`,
+		fullRepoName, key, checkName, sha, string(b), time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to cache result of %s on %s: %s", checkName, sha, err)
+	}
+	return nil
+}
+
+func (s *SQLStore) CachedResult(ctx context.Context, fullRepoName string, key string, checkName string, notBefore time.Time) (*Result, string, error) {
+	var sha, b string
+	err := s.db.QueryRowContext(ctx, `SELECT head_sha, result FROM result_cache
+		WHERE repo = $1 AND cache_key = $2 AND check_name = $3 AND saved_at >= $4`,
+		fullRepoName, key, checkName, notBefore.UTC()).Scan(&sha, &b)
+	if err == sql.ErrNoRows {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to look up cached result of %s: %s", checkName, err)
+	}
+	result := &Result{}
+	if err := json.Unmarshal([]byte(b), result); err != nil {
+		return nil, "", fmt.Errorf("invalid cached result of %s on %s: %s", checkName, sha, err)
+	}
+	return result, sha, nil
+}
+
+// logExcerpt returns the part of a result worth keeping with its record.
+func logExcerpt(result *Result) string {
+	text := result.Text
+	if text == "" {
+		text = result.Summary
+	}
+	return truncateOutput(text, logExcerptLines)
+}
+
+// recordStart stores that a job's check started, if the app has a store.
+// Checks reported as commit statuses aren't stored, since records are keyed by
+// check run.
+func (app *GithubApp) recordStart(ctx context.Context, job *Job, check *JobCheck) {
+	if app.store == nil || check.CheckRunID == 0 {
+		return
+	}
+	err := app.store.StartCheckRun(ctx, &CheckRunRecord{
+		CheckRunID:     check.CheckRunID,
+		InstallationID: job.InstallationID,
+		FullRepoName:   job.FullRepoName,
+		HeadSHA:        job.HeadSHA,
+		CheckName:      check.Name,
+		Status:         inProgress,
+		StartedAt:      time.Now(),
+	})
+	if err != nil {
+		logFrom(ctx).Warnw("failed to store check run", "error", err)
+	}
+}
+
+// recordResult stores the result of a job's check, if the app has a store.
+func (app *GithubApp) recordResult(ctx context.Context, check *JobCheck, result *Result) {
+	if app.store == nil || check.CheckRunID == 0 {
+		return
+	}
+	if err := app.store.CompleteCheckRun(ctx, check.CheckRunID, result.Conclusion, time.Now(), logExcerpt(result)); err != nil {
+		logFrom(ctx).Warnw("failed to store check run result", "error", err)
+	}
+	if as, ok := app.store.(AnnotationStore); ok {
+		if err := as.SetAnnotations(ctx, check.CheckRunID, result.Annotations); err != nil {
+			logFrom(ctx).Warnw("failed to store check run annotations", "error", err)
+		}
+	}
+}
@@ -0,0 +1,697 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// fakeSQL is a database/sql driver for testing SQLStore without a database.
+// It records the statements it's given and answers queries with the rows
+// returned by the test's rows function. Statements affect one row unless the
+// test's affected function says otherwise.
+type fakeSQL struct {
+	mu       sync.Mutex
+	execs    []fakeStatement
+	rows     func(query string, args []driver.Value) ([]string, [][]driver.Value)
+	affected func(query string, args []driver.Value) int64
+}
+
+type fakeStatement struct {
+	query string
+	args  []driver.Value
+}
+
+var (
+	fakeSQLMu sync.Mutex
+	fakeSQLs  = make(map[string]*fakeSQL)
+)
+
+func init() {
+	sql.Register("reviewbot-fake", fakeSQLDriver{})
+}
+
+// openFakeSQL returns a database answering queries through f.
+func openFakeSQL(t *testing.T, f *fakeSQL) *sql.DB {
+	fakeSQLMu.Lock()
+	fakeSQLs[t.Name()] = f
+	fakeSQLMu.Unlock()
+	db, err := sql.Open("reviewbot-fake", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// exec returns the statement executed with a query containing s.
+func (f *fakeSQL) exec(t *testing.T, s string) fakeStatement {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, e := range f.execs {
+		if strings.Contains(e.query, s) {
+			return e
+		}
+	}
+	t.Fatalf("no statement containing %q was executed", s)
+	return fakeStatement{}
+}
+
+type fakeSQLDriver struct{}
+
+func (fakeSQLDriver) Open(name string) (driver.Conn, error) {
+	fakeSQLMu.Lock()
+	defer fakeSQLMu.Unlock()
+	f, ok := fakeSQLs[name]
+	if !ok {
+		return nil, errors.New("unknown fake database")
+	}
+	return &fakeSQLConn{f}, nil
+}
+
+type fakeSQLConn struct{ f *fakeSQL }
+
+func (c *fakeSQLConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeSQLStmt{f: c.f, query: query}, nil
+}
+
+func (c *fakeSQLConn) Close() error { return nil }
+
+// Begin starts a transaction whose statements are recorded as they are
+// executed, whether it is committed or not.
+func (c *fakeSQLConn) Begin() (driver.Tx, error) {
+	return fakeSQLTx{}, nil
+}
+
+type fakeSQLTx struct{}
+
+func (fakeSQLTx) Commit() error   { return nil }
+func (fakeSQLTx) Rollback() error { return nil }
+
+type fakeSQLStmt struct {
+	f     *fakeSQL
+	query string
+}
+
+func (s *fakeSQLStmt) Close() error  { return nil }
+func (s *fakeSQLStmt) NumInput() int { return -1 }
+
+func (s *fakeSQLStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.f.mu.Lock()
+	defer s.f.mu.Unlock()
+	s.f.execs = append(s.f.execs, fakeStatement{query: s.query, args: args})
+	if s.f.affected != nil {
+		return driver.RowsAffected(s.f.affected(s.query, args)), nil
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeSQLStmt) Query(args []driver.Value) (driver.Rows, error) {
+	if s.f.rows == nil {
+		return &fakeSQLRows{}, nil
+	}
+	columns, rows := s.f.rows(s.query, args)
+	return &fakeSQLRows{columns: columns, rows: rows}, nil
+}
+
+type fakeSQLRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeSQLRows) Columns() []string { return r.columns }
+func (r *fakeSQLRows) Close() error      { return nil }
+
+func (r *fakeSQLRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func TestNewSQLStoreCreatesTable(t *testing.T) {
+	f := &fakeSQL{}
+	if _, err := NewSQLStore(context.Background(), openFakeSQL(t, f)); err != nil {
+		t.Fatal(err)
+	}
+	f.exec(t, "CREATE TABLE IF NOT EXISTS check_runs")
+	f.exec(t, "CREATE TABLE IF NOT EXISTS webhook_deliveries")
+	f.exec(t, "CREATE TABLE IF NOT EXISTS check_run_annotations")
+	f.exec(t, "CREATE TABLE IF NOT EXISTS pending_results")
+	f.exec(t, "CREATE TABLE IF NOT EXISTS results (")
+	f.exec(t, "CREATE TABLE IF NOT EXISTS check_logs")
+	f.exec(t, "CREATE TABLE IF NOT EXISTS result_cache")
+}
+
+func TestSQLStoreAddDelivery(t *testing.T) {
+	recorded := make(map[driver.Value]bool)
+	f := &fakeSQL{affected: func(query string, args []driver.Value) int64 {
+		if !strings.Contains(query, "INSERT INTO webhook_deliveries") || recorded[args[0]] {
+			return 0
+		}
+		recorded[args[0]] = true
+		return 1
+	}}
+	store, err := NewSQLStore(context.Background(), openFakeSQL(t, f))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, want := range []bool{true, false} {
+		added, err := store.AddDelivery(context.Background(), "delivery-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if added != want {
+			t.Errorf("AddDelivery %d = %t, want %t", i, added, want)
+		}
+	}
+	expire := f.exec(t, "DELETE FROM webhook_deliveries WHERE received_at")
+	if cutoff := expire.args[0].(time.Time); time.Since(cutoff) < persistedDeliveryTTL {
+		t.Errorf("deliveries received since %s are expired, want those older than %s", cutoff, persistedDeliveryTTL)
+	}
+	if err := store.RemoveDelivery(context.Background(), "delivery-1"); err != nil {
+		t.Fatal(err)
+	}
+	if remove := f.exec(t, "DELETE FROM webhook_deliveries WHERE delivery_id"); remove.args[0] != "delivery-1" {
+		t.Errorf("removed delivery %v, want delivery-1", remove.args[0])
+	}
+}
+
+func TestSQLStoreCompleteCheckRunRecordsDuration(t *testing.T) {
+	started := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	f := &fakeSQL{rows: func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+		return []string{"started_at"}, [][]driver.Value{{started}}
+	}}
+	store, err := NewSQLStore(context.Background(), openFakeSQL(t, f))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.CompleteCheckRun(context.Background(), 7, "failure", started.Add(1500*time.Millisecond), "2 issues found"); err != nil {
+		t.Fatal(err)
+	}
+	update := f.exec(t, "UPDATE check_runs")
+	want := []driver.Value{"failure", started.Add(1500 * time.Millisecond), int64(1500), "2 issues found", int64(7)}
+	if !reflect.DeepEqual(update.args, want) {
+		t.Errorf("got update arguments %v, want %v", update.args, want)
+	}
+}
+
+func TestSQLStoreListCheckRuns(t *testing.T) {
+	started := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	var gotQuery string
+	var gotArgs []driver.Value
+	f := &fakeSQL{rows: func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+		gotQuery, gotArgs = query, args
+		columns := []string{"check_run_id", "installation_id", "repo", "head_sha", "check_name", "status",
+			"conclusion", "started_at", "completed_at", "duration_ms", "log_excerpt"}
+		return columns, [][]driver.Value{
+			{int64(8), int64(2), "o/r", "abc", nogoCheck, "completed", "success", started, started.Add(time.Minute), int64(60000), "ok"},
+			{int64(7), int64(2), "o/r", "abc", nogoCheck, inProgress, "", started, nil, int64(0), ""},
+		}
+	}}
+	store, err := NewSQLStore(context.Background(), openFakeSQL(t, f))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := store.ListCheckRuns(context.Background(), CheckRunFilter{FullRepoName: "o/r", CheckName: nogoCheck, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(gotQuery, "WHERE repo = $1 AND check_name = $2 ORDER BY started_at DESC LIMIT 10") {
+		t.Errorf("got query %q, want it filtered by repository and check", gotQuery)
+	}
+	if !reflect.DeepEqual(gotArgs, []driver.Value{"o/r", nogoCheck}) {
+		t.Errorf("got query arguments %v, want the repository and check", gotArgs)
+	}
+	if len(records) != 2 {
+		t.Fatalf("got %d records, want 2", len(records))
+	}
+	if r := records[0]; r.CheckRunID != 8 || r.Duration != time.Minute || !r.CompletedAt.Equal(started.Add(time.Minute)) {
+		t.Errorf("got completed record %+v, want check run 8 taking a minute", r)
+	}
+	if r := records[1]; r.CheckRunID != 7 || r.Status != inProgress || !r.CompletedAt.IsZero() {
+		t.Errorf("got running record %+v, want check run 7 in progress", r)
+	}
+
+	if _, err := store.ListCheckRuns(context.Background(), CheckRunFilter{CheckRunID: 8, Conclusion: "success"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(gotQuery, "WHERE check_run_id = $1 AND conclusion = $2 ORDER BY") {
+		t.Errorf("got query %q, want it filtered by check run ID and conclusion", gotQuery)
+	}
+	if !reflect.DeepEqual(gotArgs, []driver.Value{int64(8), "success"}) {
+		t.Errorf("got query arguments %v, want the check run ID and conclusion", gotArgs)
+	}
+}
+
+func TestSQLStoreSetAnnotations(t *testing.T) {
+	f := &fakeSQL{}
+	store, err := NewSQLStore(context.Background(), openFakeSQL(t, f))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	annotations := []*Annotation{
+		{Path: "BUILD", Line: 3, Severity: "warning", Message: "unsorted deps"},
+		{Path: "main.go", Line: 1, Severity: "failure", Message: "not formatted"},
+	}
+	if err := store.SetAnnotations(context.Background(), 7, annotations); err != nil {
+		t.Fatal(err)
+	}
+	if clear := f.exec(t, "DELETE FROM check_run_annotations"); !reflect.DeepEqual(clear.args, []driver.Value{int64(7)}) {
+		t.Errorf("cleared the annotations of %v, want check run 7", clear.args)
+	}
+	var inserts [][]driver.Value
+	for _, e := range f.execs {
+		if strings.Contains(e.query, "INSERT INTO check_run_annotations") {
+			inserts = append(inserts, e.args)
+		}
+	}
+	want := [][]driver.Value{
+		{int64(7), "BUILD", int64(3), "warning", "unsorted deps"},
+		{int64(7), "main.go", int64(1), "failure", "not formatted"},
+	}
+	if !reflect.DeepEqual(inserts, want) {
+		t.Errorf("inserted %v, want %v", inserts, want)
+	}
+}
+
+func TestSQLStoreListAnnotations(t *testing.T) {
+	var gotArgs []driver.Value
+	f := &fakeSQL{rows: func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+		gotArgs = args
+		return []string{"path", "line", "severity", "message"}, [][]driver.Value{
+			{"BUILD", int64(3), "warning", "unsorted deps"},
+		}
+	}}
+	store, err := NewSQLStore(context.Background(), openFakeSQL(t, f))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	annotations, err := store.ListAnnotations(context.Background(), 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(gotArgs, []driver.Value{int64(7)}) {
+		t.Errorf("listed the annotations of %v, want check run 7", gotArgs)
+	}
+	want := []*Annotation{{Path: "BUILD", Line: 3, Severity: "warning", Message: "unsorted deps"}}
+	if !reflect.DeepEqual(annotations, want) {
+		t.Errorf("got annotations %v, want %v", annotations, want)
+	}
+}
+
+func TestSQLStorePendingResults(t *testing.T) {
+	var saved string
+	f := &fakeSQL{rows: func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+		if saved == "" {
+			return []string{"result"}, nil
+		}
+		return []string{"result"}, [][]driver.Value{{saved}}
+	}}
+	store, err := NewSQLStore(context.Background(), openFakeSQL(t, f))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if result, err := store.PendingResult(ctx, 7); err != nil || result != nil {
+		t.Errorf("PendingResult without a saved result = %+v, %v, want none", result, err)
+	}
+	result := &Result{Conclusion: "failure", Summary: "build failed", Annotations: []*Annotation{{Path: "BUILD", Line: 1, Severity: "failure", Message: "missing dep"}}}
+	if err := store.SavePendingResult(ctx, 7, result); err != nil {
+		t.Fatal(err)
+	}
+	save := f.exec(t, "INSERT INTO pending_results")
+	if !strings.Contains(save.query, "ON CONFLICT (check_run_id) DO UPDATE") || save.args[0] != int64(7) {
+		t.Errorf("got save %q with %v, want an upsert of check run 7", save.query, save.args)
+	}
+	saved = save.args[1].(string)
+	got, err := store.PendingResult(ctx, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, result) {
+		t.Errorf("got saved result %+v, want %+v", got, result)
+	}
+	if err := store.DeletePendingResult(ctx, 7); err != nil {
+		t.Fatal(err)
+	}
+	if remove := f.exec(t, "DELETE FROM pending_results"); !reflect.DeepEqual(remove.args, []driver.Value{int64(7)}) {
+		t.Errorf("deleted the result of %v, want check run 7", remove.args)
+	}
+}
+
+// TestSQLStoreWithPostgres runs the store against the PostgreSQL database of
+// REVIEWBOT_TEST_POSTGRES_DSN, if set.
+func TestSQLStoreResults(t *testing.T) {
+	var saved string
+	f := &fakeSQL{rows: func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+		if saved == "" {
+			return []string{"result"}, nil
+		}
+		return []string{"result"}, [][]driver.Value{{saved}}
+	}}
+	store, err := NewSQLStore(context.Background(), openFakeSQL(t, f))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if result, err := store.Result(ctx, "o/r", "abc", "lint"); err != nil || result != nil {
+		t.Errorf("Result without a saved result = %+v, %v, want none", result, err)
+	}
+	result := &Result{Conclusion: "failure", Title: "1 issue", Log: "$ lint\nmain.go:1: unused\n"}
+	if err := store.SaveResult(ctx, "o/r", "abc", "lint", result); err != nil {
+		t.Fatal(err)
+	}
+	save := f.exec(t, "INSERT INTO results")
+	if !strings.Contains(save.query, "ON CONFLICT (repo, head_sha, check_name) DO UPDATE") || !reflect.DeepEqual(save.args[:3], []driver.Value{"o/r", "abc", "lint"}) {
+		t.Errorf("got save %q with %v, want an upsert of lint on o/r@abc", save.query, save.args)
+	}
+	saved = save.args[3].(string)
+	got, err := store.Result(ctx, "o/r", "abc", "lint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, result) {
+		t.Errorf("got saved result %+v, want %+v", got, result)
+	}
+}
+
+func TestSQLStoreLogs(t *testing.T) {
+	f := &fakeSQL{rows: func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+		return []string{"seq", "output", "done"}, [][]driver.Value{{int64(2), "building\n", int64(0)}, {int64(3), "", int64(1)}}
+	}}
+	store, err := NewSQLStore(context.Background(), openFakeSQL(t, f))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if err := store.StartLog(ctx, "o/r", "abc", "lint"); err != nil {
+		t.Fatal(err)
+	}
+	if clear := f.exec(t, "DELETE FROM check_logs"); !reflect.DeepEqual(clear.args, []driver.Value{"o/r", "abc", "lint"}) {
+		t.Errorf("cleared the log of %v, want lint on o/r@abc", clear.args)
+	}
+	f.exec(t, "VALUES ($1, $2, $3, 0, '')")
+	if err := store.AppendLog(ctx, "o/r", "abc", "lint", "building\n"); err != nil {
+		t.Fatal(err)
+	}
+	if appended := f.exec(t, "COALESCE(MAX(seq), -1) + 1"); !reflect.DeepEqual(appended.args, []driver.Value{"o/r", "abc", "lint", "building\n", int64(0)}) {
+		t.Errorf("appended %v, want the output as a new chunk", appended.args)
+	}
+	if err := store.FinishLog(ctx, "o/r", "abc", "lint"); err != nil {
+		t.Fatal(err)
+	}
+
+	output, next, done, err := store.ReadLog(ctx, "o/r", "abc", "lint", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != "building\n" || next != 4 || !done {
+		t.Errorf("ReadLog = %q, %d, %v, want the output of chunks 2 and 3 of the complete log", output, next, done)
+	}
+}
+
+func TestSQLStoreResultCache(t *testing.T) {
+	var saved string
+	f := &fakeSQL{rows: func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+		if saved == "" {
+			return []string{"head_sha", "result"}, nil
+		}
+		return []string{"head_sha", "result"}, [][]driver.Value{{"abc", saved}}
+	}}
+	store, err := NewSQLStore(context.Background(), openFakeSQL(t, f))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if result, sha, err := store.CachedResult(ctx, "o/r", "tree", "lint", time.Now()); err != nil || result != nil || sha != "" {
+		t.Errorf("CachedResult without a cached result = %+v, %q, %v, want none", result, sha, err)
+	}
+	result := &Result{Conclusion: "success", Title: "No issues"}
+	if err := store.CacheResult(ctx, "o/r", "tree", "abc", "lint", result); err != nil {
+		t.Fatal(err)
+	}
+	save := f.exec(t, "INSERT INTO result_cache")
+	if !strings.Contains(save.query, "ON CONFLICT (repo, cache_key, check_name) DO UPDATE") || !reflect.DeepEqual(save.args[:4], []driver.Value{"o/r", "tree", "lint", "abc"}) {
+		t.Errorf("got save %q with %v, want an upsert of lint under tree", save.query, save.args)
+	}
+	saved = save.args[4].(string)
+	got, sha, err := store.CachedResult(ctx, "o/r", "tree", "lint", time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, result) || sha != "abc" {
+		t.Errorf("got cached result %+v of %q, want %+v of abc", got, sha, result)
+	}
+}
+
+func TestSQLStoreWithPostgres(t *testing.T) {
+	dsn := os.Getenv("REVIEWBOT_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("REVIEWBOT_TEST_POSTGRES_DSN isn't set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+	store, err := NewSQLStore(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sha := t.Name() + time.Now().Format(time.RFC3339Nano)
+	t.Cleanup(func() { db.Exec(`DELETE FROM check_runs WHERE head_sha = $1`, sha) })
+
+	started := time.Now().Truncate(time.Millisecond)
+	record := &CheckRunRecord{CheckRunID: started.UnixNano(), InstallationID: 2, FullRepoName: "o/r", HeadSHA: sha, CheckName: nogoCheck, StartedAt: started}
+	if err := store.StartCheckRun(ctx, record); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CompleteCheckRun(ctx, record.CheckRunID, "success", started.Add(time.Second), "ok"); err != nil {
+		t.Fatal(err)
+	}
+	records, err := store.ListCheckRuns(ctx, CheckRunFilter{HeadSHA: sha})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Conclusion != "success" || records[0].Duration != time.Second || records[0].LogExcerpt != "ok" {
+		t.Errorf("got records %+v, want the completed check run", records)
+	}
+}
+
+// memStore is a Store, AnnotationStore and PendingResultStore keeping check
+// runs in memory.
+type memStore struct {
+	mu          sync.Mutex
+	runs        map[int64]*CheckRunRecord
+	annotations map[int64][]*Annotation
+	pending     map[int64]*Result
+	results     map[string]*Result
+	// logs holds the chunks of each log, and done whether it is complete.
+	logs map[string][]string
+	done map[string]bool
+	// cached holds the cached results and the commits they were checked on.
+	cached    map[string]*Result
+	cachedSHA map[string]string
+}
+
+func newMemStore() *memStore {
+	return &memStore{
+		runs:        make(map[int64]*CheckRunRecord),
+		annotations: make(map[int64][]*Annotation),
+		pending:     make(map[int64]*Result),
+		results:     make(map[string]*Result),
+		logs:        make(map[string][]string),
+		done:        make(map[string]bool),
+		cached:      make(map[string]*Result),
+		cachedSHA:   make(map[string]string),
+	}
+}
+
+func (s *memStore) StartCheckRun(_ context.Context, r *CheckRunRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := *r
+	stored.Status = inProgress
+	s.runs[r.CheckRunID] = &stored
+	return nil
+}
+
+func (s *memStore) CompleteCheckRun(_ context.Context, checkRunID int64, conclusion string, completedAt time.Time, logExcerpt string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.runs[checkRunID]
+	if !ok {
+		return errors.New("no such check run")
+	}
+	r.Status = "completed"
+	r.Conclusion = conclusion
+	r.CompletedAt = completedAt
+	r.Duration = completedAt.Sub(r.StartedAt)
+	r.LogExcerpt = logExcerpt
+	return nil
+}
+
+func (s *memStore) ListCheckRuns(_ context.Context, filter CheckRunFilter) ([]*CheckRunRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var records []*CheckRunRecord
+	for _, r := range s.runs {
+		if (filter.CheckRunID == 0 || r.CheckRunID == filter.CheckRunID) &&
+			(filter.FullRepoName == "" || r.FullRepoName == filter.FullRepoName) &&
+			(filter.HeadSHA == "" || r.HeadSHA == filter.HeadSHA) &&
+			(filter.CheckName == "" || r.CheckName == filter.CheckName) &&
+			(filter.Status == "" || r.Status == filter.Status) &&
+			(filter.Conclusion == "" || r.Conclusion == filter.Conclusion) {
+			copied := *r
+			records = append(records, &copied)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].StartedAt.After(records[j].StartedAt)
+	})
+	return records, nil
+}
+
+func (s *memStore) SetAnnotations(_ context.Context, checkRunID int64, annotations []*Annotation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.annotations[checkRunID] = annotations
+	return nil
+}
+
+func (s *memStore) ListAnnotations(_ context.Context, checkRunID int64) ([]*Annotation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.annotations[checkRunID], nil
+}
+
+func (s *memStore) SavePendingResult(_ context.Context, checkRunID int64, result *Result) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending[checkRunID] = result
+	return nil
+}
+
+func (s *memStore) PendingResult(_ context.Context, checkRunID int64) (*Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pending[checkRunID], nil
+}
+
+func (s *memStore) DeletePendingResult(_ context.Context, checkRunID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pending, checkRunID)
+	return nil
+}
+
+func (s *memStore) SaveResult(_ context.Context, fullRepoName string, sha string, checkName string, result *Result) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.results[fullRepoName+"@"+sha+"/"+checkName] = result
+	return nil
+}
+
+func (s *memStore) Result(_ context.Context, fullRepoName string, sha string, checkName string) (*Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.results[fullRepoName+"@"+sha+"/"+checkName], nil
+}
+
+func (s *memStore) StartLog(_ context.Context, fullRepoName string, sha string, checkName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := fullRepoName + "@" + sha + "/" + checkName
+	s.logs[key] = []string{""}
+	s.done[key] = false
+	return nil
+}
+
+func (s *memStore) AppendLog(_ context.Context, fullRepoName string, sha string, checkName string, output string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := fullRepoName + "@" + sha + "/" + checkName
+	s.logs[key] = append(s.logs[key], output)
+	return nil
+}
+
+func (s *memStore) FinishLog(_ context.Context, fullRepoName string, sha string, checkName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := fullRepoName + "@" + sha + "/" + checkName
+	s.logs[key] = append(s.logs[key], "")
+	s.done[key] = true
+	return nil
+}
+
+func (s *memStore) ReadLog(_ context.Context, fullRepoName string, sha string, checkName string, from int) (string, int, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := fullRepoName + "@" + sha + "/" + checkName
+	chunks := s.logs[key]
+	if from >= len(chunks) {
+		return "", from, s.done[key], nil
+	}
+	return strings.Join(chunks[from:], ""), len(chunks), s.done[key], nil
+}
+
+func (s *memStore) CacheResult(_ context.Context, fullRepoName string, key string, sha string, checkName string, result *Result) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cached[fullRepoName+"@"+key+"/"+checkName] = result
+	s.cachedSHA[fullRepoName+"@"+key+"/"+checkName] = sha
+	return nil
+}
+
+func (s *memStore) CachedResult(_ context.Context, fullRepoName string, key string, checkName string, notBefore time.Time) (*Result, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cached[fullRepoName+"@"+key+"/"+checkName], s.cachedSHA[fullRepoName+"@"+key+"/"+checkName], nil
+}
+
+func TestRecordCheckRun(t *testing.T) {
+	store := newMemStore()
+	app := &GithubApp{}
+	job := &Job{InstallationID: testInstallationID, FullRepoName: "o/r", HeadSHA: "abc"}
+	check := &JobCheck{Name: nogoCheck, CheckRunID: 7}
+
+	// Without a store, nothing is recorded.
+	app.recordStart(context.Background(), job, check)
+	app.SetStore(store)
+	app.recordStart(context.Background(), job, check)
+	if r := store.runs[7]; r == nil || r.Status != inProgress || r.FullRepoName != "o/r" || r.CheckName != nogoCheck {
+		t.Fatalf("got record %+v after the start, want check run 7 in progress", r)
+	}
+	annotation := &Annotation{Path: "BUILD", Line: 1, Severity: "failure", Message: "missing dep"}
+	app.recordResult(context.Background(), check, &Result{Conclusion: "failure", Summary: "build failed", Annotations: []*Annotation{annotation}})
+	if r := store.runs[7]; r.Status != "completed" || r.Conclusion != "failure" || r.LogExcerpt != "build failed" {
+		t.Errorf("got record %+v after the result, want a failure with the summary", r)
+	}
+	if as := store.annotations[7]; len(as) != 1 || as[0] != annotation {
+		t.Errorf("got annotations %v, want the result's", as)
+	}
+}
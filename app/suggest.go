@@ -0,0 +1,156 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-github/v43/github"
+)
+
+// maxSuggestions caps the number of suggested changes posted for a check, so
+// that a badly formatted pull request doesn't get buried in comments.
+const maxSuggestions = 50
+
+// postSuggestions runs the check's Fixer on the checkout and posts the changes
+// it makes to lines of the job's pull request as a review with suggested
+// changes. The checkout is restored afterwards.
+func (app *GithubApp) postSuggestions(ctx context.Context, job *Job, checkName string, target *CheckTarget) error {
+	checker, err := GetChecker(checkName)
+	if err != nil {
+		return err
+	}
+	fixer, ok := checker.(Fixer)
+	if !ok || !checker.SupportsFix() {
+		return nil
+	}
+	fixErr := fixer.Fix(ctx, app, target)
+	diff, err := gitOutput(target.Dir, "diff", "-U0")
+	if err := runGit(target.Dir, "checkout", "--", "."); err != nil {
+		return err
+	}
+	if fixErr != nil {
+		return fixErr
+	}
+	if err != nil {
+		return err
+	}
+	hunks := parseHunks(diff)
+	if len(hunks) == 0 {
+		return nil
+	}
+
+	owner, repo := job.ownerAndRepo()
+	ghc := app.jobClient(job)
+	existing, err := reviewCommentKeys(ctx, ghc, owner, repo, job.PullNumber)
+	if err != nil {
+		return err
+	}
+	var comments []*github.DraftReviewComment
+	for _, h := range hunks {
+		c, err := suggestionComment(target.Dir, h, target.pullDiff[h.path])
+		if err != nil {
+			return err
+		}
+		if c == nil || existing[reviewCommentKey(c.GetPath(), c.GetLine(), c.GetBody())] {
+			continue
+		}
+		comments = append(comments, c)
+		if len(comments) == maxSuggestions {
+			break
+		}
+	}
+	if len(comments) == 0 {
+		return nil
+	}
+	review := &github.PullRequestReviewRequest{
+		CommitID: github.String(job.HeadSHA),
+		Body:     github.String(fmt.Sprintf("%s suggests the following changes.", checkName)),
+		Event:    github.String("COMMENT"),
+		Comments: comments,
+	}
+	_, res, err := ghc.PullRequests.CreateReview(ctx, owner, repo, job.PullNumber, review)
+	if err := extractError(ctx, res, err); err != nil {
+		return fmt.Errorf("failed to create review: %s", err)
+	}
+	logFrom(ctx).Infow("posted suggestions", "count", len(comments))
+	return nil
+}
+
+// suggestionComment returns a review comment suggesting the change of h, or
+// nil if the lines it changes aren't part of the pull request's diff d.
+func suggestionComment(dir string, h *hunk, d *fileDiff) (*github.DraftReviewComment, error) {
+	if d == nil {
+		return nil, nil
+	}
+	start, end := h.oldStart, h.oldStart+h.oldLines-1
+	newLines := h.newLines
+	if h.oldLines == 0 {
+		// Suggestions replace lines, so attach an insertion to the line
+		// before it, or the first line for an insertion at the top.
+		b, err := os.ReadFile(filepath.Join(dir, h.path))
+		if err != nil {
+			return nil, err
+		}
+		lines := strings.Split(string(b), "\n")
+		if h.oldStart == 0 {
+			start = 1
+			newLines = append(append([]string(nil), newLines...), lines[0])
+		} else {
+			start = h.oldStart
+			newLines = append([]string{lines[h.oldStart-1]}, newLines...)
+		}
+		end = start
+	}
+	for l := start; l <= end; l++ {
+		if !d.inHunk[l] {
+			return nil, nil
+		}
+	}
+
+	body := "```suggestion\n"
+	if len(newLines) > 0 {
+		body += strings.Join(newLines, "\n") + "\n"
+	}
+	body += "```"
+	c := &github.DraftReviewComment{
+		Path: github.String(h.path),
+		Body: github.String(body),
+		Line: github.Int(end),
+		Side: github.String("RIGHT"),
+	}
+	if start < end {
+		c.StartLine = github.Int(start)
+		c.StartSide = github.String("RIGHT")
+	}
+	return c, nil
+}
+
+func reviewCommentKey(path string, line int, body string) string {
+	return fmt.Sprintf("%s:%d:%s", path, line, body)
+}
+
+// reviewCommentKeys returns the keys of the review comments already on a
+// pull request, so that suggestions aren't posted twice.
+func reviewCommentKeys(ctx context.Context, ghc *github.Client, owner string, repo string, number int) (map[string]bool, error) {
+	keys := make(map[string]bool)
+	opts := &github.PullRequestListCommentsOptions{
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	for {
+		comments, res, err := ghc.PullRequests.ListComments(ctx, owner, repo, number, opts)
+		if err := extractError(ctx, res, err); err != nil {
+			return nil, err
+		}
+		for _, c := range comments {
+			keys[reviewCommentKey(c.GetPath(), c.GetLine(), c.GetBody())] = true
+		}
+		if res.NextPage == 0 {
+			break
+		}
+		opts.Page = res.NextPage
+	}
+	return keys, nil
+}
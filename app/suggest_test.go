@@ -0,0 +1,164 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-github/v43/github"
+)
+
+func TestSuggestionComment(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "main.go", "package main\nimport \"fmt\"\nfunc main() {}\n")
+	d := parsePatch("@@ -0,0 +1,3 @@\n+package main\n+import \"fmt\"\n+func main() {}")
+
+	for _, tc := range []struct {
+		desc  string
+		hunk  *hunk
+		diff  *fileDiff
+		start int
+		line  int
+		body  string
+	}{
+		{
+			desc: "replaced line",
+			hunk: &hunk{path: "main.go", oldStart: 2, oldLines: 1, newLines: []string{`import "os"`}},
+			diff: d,
+			line: 2,
+			body: "```suggestion\nimport \"os\"\n```",
+		},
+		{
+			desc:  "replaced lines",
+			hunk:  &hunk{path: "main.go", oldStart: 2, oldLines: 2, newLines: []string{`import "os"`}},
+			diff:  d,
+			start: 2,
+			line:  3,
+			body:  "```suggestion\nimport \"os\"\n```",
+		},
+		{
+			desc: "deleted line",
+			hunk: &hunk{path: "main.go", oldStart: 2, oldLines: 1},
+			diff: d,
+			line: 2,
+			body: "```suggestion\n```",
+		},
+		{
+			desc: "insertion after a line",
+			hunk: &hunk{path: "main.go", oldStart: 1, oldLines: 0, newLines: []string{""}},
+			diff: d,
+			line: 1,
+			body: "```suggestion\npackage main\n\n```",
+		},
+		{
+			desc: "insertion at the top",
+			hunk: &hunk{path: "main.go", oldStart: 0, oldLines: 0, newLines: []string{"// Command main."}},
+			diff: d,
+			line: 1,
+			body: "```suggestion\n// Command main.\npackage main\n```",
+		},
+	} {
+		c, err := suggestionComment(dir, tc.hunk, tc.diff)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.desc, err)
+		}
+		if c == nil {
+			t.Errorf("%s: got no comment", tc.desc)
+			continue
+		}
+		if c.GetPath() != "main.go" || c.GetLine() != tc.line || c.GetStartLine() != tc.start || c.GetBody() != tc.body {
+			t.Errorf("%s: got comment on lines %d-%d with %q, want lines %d-%d with %q", tc.desc, c.GetStartLine(), c.GetLine(), c.GetBody(), tc.start, tc.line, tc.body)
+		}
+	}
+}
+
+func TestSuggestionCommentOutsideOfPullRequest(t *testing.T) {
+	h := &hunk{path: "main.go", oldStart: 20, oldLines: 1, newLines: []string{"x"}}
+	if c, err := suggestionComment(t.TempDir(), h, nil); err != nil || c != nil {
+		t.Errorf("got %+v, %v for a file the pull request doesn't change, want no comment", c, err)
+	}
+	d := parsePatch("@@ -8,3 +8,3 @@\n a\n-b\n+c\n d")
+	if c, err := suggestionComment(t.TempDir(), h, d); err != nil || c != nil {
+		t.Errorf("got %+v, %v for a line outside of the diff, want no comment", c, err)
+	}
+}
+
+func TestPostSuggestions(t *testing.T) {
+	dir := t.TempDir()
+	testGit(t, dir, "init", "-q")
+	commitTestFile(t, dir, "a.txt", "one\ntwo\nthree\nfour\n")
+	registerTestChecker(t, &funcChecker{
+		name: "test-fix",
+		fixFn: func(ctx context.Context, app *GithubApp, target *CheckTarget) error {
+			writeTestFile(t, target.Dir, "a.txt", "one\nTWO\nthree\nFOUR\n")
+			return nil
+		},
+	})
+
+	f := newFakeGitHub(t)
+	// The change to line four was already suggested.
+	f.handle("GET /repos/o/r/pulls/5/comments", func(w http.ResponseWriter, req *http.Request) {
+		writeTestJSON(w, http.StatusOK, []*github.PullRequestComment{{
+			Path: github.String("a.txt"),
+			Line: github.Int(4),
+			Body: github.String("```suggestion\nFOUR\n```"),
+		}})
+	})
+	review := &github.PullRequestReviewRequest{}
+	f.handle("POST /repos/o/r/pulls/5/reviews", func(w http.ResponseWriter, req *http.Request) {
+		if err := json.NewDecoder(req.Body).Decode(review); err != nil {
+			t.Errorf("failed to decode review: %s", err)
+		}
+		writeTestJSON(w, http.StatusOK, map[string]interface{}{"id": 1})
+	})
+	app := newTestApp(t, f)
+
+	job := &Job{InstallationID: testInstallationID, FullRepoName: "o/r", HeadSHA: "abc", PullNumber: 5, Token: "test-token"}
+	target := &CheckTarget{
+		Dir:      dir,
+		Config:   &CheckConfig{Suggestions: true},
+		pullDiff: map[string]*fileDiff{"a.txt": parsePatch("@@ -1,2 +1,4 @@\n one\n-2\n+two\n+three\n+four")},
+	}
+	if err := app.postSuggestions(context.Background(), job, "test-fix", target); err != nil {
+		t.Fatal(err)
+	}
+	if review.GetCommitID() != "abc" || review.GetEvent() != "COMMENT" {
+		t.Errorf("got review of %q with event %q, want a comment on abc", review.GetCommitID(), review.GetEvent())
+	}
+	if len(review.Comments) != 1 {
+		t.Fatalf("got %d review comments, want only the new suggestion", len(review.Comments))
+	}
+	if c := review.Comments[0]; c.GetPath() != "a.txt" || c.GetLine() != 2 || c.GetBody() != "```suggestion\nTWO\n```" {
+		t.Errorf("got comment %q on %s:%d, want TWO on a.txt:2", c.GetBody(), c.GetPath(), c.GetLine())
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "one\ntwo\nthree\nfour\n" {
+		t.Errorf("checkout has %q after posting suggestions, want the fix to be reverted", b)
+	}
+}
+
+func TestPostSuggestionsWithoutChanges(t *testing.T) {
+	dir := t.TempDir()
+	testGit(t, dir, "init", "-q")
+	commitTestFile(t, dir, "a.txt", "one\n")
+	registerTestChecker(t, &funcChecker{
+		name:  "test-fix",
+		fixFn: func(ctx context.Context, app *GithubApp, target *CheckTarget) error { return nil },
+	})
+	f := newFakeGitHub(t)
+	app := newTestApp(t, f)
+
+	job := &Job{InstallationID: testInstallationID, FullRepoName: "o/r", HeadSHA: "abc", PullNumber: 5, Token: "test-token"}
+	if err := app.postSuggestions(context.Background(), job, "test-fix", &CheckTarget{Dir: dir, Config: &CheckConfig{}}); err != nil {
+		t.Fatal(err)
+	}
+	if n := f.count("GET /repos/o/r/pulls/5/comments"); n != 0 {
+		t.Errorf("listed review comments %d times although the fix changed nothing", n)
+	}
+}
@@ -0,0 +1,145 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/go-github/v43/github"
+)
+
+// summaryCommentMarker identifies the summary comment, so that it is updated
+// in place rather than posted again.
+const summaryCommentMarker = "<!-- reviewbot-summary -->"
+
+var (
+	// summaryLocks serializes updates of the same pull request's summary, so
+	// that concurrent jobs don't both create one.
+	summaryLocksMu sync.Mutex
+	summaryLocks   = make(map[string]*summaryLock)
+)
+
+// summaryLock is the lock of a key in summaryLocks.
+type summaryLock struct {
+	mu sync.Mutex
+	// refs is the number of callers holding or waiting for mu.
+	refs int
+}
+
+// lockSummary locks key and returns a func that unlocks it. The lock of a key
+// is dropped once no one holds or waits for it, so that summaryLocks doesn't
+// grow with every pull request.
+func lockSummary(key string) func() {
+	summaryLocksMu.Lock()
+	l, ok := summaryLocks[key]
+	if !ok {
+		l = &summaryLock{}
+		summaryLocks[key] = l
+	}
+	l.refs++
+	summaryLocksMu.Unlock()
+
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+		summaryLocksMu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(summaryLocks, key)
+		}
+		summaryLocksMu.Unlock()
+	}
+}
+
+// conclusionIcon returns the icon shown for a check run in the summary.
+func conclusionIcon(run *github.CheckRun) string {
+	if run.GetStatus() != "completed" {
+		return "⏳"
+	}
+	switch run.GetConclusion() {
+	case "success":
+		return "✅"
+	case "neutral", "skipped":
+		return "⚪"
+	case "cancelled", "timed_out":
+		return "⚠️"
+	}
+	return "❌"
+}
+
+// summaryCommentBody renders the summary of runs for headSHA.
+func summaryCommentBody(headSHA string, runs map[string]*github.CheckRun) string {
+	var b strings.Builder
+	b.WriteString(summaryCommentMarker + "\n")
+	fmt.Fprintf(&b, "### Review bot results for %s\n\n", headSHA)
+	b.WriteString(summaryTable(runs))
+	return b.String()
+}
+
+// summaryTable renders a markdown table of runs, sorted by check name.
+func summaryTable(runs map[string]*github.CheckRun) string {
+	names := make([]string, 0, len(runs))
+	for name := range runs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString("| | Check | Result | Annotations | Details |\n")
+	b.WriteString("|---|---|---|---|---|\n")
+	for _, name := range names {
+		run := runs[name]
+		result := run.GetConclusion()
+		if run.GetStatus() != "completed" {
+			result = run.GetStatus()
+		}
+		// The details URL is the BuildBuddy invocation, if there is one.
+		details := run.GetDetailsURL()
+		if details == "" {
+			details = run.GetHTMLURL()
+		}
+		fmt.Fprintf(&b, "| %s | %s | %s | %d | [link](%s) |\n",
+			conclusionIcon(run), name, result, run.GetOutput().GetAnnotationsCount(), details)
+	}
+	return b.String()
+}
+
+// updateSummaryComment posts or updates the summary comment on the job's pull
+// request with the latest check runs of its head SHA.
+func (app *GithubApp) updateSummaryComment(ctx context.Context, job *Job) error {
+	owner, repo := job.ownerAndRepo()
+	ghc := app.jobClient(job)
+	unlock := lockSummary(fmt.Sprintf("%s#%d", job.FullRepoName, job.PullNumber))
+	defer unlock()
+
+	runs, err := listCheckRuns(ctx, ghc, job.AppID, owner, repo, job.HeadSHA)
+	if err != nil {
+		return err
+	}
+	delete(runs, aggregateCheckName)
+	body := summaryCommentBody(job.HeadSHA, runs)
+
+	opts := &github.IssueListCommentsOptions{
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	for {
+		comments, res, err := ghc.Issues.ListComments(ctx, owner, repo, job.PullNumber, opts)
+		if err := extractError(ctx, res, err); err != nil {
+			return err
+		}
+		for _, c := range comments {
+			if strings.HasPrefix(c.GetBody(), summaryCommentMarker) {
+				_, res, err := ghc.Issues.EditComment(ctx, owner, repo, c.GetID(), &github.IssueComment{Body: github.String(body)})
+				return extractError(ctx, res, err)
+			}
+		}
+		if res.NextPage == 0 {
+			break
+		}
+		opts.Page = res.NextPage
+	}
+	_, res, err := ghc.Issues.CreateComment(ctx, owner, repo, job.PullNumber, &github.IssueComment{Body: github.String(body)})
+	return extractError(ctx, res, err)
+}
@@ -0,0 +1,163 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-github/v43/github"
+)
+
+func TestSummaryCommentBody(t *testing.T) {
+	runs := map[string]*github.CheckRun{
+		"gofmt": {
+			Status:     github.String("completed"),
+			Conclusion: github.String("failure"),
+			HTMLURL:    github.String("https://github.com/o/r/runs/2"),
+			Output:     &github.CheckRunOutput{AnnotationsCount: github.Int(3)},
+		},
+		"bazel": {
+			Status:     github.String("completed"),
+			Conclusion: github.String("success"),
+			DetailsURL: github.String("https://app.buildbuddy.io/invocation/x"),
+			HTMLURL:    github.String("https://github.com/o/r/runs/1"),
+		},
+		"buildifier": {Status: github.String("in_progress")},
+	}
+	body := summaryCommentBody("abc", runs)
+	if !strings.HasPrefix(body, summaryCommentMarker+"\n") {
+		t.Errorf("body %q doesn't start with the summary marker", body)
+	}
+	rows := []string{
+		"| ✅ | bazel | success | 0 | [link](https://app.buildbuddy.io/invocation/x) |",
+		"| ⏳ | buildifier | in_progress | 0 | [link]() |",
+		"| ❌ | gofmt | failure | 3 | [link](https://github.com/o/r/runs/2) |",
+	}
+	if !strings.HasSuffix(body, strings.Join(rows, "\n")+"\n") {
+		t.Errorf("got body:\n%s\nwant rows sorted by check:\n%s", body, strings.Join(rows, "\n"))
+	}
+}
+
+func TestSummaryCommentEnabled(t *testing.T) {
+	setTestSettings(t, func(s *Settings) { s.SummaryComment = true })
+	if !(&RepoConfig{}).SummaryCommentEnabled() {
+		t.Errorf("summary comment disabled without an override, want the default")
+	}
+	off := false
+	if (&RepoConfig{SummaryComment: &off}).SummaryCommentEnabled() {
+		t.Errorf("summary comment enabled although the repository disabled it")
+	}
+}
+
+// serveSummaryComments lets f list comments on pull request 5 and records
+// the bodies of created and edited comments.
+func serveSummaryComments(t *testing.T, f *fakeGitHub, existing []*github.IssueComment) (created *[]string, edited map[int64]string) {
+	f.handle("GET /repos/o/r/commits/abc/check-runs", func(w http.ResponseWriter, req *http.Request) {
+		writeTestJSON(w, http.StatusOK, map[string]interface{}{
+			"total_count": 1,
+			"check_runs":  []interface{}{map[string]interface{}{"name": "gofmt", "status": "completed", "conclusion": "success"}},
+		})
+	})
+	f.handle("GET /repos/o/r/issues/5/comments", func(w http.ResponseWriter, req *http.Request) {
+		writeTestJSON(w, http.StatusOK, existing)
+	})
+	created = &[]string{}
+	edited = make(map[int64]string)
+	decode := func(req *http.Request) string {
+		comment := &github.IssueComment{}
+		if err := json.NewDecoder(req.Body).Decode(comment); err != nil {
+			t.Errorf("failed to decode comment: %s", err)
+		}
+		return comment.GetBody()
+	}
+	f.handle("POST /repos/o/r/issues/5/comments", func(w http.ResponseWriter, req *http.Request) {
+		*created = append(*created, decode(req))
+		writeTestJSON(w, http.StatusCreated, map[string]interface{}{"id": 100})
+	})
+	for _, c := range existing {
+		id := c.GetID()
+		f.handle("PATCH /repos/o/r/issues/comments/"+strconv.FormatInt(id, 10), func(w http.ResponseWriter, req *http.Request) {
+			edited[id] = decode(req)
+			writeTestJSON(w, http.StatusOK, map[string]interface{}{"id": id})
+		})
+	}
+	return created, edited
+}
+
+func summaryTestJob() *Job {
+	return &Job{AppID: testAppID, InstallationID: testInstallationID, FullRepoName: "o/r", HeadSHA: "abc", PullNumber: 5, Token: "test-token"}
+}
+
+func TestUpdateSummaryCommentCreatesComment(t *testing.T) {
+	f := newFakeGitHub(t)
+	created, _ := serveSummaryComments(t, f, []*github.IssueComment{{ID: github.Int64(7), Body: github.String("LGTM")}})
+	app := newTestApp(t, f)
+
+	if err := app.updateSummaryComment(context.Background(), summaryTestJob()); err != nil {
+		t.Fatal(err)
+	}
+	if len(*created) != 1 || !strings.Contains((*created)[0], "| ✅ | gofmt | success |") {
+		t.Errorf("created comments %q, want one summarizing gofmt", *created)
+	}
+	if n := f.count("PATCH /repos/o/r/issues/comments/7"); n != 0 {
+		t.Errorf("edited another comment %d times", n)
+	}
+}
+
+func TestUpdateSummaryCommentEditsExistingComment(t *testing.T) {
+	f := newFakeGitHub(t)
+	created, edited := serveSummaryComments(t, f, []*github.IssueComment{
+		{ID: github.Int64(7), Body: github.String("LGTM")},
+		{ID: github.Int64(8), Body: github.String(summaryCommentMarker + "\nold results")},
+	})
+	app := newTestApp(t, f)
+
+	if err := app.updateSummaryComment(context.Background(), summaryTestJob()); err != nil {
+		t.Fatal(err)
+	}
+	if len(*created) != 0 {
+		t.Errorf("created comments %q, want the existing summary to be edited", *created)
+	}
+	if body := edited[8]; !strings.Contains(body, "| ✅ | gofmt | success |") {
+		t.Errorf("edited summary to %q, want the latest results", body)
+	}
+}
+
+func TestLockSummaryDropsUnusedLocks(t *testing.T) {
+	const lockers = 20
+	var wg sync.WaitGroup
+	held := 0
+	for i := 0; i < lockers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock := lockSummary("o/r#1")
+			defer unlock()
+			held++
+			if held != 1 {
+				t.Errorf("%d callers hold the lock of the same key", held)
+			}
+			held--
+		}()
+	}
+	wg.Wait()
+
+	unlock := lockSummary("o/r#2")
+	summaryLocksMu.Lock()
+	n := len(summaryLocks)
+	summaryLocksMu.Unlock()
+	if n != 1 {
+		t.Errorf("%d locks are kept while one key is locked, want 1", n)
+	}
+	unlock()
+	summaryLocksMu.Lock()
+	n = len(summaryLocks)
+	summaryLocksMu.Unlock()
+	if n != 0 {
+		t.Errorf("%d locks are kept after every key was unlocked, want 0", n)
+	}
+}
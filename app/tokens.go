@@ -0,0 +1,94 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v43/github"
+)
+
+// TokenRefreshMargin is how long before it expires a cached installation
+// token is replaced. Installation tokens are valid for an hour, so every token
+// handed out, e.g. to a job, stays valid for at least this long.
+var TokenRefreshMargin = 30 * time.Minute
+
+// cachedToken is the current token of an installation. Its mutex is held
+// while the token is refreshed, so that concurrent callers share one refresh.
+type cachedToken struct {
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+// tokenCache holds the installation tokens of the app, so that one isn't
+// created for every request, clone and push.
+type tokenCache struct {
+	mu     sync.Mutex
+	tokens map[int64]*cachedToken
+}
+
+func newTokenCache() *tokenCache {
+	return &tokenCache{tokens: make(map[int64]*cachedToken)}
+}
+
+func (c *tokenCache) entry(installationID int64) *cachedToken {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t, ok := c.tokens[installationID]
+	if !ok {
+		t = &cachedToken{}
+		c.tokens[installationID] = t
+	}
+	return t
+}
+
+// Token returns an installation token that is valid for at least
+// TokenRefreshMargin, creating a new one if the cached one expires sooner.
+func (app *GithubApp) Token(ctx context.Context, installationID int64) (string, error) {
+	if app.appsTransport == nil {
+		// Workers only have the tokens of their jobs.
+		return "", fmt.Errorf("no GitHub app credentials to create a token of installation %d with", installationID)
+	}
+	t := app.tokens.entry(installationID)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token != "" && time.Until(t.expiresAt) > TokenRefreshMargin {
+		return t.token, nil
+	}
+	tok, res, err := app.GetAppClient().Apps.CreateInstallationToken(ctx, installationID, &github.InstallationTokenOptions{})
+	if err := extractError(ctx, res, err); err != nil {
+		return "", err
+	}
+	t.token = tok.GetToken()
+	t.expiresAt = tok.GetExpiresAt()
+	return t.token, nil
+}
+
+// invalidateToken drops the cached token of an installation, e.g. because
+// GitHub rejected it, so that the next call to Token creates a new one.
+func (app *GithubApp) invalidateToken(installationID int64) {
+	t := app.tokens.entry(installationID)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.token = ""
+}
+
+// installationTransport authenticates requests as an installation with its
+// cached token.
+type installationTransport struct {
+	app            *GithubApp
+	installationID int64
+}
+
+func (t *installationTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.app.Token(req.Context(), t.installationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get token of installation %d: %s", t.installationID, err)
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "token "+token)
+	return http.DefaultTransport.RoundTrip(req)
+}
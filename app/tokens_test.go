@@ -0,0 +1,76 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+const accessTokensRoute = "POST /app/installations/2/access_tokens"
+
+func TestTokenIsCached(t *testing.T) {
+	f := newFakeGitHub(t)
+	app := newTestApp(t, f)
+
+	for i := 0; i < 2; i++ {
+		token, err := app.Token(context.Background(), testInstallationID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "test-token" {
+			t.Errorf("got token %q, want test-token", token)
+		}
+	}
+	if n := f.count(accessTokensRoute); n != 1 {
+		t.Errorf("created %d tokens, want the first one to be reused", n)
+	}
+
+	app.invalidateToken(testInstallationID)
+	if _, err := app.Token(context.Background(), testInstallationID); err != nil {
+		t.Fatal(err)
+	}
+	if n := f.count(accessTokensRoute); n != 2 {
+		t.Errorf("created %d tokens, want a new one after invalidating the cached one", n)
+	}
+}
+
+func TestTokenIsRefreshedBeforeExpiry(t *testing.T) {
+	defer func(margin time.Duration) { TokenRefreshMargin = margin }(TokenRefreshMargin)
+	// Tokens of the fake expire in an hour.
+	TokenRefreshMargin = 2 * time.Hour
+	f := newFakeGitHub(t)
+	app := newTestApp(t, f)
+
+	for i := 0; i < 2; i++ {
+		if _, err := app.Token(context.Background(), testInstallationID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := f.count(accessTokensRoute); n != 2 {
+		t.Errorf("created %d tokens, want a new one for every token expiring within the margin", n)
+	}
+}
+
+func TestInstallationTransport(t *testing.T) {
+	f := newFakeGitHub(t)
+	var auths []string
+	f.handle("GET /repos/o/r", func(w http.ResponseWriter, req *http.Request) {
+		auths = append(auths, req.Header.Get("Authorization"))
+		writeTestJSON(w, http.StatusOK, testRepo())
+	})
+	app := newTestApp(t, f)
+	ghc := app.GetClient(testInstallationID)
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := ghc.Repositories.Get(context.Background(), "o", "r"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(auths) != 2 || auths[0] != "token test-token" || auths[1] != "token test-token" {
+		t.Errorf("got authorizations %q, want the installation token", auths)
+	}
+	if n := f.count(accessTokensRoute); n != 1 {
+		t.Errorf("created %d tokens for two requests, want one", n)
+	}
+}
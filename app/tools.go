@@ -0,0 +1,156 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+var (
+	// ToolsDir is where downloaded tools are kept, by name and version.
+	ToolsDir = filepath.Join(os.TempDir(), "reviewbot-tools")
+)
+
+// toolVersionRegex matches the versions that tools may be pinned to, which
+// are part of their path in ToolsDir.
+var toolVersionRegex = regexp.MustCompile(`^[0-9A-Za-z][0-9A-Za-z._-]*$`)
+
+var (
+	// toolLocks serializes downloads of the same tool.
+	toolLocksMu sync.Mutex
+	toolLocks   = make(map[string]*sync.Mutex)
+)
+
+func toolLock(path string) *sync.Mutex {
+	toolLocksMu.Lock()
+	defer toolLocksMu.Unlock()
+	l, ok := toolLocks[path]
+	if !ok {
+		l = &sync.Mutex{}
+		toolLocks[path] = l
+	}
+	return l
+}
+
+// isBuildtool reports whether tool is a buildtools binary that the bot can
+// download.
+func isBuildtool(tool string) bool {
+	return tool == "buildifier" || tool == "buildozer"
+}
+
+// isDownloaded reports whether the bot downloads the binary that checks
+// require as tool, rather than running it from PATH.
+func isDownloaded(tool string) bool {
+	settings := CurrentSettings()
+	return (isBuildtool(tool) && settings.BuildtoolsVersion != "") || (tool == "bb" && settings.BazeliskVersion != "")
+}
+
+// bazelTool returns the bazel that checks run: bazelisk of BazeliskVersion,
+// downloaded into ToolsDir on first use, or the bazelBinary from PATH. Tools
+// run in containers always come from the image.
+func bazelTool(ctx context.Context) (string, error) {
+	settings := CurrentSettings()
+	if settings.BazeliskVersion == "" {
+		return bazelBinary(), nil
+	}
+	if _, ok := CheckExecutor.(LocalExecutor); !ok {
+		return bazelBinary(), nil
+	}
+	return downloadedTool(ctx, "bazelisk", settings.BazeliskVersion, settings.BazeliskURL)
+}
+
+// bazelBinary is the name of the bazel that checks run if it isn't
+// downloaded: bb, or plain bazel if UseBuildBuddy is false.
+func bazelBinary() string {
+	if UseBuildBuddy {
+		return "bb"
+	}
+	return "bazel"
+}
+
+// buildtool returns the path of the buildtools binary tool for target:
+// the release pinned by the check's configuration or BuildtoolsVersion,
+// downloaded into ToolsDir on first use, or tool itself to run the one on
+// PATH if no release is set. Tools run in containers always come from the
+// image.
+func buildtool(ctx context.Context, tool string, target *CheckTarget) (string, error) {
+	settings := CurrentSettings()
+	version := settings.BuildtoolsVersion
+	if target.Config.Version != "" {
+		version = target.Config.Version
+	}
+	if version == "" {
+		return tool, nil
+	}
+	if _, ok := CheckExecutor.(LocalExecutor); !ok {
+		return tool, nil
+	}
+	return downloadedTool(ctx, tool, version, settings.BuildtoolsURL)
+}
+
+// downloadedTool returns the path of version of tool in ToolsDir,
+// downloading it from urlTemplate first if it isn't there yet. See
+// BuildtoolsURL for the syntax of urlTemplate.
+func downloadedTool(ctx context.Context, tool string, version string, urlTemplate string) (string, error) {
+	if !toolVersionRegex.MatchString(version) {
+		return "", fmt.Errorf("invalid %s version %q", tool, version)
+	}
+	path := filepath.Join(ToolsDir, tool, version, tool)
+	l := toolLock(path)
+	l.Lock()
+	defer l.Unlock()
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	url := strings.NewReplacer("{version}", version, "{tool}", tool, "{os}", runtime.GOOS, "{arch}", runtime.GOARCH).Replace(urlTemplate)
+	logFrom(ctx).Infow("downloading tool", "tool", tool, "version", version, "url", url)
+	if err := downloadTool(ctx, url, path); err != nil {
+		return "", fmt.Errorf("failed to download %s %s: %s", tool, version, err)
+	}
+	return path, nil
+}
+
+// downloadTool downloads the executable at url to path. Nothing is left at
+// path if it fails.
+func downloadTool(ctx context.Context, url string, path string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, res.Status)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// Write to a temporary file first, so that concurrent processes never
+	// run a partial download.
+	f, err := os.CreateTemp(filepath.Dir(path), ".download-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, res.Body); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0755); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
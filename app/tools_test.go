@@ -0,0 +1,213 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+// serveBuildtools serves script as every buildtools release and returns the
+// paths that were downloaded.
+func serveBuildtools(t *testing.T, script string) func() []string {
+	var mu sync.Mutex
+	var downloads []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		downloads = append(downloads, req.URL.Path)
+		mu.Unlock()
+		if filepath.Base(filepath.Dir(req.URL.Path)) == "missing" {
+			http.NotFound(w, req)
+			return
+		}
+		w.Write([]byte(script))
+	}))
+	t.Cleanup(srv.Close)
+	oldDir := ToolsDir
+	t.Cleanup(func() { ToolsDir = oldDir })
+	ToolsDir = t.TempDir()
+	setTestSettings(t, func(s *Settings) { s.BuildtoolsURL = srv.URL + "/{version}/{tool}-{os}-{arch}" })
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), downloads...)
+	}
+}
+
+func TestBuildtoolOnPath(t *testing.T) {
+	downloads := serveBuildtools(t, "")
+	tool, err := buildtool(context.Background(), "buildifier", &CheckTarget{Config: &CheckConfig{}})
+	if err != nil || tool != "buildifier" {
+		t.Errorf("buildtool without a version = %q, %v, want the one on PATH", tool, err)
+	}
+	if len(downloads()) != 0 {
+		t.Errorf("downloaded %q without a version", downloads())
+	}
+}
+
+func TestBuildtoolDownloadsPinnedVersion(t *testing.T) {
+	downloads := serveBuildtools(t, "#!/bin/sh\necho pinned\n")
+	setTestSettings(t, func(s *Settings) { s.BuildtoolsVersion = "v6.0.0" })
+	target := &CheckTarget{Dir: t.TempDir(), Config: &CheckConfig{Version: "v7.1.2"}}
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		tool, err := buildtool(ctx, "buildozer", target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := filepath.Join(ToolsDir, "buildozer", "v7.1.2", "buildozer"); tool != want {
+			t.Errorf("got %s, want %s", tool, want)
+		}
+		stdOut, _, err := runCheckCmd(ctx, target, tool)
+		if err != nil || stdOut.String() != "pinned\n" {
+			t.Errorf("downloaded tool printed %q, %v, want it to run", stdOut.String(), err)
+		}
+	}
+	want := "/v7.1.2/buildozer-" + runtime.GOOS + "-" + runtime.GOARCH
+	if got := downloads(); len(got) != 1 || got[0] != want {
+		t.Errorf("downloaded %q, want %s once", got, want)
+	}
+
+	// Checks that don't pin a version use the default.
+	if tool, err := buildtool(ctx, "buildifier", &CheckTarget{Config: &CheckConfig{}}); err != nil || tool != filepath.Join(ToolsDir, "buildifier", "v6.0.0", "buildifier") {
+		t.Errorf("buildtool = %q, %v, want the default version", tool, err)
+	}
+}
+
+func TestBuildtoolDownloadFailure(t *testing.T) {
+	serveBuildtools(t, "")
+	if _, err := buildtool(context.Background(), "buildifier", &CheckTarget{Config: &CheckConfig{Version: "missing"}}); err == nil {
+		t.Errorf("buildtool of a missing release succeeded")
+	}
+	if entries, _ := os.ReadDir(filepath.Join(ToolsDir, "buildifier", "missing")); len(entries) != 0 {
+		t.Errorf("failed download left %d files", len(entries))
+	}
+}
+
+func TestBuildtoolInvalidVersion(t *testing.T) {
+	serveBuildtools(t, "")
+	for _, version := range []string{"../../bin", "v1 2", "-v1"} {
+		if _, err := buildtool(context.Background(), "buildifier", &CheckTarget{Config: &CheckConfig{Version: version}}); err == nil {
+			t.Errorf("buildtool of version %q succeeded", version)
+		}
+	}
+}
+
+func TestBuildtoolInContainer(t *testing.T) {
+	downloads := serveBuildtools(t, "")
+	useFakeContainerRuntime(t, "")
+	tool, err := buildtool(context.Background(), "buildifier", &CheckTarget{Config: &CheckConfig{Version: "v7.1.2"}})
+	if err != nil || tool != "buildifier" {
+		t.Errorf("buildtool in a container = %q, %v, want the image's", tool, err)
+	}
+	if len(downloads()) != 0 {
+		t.Errorf("downloaded %q for a container", downloads())
+	}
+}
+
+func TestCheckBuildifierRunsPinnedVersion(t *testing.T) {
+	serveBuildtools(t, "#!/bin/sh\necho '{\"success\": true, \"files\": [{\"filename\": \"BUILD\", \"formatted\": true, \"valid\": true}]}'\n")
+	// The buildifier on PATH would fail the check.
+	installFakeTool(t, "buildifier", "exit 1\n")
+	res, err := checkBuildifier(context.Background(), nil, &CheckTarget{Dir: t.TempDir(), Config: &CheckConfig{Version: "v7.1.2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Conclusion != "success" {
+		t.Errorf("got %s, want the pinned buildifier's result", res.Conclusion)
+	}
+}
+
+// serveBazelisk serves script as every bazelisk release of version and
+// returns the paths that were downloaded.
+func serveBazelisk(t *testing.T, version string, script string) func() []string {
+	downloads := serveBuildtools(t, script)
+	setTestSettings(t, func(s *Settings) {
+		s.BazeliskVersion = version
+		s.BazeliskURL = s.BuildtoolsURL
+	})
+	return downloads
+}
+
+func TestIsDownloaded(t *testing.T) {
+	serveBazelisk(t, "", "")
+	if isDownloaded("bb") || isDownloaded("buildifier") {
+		t.Errorf("tools without a version are downloaded")
+	}
+	setTestSettings(t, func(s *Settings) { s.BazeliskVersion = "v1.19.0" })
+	if !isDownloaded("bb") || isDownloaded("buildifier") || isDownloaded("golangci-lint") {
+		t.Errorf("with a bazelisk version, want only bb downloaded")
+	}
+	setTestSettings(t, func(s *Settings) { s.BuildtoolsVersion = "v7.1.2" })
+	if !isDownloaded("buildifier") || !isDownloaded("buildozer") {
+		t.Errorf("with a buildtools version, want buildifier and buildozer downloaded")
+	}
+}
+
+func TestBazelToolWithoutBazelisk(t *testing.T) {
+	downloads := serveBazelisk(t, "", "")
+	tool, err := bazelTool(context.Background())
+	if err != nil || tool != "bb" {
+		t.Errorf("bazelTool without a version = %q, %v, want bb", tool, err)
+	}
+	if len(downloads()) != 0 {
+		t.Errorf("downloaded %q without a version", downloads())
+	}
+}
+
+func TestBazelToolInContainer(t *testing.T) {
+	downloads := serveBazelisk(t, "v1.19.0", "")
+	useFakeContainerRuntime(t, "")
+	tool, err := bazelTool(context.Background())
+	if err != nil || tool != "bb" {
+		t.Errorf("bazelTool in a container = %q, %v, want the image's bb", tool, err)
+	}
+	if len(downloads()) != 0 {
+		t.Errorf("downloaded %q for a container", downloads())
+	}
+}
+
+func TestRunCheckCmdRunsBazelisk(t *testing.T) {
+	downloads := serveBazelisk(t, "v1.19.0", "#!/bin/sh\necho bazelisk \"$@\"\n")
+	// The bb on PATH would fail the check.
+	installFakeTool(t, "bb", "exit 1\n")
+	target := &CheckTarget{Dir: t.TempDir(), outputBase: "/ws/output_base"}
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		stdOut, _, err := runCheckCmd(ctx, target, "bb", "build", "//...")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := stdOut.String(), "bazelisk --output_base=/ws/output_base build //...\n"; got != want {
+			t.Errorf("ran %q, want %q", got, want)
+		}
+	}
+	want := "/v1.19.0/bazelisk-" + runtime.GOOS + "-" + runtime.GOARCH
+	if got := downloads(); len(got) != 1 || got[0] != want {
+		t.Errorf("downloaded %q, want %s once", got, want)
+	}
+}
+
+func TestBazelWorkspaceRunsBazelisk(t *testing.T) {
+	log := filepath.Join(t.TempDir(), "bazelisk.log")
+	serveBazelisk(t, "v1.19.0", "#!/bin/sh\necho \"$@\" >> "+log+"\n")
+	ws := &bazelWorkspace{dir: t.TempDir()}
+	os.Mkdir(ws.src(), 0755)
+
+	if err := ws.bazel(context.Background(), "shutdown"); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "--output_base=" + ws.outputBase() + " shutdown\n"; string(b) != want {
+		t.Errorf("ran bazelisk %q, want %q", b, want)
+	}
+}
@@ -0,0 +1,160 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v43/github"
+)
+
+var (
+	// CacheWarmupWorkers is the number of warmup builds run at the same time
+	// across all repositories.
+	CacheWarmupWorkers = 1
+)
+
+// warmupCheckName names the checkout directory of warmup builds. It isn't a
+// registered check.
+const warmupCheckName = "cache-warmup"
+
+// warmupRequest is a commit of a repository's default branch to warm the
+// cache for.
+type warmupRequest struct {
+	installationID int64
+	fullRepoName   string
+	sha            string
+	branch         string
+}
+
+// warmupScheduler runs warmup builds in the background. A repository has at
+// most one warmup build running; pushes arriving meanwhile only keep the
+// latest commit, which is built next, since building the skipped commits
+// wouldn't warm anything the latest one doesn't.
+type warmupScheduler struct {
+	app   *GithubApp
+	slots chan struct{}
+
+	mu      sync.Mutex
+	running map[string]bool
+	pending map[string]*warmupRequest
+}
+
+func newWarmupScheduler(app *GithubApp, workers int) *warmupScheduler {
+	if workers < 1 {
+		workers = 1
+	}
+	return &warmupScheduler{
+		app:     app,
+		slots:   make(chan struct{}, workers),
+		running: make(map[string]bool),
+		pending: make(map[string]*warmupRequest),
+	}
+}
+
+// Schedule queues a warmup build of req. It doesn't block.
+func (s *warmupScheduler) Schedule(req *warmupRequest) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running[req.fullRepoName] {
+		s.pending[req.fullRepoName] = req
+		return
+	}
+	s.running[req.fullRepoName] = true
+	go s.run(req)
+}
+
+// run builds req and then whatever was pushed to the repository meanwhile.
+func (s *warmupScheduler) run(req *warmupRequest) {
+	repo := req.fullRepoName
+	for {
+		s.slots <- struct{}{}
+		ctx := withLogFields(context.Background(), "repo", repo, "sha", req.sha)
+		if err := s.app.warmCache(ctx, req); err != nil {
+			logFrom(ctx).Warnw("cache warmup failed", "error", err)
+		}
+		<-s.slots
+
+		s.mu.Lock()
+		next, ok := s.pending[repo]
+		delete(s.pending, repo)
+		if !ok {
+			delete(s.running, repo)
+			s.mu.Unlock()
+			return
+		}
+		s.mu.Unlock()
+		req = next
+	}
+}
+
+// handlePush schedules a warmup build for a push to the default branch of a
+// repository that has cache warmup enabled.
+func (app *GithubApp) handlePush(ctx context.Context, e *github.PushEvent) error {
+	repo := e.GetRepo()
+	if e.GetDeleted() || e.GetRef() != "refs/heads/"+repo.GetDefaultBranch() {
+		return nil
+	}
+	sha := e.GetAfter()
+	config, err := app.fetchRepoConfig(ctx, e.GetInstallation().GetID(), repo.GetOwner().GetLogin(), repo.GetName(), sha)
+	if err != nil {
+		return err
+	}
+	if !config.CacheWarmupEnabled() {
+		return nil
+	}
+	logFrom(ctx).Infow("scheduling cache warmup", "repo", repo.GetFullName(), "sha", sha)
+	app.warmups.Schedule(&warmupRequest{
+		installationID: e.GetInstallation().GetID(),
+		fullRepoName:   repo.GetFullName(),
+		sha:            sha,
+		branch:         repo.GetDefaultBranch(),
+	})
+	return nil
+}
+
+// warmCache clones req.sha and builds the targets of the bazel check with its
+// flags, so that check runs get cache hits on the same actions.
+func (app *GithubApp) warmCache(ctx context.Context, req *warmupRequest) error {
+	ctx, cancel := context.WithTimeout(ctx, CurrentSettings().CacheWarmupTimeout)
+	defer cancel()
+	dir, err := allocWorkspace(ctx, req.fullRepoName, req.sha, warmupCheckName)
+	if err != nil {
+		return err
+	}
+	defer releaseWorkspace(ctx, dir)
+	if _, err := app.cloneRepo(ctx, req.fullRepoName, req.installationID, GitRef{hash: req.sha}, dir); err != nil {
+		return fmt.Errorf("failed to clone repo: %s", err)
+	}
+	config, err := loadRepoConfigFromDir(dir)
+	if err != nil {
+		return err
+	}
+	target := &CheckTarget{
+		InstallationID: req.installationID,
+		FullRepoName:   req.fullRepoName,
+		HeadSHA:        req.sha,
+		Dir:            dir,
+		Config:         config.Check(nogoCheck),
+		bazel:          config.Bazel.settings(req.branch, nogoCheck),
+	}
+	apiKey, err := app.BuildBuddyAPIKey(ctx, target)
+	if err != nil {
+		return err
+	}
+	limiter, err := newResourceLimiter(target.Config.Limits)
+	if err != nil {
+		return err
+	}
+	defer limiter.release(ctx)
+	ctx = withLimits(ctx, limiter)
+	start := time.Now()
+	stdOut, stdErr, err := runBazel(ctx, apiKey, target, "build", append([]string{"--keep_going"}, target.bazelFlags("build")...), target.Config.BazelTargets(target.bazel))
+	out := parseBazelOutput(ctx, &stdOut)
+	if err != nil {
+		return fmt.Errorf("build failed: %s: %s", err, truncateOutput(cleanLine(stdErr.String()), CurrentSettings().MaxOutputLines))
+	}
+	logFrom(ctx).Infow("cache warmup finished", "duration", time.Since(start).String(), "invocation", out.primaryURL())
+	return nil
+}
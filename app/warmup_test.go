@@ -0,0 +1,198 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/cgi"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v43/github"
+)
+
+// serveTestRepo lets f serve clones of the repository at src as o/r, with
+// before called ahead of each clone.
+func serveTestRepo(t *testing.T, f *fakeGitHub, src string, before func()) {
+	root := t.TempDir()
+	testGit(t, root, "clone", "-q", "--bare", src, filepath.Join(root, "o", "r.git"))
+	backend := &cgi.Handler{
+		Path: filepath.Join(testGit(t, root, "--exec-path"), "git-http-backend"),
+		Env:  []string{"GIT_PROJECT_ROOT=" + root, "GIT_HTTP_EXPORT_ALL=1"},
+	}
+	f.handle("GET /o/r.git/info/refs", func(w http.ResponseWriter, req *http.Request) {
+		if before != nil {
+			before()
+		}
+		backend.ServeHTTP(w, req)
+	})
+	f.handle("POST /o/r.git/git-upload-pack", backend.ServeHTTP)
+}
+
+// installFakeWarmupBazel installs a bb that records the commit it builds and
+// its arguments to the returned file.
+func installFakeWarmupBazel(t *testing.T) string {
+	log := filepath.Join(t.TempDir(), "builds")
+	installFakeTool(t, "bb", `echo "$(git rev-parse HEAD) $*" >> `+log+"\n")
+	return log
+}
+
+// waitForWarmups waits until s has no running warmup builds.
+func waitForWarmups(t *testing.T, s *warmupScheduler) {
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		s.mu.Lock()
+		n := len(s.running)
+		s.mu.Unlock()
+		if n == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("warmup builds didn't finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func pushEvent(ref string, after string) *github.PushEvent {
+	return &github.PushEvent{
+		Ref:          github.String(ref),
+		After:        github.String(after),
+		Repo:         &github.PushEventRepository{FullName: github.String("o/r"), Name: github.String("r"), Owner: &github.User{Login: github.String("o")}, DefaultBranch: github.String("main")},
+		Installation: &github.Installation{ID: github.Int64(testInstallationID)},
+	}
+}
+
+func TestCacheWarmupEnabled(t *testing.T) {
+	setTestSettings(t, func(s *Settings) { s.CacheWarmup = true })
+	off := false
+	if !(&RepoConfig{}).CacheWarmupEnabled() || (&RepoConfig{CacheWarmup: &off}).CacheWarmupEnabled() {
+		t.Errorf("got the app-wide setting overridden incorrectly")
+	}
+}
+
+func TestHandlePushWarmsCache(t *testing.T) {
+	src := t.TempDir()
+	testGit(t, src, "init", "-q", "-b", "main")
+	sha := commitTestFile(t, src, repoConfigFile, "cache_warmup: true\nchecks:\n  bazel:\n    flags: [--config=ci]\n")
+	f := newFakeGitHub(t)
+	serveRepoConfig(f, "cache_warmup: true\n")
+	serveTestRepo(t, f, src, nil)
+	builds := installFakeWarmupBazel(t)
+	root := setWorkspaceDir(t, 0)
+	app := newTestApp(t, f)
+
+	if err := app.processEvent(context.Background(), pushEvent("refs/heads/main", sha)); err != nil {
+		t.Fatal(err)
+	}
+	waitForWarmups(t, app.warmups)
+	b, err := os.ReadFile(builds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := sha + " build --remote_header=x-buildbuddy-api-key=bb-key --keep_going --config=ci -- //...\n"
+	if string(b) != want {
+		t.Errorf("got builds %q, want %q", b, want)
+	}
+	if entries, err := os.ReadDir(filepath.Join(root, "o", "r")); err != nil || len(entries) != 0 {
+		t.Errorf("got %d workspaces, %v after the warmup, want its checkout removed", len(entries), err)
+	}
+}
+
+func TestHandlePushSkipsOtherPushes(t *testing.T) {
+	f := newFakeGitHub(t)
+	serveRepoConfig(f, "cache_warmup: false\n")
+	app := newTestApp(t, f)
+
+	for _, e := range []*github.PushEvent{
+		pushEvent("refs/heads/feature", "abc"),
+		pushEvent("refs/tags/v1", "abc"),
+		{Ref: github.String("refs/heads/main"), Deleted: github.Bool(true), Repo: pushEvent("", "").Repo},
+	} {
+		if err := app.handlePush(context.Background(), e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := f.count("GET /repos/o/r/contents/" + repoConfigFile); n != 0 {
+		t.Errorf("fetched the config %d times for pushes to other refs", n)
+	}
+
+	if err := app.handlePush(context.Background(), pushEvent("refs/heads/main", "abc")); err != nil {
+		t.Fatal(err)
+	}
+	if len(app.warmups.running) != 0 {
+		t.Errorf("scheduled a warmup for a repository that disabled it")
+	}
+}
+
+func TestWarmupSchedulerBuildsLatestPendingPush(t *testing.T) {
+	src := t.TempDir()
+	testGit(t, src, "init", "-q", "-b", "main")
+	first := commitTestFile(t, src, "a.txt", "1\n")
+	second := commitTestFile(t, src, "a.txt", "2\n")
+	third := commitTestFile(t, src, "a.txt", "3\n")
+	f := newFakeGitHub(t)
+	started := make(chan struct{}, 3)
+	release := make(chan struct{})
+	serveTestRepo(t, f, src, func() {
+		started <- struct{}{}
+		<-release
+	})
+	builds := installFakeWarmupBazel(t)
+	app := newTestApp(t, f)
+
+	app.warmups.Schedule(&warmupRequest{installationID: testInstallationID, fullRepoName: "o/r", sha: first})
+	<-started
+	app.warmups.Schedule(&warmupRequest{installationID: testInstallationID, fullRepoName: "o/r", sha: second})
+	app.warmups.Schedule(&warmupRequest{installationID: testInstallationID, fullRepoName: "o/r", sha: third})
+	close(release)
+	waitForWarmups(t, app.warmups)
+
+	b, err := os.ReadFile(builds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var built []string
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		built = append(built, strings.Fields(line)[0])
+	}
+	if len(built) != 2 || built[0] != first || built[1] != third {
+		t.Errorf("built %v, want %s and then only the latest push %s", built, first, third)
+	}
+}
+
+func TestHandlePushWarmsCacheWithBranchSettings(t *testing.T) {
+	src := t.TempDir()
+	testGit(t, src, "init", "-q", "-b", "main")
+	sha := commitTestFile(t, src, repoConfigFile, `cache_warmup: true
+bazel:
+  targets: ["//app/..."]
+  branches:
+    - branch: main
+      configs: [opt]
+checks:
+  bazel:
+    flags: [--config=ci]
+`)
+	f := newFakeGitHub(t)
+	serveRepoConfig(f, "cache_warmup: true\n")
+	serveTestRepo(t, f, src, nil)
+	builds := installFakeWarmupBazel(t)
+	setWorkspaceDir(t, 0)
+	app := newTestApp(t, f)
+
+	if err := app.processEvent(context.Background(), pushEvent("refs/heads/main", sha)); err != nil {
+		t.Fatal(err)
+	}
+	waitForWarmups(t, app.warmups)
+	b, err := os.ReadFile(builds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := sha + " build --remote_header=x-buildbuddy-api-key=bb-key --keep_going --config=opt --config=ci -- //app/...\n"
+	if string(b) != want {
+		t.Errorf("got builds %q, want %q", b, want)
+	}
+}
@@ -0,0 +1,123 @@
+package app
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// workerService is the gRPC service of remote workers. Its only method,
+// RunJob, takes a Job and returns once the job is queued. Messages are encoded
+// as JSON, see jsonCodec, so that the service needs no generated code.
+const workerService = "reviewbot.Worker"
+
+const runJobMethod = "/" + workerService + "/RunJob"
+
+// jsonCodec encodes the messages of the worker service as JSON.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+func (jsonCodec) Name() string { return "json" }
+
+// jobAck is the response to a queued job.
+type jobAck struct{}
+
+// RemoteDispatcher sends jobs to a pool of remote workers serving the worker
+// service, so that heavy builds don't run on the webhook frontend.
+type RemoteDispatcher struct {
+	conn *grpc.ClientConn
+}
+
+// NewRemoteDispatcher connects to the remote workers at target, a gRPC target
+// such as "dns:///workers.example.com:8080". secret authenticates the
+// dispatcher to the workers; jobs carry installation tokens, so it must be
+// set. With useTLS, the workers' certificates are verified against the system
+// roots.
+func NewRemoteDispatcher(target string, secret string, useTLS bool) (*RemoteDispatcher, error) {
+	creds := insecure.NewCredentials()
+	if useTLS {
+		creds = credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+	}
+	conn, err := grpc.Dial(target,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithPerRPCCredentials(workerSecret{secret: secret, requireTLS: useTLS}),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(jsonCodec{})),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to workers at %s: %s", target, err)
+	}
+	return &RemoteDispatcher{conn: conn}, nil
+}
+
+func (d *RemoteDispatcher) Dispatch(ctx context.Context, job *Job) error {
+	if err := d.conn.Invoke(ctx, runJobMethod, job, &jobAck{}); err != nil {
+		return fmt.Errorf("failed to dispatch job to %s: %s", d.conn.Target(), err)
+	}
+	return nil
+}
+
+// workerSecret sends the shared secret of the workers with every call.
+type workerSecret struct {
+	secret     string
+	requireTLS bool
+}
+
+func (s workerSecret) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + s.secret}, nil
+}
+
+func (s workerSecret) RequireTransportSecurity() bool { return s.requireTLS }
+
+// NewWorker returns an app that only runs jobs received from a
+// RemoteDispatcher. It doesn't have the GitHub app's credentials, as jobs
+// carry an installation token; see GithubApp.jobClient.
+func NewWorker(bbAPIKeys SecretProvider) *GithubApp {
+	return &GithubApp{
+		bbAPIKeys:  newCachingSecretProvider(bbAPIKeys, secretTTL),
+		tokens:     newTokenCache(),
+		inFlight:   newExpiringSet(inFlightTTL, 0),
+		running:    newRunningChecks(),
+		rateLimits: newRateLimiters(),
+	}
+}
+
+// NewWorkerServer returns a gRPC server of the worker service that accepts
+// jobs sent by a RemoteDispatcher with secret and runs them on workers
+// goroutines. Jobs are acknowledged once they are queued for a worker.
+func (app *GithubApp) NewWorkerServer(secret string, workers int, opt ...grpc.ServerOption) *grpc.Server {
+	dispatcher := NewInProcessDispatcher(app, workers)
+	runJob := func(_ interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		auth := md.Get("authorization")
+		if len(auth) != 1 || subtle.ConstantTimeCompare([]byte(auth[0]), []byte("Bearer "+secret)) != 1 {
+			return nil, status.Error(codes.Unauthenticated, "invalid worker secret")
+		}
+		job := &Job{}
+		if err := dec(job); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid job: %s", err)
+		}
+		if err := dispatcher.Dispatch(ctx, job); err != nil {
+			return nil, status.Error(codes.Unavailable, err.Error())
+		}
+		return &jobAck{}, nil
+	}
+	srv := grpc.NewServer(append(opt, grpc.ForceServerCodec(jsonCodec{}))...)
+	srv.RegisterService(&grpc.ServiceDesc{
+		ServiceName: workerService,
+		HandlerType: (*interface{})(nil),
+		Methods:     []grpc.MethodDesc{{MethodName: "RunJob", Handler: runJob}},
+	}, nil)
+	return srv
+}
@@ -0,0 +1,90 @@
+package app
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testWorkerSecret = "worker-secret"
+
+// startTestWorker serves a worker on a local port and returns it and its
+// address.
+func startTestWorker(t *testing.T) (*GithubApp, string) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	worker := NewWorker(StaticSecretProvider("bb-key"))
+	srv := worker.NewWorkerServer(testWorkerSecret, 1)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	return worker, lis.Addr().String()
+}
+
+func TestRemoteDispatcherRunsJobOnWorker(t *testing.T) {
+	f := newFakeGitHub(t)
+	tokens := make(chan string, 1)
+	f.handle("GET /repos/o/r/pulls/1/files", func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case tokens <- req.Header.Get("Authorization"):
+		default:
+		}
+		writeTestJSON(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+	})
+	worker, addr := startTestWorker(t)
+	d, err := NewRemoteDispatcher(addr, testWorkerSecret, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job := &Job{
+		InstallationID: testInstallationID,
+		FullRepoName:   "o/r",
+		HeadSHA:        "abc",
+		PullNumber:     1,
+		Checks:         []*JobCheck{{Name: buildifierCheck, CheckRunID: 1}},
+		Token:          "job-token",
+	}
+	if err := d.Dispatch(context.Background(), job); err != nil {
+		t.Fatalf("Dispatch: %s", err)
+	}
+	select {
+	case token := <-tokens:
+		if token != "token job-token" {
+			t.Errorf("worker authenticated with %q, want the job's token", token)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("worker didn't run the job")
+	}
+
+	// Wait for the job to finish before the fake GitHub goes away.
+	key := inFlightKey(job.InstallationID, job.HeadSHA, buildifierCheck)
+	for !worker.inFlight.Add(key) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	worker.inFlight.Remove(key)
+}
+
+func TestRemoteDispatcherWithWrongSecret(t *testing.T) {
+	_, addr := startTestWorker(t)
+	d, err := NewRemoteDispatcher(addr, "wrong-secret", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = d.Dispatch(context.Background(), &Job{FullRepoName: "o/r", HeadSHA: "abc"})
+	if err == nil || !strings.Contains(err.Error(), "Unauthenticated") {
+		t.Errorf("got error %v, want the worker to reject the job as unauthenticated", err)
+	}
+}
+
+func TestWorkerHasNoAppCredentials(t *testing.T) {
+	worker := NewWorker(StaticSecretProvider("bb-key"))
+	if _, err := worker.Token(context.Background(), testInstallationID); err == nil {
+		t.Errorf("worker created an installation token without the app's credentials")
+	}
+}
@@ -0,0 +1,183 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var (
+	// WorkspaceDir is where the checkouts of checks, fixes and cache warmups
+	// are created. It must not be shared with other processes, since
+	// CleanWorkspaces removes whatever they left behind.
+	WorkspaceDir = filepath.Join(os.TempDir(), "reviewbot")
+)
+
+// workspaceBudgetPoll is how often a workspace waiting for disk space checks
+// the usage again, in case space was freed by something else than a release.
+const workspaceBudgetPoll = 30 * time.Second
+
+// workspaceManager tracks the workspaces in use.
+type workspaceManager struct {
+	mu   sync.Mutex
+	dirs map[string]struct{}
+	// released is closed and replaced when a workspace is released, to wake
+	// up allocations waiting for disk space.
+	released chan struct{}
+}
+
+var workspaces = &workspaceManager{
+	dirs:     make(map[string]struct{}),
+	released: make(chan struct{}),
+}
+
+// allocWorkspace creates a new, empty directory for a checkout of sha of
+// fullRepoName used for name, e.g. the checks of a job. The directory is named
+// after the commit and name, with a random suffix so that concurrent runs
+// never share one, even for the same commit. It waits while WorkspaceBudget
+// is exceeded. The directory must be released with releaseWorkspace.
+func allocWorkspace(ctx context.Context, fullRepoName string, sha string, name string) (string, error) {
+	if err := waitForDiskSpace(ctx); err != nil {
+		return "", err
+	}
+	parent := filepath.Join(WorkspaceDir, filepath.FromSlash(fullRepoName))
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return "", fmt.Errorf("failed to create workspace: %s", err)
+	}
+	if len(sha) > 12 {
+		sha = sha[:12]
+	}
+	prefix := name + "-"
+	if sha != "" {
+		prefix = sha + "-" + prefix
+	}
+	dir, err := os.MkdirTemp(parent, prefix)
+	if err != nil {
+		return "", fmt.Errorf("failed to create workspace: %s", err)
+	}
+	workspaces.mu.Lock()
+	workspaces.dirs[dir] = struct{}{}
+	workspaces.mu.Unlock()
+	return dir, nil
+}
+
+// releaseWorkspace deletes a directory returned by allocWorkspace.
+func releaseWorkspace(ctx context.Context, dir string) {
+	if err := os.RemoveAll(dir); err != nil {
+		logFrom(ctx).Warnw("failed to cleanup dir", "dir", dir, "error", err)
+	}
+	workspaces.mu.Lock()
+	defer workspaces.mu.Unlock()
+	delete(workspaces.dirs, dir)
+	close(workspaces.released)
+	workspaces.released = make(chan struct{})
+}
+
+// waitForDiskSpace waits until the workspaces take up less than
+// WorkspaceBudget.
+func waitForDiskSpace(ctx context.Context) error {
+	logged := false
+	for CurrentSettings().WorkspaceBudget > 0 {
+		workspaces.mu.Lock()
+		released := workspaces.released
+		workspaces.mu.Unlock()
+		usage, err := dirSize(WorkspaceDir)
+		if err != nil {
+			return fmt.Errorf("failed to measure workspaces: %s", err)
+		}
+		if usage < CurrentSettings().WorkspaceBudget {
+			return nil
+		}
+		if !logged {
+			logFrom(ctx).Infow("waiting for workspace disk space", "usage", usage, "budget", CurrentSettings().WorkspaceBudget)
+			logged = true
+		}
+		select {
+		case <-released:
+		case <-time.After(workspaceBudgetPoll):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// dirSize returns the total size of the files under dir, or 0 if it doesn't
+// exist. Files removed while walking are skipped.
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
+
+// CleanWorkspaces removes the workspaces in WorkspaceDir that aren't in use,
+// such as those left behind by a previous process that crashed. It is meant
+// to run on startup.
+func CleanWorkspaces() error {
+	owners, err := os.ReadDir(WorkspaceDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	workspaces.mu.Lock()
+	defer workspaces.mu.Unlock()
+	removed := 0
+	for _, owner := range owners {
+		if !owner.IsDir() {
+			continue
+		}
+		repos, err := os.ReadDir(filepath.Join(WorkspaceDir, owner.Name()))
+		if err != nil {
+			return err
+		}
+		for _, repo := range repos {
+			if !repo.IsDir() {
+				continue
+			}
+			repoDir := filepath.Join(WorkspaceDir, owner.Name(), repo.Name())
+			entries, err := os.ReadDir(repoDir)
+			if err != nil {
+				return err
+			}
+			for _, e := range entries {
+				dir := filepath.Join(repoDir, e.Name())
+				if _, ok := workspaces.dirs[dir]; ok {
+					continue
+				}
+				if err := os.RemoveAll(dir); err != nil {
+					return err
+				}
+				removed++
+			}
+		}
+	}
+	if removed > 0 {
+		Logger.Infow("removed orphaned workspaces", "count", removed, "dir", WorkspaceDir)
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package app
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setWorkspaceDir points WorkspaceDir at a temporary directory and sets
+// WorkspaceBudget for the duration of the test.
+func setWorkspaceDir(t *testing.T, budget int64) string {
+	oldDir := WorkspaceDir
+	t.Cleanup(func() { WorkspaceDir = oldDir })
+	WorkspaceDir = t.TempDir()
+	setTestSettings(t, func(s *Settings) { s.WorkspaceBudget = budget })
+	return WorkspaceDir
+}
+
+func TestAllocWorkspace(t *testing.T) {
+	root := setWorkspaceDir(t, 0)
+	ctx := context.Background()
+	a, err := allocWorkspace(ctx, "o/r", "abc", "gofmt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := allocWorkspace(ctx, "o/r", "abc", "gofmt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a == b {
+		t.Errorf("concurrent workspaces share %s", a)
+	}
+	for _, dir := range []string{a, b} {
+		if filepath.Dir(dir) != filepath.Join(root, "o", "r") || !strings.HasPrefix(filepath.Base(dir), "abc-gofmt-") {
+			t.Errorf("workspace %s isn't a gofmt directory of abc of o/r in %s", dir, root)
+		}
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			t.Errorf("workspace %s wasn't created: %v", dir, err)
+		}
+	}
+
+	writeTestFile(t, a, "main.go", "package main\n")
+	releaseWorkspace(ctx, a)
+	releaseWorkspace(ctx, b)
+	for _, dir := range []string{a, b} {
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Errorf("released workspace %s still exists", dir)
+		}
+	}
+}
+
+func TestAllocWorkspaceNames(t *testing.T) {
+	setWorkspaceDir(t, 0)
+	ctx := context.Background()
+	for sha, prefix := range map[string]string{
+		"0123456789abcdef0123": "0123456789ab-gofmt-",
+		"":                     "gofmt-",
+	} {
+		dir, err := allocWorkspace(ctx, "o/r", sha, "gofmt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		releaseWorkspace(ctx, dir)
+		if !strings.HasPrefix(filepath.Base(dir), prefix) {
+			t.Errorf("workspace of %q is %s, want a name starting with %s", sha, filepath.Base(dir), prefix)
+		}
+	}
+}
+
+func TestAllocWorkspaceWaitsForBudget(t *testing.T) {
+	setWorkspaceDir(t, 10)
+	ctx := context.Background()
+	dir, err := allocWorkspace(ctx, "o/r", "abc", "gofmt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, dir, "big", strings.Repeat("x", 10))
+
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := allocWorkspace(timeout, "o/r", "abc", "gofmt"); err != context.DeadlineExceeded {
+		t.Fatalf("allocWorkspace over the budget returned %v, want it to wait until the context is done", err)
+	}
+
+	allocated := make(chan error, 1)
+	go func() {
+		dir, err := allocWorkspace(ctx, "o/r", "abc", "gofmt")
+		if err == nil {
+			releaseWorkspace(ctx, dir)
+		}
+		allocated <- err
+	}()
+	releaseWorkspace(ctx, dir)
+	select {
+	case err := <-allocated:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("allocWorkspace didn't return after a workspace was released")
+	}
+}
+
+func TestDirSize(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "a", "12345")
+	writeTestFile(t, dir, "sub/b", "123")
+	if err := os.Symlink("a", filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if size, err := dirSize(dir); err != nil || size != 8 {
+		t.Errorf("dirSize = %d, %v, want 8", size, err)
+	}
+	if size, err := dirSize(filepath.Join(dir, "missing")); err != nil || size != 0 {
+		t.Errorf("dirSize of a missing directory = %d, %v, want 0", size, err)
+	}
+}
+
+func TestCleanWorkspaces(t *testing.T) {
+	root := setWorkspaceDir(t, 0)
+	ctx := context.Background()
+	inUse, err := allocWorkspace(ctx, "o/r", "abc", "gofmt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer releaseWorkspace(ctx, inUse)
+	orphan := filepath.Join(root, "o", "r", "gofmt-1")
+	writeTestFile(t, orphan, "main.go", "package main\n")
+	otherOrphan := filepath.Join(root, "other", "repo", "buildifier-2")
+	writeTestFile(t, otherOrphan, "BUILD", "")
+
+	if err := CleanWorkspaces(); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{orphan, otherOrphan} {
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Errorf("orphaned workspace %s wasn't removed", dir)
+		}
+	}
+	if _, err := os.Stat(inUse); err != nil {
+		t.Errorf("workspace in use was removed: %s", err)
+	}
+}
+
+func TestCleanWorkspacesWithoutWorkspaceDir(t *testing.T) {
+	setWorkspaceDir(t, 0)
+	WorkspaceDir = filepath.Join(WorkspaceDir, "missing")
+	if err := CleanWorkspaces(); err != nil {
+		t.Errorf("CleanWorkspaces of a missing directory: %s", err)
+	}
+}
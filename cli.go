@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/luluz66/review_bot/app"
+)
+
+// conclusionExitCode maps a check conclusion to a process exit code, so that
+// checks can gate shell CI pipelines.
+func conclusionExitCode(conclusion string) int {
+	switch conclusion {
+	case "success", "neutral", "skipped":
+		return 0
+	}
+	return 1
+}
+
+// checkOutput is the result of a check as printed by `review_bot check
+// --format=json`.
+type checkOutput struct {
+	Check       string              `json:"check"`
+	Conclusion  string              `json:"conclusion,omitempty"`
+	Title       string              `json:"title,omitempty"`
+	Summary     string              `json:"summary,omitempty"`
+	Error       string              `json:"error,omitempty"`
+	Annotations []*annotationOutput `json:"annotations,omitempty"`
+}
+
+type annotationOutput struct {
+	Path     string `json:"path"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column,omitempty"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// runCheckCommand implements `review_bot check`, which runs checks against a
+// local working tree. By default it prints annotations to stderr as
+// `file:line: message`; with --format=json or --format=sarif it prints the
+// results as a single document to stdout instead.
+func runCheckCommand(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	dir := fs.String("dir", ".", "Working tree to check.")
+	checkNames := fs.String("check", "buildifier", "Comma-separated checks to run.")
+	bbAPIKey := fs.String("bb.api.key", "", "bb API Key")
+	exitCode := fs.Bool("exit_code", true, "Exit with a non-zero code when a check concludes with failure.")
+	format := fs.String("format", "text", "Output format: text, json or sarif.")
+	fs.Parse(args)
+	switch *format {
+	case "text", "json", "sarif":
+	default:
+		fmt.Fprintf(os.Stderr, "unknown --format %q, expected text, json or sarif\n", *format)
+		return 2
+	}
+
+	code := 0
+	var outputs []*checkOutput
+	results := make(map[string]*app.Result)
+	for _, checkName := range strings.Split(*checkNames, ",") {
+		result, err := app.RunLocalCheck(context.Background(), checkName, *dir, app.StaticSecretProvider(*bbAPIKey))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", checkName, err)
+			outputs = append(outputs, &checkOutput{Check: checkName, Error: err.Error()})
+			code = 2
+			continue
+		}
+		if c := conclusionExitCode(result.Conclusion); c > code {
+			code = c
+		}
+		results[checkName] = result
+		out := &checkOutput{
+			Check:      checkName,
+			Conclusion: result.Conclusion,
+			Title:      result.Title,
+			Summary:    result.Summary,
+		}
+		for _, a := range result.Annotations {
+			out.Annotations = append(out.Annotations, &annotationOutput{
+				Path:     a.Path,
+				Line:     a.Line,
+				Column:   a.Column,
+				Severity: a.Severity,
+				Message:  a.Message,
+			})
+		}
+		outputs = append(outputs, out)
+		if *format != "text" {
+			continue
+		}
+		fmt.Printf("%s: %s: %s\n", checkName, result.Conclusion, result.Summary)
+		for _, a := range result.Annotations {
+			fmt.Fprintf(os.Stderr, "%s:%d: %s\n", a.Path, a.Line, a.Message)
+		}
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	switch *format {
+	case "json":
+		enc.Encode(outputs)
+	case "sarif":
+		enc.Encode(app.ToSARIF(results))
+	}
+	if !*exitCode && code == 1 {
+		return 0
+	}
+	return code
+}
+
+// runReplayCommand implements `review_bot replay <file>...`, which sends
+// events captured with --capture_dir to the webhook handler of a running bot,
+// signed with its webhook secret. Each replay gets a new delivery ID, so that
+// it isn't skipped as a redelivery.
+func runReplayCommand(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	url := fs.String("url", "http://localhost:3000/event_handler", "Webhook handler to send the events to.")
+	secretRef := fs.String("webhook_secret", "", "Webhook secret of the bot, or a secret reference, to sign the events with.")
+	fs.Parse(args)
+	if fs.NArg() == 0 || *secretRef == "" {
+		fmt.Fprintln(os.Stderr, "usage: review_bot replay --webhook_secret=<secret> [--url=<url>] <captured event file>...")
+		return 2
+	}
+	secret, err := app.ResolveSecret(context.Background(), *secretRef)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	code := 0
+	for _, path := range fs.Args() {
+		if err := replayEvent(*url, secret, path); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+			code = 1
+			continue
+		}
+		fmt.Printf("%s: replayed\n", path)
+	}
+	return code
+}
+
+func replayEvent(url string, secret string, path string) error {
+	c, err := app.ReadCapturedEvent(path)
+	if err != nil {
+		return err
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(c.Payload)
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(c.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-GitHub-Event", c.Event)
+	req.Header.Set("X-GitHub-Delivery", fmt.Sprintf("%s-replay-%d", c.DeliveryID, time.Now().Unix()))
+	req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("status %d: %s", res.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
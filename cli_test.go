@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-github/v43/github"
+	"github.com/luluz66/review_bot/app"
+)
+
+// cliTestChecker reports a failure with an annotation for every local check
+// run.
+type cliTestChecker struct{}
+
+func (cliTestChecker) Name() string      { return "test-cli" }
+func (cliTestChecker) SupportsFix() bool { return false }
+
+func (cliTestChecker) Run(context.Context, *app.GithubApp, *app.CheckTarget) (*app.Result, error) {
+	return &app.Result{
+		Conclusion:  "failure",
+		Title:       "1 issue",
+		Summary:     "1 issue found",
+		Annotations: []*app.Annotation{{Path: "BUILD", Line: 2, Column: 5, Severity: "warning", Message: "unsorted"}},
+	}, nil
+}
+
+var registerCLITestChecker sync.Once
+
+// captureStdout returns what f prints to stdout.
+func captureStdout(t *testing.T, f func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr := os.Stdout, os.Stderr
+	os.Stdout = w
+	os.Stderr, _ = os.Open(os.DevNull)
+	defer func() { os.Stdout, os.Stderr = stdout, stderr }()
+	out := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		out <- string(b)
+	}()
+	f()
+	w.Close()
+	return <-out
+}
+
+func TestConclusionExitCode(t *testing.T) {
+	for conclusion, want := range map[string]int{
+		"success":         0,
+		"neutral":         0,
+		"skipped":         0,
+		"failure":         1,
+		"timed_out":       1,
+		"cancelled":       1,
+		"action_required": 1,
+	} {
+		if got := conclusionExitCode(conclusion); got != want {
+			t.Errorf("conclusionExitCode(%q) = %d, want %d", conclusion, got, want)
+		}
+	}
+}
+
+func TestRunCheckCommandUsageErrors(t *testing.T) {
+	stderr := os.Stderr
+	os.Stderr, _ = os.Open(os.DevNull)
+	defer func() { os.Stderr = stderr }()
+	for _, args := range [][]string{
+		{"--format=xml"},
+		{"--dir", t.TempDir(), "--check=no-such-check", "--format=json"},
+	} {
+		if got := runCheckCommand(args); got != 2 {
+			t.Errorf("runCheckCommand(%q) = %d, want 2", args, got)
+		}
+	}
+}
+
+func TestRunCheckCommandFormats(t *testing.T) {
+	registerCLITestChecker.Do(func() { app.RegisterChecker(cliTestChecker{}) })
+	dir := t.TempDir()
+
+	var code int
+	out := captureStdout(t, func() {
+		code = runCheckCommand([]string{"--dir", dir, "--check=test-cli,no-such-check", "--format=json"})
+	})
+	if code != 2 {
+		t.Errorf("got exit code %d, want 2 for the unknown check", code)
+	}
+	var outputs []*checkOutput
+	if err := json.Unmarshal([]byte(out), &outputs); err != nil {
+		t.Fatalf("got output %q: %s", out, err)
+	}
+	if len(outputs) != 2 || outputs[0].Conclusion != "failure" || len(outputs[0].Annotations) != 1 || outputs[0].Annotations[0].Column != 5 {
+		t.Errorf("got outputs %s, want test-cli's failure with its annotation", out)
+	}
+	if outputs[1].Check != "no-such-check" || outputs[1].Error == "" {
+		t.Errorf("got output %+v, want the unknown check's error", outputs[1])
+	}
+
+	out = captureStdout(t, func() {
+		code = runCheckCommand([]string{"--dir", dir, "--check=test-cli", "--format=sarif", "--exit_code=false"})
+	})
+	if code != 0 {
+		t.Errorf("got exit code %d with --exit_code=false, want 0", code)
+	}
+	log := &app.SARIFLog{}
+	if err := json.Unmarshal([]byte(out), log); err != nil {
+		t.Fatalf("got output %q: %s", out, err)
+	}
+	if len(log.Runs) != 1 || len(log.Runs[0].Results) != 1 || log.Runs[0].Results[0].Level != "warning" {
+		t.Errorf("got SARIF %s, want test-cli's warning", out)
+	}
+
+	out = captureStdout(t, func() {
+		code = runCheckCommand([]string{"--dir", dir, "--check=test-cli"})
+	})
+	if code != 1 || out != "test-cli: failure: 1 issue found\n" {
+		t.Errorf("got exit code %d and output %q, want the failure summarized", code, out)
+	}
+}
+
+func TestRunCheckCommandPrintsAnnotationsToStderr(t *testing.T) {
+	registerCLITestChecker.Do(func() { app.RegisterChecker(cliTestChecker{}) })
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr := os.Stdout, os.Stderr
+	os.Stdout, _ = os.Open(os.DevNull)
+	os.Stderr = w
+	code := runCheckCommand([]string{"--dir", t.TempDir(), "--check=test-cli"})
+	os.Stdout, os.Stderr = stdout, stderr
+	w.Close()
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != 1 || string(out) != "BUILD:2: unsorted\n" {
+		t.Errorf("got exit code %d and stderr %q, want 1 and the annotation as file:line: message", code, out)
+	}
+}
+
+func TestRunReplayCommand(t *testing.T) {
+	var deliveries []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		payload, err := github.ValidatePayload(req, []byte("secret"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if github.WebHookType(req) != "check_suite" || !strings.Contains(string(payload), `"head_sha":"abc"`) {
+			t.Errorf("got %s event %s, want the captured check suite", github.WebHookType(req), payload)
+		}
+		deliveries = append(deliveries, github.DeliveryID(req))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	captured := filepath.Join(dir, "captured.json")
+	b, err := json.Marshal(&app.CapturedEvent{Event: "check_suite", DeliveryID: "delivery-1", Payload: []byte(`{"check_suite":{"head_sha":"abc"}}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(captured, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_WEBHOOK_SECRET", "secret")
+
+	stdout := os.Stdout
+	os.Stdout, _ = os.Open(os.DevNull)
+	defer func() { os.Stdout = stdout }()
+	if got := runReplayCommand([]string{"--url=" + srv.URL, "--webhook_secret=env://TEST_WEBHOOK_SECRET", captured}); got != 0 {
+		t.Errorf("runReplayCommand = %d, want 0", got)
+	}
+	if len(deliveries) != 1 || !strings.HasPrefix(deliveries[0], "delivery-1-replay-") {
+		t.Errorf("got deliveries %q, want a new delivery ID for the replay", deliveries)
+	}
+
+	// A failed replay doesn't stop the others.
+	if got := runReplayCommand([]string{"--url=" + srv.URL, "--webhook_secret=wrong", captured}); got != 1 {
+		t.Errorf("runReplayCommand with the wrong secret = %d, want 1", got)
+	}
+	if got := runReplayCommand([]string{"--url=" + srv.URL, "--webhook_secret=secret", filepath.Join(dir, "missing.json"), captured}); got != 1 {
+		t.Errorf("runReplayCommand with a missing file = %d, want 1", got)
+	}
+	if len(deliveries) != 2 {
+		t.Errorf("got %d deliveries, want the captured event replayed after the missing one", len(deliveries))
+	}
+}
+
+func TestRunReplayCommandUsageErrors(t *testing.T) {
+	stderr := os.Stderr
+	os.Stderr, _ = os.Open(os.DevNull)
+	defer func() { os.Stderr = stderr }()
+	for _, args := range [][]string{
+		{"captured.json"},
+		{"--webhook_secret=secret"},
+		{"--webhook_secret=env://TEST_UNSET_SECRET", "captured.json"},
+	} {
+		if got := runReplayCommand(args); got != 2 {
+			t.Errorf("runReplayCommand(%q) = %d, want 2", args, got)
+		}
+	}
+}
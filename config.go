@@ -0,0 +1,144 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/luluz66/review_bot/app"
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix starts the environment variables overriding flags, e.g.
+// REVIEWBOT_GITHUB_APP_ID for --github.app.id.
+const envPrefix = "REVIEWBOT_"
+
+// envName returns the environment variable overriding the flag name.
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(name))
+}
+
+// flattenConfig turns the nested keys of a config file into flag names, so
+// that
+//
+//	github:
+//	  app:
+//	    id: 1234
+//
+// sets --github.app.id. Lists are joined with commas, like the values of
+// flags that take several.
+func flattenConfig(prefix string, v interface{}, values map[string]string) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			name := k
+			if prefix != "" {
+				name = prefix + "." + k
+			}
+			flattenConfig(name, child, values)
+		}
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			items = append(items, fmt.Sprint(item))
+		}
+		values[prefix] = strings.Join(items, ",")
+	case nil:
+		values[prefix] = ""
+	default:
+		values[prefix] = fmt.Sprint(v)
+	}
+}
+
+// readConfigFile returns the flag values set by the YAML file at path.
+func readConfigFile(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %s", path, err)
+	}
+	values := make(map[string]string)
+	flattenConfig("", doc, values)
+	return values, nil
+}
+
+// applyConfig sets the flags that weren't given on the command line from the
+// config file at path, if any, and then from the environment, which takes
+// precedence. Flags set by neither are reset to their defaults, so that
+// removing a setting from the file on reload takes effect.
+func applyConfig(path string, explicit map[string]bool) error {
+	values := make(map[string]string)
+	if path != "" {
+		var err error
+		if values, err = readConfigFile(path); err != nil {
+			return err
+		}
+	}
+	var unknown []string
+	for name := range values {
+		if flag.Lookup(name) == nil {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown settings in %s: %s", path, strings.Join(unknown, ", "))
+	}
+
+	var err error
+	flag.VisitAll(func(f *flag.Flag) {
+		if err != nil || explicit[f.Name] || f.Name == "config" {
+			return
+		}
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok {
+			value, ok = values[f.Name]
+		}
+		if !ok {
+			value = f.DefValue
+		}
+		if setErr := f.Value.Set(value); setErr != nil {
+			err = fmt.Errorf("invalid value %q for %s: %s", value, f.Name, setErr)
+		}
+	})
+	return err
+}
+
+// explicitFlags returns the names of the flags given on the command line,
+// which override the config file and the environment.
+func explicitFlags() map[string]bool {
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	return explicit
+}
+
+// reloadOnSIGHUP rereads the config file and the environment whenever the
+// process receives SIGHUP and applies the settings that can change at run
+// time. Credentials, listeners, workers and stores keep the values they were
+// started with.
+func reloadOnSIGHUP(path string, explicit map[string]bool) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	go func() {
+		for range c {
+			if err := applyConfig(path, explicit); err != nil {
+				app.Logger.Errorw("failed to reload config, keeping the current settings", "path", path, "error", err)
+				continue
+			}
+			if err := applySettings(); err != nil {
+				app.Logger.Errorw("invalid reloaded config, keeping the current settings", "path", path, "error", err)
+				continue
+			}
+			app.Logger.Infow("reloaded config", "path", path)
+		}
+	}()
+}
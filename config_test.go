@@ -0,0 +1,162 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/luluz66/review_bot/app"
+)
+
+// explicitTestFlags returns the flags of the test binary, e.g. -test.v, plus
+// names, as if given on the command line, so that applyConfig leaves them
+// alone.
+func explicitTestFlags(names ...string) map[string]bool {
+	explicit := make(map[string]bool)
+	flag.VisitAll(func(f *flag.Flag) {
+		if strings.HasPrefix(f.Name, "test.") {
+			explicit[f.Name] = true
+		}
+	})
+	for _, name := range names {
+		explicit[name] = true
+	}
+	return explicit
+}
+
+// resetFlags restores the defaults of the flags and the settings applied from
+// them after the test.
+func resetFlags(t *testing.T) {
+	t.Cleanup(func() {
+		if err := applyConfig("", explicitTestFlags()); err != nil {
+			t.Error(err)
+		}
+		if err := applySettings(); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestEnvName(t *testing.T) {
+	if got := envName("github.app.webhook_secret"); got != "REVIEWBOT_GITHUB_APP_WEBHOOK_SECRET" {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestFlattenConfig(t *testing.T) {
+	values := make(map[string]string)
+	flattenConfig("", map[string]interface{}{
+		"github": map[string]interface{}{
+			"app":         map[string]interface{}{"id": 1234},
+			"skip_labels": []interface{}{"skip-ci", "wip"},
+		},
+		"bb": map[string]interface{}{"api": map[string]interface{}{"key": nil}},
+	}, values)
+	want := map[string]string{
+		"github.app.id":      "1234",
+		"github.skip_labels": "skip-ci,wip",
+		"bb.api.key":         "",
+	}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("got %v, want %v", values, want)
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	resetFlags(t)
+	path := writeConfig(t, `
+github:
+  check_run_retries: 7
+  retries: 5
+  skip_labels: [skip-ci, wip]
+log:
+  max_output_lines: 10
+`)
+	t.Setenv("REVIEWBOT_GITHUB_RETRIES", "6")
+	*maxOutputLines = 20
+
+	if err := applyConfig(path, explicitTestFlags("log.max_output_lines")); err != nil {
+		t.Fatal(err)
+	}
+	if *checkRunRetries != 7 {
+		t.Errorf("got %d check run retries, want the config's 7", *checkRunRetries)
+	}
+	if *gitHubRetries != 6 {
+		t.Errorf("got %d retries, want the environment's 6 over the config's", *gitHubRetries)
+	}
+	if *maxOutputLines != 20 {
+		t.Errorf("got %d max output lines, want the command line's 20 over the config's", *maxOutputLines)
+	}
+	if err := applySettings(); err != nil {
+		t.Fatal(err)
+	}
+	if settings := app.CurrentSettings(); !reflect.DeepEqual(settings.SkipLabels, []string{"skip-ci", "wip"}) || settings.NewCheckRunAttempts != 7 {
+		t.Errorf("got skip labels %v and %d check run attempts, want the config's", settings.SkipLabels, settings.NewCheckRunAttempts)
+	}
+
+	// Removing a setting from the file restores its default on reload.
+	if err := os.WriteFile(path, []byte("github:\n  retries: 5\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyConfig(path, explicitTestFlags()); err != nil {
+		t.Fatal(err)
+	}
+	if *checkRunRetries != 4 || *skipLabels != "" {
+		t.Errorf("got %d check run retries and skip labels %q, want the defaults", *checkRunRetries, *skipLabels)
+	}
+}
+
+func TestApplyConfigWithInvalidSettings(t *testing.T) {
+	resetFlags(t)
+	for content, want := range map[string]string{
+		"github:\n  retrys: 5\n":     "unknown settings",
+		"github:\n  retries: many\n": "invalid value",
+		"github: [":                  "failed to parse",
+	} {
+		if err := applyConfig(writeConfig(t, content), explicitTestFlags()); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("applyConfig(%q) = %v, want an error containing %q", content, err, want)
+		}
+	}
+	if err := applyConfig(filepath.Join(t.TempDir(), "missing.yaml"), explicitTestFlags()); err == nil {
+		t.Errorf("applyConfig succeeded with a missing config file")
+	}
+}
+
+func TestApplySettingsKeepsCurrentOnInvalid(t *testing.T) {
+	resetFlags(t)
+	old := app.CurrentSettings()
+	*emailAuthors = true
+	*maxOutputLines = 10
+	if err := applySettings(); err == nil {
+		t.Errorf("applySettings accepted --email.authors without --email.smtp_addr")
+	}
+	if app.CurrentSettings() != old {
+		t.Errorf("invalid settings were put into effect")
+	}
+}
+
+func TestFlagsDefaultToDefaultSettings(t *testing.T) {
+	settings := app.DefaultSettings()
+	for name, want := range map[string]int{
+		"github.check_run_retries": settings.NewCheckRunAttempts,
+		"github.report_attempts":   settings.ReportAttempts,
+		"github.retries":           settings.GitHubRetries,
+		"log.max_output_lines":     settings.MaxOutputLines,
+	} {
+		if got := flag.Lookup(name).DefValue; got != strconv.Itoa(want) {
+			t.Errorf("--%s defaults to %s, want %d", name, got, want)
+		}
+	}
+}
@@ -1,50 +1,467 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"flag"
 	"fmt"
-	"log"
 	"net/http"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/luluz66/review_bot/app"
+	"google.golang.org/grpc"
+
+	_ "github.com/lib/pq"
 )
 
 var (
-	appID          = flag.Int64("github.app.id", -1, "GitHub app ID.")
-	privateKeyPath = flag.String("github.app.private_key_path", "", "A Path to GitHub app private key.")
-	webHookSecret  = flag.String("github.app.webhook_secret", "", "webhook secret")
-	bbAPIKey       = flag.String("bb.api.key", "", "bb API Key")
-	port           = flag.Int64("github.app.port", 3000, "port")
+	// defaults are the settings of the app before flags are applied.
+	defaults = app.DefaultSettings()
+
+	configPath         = flag.String("config", "", "YAML file with settings keyed by flag name, e.g. github: {app: {id: 1234}} for --github.app.id. Flags given on the command line and REVIEWBOT_* environment variables, e.g. REVIEWBOT_GITHUB_APP_ID, take precedence. Settings other than credentials, listeners and workers are reloaded on SIGHUP.")
+	appID              = flag.Int64("github.app.id", -1, "GitHub app ID.")
+	privateKeyPath     = flag.String("github.app.private_key_path", "", "A Path to GitHub app private key, or a secret reference like vault://secret/data/reviewbot#private_key.")
+	webHookSecret      = flag.String("github.app.webhook_secret", "", "Comma-separated webhook secrets or secret references, e.g. env://WEBHOOK_SECRET or awssm://reviewbot#webhook_secret. List the current secret first and the previous one after it while rotating.")
+	bbAPIKey           = flag.String("bb.api.key", "", "bb API Key, or a secret reference like gcpsm://projects/p/secrets/bb-api-key, which is reread every few minutes.")
+	bbKeyProvider      = flag.String("bb.api.key_provider", "", "Where to look up per-repo BuildBuddy API keys: \"env\" or \"file\". Defaults to using --bb.api.key for every repo.")
+	bbKeyDir           = flag.String("bb.api.key_dir", "", "Directory holding per-repo BuildBuddy API keys when --bb.api.key_provider=file.")
+	bbAPIURL           = flag.String("bb.api.url", defaults.BuildBuddyURL, "BuildBuddy API that bazel check results are enriched with invocation stats from. Empty disables it.")
+	bbPollTimeout      = flag.Duration("bb.api.poll_timeout", defaults.BuildBuddyPollTimeout, "How long to wait for BuildBuddy to process an invocation before reporting without its stats.")
+	cacheWarmup        = flag.Bool("bb.cache_warmup", defaults.CacheWarmup, "Build all targets of the default branch after each push to it to warm the remote cache. Needs the push event.")
+	cacheWarmupWorkers = flag.Int("bb.cache_warmup_workers", app.CacheWarmupWorkers, "Number of cache warmup builds run at the same time.")
+	cacheWarmupTimeout = flag.Duration("bb.cache_warmup_timeout", defaults.CacheWarmupTimeout, "Maximum duration of a cache warmup build.")
+	githubBaseURL      = flag.String("github.base_url", "", "API URL of a GitHub Enterprise Server instance, e.g. https://github.example.com/api/v3/. Empty uses github.com.")
+	githubUploadURL    = flag.String("github.upload_url", "", "Upload API URL of a GitHub Enterprise Server instance. Defaults to the one of --github.base_url.")
+	adminToken         = flag.String("admin.token", "", "Bearer token, or a secret reference, authenticating requests to the /admin API. Empty disables the API.")
+	dashboard          = flag.Bool("dashboard", false, "Serve a web UI of the check run history in --store.dsn under /dashboard/.")
+	dashboardPassword  = flag.String("dashboard.password", "", "Password, or a secret reference, required by the dashboard through HTTP basic auth. Empty serves it without authentication.")
+	captureDir         = flag.String("capture_dir", "", "Directory to archive the raw payload of every received webhook in, for review_bot replay. Empty disables capturing.")
+	port               = flag.Int64("github.app.port", 3000, "port")
+	checkRunRetries    = flag.Int("github.check_run_retries", defaults.NewCheckRunAttempts, "Attempts to update a newly created check run that GitHub reports as not found.")
+	reportAttempts     = flag.Int("github.report_attempts", defaults.ReportAttempts, "Attempts to report a check result on its check run. Results that still fail to be reported are kept in the store and reported on the next start.")
+	gitHubRetries      = flag.Int("github.retries", defaults.GitHubRetries, "Times a GitHub API request is retried after a rate limit or server error response.")
+	fixAuthorName      = flag.String("fix.author_name", defaults.FixAuthorName, "Author name of commits pushed by fix actions.")
+	fixAuthorEmail     = flag.String("fix.author_email", defaults.FixAuthorEmail, "Author email of commits pushed by fix actions.")
+	fixMessage         = flag.String("fix.message", defaults.FixMessage, "Template of the message of commits pushed by fix actions. {{check}} and {{pr}} are replaced with the check name and pull request number. Empty uses each check's default message.")
+	fixSignOff         = flag.Bool("fix.sign_off", defaults.FixSignOff, "Add a DCO Signed-off-by trailer to commits pushed by fix actions.")
+	fixGPGKeyPath      = flag.String("fix.gpg_key_path", "", "Path of an unencrypted armored OpenPGP private key to sign commits pushed by fix actions with.")
+	publicURL          = flag.String("server.public_url", "", "URL the bot is reachable at, e.g. https://reviewbot.example.com. Setting it serves the result of each check with its full log under /results/, which check runs and commit statuses without a URL of their own link to. Needs --store.dsn.")
+	resultsPassword    = flag.String("results.password", "", "Password, or a secret reference, required by the results pages through HTTP basic auth. Required with --server.public_url unless --results.public is set.")
+	resultsPublic      = flag.Bool("results.public", false, "Serve the results pages, which show the full logs of checks, without authentication.")
+	logInterval        = flag.Duration("results.log_interval", defaults.LogFlushInterval, "How often the output of running checks is saved to --store.dsn for the live logs on the results pages.")
+	progressInterval   = flag.Duration("bb.progress_interval", defaults.ProgressInterval, "How often the check runs of running bazel builds and tests are updated with their progress. 0 disables progress updates.")
+	cgroupRoot         = flag.String("limits.cgroup_root", "", "cgroup v2 directory, delegated to the bot with the cpu and memory controllers enabled, to create a cgroup per check in to enforce CPU and memory limits of checks run on the host. Empty leaves those limits unenforced on the host.")
+	limitCPUs          = flag.Float64("limits.cpus", 0, "CPUs available to the commands of each check. Repositories can lower it in .reviewbot.yaml. 0 means no limit.")
+	limitMemory        = flag.String("limits.memory", "", "Memory available to the commands of each check, e.g. 8g. Commands exceeding it are killed and reported in the check summary. Repositories can lower it. Empty means no limit.")
+	limitNice          = flag.Int("limits.nice", 0, "Niceness the commands of checks run on the host with, from 0 to 19.")
+	limitJobs          = flag.Int("limits.jobs", 0, "Value of --jobs passed to bazel by checks. Repositories can lower it. 0 leaves it to bazel.")
+	resultCacheTTL     = flag.Duration("cache.result_ttl", defaults.ResultCacheTTL, "How long the results of checks that didn't fail are reused for commits with the same tree, e.g. re-requested suites and force-pushes without changes, instead of running the checks again. Needs --store.dsn. 0 disables the cache.")
+	bazelWorkspaceDir  = flag.String("bb.workspace_dir", "", "Directory for persistent checkouts, each with its own bazel output base, that jobs with bazel checks update in place instead of cloning into --workspace.dir, so that bazel servers and their caches survive between runs. Not supported with --sandbox.runtime. Empty disables them.")
+	bazelBudget        = flag.Int64("bb.workspace_budget_mb", 0, "Disk space in MiB that the checkouts in --bb.workspace_dir may take up in total. The least recently used ones are removed while it is exceeded. 0 is unlimited.")
+	expungeInterval    = flag.Duration("bb.expunge_interval", defaults.BazelExpungeInterval, "How often the output bases of the checkouts in --bb.workspace_dir are cleaned with bazel clean --expunge. 0 never expunges them.")
+	toolsDir           = flag.String("tools.dir", app.ToolsDir, "Directory downloaded tools are kept in, by name and version.")
+	buildtoolsVersion  = flag.String("buildtools.version", "", "Release of buildifier and buildozer to download for repositories that don't pin one with the version of their buildifier check, e.g. v7.1.2. Empty runs the ones on PATH.")
+	buildtoolsURL      = flag.String("buildtools.url", defaults.BuildtoolsURL, "Where buildifier and buildozer are downloaded from. {version}, {tool}, {os} and {arch} are replaced with the release, the tool and the host platform.")
+	bazeliskVersion    = flag.String("bazelisk.version", "", "Release of bazelisk to download and run bazel with instead of bb, e.g. v1.19.0, so that checks use the bazel version of the repository's .bazelversion. Empty runs bb, or bazel with --bazel.buildbuddy=false, from PATH.")
+	bazeliskURL        = flag.String("bazelisk.url", defaults.BazeliskURL, "Where bazelisk is downloaded from, like --buildtools.url.")
+	useBuildBuddy      = flag.Bool("bazel.buildbuddy", app.UseBuildBuddy, "Run bazel checks with bb, authenticated with the BuildBuddy API key of the repo. When false, they run plain bazel with --bazel.flags and don't need an API key.")
+	bazelFlags         = flag.String("bazel.flags", "", "Space-separated flags passed to every bazel build and test of checks, e.g. to set up a remote cache without BuildBuddy.")
+	workspaceDir       = flag.String("workspace.dir", app.WorkspaceDir, "Directory checkouts are created in. Leftovers of previous runs are removed on startup, so it must not be shared with other processes.")
+	workspaceBudget    = flag.Int64("workspace.budget_mb", 0, "Disk space in MiB that checkouts may take up in total. New checkouts wait while it is exceeded. 0 is unlimited.")
+	repoCacheDir       = flag.String("git.cache_dir", "", "Directory for persistent bare mirrors of checked repositories. Clones are made from the mirror after fetching only new objects. Empty disables the cache.")
+	fetchLFS           = flag.Bool("git.lfs", true, "Fetch Git LFS objects after cloning repositories that use LFS.")
+	eventWorkers       = flag.Int("event_workers", app.EventWorkers, "Number of background workers processing webhook events. 0 processes events before acknowledging the webhook.")
+	eventQueueSize     = flag.Int("event_queue_size", app.EventQueueSize, "Number of webhook events that can wait for a worker.")
+	concurrentChecks   = flag.Bool("concurrent_checks", defaults.ConcurrentChecks, "Run all checks for a commit concurrently against a single clone.")
+	checkTimeout       = flag.Duration("check_timeout", defaults.CheckTimeout, "Maximum duration of a check before it is killed and marked timed out.")
+	workers            = flag.Int("workers", 0, "Number of in-process workers running checks in the background. 0 runs checks inline while handling the webhook.")
+	maxOutputLines     = flag.Int("log.max_output_lines", defaults.MaxOutputLines, "Maximum number of lines of command output to retain; the middle of longer output is omitted.")
+	customChecks       = flag.String("checks.custom", "", "YAML file listing custom checks under checks, each with a name, a command printing file:line:col: message findings, and optionally a report_file, relative to the checkout, that the command writes them to instead.")
+	storeDriver        = flag.String("store.driver", "postgres", "database/sql driver of the check run store.")
+	storeDSN           = flag.String("store.dsn", "", "Data source name of the database recording check runs. Empty disables recording.")
+	sandboxRuntime     = flag.String("sandbox.runtime", "", "Container runtime, \"docker\" or \"podman\", to run check tools with. Empty runs them on the host.")
+	sandboxImage       = flag.String("sandbox.image", "", "Container image containing the tools of the enabled checks.")
+	sandboxCPUs        = flag.String("sandbox.cpus", "", "CPU limit of check containers. Empty means no limit.")
+	sandboxMemory      = flag.String("sandbox.memory", "", "Memory limit of check containers, e.g. 8g. Empty means no limit.")
+	sandboxNetwork     = flag.String("sandbox.network", "none", "Network check containers are attached to. Use a network that only reaches the remote cache.")
+	perInstallation    = flag.Int("workers.per_installation", defaults.InstallationConcurrency, "Maximum number of jobs of one installation that run at the same time on --workers. Installations with waiting jobs take turns. 0 is unlimited.")
+	installationQueue  = flag.Int("workers.installation_queue_size", defaults.InstallationQueueSize, "Number of jobs of one installation that can wait for --workers before dispatching more of them blocks.")
+	workerServe        = flag.Bool("worker.serve", false, "Run as a remote worker serving the gRPC worker service on --port, over TLS with --tls.cert, to run jobs sent by a frontend with --worker.target, instead of handling webhooks.")
+	workerTarget       = flag.String("worker.target", "", "gRPC target of the remote workers to send checks to, e.g. dns:///workers.example.com:8080. Empty runs checks in this process.")
+	workerTLS          = flag.Bool("worker.tls", false, "Connect to --worker.target over TLS.")
+	workerSecret       = flag.String("worker.secret", "", "Shared secret authenticating the frontend to remote workers.")
+	summaryComment     = flag.Bool("github.summary_comment", defaults.SummaryComment, "Post a pull request comment summarizing all check results, updated in place.")
+	skipDrafts         = flag.Bool("github.skip_drafts", defaults.SkipDrafts, "Skip checks on draft pull requests until they are marked ready for review.")
+	skipLabels         = flag.String("github.skip_labels", "", "Comma-separated pull request labels, e.g. skip-ci, that skip checks until they are removed.")
+	aggregateCheck     = flag.Bool("github.aggregate_check", defaults.AggregateCheck, "Create a \"reviewbot\" check run rolling up the results of all checks, to use as a single required status.")
+	brokenBranch       = flag.Bool("github.broken_branch_issue", defaults.BrokenBranchIssue, "Open an issue labeled broken-default-branch when checks fail on the head of the default branch, with the commits since it last passed, and close it once they pass again.")
+	codeScanning       = flag.Bool("github.code_scanning", defaults.CodeScanning, "Upload check annotations as SARIF to GitHub code scanning, so that findings show up in the Security tab. Needs the security events write permission.")
+	notifyWebhooks     = flag.String("notify.webhooks", "", "Comma-separated scope=url Slack or Discord incoming webhooks that check failures are posted to, where scope is an owner, an owner/repo repository or * for all, and url may be a secret reference.")
+	notifyAll          = flag.Bool("notify.all", defaults.NotifyAll, "Post every check outcome to --notify.webhooks, not only failures.")
+	notifyTemplate     = flag.String("notify.template", defaults.NotifyTemplate, "Template of the messages posted to --notify.webhooks. {{repo}}, {{check}}, {{conclusion}}, {{title}}, {{url}}, {{link}} and {{annotations}} are replaced with the outcome.")
+	emailAuthors       = flag.Bool("email.authors", defaults.EmailAuthors, "Email the author of a commit pushed to a protected branch when it breaks checks that passed on its parent. Needs --email.smtp_addr.")
+	smtpAddr           = flag.String("email.smtp_addr", "", "host:port of the SMTP server that emails are sent through.")
+	smtpUsername       = flag.String("email.smtp_username", "", "Username authenticating with the SMTP server. Empty doesn't authenticate.")
+	smtpPassword       = flag.String("email.smtp_password", "", "Password of --email.smtp_username, or a secret reference to it.")
+	smtpFrom           = flag.String("email.from", "", "Sender of emails, e.g. reviewbot@example.com.")
+	emailInterval      = flag.Duration("email.interval", defaults.EmailInterval, "Least time between two emails to the same author.")
+	allowList          = flag.String("github.allow", "", "Comma-separated installation IDs, owners and owner/repo repositories to act on. Events from anything else are ignored. Empty allows all.")
+	denyList           = flag.String("github.deny", "", "Comma-separated installation IDs, owners and owner/repo repositories whose events are ignored, even if --github.allow lists them.")
+	gitlabURL          = flag.String("gitlab.url", "https://gitlab.com", "URL of the GitLab instance to run checks for.")
+	gitlabToken        = flag.String("gitlab.token", "", "GitLab access token, or a secret reference to one, with the api scope and the Developer role. Setting it runs checks for the GitLab merge requests whose webhooks are sent to /gitlab/event_handler.")
+	gitlabSecret       = flag.String("gitlab.webhook_secret", "", "Secret token of the GitLab webhooks, or a secret reference to it.")
+	bitbucketURL       = flag.String("bitbucket.url", "https://bitbucket.org", "URL of Bitbucket Cloud, which repositories are cloned from.")
+	bitbucketAPIURL    = flag.String("bitbucket.api_url", "https://api.bitbucket.org/2.0", "URL of the Bitbucket API.")
+	bitbucketToken     = flag.String("bitbucket.token", "", "Bitbucket access token, or a secret reference to one, with the pull request write and repository admin scopes. Setting it runs checks for the Bitbucket pull requests whose webhooks are sent to /bitbucket/event_handler.")
+	bitbucketSecret    = flag.String("bitbucket.webhook_secret", "", "Secret of the Bitbucket webhooks, or a secret reference to it.")
+	gerritURL          = flag.String("gerrit.url", "", "URL of the Gerrit server to run checks for. Patchsets are found by polling and from the stream events the webhooks plugin sends to /gerrit/event_handler.")
+	gerritUser         = flag.String("gerrit.user", "", "Username of the Gerrit account that votes on the Verified label.")
+	gerritPassword     = flag.String("gerrit.password", "", "HTTP password of the Gerrit account, or a secret reference to it.")
+	gerritSecret       = flag.String("gerrit.webhook_secret", "", "Secret the Gerrit webhook sends in its Authorization header, as a bearer token or basic auth password, or a secret reference to it. Empty disables the webhook.")
+	gerritQuery        = flag.String("gerrit.query", "status:open -is:wip", "Query of the Gerrit changes to check when polling.")
+	gerritPoll         = flag.Duration("gerrit.poll_interval", time.Minute, "How often Gerrit is polled for new patchsets. 0 disables polling.")
+	stripANSI          = flag.Bool("output.strip_ansi", true, "Strip ANSI escape codes from tool output before parsing it.")
+	tlsCert            = flag.String("tls.cert", "", "PEM certificate file to serve HTTPS with. Requires --tls.key.")
+	tlsKey             = flag.String("tls.key", "", "PEM private key file of --tls.cert.")
+	tlsHostname        = flag.String("tls.hostname", "", "Hostname to obtain certificates for from Let's Encrypt, instead of --tls.cert. The server must be reachable on port 443 of the hostname.")
+	tlsCacheDir        = flag.String("tls.cache_dir", "autocert", "Directory caching the certificates obtained for --tls.hostname.")
+	readTimeout        = flag.Duration("server.read_timeout", time.Minute, "Maximum duration of reading a request, including its body.")
+	writeTimeout       = flag.Duration("server.write_timeout", 10*time.Minute, "Maximum duration of handling a request. Raise it above --check_timeout if checks run inline while handling webhooks.")
+	idleTimeout        = flag.Duration("server.idle_timeout", 2*time.Minute, "Maximum time an idle keep-alive connection is kept open.")
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		os.Exit(runCheckCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Exit(runReplayCommand(os.Args[2:]))
+	}
 	flag.Parse()
+	explicit := explicitFlags()
+	if err := applyConfig(*configPath, explicit); err != nil {
+		app.Logger.Fatal(err)
+	}
+	if err := applySettings(); err != nil {
+		app.Logger.Fatal(err)
+	}
+	if *fixGPGKeyPath != "" {
+		key, err := app.LoadSigningKey(*fixGPGKeyPath)
+		if err != nil {
+			app.Logger.Fatal(err)
+		}
+		app.FixSigningKey = key
+	}
+	if *customChecks != "" {
+		if err := app.LoadCustomChecks(*customChecks); err != nil {
+			app.Logger.Fatal(err)
+		}
+	}
+	app.EventWorkers = *eventWorkers
+	app.EventQueueSize = *eventQueueSize
+	app.RepoCacheDir = *repoCacheDir
+	app.CacheWarmupWorkers = *cacheWarmupWorkers
+	app.FetchLFS = *fetchLFS
+	app.CaptureDir = *captureDir
+	app.WorkspaceDir = *workspaceDir
+	app.PublicURL = *publicURL
+	app.CgroupRoot = *cgroupRoot
+	app.BazelWorkspaceDir = *bazelWorkspaceDir
+	app.ToolsDir = *toolsDir
+	app.UseBuildBuddy = *useBuildBuddy
+	if err := app.SetGitHubURLs(*githubBaseURL, *githubUploadURL); err != nil {
+		app.Logger.Fatal(err)
+	}
+	if err := app.CleanWorkspaces(); err != nil {
+		app.Logger.Warnw("failed to clean up workspaces", "error", err)
+	}
+	if *sandboxRuntime != "" && *bazelWorkspaceDir != "" {
+		// Containers only mount the checkout, not its output base.
+		app.Logger.Fatal("--bb.workspace_dir isn't supported with --sandbox.runtime")
+	}
+	if *sandboxRuntime != "" {
+		app.CheckExecutor = &app.ContainerExecutor{
+			Runtime: *sandboxRuntime,
+			Image:   *sandboxImage,
+			CPUs:    *sandboxCPUs,
+			Memory:  *sandboxMemory,
+			Network: *sandboxNetwork,
+		}
+	}
+	var bbAPIKeys app.SecretProvider
+	switch *bbKeyProvider {
+	case "":
+		bbAPIKeys = app.StaticSecretProvider(*bbAPIKey)
+		if app.IsSecretRef(*bbAPIKey) {
+			bbAPIKeys = &app.RefSecretProvider{Ref: *bbAPIKey}
+		}
+	case "env":
+		bbAPIKeys = &app.EnvSecretProvider{Prefix: "BB_API_KEY"}
+	case "file":
+		if *bbKeyDir == "" {
+			app.Logger.Fatal("require --bb.api.key_dir with --bb.api.key_provider=file")
+		}
+		bbAPIKeys = &app.FileSecretProvider{Dir: *bbKeyDir}
+	default:
+		app.Logger.Fatalf("unknown --bb.api.key_provider %q", *bbKeyProvider)
+	}
+	addr := fmt.Sprintf("0.0.0.0:%d", *port)
+	mux := http.NewServeMux()
+
+	if *workerServe {
+		if *workerSecret == "" {
+			app.Logger.Fatal("require --worker.secret with --worker.serve")
+		}
+		worker := app.NewWorker(bbAPIKeys)
+		setStore(worker)
+		n := *workers
+		if n <= 0 {
+			n = 1
+		}
+		app.Logger.Info("Running as a worker")
+		if *configPath != "" {
+			reloadOnSIGHUP(*configPath, explicit)
+		}
+		if err := serveGRPC(addr, func(opt ...grpc.ServerOption) *grpc.Server {
+			return worker.NewWorkerServer(*workerSecret, n, opt...)
+		}); err != nil {
+			app.Logger.Fatal(err)
+		}
+		return
+	}
+
 	if appID == nil || *appID == -1 {
-		log.Fatal("require --github.app.id")
+		app.Logger.Fatal("require --github.app.id")
 	}
 	if privateKeyPath == nil || *privateKeyPath == "" {
-		log.Fatal("require --github.app.private_key_path")
+		app.Logger.Fatal("require --github.app.private_key_path")
 	}
 	if webHookSecret == nil || *webHookSecret == "" {
-		log.Fatal("require --github.app.webhook_secret")
+		app.Logger.Fatal("require --github.app.webhook_secret")
+	}
+	ctx := context.Background()
+	privateKeyRef := *privateKeyPath
+	if !app.IsSecretRef(privateKeyRef) {
+		privateKeyRef = "file://" + privateKeyRef
 	}
-	ghApp, err := app.NewGithubApp(*appID, *privateKeyPath, *webHookSecret, *bbAPIKey)
+	privateKey, err := app.ResolveSecret(ctx, privateKeyRef)
+	if err != nil {
+		app.Logger.Fatalf("failed to read private key: %s", err)
+	}
+	var webhookSecrets []string
+	for _, ref := range strings.Split(*webHookSecret, ",") {
+		secret, err := app.ResolveSecret(ctx, ref)
+		if err != nil {
+			app.Logger.Fatalf("failed to read webhook secret: %s", err)
+		}
+		webhookSecrets = append(webhookSecrets, secret)
+	}
+	ghApp, err := app.NewGithubApp(*appID, []byte(privateKey), webhookSecrets, bbAPIKeys)
 
 	if err != nil {
-		log.Fatalf("failed to create github app: %s", err)
+		app.Logger.Fatalf("failed to create github app: %s", err)
+	}
+	setStore(ghApp)
+	switch {
+	case *workerTarget != "":
+		if *workerSecret == "" {
+			app.Logger.Fatal("require --worker.secret with --worker.target")
+		}
+		dispatcher, err := app.NewRemoteDispatcher(*workerTarget, *workerSecret, *workerTLS)
+		if err != nil {
+			app.Logger.Fatal(err)
+		}
+		ghApp.SetDispatcher(dispatcher)
+	case *workers > 0:
+		ghApp.SetDispatcher(app.NewInProcessDispatcher(ghApp, *workers))
 	}
+	go func() {
+		if err := ghApp.RecoverCheckRuns(context.Background()); err != nil {
+			app.Logger.Errorw("failed to recover interrupted check runs", "error", err)
+		}
+	}()
 
-	addr := fmt.Sprintf("0.0.0.0:%d", *port)
-	log.Printf("Listening on http://%s", addr)
-	mux := http.NewServeMux()
 	handle(mux, "/event_handler", ghApp.HandleWebhook)
-	http.ListenAndServe(addr, mux)
+	if *dashboard {
+		password, err := app.ResolveSecret(ctx, *dashboardPassword)
+		if err != nil {
+			app.Logger.Fatalf("failed to read dashboard password: %s", err)
+		}
+		handle(mux, "/dashboard/", ghApp.DashboardHandler(password))
+	}
+	if *publicURL != "" {
+		password, err := app.ResolveSecret(ctx, *resultsPassword)
+		if err != nil {
+			app.Logger.Fatalf("failed to read results password: %s", err)
+		}
+		if password == "" && !*resultsPublic {
+			app.Logger.Fatal("require --results.password or --results.public with --server.public_url")
+		}
+		handle(mux, "/results/", ghApp.ResultsHandler(password))
+	}
+	if *adminToken != "" {
+		token, err := app.ResolveSecret(ctx, *adminToken)
+		if err != nil {
+			app.Logger.Fatalf("failed to read admin token: %s", err)
+		}
+		handle(mux, "/admin/", ghApp.AdminHandler(token))
+	}
+	if *gitlabToken != "" {
+		token, err := app.ResolveSecret(ctx, *gitlabToken)
+		if err != nil {
+			app.Logger.Fatalf("failed to read GitLab token: %s", err)
+		}
+		secret, err := app.ResolveSecret(ctx, *gitlabSecret)
+		if err != nil {
+			app.Logger.Fatalf("failed to read GitLab webhook secret: %s", err)
+		}
+		if secret == "" {
+			app.Logger.Fatal("require --gitlab.webhook_secret with --gitlab.token")
+		}
+		gitlab := &app.GitLab{URL: *gitlabURL, Token: token, WebhookSecret: secret}
+		handle(mux, "/gitlab/event_handler", app.NewProviderApp(gitlab, bbAPIKeys).HandleWebhook)
+	}
+	if *bitbucketToken != "" {
+		token, err := app.ResolveSecret(ctx, *bitbucketToken)
+		if err != nil {
+			app.Logger.Fatalf("failed to read Bitbucket token: %s", err)
+		}
+		secret, err := app.ResolveSecret(ctx, *bitbucketSecret)
+		if err != nil {
+			app.Logger.Fatalf("failed to read Bitbucket webhook secret: %s", err)
+		}
+		if secret == "" {
+			app.Logger.Fatal("require --bitbucket.webhook_secret with --bitbucket.token")
+		}
+		bitbucket := &app.Bitbucket{URL: *bitbucketURL, APIURL: *bitbucketAPIURL, Token: token, WebhookSecret: secret}
+		handle(mux, "/bitbucket/event_handler", app.NewProviderApp(bitbucket, bbAPIKeys).HandleWebhook)
+	}
+	if *gerritURL != "" {
+		password, err := app.ResolveSecret(ctx, *gerritPassword)
+		if err != nil {
+			app.Logger.Fatalf("failed to read Gerrit password: %s", err)
+		}
+		secret, err := app.ResolveSecret(ctx, *gerritSecret)
+		if err != nil {
+			app.Logger.Fatalf("failed to read Gerrit webhook secret: %s", err)
+		}
+		if secret == "" && *gerritPoll == 0 {
+			app.Logger.Fatal("require --gerrit.webhook_secret or --gerrit.poll_interval with --gerrit.url")
+		}
+		gerrit := &app.Gerrit{URL: *gerritURL, Username: *gerritUser, Password: password, WebhookSecret: secret, Query: *gerritQuery}
+		gerritApp := app.NewProviderApp(gerrit, bbAPIKeys)
+		if secret != "" {
+			handle(mux, "/gerrit/event_handler", gerritApp.HandleWebhook)
+		}
+		if *gerritPoll > 0 {
+			go gerritApp.Poll(context.Background(), *gerritPoll)
+		}
+	}
+	if *configPath != "" {
+		reloadOnSIGHUP(*configPath, explicit)
+	}
+	if err := serve(addr, mux); err != nil {
+		app.Logger.Fatal(err)
+	}
+}
+
+// applySettings puts the app's settings that can change at run time into
+// effect from the flags, if they are valid. It is called again when the config
+// is reloaded.
+func applySettings() error {
+	settings, err := settingsFromFlags()
+	if err != nil {
+		return err
+	}
+	return app.SetSettings(settings)
+}
+
+// settingsFromFlags returns the app's settings that can change at run time
+// as set by the flags.
+func settingsFromFlags() (*app.Settings, error) {
+	webhooks, err := app.ParseNotifyWebhooks(*notifyWebhooks)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --notify.webhooks: %s", err)
+	}
+	s := &app.Settings{
+		MaxOutputLines:          *maxOutputLines,
+		NewCheckRunAttempts:     *checkRunRetries,
+		ReportAttempts:          *reportAttempts,
+		StripANSI:               *stripANSI,
+		FixAuthorName:           *fixAuthorName,
+		FixAuthorEmail:          *fixAuthorEmail,
+		FixMessage:              *fixMessage,
+		FixSignOff:              *fixSignOff,
+		ConcurrentChecks:        *concurrentChecks,
+		CheckTimeout:            *checkTimeout,
+		GitHubRetries:           *gitHubRetries,
+		SummaryComment:          *summaryComment,
+		SkipDrafts:              *skipDrafts,
+		AggregateCheck:          *aggregateCheck,
+		BrokenBranchIssue:       *brokenBranch,
+		NotifyWebhooks:          webhooks,
+		NotifyAll:               *notifyAll,
+		EmailAuthors:            *emailAuthors,
+		SMTPAddr:                *smtpAddr,
+		SMTPUsername:            *smtpUsername,
+		SMTPPassword:            *smtpPassword,
+		SMTPFrom:                *smtpFrom,
+		EmailInterval:           *emailInterval,
+		NotifyTemplate:          *notifyTemplate,
+		CodeScanning:            *codeScanning,
+		LogFlushInterval:        *logInterval,
+		ProgressInterval:        *progressInterval,
+		ResultCacheTTL:          *resultCacheTTL,
+		BazelWorkspaceBudget:    *bazelBudget << 20,
+		BazelExpungeInterval:    *expungeInterval,
+		BuildtoolsVersion:       *buildtoolsVersion,
+		BuildtoolsURL:           *buildtoolsURL,
+		BazeliskVersion:         *bazeliskVersion,
+		BazeliskURL:             *bazeliskURL,
+		BazelFlags:              strings.Fields(*bazelFlags),
+		InstallationConcurrency: *perInstallation,
+		InstallationQueueSize:   *installationQueue,
+		WorkspaceBudget:         *workspaceBudget << 20,
+		BuildBuddyURL:           *bbAPIURL,
+		BuildBuddyPollTimeout:   *bbPollTimeout,
+		CacheWarmup:             *cacheWarmup,
+		CacheWarmupTimeout:      *cacheWarmupTimeout,
+		DefaultLimits:           app.ResourceLimits{CPUs: *limitCPUs, Memory: *limitMemory, Nice: *limitNice, Jobs: *limitJobs},
+	}
+	if *skipLabels != "" {
+		s.SkipLabels = strings.Split(*skipLabels, ",")
+	}
+	if *allowList != "" {
+		s.AllowList = strings.Split(*allowList, ",")
+	}
+	if *denyList != "" {
+		s.DenyList = strings.Split(*denyList, ",")
+	}
+	return s, nil
+}
+
+// setStore configures the check run store of a, if one is configured.
+func setStore(a *app.GithubApp) {
+	if *storeDSN == "" {
+		return
+	}
+	db, err := sql.Open(*storeDriver, *storeDSN)
+	if err != nil {
+		app.Logger.Fatalf("failed to open store: %s", err)
+	}
+	store, err := app.NewSQLStore(context.Background(), db)
+	if err != nil {
+		app.Logger.Fatalf("failed to create store: %s", err)
+	}
+	a.SetStore(store)
 }
 
 func handle(mux *http.ServeMux, pattern string, handleFunc http.HandlerFunc) {
 	mux.HandleFunc(pattern, func(w http.ResponseWriter, req *http.Request) {
-		log.Printf("%s %s", req.Method, req.URL)
+		// Not the query, which can hold credentials.
+		app.Logger.Infow("request", "method", req.Method, "path", req.URL.Path)
 		handleFunc(w, req)
 	})
 	if !strings.HasSuffix(pattern, "/") {
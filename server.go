@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/luluz66/review_bot/app"
+	"golang.org/x/crypto/acme/autocert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// serve serves handler on addr, over HTTPS if TLS is configured. HTTP/2 is
+// negotiated on TLS connections.
+func serve(addr string, handler http.Handler) error {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       *readTimeout,
+		WriteTimeout:      *writeTimeout,
+		IdleTimeout:       *idleTimeout,
+	}
+	switch {
+	case *tlsHostname != "":
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(*tlsHostname),
+			Cache:      autocert.DirCache(*tlsCacheDir),
+		}
+		// The manager answers TLS-ALPN challenges, so no separate HTTP
+		// listener is needed.
+		srv.TLSConfig = m.TLSConfig()
+		app.Logger.Infof("Listening on https://%s for %s", addr, *tlsHostname)
+		return srv.ListenAndServeTLS("", "")
+	case *tlsCert != "" || *tlsKey != "":
+		if *tlsCert == "" || *tlsKey == "" {
+			app.Logger.Fatal("require both --tls.cert and --tls.key")
+		}
+		srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		app.Logger.Infof("Listening on https://%s", addr)
+		return srv.ListenAndServeTLS(*tlsCert, *tlsKey)
+	}
+	app.Logger.Infof("Listening on http://%s", addr)
+	return srv.ListenAndServe()
+}
+
+// serveGRPC serves the gRPC server returned by newServer on addr, over TLS if
+// --tls.cert is set. Let's Encrypt certificates aren't supported, since
+// obtaining them needs an HTTPS listener.
+func serveGRPC(addr string, newServer func(opt ...grpc.ServerOption) *grpc.Server) error {
+	var opts []grpc.ServerOption
+	switch {
+	case *tlsHostname != "":
+		app.Logger.Fatal("--tls.hostname isn't supported with --worker.serve, use --tls.cert")
+	case *tlsCert != "" || *tlsKey != "":
+		if *tlsCert == "" || *tlsKey == "" {
+			app.Logger.Fatal("require both --tls.cert and --tls.key")
+		}
+		creds, err := credentials.NewServerTLSFromFile(*tlsCert, *tlsKey)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	app.Logger.Infof("Serving gRPC on %s", addr)
+	return newServer(opts...).Serve(lis)
+}
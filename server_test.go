@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCertificate writes a self-signed certificate for 127.0.0.1 and
+// its key to dir and returns their paths.
+func writeTestCertificate(t *testing.T, dir string) (certPath string, keyPath string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "reviewbot"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPath, keyPath = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certPath, keyPath
+}
+
+// startTestServer runs serve on a free port in the background and returns
+// its address. The server keeps running until the test binary exits.
+func startTestServer(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, req.Proto)
+	})
+	go serve(addr, mux)
+	return addr
+}
+
+// getWhenUp gets url with client, retrying until the server is up.
+func getWhenUp(t *testing.T, client *http.Client, url string) *http.Response {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		res, err := client.Get(url)
+		if err == nil {
+			return res
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServe(t *testing.T) {
+	res := getWhenUp(t, http.DefaultClient, "http://"+startTestServer(t)+"/")
+	defer res.Body.Close()
+	if res.TLS != nil || res.ProtoMajor != 1 {
+		t.Errorf("got %s with TLS %v, want plain HTTP/1.1 without TLS flags", res.Proto, res.TLS)
+	}
+}
+
+func TestServeTLS(t *testing.T) {
+	certPath, keyPath := writeTestCertificate(t, t.TempDir())
+	*tlsCert, *tlsKey = certPath, keyPath
+	t.Cleanup(func() { *tlsCert, *tlsKey = "", "" })
+	addr := startTestServer(t)
+
+	pool := x509.NewCertPool()
+	b, err := os.ReadFile(certPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.AppendCertsFromPEM(b)
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: pool},
+		ForceAttemptHTTP2: true,
+	}}
+	res := getWhenUp(t, client, "https://"+addr+"/")
+	defer res.Body.Close()
+	if res.TLS == nil || res.ProtoMajor != 2 {
+		t.Errorf("got %s with TLS %v, want HTTP/2 over TLS", res.Proto, res.TLS)
+	}
+
+	client = &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: pool, MaxVersion: tls.VersionTLS11},
+	}}
+	if _, err := client.Get("https://" + addr + "/"); err == nil {
+		t.Errorf("got a response over TLS 1.1, want at least TLS 1.2")
+	}
+}